@@ -25,34 +25,39 @@ import (
 	"github.com/google/git-appraise/review/comment"
 )
 
-var acceptFlagSet = flag.NewFlagSet("accept", flag.ExitOnError)
+var acceptFlagSet = flag.NewFlagSet("accept", flag.ContinueOnError)
 
 var (
-	acceptMessage = acceptFlagSet.String("m", "", "Message to attach to the review")
+	acceptMessage     = acceptFlagSet.String("m", "", "Message to attach to the review")
+	acceptScope       = acceptFlagSet.String("scope", "", "The parts of the change that are accepted, if not all of it")
+	acceptConditional = acceptFlagSet.Bool("conditional", false, "Only accept the review once its requester responds")
+	acceptSign        = acceptFlagSet.Bool("S", false, "Sign the comment with the configured signing key")
+	acceptAndSubmit   = acceptFlagSet.Bool("and-submit", false, "Submit the review right after accepting it, as \"git appraise submit\" would; the review has to be checked out")
+	acceptAll         = acceptFlagSet.Bool("all", false, "Accept every open review that matches the --filter flag, rather than a single review")
+	acceptFilter      = acceptFlagSet.String("filter", "", "Filter flags of the \"list\" command (e.g. \"--label=docs\") selecting the reviews that --all accepts")
+	acceptConfirm     = acceptFlagSet.Bool("confirm", false, "Actually accept the reviews selected by --all, rather than only listing them")
 )
 
-// acceptReview adds an LGTM comment to the current code review.
-func acceptReview(repo repository.Repo, args []string) error {
-	acceptFlagSet.Parse(args)
-	args = acceptFlagSet.Args()
-
-	var r *review.Review
-	var err error
-	if len(args) > 1 {
-		return errors.New("Only accepting a single review is supported.")
-	}
-
-	if len(args) == 1 {
-		r, err = review.Get(repo, args[0])
-	} else {
-		r, err = review.GetCurrent(repo)
-	}
+// AcceptOptions are the options of Accept, which match the flags of the "accept" command.
+type AcceptOptions struct {
+	// Review is the hash of the review to accept, or empty for the current review.
+	Review  string
+	Message string
+	// Scope is the parts of the change that are accepted, if not all of it.
+	Scope string
+	// Conditional only accepts the review once its requester responds.
+	Conditional bool
+	// Sign signs the comment with the configured signing key.
+	Sign bool
+	// AndSubmit submits the review right after accepting it, with the default SubmitOptions.
+	AndSubmit bool
+}
 
+// Accept adds an LGTM comment to a review, as the "accept" command does.
+func Accept(repo repository.Repo, opts AcceptOptions) error {
+	r, err := loadReview(repo, opts.Review)
 	if err != nil {
-		return fmt.Errorf("Failed to load the review: %v\n", err)
-	}
-	if r == nil {
-		return errors.New("There is no matching review.")
+		return err
 	}
 
 	acceptedCommit, err := r.GetHeadCommit()
@@ -67,19 +72,90 @@ func acceptReview(repo repository.Repo, args []string) error {
 	if err != nil {
 		return err
 	}
-	c := comment.New(userEmail, *acceptMessage)
+	c := comment.New(userEmail, opts.Message)
 	c.Location = &location
+	c.Snapshot = acceptedCommit
 	c.Resolved = &resolved
-	return r.AddComment(c)
+	c.Scope = opts.Scope
+	c.Conditional = opts.Conditional
+	if err := signIfRequested(repo, opts.Sign, c.Sign); err != nil {
+		return err
+	}
+	if err := r.AddComment(c); err != nil {
+		return err
+	}
+	if opts.AndSubmit {
+		return submitAcceptedReview(repo, r)
+	}
+	return nil
+}
+
+// acceptReview adds an LGTM comment to the current code review.
+func acceptReview(repo repository.Repo, args []string) error {
+	if err := acceptFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = acceptFlagSet.Args()
+	if !*acceptAll && (*acceptFilter != "" || *acceptConfirm) {
+		return errors.New("The --filter and --confirm flags can only be used with the --all flag.")
+	}
+	if *acceptAll && (len(args) > 0 || *acceptAndSubmit) {
+		return errors.New("The --all flag cannot be used with a review hash, or with the --and-submit flag.")
+	}
+	if len(args) > 1 {
+		return errors.New("Only accepting a single review is supported.")
+	}
+	opts := AcceptOptions{
+		Message:     *acceptMessage,
+		Scope:       *acceptScope,
+		Conditional: *acceptConditional,
+		Sign:        *acceptSign,
+		AndSubmit:   *acceptAndSubmit,
+	}
+	if *acceptAll {
+		return applyToMatchingReviews(repo, *acceptFilter, *acceptConfirm, "Accepted", func(revision string) error {
+			opts.Review = revision
+			return Accept(repo, opts)
+		})
+	}
+	if len(args) == 1 {
+		opts.Review = args[0]
+	}
+	return Accept(repo, opts)
+}
+
+// submitAcceptedReview submits the given review, which was just accepted, in the same way
+// as the submit command. If it cannot be submitted, the acceptance still stands.
+func submitAcceptedReview(repo repository.Repo, r *review.Review) error {
+	// The submit command always submits the current review, so check that it is this one.
+	current, err := review.GetCurrent(repo)
+	if err == nil && current != nil {
+		current.Revision, err = repo.GetCommitHash(current.Revision)
+	}
+	revision, revisionErr := repo.GetCommitHash(r.Revision)
+	if err != nil || revisionErr != nil || current == nil || current.Revision != revision {
+		return CommandError{
+			Err:      fmt.Errorf("Accepted review %.12s, but did not submit it, as it is not the current review", r.Revision),
+			Guidance: "Check out the branch under review, and then run \"git appraise submit\".",
+			ExitCode: ExitPreconditionFailed,
+		}
+	}
+	if err := Submit(repo, SubmitOptions{}); err != nil {
+		commandErr := describeError(err)
+		commandErr.Err = fmt.Errorf("Accepted review %.12s, but failed to submit it: %w", r.Revision, commandErr.Err)
+		return commandErr
+	}
+	return nil
 }
 
 // acceptCmd defines the "accept" subcommand.
 var acceptCmd = &Command{
 	Usage: func(arg0 string) {
-		fmt.Printf("Usage: %s accept [<option>...] [<commit>]\n\nOptions:\n", arg0)
+		fmt.Printf("Usage: %s accept [<option>...] [<commit>]\n       %s accept --all [--filter=<flags>] [--confirm] [<option>...]\n\nOptions:\n", arg0, arg0)
 		acceptFlagSet.PrintDefaults()
 	},
 	RunMethod: func(repo repository.Repo, args []string) error {
 		return acceptReview(repo, args)
 	},
+	Flags: acceptFlagSet,
 }
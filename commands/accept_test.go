@@ -0,0 +1,114 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/reviewtest"
+	"testing"
+)
+
+func TestAccept(t *testing.T) {
+	repo := repository.NewMemoryRepoForTest()
+	if err := Accept(repo, AcceptOptions{Review: repository.TestCommitA}); err == nil {
+		t.Error("Unexpectedly accepted a commit without a review")
+	}
+	if err := Accept(repo, AcceptOptions{Review: repository.TestCommitG, Message: "Docs look good", Scope: "docs"}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.Get(repo, repository.TestCommitG)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	thread := findThread(r.Comments, "Docs look good")
+	if thread == nil {
+		t.Fatalf("The comment was not added: %+v", r.Comments)
+	}
+	if resolved := thread.Comment.Resolved; resolved == nil || !*resolved || thread.Comment.Scope != "docs" {
+		t.Errorf("Unexpected accepting comment: %+v", thread.Comment)
+	}
+}
+
+// noteFailingRepo fails to add notes to the given revision.
+type noteFailingRepo struct {
+	repository.Repo
+	failing string
+}
+
+func (r noteFailingRepo) AppendNote(ref, revision string, note repository.Note) error {
+	if revision == r.failing {
+		return errors.New("cannot write the note")
+	}
+	return r.Repo.AppendNote(ref, revision, note)
+}
+
+func TestAcceptAll(t *testing.T) {
+	defer func() {
+		*acceptMessage, *acceptAll, *acceptFilter, *acceptConfirm = "", false, "", false
+	}()
+	memoryRepo := repository.NewRepoWithHistory(map[string][]string{
+		"master": {"A"},
+		"docs":   {"A", "B"},
+		"fix":    {"A", "C"},
+		"other":  {"A", "D"},
+	})
+	for _, ref := range []string{"refs/heads/docs", "refs/heads/fix", "refs/heads/other"} {
+		if _, err := reviewtest.AddReview(memoryRepo, ref, "refs/heads/master"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rejected := false
+	for _, revision := range []string{"B", "C"} {
+		if _, err := reviewtest.AddComment(memoryRepo, revision, comment.Comment{Description: "Not yet", Resolved: &rejected}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo := noteFailingRepo{Repo: memoryRepo, failing: "C"}
+
+	if err := acceptReview(repo, []string{"-filter", "-rejected", "B"}); err == nil {
+		t.Fatal("Unexpectedly allowed --filter without --all")
+	}
+	if err := acceptReview(repo, []string{"-all", "-filter", "-bogus"}); err == nil {
+		t.Fatal("Unexpectedly allowed an invalid filter")
+	}
+	if err := acceptReview(repo, []string{"-all", "-filter", "-rejected"}); ExitCode(err) != ExitUserError {
+		t.Fatalf("Unexpectedly accepted the reviews without --confirm: %v", err)
+	}
+	if r, err := review.Get(repo, "B"); err != nil || r.Resolved == nil || *r.Resolved {
+		t.Fatalf("The review was accepted without --confirm: %+v, %v", r, err)
+	}
+
+	// The failure to accept one review does not keep the others from being accepted.
+	if err := acceptReview(repo, []string{"-all", "-filter", "-rejected", "-confirm", "-m", "Cleaning up"}); err == nil {
+		t.Fatal("Unexpectedly succeeded despite failing to accept a review")
+	}
+	for revision, expected := range map[string]bool{"B": true, "C": false, "D": false} {
+		r, err := review.Get(repo, revision)
+		if err != nil || r == nil {
+			t.Fatalf("Failed to load the review: %v", err)
+		}
+		thread := findThread(r.Comments, "Cleaning up")
+		if accepted := thread != nil; accepted != expected {
+			t.Errorf("Unexpected state of review %s: accepted %v, want %v", revision, accepted, expected)
+		} else if accepted && (thread.Comment.Author != "user@example.com" || !*thread.Comment.Resolved) {
+			t.Errorf("Unexpected accepting comment: %+v", thread.Comment)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var amendRequestFlagSet = flag.NewFlagSet("amend-request", flag.ContinueOnError)
+
+var amendRequestMessage = amendRequestFlagSet.String("m", "", "New description of the review")
+
+// amendRequestDescription rewrites the request of an existing review with a new description,
+// leaving everything else about it (including its reviewers and base) as-is.
+//
+// This is a shorthand for "request --amend -m <message>".
+func amendRequestDescription(repo repository.Repo, args []string) error {
+	*amendRequestMessage = ""
+	if err := amendRequestFlagSet.Parse(args); err != nil {
+		return err
+	}
+	if strings.TrimSpace(*amendRequestMessage) == "" {
+		return errors.New("Nothing to amend; give the new description of the review with -m.")
+	}
+	r, err := getReviewToUpdate(repo, amendRequestFlagSet.Args())
+	if err != nil {
+		return err
+	}
+	updatedRequest := r.Request
+	updatedRequest.Description = *amendRequestMessage
+	updatedRequest.Amended = strconv.FormatInt(time.Now().Unix(), 10)
+	if err := writeUpdatedRequest(repo, r, updatedRequest); err != nil {
+		return err
+	}
+	fmt.Printf("Amended the description of review %.12s\n", r.Revision)
+	return nil
+}
+
+// amendRequestCmd defines the "amend-request" subcommand.
+var amendRequestCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s amend-request -m <message> [<review-hash>]\n\nOptions:\n", arg0)
+		amendRequestFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return amendRequestDescription(repo, args)
+	},
+	Flags: amendRequestFlagSet,
+}
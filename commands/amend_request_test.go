@@ -0,0 +1,48 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"testing"
+)
+
+func TestAmendRequestDescription(t *testing.T) {
+	repo := repository.NewMemoryRepoForTest()
+	original, err := review.Get(repo, repository.TestCommitG)
+	if err != nil || original == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	if err := amendRequestDescription(repo, []string{repository.TestCommitG}); err == nil {
+		t.Fatal("Unexpectedly amended a review without a new description")
+	}
+	if err := amendRequestDescription(repo, []string{"-m", "Fixed a typo", repository.TestCommitG}); err != nil {
+		t.Fatal(err)
+	}
+	amended, err := review.Get(repo, repository.TestCommitG)
+	if err != nil || amended == nil {
+		t.Fatalf("Failed to load the amended review: %v", err)
+	}
+	if amended.Request.Description != "Fixed a typo" || amended.Request.Amended == "" {
+		t.Fatalf("The description was not amended: %+v", amended.Request)
+	}
+	if amended.Request.BaseCommit != original.Request.BaseCommit || amended.Request.Timestamp != original.Request.Timestamp ||
+		len(amended.Request.Reviewers) != len(original.Request.Reviewers) {
+		t.Fatalf("Unexpected changes to the rest of the request: %+v", amended.Request)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"strings"
+)
+
+var attentionFlagSet = flag.NewFlagSet("attention", flag.ContinueOnError)
+
+var attentionAdd, attentionRemove stringList
+
+func init() {
+	attentionFlagSet.Var(&attentionAdd, "add", "Email address to add to the attention set of the review; may be repeated")
+	attentionFlagSet.Var(&attentionRemove, "remove", "Email address to remove from the attention set of the review; may be repeated")
+}
+
+// setAttention adds people to, and removes people from, the attention set of a review.
+func setAttention(repo repository.Repo, args []string) error {
+	attentionAdd, attentionRemove = nil, nil
+	if err := attentionFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = attentionFlagSet.Args()
+	if len(args) > 1 {
+		// Allow the review hash to come before the flags, as in "attention <review-hash> -add <email>".
+		revision := args[0]
+		if err := attentionFlagSet.Parse(args[1:]); err != nil {
+			return err
+		}
+		args = append([]string{revision}, attentionFlagSet.Args()...)
+	}
+	if len(args) > 1 {
+		return errors.New("Only changing the attention set of a single review is supported.")
+	}
+	for _, added := range attentionAdd {
+		for _, removed := range attentionRemove {
+			if added == removed {
+				return fmt.Errorf("%q cannot be both added to and removed from the attention set.", added)
+			}
+		}
+	}
+
+	var r *review.Review
+	var err error
+	if len(args) == 1 {
+		r, err = review.Get(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %v\n", err)
+	}
+	if r == nil {
+		return noMatchingReview(args)
+	}
+	if len(attentionAdd) > 0 || len(attentionRemove) > 0 {
+		userEmail, err := repo.GetUserEmail()
+		if err != nil {
+			return err
+		}
+		if err := r.SetAttention(userEmail, attentionAdd, attentionRemove); err != nil {
+			return err
+		}
+	}
+	if len(r.Attention) == 0 {
+		fmt.Printf("Review %.12s is not waiting on anyone.\n", r.Revision)
+	} else {
+		fmt.Printf("Review %.12s is waiting on: %s\n", r.Revision, strings.Join(r.Attention, ", "))
+	}
+	return nil
+}
+
+// attentionCmd defines the "attention" subcommand.
+var attentionCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s attention [-add <email>]... [-remove <email>]... [<review-hash>]\n\nOptions:\n", arg0)
+		attentionFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return setAttention(repo, args)
+	},
+	Flags: attentionFlagSet,
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/request"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	// autoRequestPatternConfigKey is the config key holding the pattern of the pushed refs
+	// that "auto-request" requests reviews of.
+	autoRequestPatternConfigKey = "appraise.autoRequest.pattern"
+	// autoRequestTargetConfigKey is the config key holding the target ref of the reviews
+	// that "auto-request" requests.
+	autoRequestTargetConfigKey = "appraise.autoRequest.target"
+	// defaultAutoRequestPattern is the pattern used if none is configured.
+	defaultAutoRequestPattern = "refs/heads/review/*"
+)
+
+var autoRequestFlagSet = flag.NewFlagSet("auto-request", flag.ContinueOnError)
+
+var (
+	autoRequestPattern = autoRequestFlagSet.String("pattern", "", "Glob pattern of the pushed refs to request reviews of "+
+		"(default from the "+autoRequestPatternConfigKey+" setting, or else \""+defaultAutoRequestPattern+"\")")
+	autoRequestTarget = autoRequestFlagSet.String("target", "", "Ref to review the pushed refs against (default from the "+
+		autoRequestTargetConfigKey+" setting, or else the "+requestTargetConfigKey+" setting, or else \"refs/heads/master\")")
+	autoRequestPusher = autoRequestFlagSet.String("pusher", "", "Email address of whoever pushed the refs, who is made the requester "+
+		"(defaults to the author of the latest commit of each ref)")
+)
+
+// autoRequestInput is where the pushed refs are read from, if they are not given as arguments.
+var autoRequestInput io.Reader = os.Stdin
+
+// readPushedRefs reads the names of the updated refs from the input of a post-receive hook,
+// which has a line of "<old-value> <new-value> <ref-name>" for each of them. Deleted refs are skipped.
+func readPushedRefs(input io.Reader) ([]string, error) {
+	var refs []string
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		if strings.Trim(fields[1], "0") == "" {
+			continue
+		}
+		refs = append(refs, fields[2])
+	}
+	return refs, scanner.Err()
+}
+
+// findReviewOfRef returns the open review of the given ref, or nil if there is none.
+func findReviewOfRef(repo repository.Repo, ref string) *review.Review {
+	for _, r := range review.ListAllCached(repo, false) {
+		if r.IsOpen() && r.Request.ReviewRef == ref {
+			r := r
+			return &r
+		}
+	}
+	return nil
+}
+
+// autoRequestRef requests a review of the given pushed ref against the given target, or
+// updates the base commit of its existing review.
+func autoRequestRef(repo repository.Repo, ref, target, pusher string) error {
+	targetHead, err := repo.ResolveRefCommit(target)
+	if err != nil {
+		return err
+	}
+	reviewHead, err := repo.ResolveRefCommit(ref)
+	if err != nil {
+		return err
+	}
+	base, err := repo.MergeBase(targetHead, reviewHead)
+	if err != nil {
+		return err
+	}
+
+	if existing := findReviewOfRef(repo, ref); existing != nil {
+		if existing.Request.FixedBase || existing.Request.BaseCommit == base {
+			fmt.Printf("Review %.12s already tracks %s\n", existing.Revision, ref)
+			return nil
+		}
+		updatedRequest := existing.Request
+		updatedRequest.BaseCommit = base
+		if err := writeUpdatedRequest(repo, existing, updatedRequest); err != nil {
+			return err
+		}
+		fmt.Printf("Updated the base commit of review %.12s of %s to %.12s\n", existing.Revision, ref, base)
+		return nil
+	}
+
+	commits, err := repo.ListCommitsBetween(base, reviewHead)
+	if err != nil {
+		return err
+	}
+	if len(commits) == 0 {
+		fmt.Printf("Not requesting a review of %s, as it has no commits that are not in %s\n", ref, target)
+		return nil
+	}
+	revision := commits[0]
+	if existing, err := review.Get(repo, revision); err != nil {
+		return err
+	} else if existing != nil {
+		fmt.Printf("Review %.12s already exists for the first commit of %s\n", revision, ref)
+		return nil
+	}
+
+	requester := pusher
+	if requester == "" {
+		details, err := repo.GetCommitDetails(reviewHead)
+		if err != nil {
+			return err
+		}
+		requester = details.AuthorEmail
+	}
+	description, err := repo.GetCommitMessage(revision)
+	if err != nil {
+		return err
+	}
+	r := request.New(requester, nil, ref, target, description)
+	r.BaseCommit = base
+	note, err := r.Write()
+	if err != nil {
+		return err
+	}
+	if err := repo.AppendNote(request.Ref, revision, note); err != nil {
+		return err
+	}
+	fmt.Printf("Requested review %.12s of %s against %s\n", revision, ref, target)
+	return nil
+}
+
+// autoRequest requests reviews of the pushed refs that match the configured pattern.
+//
+// The refs are either given as arguments, or read from the input of a post-receive hook.
+func autoRequest(repo repository.Repo, args []string) error {
+	if err := autoRequestFlagSet.Parse(args); err != nil {
+		return err
+	}
+	refs := autoRequestFlagSet.Args()
+	if len(refs) == 0 {
+		var err error
+		if refs, err = readPushedRefs(autoRequestInput); err != nil {
+			return err
+		}
+	}
+	pattern := *autoRequestPattern
+	if pattern == "" {
+		pattern = repository.GetConfigValue(repo, autoRequestPatternConfigKey, defaultAutoRequestPattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("Invalid ref pattern %q: %v", pattern, err)
+	}
+	target := *autoRequestTarget
+	if target == "" {
+		target = repository.GetConfigValue(repo, autoRequestTargetConfigKey,
+			repository.GetConfigValue(repo, requestTargetConfigKey, "refs/heads/master"))
+	}
+
+	var failed []string
+	for _, ref := range refs {
+		if matched, _ := path.Match(pattern, ref); !matched || ref == target {
+			continue
+		}
+		if err := autoRequestRef(repo, ref, target, *autoRequestPusher); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to request a review of %s: %v\n", ref, err)
+			failed = append(failed, ref)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Failed to request reviews of %d ref(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// autoRequestCmd defines the "auto-request" subcommand.
+var autoRequestCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s auto-request [<option>...] [<ref>...]\n\n"+
+			"Requests reviews of the pushed refs that match a pattern, for use in a post-receive hook,\n"+
+			"which passes the pushed refs on stdin unless they are given as arguments.\n\nOptions:\n", arg0)
+		autoRequestFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return autoRequest(repo, args)
+	},
+	Flags: autoRequestFlagSet,
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/request"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadPushedRefs(t *testing.T) {
+	input := "1111111111111111111111111111111111111111 2222222222222222222222222222222222222222 refs/heads/review/a\n" +
+		"3333333333333333333333333333333333333333 0000000000000000000000000000000000000000 refs/heads/review/deleted\n" +
+		"0000000000000000000000000000000000000000 4444444444444444444444444444444444444444 refs/heads/review/b\n"
+	refs, err := readPushedRefs(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"refs/heads/review/a", "refs/heads/review/b"}; !reflect.DeepEqual(refs, expected) {
+		t.Errorf("Unexpected pushed refs: got %q, want %q", refs, expected)
+	}
+}
+
+func TestAutoRequest(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("The git command line tool is not installed")
+	}
+	defer func() {
+		*autoRequestPusher = ""
+		autoRequestInput = os.Stdin
+	}()
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "config", "user.email", "user@example.com")
+	runGit(t, dir, "config", "user.name", "Test User")
+	runGit(t, dir, "config", autoRequestTargetConfigKey, "refs/heads/release")
+	runGit(t, dir, "checkout", "-q", "-b", "release")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "First commit")
+	runGit(t, dir, "checkout", "-q", "-b", "review/feature")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "Add the feature")
+	runGit(t, dir, "checkout", "-q", "-b", "other", "release")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "Unrelated change")
+
+	repo, err := repository.NewGitRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	featureCommit, err := repo.GetCommitHash("refs/heads/review/feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCommit, err := repo.GetCommitHash("refs/heads/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushed := "0000000000000000000000000000000000000000 " + featureCommit + " refs/heads/review/feature\n" +
+		"0000000000000000000000000000000000000000 " + otherCommit + " refs/heads/other\n"
+	for i := 0; i < 2; i++ {
+		autoRequestInput = strings.NewReader(pushed)
+		if err := autoRequest(repo, []string{"-pusher", "pusher@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := review.Get(repo, featureCommit)
+	if err != nil || r == nil {
+		t.Fatalf("The review was not requested: %v", err)
+	}
+	if r.Request.Requester != "pusher@example.com" || r.Request.ReviewRef != "refs/heads/review/feature" ||
+		r.Request.TargetRef != "refs/heads/release" || r.Request.Description != "Add the feature" {
+		t.Errorf("Unexpected request: %+v", r.Request)
+	}
+	if requests := request.ParseAllValid(repo.GetNotes(request.Ref, featureCommit)); len(requests) != 1 {
+		t.Errorf("Pushing the branch again duplicated the request: %+v", requests)
+	}
+	if other, err := review.Get(repo, otherCommit); err != nil || other != nil {
+		t.Errorf("Requested a review of a branch that does not match the pattern: %+v, %v", other, err)
+	}
+
+	// Merging the target into the branch updates the base of the existing review.
+	runGit(t, dir, "checkout", "-q", "release")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "Release fix")
+	runGit(t, dir, "checkout", "-q", "review/feature")
+	runGit(t, dir, "merge", "-q", "--no-edit", "release")
+	releaseCommit, err := repo.GetCommitHash("refs/heads/release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := autoRequest(repo, []string{"refs/heads/review/feature"}); err != nil {
+		t.Fatal(err)
+	}
+	r, err = review.Get(repo, featureCommit)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	if r.Request.BaseCommit != releaseCommit || r.Request.Requester != "pusher@example.com" {
+		t.Errorf("The review was not updated: %+v", r.Request)
+	}
+	reviews := review.ListAll(repo)
+	if len(reviews) != 1 {
+		t.Errorf("Unexpected reviews after pushing the branch again: %+v", reviews)
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"time"
+)
+
+var blameFlagSet = flag.NewFlagSet("blame", flag.ContinueOnError)
+
+var (
+	blameCommit     = blameFlagSet.String("commit", "HEAD", "Commit whose reviews should be described")
+	blameJsonOutput = blameFlagSet.Bool("json", false, "Format the output as JSON")
+)
+
+// blameApproval records a single reviewer's acceptance of a review.
+type blameApproval struct {
+	Reviewer  string `json:"reviewer"`
+	Timestamp string `json:"timestamp"`
+}
+
+// blameRecord describes who requested and approved a single review.
+type blameRecord struct {
+	Review    string          `json:"review"`
+	Requester string          `json:"requester"`
+	Approvals []blameApproval `json:"approvals"`
+}
+
+// formatBlameTimestamp converts a timestamp stored in a git note to RFC 3339, in UTC.
+func formatBlameTimestamp(timestamp string) string {
+	t, err := parseTimestamp(timestamp)
+	if err != nil {
+		return timestamp
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// getBlameRecord returns the requester and the latest acceptances of the given review.
+func getBlameRecord(r review.Review) blameRecord {
+	record := blameRecord{
+		Review:    r.Revision,
+		Requester: r.Request.Requester,
+		Approvals: []blameApproval{},
+	}
+	for _, signOff := range r.GetSignOffs() {
+		if signOff.Status == review.SignOffAccepted {
+			record.Approvals = append(record.Approvals, blameApproval{
+				Reviewer:  signOff.Reviewer,
+				Timestamp: formatBlameTimestamp(signOff.Timestamp),
+			})
+		}
+	}
+	return record
+}
+
+// blame prints who requested and who approved the reviews that resulted in a commit.
+func blame(repo repository.Repo, args []string) error {
+	if err := blameFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = blameFlagSet.Args()
+	if len(args) > 0 {
+		return errors.New("The blame command does not take any arguments; use --commit to pick the commit.")
+	}
+
+	commit, err := repo.GetCommitHash(*blameCommit)
+	if err != nil {
+		return fmt.Errorf("Unknown commit %q: %v", *blameCommit, err)
+	}
+	reviews, err := review.FindForCommit(repo, commit)
+	if err != nil {
+		return err
+	}
+	if len(reviews) == 0 {
+		return fmt.Errorf("There is no review that resulted in the commit %.12s.", commit)
+	}
+	records := []blameRecord{}
+	for _, r := range reviews {
+		records = append(records, getBlameRecord(r))
+	}
+
+	if *blameJsonOutput {
+		jsonBytes, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonBytes))
+		return nil
+	}
+	for _, record := range records {
+		fmt.Printf("review\t%s\n", record.Review)
+		fmt.Printf("requester\t%s\n", record.Requester)
+		for _, approval := range record.Approvals {
+			fmt.Printf("approved\t%s\t%s\n", approval.Reviewer, approval.Timestamp)
+		}
+	}
+	return nil
+}
+
+// blameCmd defines the "blame" subcommand.
+var blameCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s blame [<option>...]\n\nOptions:\n", arg0)
+		blameFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return blame(repo, args)
+	},
+	Flags: blameFlagSet,
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// parseReviewFilter parses the value of a --filter flag, which holds the same filter flags
+// as the "list" command (e.g. "--rejected --label=stale"), into a function that reports
+// whether a review matches them. An empty filter matches all open reviews.
+func parseReviewFilter(repo repository.Repo, filter string) (func(review.Review) bool, error) {
+	filterFlagSet := flag.NewFlagSet("filter", flag.ContinueOnError)
+	filterFlagSet.SetOutput(ioutil.Discard)
+	filterFlags := addReviewFilterFlags(filterFlagSet)
+	if err := filterFlagSet.Parse(strings.Fields(filter)); err != nil {
+		return nil, fmt.Errorf("Invalid --filter %q: %v", filter, err)
+	}
+	if len(filterFlagSet.Args()) > 0 {
+		return nil, fmt.Errorf("Invalid --filter %q: it can only hold the filter flags of the \"list\" command", filter)
+	}
+	return filterFlags.build(repo)
+}
+
+// applyToMatchingReviews applies the given action, described by the past tense verb (e.g.
+// "Accepted"), to every review that matches the filter, one review at a time.
+//
+// Unless confirm is set, this only prints the reviews that would be affected. A failure on
+// one review is reported and does not stop the action from being applied to the others,
+// but it does make the whole batch fail.
+func applyToMatchingReviews(repo repository.Repo, filter string, confirm bool, verb string, action func(revision string) error) error {
+	matches, err := parseReviewFilter(repo, filter)
+	if err != nil {
+		return err
+	}
+	var matching []review.Review
+	for _, r := range review.ListAllCached(repo, false) {
+		if matches(r) {
+			matching = append(matching, r)
+		}
+	}
+	if len(matching) == 0 {
+		fmt.Println("No reviews match the filter")
+		return nil
+	}
+	if !confirm {
+		for _, r := range matching {
+			fmt.Printf("Review %.12s would be %s: %s\n", r.Revision, strings.ToLower(verb), firstLine(r.Request.Description))
+		}
+		return CommandError{
+			Err:      fmt.Errorf("Did not change the %d matching review(s), as --confirm was not given", len(matching)),
+			Guidance: "Check the reviews listed above, and then run the command again with --confirm.",
+			ExitCode: ExitUserError,
+		}
+	}
+
+	var failed []string
+	for _, r := range matching {
+		if err := action(r.Revision); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to change review %.12s: %v\n", r.Revision, err)
+			failed = append(failed, fmt.Sprintf("%.12s", r.Revision))
+			continue
+		}
+		fmt.Printf("%s review %.12s: %s\n", verb, r.Revision, firstLine(r.Request.Description))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Failed to change %d of the %d matching review(s): %s", len(failed), len(matching), strings.Join(failed, ", "))
+	}
+	return nil
+}
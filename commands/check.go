@@ -0,0 +1,131 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+)
+
+var (
+	checkFlagSet   = flag.NewFlagSet("check", flag.ContinueOnError)
+	uncheckFlagSet = flag.NewFlagSet("uncheck", flag.ContinueOnError)
+)
+
+var (
+	checkReview   = checkFlagSet.String("review", "", "Hash of the review whose checklist to update, rather than the current one")
+	uncheckReview = uncheckFlagSet.String("review", "", "Hash of the review whose checklist to update, rather than the current one")
+)
+
+// printChecklistItems prints the numbered items of the review's checklist, so that they can
+// be referred to by number.
+func printChecklistItems(r *review.Review) {
+	for i, item := range r.Checklist {
+		mark, note := " ", ""
+		if item.Checked {
+			mark = "x"
+			note = fmt.Sprintf(" (checked by %s)", item.CheckedBy)
+		}
+		fmt.Printf("%d. [%s] %s%s\n", i+1, mark, item.Item, note)
+	}
+}
+
+// setChecked checks, or unchecks, the given items of a review's checklist, which are given
+// either by their text or by their number. Without any items, it lists the checklist.
+func setChecked(repo repository.Repo, flags *flag.FlagSet, revision *string, args []string, checked bool) error {
+	*revision = ""
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var r *review.Review
+	var err error
+	if *revision != "" {
+		r, err = review.Get(repo, *revision)
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %v\n", err)
+	}
+	if r == nil {
+		if *revision != "" {
+			return noMatchingReview([]string{*revision})
+		}
+		return noMatchingReview(nil)
+	}
+	if len(r.Checklist) == 0 {
+		return CommandError{
+			Err:      fmt.Errorf("Review %.12s has no checklist.", r.Revision),
+			Guidance: "Add one with \"git appraise request --amend --checklist-item=<item>\".",
+			ExitCode: ExitUserError,
+		}
+	}
+
+	// Resolve every item before recording any of them, so that a typo leaves the checklist untouched.
+	var items []string
+	for _, arg := range flags.Args() {
+		item, err := r.FindChecklistItem(arg)
+		if err != nil {
+			return CommandError{Err: err, ExitCode: ExitUserError}
+		}
+		items = append(items, item)
+	}
+	if len(items) > 0 {
+		userEmail, err := repo.GetUserEmail()
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err := r.SetChecked(userEmail, item, checked); err != nil {
+				return err
+			}
+		}
+	}
+	printChecklistItems(r)
+	return nil
+}
+
+// checkCmd defines the "check" subcommand.
+var checkCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s check [-review <review-hash>] [<item>...]\n\n"+
+			"Checks the given items of a review's checklist, each given by its text or its number,\n"+
+			"and prints the checklist.\n\nOptions:\n", arg0)
+		checkFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return setChecked(repo, checkFlagSet, checkReview, args, true)
+	},
+	Flags: checkFlagSet,
+}
+
+// uncheckCmd defines the "uncheck" subcommand.
+var uncheckCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s uncheck [-review <review-hash>] [<item>...]\n\n"+
+			"Unchecks the given items of a review's checklist, each given by its text or its number,\n"+
+			"and prints the checklist.\n\nOptions:\n", arg0)
+		uncheckFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return setChecked(repo, uncheckFlagSet, uncheckReview, args, false)
+	},
+	Flags: uncheckFlagSet,
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/reviewtest"
+	"strings"
+	"testing"
+)
+
+func TestCheckRequiredChecklist(t *testing.T) {
+	defer func() {
+		*acceptMessage, *submitTBR = "", false
+	}()
+	*submitMerge, *submitRebase, *submitSquash, *submitKeepReviewRef = false, false, false, false
+	repo := repository.NewRepoWithHistory(map[string][]string{"master": {"A"}, "feature": {"A", "B"}})
+	revision, err := reviewtest.AddReview(repo, "refs/heads/feature", "refs/heads/master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setChecked(repo, checkFlagSet, checkReview, []string{"-review", revision, "1"}, true); ExitCode(err) != ExitUserError {
+		t.Fatalf("Unexpected result of checking a review without a checklist: %v", err)
+	}
+	r, err := loadReview(repo, revision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updatedRequest := r.Request
+	updatedRequest.Checklist = []string{"Tests added", "Docs updated"}
+	updatedRequest.RequireChecklist = true
+	if err := writeUpdatedRequest(repo, r, updatedRequest); err != nil {
+		t.Fatal(err)
+	}
+	if err := acceptReview(repo, []string{"-m", "LGTM", revision}); err != nil {
+		t.Fatal(err)
+	}
+	r, err = loadReview(repo, revision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Resolved == nil || *r.Resolved || !r.AwaitingChecklist {
+		t.Fatalf("The review was accepted before its checklist was complete: %+v", r)
+	}
+
+	if err := repo.SwitchToRef("refs/heads/feature"); err != nil {
+		t.Fatal(err)
+	}
+	err = submitReview(repo, nil)
+	if ExitCode(err) != ExitPreconditionFailed || !strings.Contains(err.Error(), "Tests added; Docs updated") {
+		t.Fatalf("Unexpected result of submitting a review with an incomplete checklist: %v", err)
+	}
+
+	if err := setChecked(repo, checkFlagSet, checkReview, []string{"No such item"}, true); ExitCode(err) != ExitUserError {
+		t.Fatalf("Unexpected result of checking an unknown item: %v", err)
+	}
+	if err := setChecked(repo, checkFlagSet, checkReview, []string{"1", "Docs updated"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := setChecked(repo, uncheckFlagSet, uncheckReview, []string{"2"}, false); err != nil {
+		t.Fatal(err)
+	}
+	r, err = loadReview(repo, revision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.AwaitingChecklist || r.Checklist[0].CheckedBy != "user@example.com" || r.Checklist[1].Checked {
+		t.Fatalf("Unexpected checklist: %+v", r.Checklist)
+	}
+	out, err := captureStdout(func() error { return showReview(repo, []string{revision}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "Tests added (checked by \"user@example.com\"") || !strings.Contains(out, "[ ] Docs updated") {
+		t.Errorf("The checklist was not shown:\n%s", out)
+	}
+
+	if err := setChecked(repo, checkFlagSet, checkReview, []string{"Docs updated"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = loadReview(repo, revision); err != nil {
+		t.Fatal(err)
+	}
+	if r.Resolved == nil || !*r.Resolved || r.AwaitingChecklist {
+		t.Fatalf("The review was not accepted once its checklist was complete: %+v", r)
+	}
+}
@@ -15,36 +15,378 @@ limitations under the License.
 */
 
 // Package commands contains the assorted sub commands supported by the git-appraise tool.
+//
+// The main operations on reviews are also exported as functions that take an options struct
+// rather than command line arguments, such as Request, Comment, Accept, Reject, and Submit,
+// so that other tools can embed them. These behave exactly like the matching commands,
+// including the messages that they print.
 package commands
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/commands/output"
 	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	notesRefPattern   = "refs/notes/devtools/*"
+	archiveRefPattern = "refs/devtools/archives/*"
 )
 
-const notesRefPattern = "refs/notes/devtools/*"
+// stringList is a flag.Value that collects every occurrence of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// isFlagSet returns whether the flag with the given name was given on the command line, so
+// that an explicit flag can win over a configured default.
+func isFlagSet(flagSet *flag.FlagSet, name string) bool {
+	set := false
+	flagSet.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// colorFlag adds the --color flag, controlling whether the output is colored, to the given flag set.
+func colorFlag(flagSet *flag.FlagSet) *string {
+	return flagSet.String("color", "", "When to color the output: \"auto\" (only on a terminal), \"always\", or \"never\" "+
+		"(default from the "+output.ColorConfigKey+" setting, or else \"auto\")")
+}
+
+// setupColor turns the coloring of the output on or off, according to the given --color
+// flag, or if that is not given, the configured default.
+func setupColor(repo repository.Repo, color string) error {
+	if color == "" {
+		color = repository.GetConfigValue(repo, output.ColorConfigKey, output.ColorAuto)
+	}
+	return output.SetColor(color)
+}
+
+// requireWorktree returns an error if the given repository is bare, for commands that
+// must check out or modify files in a working tree.
+func requireWorktree(repo repository.Repo, command string) error {
+	bare, err := repo.IsBare()
+	if err != nil {
+		return err
+	}
+	if bare {
+		return fmt.Errorf("The %s command requires a working tree, but this is a bare repository.", command)
+	}
+	return nil
+}
+
+// withCleanWorktree runs the given function, which moves HEAD, only if there are no
+// uncommitted changes that could block it or be carried along with it.
+//
+// If autostash is true, then any such changes are stashed beforehand instead, and are
+// restored afterward, whether or not the function succeeds.
+func withCleanWorktree(repo repository.Repo, command string, autostash bool, run func() error) error {
+	dirty, err := repo.HasUncommittedChanges()
+	if err != nil {
+		return err
+	}
+	if !dirty {
+		return run()
+	}
+	if !autostash {
+		return fmt.Errorf("Not running %s as you have uncommitted or untracked files; commit or stash them first, or use --autostash.", command)
+	}
+	stash, err := repo.StashChanges(fmt.Sprintf("git-appraise: autostash for %s", command))
+	if err != nil {
+		return fmt.Errorf("Not running %s as the uncommitted changes could not be stashed: %v", command, err)
+	}
+	if stash == "" {
+		return run()
+	}
+	runErr := run()
+	if err := repo.RestoreStash(stash); err != nil {
+		restoreErr := fmt.Errorf("Failed to restore the uncommitted changes, which are still saved in the stash %.12s; "+
+			"run \"git stash list\" to find them: %v", stash, err)
+		if runErr != nil {
+			return fmt.Errorf("%v\n%v", runErr, restoreErr)
+		}
+		return restoreErr
+	}
+	return runErr
+}
+
+// signIfRequested signs a note using the given method, if either the given flag or the
+// "appraise.sign" git config setting requests it.
+func signIfRequested(repo repository.Repo, signFlag bool, sign func(repository.Repo) error) error {
+	if !signFlag && !review.ShouldSign(repo) {
+		return nil
+	}
+	if err := sign(repo); err != nil {
+		return fmt.Errorf("Failed to sign the note: %v", err)
+	}
+	return nil
+}
+
+// Exit codes of the review tool, by the kind of error that a command failed with.
+const (
+	// ExitUserError is for errors in how a command was run, such as bad arguments, or
+	// naming a review that does not exist.
+	ExitUserError = 1
+	// ExitPreconditionFailed is for commands that were refused because the review or the
+	// repository is not yet in the required state, such as submitting an unaccepted review.
+	ExitPreconditionFailed = 2
+	// ExitRepositoryError is for failures of the repository itself, such as a missing ref,
+	// or a git command that failed.
+	ExitRepositoryError = 3
+	// ExitNotYetAccepted is for "submit --wait", when the review has not been accepted yet,
+	// so that scripts can tell that they should try again later.
+	ExitNotYetAccepted = 4
+)
+
+// CommandError is an error returned by a command, along with what to do about it, and the
+// exit code that the review tool should exit with.
+type CommandError struct {
+	Err error
+	// Guidance suggests the next step to take, or is empty if there is nothing to suggest.
+	Guidance string
+	ExitCode int
+}
+
+func (e CommandError) Error() string {
+	message := e.Err.Error()
+	if e.Guidance == "" {
+		return message
+	}
+	if !strings.HasSuffix(message, ".") {
+		message += "."
+	}
+	return message + "\n" + e.Guidance
+}
+
+// Unwrap returns the underlying error, so that errors.Is and errors.As can check for it.
+func (e CommandError) Unwrap() error {
+	return e.Err
+}
+
+// describeError returns the given error from a command as a CommandError, classifying it
+// by what went wrong, and adding guidance for the errors whose next step is known.
+//
+// Any error that is already a CommandError is returned as-is.
+func describeError(err error) CommandError {
+	var commandErr CommandError
+	var refMissingErr repository.RefMissingError
+	var shallowErr repository.ShallowRepoError
+	var pushRejectedErr repository.PushRejectedError
+	var canceledErr repository.CanceledError
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &commandErr):
+		return commandErr
+	case errors.Is(err, review.ErrNoCurrentReview):
+		return CommandError{err, "Check out the branch under review, or pass the review's hash to commands that take one (see \"git appraise list\").", ExitUserError}
+	case errors.Is(err, review.ErrReviewNotAccepted):
+		return CommandError{err, "Run \"git appraise accept\" once it has been reviewed, or pass --tbr to submit it anyway.", ExitPreconditionFailed}
+	case errors.Is(err, repository.ErrNotFastForward):
+		return CommandError{err, "Run \"git merge <target>\" on the review branch, and then retry.", ExitPreconditionFailed}
+	case errors.As(err, &pushRejectedErr):
+		return CommandError{err, "Run \"git appraise pull\", and then push again.", ExitPreconditionFailed}
+	case errors.As(err, &shallowErr):
+		return CommandError{err, "Run \"git fetch --unshallow\" or \"git fetch --depth=N\" (with a large enough N) to fetch more of it, and then try again.", ExitRepositoryError}
+	case errors.As(err, &refMissingErr):
+		return CommandError{err, fmt.Sprintf("Check the spelling of %q, or fetch it from the remote that has it.", refMissingErr.Ref), ExitRepositoryError}
+	case errors.As(err, &canceledErr), errors.As(err, &exitErr):
+		return CommandError{err, "", ExitRepositoryError}
+	}
+	return CommandError{err, "", ExitUserError}
+}
+
+// ExitCode returns the exit code that the review tool should exit with after a command
+// failed with the given error.
+func ExitCode(err error) int {
+	return describeError(err).ExitCode
+}
+
+// noMatchingReview returns the error for there being no review matching the given
+// arguments, which hold the review hash if one was given.
+func noMatchingReview(args []string) error {
+	if len(args) == 0 {
+		return review.ErrNoCurrentReview
+	}
+	return errors.New("There is no matching review.")
+}
+
+// loadReview loads the review with the given hash, or the current review if the hash is empty.
+func loadReview(repo repository.Repo, hash string) (*review.Review, error) {
+	var r *review.Review
+	var err error
+	if hash != "" {
+		r, err = review.Get(repo, hash)
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load the review: %v\n", err)
+	}
+	if r == nil {
+		var args []string
+		if hash != "" {
+			args = []string{hash}
+		}
+		return nil, noMatchingReview(args)
+	}
+	return r, nil
+}
+
+// confirmInput is where the answers to confirmation prompts are read from.
+var confirmInput io.Reader = os.Stdin
+
+// confirm asks the user the given yes-or-no question, and returns whether they answered yes.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	var answer string
+	fmt.Fscanln(confirmInput, &answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// disableInteraction makes sure that none of the git commands run from here on can block
+// waiting for the user, by turning off git's credential prompts, editor, and pager.
+//
+// This is for output formats that are meant to be read by scripts.
+func disableInteraction() {
+	os.Setenv("GIT_TERMINAL_PROMPT", "0")
+	os.Setenv("GIT_EDITOR", "true")
+	os.Setenv("GIT_PAGER", "cat")
+}
+
+// withTimeout returns a copy of the given repo whose git commands are killed once the given
+// timeout has passed, along with a function that releases the timer. A timeout of zero (or
+// less) means that there is no limit.
+func withTimeout(repo repository.Repo, timeout time.Duration) (repository.Repo, context.CancelFunc) {
+	if timeout <= 0 {
+		return repo, func() {}
+	}
+	ctx, cancel := context.WithTimeout(repo.Context(), timeout)
+	return repo.WithContext(ctx), cancel
+}
+
+// shellArgs returns the command line that runs the given shell command on the given
+// operating system (as named by runtime.GOOS).
+func shellArgs(goos, command string) []string {
+	if goos == "windows" {
+		return []string{"cmd", "/C", command}
+	}
+	return []string{"sh", "-c", command}
+}
+
+// shellCommand returns a command that runs the given shell command using the platform's shell.
+func shellCommand(command string) *exec.Cmd {
+	args := shellArgs(runtime.GOOS, command)
+	return exec.Command(args[0], args[1:]...)
+}
 
 // Command represents the definition of a single command.
 type Command struct {
 	Usage     func(string)
 	RunMethod func(repository.Repo, []string) error
+	// Flags holds the command's flags, so that the shell completion can list them.
+	Flags *flag.FlagSet
+	// LongRunning is set for commands, such as "serve", that keep running until they are
+	// interrupted. Their changes are not journaled as a single operation for "undo".
+	LongRunning bool
 }
 
 // Run executes a command, given its arguments.
 //
 // The args parameter is all of the command line args that followed the
 // subcommand.
+//
+// Any error is returned as a CommandError, describing what to do about it. Asking
+// for help with the "-h" flag is not an error, since the usage has already been printed.
 func (cmd *Command) Run(repo repository.Repo, args []string) error {
-	return cmd.RunMethod(repo, args)
+	err := cmd.RunMethod(repo, args)
+	if err == nil || errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	return describeError(err)
+}
+
+// DryRun executes a command like Run, except that nothing in the repo is changed. Instead,
+// the changes that the command would have made (such as the notes it would have written)
+// are printed once it finishes.
+func (cmd *Command) DryRun(repo repository.Repo, args []string) error {
+	recorder := repository.NewMutationRecorder(repo, true)
+	err := cmd.Run(recorder, args)
+	printMutations(recorder.Mutations())
+	return err
+}
+
+// printMutations prints the changes that a dry run of a command would have made.
+func printMutations(mutations []repository.Mutation) {
+	if len(mutations) == 0 {
+		return
+	}
+	fmt.Println("Dry run; nothing was changed. The command would have done the following:")
+	for _, mutation := range mutations {
+		fmt.Println("  " + strings.Replace(mutation.String(), "\n", "\n    ", -1))
+	}
 }
 
 // CommandMap defines all of the available (sub)commands.
 var CommandMap = map[string]*Command{
-	"accept":  acceptCmd,
-	"comment": commentCmd,
-	"list":    listCmd,
-	"pull":    pullCmd,
-	"push":    pushCmd,
-	"request": requestCmd,
-	"show":    showCmd,
-	"submit":  submitCmd,
+	"accept":        acceptCmd,
+	"amend-request": amendRequestCmd,
+	"attention":     attentionCmd,
+	"auto-request":  autoRequestCmd,
+	"blame":         blameCmd,
+	"check":         checkCmd,
+	"comment":       commentCmd,
+	"config":        configCmd,
+	"dedupe":        dedupeCmd,
+	"diff":          diffCmd,
+	"export":        exportCmd,
+	"format-patch":  formatPatchCmd,
+	"fsck":          fsckCmd,
+	"gc":            gcCmd,
+	"import-mail":   importMailCmd,
+	"init":          initCmd,
+	"label":         labelCmd,
+	"lint":          lintCmd,
+	"list":          listCmd,
+	"notify":        notifyCmd,
+	"pull":          pullCmd,
+	"push":          pushCmd,
+	"react":         reactCmd,
+	"reject":        rejectCmd,
+	"request":       requestCmd,
+	"search":        searchCmd,
+	"serve":         serveCmd,
+	"show":          showCmd,
+	"stats":         statsCmd,
+	"submit":        submitCmd,
+	"subscribe":     subscribeCmd,
+	"sync":          syncCmd,
+	"tui":           tuiCmd,
+	"uncheck":       uncheckCmd,
+	"undo":          undoCmd,
+	"unsubscribe":   unsubscribeCmd,
+	"verify":        verifyCmd,
+	"viewed":        viewedCmd,
+	"version":       versionCmd,
+	"watch":         watchCmd,
 }
@@ -0,0 +1,137 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/comment"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestDescribeError(t *testing.T) {
+	exitErr := exec.Command("false").Run()
+	if _, ok := exitErr.(*exec.ExitError); !ok {
+		t.Skipf("Unable to run \"false\": %v", exitErr)
+	}
+	custom := CommandError{Err: errors.New("Custom"), Guidance: "Do something.", ExitCode: ExitPreconditionFailed}
+	for _, test := range []struct {
+		err          error
+		wantCode     int
+		wantGuidance string
+	}{
+		{errors.New("Bad arguments."), ExitUserError, ""},
+		{review.ErrNoCurrentReview, ExitUserError, "git appraise list"},
+		{review.ErrReviewNotAccepted, ExitPreconditionFailed, "git appraise accept"},
+		{fmt.Errorf("Failed to merge: %w", repository.ErrNotFastForward), ExitPreconditionFailed, "git merge <target>"},
+		{repository.PushRejectedError{Remote: "origin"}, ExitPreconditionFailed, "git appraise pull"},
+		{repository.ShallowRepoError{Operation: "list the commits"}, ExitRepositoryError, "git fetch --unshallow"},
+		{repository.RefMissingError{Ref: "refs/heads/release"}, ExitRepositoryError, `"refs/heads/release"`},
+		{exitErr, ExitRepositoryError, ""},
+		{custom, ExitPreconditionFailed, "Do something."},
+	} {
+		described := describeError(test.err)
+		if described.ExitCode != test.wantCode || ExitCode(test.err) != test.wantCode {
+			t.Errorf("Unexpected exit code for %q: got %d, want %d", test.err, described.ExitCode, test.wantCode)
+		}
+		if !strings.Contains(described.Guidance, test.wantGuidance) || !strings.HasPrefix(described.Error(), test.err.Error()) {
+			t.Errorf("Unexpected description of %q: %q", test.err, described)
+		}
+		if !errors.Is(described, test.err) {
+			t.Errorf("The description of %q does not wrap it", test.err)
+		}
+	}
+}
+
+func TestFlagErrors(t *testing.T) {
+	listFlagSet.SetOutput(ioutil.Discard)
+	defer listFlagSet.SetOutput(nil)
+	repo := repository.NewMemoryRepoForTest()
+	if err := listCmd.Run(repo, []string{"-h"}); err != nil {
+		t.Errorf("Asking for help failed: %v", err)
+	}
+	err := listCmd.Run(repo, []string{"--no-such-flag"})
+	if err == nil {
+		t.Fatal("An unknown flag was accepted")
+	}
+	if code := ExitCode(err); code != ExitUserError {
+		t.Errorf("Unexpected exit code for an unknown flag: got %d, want %d", code, ExitUserError)
+	}
+}
+
+func TestShellArgs(t *testing.T) {
+	for goos, expected := range map[string]string{
+		"linux":   "sh -c make test",
+		"darwin":  "sh -c make test",
+		"windows": "cmd /C make test",
+	} {
+		if args := strings.Join(shellArgs(goos, "make test"), " "); args != expected {
+			t.Errorf("Unexpected shell command line on %s: %q", goos, args)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	defer func() { *commentMessage = "" }()
+	repo := repository.NewRepoWithHistory(map[string][]string{
+		"master":  {"A"},
+		"feature": {"A", "B"},
+	})
+	if err := repo.SwitchToRef("refs/heads/feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Request(repo, RequestOptions{Target: "refs/heads/master", Quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := repo.GetNotesTip(comment.Ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := captureStdout(func() error {
+		return commentCmd.DryRun(repo, []string{"-m", "Looks risky"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after, err := repo.GetNotesTip(comment.Ref); err != nil || after != before {
+		t.Errorf("The dry run changed the comments: %q, %v", after, err)
+	}
+	if !strings.Contains(out, "append a note to B under "+comment.Ref+":") || !strings.Contains(out, `"description":"Looks risky"`) {
+		t.Errorf("The dry run did not print the note that it would have written:\n%s", out)
+	}
+
+	if err := Accept(repo, AcceptOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	out, err = captureStdout(func() error {
+		return submitCmd.DryRun(repo, []string{"--merge"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head, _ := repo.GetHeadRef(); head != "refs/heads/feature" || !strings.Contains(out, "merge refs/heads/feature into refs/heads/master") {
+		t.Errorf("Unexpected dry run of the submit, with HEAD left at %q:\n%s", head, out)
+	}
+	if master, _ := repo.GetCommitHash("refs/heads/master"); master != "A" {
+		t.Errorf("The dry run moved the target ref to %q", master)
+	}
+}
@@ -23,79 +23,286 @@ import (
 	"github.com/google/git-appraise/repository"
 	"github.com/google/git-appraise/review"
 	"github.com/google/git-appraise/review/comment"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
-var commentFlagSet = flag.NewFlagSet("comment", flag.ExitOnError)
+var commentFlagSet = flag.NewFlagSet("comment", flag.ContinueOnError)
 
 var (
-	commentMessage = commentFlagSet.String("m", "", "Message to attach to the review")
-	commentParent  = commentFlagSet.String("p", "", "Parent comment")
-	commentFile    = commentFlagSet.String("f", "", "File being commented upon")
-	commentLine    = commentFlagSet.Uint("l", 0, "Line being commented upon; requires that the -f flag also be set")
-	commentLgtm    = commentFlagSet.Bool("lgtm", false, "'Looks Good To Me'. Set this to express your approval. This cannot be combined with nmw")
-	commentNmw     = commentFlagSet.Bool("nmw", false, "'Needs More Work'. Set this to express your disapproval. This cannot be combined with lgtm")
+	commentMessage  = commentFlagSet.String("m", "", "Message to attach to the review")
+	commentParent   = commentFlagSet.String("p", "", "Parent comment")
+	commentFile     = commentFlagSet.String("f", "", "File or submodule being commented upon")
+	commentLine     = commentFlagSet.Uint("l", 0, "Line being commented upon; requires that the -f flag also be set")
+	commentLgtm     = commentFlagSet.Bool("lgtm", false, "'Looks Good To Me'. Set this to express your approval. This cannot be combined with nmw")
+	commentNmw      = commentFlagSet.Bool("nmw", false, "'Needs More Work'. Set this to express your disapproval. This cannot be combined with lgtm")
+	commentCommit   = commentFlagSet.String("commit", "", "Commit of the review to comment on, such as one of the earlier commits of a multi-commit review; the comment stays on the file as of that commit, rather than following its line to the latest revision")
+	commentCategory = commentFlagSet.String("category", "", "Tag a -nmw comment with the reason for the rejection, such as \"needs-tests\" or \"design-concern\"")
+	commentSign     = commentFlagSet.Bool("S", false, "Sign the comment with the configured signing key")
+	commentTemplate = commentFlagSet.String("template", "", "Name of the saved comment template to use as the message, from "+
+		sharedTemplatesPath+"/<name>"+templateSuffix+" in the repo or ~/.config/appraise/templates/<name>"+templateSuffix)
+	commentEdit          = commentFlagSet.Bool("edit", false, "Open the message (such as the one from -template) in the editor before commenting")
+	commentListTemplates = commentFlagSet.Bool("list-templates", false, "List the available comment templates, rather than commenting")
+	commentQuote         = commentFlagSet.Bool("quote", false, "Quote the text of the parent comment (given with -p) at the start of the message, for context in flat exports such as email")
 )
 
-// commentOnReview adds a comment to the current code review.
-func commentOnReview(repo repository.Repo, args []string) error {
-	commentFlagSet.Parse(args)
-	args = commentFlagSet.Args()
-	if *commentLgtm && *commentNmw {
-		return errors.New("You cannot combine the flags -lgtm and -nmw.")
+var commentAttachments stringList
+
+func init() {
+	commentFlagSet.Var(&commentAttachments, "attach", "URL or local file to attach to the comment; may be repeated")
+}
+
+// buildAttachment creates an attachment for the given argument of the --attach flag.
+//
+// Local files are written to the repo as blobs, and anything else must be a URL.
+func buildAttachment(repo repository.Repo, arg string) (*comment.Attachment, error) {
+	if info, err := os.Stat(arg); err == nil && info.Mode().IsRegular() {
+		contents, err := ioutil.ReadFile(arg)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := repo.StoreBlob(comment.AttachmentsRef, contents)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to store the attachment %q: %v", arg, err)
+		}
+		return &comment.Attachment{Blob: hash, Name: filepath.Base(arg)}, nil
 	}
-	if *commentLine != 0 && *commentFile == "" {
-		return errors.New("Specifying a line number with the -l flag requires that you also specify a file name with the -f flag.")
+	if !strings.Contains(arg, "://") {
+		return nil, fmt.Errorf("The attachment %q is neither a URL nor a local file.", arg)
 	}
+	return &comment.Attachment{URL: arg}, nil
+}
 
-	var r *review.Review
-	var err error
-	if len(args) > 1 {
-		return errors.New("Only accepting a single review is supported.")
+// resolveReviewCommit returns the hash of the given commit, after checking that it is one
+// of the commits of the given review.
+func resolveReviewCommit(repo repository.Repo, r *review.Review, commit string) (string, error) {
+	hash, err := repo.GetCommitHash(commit)
+	if err != nil {
+		return "", fmt.Errorf("Unknown commit %q: %v", commit, err)
 	}
+	commits, err := r.GetCommits()
+	if err != nil {
+		return "", err
+	}
+	for _, reviewCommit := range commits {
+		if reviewCommit == hash {
+			return hash, nil
+		}
+	}
+	return "", fmt.Errorf("The commit %q is not one of the commits of review %.12s.", commit, r.Revision)
+}
 
-	if len(args) == 1 {
-		r, err = review.Get(repo, args[0])
-	} else {
-		r, err = review.GetCurrent(repo)
+// CommentOptions are the options of Comment, which match the flags of the "comment" command.
+type CommentOptions struct {
+	// Review is the hash of the review to comment on, or empty for the current review.
+	Review  string
+	Message string
+	// Parent is the hash of the comment being replied to, if any.
+	Parent string
+	// File and Line are the location being commented upon, if any. Line requires File.
+	File string
+	Line uint
+	// Commit is the commit of the review that the comment is about, if not its latest one.
+	Commit string
+	// Lgtm and Nmw express approval or disapproval, and cannot be combined.
+	Lgtm bool
+	Nmw  bool
+	// Category tags the reason for a rejection, and requires Nmw.
+	Category string
+	// Attachments are the URLs or local files to attach to the comment.
+	Attachments []string
+	// Sign signs the comment with the configured signing key.
+	Sign bool
+	// Template is the name of the comment template to use as the message, which cannot be
+	// combined with Message.
+	Template string
+	// Edit opens the message in the editor before the comment is added.
+	Edit bool
+	// Quote quotes the text of the parent comment at the start of the message, and requires Parent.
+	Quote bool
+}
+
+// findComment returns the comment with the given hash among the given threads, or nil if
+// there is none.
+func findComment(threads []review.CommentThread, hash string) *comment.Comment {
+	for _, thread := range threads {
+		if thread.Hash == hash {
+			return &thread.Comment
+		}
+		if c := findComment(thread.Children, hash); c != nil {
+			return c
+		}
 	}
+	return nil
+}
 
-	if err != nil {
-		return fmt.Errorf("Failed to load the review: %v\n", err)
+// quoteComment returns the text of the given comment as a blockquote, attributed to its
+// author, followed by the given message.
+func quoteComment(c comment.Comment, message string) string {
+	lines := []string{fmt.Sprintf("%s wrote:", c.Author)}
+	for _, line := range strings.Split(strings.TrimRight(c.Description, "\n"), "\n") {
+		if line == "" {
+			lines = append(lines, ">")
+		} else {
+			lines = append(lines, "> "+line)
+		}
+	}
+	return strings.Join(lines, "\n") + "\n\n" + message
+}
+
+// Comment adds a comment to a review, as the "comment" command does.
+func Comment(repo repository.Repo, opts CommentOptions) error {
+	if opts.Lgtm && opts.Nmw {
+		return errors.New("You cannot combine the flags -lgtm and -nmw.")
+	}
+	if opts.Category != "" {
+		if !opts.Nmw {
+			return errors.New("The -category flag can only be used with the -nmw flag.")
+		}
+		if err := review.ValidateRejectionCategory(repo, opts.Category); err != nil {
+			return err
+		}
+	}
+	if opts.Line != 0 && opts.File == "" {
+		return errors.New("Specifying a line number with the -l flag requires that you also specify a file name with the -f flag.")
+	}
+	if opts.Template != "" && opts.Message != "" {
+		return errors.New("Only one of -m or -template is allowed.")
 	}
-	if r == nil {
-		return errors.New("There is no matching review.")
+	if opts.Quote && opts.Parent == "" {
+		return errors.New("The -quote flag requires the comment being replied to, given with the -p flag.")
 	}
 
-	commentedUponCommit, err := r.GetHeadCommit()
+	r, err := loadReview(repo, opts.Review)
+	if err != nil {
+		return err
+	}
+
+	headCommit, err := r.GetHeadCommit()
 	if err != nil {
 		return err
 	}
 	location := comment.Location{
-		Commit: commentedUponCommit,
+		Commit: headCommit,
 	}
-	if *commentFile != "" {
-		location.Path = *commentFile
-		if *commentLine != 0 {
+	if opts.Commit != "" {
+		if location.Commit, err = resolveReviewCommit(repo, r, opts.Commit); err != nil {
+			return err
+		}
+		location.Pinned = true
+	}
+	commentedUponCommit := location.Commit
+	if opts.File != "" {
+		location.Path = comment.NormalizePath(opts.File)
+		isSubmodule, err := repo.IsSubmodule(commentedUponCommit, location.Path)
+		if err != nil {
+			return err
+		}
+		if isSubmodule && opts.Line != 0 {
+			return fmt.Errorf("The path %q is a submodule, so comments on it cannot specify a line number.", location.Path)
+		}
+		if _, err := repo.Show(commentedUponCommit, location.Path); !isSubmodule && err != nil {
+			if location.Pinned {
+				return fmt.Errorf("The file %q does not exist in commit %.12s.", location.Path, commentedUponCommit)
+			}
+			if renamed, ok := r.FindRenamedPath(location.Path); ok {
+				fmt.Fprintf(os.Stderr, "Warning: %q does not exist at %.12s, since the review renamed it to %q; did you mean \"-f %s\"?\n",
+					location.Path, commentedUponCommit, renamed, renamed)
+			}
+		}
+		if opts.Line != 0 {
 			location.Range = &comment.Range{
-				StartLine: uint32(*commentLine),
+				StartLine: uint32(opts.Line),
 			}
 		}
 	}
 
+	message := opts.Message
+	if opts.Template != "" {
+		message, err = renderCommentTemplate(repo, opts.Template, CommentContext{
+			File:      location.Path,
+			Line:      opts.Line,
+			Requester: r.Request.Requester,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if opts.Quote {
+		parent := findComment(r.Comments, opts.Parent)
+		if parent == nil {
+			return fmt.Errorf("Review %.12s has no comment with the hash %q to quote.", r.Revision, opts.Parent)
+		}
+		message = quoteComment(*parent, message)
+	}
+	if opts.Edit {
+		if message, err = editMessage(repo, message); err != nil {
+			return err
+		}
+	}
+
 	userEmail, err := repo.GetUserEmail()
 	if err != nil {
 		return err
 	}
-	c := comment.New(userEmail, *commentMessage)
+	c := comment.New(userEmail, message)
+	for _, arg := range opts.Attachments {
+		attachment, err := buildAttachment(repo, arg)
+		if err != nil {
+			return err
+		}
+		c.Attachments = append(c.Attachments, *attachment)
+	}
 	c.Location = &location
-	c.Parent = *commentParent
-	if *commentLgtm || *commentNmw {
-		resolved := *commentLgtm
+	c.Snapshot = headCommit
+	c.Parent = opts.Parent
+	if opts.Lgtm || opts.Nmw {
+		resolved := opts.Lgtm
 		c.Resolved = &resolved
+		c.Category = opts.Category
+	}
+	if err := signIfRequested(repo, opts.Sign, c.Sign); err != nil {
+		return err
 	}
 	return r.AddComment(c)
 }
 
+// commentOnReview adds a comment to the current code review.
+func commentOnReview(repo repository.Repo, args []string) error {
+	commentAttachments = nil
+	if err := commentFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = commentFlagSet.Args()
+	if len(args) > 1 {
+		return errors.New("Only accepting a single review is supported.")
+	}
+	if *commentListTemplates {
+		return printCommentTemplates(repo)
+	}
+	opts := CommentOptions{
+		Message:     *commentMessage,
+		Parent:      *commentParent,
+		File:        *commentFile,
+		Line:        *commentLine,
+		Commit:      *commentCommit,
+		Lgtm:        *commentLgtm,
+		Nmw:         *commentNmw,
+		Category:    *commentCategory,
+		Attachments: commentAttachments,
+		Sign:        *commentSign,
+		Template:    *commentTemplate,
+		Edit:        *commentEdit,
+		Quote:       *commentQuote,
+	}
+	if len(args) == 1 {
+		opts.Review = args[0]
+	}
+	return Comment(repo, opts)
+}
+
 // commentCmd defines the "comment" subcommand.
 var commentCmd = &Command{
 	Usage: func(arg0 string) {
@@ -105,4 +312,5 @@ var commentCmd = &Command{
 	RunMethod: func(repo repository.Repo, args []string) error {
 		return commentOnReview(repo, args)
 	},
+	Flags: commentFlagSet,
 }
@@ -0,0 +1,221 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const (
+	// sharedTemplatesPath is the directory, within the working tree of a repo, holding the
+	// comment templates that are shared by the team.
+	sharedTemplatesPath = ".appraise/templates"
+	// templateSuffix is the file name suffix of comment templates.
+	templateSuffix = ".txt"
+	// editorHint is the text shown below the message being edited, which is removed afterward.
+	editorHint = "# Edit the comment above. Lines starting with \"#\" are ignored, and an empty comment aborts it."
+)
+
+// CommentTemplate is a named, saved comment message.
+type CommentTemplate struct {
+	Name string
+	// Path is the file holding the template.
+	Path string
+	// Personal is set for templates of the user, rather than ones shared by the team.
+	Personal bool
+}
+
+// CommentContext holds the values that can be substituted into a comment template.
+type CommentContext struct {
+	// File and Line are the location being commented upon, if any.
+	File string
+	Line uint
+	// Requester is whoever requested the review.
+	Requester string
+}
+
+// personalTemplatesDir returns the directory holding the user's own comment templates,
+// which is "appraise/templates" in $XDG_CONFIG_HOME, or else in ~/.config.
+func personalTemplatesDir() (string, error) {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		configDir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configDir, "appraise", "templates"), nil
+}
+
+// ListCommentTemplates returns the comment templates available in the given repo, sorted by
+// name. Personal templates take precedence over shared ones with the same name.
+func ListCommentTemplates(repo repository.Repo) ([]CommentTemplate, error) {
+	templates := make(map[string]CommentTemplate)
+	addTemplates := func(dir string, personal bool) error {
+		files, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if file.IsDir() || !strings.HasSuffix(file.Name(), templateSuffix) {
+				continue
+			}
+			name := strings.TrimSuffix(file.Name(), templateSuffix)
+			templates[name] = CommentTemplate{Name: name, Path: filepath.Join(dir, file.Name()), Personal: personal}
+		}
+		return nil
+	}
+	if path := repo.GetPath(); path != "" {
+		if err := addTemplates(filepath.Join(path, sharedTemplatesPath), false); err != nil {
+			return nil, err
+		}
+	}
+	if dir, err := personalTemplatesDir(); err == nil {
+		if err := addTemplates(dir, true); err != nil {
+			return nil, err
+		}
+	}
+	var result []CommentTemplate
+	for _, t := range templates {
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// renderCommentTemplate returns the message of the named comment template, with the
+// values of the given context substituted into it.
+func renderCommentTemplate(repo repository.Repo, name string, context CommentContext) (string, error) {
+	templates, err := ListCommentTemplates(repo)
+	if err != nil {
+		return "", err
+	}
+	for _, t := range templates {
+		if t.Name != name {
+			continue
+		}
+		contents, err := ioutil.ReadFile(t.Path)
+		if err != nil {
+			return "", err
+		}
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(string(contents))
+		if err != nil {
+			return "", fmt.Errorf("Failed to parse the comment template %q: %v", t.Path, err)
+		}
+		var message bytes.Buffer
+		if err := tmpl.Execute(&message, context); err != nil {
+			return "", fmt.Errorf("Failed to fill in the comment template %q: %v", t.Path, err)
+		}
+		return strings.TrimSpace(message.String()), nil
+	}
+	return "", CommandError{
+		Err:      fmt.Errorf("There is no comment template named %q", name),
+		Guidance: "See the available templates with \"git appraise comment -list-templates\".",
+		ExitCode: ExitUserError,
+	}
+}
+
+// printCommentTemplates prints the available comment templates, along with the first line of each.
+func printCommentTemplates(repo repository.Repo) error {
+	templates, err := ListCommentTemplates(repo)
+	if err != nil {
+		return err
+	}
+	if len(templates) == 0 {
+		fmt.Printf("There are no comment templates; add them to %s in the repo, or to your own %s.\n",
+			sharedTemplatesPath, filepath.Join("~", ".config", "appraise", "templates"))
+		return nil
+	}
+	for _, t := range templates {
+		contents, err := ioutil.ReadFile(t.Path)
+		if err != nil {
+			return err
+		}
+		source := "shared"
+		if t.Personal {
+			source = "personal"
+		}
+		fmt.Printf("%s (%s): %s\n", t.Name, source, firstLine(string(contents)))
+	}
+	return nil
+}
+
+// getEditor returns the command that edits messages, which is chosen as git chooses it.
+func getEditor(repo repository.Repo) string {
+	if editor := os.Getenv("GIT_EDITOR"); editor != "" {
+		return editor
+	}
+	if editor := repository.GetConfigValue(repo, "core.editor", ""); editor != "" {
+		return editor
+	}
+	for _, variable := range []string{"VISUAL", "EDITOR"} {
+		if editor := os.Getenv(variable); editor != "" {
+			return editor
+		}
+	}
+	return "vi"
+}
+
+// editMessage opens the given message in the user's editor, and returns the edited message.
+func editMessage(repo repository.Repo, message string) (string, error) {
+	file, err := ioutil.TempFile("", "git-appraise-comment-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(message + "\n\n" + editorHint + "\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	cmd := shellCommand(fmt.Sprintf("%s %q", getEditor(repo), file.Name()))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("Failed to edit the comment: %v", err)
+	}
+	edited, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(edited), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	message = strings.TrimSpace(strings.Join(lines, "\n"))
+	if message == "" {
+		return "", errors.New("Aborting the comment, since its message is empty.")
+	}
+	return message, nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/reviewtest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCommentTemplates(t *testing.T) {
+	defer func() { *commentListTemplates = false }()
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	defer os.Setenv("GIT_EDITOR", os.Getenv("GIT_EDITOR"))
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	for path, contents := range map[string]string{
+		"repo/.appraise/templates/needs-test.txt": "Please add a test for {{.File}}:{{.Line}}, {{.Requester}}.\n",
+		"repo/.appraise/templates/nit.txt":        "nit: gofmt\n",
+		"config/appraise/templates/nit.txt":       "nit: please run gofmt\n\nThanks!\n",
+	} {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo := repository.NewRepoWithHistory(map[string][]string{"master": {"A"}, "feature": {"A", "B"}})
+	repo.SetPath(filepath.Join(dir, "repo"))
+	if _, err := reviewtest.AddReview(repo, "refs/heads/feature", "refs/heads/master"); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := captureStdout(func() error { return commentOnReview(repo, []string{"-list-templates"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "needs-test (shared): Please add a test for {{.File}}:{{.Line}}, {{.Requester}}.\n" +
+		"nit (personal): nit: please run gofmt\n"; list != expected {
+		t.Errorf("Unexpected list of templates: got %q, want %q", list, expected)
+	}
+
+	if err := Comment(repo, CommentOptions{Review: "B", Template: "needs-test", File: "B.txt", Line: 1}); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("GIT_EDITOR", "sed -i -e s/gofmt/goimports/")
+	if err := Comment(repo, CommentOptions{Review: "B", Template: "nit", Edit: true}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.Get(repo, "B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if findThread(r.Comments, "Please add a test for B.txt:1, user@example.com.") == nil {
+		t.Errorf("The template was not filled in: %+v", r.Comments)
+	}
+	if findThread(r.Comments, "nit: please run goimports\n\nThanks!") == nil {
+		t.Errorf("The edited template was not used: %+v", r.Comments)
+	}
+
+	if err := Comment(repo, CommentOptions{Review: "B", Template: "missing"}); ExitCode(err) != ExitUserError {
+		t.Errorf("Unexpected result of using a missing template: %v", err)
+	}
+	if err := Comment(repo, CommentOptions{Review: "B", Template: "nit", Message: "Both"}); err == nil {
+		t.Error("Unexpectedly allowed both a message and a template")
+	}
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/comment"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// findThread returns the thread of the comment with the given message, searching the replies too.
+func findThread(threads []review.CommentThread, message string) *review.CommentThread {
+	for i := range threads {
+		if threads[i].Comment.Description == message {
+			return &threads[i]
+		}
+		if thread := findThread(threads[i].Children, message); thread != nil {
+			return thread
+		}
+	}
+	return nil
+}
+
+func TestComment(t *testing.T) {
+	repo := repository.NewMemoryRepoForTest()
+	if err := Comment(repo, CommentOptions{Review: repository.TestCommitG, Message: "Both", Lgtm: true, Nmw: true}); err == nil {
+		t.Error("Unexpectedly allowed a comment that is both -lgtm and -nmw")
+	}
+	if err := Comment(repo, CommentOptions{Review: repository.TestCommitG, Message: "Where?", Line: 3}); err == nil {
+		t.Error("Unexpectedly allowed a line number without a file")
+	}
+	if err := Comment(repo, CommentOptions{Review: repository.TestCommitA, Message: "No review"}); err == nil {
+		t.Error("Unexpectedly commented on a commit without a review")
+	}
+
+	if err := Comment(repo, CommentOptions{Review: repository.TestCommitG, Message: "Why?", File: "./foo.txt", Line: 3}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.Get(repo, repository.TestCommitG)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	thread := findThread(r.Comments, "Why?")
+	if thread == nil {
+		t.Fatalf("The comment was not added: %+v", r.Comments)
+	}
+	location := thread.Comment.Location
+	if location == nil || location.Path != "foo.txt" || location.Range == nil || location.Range.StartLine != 3 {
+		t.Errorf("Unexpected location of the comment: %+v", location)
+	}
+
+	if err := Comment(repo, CommentOptions{Review: repository.TestCommitG, Message: "Because.", Parent: thread.Hash, Lgtm: true}); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = review.Get(repo, repository.TestCommitG); err != nil || r == nil {
+		t.Fatalf("Failed to reload the review: %v", err)
+	}
+	thread = findThread(r.Comments, "Why?")
+	if thread == nil || len(thread.Children) != 1 || thread.Children[0].Comment.Description != "Because." {
+		t.Fatalf("The reply was not added to the thread: %+v", thread)
+	}
+	if resolved := thread.Children[0].Comment.Resolved; resolved == nil || !*resolved {
+		t.Errorf("The reply does not resolve the thread: %+v", thread.Children[0].Comment)
+	}
+}
+
+func TestCommentAttachments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	screenshot := filepath.Join(dir, "screenshot.png")
+	if err := ioutil.WriteFile(screenshot, []byte("not really a PNG"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repo := repository.NewMemoryRepoForTest()
+	if _, err := buildAttachment(repo, filepath.Join(dir, "missing.png")); err == nil {
+		t.Error("Unexpectedly attached a file that does not exist")
+	}
+	if _, err := buildAttachment(repo, dir); err == nil {
+		t.Error("Unexpectedly attached a directory")
+	}
+
+	const url = "https://ci.example.com/builds/42"
+	if err := Comment(repo, CommentOptions{Review: repository.TestCommitG, Message: "See the build", Attachments: []string{url, screenshot}}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.Get(repo, repository.TestCommitG)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	thread := findThread(r.Comments, "See the build")
+	if thread == nil || len(thread.Comment.Attachments) != 2 {
+		t.Fatalf("The attachments were not added: %+v", thread)
+	}
+	link, file := thread.Comment.Attachments[0], thread.Comment.Attachments[1]
+	if link.URL != url || link.Blob != "" {
+		t.Errorf("Unexpected URL attachment: %+v", link)
+	}
+	if file.Name != "screenshot.png" || file.Blob == "" || file.URL != "" {
+		t.Fatalf("Unexpected file attachment: %+v", file)
+	}
+	// The blob is kept reachable from the attachments notes ref, so that it is pushed and pulled.
+	if blob := repo.GetNotes(comment.AttachmentsRef, file.Blob); len(blob) != 1 || string(blob[0]) != "not really a PNG" {
+		t.Errorf("Unexpected contents of the attached file: %q", blob)
+	}
+
+	out, err := captureStdout(func() error { return showCmd.Run(repo, []string{repository.TestCommitG}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"attachment: " + url,
+		"attachment: screenshot.png (git cat-file blob " + file.Blob + ")",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("The review does not show %q:\n%s", expected, out)
+		}
+	}
+}
+
+func TestCommentAttachmentsAreNotReused(t *testing.T) {
+	defer resetFlags(commentFlagSet)
+	repo := repository.NewMemoryRepoForTest()
+	const url = "https://ci.example.com/builds/42"
+	if err := commentOnReview(repo, []string{"-m", "With a link", "-attach", url, repository.TestCommitG}); err != nil {
+		t.Fatal(err)
+	}
+	if err := commentOnReview(repo, []string{"-m", "Without a link", repository.TestCommitG}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.Get(repo, repository.TestCommitG)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	if thread := findThread(r.Comments, "With a link"); thread == nil || len(thread.Comment.Attachments) != 1 {
+		t.Errorf("Unexpected attachments of the first comment: %+v", thread)
+	}
+	if thread := findThread(r.Comments, "Without a link"); thread == nil || len(thread.Comment.Attachments) != 0 {
+		t.Errorf("The attachments of the first comment were reused: %+v", thread)
+	}
+}
+
+func TestCommentQuote(t *testing.T) {
+	repo := repository.NewMemoryRepoForTest()
+	if err := Comment(repo, CommentOptions{Review: repository.TestCommitG, Message: "Quoted", Quote: true}); err == nil {
+		t.Error("Unexpectedly quoted a comment without a parent")
+	}
+	if err := Comment(repo, CommentOptions{Review: repository.TestCommitG, Message: "Why?\n\nThis looks odd."}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.Get(repo, repository.TestCommitG)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	thread := findThread(r.Comments, "Why?\n\nThis looks odd.")
+	if thread == nil {
+		t.Fatalf("The comment was not added: %+v", r.Comments)
+	}
+	if err := Comment(repo, CommentOptions{Review: repository.TestCommitG, Message: "Reply", Parent: "missing", Quote: true}); err == nil {
+		t.Error("Unexpectedly quoted a comment that does not exist")
+	}
+	if err := Comment(repo, CommentOptions{Review: repository.TestCommitG, Message: "Because.", Parent: thread.Hash, Quote: true}); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = review.Get(repo, repository.TestCommitG); err != nil || r == nil {
+		t.Fatalf("Failed to reload the review: %v", err)
+	}
+	expected := thread.Comment.Author + " wrote:\n> Why?\n>\n> This looks odd.\n\nBecause."
+	if thread = findThread(r.Comments, expected); thread == nil || thread.Comment.Parent == "" {
+		t.Fatalf("The reply does not quote its parent: %+v", r.Comments)
+	}
+}
+
+func TestCommentOnCommit(t *testing.T) {
+	defer func() { *showPatch = false }()
+	repo := repository.NewRepoWithHistory(nil)
+	repo.AddCommit("A", "First commit", map[string]string{"a.txt": "one\n"})
+	repo.AddCommit("B", "Add a second line", map[string]string{"a.txt": "one\ntwo\n"}, "A")
+	repo.AddCommit("C", "Rework the lines", map[string]string{"a.txt": "zero\none\n2\n"}, "B")
+	repo.SetRef("refs/heads/master", "A")
+	repo.SetRef("refs/heads/feature", "C")
+	if err := repo.SwitchToRef("refs/heads/feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Request(repo, RequestOptions{Target: "refs/heads/master", Quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Comment(repo, CommentOptions{Message: "Not in the review", Commit: "master", File: "a.txt", Line: 1}); err == nil {
+		t.Error("Unexpectedly commented on a commit that is not in the review")
+	}
+	if err := Comment(repo, CommentOptions{Message: "Spell it out", Commit: "HEAD~1", File: "a.txt", Line: 2}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.GetCurrent(repo)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	thread := findThread(r.Comments, "Spell it out")
+	if thread == nil {
+		t.Fatalf("The comment was not added: %+v", r.Comments)
+	}
+	if location := thread.Comment.Location; location.Commit != "B" || !location.Pinned {
+		t.Errorf("Unexpected location of the comment: %+v", location)
+	}
+	if thread.Comment.Snapshot != "C" {
+		t.Errorf("Unexpected snapshot of the comment: %q", thread.Comment.Snapshot)
+	}
+
+	// The comment is shown with the line from its commit, which the next commit changed.
+	shown, err := captureStdout(func() error {
+		return showReview(repo, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(shown, "|two\n") || !strings.Contains(shown, "pinned to this commit") || strings.Contains(shown, "outdated") {
+		t.Errorf("The comment is not shown on the line of its commit:\n%s", shown)
+	}
+	patch, err := captureStdout(func() error {
+		return showReview(repo, []string{"-patch"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(patch, "\n+two\n#appraise: user@example.com (fyi)") {
+		t.Errorf("The comment does not follow its line in the patch of its commit:\n%s", patch)
+	}
+}
@@ -0,0 +1,389 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// completeCommandName is the name of the hidden command that the completion scripts call
+// to complete the review hashes and reviewers.
+const completeCommandName = "__complete"
+
+// completionReviewCommands are the commands that take a review hash as their argument.
+var completionReviewCommands = map[string]bool{
+	"accept":        true,
+	"amend-request": true,
+	"attention":     true,
+	"comment":       true,
+	"diff":          true,
+	"export":        true,
+	"format-patch":  true,
+	"label":         true,
+	"reject":        true,
+	"request":       true,
+	"show":          true,
+	"subscribe":     true,
+	"unsubscribe":   true,
+	"verify":        true,
+}
+
+// completionReviewerFlags are the flags, by command, whose values are reviewer emails.
+var completionReviewerFlags = map[string]string{
+	"request": "r",
+}
+
+// completionFlag describes a single flag of a command, for the completion scripts.
+type completionFlag struct {
+	// Name is the flag as it is typed, such as "-m" or "--json".
+	Name        string
+	Description string
+	// TakesValue is set for the flags that are followed by a value, rather than being booleans.
+	TakesValue bool
+	// Reviewers is set for the flags whose values are reviewer emails.
+	Reviewers bool
+}
+
+// completionCommand describes a single command, for the completion scripts.
+type completionCommand struct {
+	Name  string
+	Flags []completionFlag
+	// Reviews is set for the commands that take a review hash.
+	Reviews bool
+}
+
+// listCompletionCommands returns every command that can be completed, sorted by name.
+func listCompletionCommands() []completionCommand {
+	var commands []completionCommand
+	for name, cmd := range CommandMap {
+		if strings.HasPrefix(name, "__") {
+			continue
+		}
+		command := completionCommand{Name: name, Reviews: completionReviewCommands[name]}
+		if cmd.Flags != nil {
+			cmd.Flags.VisitAll(func(f *flag.Flag) {
+				typed := "--" + f.Name
+				if len(f.Name) == 1 {
+					typed = "-" + f.Name
+				}
+				boolFlag, isBool := f.Value.(interface{ IsBoolFlag() bool })
+				command.Flags = append(command.Flags, completionFlag{
+					Name:        typed,
+					Description: firstLine(f.Usage),
+					TakesValue:  !isBool || !boolFlag.IsBoolFlag(),
+					Reviewers:   completionReviewerFlags[name] == f.Name,
+				})
+			})
+		}
+		commands = append(commands, command)
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+	return commands
+}
+
+// completionNames returns the names of the given commands, separated by spaces.
+func completionNames(commands []completionCommand) string {
+	var names []string
+	for _, command := range commands {
+		names = append(names, command.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+// flagNames returns the names of the given flags (or only those that take values), separated by the given separator.
+func flagNames(flags []completionFlag, onlyValues bool, separator string) string {
+	var names []string
+	for _, f := range flags {
+		if f.TakesValue || !onlyValues {
+			names = append(names, f.Name)
+		}
+	}
+	return strings.Join(names, separator)
+}
+
+// reviewerFlagNames returns the names of the flags (for any command) whose values are
+// reviewer emails, separated by the given separator.
+func reviewerFlagNames(commands []completionCommand, separator string) string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, command := range commands {
+		for _, f := range command.Flags {
+			if f.Reviewers && !seen[f.Name] {
+				seen[f.Name] = true
+				names = append(names, f.Name)
+			}
+		}
+	}
+	return strings.Join(names, separator)
+}
+
+// writeBashCompletion writes the completion script for bash.
+//
+// Defining _git_appraise also lets the completion for git itself complete "git appraise".
+func writeBashCompletion(w io.Writer, commands []completionCommand) {
+	names := completionNames(commands)
+	fmt.Fprintf(w, `# bash completion for git-appraise; load it with:
+#     source <(git appraise completion bash)
+_git_appraise() {
+	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+	local command="" i
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		case "${COMP_WORDS[i]}" in
+		%s)
+			command="${COMP_WORDS[i]}"
+			break
+			;;
+		esac
+	done
+	if [[ -z "$command" ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+		return
+	fi
+	case "$prev" in
+	%s)
+		COMPREPLY=($(compgen -W "$(git appraise %s reviewers 2>/dev/null)" -- "$cur"))
+		return
+		;;
+	esac
+	case "$command" in
+`, strings.Replace(names, " ", "|", -1), names, reviewerFlagNames(commands, "|"), completeCommandName)
+	for _, command := range commands {
+		fmt.Fprintf(w, "\t%s)\n", command.Name)
+		if values := flagNames(command.Flags, true, "|"); values != "" {
+			// The values of other flags are left to the default completion.
+			fmt.Fprintf(w, "\t\tcase \"$prev\" in %s) return ;; esac\n", values)
+		}
+		fmt.Fprintf(w, "\t\tif [[ \"$cur\" == -* ]]; then\n\t\t\tCOMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n",
+			flagNames(command.Flags, false, " "))
+		if command.Reviews {
+			fmt.Fprintf(w, "\t\telse\n\t\t\tCOMPREPLY=($(compgen -W \"$(git appraise %s reviews 2>/dev/null | cut -f1)\" -- \"$cur\"))\n",
+				completeCommandName)
+		}
+		fmt.Fprintf(w, "\t\tfi\n\t\t;;\n")
+	}
+	fmt.Fprintf(w, "\tesac\n}\ncomplete -o default -F _git_appraise git-appraise\n")
+}
+
+// zshQuote quotes the given text for zsh (or fish), within single quotes.
+func zshQuote(text string) string {
+	return "'" + strings.Replace(text, "'", `'\''`, -1) + "'"
+}
+
+// writeZshCompletion writes the completion script for zsh.
+//
+// Defining _git-appraise also lets the completion for git itself complete "git appraise".
+func writeZshCompletion(w io.Writer, commands []completionCommand) {
+	names := completionNames(commands)
+	fmt.Fprintf(w, `#compdef git-appraise
+# zsh completion for git-appraise; load it with:
+#     source <(git appraise completion zsh)
+# or save it as "_git-appraise" in a directory on your $fpath.
+_git-appraise() {
+	local command i
+	local -a candidates
+	for ((i = 2; i < CURRENT; i++)); do
+		case ${words[i]} in
+		(%s)
+			command=${words[i]}
+			break
+			;;
+		esac
+	done
+	if [[ -z $command ]]; then
+		compadd -- %s
+		return
+	fi
+	case ${words[CURRENT-1]} in
+	(%s)
+		compadd -- ${(f)"$(git appraise %s reviewers 2>/dev/null)"}
+		return
+		;;
+	esac
+	case $command in
+`, strings.Replace(names, " ", "|", -1), names, reviewerFlagNames(commands, "|"), completeCommandName)
+	for _, command := range commands {
+		fmt.Fprintf(w, "\t(%s)\n", command.Name)
+		if values := flagNames(command.Flags, true, "|"); values != "" {
+			fmt.Fprintf(w, "\t\tcase ${words[CURRENT-1]} in (%s) _default; return ;; esac\n", values)
+		}
+		fmt.Fprintf(w, "\t\tif [[ $PREFIX == -* ]]; then\n\t\t\tcandidates=(\n")
+		for _, f := range command.Flags {
+			fmt.Fprintf(w, "\t\t\t\t%s\n", zshQuote(f.Name+":"+f.Description))
+		}
+		fmt.Fprintf(w, "\t\t\t)\n\t\t\t_describe -t flags flag candidates\n")
+		if command.Reviews {
+			fmt.Fprintf(w, "\t\telse\n\t\t\tcandidates=(${(f)\"$(git appraise %s reviews 2>/dev/null | sed 's/\t/:/')\"})\n"+
+				"\t\t\t_describe -t reviews review candidates\n", completeCommandName)
+		}
+		fmt.Fprintf(w, "\t\tfi\n\t\t;;\n")
+	}
+	fmt.Fprintf(w, `	esac
+}
+if [[ $funcstack[1] == _git-appraise ]]; then
+	_git-appraise "$@"
+else
+	compdef _git-appraise git-appraise
+fi
+`)
+}
+
+// writeFishCompletion writes the completion script for fish.
+func writeFishCompletion(w io.Writer, commands []completionCommand) {
+	names := completionNames(commands)
+	fmt.Fprintf(w, `# fish completion for git-appraise; load it with:
+#     git appraise completion fish | source
+function __git_appraise_needs_command
+	for word in (commandline -opc)[2..-1]
+		if contains -- $word %s
+			return 1
+		end
+	end
+	return 0
+end
+
+function __git_appraise_using_command
+	contains -- $argv[1] (commandline -opc)
+end
+
+complete -c git-appraise -f -n __git_appraise_needs_command -a %s
+`, names, zshQuote(names))
+	for _, command := range commands {
+		condition := zshQuote("__git_appraise_using_command " + command.Name)
+		for _, f := range command.Flags {
+			name := "-l " + strings.TrimPrefix(f.Name, "--")
+			if !strings.HasPrefix(f.Name, "--") {
+				name = "-o " + strings.TrimPrefix(f.Name, "-")
+			}
+			line := fmt.Sprintf("complete -c git-appraise -f -n %s %s -d %s", condition, name, zshQuote(f.Description))
+			if f.Reviewers {
+				line += fmt.Sprintf(" -x -a '(git appraise %s reviewers)'", completeCommandName)
+			} else if f.TakesValue {
+				line += " -r -F"
+			}
+			fmt.Fprintln(w, line)
+		}
+		if command.Reviews {
+			fmt.Fprintf(w, "complete -c git-appraise -f -n %s -a '(git appraise %s reviews)'\n", condition, completeCommandName)
+		}
+	}
+}
+
+// printCompletion prints the completion script for the given shell.
+func printCompletion(args []string) error {
+	if len(args) != 1 {
+		return errors.New("The completion command requires a single shell: \"bash\", \"zsh\", or \"fish\".")
+	}
+	commands := listCompletionCommands()
+	switch args[0] {
+	case "bash":
+		writeBashCompletion(os.Stdout, commands)
+	case "zsh":
+		writeZshCompletion(os.Stdout, commands)
+	case "fish":
+		writeFishCompletion(os.Stdout, commands)
+	default:
+		return fmt.Errorf("Unknown shell %q; expected \"bash\", \"zsh\", or \"fish\".", args[0])
+	}
+	return nil
+}
+
+// completeReviews prints the abbreviated hash and the first line of the description of
+// each open review, separated by a tab.
+func completeReviews(w io.Writer, repo repository.Repo) {
+	review.WalkAllCached(repo, false, func(r review.Review) {
+		if r.IsOpen() {
+			fmt.Fprintf(w, "%.12s\t%s\n", r.Revision, firstLine(r.Request.Description))
+		}
+	}, nil)
+}
+
+// completeReviewers prints the email of everyone who has requested or been asked to review
+// any of the reviews, in sorted order.
+func completeReviewers(w io.Writer, repo repository.Repo) {
+	seen := make(map[string]bool)
+	review.WalkAllCached(repo, false, func(r review.Review) {
+		seen[r.Request.Requester] = true
+		for _, reviewer := range r.Request.Reviewers {
+			seen[reviewer] = true
+		}
+	}, nil)
+	var reviewers []string
+	for reviewer := range seen {
+		if reviewer != "" {
+			reviewers = append(reviewers, reviewer)
+		}
+	}
+	sort.Strings(reviewers)
+	for _, reviewer := range reviewers {
+		fmt.Fprintln(w, reviewer)
+	}
+}
+
+// complete prints the candidates for completing the given kind of argument, for the
+// completion scripts. This reads the reviews from the cache, so that it is quick enough
+// to run at every press of the tab key.
+func complete(repo repository.Repo, args []string) error {
+	if len(args) != 1 {
+		return errors.New("The __complete command requires one of \"reviews\" or \"reviewers\".")
+	}
+	switch args[0] {
+	case "reviews":
+		completeReviews(os.Stdout, repo)
+	case "reviewers":
+		completeReviewers(os.Stdout, repo)
+	default:
+		return fmt.Errorf("Unknown completion %q; expected \"reviews\" or \"reviewers\".", args[0])
+	}
+	return nil
+}
+
+// completionCmd defines the "completion" subcommand.
+var completionCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s completion <bash|zsh|fish>\n", arg0)
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return printCompletion(args)
+	},
+}
+
+// completeCmd defines the hidden "__complete" subcommand, used by the completion scripts.
+var completeCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s %s <reviews|reviewers>\n", arg0, completeCommandName)
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return complete(repo, args)
+	},
+}
+
+func init() {
+	// These are added here rather than in CommandMap itself, since the completion
+	// command needs to look through CommandMap for the other commands.
+	CommandMap["completion"] = completionCmd
+	CommandMap[completeCommandName] = completeCmd
+}
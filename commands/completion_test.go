@@ -0,0 +1,93 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCompletionCommands(t *testing.T) {
+	commands := listCompletionCommands()
+	if len(commands) != len(CommandMap)-1 {
+		t.Errorf("Unexpected number of commands to complete: got %d, want %d", len(commands), len(CommandMap)-1)
+	}
+	for _, command := range commands {
+		if strings.HasPrefix(command.Name, "__") {
+			t.Errorf("The hidden command %q is completed", command.Name)
+		}
+		if CommandMap[command.Name].Flags == nil && command.Name != "completion" {
+			t.Errorf("The flags of the %q command are not set", command.Name)
+		}
+	}
+}
+
+func TestCompletionScripts(t *testing.T) {
+	commands := listCompletionCommands()
+	for _, test := range []struct {
+		shell    string
+		write    func(w *bytes.Buffer)
+		expected []string
+	}{
+		{"bash", func(w *bytes.Buffer) { writeBashCompletion(w, commands) }, []string{
+			"complete -o default -F _git_appraise git-appraise",
+			`case "$prev" in --ci-template|--color|--diff-opts|--remote-url) return ;; esac`,
+			"__complete reviews",
+			"__complete reviewers",
+		}},
+		{"zsh", func(w *bytes.Buffer) { writeZshCompletion(w, commands) }, []string{
+			"compdef _git-appraise git-appraise",
+			"'--porcelain:Format the output as stable",
+		}},
+		{"fish", func(w *bytes.Buffer) { writeFishCompletion(w, commands) }, []string{
+			"-n '__git_appraise_using_command request' -o r -d 'Comma-separated list of reviewers' -x -a '(git appraise __complete reviewers)'",
+			"-n '__git_appraise_using_command show' -a '(git appraise __complete reviews)'",
+		}},
+	} {
+		var script bytes.Buffer
+		test.write(&script)
+		for _, expected := range test.expected {
+			if !strings.Contains(script.String(), expected) {
+				t.Errorf("The %s completion does not contain %q:\n%s", test.shell, expected, script.String())
+			}
+		}
+		if shell, err := exec.LookPath(test.shell); err == nil {
+			cmd := exec.Command(shell, "-n")
+			cmd.Stdin = &script
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("The %s completion is not valid: %v\n%s", test.shell, err, out)
+			}
+		}
+	}
+}
+
+func TestCompleteReviews(t *testing.T) {
+	repo := repository.NewMemoryRepoForTest()
+	var reviews, reviewers bytes.Buffer
+	completeReviews(&reviews, repo)
+	if expected := fmt.Sprintf("%.12s\tG\n", repository.TestCommitG); reviews.String() != expected {
+		t.Errorf("Unexpected reviews completed: got %q, want %q", reviews.String(), expected)
+	}
+	completeReviewers(&reviewers, repo)
+	if reviewers.String() != "ojarjur\n" {
+		t.Errorf("Unexpected reviewers completed: %q", reviewers.String())
+	}
+}
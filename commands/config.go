@@ -0,0 +1,397 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/commands/output"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// configKeyPrefix is the prefix of the git config keys that hold the git-appraise settings.
+const configKeyPrefix = "appraise."
+
+var configFlagSet = flag.NewFlagSet("config", flag.ContinueOnError)
+var configList = configFlagSet.Bool("list", false, "Print the effective value of every setting, and where it came from; the same as \"config list\"")
+
+// configSetting describes one of the git config settings that git-appraise reads.
+type configSetting struct {
+	Key         string
+	Description string
+	// validate checks a new value for the setting, and is nil if any value is allowed.
+	validate func(repo repository.Repo, value string) error
+}
+
+// configSettings lists the known git-appraise settings, sorted by key.
+var configSettings = []configSetting{
+	{
+		Key:         autoRequestPatternConfigKey,
+		Description: "The glob pattern of the pushed refs that the auto-request hook requests reviews of, in place of \"" + defaultAutoRequestPattern + "\".",
+		validate:    validateRefPattern,
+	},
+	{
+		Key:         autoRequestTargetConfigKey,
+		Description: "The target ref of the reviews requested by the auto-request hook, in place of " + requestTargetConfigKey + ".",
+	},
+	{
+		Key:         output.ColorConfigKey,
+		Description: "Whether to color the output: \"auto\" (only on a terminal), \"always\", or \"never\".",
+		validate:    validateOneOf(output.ColorAuto, output.ColorAlways, output.ColorNever),
+	},
+	{
+		Key:         review.LabelsConfigKey,
+		Description: "The labels (separated by commas or spaces) that reviews may be given; if unset, any label may be used.",
+		validate:    validateLabels,
+	},
+	{
+		Key:         review.LintForbiddenPrefixesConfigKey,
+		Description: "The prefixes (separated by commas or spaces, such as \"WIP fixup! squash!\") that the subjects of the commits of new reviews may not start with.",
+	},
+	{
+		Key:         review.LintMaxSubjectLengthConfigKey,
+		Description: "The maximum length of the subjects of the commits of new reviews.",
+		validate:    validateCount,
+	},
+	{
+		Key:         review.LintRecordConfigKey,
+		Description: "Record the results of checking the commit messages of new reviews as an analysis report.",
+		validate:    validateBool,
+	},
+	{
+		Key:         review.LintRequiredPatternConfigKey,
+		Description: "A regular expression (such as a ticket ID) that the messages of the commits of new reviews have to match.",
+		validate:    validateRegexp,
+	},
+	{
+		Key:         review.LintRequiredTrailersConfigKey,
+		Description: "The trailers (separated by commas or spaces, such as \"Bug\") that the messages of the commits of new reviews have to have.",
+	},
+	{
+		Key:         repository.NamespaceConfigKey,
+		Description: "The namespace of the notes refs holding the reviews, in place of \"devtools\".",
+		validate:    validateNamespace,
+	},
+	{
+		Key:         notifyEmailConfigKey,
+		Description: "The address that the notify command sends its emails to, in place of user.email.",
+		validate:    validateEmail,
+	},
+	{
+		Key:         review.RejectionCategoriesConfigKey,
+		Description: "The categories (separated by commas or spaces) that rejections may be tagged with; if unset, any category may be used.",
+		validate:    validateLabels,
+	},
+	{
+		Key:         syncRemotesConfigKey,
+		Description: "The remotes (separated by commas or spaces) for the sync command to use.",
+		validate:    validateRemotes,
+	},
+	{
+		Key:         requestTargetConfigKey,
+		Description: "The default target ref of new reviews, in place of \"refs/heads/master\".",
+	},
+	{
+		Key:         review.RequiredApprovalsConfigKey,
+		Description: "The number of reviewers who have to accept a review before it can be submitted.",
+		validate:    validateCount,
+	},
+	{
+		Key:         review.RequireSignaturesConfigKey,
+		Description: "Only submit reviews whose accepting comments have good signatures.",
+		validate:    validateBool,
+	},
+	{
+		Key:         review.RequireSignoffConfigKey,
+		Description: "Only submit reviews whose commits are all signed off by their authors, and warn about those that are not when requesting reviews.",
+		validate:    validateBool,
+	},
+	{
+		Key:         review.SignConfigKey,
+		Description: "Sign every request and comment written.",
+		validate:    validateBool,
+	},
+	{
+		Key:         submitStrategyConfigKey,
+		Description: "How the submit command submits reviews by default: \"merge\", \"rebase\", \"squash\", or \"ff\" (fast-forward).",
+		validate:    validateOneOf(submitStrategies...),
+	},
+}
+
+// validateRefPattern checks that the given value is a valid glob pattern.
+func validateRefPattern(repo repository.Repo, value string) error {
+	if _, err := path.Match(value, ""); err != nil {
+		return fmt.Errorf("Invalid ref pattern %q: %v", value, err)
+	}
+	return nil
+}
+
+// validateBool checks that the given value is one of the boolean values that git understands.
+func validateBool(repo repository.Repo, value string) error {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1", "false", "no", "off", "0":
+		return nil
+	}
+	return fmt.Errorf("Invalid boolean %q; expected \"true\" or \"false\".", value)
+}
+
+// validateOneOf returns a validation function that checks that a value is one of the given choices.
+func validateOneOf(choices ...string) func(repo repository.Repo, value string) error {
+	return func(repo repository.Repo, value string) error {
+		for _, choice := range choices {
+			if value == choice {
+				return nil
+			}
+		}
+		return fmt.Errorf("Invalid value %q; expected one of: %s", value, strings.Join(choices, ", "))
+	}
+}
+
+// validateCount checks that the given value is a non-negative number.
+func validateCount(repo repository.Repo, value string) error {
+	if count, err := strconv.Atoi(value); err != nil || count < 0 {
+		return fmt.Errorf("Invalid count %q; expected a number that is zero or more.", value)
+	}
+	return nil
+}
+
+// validateRegexp checks that the given value is a valid regular expression.
+func validateRegexp(repo repository.Repo, value string) error {
+	if _, err := regexp.Compile(value); err != nil {
+		return fmt.Errorf("Invalid regular expression %q: %v", value, err)
+	}
+	return nil
+}
+
+// validateEmail checks that the given value looks like an email address.
+func validateEmail(repo repository.Repo, value string) error {
+	if at := strings.Index(value, "@"); at <= 0 || at == len(value)-1 || strings.ContainsAny(value, " \t\n") {
+		return fmt.Errorf("Invalid email address %q.", value)
+	}
+	return nil
+}
+
+// validateLabels checks that the given value is a list of valid label names.
+func validateLabels(repo repository.Repo, value string) error {
+	for _, label := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		if err := review.ValidateLabelName(label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateNamespace checks that the given value can be used in the names of refs.
+func validateNamespace(repo repository.Repo, value string) error {
+	name := strings.Trim(strings.TrimPrefix(value, "refs/notes/"), "/")
+	if name == "" || strings.Contains(name, "..") || strings.Contains(name, "//") ||
+		strings.ContainsAny(name, " ~^:?*[\\") {
+		return fmt.Errorf("Invalid namespace %q; it must be usable as part of a ref name.", value)
+	}
+	return nil
+}
+
+// validateRemotes checks that every remote in the given list is configured.
+func validateRemotes(repo repository.Repo, value string) error {
+	remotes, err := repo.ListRemotes()
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool)
+	for _, remote := range remotes {
+		known[remote] = true
+	}
+	listed := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(listed) == 0 {
+		return errors.New("The list of remotes is empty.")
+	}
+	for _, remote := range listed {
+		if !known[remote] && len(remotes) == 0 {
+			return fmt.Errorf("Unknown remote %q; there are no remotes configured.", remote)
+		} else if !known[remote] {
+			return fmt.Errorf("Unknown remote %q; the configured remotes are: %s", remote, strings.Join(remotes, ", "))
+		}
+	}
+	return nil
+}
+
+// editDistance returns the Levenshtein distance between the two given strings.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+// findConfigSetting returns the known setting with the given key.
+//
+// Like git itself, this ignores the case of the key, and the "appraise." prefix may be
+// left out. If the key is unknown, then the error suggests the closest known key.
+func findConfigSetting(key string) (configSetting, error) {
+	normalized := strings.ToLower(key)
+	if !strings.HasPrefix(normalized, configKeyPrefix) {
+		normalized = configKeyPrefix + normalized
+	}
+	// The shared prefix is left out of the distances, so that it does not make unrelated keys look alike.
+	name := strings.TrimPrefix(normalized, configKeyPrefix)
+	var closest string
+	closestDistance := -1
+	for _, setting := range configSettings {
+		lowerKey := strings.ToLower(setting.Key)
+		if lowerKey == normalized {
+			return setting, nil
+		}
+		distance := editDistance(strings.TrimPrefix(lowerKey, configKeyPrefix), name)
+		if closestDistance < 0 || distance < closestDistance {
+			closest, closestDistance = setting.Key, distance
+		}
+	}
+	if closestDistance <= len(name)/3 {
+		return configSetting{}, fmt.Errorf("Unknown setting %q; did you mean %q?", key, closest)
+	}
+	return configSetting{}, fmt.Errorf("Unknown setting %q; run \"config list\" to see the known settings.", key)
+}
+
+// getConfig prints the values of a single setting, one per line.
+//
+// Those come from the git config, or else from the shared defaults in the repo.
+func getConfig(repo repository.Repo, key string) error {
+	setting, err := findConfigSetting(key)
+	if err != nil {
+		return err
+	}
+	values, err := repository.GetConfigWithDefaults(repo, setting.Key)
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("The %s setting is not set.", setting.Key)
+	}
+	for _, value := range values {
+		fmt.Println(value.Value)
+	}
+	return nil
+}
+
+// setConfig validates and then stores a new value for a single setting.
+func setConfig(repo repository.Repo, key, value string) error {
+	setting, err := findConfigSetting(key)
+	if err != nil {
+		return err
+	}
+	if setting.validate != nil {
+		if err := setting.validate(repo, value); err != nil {
+			return err
+		}
+	}
+	return repo.SetConfigValue(setting.Key, value)
+}
+
+// listConfig prints every known setting, along with its effective value, where that value
+// came from, and a description.
+func listConfig(repo repository.Repo) error {
+	for _, setting := range configSettings {
+		values, err := repository.GetConfigWithDefaults(repo, setting.Key)
+		if err != nil {
+			return err
+		}
+		value := "(not set)"
+		if len(values) > 0 {
+			var parts []string
+			for _, v := range values {
+				parts = append(parts, fmt.Sprintf("%s (%s)", v.Value, v.Origin))
+			}
+			value = strings.Join(parts, ", ")
+		}
+		fmt.Printf("%s=%s\n    %s\n", setting.Key, value, setting.Description)
+	}
+	return nil
+}
+
+// manageConfig reads or writes the git-appraise settings.
+func manageConfig(repo repository.Repo, args []string) error {
+	if err := configFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = configFlagSet.Args()
+	if *configList {
+		args = append([]string{"list"}, args...)
+	}
+	if len(args) == 0 {
+		return errors.New("The config command requires one of \"get\", \"set\", or \"list\".")
+	}
+	switch action, args := args[0], args[1:]; action {
+	case "get":
+		if len(args) != 1 {
+			return errors.New("The config get command requires a single setting.")
+		}
+		return getConfig(repo, args[0])
+	case "set":
+		if len(args) != 2 {
+			return errors.New("The config set command requires a setting and a value.")
+		}
+		return setConfig(repo, args[0], args[1])
+	case "list":
+		if len(args) != 0 {
+			return errors.New("The config list command does not take any arguments.")
+		}
+		return listConfig(repo)
+	default:
+		return fmt.Errorf("Unknown config action %q; expected one of \"get\", \"set\", or \"list\".", action)
+	}
+}
+
+// configCmd defines the "config" subcommand.
+var configCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s config get <setting>\n   or: %s config set <setting> <value>\n   or: %s config list\n\n"+
+			"Settings that are not in the git config are read from the %s file committed in the repo, if there is one.\n",
+			arg0, arg0, arg0, repository.SharedConfigPath)
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return manageConfig(repo, args)
+	},
+	Flags: configFlagSet,
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"strings"
+	"testing"
+)
+
+func TestFindConfigSetting(t *testing.T) {
+	for _, key := range []string{"appraise.sign", "Appraise.Sign", "sign"} {
+		if setting, err := findConfigSetting(key); err != nil || setting.Key != review.SignConfigKey {
+			t.Fatalf("Failed to find the setting %q: %+v, %v", key, setting, err)
+		}
+	}
+	if _, err := findConfigSetting("appraise.requireSignature"); err == nil || !strings.Contains(err.Error(), review.RequireSignaturesConfigKey) {
+		t.Fatalf("Unexpected error for a misspelled setting: %v", err)
+	}
+	if _, err := findConfigSetting("user.email"); err == nil || strings.Contains(err.Error(), "did you mean") {
+		t.Fatalf("Unexpected error for an unrelated setting: %v", err)
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	repo := repository.NewMemoryRepoForTest()
+	if err := setConfig(repo, "sign", "maybe"); err == nil {
+		t.Fatal("Unexpectedly set a boolean setting to a non-boolean value")
+	}
+	if err := setConfig(repo, "remotes", "origin"); err == nil {
+		t.Fatal("Unexpectedly set the remotes to an unknown remote")
+	}
+	if err := setConfig(repo, "namespace", "bad..name"); err == nil {
+		t.Fatal("Unexpectedly set an invalid namespace")
+	}
+	if err := setConfig(repo, "submit.strategy", "octopus"); err == nil {
+		t.Fatal("Unexpectedly set an unknown submit strategy")
+	}
+	if err := setConfig(repo, "requiredApprovals", "-1"); err == nil {
+		t.Fatal("Unexpectedly set a negative number of required approvals")
+	}
+	if err := setConfig(repo, "notifyEmail", "team"); err == nil {
+		t.Fatal("Unexpectedly set an invalid notification email")
+	}
+	if err := setConfig(repo, "sign", "true"); err != nil {
+		t.Fatal(err)
+	}
+	if !review.ShouldSign(repo) {
+		t.Fatal("The sign setting was not stored")
+	}
+}
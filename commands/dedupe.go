@@ -0,0 +1,123 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"strings"
+)
+
+var dedupeFlagSet = flag.NewFlagSet("dedupe", flag.ContinueOnError)
+
+var (
+	dedupeSimilarity = dedupeFlagSet.Float64("similarity", 0.9, "Fraction of the changed lines that the diffs of two reviews must share "+
+		"for them to be duplicates; above 1, only reviews with the same review ref or head commit are")
+	dedupeYes = dedupeFlagSet.Bool("yes", false, "Merge the duplicates without asking for confirmation")
+)
+
+// mergeDuplicates merges the duplicate reviews of the given group into its survivor, by
+// copying over their comments and CI reports and adding their reviewers, and then marks
+// them as superseded by it.
+func mergeDuplicates(repo repository.Repo, group review.DuplicateGroup) error {
+	survivor := group.Survivor
+	reviewers := append([]string(nil), survivor.Request.Reviewers...)
+	known := make(map[string]bool)
+	for _, reviewer := range reviewers {
+		known[reviewer] = true
+	}
+	for _, duplicate := range group.Duplicates {
+		copied, err := review.CopyDiscussion(repo, duplicate.Revision, survivor.Revision)
+		if err != nil {
+			return fmt.Errorf("Failed to copy the comments of review %.12s: %v", duplicate.Revision, err)
+		}
+		for _, reviewer := range duplicate.Request.Reviewers {
+			if !known[reviewer] {
+				known[reviewer] = true
+				reviewers = append(reviewers, reviewer)
+			}
+		}
+		abandoned := duplicate.Request
+		abandoned.SupersededBy = survivor.Revision
+		if err := writeUpdatedRequest(repo, &duplicate, abandoned); err != nil {
+			return err
+		}
+		fmt.Printf("Merged review %.12s into review %.12s, copying %d comment(s) and report(s)\n", duplicate.Revision, survivor.Revision, copied)
+	}
+	if len(reviewers) > len(survivor.Request.Reviewers) {
+		updatedRequest := survivor.Request
+		updatedRequest.Reviewers = reviewers
+		if err := writeUpdatedRequest(repo, &survivor, updatedRequest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dedupeReviews finds the open reviews that duplicate each other, and merges each set of them
+// into the most recently requested one.
+func dedupeReviews(repo repository.Repo, args []string) error {
+	if err := dedupeFlagSet.Parse(args); err != nil {
+		return err
+	}
+	if len(dedupeFlagSet.Args()) > 0 {
+		return errors.New("The dedupe command does not take any arguments.")
+	}
+	if *dedupeSimilarity <= 0 {
+		return errors.New("The --similarity flag must be greater than 0.")
+	}
+	groups := review.FindDuplicates(review.ListAllCached(repo, false), *dedupeSimilarity)
+	if len(groups) == 0 {
+		fmt.Println("No duplicate reviews found")
+		return nil
+	}
+	duplicates := 0
+	for _, group := range groups {
+		fmt.Printf("Review %.12s: %s\n", group.Survivor.Revision, firstLine(group.Survivor.Request.Description))
+		for _, duplicate := range group.Duplicates {
+			fmt.Printf("  duplicated by %.12s: %s\n", duplicate.Revision, firstLine(duplicate.Request.Description))
+		}
+		fmt.Printf("  (%s)\n", strings.Join(group.Reasons, "; "))
+		duplicates += len(group.Duplicates)
+	}
+	if !*dedupeYes && !confirm(fmt.Sprintf("Merge %d duplicate review(s) into the reviews that they duplicate?", duplicates)) {
+		return errors.New("Nothing was merged.")
+	}
+	for _, group := range groups {
+		if err := mergeDuplicates(repo, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dedupeCmd defines the "dedupe" subcommand.
+var dedupeCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s dedupe [<option>...]\n\n"+
+			"Finds the open reviews of the same change, and merges each set of them into the one\n"+
+			"requested most recently, abandoning the others.\n\nOptions:\n", arg0)
+		dedupeFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return dedupeReviews(repo, args)
+	},
+	Flags: dedupeFlagSet,
+}
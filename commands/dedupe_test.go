@@ -0,0 +1,97 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/ci"
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/reviewtest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDedupe(t *testing.T) {
+	defer func() {
+		*dedupeSimilarity, *dedupeYes = 0.9, false
+		confirmInput = os.Stdin
+	}()
+	repo := repository.NewRepoWithHistory(map[string][]string{"master": {"A"}})
+	repo.AddCommit("B", "Add the feature", map[string]string{"feature.go": "one\ntwo\n"}, "A")
+	repo.AddCommit("C", "Add the feature again", map[string]string{"feature.go": "one\ntwo\n"}, "A")
+	repo.AddCommit("D", "Something else", map[string]string{"other.go": "one\n"}, "A")
+	repo.SetRef("refs/heads/feature", "B")
+	repo.SetRef("refs/heads/retry", "C")
+	repo.SetRef("refs/heads/other", "D")
+	for ref, reviewer := range map[string]string{
+		"refs/heads/feature": "alice@example.com",
+		"refs/heads/retry":   "bob@example.com",
+		"refs/heads/other":   "carol@example.com",
+	} {
+		if _, err := reviewtest.AddReview(repo, ref, "refs/heads/master", reviewer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := reviewtest.AddComment(repo, "B", comment.Comment{Description: "Please add a test"}); err != nil {
+		t.Fatal(err)
+	}
+	report, err := ci.Report{URL: "https://ci.example.com/1", Status: ci.StatusSuccess, Agent: "ci"}.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AppendNote(ci.Ref, "B", report); err != nil {
+		t.Fatal(err)
+	}
+
+	confirmInput = strings.NewReader("n\n")
+	if err := dedupeReviews(repo, nil); err == nil {
+		t.Fatal("Unexpectedly merged the duplicates without confirmation")
+	}
+	if r, err := review.Get(repo, "B"); err != nil || !r.IsOpen() {
+		t.Fatalf("The duplicate was abandoned without confirmation: %+v, %v", r, err)
+	}
+
+	if err := dedupeReviews(repo, []string{"-yes"}); err != nil {
+		t.Fatal(err)
+	}
+	duplicate, err := review.Get(repo, "B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if duplicate.IsOpen() || duplicate.Request.SupersededBy != "C" {
+		t.Errorf("The duplicate was not abandoned: %+v", duplicate.Request)
+	}
+	survivor, err := review.Get(repo, "C")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !survivor.IsOpen() || findThread(survivor.Comments, "Please add a test") == nil || len(survivor.Reports) != 1 {
+		t.Errorf("The discussion was not merged into the survivor: %+v", survivor)
+	}
+	if expected := []string{"bob@example.com", "alice@example.com"}; !reflect.DeepEqual(survivor.Request.Reviewers, expected) {
+		t.Errorf("Unexpected reviewers of the survivor: got %q, want %q", survivor.Request.Reviewers, expected)
+	}
+	if other, err := review.Get(repo, "D"); err != nil || !other.IsOpen() {
+		t.Errorf("Abandoned a review that is not a duplicate: %+v, %v", other, err)
+	}
+	if open := review.ListOpen(repo); len(open) != 2 {
+		t.Errorf("Unexpected open reviews after merging the duplicates: %+v", open)
+	}
+}
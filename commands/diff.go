@@ -0,0 +1,146 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/commands/output"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"os"
+	"strings"
+)
+
+var diffFlagSet = flag.NewFlagSet("diff", flag.ContinueOnError)
+
+var (
+	diffBetween        = diffFlagSet.String("between", "", "Two versions of the review to compare, as <revA>..<revB>; if <revB> is omitted, the current version is used")
+	diffSinceMyComment = diffFlagSet.Bool("since-my-comment", false, "Compare the version of the review that you last commented on with the current version")
+	diffIterations     = diffFlagSet.Bool("iterations", false, "List the versions of the review that have been commented upon, instead of showing a diff")
+	diffOptions        = diffFlagSet.String("diff-opts", "", "Options to pass to the diff tool")
+	diffColor          = colorFlag(diffFlagSet)
+)
+
+// parseBetween returns the two commits named by the given <revA>..<revB> range, using
+// the given head commit if <revB> is omitted.
+func parseBetween(repo repository.Repo, between, head string) (string, string, error) {
+	parts := strings.SplitN(between, "..", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("The --between flag must be of the form <revA>..<revB>, but was %q.", between)
+	}
+	from, err := repo.GetCommitHash(parts[0])
+	if err != nil {
+		return "", "", fmt.Errorf("Unknown revision %q: %v", parts[0], err)
+	}
+	to := head
+	if parts[1] != "" {
+		to, err = repo.GetCommitHash(parts[1])
+		if err != nil {
+			return "", "", fmt.Errorf("Unknown revision %q: %v", parts[1], err)
+		}
+	}
+	return from, to, nil
+}
+
+// diffReview prints the changes between two versions of a review.
+func diffReview(repo repository.Repo, args []string) error {
+	if err := diffFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = diffFlagSet.Args()
+	if countTrue(*diffBetween != "", *diffSinceMyComment, *diffIterations) > 1 {
+		return errors.New("Only one of --between, --since-my-comment, or --iterations is allowed.")
+	}
+	if countTrue(*diffBetween != "", *diffSinceMyComment) == 0 && !*diffIterations {
+		return errors.New("One of --between, --since-my-comment, or --iterations is required.")
+	}
+	if err := setupColor(repo, *diffColor); err != nil {
+		return err
+	}
+
+	var r *review.Review
+	var err error
+	if len(args) > 1 {
+		return errors.New("Only diffing a single review is supported.")
+	}
+	if len(args) == 1 {
+		r, err = review.Get(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %v\n", err)
+	}
+	if r == nil {
+		return noMatchingReview(args)
+	}
+	if *diffIterations {
+		iterations, err := r.GetIterations()
+		if err != nil {
+			return err
+		}
+		output.PrintIterations(iterations)
+		return nil
+	}
+
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	var from, to string
+	if *diffSinceMyComment {
+		userEmail, err := repo.GetUserEmail()
+		if err != nil {
+			return err
+		}
+		from, to = r.GetLastSnapshot(userEmail), head
+		if from == "" {
+			return fmt.Errorf("You have not commented on review %.12s; run \"git appraise show --diff\" to see all of it.", r.Revision)
+		}
+		if from == to {
+			fmt.Fprintf(os.Stderr, "Review %.12s has not changed since your last comment.\n", r.Revision)
+			return nil
+		}
+	} else if from, to, err = parseBetween(repo, *diffBetween, head); err != nil {
+		return err
+	}
+
+	var diffArgs []string
+	if *diffOptions != "" {
+		diffArgs = strings.Split(*diffOptions, ",")
+	}
+	diff, err := repo.Diff(from, to, review.WithSubmoduleLog(diffArgs)...)
+	if err != nil {
+		return err
+	}
+	output.PrintRawDiff(diff)
+	return nil
+}
+
+// diffCmd defines the "diff" subcommand.
+var diffCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s diff (--between=<revA>..[<revB>] | --since-my-comment | --iterations) [<option>...] [<review-hash>]\n\nOptions:\n", arg0)
+		diffFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return diffReview(repo, args)
+	},
+	Flags: diffFlagSet,
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"io/ioutil"
+	"strings"
+)
+
+var exportFlagSet = flag.NewFlagSet("export", flag.ContinueOnError)
+
+var (
+	exportPHIDMap = exportFlagSet.String("phid-map", "", "JSON file mapping reviewer emails to Phabricator user PHIDs")
+)
+
+// arcanistInline is a comment on a single line of a file, in the form taken by the
+// "differential.createinline" Conduit method.
+type arcanistInline struct {
+	ID          string `json:"id"`
+	ReplyTo     string `json:"replyTo,omitempty"`
+	FilePath    string `json:"filePath"`
+	IsNewFile   bool   `json:"isNewFile"`
+	LineNumber  uint32 `json:"lineNumber"`
+	LineLength  uint32 `json:"lineLength"`
+	Content     string `json:"content"`
+	Author      string `json:"author"`
+	DateCreated int64  `json:"dateCreated"`
+}
+
+// arcanistComment is a comment on the whole revision, or on a whole file, in the form
+// taken by the "differential.createcomment" Conduit method.
+type arcanistComment struct {
+	ID          string `json:"id"`
+	ReplyTo     string `json:"replyTo,omitempty"`
+	FilePath    string `json:"filePath,omitempty"`
+	Message     string `json:"message"`
+	Author      string `json:"author"`
+	DateCreated int64  `json:"dateCreated"`
+}
+
+// arcanistRevision is a review, exported as a Differential revision.
+//
+// The diff is in the form taken by "differential.createrawdiff", and the title, summary,
+// and reviewers are the fields set by "differential.revision.edit".
+type arcanistRevision struct {
+	Title      string            `json:"title"`
+	Summary    string            `json:"summary"`
+	Author     string            `json:"author"`
+	Reviewers  []string          `json:"reviewers"`
+	Branch     string            `json:"branch,omitempty"`
+	BaseCommit string            `json:"sourceControlBaseRevision"`
+	HeadCommit string            `json:"headCommit"`
+	Diff       string            `json:"diff"`
+	Inlines    []arcanistInline  `json:"inlines"`
+	Comments   []arcanistComment `json:"comments"`
+}
+
+// readPHIDMap reads the JSON object at the given path, which maps emails to PHIDs.
+func readPHIDMap(path string) (map[string]string, error) {
+	mapBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var phids map[string]string
+	if err := json.Unmarshal(mapBytes, &phids); err != nil {
+		return nil, fmt.Errorf("Failed to parse the PHID map %q: %v", path, err)
+	}
+	return phids, nil
+}
+
+// exportDateCreated converts a timestamp stored in a git note to seconds since the epoch,
+// which is how Conduit represents dates.
+func exportDateCreated(timestamp string) int64 {
+	t, err := parseTimestamp(timestamp)
+	if err != nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+// addArcanistThread adds the comments in the given thread to the given revision.
+//
+// Replies do not have locations of their own, so they are exported with the location of the
+// comment that started their thread. Comments on the review's base commit are on the old side
+// of the diff, and all others are on the new side.
+func addArcanistThread(revision *arcanistRevision, thread review.CommentThread, replyTo string, location *arcanistInline) {
+	c := thread.Comment
+	if location == nil && c.Location != nil && c.Location.Path != "" && c.Location.Range != nil {
+		location = &arcanistInline{
+			FilePath:   c.Location.Path,
+			IsNewFile:  c.Location.Commit != revision.BaseCommit,
+			LineNumber: c.Location.Range.StartLine,
+		}
+	}
+	if location != nil {
+		inline := *location
+		inline.ID = thread.Hash
+		inline.ReplyTo = replyTo
+		inline.Content = c.Description
+		inline.Author = c.Author
+		inline.DateCreated = exportDateCreated(c.Timestamp)
+		revision.Inlines = append(revision.Inlines, inline)
+	} else {
+		comment := arcanistComment{
+			ID:          thread.Hash,
+			ReplyTo:     replyTo,
+			Message:     c.Description,
+			Author:      c.Author,
+			DateCreated: exportDateCreated(c.Timestamp),
+		}
+		if c.Location != nil {
+			comment.FilePath = c.Location.Path
+		}
+		revision.Comments = append(revision.Comments, comment)
+	}
+	for _, child := range thread.Children {
+		addArcanistThread(revision, child, thread.Hash, location)
+	}
+}
+
+// exportArcanist builds the Differential revision for the given review.
+//
+// If phids is non-nil, then the reviewers are exported as the PHIDs that it maps their
+// emails to, and every reviewer must have one. Otherwise, their emails are exported as-is.
+func exportArcanist(r *review.Review, phids map[string]string) (*arcanistRevision, error) {
+	baseCommit, err := r.GetBaseCommit()
+	if err != nil {
+		return nil, err
+	}
+	headCommit, err := r.GetHeadCommit()
+	if err != nil {
+		return nil, err
+	}
+	diff, err := r.Repo.Diff(baseCommit, headCommit)
+	if err != nil {
+		return nil, err
+	}
+	// Patch tools expect the diff to end with a newline, which the repo trims off.
+	if diff != "" && !strings.HasSuffix(diff, "\n") {
+		diff += "\n"
+	}
+	description := strings.SplitN(strings.TrimSpace(r.Request.Description), "\n", 2)
+	revision := &arcanistRevision{
+		Title:      description[0],
+		Author:     r.Request.Requester,
+		Reviewers:  []string{},
+		Branch:     strings.TrimPrefix(r.Request.ReviewRef, "refs/heads/"),
+		BaseCommit: baseCommit,
+		HeadCommit: headCommit,
+		Diff:       diff,
+		Inlines:    []arcanistInline{},
+		Comments:   []arcanistComment{},
+	}
+	if len(description) > 1 {
+		revision.Summary = strings.TrimSpace(description[1])
+	}
+	for _, reviewer := range r.Request.Reviewers {
+		if phids == nil {
+			revision.Reviewers = append(revision.Reviewers, reviewer)
+			continue
+		}
+		phid, ok := phids[reviewer]
+		if !ok {
+			return nil, fmt.Errorf("The PHID map does not have an entry for the reviewer %q.", reviewer)
+		}
+		revision.Reviewers = append(revision.Reviewers, phid)
+	}
+	for _, thread := range r.Comments {
+		addArcanistThread(revision, thread, "", nil)
+	}
+	return revision, nil
+}
+
+// exportReview prints a review in a format that other code review tools can import.
+func exportReview(repo repository.Repo, args []string) error {
+	if err := exportFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = exportFlagSet.Args()
+	if len(args) == 0 {
+		return errors.New("The export command requires a format; the only supported one is \"arcanist\".")
+	}
+	if args[0] != "arcanist" {
+		return fmt.Errorf("Unknown export format %q; the only supported one is \"arcanist\".", args[0])
+	}
+	// Flags may also follow the format.
+	if err := exportFlagSet.Parse(args[1:]); err != nil {
+		return err
+	}
+	args = exportFlagSet.Args()
+	if len(args) > 1 {
+		return errors.New("Only exporting a single review is supported.")
+	}
+
+	var phids map[string]string
+	if *exportPHIDMap != "" {
+		var err error
+		if phids, err = readPHIDMap(*exportPHIDMap); err != nil {
+			return err
+		}
+	}
+	var r *review.Review
+	var err error
+	if len(args) == 1 {
+		r, err = review.Get(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %v\n", err)
+	}
+	if r == nil {
+		return noMatchingReview(args)
+	}
+	revision, err := exportArcanist(r, phids)
+	if err != nil {
+		return err
+	}
+	jsonBytes, err := json.MarshalIndent(revision, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(jsonBytes))
+	return nil
+}
+
+// exportCmd defines the "export" subcommand.
+var exportCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s export arcanist [<option>...] [<review-hash>]\n\nOptions:\n", arg0)
+		exportFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return exportReview(repo, args)
+	},
+	Flags: exportFlagSet,
+}
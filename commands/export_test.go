@@ -0,0 +1,91 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/comment"
+	"reflect"
+	"testing"
+)
+
+func TestExportArcanist(t *testing.T) {
+	repo := repository.NewMemoryRepoForTest()
+	r, err := review.Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Request.Description = "Add a feature\n\nIt is a useful feature."
+	r.Request.Reviewers = []string{"reviewer@example.com"}
+	baseCommit, err := r.GetBaseCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Comments = []review.CommentThread{
+		{
+			Hash: "inline",
+			Comment: comment.Comment{Timestamp: "0000000010", Author: "reviewer@example.com", Description: "Rename this.",
+				Location: &comment.Location{Commit: repository.TestCommitG, Path: "a.go", Range: &comment.Range{StartLine: 3}}},
+			Children: []review.CommentThread{{
+				Hash:    "reply",
+				Comment: comment.Comment{Timestamp: "0000000011", Author: "requester@example.com", Description: "Done."},
+			}},
+		},
+		{
+			Hash: "old",
+			Comment: comment.Comment{Timestamp: "0000000012", Author: "reviewer@example.com", Description: "Why was this removed?",
+				Location: &comment.Location{Commit: baseCommit, Path: "b.go", Range: &comment.Range{StartLine: 7}}},
+		},
+		{
+			Hash:    "general",
+			Comment: comment.Comment{Timestamp: "0000000013", Author: "reviewer@example.com", Description: "LGTM"},
+		},
+	}
+
+	revision, err := exportArcanist(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision.Title != "Add a feature" || revision.Summary != "It is a useful feature." || revision.BaseCommit != baseCommit ||
+		!reflect.DeepEqual(revision.Reviewers, []string{"reviewer@example.com"}) || revision.Diff == "" {
+		t.Fatalf("Unexpected revision: %+v", revision)
+	}
+	expectedInlines := []arcanistInline{
+		{ID: "inline", FilePath: "a.go", IsNewFile: true, LineNumber: 3, Content: "Rename this.", Author: "reviewer@example.com", DateCreated: 10},
+		{ID: "reply", ReplyTo: "inline", FilePath: "a.go", IsNewFile: true, LineNumber: 3, Content: "Done.", Author: "requester@example.com", DateCreated: 11},
+		{ID: "old", FilePath: "b.go", LineNumber: 7, Content: "Why was this removed?", Author: "reviewer@example.com", DateCreated: 12},
+	}
+	if !reflect.DeepEqual(revision.Inlines, expectedInlines) {
+		t.Fatalf("Unexpected inline comments: got %+v, want %+v", revision.Inlines, expectedInlines)
+	}
+	expectedComments := []arcanistComment{{ID: "general", Message: "LGTM", Author: "reviewer@example.com", DateCreated: 13}}
+	if !reflect.DeepEqual(revision.Comments, expectedComments) {
+		t.Fatalf("Unexpected comments: got %+v, want %+v", revision.Comments, expectedComments)
+	}
+
+	revision, err = exportArcanist(r, map[string]string{"reviewer@example.com": "PHID-USER-1234"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(revision.Reviewers, []string{"PHID-USER-1234"}) {
+		t.Fatalf("Unexpected mapped reviewers: %v", revision.Reviewers)
+	}
+	if _, err := exportArcanist(r, map[string]string{}); err == nil {
+		t.Fatal("Unexpectedly exported a reviewer missing from the PHID map")
+	}
+}
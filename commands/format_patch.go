@@ -0,0 +1,184 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"path/filepath"
+	"strings"
+)
+
+var formatPatchFlagSet = flag.NewFlagSet("format-patch", flag.ContinueOnError)
+
+var (
+	formatPatchOutput = formatPatchFlagSet.String("o", ".", "Directory to write the patches to")
+	formatPatchForce  = formatPatchFlagSet.Bool("force", false, "Export the review even if it has not been accepted")
+	formatPatchLink   = formatPatchFlagSet.String("link-prefix", "git-appraise:", "Prefix of the review hash in the Link: trailer, such as the URL of a page showing the review")
+)
+
+// checkExportable returns an error unless the given review has been accepted, by as many
+// reviewers as submitting it would require.
+func checkExportable(repo repository.Repo, r *review.Review) error {
+	if r.Resolved == nil || !*r.Resolved {
+		return CommandError{
+			Err:      fmt.Errorf("Not exporting review %.12s, as it has not been accepted", r.Revision),
+			Guidance: "Ask a reviewer to run \"git appraise accept\", or pass --force to export it anyway.",
+			ExitCode: ExitPreconditionFailed,
+		}
+	}
+	if required := review.RequiredApprovals(repo); r.CountApprovals() < required {
+		return CommandError{
+			Err: fmt.Errorf("Not exporting review %.12s, as it has been accepted by %d reviewer(s), but the %s setting requires %d",
+				r.Revision, r.CountApprovals(), review.RequiredApprovalsConfigKey, required),
+			Guidance: "Ask more reviewers to run \"git appraise accept\", or pass --force to export it anyway.",
+			ExitCode: ExitPreconditionFailed,
+		}
+	}
+	return nil
+}
+
+// getReviewedBy returns the reviewers whose latest vote accepts the review, other than its requester.
+func getReviewedBy(r *review.Review) []string {
+	var reviewers []string
+	for _, signOff := range r.GetSignOffs() {
+		if signOff.Status == review.SignOffAccepted && signOff.Reviewer != r.Request.Requester {
+			reviewers = append(reviewers, signOff.Reviewer)
+		}
+	}
+	return reviewers
+}
+
+// countUnresolvedThreads returns the number of comment threads of the review that have unaddressed comments.
+func countUnresolvedThreads(r *review.Review) int {
+	count := 0
+	for _, thread := range r.Comments {
+		if thread.IsUnresolved() {
+			count++
+		}
+	}
+	return count
+}
+
+// buildCoverLetter returns the cover letter of the patch series exported from the given review,
+// whose first line is the subject. The link is also added, so that replies to the cover letter
+// can be imported with "import-mail".
+func buildCoverLetter(r *review.Review, reviewedBy []string, revision, link string) string {
+	description := strings.TrimSpace(r.Request.Description)
+	if description == "" {
+		description = fmt.Sprintf("Review %.12s", revision)
+	}
+	lines := []string{description, ""}
+	if unresolved := countUnresolvedThreads(r); unresolved > 0 {
+		lines = append(lines, fmt.Sprintf("WARNING: %d comment thread(s) of the review are still unresolved.", unresolved), "")
+	}
+	lines = append(lines, fmt.Sprintf("This series was reviewed with git-appraise, as review %s.", revision))
+	lines = append(lines, "Requested-by: "+r.Request.Requester)
+	for _, reviewer := range reviewedBy {
+		lines = append(lines, "Reviewed-by: "+reviewer)
+	}
+	lines = append(lines, "Link: "+link)
+	return strings.Join(lines, "\n")
+}
+
+// formatPatch writes a review's commits to a directory as a series of email patches.
+func formatPatch(repo repository.Repo, args []string) error {
+	if err := formatPatchFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = formatPatchFlagSet.Args()
+	if len(args) > 1 {
+		// Allow the review hash to come before the flags, as in "format-patch <review-hash> -o <dir>".
+		revision := args[0]
+		if err := formatPatchFlagSet.Parse(args[1:]); err != nil {
+			return err
+		}
+		args = append([]string{revision}, formatPatchFlagSet.Args()...)
+	}
+	if len(args) > 1 {
+		return errors.New("Only exporting a single review is supported.")
+	}
+
+	var r *review.Review
+	var err error
+	if len(args) == 1 {
+		r, err = review.Get(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %v\n", err)
+	}
+	if r == nil {
+		return noMatchingReview(args)
+	}
+	if !*formatPatchForce {
+		if err := checkExportable(repo, r); err != nil {
+			return err
+		}
+	}
+
+	revision, err := repo.GetCommitHash(r.Revision)
+	if err != nil {
+		return err
+	}
+	baseCommit, err := r.GetBaseCommit()
+	if err != nil {
+		return err
+	}
+	headCommit, err := r.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	outputDir, err := filepath.Abs(*formatPatchOutput)
+	if err != nil {
+		return err
+	}
+	reviewedBy := getReviewedBy(r)
+	var trailers []string
+	for _, reviewer := range reviewedBy {
+		trailers = append(trailers, "Reviewed-by: "+reviewer)
+	}
+	link := *formatPatchLink + revision
+	trailers = append(trailers, "Link: "+link)
+	paths, err := repo.FormatPatch(baseCommit, headCommit, outputDir, trailers, buildCoverLetter(r, reviewedBy, revision, link))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("Review %.12s has no commits to export.", revision)
+	}
+	for _, path := range paths {
+		fmt.Println(path)
+	}
+	return nil
+}
+
+// formatPatchCmd defines the "format-patch" subcommand.
+var formatPatchCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s format-patch [<option>...] [<review-hash>]\n\nOptions:\n", arg0)
+		formatPatchFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return formatPatch(repo, args)
+	},
+	Flags: formatPatchFlagSet,
+}
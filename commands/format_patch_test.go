@@ -0,0 +1,114 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatPatch(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("The git command line tool is not installed")
+	}
+	defer func() { *formatPatchForce, *formatPatchOutput, *commentNmw = false, ".", false }()
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "config", "user.email", "user@example.com")
+	runGit(t, dir, "config", "user.name", "Test User")
+	runGit(t, dir, "checkout", "-q", "-b", "release")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "First commit")
+	runGit(t, dir, "checkout", "-q", "-b", "feature")
+	if err := ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("Feature\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, dir, "add", "file.txt")
+	runGit(t, dir, "commit", "-q", "-m", "Add the feature")
+
+	repo, err := repository.NewGitRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := requestReview(repo, []string{"-quiet", "-m", "Feature\n\nAdds a feature.", "-r", "", "-target", "refs/heads/release"}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.GetCurrent(repo)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	revision := r.Revision
+
+	patchDir := filepath.Join(dir, "patches")
+	err = formatPatch(repo, []string{revision, "-o", patchDir})
+	if code := ExitCode(err); err == nil || code != ExitPreconditionFailed {
+		t.Fatalf("Unexpected result of exporting a review that is not accepted: %v (exit code %d)", err, code)
+	}
+
+	runGit(t, dir, "config", "user.email", "reviewer@example.com")
+	if err := acceptReview(repo, []string{"-m", "LGTM", revision}); err != nil {
+		t.Fatal(err)
+	}
+	if err := formatPatch(repo, []string{revision, "-o", patchDir}); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string][]string{
+		"0000-cover-letter.patch":    {"Subject: [PATCH 0/1] Feature", "Adds a feature.", "Reviewed-by: reviewer@example.com"},
+		"0001-Add-the-feature.patch": {"\nReviewed-by: reviewer@example.com\nLink: git-appraise:" + revision + "\n"},
+	} {
+		contents, err := ioutil.ReadFile(filepath.Join(patchDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, substring := range expected {
+			if !strings.Contains(string(contents), substring) {
+				t.Errorf("The patch %q does not contain %q:\n%s", name, substring, contents)
+			}
+		}
+		if strings.Contains(string(contents), "WARNING") {
+			t.Errorf("The patch %q warns about unresolved comments:\n%s", name, contents)
+		}
+	}
+
+	runGit(t, dir, "config", "user.email", "other@example.com")
+	if err := commentOnReview(repo, []string{"-nmw", "-m", "Needs tests", revision}); err != nil {
+		t.Fatal(err)
+	}
+	if err := formatPatch(repo, []string{revision, "-o", patchDir}); err == nil {
+		t.Fatal("Unexpectedly exported a rejected review")
+	}
+	forcedDir := filepath.Join(dir, "forced")
+	if err := formatPatch(repo, []string{revision, "-o", forcedDir, "-force"}); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(forcedDir, "0000-cover-letter.patch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(contents), "WARNING: 1 comment thread(s)") {
+		t.Fatalf("The cover letter does not warn about the unresolved comment:\n%s", contents)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/schema"
+	"path"
+	"sort"
+	"strings"
+)
+
+// quarantineRefPrefix is the prefix of the notes refs that hold malformed notes removed by "fsck -fix".
+//
+// This is outside of the review data namespace, so quarantined notes are neither read nor pushed.
+const quarantineRefPrefix = "refs/notes/appraise-quarantine/"
+
+var fsckFlagSet = flag.NewFlagSet("fsck", flag.ContinueOnError)
+
+var (
+	fsckFix = fsckFlagSet.Bool("fix", false, "Move malformed notes into a quarantine ref")
+)
+
+// malformedNote describes a note blob containing lines that cannot be parsed.
+type malformedNote struct {
+	ref    string
+	object string
+	blob   string
+	valid  []repository.Note
+	bad    []repository.Note
+}
+
+// findMalformedNotes scans the given notes ref for note blobs containing lines that cannot be parsed.
+func findMalformedNotes(repo repository.Repo, ref string) ([]malformedNote, error) {
+	blobs, err := repo.ListNotes(ref)
+	if err != nil {
+		return nil, err
+	}
+	var objects []string
+	for object := range blobs {
+		objects = append(objects, object)
+	}
+	sort.Strings(objects)
+	var malformed []malformedNote
+	for _, object := range objects {
+		note := malformedNote{ref: ref, object: object, blob: blobs[object]}
+		for _, line := range repo.GetNotes(ref, object) {
+			if schema.CheckWellFormed(line) != nil {
+				note.bad = append(note.bad, line)
+			} else if strings.TrimSpace(string(line)) != "" {
+				note.valid = append(note.valid, line)
+			}
+		}
+		if len(note.bad) > 0 {
+			malformed = append(malformed, note)
+		}
+	}
+	return malformed, nil
+}
+
+// quarantine moves the malformed lines of a note into the quarantine ref, keeping the valid ones.
+func quarantine(repo repository.Repo, note malformedNote) error {
+	quarantineRef := quarantineRefPrefix + strings.TrimPrefix(note.ref, "refs/notes/")
+	for _, line := range note.bad {
+		if err := repo.AppendNote(quarantineRef, note.object, line); err != nil {
+			return err
+		}
+	}
+	return repo.SetNotes(note.ref, note.object, note.valid)
+}
+
+// fsckNotes checks every review data notes ref for malformed notes.
+func fsckNotes(repo repository.Repo, args []string) error {
+	if err := fsckFlagSet.Parse(args); err != nil {
+		return err
+	}
+	if len(fsckFlagSet.Args()) > 0 {
+		return errors.New("The fsck command does not take any arguments.")
+	}
+	refs, err := repo.ListNotesRefs(notesRefPattern)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, ref := range refs {
+		if path.Base(ref) == path.Base(comment.AttachmentsRef) {
+			// Attachments are stored as raw file contents rather than as JSON.
+			continue
+		}
+		malformed, err := findMalformedNotes(repo, ref)
+		if err != nil {
+			return err
+		}
+		for _, note := range malformed {
+			count++
+			fmt.Printf("%s: note blob %s on %s has %d malformed line(s)\n", note.ref, note.blob, note.object, len(note.bad))
+			if *fsckFix {
+				if err := quarantine(repo, note); err != nil {
+					return fmt.Errorf("Failed to quarantine the note on %s: %v", note.object, err)
+				}
+			}
+		}
+	}
+	if count == 0 {
+		fmt.Println("No malformed notes found.")
+		return nil
+	}
+	if *fsckFix {
+		fmt.Printf("Moved the malformed lines of %d note(s) under %q.\n", count, quarantineRefPrefix)
+		return nil
+	}
+	return fmt.Errorf("Found %d malformed note(s); run with -fix to quarantine them.", count)
+}
+
+// fsckCmd defines the "fsck" subcommand.
+var fsckCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s fsck [<option>...]\n\nOptions:\n", arg0)
+		fsckFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return fsckNotes(repo, args)
+	},
+	Flags: fsckFlagSet,
+}
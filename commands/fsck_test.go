@@ -0,0 +1,60 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"testing"
+)
+
+const testTruncatedNote = `{"timestamp": "0000000005", "author": "ojarjur", "descr`
+
+func TestQuarantineMalformedNotes(t *testing.T) {
+	repo := repository.NewMemoryRepoForTest()
+	if err := repo.AppendNote(repository.TestCommentsRef, repository.TestCommitB, repository.Note(testTruncatedNote)); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.Get(repo, repository.TestCommitB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Comments) != 1 || len(r.NoteErrors) != 1 || r.NoteErrors[0].Note != testTruncatedNote {
+		t.Fatalf("Unexpected review with a malformed note: comments %v, errors %v", r.Comments, r.NoteErrors)
+	}
+
+	malformed, err := findMalformedNotes(repo, repository.TestCommentsRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(malformed) != 1 || malformed[0].object != repository.TestCommitB || len(malformed[0].bad) != 1 {
+		t.Fatalf("Unexpected malformed notes: %+v", malformed)
+	}
+	if err := quarantine(repo, malformed[0]); err != nil {
+		t.Fatal(err)
+	}
+	if malformed, err := findMalformedNotes(repo, repository.TestCommentsRef); err != nil || len(malformed) != 0 {
+		t.Fatalf("Malformed notes remain after quarantining them: %+v, %v", malformed, err)
+	}
+	quarantined := repo.GetNotes(quarantineRefPrefix+"devtools/discuss", repository.TestCommitB)
+	if len(quarantined) != 1 || string(quarantined[0]) != testTruncatedNote {
+		t.Fatalf("Unexpected quarantined notes: %q", quarantined)
+	}
+	if r, err := review.Get(repo, repository.TestCommitB); err != nil || len(r.Comments) != 1 || len(r.NoteErrors) != 0 {
+		t.Fatalf("Unexpected review after quarantining its malformed note: %v, %v", r, err)
+	}
+}
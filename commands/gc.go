@@ -0,0 +1,220 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/analyses"
+	"github.com/google/git-appraise/review/ci"
+	"github.com/google/git-appraise/review/comment"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gcBackupRefPrefix is the prefix of the refs that hold the notes as they were before "gc" rewrote them.
+//
+// This is outside of the review data namespace, so backups are neither read nor pushed.
+const gcBackupRefPrefix = "refs/appraise-backup/"
+
+// gcBackupRef returns the ref under which the given notes ref is saved before being rewritten at the given time.
+func gcBackupRef(now time.Time, notesRef string) string {
+	return fmt.Sprintf("%s%d/%s", gcBackupRefPrefix, now.Unix(), strings.TrimPrefix(notesRef, "refs/notes/"))
+}
+
+var gcFlagSet = flag.NewFlagSet("gc", flag.ContinueOnError)
+
+var (
+	gcDryRun = gcFlagSet.Bool("dry-run", false, "Report the notes that would be removed, without removing them")
+	gcMaxAge = gcFlagSet.Duration("max-age", 30*24*time.Hour, "Remove superseded CI and analysis reports older than this")
+)
+
+// gcRemoval describes a single note that "gc" removes.
+type gcRemoval struct {
+	object string
+	note   repository.Note
+	reason string
+}
+
+// robotReport holds the fields shared by the reports that tools write into the CI and analyses refs.
+type robotReport struct {
+	Timestamp string `json:"timestamp"`
+	Agent     string `json:"agent"`
+}
+
+// parseRobotReport returns the agent and timestamp of the given report, or false if it has no valid timestamp.
+func parseRobotReport(note repository.Note) (string, int64, bool) {
+	var report robotReport
+	if err := json.Unmarshal([]byte(note), &report); err != nil {
+		return "", 0, false
+	}
+	timestamp, err := strconv.ParseInt(report.Timestamp, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return report.Agent, timestamp, true
+}
+
+// compactNote returns which of the notes annotating an object under the given ref to keep, and which to remove.
+//
+// Blank lines and exact duplicates are always removed. In the CI and analyses refs, a report is also
+// removed if it is older than the given cutoff and the same agent has reported on the object since.
+func compactNote(ref, object string, notes []repository.Note, cutoff time.Time) ([]repository.Note, []gcRemoval) {
+	var kept []repository.Note
+	var removed []gcRemoval
+	seen := make(map[string]bool)
+	for _, note := range notes {
+		if strings.TrimSpace(string(note)) == "" {
+			continue
+		}
+		if seen[string(note)] {
+			removed = append(removed, gcRemoval{object, note, "duplicate"})
+			continue
+		}
+		seen[string(note)] = true
+		kept = append(kept, note)
+	}
+	if path.Base(ref) != path.Base(ci.Ref) && path.Base(ref) != path.Base(analyses.Ref) {
+		return kept, removed
+	}
+	latest := make(map[string]int64)
+	for _, note := range kept {
+		if agent, timestamp, ok := parseRobotReport(note); ok && timestamp > latest[agent] {
+			latest[agent] = timestamp
+		}
+	}
+	var current []repository.Note
+	for _, note := range kept {
+		agent, timestamp, ok := parseRobotReport(note)
+		if ok && timestamp < latest[agent] && timestamp < cutoff.Unix() {
+			removed = append(removed, gcRemoval{object, note, "superseded"})
+			continue
+		}
+		current = append(current, note)
+	}
+	return current, removed
+}
+
+// findRemovals returns the notes under the given ref that "gc" would remove.
+func findRemovals(repo repository.Repo, ref string, cutoff time.Time) ([]gcRemoval, error) {
+	blobs, err := repo.ListNotes(ref)
+	if err != nil {
+		return nil, err
+	}
+	var objects []string
+	for object := range blobs {
+		objects = append(objects, object)
+	}
+	sort.Strings(objects)
+	var removed []gcRemoval
+	for _, object := range objects {
+		_, objectRemoved := compactNote(ref, object, repo.GetNotes(ref, object), cutoff)
+		removed = append(removed, objectRemoved...)
+	}
+	return removed, nil
+}
+
+// compactRef rewrites the notes under the given ref, and returns the notes that were removed.
+func compactRef(repo repository.Repo, ref, backupRef string, cutoff time.Time) ([]gcRemoval, error) {
+	// The compaction is retried if the ref is updated concurrently, so only the last attempt counts.
+	removedByObject := make(map[string][]gcRemoval)
+	err := repo.CompactNotes(ref, backupRef, func(object string, notes []repository.Note) []repository.Note {
+		kept, removed := compactNote(ref, object, notes, cutoff)
+		removedByObject[object] = removed
+		return kept
+	})
+	if err != nil {
+		return nil, err
+	}
+	var objects []string
+	for object := range removedByObject {
+		objects = append(objects, object)
+	}
+	sort.Strings(objects)
+	var removed []gcRemoval
+	for _, object := range objects {
+		removed = append(removed, removedByObject[object]...)
+	}
+	return removed, nil
+}
+
+// gcNotes removes duplicate and obsolete notes from every review data notes ref.
+func gcNotes(repo repository.Repo, args []string) error {
+	if err := gcFlagSet.Parse(args); err != nil {
+		return err
+	}
+	if len(gcFlagSet.Args()) > 0 {
+		return errors.New("The gc command does not take any arguments.")
+	}
+	if *gcMaxAge < 0 {
+		return errors.New("The -max-age flag cannot be negative.")
+	}
+	now := time.Now()
+	cutoff := now.Add(-*gcMaxAge)
+	refs, err := repo.ListNotesRefs(notesRefPattern)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, ref := range refs {
+		if path.Base(ref) == path.Base(comment.AttachmentsRef) {
+			// Attachments are stored as raw file contents rather than as lines of JSON.
+			continue
+		}
+		backupRef := gcBackupRef(now, ref)
+		var removed []gcRemoval
+		if *gcDryRun {
+			removed, err = findRemovals(repo, ref, cutoff)
+		} else {
+			removed, err = compactRef(repo, ref, backupRef, cutoff)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to compact the notes in %q: %v", ref, err)
+		}
+		for _, removal := range removed {
+			fmt.Printf("%s: %s note on %s: %s\n", ref, removal.reason, removal.object, removal.note)
+		}
+		if len(removed) > 0 && !*gcDryRun {
+			fmt.Printf("%s: the previous notes are saved under %q\n", ref, backupRef)
+		}
+		count += len(removed)
+	}
+	if *gcDryRun {
+		fmt.Printf("Would remove %d note(s).\n", count)
+	} else {
+		fmt.Printf("Removed %d note(s).\n", count)
+	}
+	return nil
+}
+
+// gcCmd defines the "gc" subcommand.
+var gcCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s gc [<option>...]\n\nOptions:\n", arg0)
+		gcFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return gcNotes(repo, args)
+	},
+	Flags: gcFlagSet,
+}
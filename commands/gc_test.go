@@ -0,0 +1,81 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/ci"
+	"testing"
+	"time"
+)
+
+func TestCompactNote(t *testing.T) {
+	oldReport := repository.Note(`{"timestamp": "0000000001", "agent": "bot", "status": "failure"}`)
+	newReport := repository.Note(`{"timestamp": "0000000002", "agent": "bot", "status": "success"}`)
+	otherReport := repository.Note(`{"timestamp": "0000000001", "agent": "other", "status": "failure"}`)
+	notes := []repository.Note{repository.Note(""), oldReport, otherReport, newReport, repository.Note(""), oldReport}
+
+	kept, removed := compactNote(ci.Ref, repository.TestCommitB, notes, time.Unix(10, 0))
+	if len(kept) != 2 || string(kept[0]) != string(otherReport) || string(kept[1]) != string(newReport) {
+		t.Fatalf("Unexpected notes kept: %q", kept)
+	}
+	if len(removed) != 2 || removed[0].reason != "duplicate" || removed[1].reason != "superseded" || string(removed[1].note) != string(oldReport) {
+		t.Fatalf("Unexpected notes removed: %+v", removed)
+	}
+
+	// Superseded reports newer than the cutoff are kept.
+	if kept, removed := compactNote(ci.Ref, repository.TestCommitB, notes, time.Unix(1, 0)); len(kept) != 3 || len(removed) != 1 {
+		t.Fatalf("Unexpected compaction of recent reports: %q, %+v", kept, removed)
+	}
+	// Only duplicates are removed from refs that are not written by tools.
+	if kept, removed := compactNote(repository.TestCommentsRef, repository.TestCommitB, notes, time.Unix(10, 0)); len(kept) != 3 || len(removed) != 1 {
+		t.Fatalf("Unexpected compaction of comments: %q, %+v", kept, removed)
+	}
+}
+
+func TestCompactRef(t *testing.T) {
+	repo := repository.NewMemoryRepoForTest()
+	for i := 0; i < 2; i++ {
+		if err := repo.AppendNote(repository.TestCommentsRef, repository.TestCommitB, repository.Note(repository.TestDiscussB)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cutoff := time.Now()
+	wouldRemove, err := findRemovals(repo, repository.TestCommentsRef, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := compactRef(repo, repository.TestCommentsRef, gcBackupRefPrefix+"test", cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The mock repo already has one copy of the comment, so there are now two duplicates.
+	if len(removed) != 2 || len(wouldRemove) != len(removed) {
+		t.Fatalf("The dry run reported %+v, but gc removed %+v", wouldRemove, removed)
+	}
+	for i := range removed {
+		if removed[i].object != wouldRemove[i].object || string(removed[i].note) != string(wouldRemove[i].note) {
+			t.Fatalf("The dry run reported %+v, but gc removed %+v", wouldRemove[i], removed[i])
+		}
+	}
+	if notes := repo.GetNotes(repository.TestCommentsRef, repository.TestCommitB); len(notes) != 1 || string(notes[0]) != repository.TestDiscussB {
+		t.Fatalf("Unexpected notes after compaction: %q", notes)
+	}
+	if removed, err := compactRef(repo, repository.TestCommentsRef, gcBackupRefPrefix+"test", cutoff); err != nil || len(removed) != 0 {
+		t.Fatalf("Unexpected second compaction: %+v, %v", removed, err)
+	}
+}
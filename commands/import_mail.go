@@ -0,0 +1,474 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/comment"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var importMailFlagSet = flag.NewFlagSet("import-mail", flag.ContinueOnError)
+
+var (
+	// linkTrailerPattern matches the Link: trailer that format-patch adds to each patch,
+	// even when it is quoted in a reply.
+	linkTrailerPattern = regexp.MustCompile(`(?m)^[> ]*Link:\s*\S*?([0-9a-f]{40})\s*$`)
+	// hunkHeaderPattern matches the header of a diff hunk, capturing its first new line.
+	hunkHeaderPattern = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+	// subjectPrefixPattern matches the reply and patch prefixes of an email subject,
+	// such as "Re: [PATCH v2 1/3] ".
+	subjectPrefixPattern = regexp.MustCompile(`^(?i:(?:re|fwd?):\s*|\[[^\]]*\]\s*)+`)
+)
+
+// mailMessage is a single message read from an mbox file.
+type mailMessage struct {
+	ID         string
+	InReplyTo  string
+	References []string
+	From       string
+	Subject    string
+	Date       time.Time
+	Body       string
+}
+
+// mailComment is a comment on a quoted line of a diff, found in the body of a reply.
+type mailComment struct {
+	Path string
+	Line uint32
+	Text string
+}
+
+// normalizeMessageID strips the angle brackets and whitespace around a Message-Id.
+func normalizeMessageID(id string) string {
+	return strings.Trim(strings.TrimSpace(id), "<>")
+}
+
+// readMbox splits an mbox file into its messages.
+func readMbox(r io.Reader) ([]mailMessage, error) {
+	var messages []mailMessage
+	var raw bytes.Buffer
+	started := false
+	flush := func() error {
+		if !started {
+			return nil
+		}
+		message, err := parseMailMessage(raw.Bytes())
+		if err != nil {
+			return err
+		}
+		messages = append(messages, message)
+		raw.Reset()
+		return nil
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	previousBlank := true
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if previousBlank && strings.HasPrefix(line, "From ") {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			started = true
+			previousBlank = false
+			continue
+		}
+		if !started {
+			return nil, errors.New("The file is not an mbox, as it does not start with a \"From \" line.")
+		}
+		// Undo the quoting of lines that would otherwise start a new message (the "mboxrd" format).
+		if trimmed := strings.TrimLeft(line, ">"); trimmed != line && strings.HasPrefix(trimmed, "From ") {
+			line = line[1:]
+		}
+		raw.WriteString(line)
+		raw.WriteString("\n")
+		previousBlank = line == ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// parseMailMessage parses the headers and plain text body of a single email.
+func parseMailMessage(raw []byte) (mailMessage, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return mailMessage{}, fmt.Errorf("Failed to parse an email: %v", err)
+	}
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	message := mailMessage{
+		ID:        normalizeMessageID(msg.Header.Get("Message-Id")),
+		InReplyTo: normalizeMessageID(msg.Header.Get("In-Reply-To")),
+		Subject:   strings.TrimSpace(subject),
+	}
+	for _, reference := range strings.Fields(msg.Header.Get("References")) {
+		message.References = append(message.References, normalizeMessageID(reference))
+	}
+	if from, err := mail.ParseAddress(msg.Header.Get("From")); err == nil {
+		message.From = from.Address
+	} else {
+		message.From = strings.TrimSpace(msg.Header.Get("From"))
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		message.Date = date
+	}
+	body, err := readMailBody(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return mailMessage{}, fmt.Errorf("Failed to read the email %q: %v", message.Subject, err)
+	}
+	message.Body = strings.Replace(body, "\r\n", "\n", -1)
+	return message, nil
+}
+
+// readMailBody returns the plain text of an email body, taking the first plain text
+// part of multipart emails.
+func readMailBody(contentType, encoding string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return "", nil
+			}
+			if err != nil {
+				return "", err
+			}
+			partType := part.Header.Get("Content-Type")
+			if partType == "" {
+				partType = "text/plain"
+			}
+			if partMediaType, _, _ := mime.ParseMediaType(partType); partMediaType == "text/plain" || strings.HasPrefix(partMediaType, "multipart/") {
+				return readMailBody(partType, part.Header.Get("Content-Transfer-Encoding"), part)
+			}
+		}
+	}
+	contents, err := ioutil.ReadAll(body)
+	return string(contents), err
+}
+
+// trimSubject removes the reply and patch prefixes of an email subject.
+func trimSubject(subject string) string {
+	return strings.TrimSpace(subjectPrefixPattern.ReplaceAllString(subject, ""))
+}
+
+// parseReply splits the body of a reply into the comments made on quoted diff lines,
+// and the remaining text, which could not be located in the diff.
+func parseReply(body string) ([]mailComment, string) {
+	var comments []mailComment
+	var unlocated []string
+	var path string
+	var newLine, lastLine uint32
+	inHunk, afterHunkLine := false, false
+	var block []string
+	blockPath, blockLine := "", uint32(0)
+	flush := func(beforeQuote bool) {
+		if beforeQuote {
+			// Drop the attribution line, such as "On Monday, Jane wrote:", that introduces a quote.
+			for len(block) > 0 && strings.TrimSpace(block[len(block)-1]) == "" {
+				block = block[:len(block)-1]
+			}
+			if len(block) > 0 && strings.HasSuffix(strings.TrimSpace(block[len(block)-1]), "wrote:") {
+				block = block[:len(block)-1]
+			}
+		}
+		text := strings.TrimSpace(strings.Join(block, "\n"))
+		block = nil
+		if text == "" {
+			return
+		}
+		if blockPath != "" {
+			comments = append(comments, mailComment{Path: blockPath, Line: blockLine, Text: text})
+		} else {
+			unlocated = append(unlocated, text)
+		}
+	}
+	for _, line := range strings.Split(body, "\n") {
+		if strings.TrimRight(line, " ") == "--" {
+			// The rest of the message is the signature.
+			break
+		}
+		if !strings.HasPrefix(line, ">") {
+			if block == nil {
+				blockPath, blockLine = "", 0
+				if afterHunkLine {
+					blockPath, blockLine = path, lastLine
+				}
+			}
+			block = append(block, line)
+			continue
+		}
+		if block != nil {
+			flush(true)
+		}
+		quoted := strings.TrimPrefix(line[1:], " ")
+		afterHunkLine = false
+		if strings.HasPrefix(quoted, ">") {
+			// Quotes of earlier replies are not part of the diff.
+			continue
+		}
+		switch {
+		case strings.HasPrefix(quoted, "diff --git "):
+			path, inHunk = "", false
+			if i := strings.LastIndex(quoted, " b/"); i >= 0 {
+				path = quoted[i+len(" b/"):]
+			}
+		case !inHunk && strings.HasPrefix(quoted, "+++ "):
+			path = strings.TrimPrefix(strings.TrimPrefix(quoted, "+++ "), "b/")
+			if path == "/dev/null" {
+				path = ""
+			}
+		case hunkHeaderPattern.MatchString(quoted):
+			first, _ := strconv.ParseUint(hunkHeaderPattern.FindStringSubmatch(quoted)[1], 10, 32)
+			newLine, lastLine, inHunk = uint32(first)-1, uint32(first), true
+		case inHunk && (strings.HasPrefix(quoted, " ") || strings.HasPrefix(quoted, "+") || quoted == ""):
+			newLine++
+			lastLine = newLine
+			afterHunkLine = path != ""
+		case inHunk && strings.HasPrefix(quoted, "-"):
+			// Comments on removed lines are placed on the line that replaced them.
+			lastLine = newLine + 1
+			afterHunkLine = path != ""
+		default:
+			inHunk = false
+		}
+	}
+	flush(false)
+	return comments, strings.Join(unlocated, "\n\n")
+}
+
+// findPatchCommit returns the commit of the review whose summary matches the subject of an
+// email about its patch, or the head commit of the review if there is none.
+func findPatchCommit(repo repository.Repo, r *review.Review, subject string) (string, error) {
+	headCommit, err := r.GetHeadCommit()
+	if err != nil {
+		return "", err
+	}
+	baseCommit, err := r.GetBaseCommit()
+	if err != nil {
+		return "", err
+	}
+	commits, err := repo.ListCommitsBetween(baseCommit, headCommit)
+	if err != nil {
+		return "", err
+	}
+	summary := trimSubject(subject)
+	for _, commit := range commits {
+		details, err := repo.GetCommitDetails(commit)
+		if err == nil && details.Summary == summary {
+			return commit, nil
+		}
+	}
+	return headCommit, nil
+}
+
+// collectMessageIDs maps the Message-Ids of already imported comments to the comments' hashes.
+func collectMessageIDs(threads []review.CommentThread, ids map[string]string) {
+	for _, thread := range threads {
+		if thread.Comment.MessageID != "" {
+			if _, ok := ids[thread.Comment.MessageID]; !ok {
+				ids[thread.Comment.MessageID] = thread.Hash
+			}
+		}
+		collectMessageIDs(thread.Children, ids)
+	}
+}
+
+// matchMessageReview returns the hash of the review that a message is about, found either
+// from a Link: trailer in the message, or from the messages that it replies to.
+func matchMessageReview(message mailMessage, reviewsByMessage map[string]string) string {
+	if match := linkTrailerPattern.FindStringSubmatch(message.Body); match != nil {
+		return match[1]
+	}
+	for _, id := range append([]string{message.InReplyTo}, message.References...) {
+		if revision, ok := reviewsByMessage[id]; ok {
+			return revision
+		}
+	}
+	return ""
+}
+
+// importMessage adds the comments in a single email to the given review, returning how many were added.
+func importMessage(repo repository.Repo, r *review.Review, message mailMessage, importedIDs map[string]string) (int, error) {
+	located, unlocated := parseReply(message.Body)
+	if len(located) == 0 && unlocated == "" {
+		return 0, nil
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	if !message.Date.IsZero() {
+		timestamp = strconv.FormatInt(message.Date.Unix(), 10)
+	}
+	newComment := func(description string) comment.Comment {
+		c := comment.New(message.From, description)
+		c.Timestamp = timestamp
+		c.MessageID = message.ID
+		return c
+	}
+	var comments []comment.Comment
+	if unlocated != "" || len(located) == 0 {
+		c := newComment(strings.TrimSpace(message.Subject + "\n\n" + unlocated))
+		if parent, ok := importedIDs[message.InReplyTo]; ok && message.InReplyTo != "" {
+			c.Parent = parent
+		}
+		comments = append(comments, c)
+	}
+	if len(located) > 0 {
+		commit, err := findPatchCommit(repo, r, message.Subject)
+		if err != nil {
+			return 0, err
+		}
+		for _, mc := range located {
+			c := newComment(mc.Text)
+			c.Location = &comment.Location{
+				Commit: commit,
+				Path:   mc.Path,
+				Range:  &comment.Range{StartLine: mc.Line},
+			}
+			comments = append(comments, c)
+		}
+	}
+	for i, c := range comments {
+		if err := r.AddComment(c); err != nil {
+			return 0, err
+		}
+		if i == 0 && message.ID != "" {
+			hash, err := c.Hash()
+			if err != nil {
+				return 0, err
+			}
+			importedIDs[message.ID] = hash
+		}
+	}
+	return len(comments), nil
+}
+
+// importMail imports the replies in an mbox file as review comments.
+func importMail(repo repository.Repo, args []string) error {
+	if err := importMailFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = importMailFlagSet.Args()
+	if len(args) != 1 {
+		return errors.New("Exactly one mbox file must be specified.")
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	messages, err := readMbox(file)
+	if err != nil {
+		return err
+	}
+
+	reviewsByMessage := make(map[string]string)
+	reviews := make(map[string]*review.Review)
+	importedIDs := make(map[string]map[string]string)
+	imported, skipped, unmatched := 0, 0, 0
+	for _, message := range messages {
+		revision := matchMessageReview(message, reviewsByMessage)
+		if revision == "" {
+			unmatched++
+			continue
+		}
+		r, ok := reviews[revision]
+		if !ok {
+			r, err = review.Get(repo, revision)
+			if err != nil {
+				return fmt.Errorf("Failed to load the review %.12s: %v", revision, err)
+			}
+			reviews[revision] = r
+			if r != nil {
+				importedIDs[revision] = make(map[string]string)
+				collectMessageIDs(r.Comments, importedIDs[revision])
+			}
+		}
+		if r == nil {
+			unmatched++
+			continue
+		}
+		if message.ID != "" {
+			reviewsByMessage[message.ID] = revision
+			if _, ok := importedIDs[revision][message.ID]; ok {
+				skipped++
+				continue
+			}
+		}
+		if !strings.HasPrefix(strings.TrimSpace(strings.ToLower(message.Subject)), "re:") && linkTrailerPattern.MatchString(message.Body) {
+			// The patches and cover letter themselves only let us match the replies to them.
+			continue
+		}
+		count, err := importMessage(repo, r, message, importedIDs[revision])
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			fmt.Printf("Imported %d comment(s) from %s into review %.12s\n", count, message.From, revision)
+			imported += count
+		}
+	}
+	fmt.Printf("Imported %d comment(s); skipped %d already imported and %d unmatched message(s).\n", imported, skipped, unmatched)
+	return nil
+}
+
+// importMailCmd defines the "import-mail" subcommand.
+var importMailCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s import-mail <mbox-file>\n\n"+
+			"Imports the email replies to patches exported with \"format-patch\" as review comments.\n", arg0)
+		importMailFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return importMail(repo, args)
+	},
+	Flags: importMailFlagSet,
+}
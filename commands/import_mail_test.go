@@ -0,0 +1,184 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseReply(t *testing.T) {
+	body := `Thanks for the patch.
+
+On Mon, Jan 2, 2017, Jane <jane@example.com> wrote:
+> diff --git a/file.txt b/file.txt
+> index 1111111..2222222 100644
+> --- a/file.txt
+> +++ b/file.txt
+> @@ -1,2 +1,3 @@
+>  First
+> +Second
+
+Why is this needed?
+
+> +Third
+> -Removed
+
+Please keep this.
+--
+Reviewer
+`
+	comments, unlocated := parseReply(body)
+	expected := []mailComment{
+		{Path: "file.txt", Line: 2, Text: "Why is this needed?"},
+		{Path: "file.txt", Line: 4, Text: "Please keep this."},
+	}
+	if !reflect.DeepEqual(comments, expected) {
+		t.Errorf("Unexpected located comments: got %+v, want %+v", comments, expected)
+	}
+	if unlocated != "Thanks for the patch." {
+		t.Errorf("Unexpected unlocated text: %q", unlocated)
+	}
+}
+
+func TestTrimSubject(t *testing.T) {
+	if subject := trimSubject("Re: RE: [PATCH v2 1/3] Add the feature"); subject != "Add the feature" {
+		t.Errorf("Unexpected subject: %q", subject)
+	}
+}
+
+func TestImportMail(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("The git command line tool is not installed")
+	}
+	defer func() { *formatPatchForce, *formatPatchOutput = false, "." }()
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "config", "user.email", "user@example.com")
+	runGit(t, dir, "config", "user.name", "Test User")
+	runGit(t, dir, "checkout", "-q", "-b", "release")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "First commit")
+	runGit(t, dir, "checkout", "-q", "-b", "feature")
+	if err := ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("One\nTwo\nThree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, dir, "add", "file.txt")
+	runGit(t, dir, "commit", "-q", "-m", "Add the feature")
+
+	repo, err := repository.NewGitRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := requestReview(repo, []string{"-quiet", "-m", "Feature", "-r", "", "-target", "refs/heads/release"}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.GetCurrent(repo)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	revision := r.Revision
+	headCommit, err := r.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patchDir := filepath.Join(dir, "patches")
+	if err := formatPatch(repo, []string{revision, "-force", "-o", patchDir}); err != nil {
+		t.Fatal(err)
+	}
+	patch, err := ioutil.ReadFile(filepath.Join(patchDir, "0001-Add-the-feature.patch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var quoted []string
+	for _, line := range strings.Split(strings.TrimSpace(string(patch)), "\n") {
+		if strings.HasPrefix(line, "+Two") {
+			quoted = append(quoted, "> "+line, "", "Should this be lowercase?", "")
+			continue
+		}
+		quoted = append(quoted, "> "+line)
+	}
+	mbox := string(patch) + "\n" +
+		"From reviewer@example.com Mon Jan  2 15:04:05 2017\n" +
+		"From: Reviewer <reviewer@example.com>\n" +
+		"Subject: Re: [PATCH 1/1] Add the feature\n" +
+		"Date: Mon, 2 Jan 2017 15:04:05 +0000\n" +
+		"Message-Id: <reply-1@example.com>\n" +
+		"\n" +
+		"On Monday, Test User wrote:\n" +
+		strings.Join(quoted, "\n") + "\n" +
+		"\n" +
+		"From other@example.com Mon Jan  2 16:04:05 2017\n" +
+		"From: Other <other@example.com>\n" +
+		"Subject: Re: [PATCH 1/1] Add the feature\n" +
+		"Date: Mon, 2 Jan 2017 16:04:05 +0000\n" +
+		"Message-Id: <reply-2@example.com>\n" +
+		"In-Reply-To: <reply-1@example.com>\n" +
+		"\n" +
+		"Looks fine to me otherwise.\n" +
+		"\n" +
+		"From stranger@example.com Mon Jan  2 17:04:05 2017\n" +
+		"From: Stranger <stranger@example.com>\n" +
+		"Subject: Unrelated\n" +
+		"Message-Id: <unrelated@example.com>\n" +
+		"\n" +
+		"Nothing to see here.\n"
+	mboxPath := filepath.Join(dir, "replies.mbox")
+	if err := ioutil.WriteFile(mboxPath, []byte(mbox), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := importMail(repo, []string{mboxPath}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err = review.Get(repo, revision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Comments) != 1 {
+		t.Fatalf("Unexpected comment threads: %+v", r.Comments)
+	}
+	located := r.Comments[0]
+	if located.Comment.Author != "reviewer@example.com" || located.Comment.Description != "Should this be lowercase?" ||
+		located.Comment.MessageID != "reply-1@example.com" || located.Comment.Timestamp != "1483369445" {
+		t.Errorf("Unexpected located comment: %+v", located.Comment)
+	}
+	if location := located.Comment.Location; location == nil || location.Commit != headCommit ||
+		location.Path != "file.txt" || location.Range == nil || location.Range.StartLine != 2 {
+		t.Errorf("Unexpected location of the comment: %+v", location)
+	}
+	if len(located.Children) != 1 {
+		t.Fatalf("Unexpected replies to the located comment: %+v", located.Children)
+	}
+	if reply := located.Children[0].Comment; reply.Author != "other@example.com" ||
+		reply.Description != "Re: [PATCH 1/1] Add the feature\n\nLooks fine to me otherwise." {
+		t.Errorf("Unexpected reply: %+v", reply)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/analyses"
+	"github.com/google/git-appraise/review/ci"
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/request"
+)
+
+var initFlagSet = flag.NewFlagSet("init", flag.ContinueOnError)
+
+// initNotesRefs are the notes refs that "init" creates, so that every kind of review data has a ref to be written to.
+var initNotesRefs = []string{request.Ref, comment.Ref, ci.Ref, analyses.Ref}
+
+// initRepo sets up a repository for code reviews, by configuring the given remote to
+// fetch the review data and creating the local notes refs.
+//
+// Running it again only reports that there was nothing left to set up.
+func initRepo(repo repository.Repo, args []string) error {
+	if err := initFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = initFlagSet.Args()
+	if len(args) > 1 {
+		return errors.New("Only initializing one remote at a time is supported.")
+	}
+
+	remote := "origin"
+	if len(args) == 1 {
+		remote = args[0]
+	}
+	remotes, err := repo.ListRemotes()
+	if err != nil {
+		return err
+	}
+	remoteExists := false
+	for _, r := range remotes {
+		remoteExists = remoteExists || r == remote
+	}
+
+	changed := false
+	if remoteExists {
+		added, err := repo.ConfigureFetchRefSpecs(remote, notesRefPattern, archiveRefPattern)
+		if err != nil {
+			return err
+		}
+		for _, refSpec := range added {
+			fmt.Printf("Added the fetch refspec %q to the remote %q\n", refSpec, remote)
+			changed = true
+		}
+	} else if len(args) == 1 {
+		return fmt.Errorf("There is no remote named %q.", remote)
+	} else {
+		fmt.Printf("There is no remote named %q, so no fetch refspecs were configured\n", remote)
+	}
+	for _, ref := range initNotesRefs {
+		created, err := repo.InitNotesRef(ref)
+		if err != nil {
+			return err
+		}
+		if created {
+			fmt.Printf("Created the notes ref %q\n", ref)
+			changed = true
+		}
+	}
+	if !changed {
+		fmt.Println("Already initialized; nothing to do")
+	} else if remoteExists {
+		fmt.Printf("Run \"git appraise pull %s\" to fetch the existing reviews\n", remote)
+	}
+	return nil
+}
+
+var initCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s init [<remote>]\n", arg0)
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return initRepo(repo, args)
+	},
+	Flags: initFlagSet,
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"strings"
+)
+
+var labelFlagSet = flag.NewFlagSet("label", flag.ContinueOnError)
+
+var labelAdd, labelRemove stringList
+
+func init() {
+	labelFlagSet.Var(&labelAdd, "add", "Label to add to the review; may be repeated")
+	labelFlagSet.Var(&labelRemove, "remove", "Label to remove from the review; may be repeated")
+}
+
+// labelReview adds labels to, and removes labels from, a review.
+func labelReview(repo repository.Repo, args []string) error {
+	labelAdd, labelRemove = nil, nil
+	if err := labelFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = labelFlagSet.Args()
+	if len(args) > 1 {
+		// Allow the review hash to come before the flags, as in "label <review-hash> -add <label>".
+		revision := args[0]
+		if err := labelFlagSet.Parse(args[1:]); err != nil {
+			return err
+		}
+		args = append([]string{revision}, labelFlagSet.Args()...)
+	}
+	if len(args) > 1 {
+		return errors.New("Only labelling a single review is supported.")
+	}
+	for _, added := range labelAdd {
+		for _, removed := range labelRemove {
+			if added == removed {
+				return fmt.Errorf("The label %q cannot be both added and removed.", added)
+			}
+		}
+	}
+
+	var r *review.Review
+	var err error
+	if len(args) == 1 {
+		r, err = review.Get(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %v\n", err)
+	}
+	if r == nil {
+		return noMatchingReview(args)
+	}
+	if len(labelAdd) > 0 || len(labelRemove) > 0 {
+		userEmail, err := repo.GetUserEmail()
+		if err != nil {
+			return err
+		}
+		if err := r.SetLabels(userEmail, labelAdd, labelRemove); err != nil {
+			return err
+		}
+	}
+	if len(r.Labels) == 0 {
+		fmt.Printf("Review %.12s has no labels.\n", r.Revision)
+	} else {
+		fmt.Printf("Review %.12s is labelled: %s\n", r.Revision, strings.Join(r.Labels, ", "))
+	}
+	return nil
+}
+
+// labelCmd defines the "label" subcommand.
+var labelCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s label [-add <label>]... [-remove <label>]... [<review-hash>]\n\nOptions:\n", arg0)
+		labelFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return labelReview(repo, args)
+	},
+	Flags: labelFlagSet,
+}
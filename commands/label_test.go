@@ -0,0 +1,44 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"reflect"
+	"testing"
+)
+
+func TestLabelReview(t *testing.T) {
+	repo := repository.NewMemoryRepoForTest()
+	if err := labelReview(repo, []string{repository.TestCommitG, "-add", "docs", "-add", "security"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := labelReview(repo, []string{"-remove", "docs", "-add", "release-blocker", repository.TestCommitG}); err != nil {
+		t.Fatal(err)
+	}
+	if err := labelReview(repo, []string{"-remove", "docs", "-add", "docs", repository.TestCommitG}); err == nil {
+		t.Fatal("Unexpectedly allowed a label to be both added and removed")
+	}
+	r, err := review.Get(repo, repository.TestCommitG)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	if !reflect.DeepEqual(r.Labels, []string{"release-blocker", "security"}) {
+		t.Fatalf("Unexpected labels: %v", r.Labels)
+	}
+}
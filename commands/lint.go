@@ -0,0 +1,128 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"strings"
+)
+
+var lintFlagSet = flag.NewFlagSet("lint", flag.ContinueOnError)
+
+var (
+	lintTarget = lintFlagSet.String("target", "", "Revision that HEAD would be reviewed against, if it has no review yet (default from the "+
+		requestTargetConfigKey+" setting, or else \"refs/heads/master\")")
+	lintRecord = lintFlagSet.Bool("record", false, "Record the results as an analysis report on the review, which is shown with it")
+)
+
+// lintError returns the error for the given commit message rule violations.
+func lintError(violations []review.LintViolation) error {
+	var lines []string
+	for _, violation := range violations {
+		lines = append(lines, "  "+violation.String())
+	}
+	return CommandError{
+		Err:      fmt.Errorf("%d problem(s) with the commit messages:\n%s", len(violations), strings.Join(lines, "\n")),
+		Guidance: "Reword the commits (such as with \"git rebase -i\") to follow the " + configKeyPrefix + "lint.* settings.",
+		ExitCode: ExitPreconditionFailed,
+	}
+}
+
+// lintCommitMessages checks the commit messages of a review against the configured rules.
+//
+// Without a review hash, this checks the current review, or else the commits that a review
+// of HEAD would include, so that they can be checked before requesting a review.
+func lintCommitMessages(repo repository.Repo, args []string) error {
+	if err := lintFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = lintFlagSet.Args()
+	if len(args) > 1 {
+		return errors.New("Only linting a single review is supported.")
+	}
+	rules, err := review.ReadLintRules(repo)
+	if err != nil {
+		return err
+	}
+	if rules.Empty() {
+		fmt.Printf("There are no commit message rules to check; see the %slint.* settings.\n", configKeyPrefix)
+		return nil
+	}
+
+	var r *review.Review
+	if len(args) == 1 {
+		if r, err = loadReview(repo, args[0]); err != nil {
+			return err
+		}
+	} else if r, err = review.GetCurrent(repo); err != nil {
+		return err
+	}
+	var commits []string
+	if r != nil {
+		if commits, err = r.GetCommits(); err != nil {
+			return err
+		}
+	} else {
+		if *lintRecord {
+			return errors.New("There is no review to record the results on; request one first, or give its hash.")
+		}
+		target := *lintTarget
+		if target == "" {
+			target = repository.GetConfigValue(repo, requestTargetConfigKey, "refs/heads/master")
+		}
+		if commits, err = repo.ListCommitsBetween(target, "HEAD"); err != nil {
+			return err
+		}
+	}
+
+	violations, err := review.LintCommits(repo, rules, commits)
+	if err != nil {
+		return err
+	}
+	if *lintRecord {
+		headCommit, err := r.GetHeadCommit()
+		if err != nil {
+			return err
+		}
+		if err := review.RecordLintResults(repo, headCommit, violations); err != nil {
+			return err
+		}
+	}
+	if len(violations) > 0 {
+		return lintError(violations)
+	}
+	fmt.Printf("All %d commit message(s) follow the rules.\n", len(commits))
+	return nil
+}
+
+// lintCmd defines the "lint" subcommand.
+var lintCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s lint [<option>...] [<review-hash>]\n\n"+
+			"Checks the commit messages of a review (or of HEAD, before requesting a review of it)\n"+
+			"against the rules in the %slint.* settings, as the request command does.\n\nOptions:\n", arg0, configKeyPrefix)
+		lintFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return lintCommitMessages(repo, args)
+	},
+	Flags: lintFlagSet,
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	defer func() { *lintRecord = false }()
+	repo := repository.NewRepoWithHistory(map[string][]string{"master": {"A"}})
+	repo.AddCommit("B", "WIP: add the feature", map[string]string{"feature.go": "package feature\n"}, "A")
+	repo.AddCommit("C", "Add a test for PROJ-7", map[string]string{"feature_test.go": "package feature\n"}, "B")
+	repo.SetRef("refs/heads/feature", "C")
+	if err := repo.SwitchToRef("refs/heads/feature"); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := captureStdout(func() error { return lintCommitMessages(repo, nil) }); err != nil || !strings.Contains(output, "no commit message rules") {
+		t.Fatalf("Unexpected result of linting without any rules: %q, %v", output, err)
+	}
+	for key, value := range map[string]string{
+		review.LintRequiredPatternConfigKey:   `PROJ-\d+`,
+		review.LintForbiddenPrefixesConfigKey: "WIP",
+		review.LintRecordConfigKey:            "true",
+	} {
+		if err := repo.SetConfigValue(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Before requesting a review, the commits on top of the target are checked.
+	err := lintCommitMessages(repo, nil)
+	if ExitCode(err) != ExitPreconditionFailed || !strings.Contains(err.Error(), "2 problem(s)") ||
+		!strings.Contains(err.Error(), "B WIP: add the feature: the subject starts with \"WIP\"") {
+		t.Fatalf("Unexpected result of linting the commits: %v", err)
+	}
+	if err := Request(repo, RequestOptions{Target: "refs/heads/master", Quiet: true}); ExitCode(err) != ExitPreconditionFailed {
+		t.Fatalf("Unexpectedly requested a review whose commit messages break the rules: %v", err)
+	}
+	if r, err := review.Get(repo, "B"); err != nil || r != nil {
+		t.Fatalf("The review was requested despite the errors: %+v, %v", r, err)
+	}
+	if _, err := captureStdout(func() error {
+		return Request(repo, RequestOptions{Target: "refs/heads/master", Quiet: true, NoVerify: true})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.Get(repo, "B")
+	if err != nil || r == nil {
+		t.Fatalf("The review was not requested with --no-verify: %v", err)
+	}
+	if notes, err := r.GetAnalysesNotes(); err != nil || len(notes) != 2 || notes[0].Category != review.LintRuleRequiredPattern {
+		t.Errorf("The results were not recorded: %+v, %v", notes, err)
+	}
+
+	// Once the commit is reworded, recording the results again clears the warnings.
+	repo.AddCommit("D", "Add the feature for PROJ-7", map[string]string{"feature.go": "package feature\n"}, "A")
+	repo.AddCommit("E", "Add a test for PROJ-7", map[string]string{"feature_test.go": "package feature\n"}, "D")
+	repo.SetRef("refs/heads/feature", "E")
+	if _, err := captureStdout(func() error { return lintCommitMessages(repo, []string{"-record", "B"}) }); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = review.Get(repo, "B"); err != nil {
+		t.Fatal(err)
+	}
+	if notes, err := r.GetAnalysesNotes(); err != nil || len(notes) != 0 {
+		t.Errorf("Unexpected results after rewording the commit: %+v, %v", notes, err)
+	}
+}
@@ -17,34 +17,465 @@ limitations under the License.
 package commands
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/google/git-appraise/commands/output"
 	"github.com/google/git-appraise/repository"
 	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/analyses"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
 )
 
-var listFlagSet = flag.NewFlagSet("list", flag.ExitOnError)
+// listProgressThreshold is the number of reviews that have to be re-read before the
+// list command shows its progress.
+const listProgressThreshold = 100
+
+var listFlagSet = flag.NewFlagSet("list", flag.ContinueOnError)
 
 var (
-	listAll = listFlagSet.Bool("a", false, "List all reviews (not just the open ones).")
+	listFilter    = addReviewFilterFlags(listFlagSet)
+	listAll       = listFilter.all
+	listRejected  = listFilter.rejected
+	listCategory  = listFilter.category
+	listJson      = listFlagSet.Bool("json", false, "Format the output as JSON")
+	listPorcelain = listFlagSet.Bool("porcelain", false, "Format the output as stable, tab separated lines for scripts, "+
+		"and never prompt for anything")
+	listLimit   = listFlagSet.Int("limit", 0, "List at most this many reviews (0 means no limit)")
+	listOffset  = listFlagSet.Int("offset", 0, "Skip this many of the matching reviews before listing any")
+	listNoCache = listFlagSet.Bool("no-cache", false, "Rebuild the cache of parsed reviews from scratch")
+	listSort    = listFlagSet.String("sort", "", "Sort the reviews by \"revision\", \"timestamp\" (newest first), or \"requester\"; "+
+		"this prints them only once all have been read, rather than as each one is")
+	listOrphaned        = listFlagSet.Bool("orphaned", false, "List the reviews whose commits are missing, or cannot be reached from any branch or tag")
+	listPrune           = listFlagSet.Bool("prune", false, "Remove the notes of the orphaned reviews; can only be used with the --orphaned flag")
+	listYes             = listFlagSet.Bool("yes", false, "Prune without asking for confirmation")
+	listFormat          = listFlagSet.String("format", "", "Go text/template with which to print each review on a line of its own, using the fields Revision, Requester, Reviewers, Description, ReviewRef, TargetRef, Timestamp, Status, Resolved, OpenComments, CIStatus, and Labels")
+	listRemote          = listFlagSet.String(remoteURLFlag, "", "Read the reviews from the git-appraise server (see \"serve\") at this URL, rather than from the local repository")
+	listIncludeCI       = listFlagSet.Bool("include-ci", false, "Include the CI reports of each review in the --json output")
+	listIncludeAnalyses = listFlagSet.Bool("include-analyses", false, "Include the analyses reports of each review in the --json output, "+
+		"along with the notes of the latest one (which are fetched from its URL)")
+	listColor = colorFlag(listFlagSet)
 )
 
+// reviewFilterFlags are the flags that select which reviews are listed, which the "tui"
+// command shares with the "list" command.
+type reviewFilterFlags struct {
+	all      *bool
+	mine     *bool
+	involved *bool
+	watched  *bool
+	rejected *bool
+	category *string
+	labels   *stringList
+}
+
+// addReviewFilterFlags defines the flags that select which reviews are listed in the given flag set.
+func addReviewFilterFlags(flagSet *flag.FlagSet) reviewFilterFlags {
+	filter := reviewFilterFlags{
+		all:      flagSet.Bool("a", false, "List all reviews (not just the open ones)."),
+		mine:     flagSet.Bool("mine", false, "List only the reviews that are waiting on you."),
+		involved: flagSet.Bool("involved", false, "List only the reviews that you requested or are a reviewer on."),
+		watched:  flagSet.Bool("watched", false, "List only the reviews that you are watching."),
+		rejected: flagSet.Bool("rejected", false, "List only the reviews that a reviewer's latest vote rejects."),
+		category: flagSet.String("category", "", "List only the reviews rejected with this category; can only be used with the --rejected flag"),
+		labels:   &stringList{},
+	}
+	flagSet.Var(filter.labels, "label", "List only the reviews with this label; may be repeated to require several labels")
+	return filter
+}
+
+// build returns a function that reports whether a review matches all of the filter's flags.
+func (filter reviewFilterFlags) build(repo repository.Repo) (func(review.Review) bool, error) {
+	if *filter.category != "" && !*filter.rejected {
+		return nil, errors.New("The --category flag can only be used with the --rejected flag.")
+	}
+	var filters []func(review.Review) bool
+	var userEmail string
+	if *filter.mine || *filter.involved || *filter.watched {
+		var err error
+		userEmail, err = repo.GetUserEmail()
+		if err != nil || userEmail == "" {
+			return nil, errors.New("Unable to determine your identity for the --mine, --involved, and --watched flags; " +
+				"set it with \"git config user.email <email>\".")
+		}
+	}
+	if *filter.mine {
+		filters = append(filters, func(r review.Review) bool {
+			return r.NeedsAttentionFrom(userEmail)
+		})
+	}
+	if *filter.involved {
+		filters = append(filters, func(r review.Review) bool {
+			return isInvolved(r, userEmail)
+		})
+	}
+	if *filter.watched {
+		watched, err := review.ListWatched(repo, userEmail)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, func(r review.Review) bool {
+			return watched[r.Revision]
+		})
+	}
+	if *filter.rejected {
+		category := *filter.category
+		filters = append(filters, func(r review.Review) bool {
+			return r.IsRejected(category)
+		})
+	}
+	if labels := *filter.labels; len(labels) > 0 {
+		filters = append(filters, func(r review.Review) bool {
+			return r.HasLabels(labels)
+		})
+	}
+	if !*filter.all {
+		filters = append(filters, func(r review.Review) bool {
+			return r.IsOpen()
+		})
+	}
+	return func(r review.Review) bool {
+		for _, filter := range filters {
+			if !filter(r) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// reviewPage is the JSON output of the list command: a single page of the matching reviews.
+type reviewPage struct {
+	Total   int            `json:"total"`
+	Offset  int            `json:"offset"`
+	Reviews []listedReview `json:"reviews"`
+}
+
+// listedReview is a review in the JSON output of the list command.
+//
+// Its CI and analyses reports are only included when asked for, and only then are the
+// notes of its latest analyses fetched, as that requires a request to the analyses' URL.
+type listedReview struct {
+	review.Review
+	AnalysesNotes []analyses.Note `json:"analysesNotes,omitempty"`
+	AnalysesError string          `json:"analysesError,omitempty"`
+}
+
+// newListedReview returns the JSON output of the list command for a single review.
+func newListedReview(r review.Review, includeCI, includeAnalyses bool) listedReview {
+	if !includeCI {
+		r.Reports = nil
+	}
+	if !includeAnalyses {
+		r.Analyses = nil
+		return listedReview{Review: r}
+	}
+	listed := listedReview{Review: r}
+	if len(r.Analyses) > 0 {
+		notes, err := r.GetAnalysesNotes()
+		if err != nil {
+			listed.AnalysesError = err.Error()
+		}
+		listed.AnalysesNotes = notes
+	}
+	return listed
+}
+
+// paginate returns the page of the given reviews starting at the given offset, and holding
+// at most the given number of reviews (or all of the remaining ones if the limit is 0).
+func paginate(reviews []review.Review, offset, limit int) []review.Review {
+	if offset >= len(reviews) {
+		return []review.Review{}
+	}
+	page := reviews[offset:]
+	if limit > 0 && limit < len(page) {
+		page = page[:limit]
+	}
+	return page
+}
+
+// isInvolved determines if the given user is either the requester of or a reviewer on the given review.
+func isInvolved(r review.Review, user string) bool {
+	if r.Request.Requester == user {
+		return true
+	}
+	for _, reviewer := range r.Request.Reviewers {
+		if reviewer == user {
+			return true
+		}
+	}
+	return false
+}
+
+// sortReviews sorts the given reviews, which are in order of revision, by the given key.
+func sortReviews(reviews []review.Review, key string) error {
+	var less func(a, b review.Review) bool
+	switch key {
+	case "", "revision":
+		return nil
+	case "timestamp":
+		less = func(a, b review.Review) bool {
+			aTime, _ := strconv.ParseInt(a.Request.Timestamp, 10, 64)
+			bTime, _ := strconv.ParseInt(b.Request.Timestamp, 10, 64)
+			return aTime > bTime
+		}
+	case "requester":
+		less = func(a, b review.Review) bool {
+			return a.Request.Requester < b.Request.Requester
+		}
+	default:
+		return fmt.Errorf("Unknown sort key %q; expected one of \"revision\", \"timestamp\", or \"requester\".", key)
+	}
+	sort.SliceStable(reviews, func(i, j int) bool {
+		return less(reviews[i], reviews[j])
+	})
+	return nil
+}
+
+// listOrphans lists the reviews whose commits can no longer be reached, and optionally prunes them.
+func listOrphans(repo repository.Repo) error {
+	orphans, err := review.FindOrphans(repo)
+	if err != nil {
+		return err
+	}
+	if *listJson {
+		if orphans == nil {
+			orphans = []review.Orphan{}
+		}
+		if err := output.PrintOrphansJson(orphans); err != nil {
+			return err
+		}
+	} else if *listPorcelain {
+		output.PrintPorcelainVersion()
+		for _, orphan := range orphans {
+			output.PrintPorcelainOrphan(orphan)
+		}
+	} else {
+		for _, orphan := range orphans {
+			output.PrintOrphan(orphan)
+		}
+		fmt.Printf("Found %d orphaned reviews\n", len(orphans))
+	}
+	if !*listPrune || len(orphans) == 0 {
+		return nil
+	}
+	if !*listYes {
+		if *listJson || *listPorcelain {
+			return errors.New("Pruning from scripts requires the --yes flag.")
+		}
+		if !confirm(fmt.Sprintf("Remove the notes of %d orphaned review(s)?", len(orphans))) {
+			return errors.New("Nothing was pruned.")
+		}
+	}
+	now := time.Now()
+	err = review.PruneOrphans(repo, orphans, func(notesRef string) string {
+		return gcBackupRef(now, notesRef)
+	})
+	if err != nil {
+		return err
+	}
+	if !*listJson && !*listPorcelain {
+		fmt.Printf("Pruned %d orphaned reviews; the previous notes are saved under %q\n", len(orphans),
+			fmt.Sprintf("%s%d/", gcBackupRefPrefix, now.Unix()))
+	}
+	return nil
+}
+
+// listProgress shows how many of the reviews have been read on stderr, if it is a
+// terminal and there are enough of them for that to be worth showing.
+type listProgress struct {
+	// width is the length of the progress line currently shown, or 0 if there is none.
+	width int
+}
+
+func (p *listProgress) update(parsed, total int) {
+	if total < listProgressThreshold {
+		return
+	}
+	if info, err := os.Stderr.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return
+	}
+	line := fmt.Sprintf("Reading reviews: %d/%d", parsed, total)
+	fmt.Fprintf(os.Stderr, "\r%s", line)
+	p.width = len(line)
+}
+
+// clear removes the progress line, so that it is not mixed up with the listed reviews.
+func (p *listProgress) clear() {
+	if p.width > 0 {
+		fmt.Fprintf(os.Stderr, "\r%s\r", strings.Repeat(" ", p.width))
+		p.width = 0
+	}
+}
+
+// printPageFooter prints which of the matching reviews were listed, if only some were requested.
+func printPageFooter(listed, total int) {
+	if *listLimit > 0 || *listOffset > 0 {
+		if listed == 0 {
+			fmt.Printf("Showing none of %d\n", total)
+		} else {
+			fmt.Printf("Showing %d-%d of %d\n", *listOffset+1, *listOffset+listed, total)
+		}
+	}
+}
+
 // listReviews lists all extant reviews.
+//
+// Unless the output is sorted or formatted as JSON, each review is printed as soon as it has
+// been read, so that nothing but the current review has to be held onto.
 // TODO(ojarjur): Add more flags for filtering the output (e.g. filtering by reviewer or status).
-func listReviews(repo repository.Repo, args []string) {
-	listFlagSet.Parse(args)
+func listReviews(repo repository.Repo, args []string) error {
+	*listFilter.labels = nil
+	if err := listFlagSet.Parse(args); err != nil {
+		return err
+	}
+	if *listRemote != "" {
+		remote, err := openRemoteRepo(repo, *listRemote)
+		if err != nil {
+			return err
+		}
+		repo = remote
+	}
+	if *listLimit < 0 || *listOffset < 0 {
+		return errors.New("The --limit and --offset flags cannot be negative.")
+	}
+	if countTrue(*listJson, *listPorcelain, *listFormat != "") > 1 {
+		return errors.New("Only one of --json, --porcelain, or --format is allowed.")
+	}
+	var format *template.Template
+	if *listFormat != "" {
+		var err error
+		if format, err = output.NewSummaryFormat(*listFormat); err != nil {
+			return fmt.Errorf("Invalid --format template: %v", err)
+		}
+	}
+	if *listSort != "" {
+		if err := sortReviews(nil, *listSort); err != nil {
+			return err
+		}
+	}
+	if (*listIncludeCI || *listIncludeAnalyses) && !*listJson {
+		fmt.Fprintln(os.Stderr, "Note: the --include-ci and --include-analyses flags only apply to the --json output, so they are ignored.")
+	}
+	if *listPrune && !*listOrphaned {
+		return errors.New("The --prune flag can only be used with the --orphaned flag.")
+	}
+	if *listPorcelain {
+		disableInteraction()
+	}
+	if err := setupColor(repo, *listColor); err != nil {
+		return err
+	}
+	if *listOrphaned {
+		return listOrphans(repo)
+	}
+	matches, err := listFilter.build(repo)
+	if err != nil {
+		return err
+	}
+	progress := &listProgress{}
+	defer progress.clear()
+	updateProgress := progress.update
+	if *listPorcelain {
+		updateProgress = nil
+		output.PrintPorcelainVersion()
+	}
+
+	if !*listJson && *listSort == "" {
+		total, listed := 0, 0
+		var formatErr error
+		review.WalkAllCached(repo, *listNoCache, func(r review.Review) {
+			if !matches(r) {
+				return
+			}
+			total++
+			if total > *listOffset && (*listLimit == 0 || listed < *listLimit) {
+				progress.clear()
+				if *listPorcelain {
+					output.PrintPorcelainSummary(&r)
+				} else if format != nil {
+					if err := output.PrintFormattedSummary(format, &r); err != nil && formatErr == nil {
+						formatErr = err
+					}
+				} else {
+					output.PrintSummary(&r)
+				}
+				listed++
+			}
+		}, updateProgress)
+		progress.clear()
+		if format != nil {
+			return formatErr
+		}
+		if *listPorcelain {
+			return nil
+		}
+		if *listAll {
+			fmt.Printf("Listed %d reviews\n", total)
+		} else {
+			fmt.Printf("Listed %d open reviews\n", total)
+		}
+		printPageFooter(listed, total)
+		return nil
+	}
+
 	var reviews []review.Review
+	review.WalkAllCached(repo, *listNoCache, func(r review.Review) {
+		if matches(r) {
+			reviews = append(reviews, r)
+		}
+	}, updateProgress)
+	progress.clear()
+	if err := sortReviews(reviews, *listSort); err != nil {
+		return err
+	}
+	page := paginate(reviews, *listOffset, *listLimit)
+	if *listJson {
+		listed := make([]listedReview, 0, len(page))
+		for _, r := range page {
+			listed = append(listed, newListedReview(r, *listIncludeCI, *listIncludeAnalyses))
+		}
+		jsonBytes, err := json.MarshalIndent(reviewPage{
+			Total:   len(reviews),
+			Offset:  *listOffset,
+			Reviews: listed,
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonBytes))
+		return nil
+	}
+	if *listPorcelain {
+		for _, r := range page {
+			output.PrintPorcelainSummary(&r)
+		}
+		return nil
+	}
+	if format != nil {
+		for _, r := range page {
+			if err := output.PrintFormattedSummary(format, &r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	if *listAll {
-		reviews = review.ListAll(repo)
 		fmt.Printf("Loaded %d reviews:\n", len(reviews))
 	} else {
-		reviews = review.ListOpen(repo)
 		fmt.Printf("Loaded %d open reviews:\n", len(reviews))
 	}
-	for _, r := range reviews {
+	for _, r := range page {
 		output.PrintSummary(&r)
 	}
+	printPageFooter(len(page), len(reviews))
+	return nil
 }
 
 // listCmd defines the "list" subcommand.
@@ -54,7 +485,7 @@ var listCmd = &Command{
 		listFlagSet.PrintDefaults()
 	},
 	RunMethod: func(repo repository.Repo, args []string) error {
-		listReviews(repo, args)
-		return nil
+		return listReviews(repo, args)
 	},
+	Flags: listFlagSet,
 }
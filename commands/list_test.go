@@ -0,0 +1,207 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/analyses"
+	"github.com/google/git-appraise/review/ci"
+	"github.com/google/git-appraise/review/reviewtest"
+	"github.com/google/git-appraise/server"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPaginate(t *testing.T) {
+	reviews := []review.Review{{Revision: "A"}, {Revision: "B"}, {Revision: "C"}}
+	for _, testCase := range []struct {
+		offset, limit int
+		expected      string
+	}{
+		{0, 0, "ABC"},
+		{0, 2, "AB"},
+		{1, 0, "BC"},
+		{1, 1, "B"},
+		{2, 5, "C"},
+		{3, 1, ""},
+		{7, 0, ""},
+	} {
+		page := paginate(reviews, testCase.offset, testCase.limit)
+		revisions := ""
+		for _, r := range page {
+			revisions += r.Revision
+		}
+		if revisions != testCase.expected || page == nil {
+			t.Errorf("Unexpected page for offset %d and limit %d: %q", testCase.offset, testCase.limit, revisions)
+		}
+	}
+}
+
+func TestNewListedReview(t *testing.T) {
+	analysesServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"analyze_response":[{"note":[{"category":"lint","description":"Unused variable"}]}]}`)
+	}))
+	defer analysesServer.Close()
+	r := review.Review{
+		Revision: "A",
+		Reports:  []ci.Report{{Timestamp: "1", Status: "success"}},
+		Analyses: []analyses.Report{{Timestamp: "1", URL: analysesServer.URL}},
+	}
+	for _, testCase := range []struct {
+		includeCI, includeAnalyses bool
+		expected                   []string
+		unexpected                 []string
+	}{
+		{false, false, nil, []string{`"reports"`, `"analyses"`, `"analysesNotes"`}},
+		{true, false, []string{`"reports"`, `"success"`}, []string{`"analyses"`, `"analysesNotes"`}},
+		{false, true, []string{`"analyses"`, `"analysesNotes"`, `"Unused variable"`}, []string{`"reports"`}},
+	} {
+		jsonBytes, err := json.Marshal(newListedReview(r, testCase.includeCI, testCase.includeAnalyses))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range testCase.expected {
+			if !strings.Contains(string(jsonBytes), expected) {
+				t.Errorf("The output with CI %v and analyses %v does not contain %s: %s", testCase.includeCI, testCase.includeAnalyses, expected, jsonBytes)
+			}
+		}
+		for _, unexpected := range testCase.unexpected {
+			if strings.Contains(string(jsonBytes), unexpected) {
+				t.Errorf("The output with CI %v and analyses %v contains %s: %s", testCase.includeCI, testCase.includeAnalyses, unexpected, jsonBytes)
+			}
+		}
+		if !strings.Contains(string(jsonBytes), `"revision":"A"`) {
+			t.Errorf("The output does not contain the review: %s", jsonBytes)
+		}
+	}
+}
+
+func TestSortReviews(t *testing.T) {
+	newReview := func(revision, timestamp, requester string) review.Review {
+		r := review.Review{Revision: revision}
+		r.Request.Timestamp = timestamp
+		r.Request.Requester = requester
+		return r
+	}
+	for key, expected := range map[string]string{
+		"":          "ABC",
+		"revision":  "ABC",
+		"timestamp": "CAB",
+		"requester": "BAC",
+	} {
+		reviews := []review.Review{newReview("A", "200", "bob"), newReview("B", "100", "alice"), newReview("C", "300", "bob")}
+		if err := sortReviews(reviews, key); err != nil {
+			t.Fatal(err)
+		}
+		revisions := ""
+		for _, r := range reviews {
+			revisions += r.Revision
+		}
+		if revisions != expected {
+			t.Errorf("Unexpected order when sorting by %q: %q", key, revisions)
+		}
+	}
+	if err := sortReviews(nil, "size"); err == nil {
+		t.Error("Unexpectedly sorted by an unknown key")
+	}
+}
+
+func TestListOrphaned(t *testing.T) {
+	repo := repository.NewRepoWithHistory(map[string][]string{
+		"master":  {"A"},
+		"feature": {"A", "B"},
+	})
+	revision, err := reviewtest.AddReview(repo, "refs/heads/feature", "refs/heads/master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orphans, err := review.FindOrphans(repo); err != nil || len(orphans) != 0 {
+		t.Fatalf("Unexpected orphans before the branch was rewritten: %v, %v", orphans, err)
+	}
+
+	// Rewrite the branch, so that the reviewed commit is no longer reachable.
+	repo.SetRef("refs/heads/feature", repo.AddCommit("", "Rewritten feature commit", nil, "A"))
+	orphans, err := review.FindOrphans(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 || orphans[0].Revision != revision || orphans[0].Reason != review.OrphanUnreachable {
+		t.Fatalf("Unexpected orphans: %v", orphans)
+	}
+
+	defer func() {
+		*listOrphaned, *listPrune, *listYes = false, false, false
+		confirmInput = os.Stdin
+	}()
+	if err := listReviews(repo, []string{"-prune"}); err == nil {
+		t.Fatal("Unexpectedly allowed --prune without --orphaned")
+	}
+	confirmInput = strings.NewReader("n\n")
+	if err := listReviews(repo, []string{"-orphaned", "-prune"}); err == nil {
+		t.Fatal("Unexpectedly pruned without confirmation")
+	}
+	if r, err := review.Get(repo, revision); err != nil || r == nil {
+		t.Fatalf("The orphaned review was pruned without confirmation: %v", err)
+	}
+	confirmInput = strings.NewReader("y\n")
+	if err := listReviews(repo, []string{"-orphaned", "-prune"}); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := review.Get(repo, revision); err != nil || r != nil {
+		t.Fatalf("The orphaned review was not pruned: %v, %v", r, err)
+	}
+}
+
+func TestListFormat(t *testing.T) {
+	repo := repository.NewMemoryRepoForTest()
+	defer func() { *listFormat, *listJson = "", false }()
+	if err := listReviews(repo, []string{"-format", "{{.Revision"}); err == nil {
+		t.Fatal("Unexpectedly accepted a malformed template")
+	}
+	if err := listReviews(repo, []string{"-format", "{{.Revision}}", "-json"}); err == nil {
+		t.Fatal("Unexpectedly allowed both --format and --json")
+	}
+	*listJson = false
+	if err := listReviews(repo, []string{"-format", "{{.Revision}}\t{{.Status}}\t{{.OpenComments}}"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListRemote(t *testing.T) {
+	testServer := httptest.NewServer(server.New(repository.NewMemoryRepoForTest(), time.Minute))
+	defer testServer.Close()
+	defer func() { *listRemote, *showRemote = "", "" }()
+	if !UsesRemoteRepo("list", []string{"-a", "--remote-url=" + testServer.URL}) || UsesRemoteRepo("accept", []string{"--remote-url", testServer.URL}) {
+		t.Fatal("Unexpected commands found to use a remote repository")
+	}
+	if err := listReviews(nil, []string{"-a", "-remote-url", testServer.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if err := showReview(nil, []string{"-remote-url", testServer.URL, repository.TestCommitG}); err != nil {
+		t.Fatal(err)
+	}
+	if err := showReview(nil, []string{"-remote-url", testServer.URL}); err == nil {
+		t.Fatal("Unexpectedly showed the current review of a remote repository")
+	}
+}
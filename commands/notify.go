@@ -0,0 +1,276 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// notifyCursorFile is the name of the file (inside of the git directory) that records
+// the latest comments that the notify command has reported.
+const notifyCursorFile = "appraise-notify"
+
+// notifyEmailConfigKey is the config key holding the address that notification emails are
+// sent to, in place of the user's own email address.
+const notifyEmailConfigKey = "appraise.notifyEmail"
+
+var notifyFlagSet = flag.NewFlagSet("notify", flag.ContinueOnError)
+
+var (
+	notifySendmail        = notifyFlagSet.Bool("sendmail", false, "Send an email per review, rather than printing a summary")
+	notifySendmailCommand = notifyFlagSet.String("sendmail-command", "sendmail -t -oi", "Shell command that the emails are piped to, with their recipients in the headers")
+	notifyTo              = notifyFlagSet.String("to", "", "Address to send the emails to (default from the "+notifyEmailConfigKey+" setting, or else your user.email)")
+)
+
+// notification is a single comment that the current user should hear about.
+type notification struct {
+	hash   string
+	thread review.CommentThread
+	// reason explains how the comment is addressed to the user.
+	reason    string
+	timestamp int64
+}
+
+// notifyCursor records the latest comments that have been reported.
+type notifyCursor struct {
+	Timestamp int64 `json:"timestamp"`
+	// Seen holds the hashes of the comments reported with exactly that timestamp, since
+	// more comments with the same timestamp may still be pulled.
+	Seen []string `json:"seen,omitempty"`
+}
+
+// isNew determines if a comment with the given hash and timestamp has not been reported yet.
+func (cursor notifyCursor) isNew(hash string, timestamp int64) bool {
+	if timestamp != cursor.Timestamp {
+		return timestamp > cursor.Timestamp
+	}
+	for _, seen := range cursor.Seen {
+		if seen == hash {
+			return false
+		}
+	}
+	return true
+}
+
+// advance records that the comment with the given hash and timestamp has been reported.
+func (cursor *notifyCursor) advance(hash string, timestamp int64) {
+	if timestamp > cursor.Timestamp {
+		cursor.Timestamp, cursor.Seen = timestamp, nil
+	}
+	if timestamp == cursor.Timestamp {
+		cursor.Seen = append(cursor.Seen, hash)
+	}
+}
+
+// mentions determines if the given text mentions the given user, either by their
+// email address, or as "@" followed by the part of the address before the "@".
+func mentions(text, user string) bool {
+	if strings.Contains(text, user) {
+		return true
+	}
+	name := user
+	if at := strings.Index(user, "@"); at > 0 {
+		name = user[:at]
+	}
+	for _, field := range strings.FieldsFunc(text, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == '\n' || r == ',' || r == ':' || r == ';' || r == '(' || r == ')'
+	}) {
+		if strings.TrimRight(field, ".!?") == "@"+name {
+			return true
+		}
+	}
+	return false
+}
+
+// findNotifications returns the comments in the given review that have not been reported
+// according to the given cursor, that were made by someone else, and that are addressed
+// to the given user, oldest first.
+//
+// Those are the comments that mention the user, that reply to one of the user's comments,
+// or that are on a review that the user requested or is watching.
+func findNotifications(r review.Review, user string, watching bool, cursor notifyCursor) []notification {
+	var notifications []notification
+	var visit func(threads []review.CommentThread, parentAuthor string)
+	visit = func(threads []review.CommentThread, parentAuthor string) {
+		for _, thread := range threads {
+			c := thread.Comment
+			visit(thread.Children, c.Author)
+			timestamp, err := strconv.ParseInt(c.Timestamp, 10, 64)
+			if err != nil || !cursor.isNew(thread.Hash, timestamp) || c.Author == user {
+				continue
+			}
+			var reason string
+			if mentions(c.Description, user) {
+				reason = "mentions you"
+			} else if parentAuthor == user {
+				reason = "replies to you"
+			} else if r.Request.Requester == user {
+				reason = "on your review"
+			} else if watching {
+				reason = "on a review you watch"
+			} else {
+				continue
+			}
+			notifications = append(notifications, notification{thread.Hash, thread, reason, timestamp})
+		}
+	}
+	visit(r.Comments, "")
+	sort.SliceStable(notifications, func(i, j int) bool {
+		return notifications[i].timestamp < notifications[j].timestamp
+	})
+	return notifications
+}
+
+// firstLine returns the first line of the given text.
+func firstLine(text string) string {
+	return strings.SplitN(strings.TrimSpace(text), "\n", 2)[0]
+}
+
+// formatNotifications returns the summary of the given notifications on a single review.
+func formatNotifications(r review.Review, notifications []notification) string {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "review %.12s: %s\n", r.Revision, firstLine(r.Request.Description))
+	for _, n := range notifications {
+		fmt.Fprintf(&buffer, "  comment %.12s by %s (%s):\n", n.hash, n.thread.Comment.Author, n.reason)
+		for _, line := range strings.Split(strings.TrimSpace(n.thread.Comment.Description), "\n") {
+			fmt.Fprintf(&buffer, "    %s\n", line)
+		}
+	}
+	return buffer.String()
+}
+
+// formatNotificationEmail returns an email, with headers, of the given notifications on a single review,
+// addressed to the given recipient.
+func formatNotificationEmail(recipient string, r review.Review, notifications []notification) string {
+	return fmt.Sprintf("To: %s\nSubject: [git-appraise] %d new comment(s) on review %.12s: %s\n"+
+		"Content-Type: text/plain; charset=UTF-8\n\n%s",
+		recipient, len(notifications), r.Revision, firstLine(r.Request.Description), formatNotifications(r, notifications))
+}
+
+// sendNotificationEmail pipes the given email to the sendmail command.
+func sendNotificationEmail(email string) error {
+	cmd := shellCommand(*notifySendmailCommand)
+	cmd.Stdin = strings.NewReader(email)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Failed to send the notification email with %q: %v", *notifySendmailCommand, err)
+	}
+	return nil
+}
+
+// readNotifyCursor returns the cursor of the comments reported, which is empty if none have been.
+func readNotifyCursor(cursorPath string) (notifyCursor, error) {
+	var cursor notifyCursor
+	cursorBytes, err := ioutil.ReadFile(cursorPath)
+	if os.IsNotExist(err) {
+		return cursor, nil
+	} else if err != nil {
+		return cursor, err
+	}
+	if err := json.Unmarshal(cursorBytes, &cursor); err != nil {
+		return cursor, fmt.Errorf("Failed to parse the notify cursor %q: %v", cursorPath, err)
+	}
+	return cursor, nil
+}
+
+// notify reports the comments addressed to the current user since the last time it was run.
+//
+// This only reads the review data; the only thing it writes is the cursor, which is only
+// advanced once every notification has been printed or sent, and not at all in a dry run.
+func notify(repo repository.Repo, args []string) error {
+	if err := notifyFlagSet.Parse(args); err != nil {
+		return err
+	}
+	if len(notifyFlagSet.Args()) > 0 {
+		return errors.New("The notify command does not take any arguments.")
+	}
+	userEmail, err := repo.GetUserEmail()
+	if err != nil || userEmail == "" {
+		return errors.New("Unable to determine your identity; set it with \"git config user.email <email>\".")
+	}
+	gitDir, err := repo.GetGitDir()
+	if err != nil {
+		return err
+	}
+	cursorPath := filepath.Join(gitDir, notifyCursorFile)
+	cursor, err := readNotifyCursor(cursorPath)
+	if err != nil {
+		return err
+	}
+	watched, err := review.ListWatched(repo, userEmail)
+	if err != nil {
+		return err
+	}
+	recipient := *notifyTo
+	if recipient == "" {
+		recipient = repository.GetConfigValue(repo, notifyEmailConfigKey, userEmail)
+	}
+
+	reported := 0
+	latest := notifyCursor{Timestamp: cursor.Timestamp, Seen: append([]string(nil), cursor.Seen...)}
+	for _, r := range review.ListAllCached(repo, false) {
+		notifications := findNotifications(r, userEmail, watched[r.Revision], cursor)
+		if len(notifications) == 0 {
+			continue
+		}
+		if *notifySendmail {
+			if err := sendNotificationEmail(formatNotificationEmail(recipient, r, notifications)); err != nil {
+				return err
+			}
+		} else {
+			fmt.Print(formatNotifications(r, notifications))
+		}
+		for _, n := range notifications {
+			latest.advance(n.hash, n.timestamp)
+		}
+		reported += len(notifications)
+	}
+	if reported == 0 || repository.IsRecordOnly(repo) {
+		// A dry run reports the same comments again next time.
+		return nil
+	}
+	cursorBytes, err := json.Marshal(latest)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cursorPath, cursorBytes, 0644)
+}
+
+// notifyCmd defines the "notify" subcommand.
+var notifyCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s notify [<option>...]\n\nOptions:\n", arg0)
+		notifyFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return notify(repo, args)
+	},
+	Flags: notifyFlagSet,
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/reviewtest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMentions(t *testing.T) {
+	for text, expected := range map[string]bool{
+		"Could alice@example.com take a look?": true,
+		"@alice, what do you think?":           true,
+		"Thanks @alice.":                       true,
+		"Ask @alicia instead":                  false,
+		"Nothing to see here":                  false,
+	} {
+		if mentioned := mentions(text, "alice@example.com"); mentioned != expected {
+			t.Errorf("Unexpected result for %q: got %v, want %v", text, mentioned, expected)
+		}
+	}
+}
+
+func TestFindNotifications(t *testing.T) {
+	newThread := func(hash, author, timestamp, description string, children ...review.CommentThread) review.CommentThread {
+		return review.CommentThread{
+			Hash:     hash,
+			Comment:  comment.Comment{Author: author, Timestamp: timestamp, Description: description},
+			Children: children,
+		}
+	}
+	r := review.Review{Revision: "R"}
+	r.Request.Requester = "bob@example.com"
+	r.Comments = []review.CommentThread{
+		newThread("old", "carol@example.com", "0000000001", "@alice, an old mention"),
+		newThread("mine", "alice@example.com", "0000000002", "A comment by alice",
+			newThread("reply", "bob@example.com", "0000000004", "A reply to alice")),
+		newThread("mention", "carol@example.com", "0000000003", "What does @alice think?"),
+		newThread("other", "carol@example.com", "0000000005", "Not for alice"),
+	}
+	reasons := func(notifications []notification) []string {
+		var reasons []string
+		for _, n := range notifications {
+			reasons = append(reasons, n.hash+": "+n.reason)
+		}
+		return reasons
+	}
+	expected := []string{"mention: mentions you", "reply: replies to you"}
+	if got := reasons(findNotifications(r, "alice@example.com", false, notifyCursor{Timestamp: 1, Seen: []string{"old"}})); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Unexpected notifications: got %q, want %q", got, expected)
+	}
+	expected = []string{"mention: mentions you", "reply: replies to you", "other: on a review you watch"}
+	if got := reasons(findNotifications(r, "alice@example.com", true, notifyCursor{Timestamp: 1, Seen: []string{"old"}})); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Unexpected notifications for a watched review: got %q, want %q", got, expected)
+	}
+	expected = []string{"mention: on your review", "other: on your review"}
+	if got := reasons(findNotifications(r, "bob@example.com", false, notifyCursor{Timestamp: 2, Seen: []string{"mine"}})); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Unexpected notifications for the requester: got %q, want %q", got, expected)
+	}
+
+	// Comments with the same timestamp as the latest one reported may still be new.
+	expected = []string{"mention: mentions you", "reply: replies to you"}
+	cursor := notifyCursor{Timestamp: 3, Seen: []string{"other-mention"}}
+	if got := reasons(findNotifications(r, "alice@example.com", false, cursor)); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Unexpected notifications with the same timestamp: got %q, want %q", got, expected)
+	}
+	cursor.advance("mention", 3)
+	if got := findNotifications(r, "alice@example.com", false, cursor); len(got) != 1 || got[0].hash != "reply" {
+		t.Fatalf("Unexpected notifications after advancing the cursor: %q", reasons(got))
+	}
+
+	email := formatNotificationEmail("alice@example.com", r, findNotifications(r, "alice@example.com", false, notifyCursor{Timestamp: 1, Seen: []string{"old"}}))
+	if !strings.HasPrefix(email, "To: alice@example.com\nSubject: [git-appraise] 2 new comment(s) on review R") ||
+		!strings.Contains(email, "\n\nreview R: \n  comment mention by carol@example.com (mentions you):\n    What does @alice think?\n") {
+		t.Fatalf("Unexpected notification email: %q", email)
+	}
+}
+
+func TestNotifyDryRunKeepsCursor(t *testing.T) {
+	repo, r, dir := newWatchTestRepo(t)
+	defer os.RemoveAll(dir)
+	repo.SetConfigValue("user.email", "alice@example.com")
+	if _, err := reviewtest.AddComment(repo, r.Revision, comment.Comment{
+		Author: "carol@example.com", Timestamp: "0000000003", Description: "What does @alice think?",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cursorPath := filepath.Join(dir, ".git", notifyCursorFile)
+	for _, dryRun := range []bool{true, false} {
+		run := notifyCmd.Run
+		if dryRun {
+			run = notifyCmd.DryRun
+		}
+		out, err := captureStdout(func() error { return run(repo, nil) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "What does @alice think?") {
+			t.Errorf("The comment was not reported (dry run: %v):\n%s", dryRun, out)
+		}
+		if _, err := os.Stat(cursorPath); os.IsNotExist(err) != dryRun {
+			t.Errorf("Unexpected notify cursor (dry run: %v): %v", dryRun, err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/ci"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// ciTemplate renders each CI report of a review in place of the default build status, or is
+// nil if the default should be used.
+var ciTemplate *template.Template
+
+// ciTemplateFuncs are the functions available to CI templates, in addition to the built-in ones.
+var ciTemplateFuncs = template.FuncMap{
+	// date formats a timestamp, such as that of a report, as a human readable date.
+	"date": reformatTimestamp,
+}
+
+// SetCITemplate sets the Go text/template used to render each of the CI reports of a review,
+// with access to the Status, URL, Agent, and Timestamp fields of the report.
+//
+// An empty template restores the default rendering.
+func SetCITemplate(text string) error {
+	if text == "" {
+		ciTemplate = nil
+		return nil
+	}
+	tmpl, err := template.New("ci").Funcs(ciTemplateFuncs).Parse(text)
+	if err != nil {
+		return err
+	}
+	ciTemplate = tmpl
+	return nil
+}
+
+// printBuildStatus prints the build status of a review, either as the latest CI report, or
+// with every CI report rendered by the CI template if one is set.
+func printBuildStatus(r *review.Review) {
+	if ciTemplate == nil {
+		fmt.Printf(buildStatusTemplate, r.GetBuildStatusMessage())
+		return
+	}
+	fmt.Println("  build status:")
+	for _, rendered := range renderCIReports(r.Reports) {
+		fmt.Printf("    %s\n", strings.Replace(rendered, "\n", "\n    ", -1))
+	}
+}
+
+// renderCIReports renders the given reports, oldest first, with the CI template.
+func renderCIReports(reports []ci.Report) []string {
+	reports = append([]ci.Report{}, reports...)
+	sort.SliceStable(reports, func(i, j int) bool {
+		iTime, _ := strconv.ParseInt(reports[i].Timestamp, 10, 64)
+		jTime, _ := strconv.ParseInt(reports[j].Timestamp, 10, 64)
+		return iTime < jTime
+	})
+	var results []string
+	for _, report := range reports {
+		var rendered bytes.Buffer
+		if err := ciTemplate.Execute(&rendered, report); err != nil {
+			results = append(results, fmt.Sprintf("failed to render the CI report: %v", err))
+			continue
+		}
+		results = append(results, strings.TrimRight(rendered.String(), "\n"))
+	}
+	return results
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"github.com/google/git-appraise/review/ci"
+	"reflect"
+	"testing"
+)
+
+func TestCITemplate(t *testing.T) {
+	defer SetCITemplate("")
+	if err := SetCITemplate("{{.Status"); err == nil {
+		t.Fatal("Unexpectedly accepted a malformed CI template")
+	}
+	if err := SetCITemplate("{{.Agent}}: {{.Status}} {{.URL}}\n"); err != nil {
+		t.Fatal(err)
+	}
+	reports := []ci.Report{
+		{Timestamp: "20", Agent: "bot", Status: ci.StatusFailure, URL: "http://ci/2"},
+		{Timestamp: "10", Agent: "bot", Status: ci.StatusSuccess, URL: "http://ci/1"},
+	}
+	expected := []string{"bot: success http://ci/1", "bot: failure http://ci/2"}
+	if rendered := renderCIReports(reports); !reflect.DeepEqual(rendered, expected) {
+		t.Errorf("Unexpected rendering of the CI reports: got %q, want %q", rendered, expected)
+	}
+	if err := SetCITemplate(""); err != nil || ciTemplate != nil {
+		t.Errorf("The default rendering was not restored: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ColorConfigKey is the config key that controls whether the output is colored: one of
+// ColorAuto, ColorAlways, or ColorNever.
+const ColorConfigKey = "appraise.color"
+
+// The possible modes for coloring the output.
+const (
+	// ColorAuto colors the output only if it is written to a terminal, and the NO_COLOR
+	// environment variable is not set.
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+// ANSI escape sequences for the colors used in the output.
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+)
+
+// colorEnabled is set if the human readable output should be colored.
+//
+// The JSON and porcelain output are never colored.
+var colorEnabled = false
+
+// isTerminal returns whether the given file is a terminal.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// SetColor turns the coloring of the output on or off, according to the given mode.
+func SetColor(mode string) error {
+	switch mode {
+	case ColorAlways:
+		colorEnabled = true
+	case ColorNever:
+		colorEnabled = false
+	case ColorAuto:
+		// See https://no-color.org/ for the NO_COLOR convention.
+		colorEnabled = os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+	default:
+		return fmt.Errorf("Unknown color mode %q; expected one of %q, %q, or %q.", mode, ColorAuto, ColorAlways, ColorNever)
+	}
+	return nil
+}
+
+// colorize wraps the given text in the given color, if the output is colored.
+func colorize(color, text string) string {
+	if !colorEnabled || text == "" {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// statusColors maps the statuses of reviews, sign-offs, comments, and dependencies to their colors.
+var statusColors = map[string]string{
+	"accepted":    colorGreen,
+	"submitted":   colorGreen,
+	"lgtm":        colorGreen,
+	"rejected":    colorRed,
+	"danger":      colorRed,
+	"needs work":  colorRed,
+	"missing":     colorRed,
+	"pending":     colorYellow,
+	"conditional": colorYellow,
+	"incomplete":  colorYellow,
+	"tbr":         colorYellow,
+	"open":        colorYellow,
+	"abandoned":   colorYellow,
+}
+
+// colorizeStatus colors the given status by what it means: green for the ones that need no
+// further action, red for the ones that block the review, and yellow for the ones still pending.
+//
+// Only the leading word (or words) of the status that are known are colored.
+func colorizeStatus(status string) string {
+	for known, color := range statusColors {
+		if status == known || strings.HasPrefix(status, known+" ") {
+			return colorize(color, known) + status[len(known):]
+		}
+	}
+	return status
+}
+
+// colorizeDiff colors the given diff like git does: the file headers in bold, the hunk
+// headers in cyan, and the added and removed lines in green and red.
+func colorizeDiff(diff string) string {
+	if !colorEnabled {
+		return diff
+	}
+	lines := strings.Split(diff, "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "diff "), strings.HasPrefix(line, "index "),
+			strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "+++ "):
+			lines[i] = colorize(colorBold, line)
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = colorize(colorCyan, line)
+		case strings.HasPrefix(line, "+"):
+			lines[i] = colorize(colorGreen, line)
+		case strings.HasPrefix(line, "-"):
+			lines[i] = colorize(colorRed, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// PrintRawDiff prints the given diff, colored if the output is.
+func PrintRawDiff(diff string) {
+	fmt.Println(colorizeDiff(diff))
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSetColor(t *testing.T) {
+	defer SetColor(ColorNever)
+	if err := SetColor("sometimes"); err == nil {
+		t.Fatal("Unexpectedly accepted an unknown color mode")
+	}
+	if err := SetColor(ColorAlways); err != nil || !colorEnabled {
+		t.Fatalf("The output is not colored with %q: %v", ColorAlways, err)
+	}
+	// The tests never write to a terminal, so the output is not colored by default.
+	if err := SetColor(ColorAuto); err != nil || colorEnabled {
+		t.Fatalf("The output is colored with %q when it is not a terminal: %v", ColorAuto, err)
+	}
+	os.Setenv("NO_COLOR", "1")
+	defer os.Unsetenv("NO_COLOR")
+	if err := SetColor(ColorAlways); err != nil || !colorEnabled {
+		t.Fatalf("NO_COLOR overrode an explicit %q: %v", ColorAlways, err)
+	}
+}
+
+func TestColorize(t *testing.T) {
+	defer SetColor(ColorNever)
+	SetColor(ColorNever)
+	if status := colorizeStatus("accepted at now"); status != "accepted at now" {
+		t.Fatalf("Uncolored output has a colored status: %q", status)
+	}
+	SetColor(ColorAlways)
+	for status, expected := range map[string]string{
+		"accepted at now":    colorGreen + "accepted" + colorReset + " at now",
+		"needs work":         colorRed + "needs work" + colorReset,
+		"lgtm for \"tests\"": colorGreen + "lgtm" + colorReset + " for \"tests\"",
+		"fyi":                "fyi",
+	} {
+		if colored := colorizeStatus(status); colored != expected {
+			t.Errorf("Unexpected colored status for %q: got %q, want %q", status, colored, expected)
+		}
+	}
+	diff := "diff --git a/f b/f\n--- a/f\n+++ b/f\n@@ -1 +1 @@\n-old\n+new\n same"
+	expected := colorBold + "diff --git a/f b/f" + colorReset + "\n" + colorBold + "--- a/f" + colorReset + "\n" +
+		colorBold + "+++ b/f" + colorReset + "\n" + colorCyan + "@@ -1 +1 @@" + colorReset + "\n" +
+		colorRed + "-old" + colorReset + "\n" + colorGreen + "+new" + colorReset + "\n same"
+	if colored := colorizeDiff(diff); colored != expected {
+		t.Errorf("Unexpected colored diff: got %q, want %q", colored, expected)
+	}
+}
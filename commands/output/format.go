@@ -0,0 +1,111 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/ci"
+	"io/ioutil"
+	"strings"
+	"text/template"
+)
+
+// SummaryFields are the fields of a review that the templates given to NewSummaryFormat can use.
+type SummaryFields struct {
+	Revision    string
+	Requester   string
+	Reviewers   []string
+	Description string
+	ReviewRef   string
+	TargetRef   string
+	Timestamp   string
+	// Status is the status shown in the summary of the review, such as "pending" or "accepted".
+	Status string
+	// Resolved is set if the review has been accepted.
+	Resolved bool
+	// OpenComments is the number of comment threads that have unaddressed comments.
+	OpenComments int
+	// CIStatus is the status of the latest CI report, or empty if there is none.
+	CIStatus string
+	Labels   []string
+}
+
+// getSummaryFields returns the fields of the given review for a summary template.
+func getSummaryFields(r *review.Review) SummaryFields {
+	fields := SummaryFields{
+		Revision:    r.Revision,
+		Requester:   r.Request.Requester,
+		Reviewers:   r.Request.Reviewers,
+		Description: r.Request.Description,
+		ReviewRef:   r.Request.ReviewRef,
+		TargetRef:   r.Request.TargetRef,
+		Timestamp:   r.Request.Timestamp,
+		Status:      getStatusString(r),
+		Resolved:    r.Resolved != nil && *r.Resolved,
+		Labels:      r.Labels,
+	}
+	for _, thread := range r.Comments {
+		if thread.IsUnresolved() {
+			fields.OpenComments++
+		}
+	}
+	if report, err := ci.GetLatestCIReport(r.Reports); err == nil && report != nil {
+		fields.CIStatus = report.Status
+	}
+	return fields
+}
+
+// NewSummaryFormat parses a Go text/template for printing the summary of each review on a
+// single line, with access to the SummaryFields of the review.
+//
+// The template is also tried out on an empty review, so that references to unknown fields
+// are reported before anything is printed.
+func NewSummaryFormat(text string) (*template.Template, error) {
+	format, err := template.New("format").Funcs(template.FuncMap{
+		"date": reformatTimestamp,
+		"join": strings.Join,
+	}).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := format.Execute(ioutil.Discard, SummaryFields{}); err != nil {
+		return nil, err
+	}
+	return format, nil
+}
+
+// FormatSummary returns the summary of the given review using the given template.
+func FormatSummary(format *template.Template, r *review.Review) (string, error) {
+	var summary bytes.Buffer
+	if err := format.Execute(&summary, getSummaryFields(r)); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(summary.String(), "\n"), nil
+}
+
+// PrintFormattedSummary prints the summary of the given review using the given template,
+// on a line of its own.
+func PrintFormattedSummary(format *template.Template, r *review.Review) error {
+	summary, err := FormatSummary(format, r)
+	if err != nil {
+		return err
+	}
+	fmt.Println(summary)
+	return nil
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"bytes"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/ci"
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/request"
+	"testing"
+)
+
+func TestSummaryFormat(t *testing.T) {
+	for _, text := range []string{"{{.Revision", "{{.NoSuchField}}"} {
+		if _, err := NewSummaryFormat(text); err == nil {
+			t.Errorf("Unexpectedly accepted the invalid template %q", text)
+		}
+	}
+	format, err := NewSummaryFormat("{{.Revision}} {{.Requester}} {{.Status}} {{.Resolved}} {{.OpenComments}} {{.CIStatus}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, rejected := true, false
+	r := &review.Review{
+		Revision: "abc",
+		Request:  request.Request{Requester: "alice"},
+		Resolved: &accepted,
+		Comments: []review.CommentThread{
+			{Comment: comment.Comment{Description: "Fix this"}, Resolved: &rejected},
+			{Comment: comment.Comment{Description: "LGTM"}, Resolved: &accepted},
+		},
+		Reports: []ci.Report{{Timestamp: "1", Status: ci.StatusFailure}, {Timestamp: "2", Status: ci.StatusSuccess}},
+	}
+	var summary bytes.Buffer
+	if err := format.Execute(&summary, getSummaryFields(r)); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "abc alice accepted true 1 success"; summary.String() != expected {
+		t.Errorf("Unexpected summary: got %q, want %q", summary.String(), expected)
+	}
+}
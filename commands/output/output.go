@@ -18,26 +18,83 @@ limitations under the License.
 package output
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/comment"
 	"strconv"
 	"strings"
 	"time"
 )
 
 const (
-	// Template for printing the summary of a code review.
-	reviewSummaryTemplate = `[%s] %.12s
+	// Template for printing the summary of a code review, along with any notes about it, such
+	// as its labels, or that its commit is missing from a shallow clone.
+	reviewSummaryTemplate = `[%s] %.12s%s
   %s
 `
 	// Template for printing the summary of a code review.
 	reviewDetailsTemplate = `  %q -> %q
   reviewers: %q
   requester: %q
-  build status: %s
+  attention: %q
+`
+	// Template for printing the build status of a code review.
+	buildStatusTemplate = `  build status: %s
+`
+	// Template for marking a code review whose request has been amended.
+	editedTemplate = `  (edited %s)
+`
+	// Template for marking a code review that was superseded by another one.
+	supersededTemplate = `  (superseded by review %.12s)
+`
+	// Template for printing a single version of a code review's request.
+	requestVersionTemplate = `%d: %s %s by %q
+  %q -> %q
+  reviewers: %q
+  %s
+`
+	// Template for printing a single file changed by a code review, marked if it was viewed.
+	changedFileTemplate = `  %s %s%s
+`
+	// Template for printing a single reviewer's sign-off on a code review.
+	signOffTemplate = `    %q: %s
+`
+	// Template for printing a single review that a code review depends on.
+	dependencyTemplate = `    %.12s: %s
+`
+	// Template for printing a single warning from the analyses of a code review.
+	analysisNoteTemplate = `    [%s] %s
+`
+	// Template for printing a single item of a code review's checklist.
+	checklistItemTemplate = `    [%s] %s%s
+`
+	// Template for printing a single commit of a code review.
+	commitTemplate = `    %.12s %s (%s)
+`
+	// Template for printing a single version of a code review.
+	iterationTemplate = `%d: %.12s (%s)
 `
 	// Template for printing the location of an inline comment
 	commentLocationTemplate = `%s%q@%.12s
+`
+	// Template for marking an inline comment whose line has since been changed or removed
+	outdatedLocationTemplate = `%s(outdated; the line commented upon has since been changed or removed)
+`
+	// Template for marking an inline comment that is pinned to the commit it was made on
+	pinnedLocationTemplate = `%s(pinned to this commit of the review)
+`
+	// Template for marking an inline comment whose file has since been renamed
+	renamedLocationTemplate = `%s(file renamed from %s)
+`
+	// Template for marking an inline comment on a file that its commit does not have
+	missingFileLocationTemplate = `%s(the file does not exist in this commit)
+`
+	// Template for marking an inline comment whose commit is missing from a shallow clone
+	unavailableLocationTemplate = `%s(commit not available locally)
+`
+	// Template for printing the location of a comment on a submodule
+	submoduleLocationTemplate = `%ssubmodule %q@%.12s
 `
 	// Template for printing a single comment.
 	commentTemplate = `comment: %s
@@ -45,6 +102,21 @@ author: %s
 time:   %s
 status: %s
 %s`
+	// Template for printing a placeholder for a comment that was filtered out, but has replies that were not.
+	elidedCommentTemplate = `comment: %s (hidden by the filter)`
+	// Template for printing an attached link
+	attachmentURLTemplate = `
+attachment: %s`
+	// Template for printing an attached file
+	attachmentBlobTemplate = `
+attachment: %s (git cat-file blob %s)`
+	// Template for printing the reactions to a comment
+	reactionsTemplate = `
+reactions: %s`
+	// Template for printing a malformed note
+	noteErrorTemplate = `    %s@%.12s: %s
+      %q
+`
 	// Template for displaying the summary of the comment threads for a review
 	commentSummaryTemplate = `  comments (%d threads):
 `
@@ -52,9 +124,22 @@ status: %s
 	contextLineCount = 5
 )
 
+// reactionEmoji maps each supported reaction to the emoji used to display it.
+var reactionEmoji = map[string]string{
+	comment.ReactionThumbsUp:   "\U0001F44D",
+	comment.ReactionThumbsDown: "\U0001F44E",
+	comment.ReactionEyes:       "\U0001F440",
+}
+
+// reactionOrder is the order in which reactions are displayed.
+var reactionOrder = comment.Reactions
+
 // getStatusString returns a human friendly string encapsulating both the review's
 // resolved status, and its submitted status.
 func getStatusString(r *review.Review) string {
+	if !r.Submitted && r.Request.SupersededBy != "" {
+		return "abandoned"
+	}
 	if r.Resolved == nil && r.Submitted {
 		return "tbr"
 	}
@@ -70,14 +155,48 @@ func getStatusString(r *review.Review) string {
 	if r.Submitted {
 		return "danger"
 	}
+	if r.AwaitingResponse {
+		return "conditional"
+	}
+	if r.AwaitingChecklist {
+		return "incomplete"
+	}
 	return "rejected"
 }
 
 // PrintSummary prints a single-line summary of a review.
 func PrintSummary(r *review.Review) {
-	statusString := getStatusString(r)
+	statusString := colorizeStatus(getStatusString(r))
 	indentedDescription := strings.Replace(r.Request.Description, "\n", "\n  ", -1)
-	fmt.Printf(reviewSummaryTemplate, statusString, r.Revision, indentedDescription)
+	var notes string
+	if r.Unavailable {
+		notes += " (commit not available locally)"
+	} else if len(r.Request.DependsOn) > 0 {
+		var dependencies []string
+		for _, dependency := range r.Request.DependsOn {
+			dependencies = append(dependencies, fmt.Sprintf("%.12s", dependency))
+		}
+		relationship := "depends on"
+		if len(dependencies) == 1 && r.Request.DependsOn[0] == r.Request.BaseReview {
+			relationship = "stacked on"
+		}
+		notes += fmt.Sprintf(" (%s %s)", relationship, strings.Join(dependencies, ", "))
+	}
+	if len(r.Labels) > 0 {
+		notes += fmt.Sprintf(" (labels: %s)", strings.Join(r.Labels, ", "))
+	}
+	fmt.Printf(reviewSummaryTemplate, statusString, r.Revision, notes, indentedDescription)
+}
+
+// PrintOrphan prints a single-line summary of an orphaned review, and why it is orphaned.
+func PrintOrphan(orphan review.Orphan) {
+	indentedDescription := strings.Replace(orphan.Request.Description, "\n", "\n  ", -1)
+	fmt.Printf(reviewSummaryTemplate, colorize(colorRed, orphan.Reason), orphan.Revision, "", indentedDescription)
+}
+
+// PrintOrphansJson prints the given orphaned reviews in JSON format.
+func PrintOrphansJson(orphans []review.Orphan) error {
+	return printIndentedJson(orphans)
 }
 
 // reformatTimestamp takes a timestamp string of the form "0123456789" and changes it
@@ -94,23 +213,76 @@ func reformatTimestamp(timestamp string) string {
 	return t.Format(time.UnixDate)
 }
 
+// normalizePath returns the given comment location path, separated by forward slashes.
+func normalizePath(path string) string {
+	return comment.NormalizePath(path)
+}
+
 // showThread prints the detailed output for an entire comment thread.
 func showThread(r *review.Review, thread review.CommentThread) error {
 	comment := thread.Comment
 	indent := "    "
-	if comment.Location != nil && comment.Location.Path != "" && comment.Location.Range != nil && comment.Location.Range.StartLine > 0 {
-		contents, err := r.Repo.Show(comment.Location.Commit, comment.Location.Path)
+	if thread.Elided {
+		// Only the replies are shown, so there is no need for the lines commented upon.
+		return showSubThread(r, thread, indent)
+	}
+	// Comments written by older clients on Windows may have stored the path with backslashes.
+	locationPath := ""
+	if comment.Location != nil {
+		locationPath = normalizePath(comment.Location.Path)
+	}
+	if comment.Location != nil && locationPath != "" && r.Repo.VerifyCommit(comment.Location.Commit) != nil {
+		if shallow, err := r.Repo.IsShallow(); err == nil && shallow {
+			// The comment can still be shown, just not the lines it was made on.
+			fmt.Printf(commentLocationTemplate, indent, locationPath, comment.Location.Commit)
+			fmt.Printf(unavailableLocationTemplate, indent)
+			return showSubThread(r, thread, indent)
+		}
+	}
+	if comment.Location != nil && locationPath != "" && comment.Location.Range == nil {
+		// Comments on a submodule are anchored to its path, since it has no lines to point to.
+		isSubmodule, err := r.Repo.IsSubmodule(comment.Location.Commit, locationPath)
 		if err != nil {
 			return err
 		}
+		if isSubmodule {
+			fmt.Printf(submoduleLocationTemplate, indent, locationPath, comment.Location.Commit)
+			// The summary is only additional context, so the comment is still shown without it.
+			if summary, err := r.GetSubmoduleSummary(comment.Location.Commit, locationPath); err == nil && len(summary) > 0 {
+				fmt.Println(indent + "|" + strings.Join(summary, "\n"+indent+"|"))
+			}
+		}
+	}
+	if comment.Location != nil && locationPath != "" && comment.Location.Range != nil && comment.Location.Range.StartLine > 0 {
+		// The comment is shown on the line it was made on, wherever that line is now.
+		anchor, err := r.AnchorComment(thread.Hash, *comment.Location)
+		if err != nil {
+			anchor = review.Anchor{Commit: comment.Location.Commit, Path: locationPath, Line: comment.Location.Range.StartLine}
+		}
+		contents, err := r.Repo.Show(anchor.Commit, anchor.Path)
+		if err != nil {
+			// The comment was made on a path that is not in the commit, such as the old name of a renamed file.
+			fmt.Printf(commentLocationTemplate, indent, anchor.Path, anchor.Commit)
+			fmt.Printf(missingFileLocationTemplate, indent)
+			return showSubThread(r, thread, indent)
+		}
 		lines := strings.Split(contents, "\n")
-		if comment.Location.Range.StartLine <= uint32(len(lines)) {
+		if anchor.Line <= uint32(len(lines)) {
 			var firstLine uint32 = 0
-			lastLine := comment.Location.Range.StartLine
+			lastLine := anchor.Line
 			if lastLine > contextLineCount {
 				firstLine = lastLine - contextLineCount
 			}
-			fmt.Printf(commentLocationTemplate, indent, comment.Location.Path, comment.Location.Commit)
+			fmt.Printf(commentLocationTemplate, indent, anchor.Path, anchor.Commit)
+			if anchor.Path != locationPath {
+				fmt.Printf(renamedLocationTemplate, indent, locationPath)
+			}
+			if anchor.Outdated {
+				fmt.Printf(outdatedLocationTemplate, indent)
+			}
+			if comment.Location.Pinned {
+				fmt.Printf(pinnedLocationTemplate, indent)
+			}
 			fmt.Println(indent + "|" + strings.Join(lines[firstLine:lastLine], "\n"+indent+"|"))
 		}
 	}
@@ -119,6 +291,10 @@ func showThread(r *review.Review, thread review.CommentThread) error {
 
 // showSubThread prints the given comment (sub)thread, indented by the given prefix string.
 func showSubThread(r *review.Review, thread review.CommentThread, indent string) error {
+	if thread.Elided {
+		fmt.Println(indent + fmt.Sprintf(elidedCommentTemplate, thread.Hash))
+		return showChildThreads(r, thread, indent+"  ")
+	}
 	statusString := "fyi"
 	if thread.Resolved != nil {
 		if *thread.Resolved {
@@ -128,16 +304,50 @@ func showSubThread(r *review.Review, thread review.CommentThread, indent string)
 		}
 	}
 	comment := thread.Comment
+	if comment.Category != "" && comment.Resolved != nil && !*comment.Resolved {
+		statusString += fmt.Sprintf(" (%s)", comment.Category)
+	}
+	if statusString == "lgtm" && comment.Resolved != nil && *comment.Resolved {
+		if comment.Scope != "" {
+			statusString += fmt.Sprintf(" for %q", comment.Scope)
+		}
+		if thread.AwaitsResponseFrom(r.Request.Requester) {
+			statusString += " (conditional; awaiting a response from the requester)"
+		} else if comment.Conditional {
+			statusString += " (conditional; the requester has responded)"
+		}
+	}
 	threadHash, err := comment.Hash()
 	if err != nil {
 		return err
 	}
 
 	timestamp := reformatTimestamp(comment.Timestamp)
-	commentSummary := fmt.Sprintf(indent+commentTemplate, threadHash, comment.Author, timestamp, statusString, comment.Description)
+	commentSummary := fmt.Sprintf(indent+commentTemplate, threadHash, comment.Author, timestamp, colorizeStatus(statusString), comment.Description)
+	for _, attachment := range comment.Attachments {
+		if attachment.Blob != "" {
+			commentSummary += fmt.Sprintf(attachmentBlobTemplate, attachment.Name, attachment.Blob)
+		} else {
+			commentSummary += fmt.Sprintf(attachmentURLTemplate, attachment.URL)
+		}
+	}
+	if len(thread.Reactions) > 0 {
+		var reactions []string
+		for _, reaction := range reactionOrder {
+			if count := thread.Reactions[reaction]; count > 0 {
+				reactions = append(reactions, fmt.Sprintf("%s %d", reactionEmoji[reaction], count))
+			}
+		}
+		commentSummary += fmt.Sprintf(reactionsTemplate, strings.Join(reactions, "  "))
+	}
 	indent = indent + "  "
 	indentedSummary := strings.Replace(commentSummary, "\n", "\n"+indent, -1)
 	fmt.Println(indentedSummary)
+	return showChildThreads(r, thread, indent)
+}
+
+// showChildThreads prints the replies to the given comment thread, indented by the given prefix string.
+func showChildThreads(r *review.Review, thread review.CommentThread, indent string) error {
 	for _, child := range thread.Children {
 		err := showSubThread(r, child, indent)
 		if err != nil {
@@ -159,7 +369,17 @@ func printAnalyses(r *review.Review) {
 		return
 	}
 	fmt.Printf("  analyses: %d warnings\n", len(analysesNotes))
-	// TODO(ojarjur): Print the actual notes
+	for _, note := range analysesNotes {
+		description := note.Description
+		if note.Location != nil && note.Location.Path != "" {
+			location := note.Location.Path
+			if note.Location.Range != nil && note.Location.Range.StartLine > 0 {
+				location += fmt.Sprintf(":%d", note.Location.Range.StartLine)
+			}
+			description = location + ": " + description
+		}
+		fmt.Printf(analysisNoteTemplate, note.Category, description)
+	}
 }
 
 // printComments prints all of the comments for the review, with snippets of the preceding source code.
@@ -174,19 +394,177 @@ func printComments(r *review.Review) error {
 	return nil
 }
 
-// PrintDetails prints a multi-line overview of a review, including all comments.
-func PrintDetails(r *review.Review) error {
+// PrintMetadata prints a multi-line overview of a review, without any comments.
+func PrintMetadata(r *review.Review) {
 	PrintSummary(r)
+	if r.Request.Amended != "" {
+		fmt.Printf(editedTemplate, reformatTimestamp(r.Request.Amended))
+	}
+	if r.Request.SupersededBy != "" {
+		fmt.Printf(supersededTemplate, r.Request.SupersededBy)
+	}
 	fmt.Printf(reviewDetailsTemplate, r.Request.ReviewRef, r.Request.TargetRef,
-		strings.Join(r.Request.Reviewers, ", "),
-		r.Request.Requester, r.GetBuildStatusMessage())
+		strings.Join(r.Request.Reviewers, ", "), r.Request.Requester, strings.Join(r.Attention, ", "))
+	printBuildStatus(r)
+	printSignOffs(r)
+	printChecklist(r)
+	printDependencies(r)
 	printAnalyses(r)
+}
+
+// PrintRequestHistory prints every version of a review's request, oldest first.
+func PrintRequestHistory(r *review.Review) {
+	for i, version := range r.GetRequestHistory() {
+		action, timestamp := "requested", version.Timestamp
+		if version.Amended != "" {
+			action, timestamp = "edited", version.Amended
+		}
+		indentedDescription := strings.Replace(version.Description, "\n", "\n  ", -1)
+		fmt.Printf(requestVersionTemplate, i+1, action, reformatTimestamp(timestamp), version.Requester,
+			version.ReviewRef, version.TargetRef, strings.Join(version.Reviewers, ", "), indentedDescription)
+	}
+}
+
+// PrintChangedFiles prints the files changed by a review, with a check mark next to those
+// that were viewed, and a note next to those that were changed since they were viewed.
+func PrintChangedFiles(files []review.ChangedFile) {
+	for _, file := range files {
+		mark, note := " ", ""
+		switch file.Status {
+		case review.FileViewed:
+			mark = colorize(colorGreen, "\u2713")
+		case review.FileChangedSinceViewed:
+			note = " (" + colorize(colorYellow, review.FileChangedSinceViewed) + ")"
+		}
+		fmt.Printf(changedFileTemplate, mark, file.Path, note)
+	}
+}
+
+// PrintChangedFilesJson prints the files changed by a review, and whether each was viewed, in JSON format.
+func PrintChangedFilesJson(files []review.ChangedFile) error {
+	if files == nil {
+		files = []review.ChangedFile{}
+	}
+	return printIndentedJson(files)
+}
+
+// PrintRequestHistoryJson prints every version of a review's request, oldest first, in JSON format.
+func PrintRequestHistoryJson(r *review.Review) error {
+	return printIndentedJson(r.GetRequestHistory())
+}
+
+// printDependencies prints the reviews that this one depends on, and whether each has been submitted.
+func printDependencies(r *review.Review) {
+	if len(r.Request.DependsOn) == 0 {
+		return
+	}
+	dependencies, err := r.GetDependencies()
+	if err != nil {
+		fmt.Println("  dependencies: ", err)
+		return
+	}
+	fmt.Println("  dependencies:")
+	for _, dependency := range dependencies {
+		fmt.Printf(dependencyTemplate, dependency.Revision, colorizeStatus(dependency.Status))
+	}
+}
+
+// printChecklist prints the items of the review's checklist, and who checked each of them.
+func printChecklist(r *review.Review) {
+	if len(r.Checklist) == 0 {
+		return
+	}
+	heading := "  checklist:"
+	if r.Request.RequireChecklist {
+		heading = "  checklist (required):"
+	}
+	fmt.Println(heading)
+	for _, item := range r.Checklist {
+		mark, note := " ", ""
+		if item.Checked {
+			mark = colorize(colorGreen, "x")
+			note = fmt.Sprintf(" (checked by %q at %s)", item.CheckedBy, reformatTimestamp(item.Timestamp))
+		}
+		fmt.Printf(checklistItemTemplate, mark, item.Item, note)
+	}
+}
+
+// printCommits prints the commits of a review, oldest first, so that its structure is clear
+// before reading the diff.
+func printCommits(r *review.Review) {
+	commits, err := r.GetCommitLog()
+	if err != nil {
+		fmt.Println("  commits: ", err)
+		return
+	}
+	if len(commits) == 0 {
+		return
+	}
+	fmt.Printf("  commits (%d):\n", len(commits))
+	for _, commit := range commits {
+		fmt.Printf(commitTemplate, commit.Hash, commit.Summary, commit.AuthorEmail)
+	}
+}
+
+// printSignOffs prints the latest vote of each reviewer, and who has yet to vote.
+func printSignOffs(r *review.Review) {
+	signOffs := r.GetSignOffs()
+	if len(signOffs) == 0 {
+		return
+	}
+	fmt.Println("  sign-offs:")
+	for _, signOff := range signOffs {
+		status := signOff.Status
+		if signOff.Status != review.SignOffPending {
+			status += " at " + reformatTimestamp(signOff.Timestamp)
+		}
+		if signOff.Conditional {
+			status += " (conditional)"
+		}
+		if signOff.Category != "" {
+			status += fmt.Sprintf(" (%s)", signOff.Category)
+		}
+		fmt.Printf(signOffTemplate, signOff.Reviewer, colorizeStatus(status))
+	}
+}
+
+// PrintNoteErrors prints the malformed notes that were skipped while reading a review.
+func PrintNoteErrors(r *review.Review) {
+	if len(r.NoteErrors) == 0 {
+		return
+	}
+	fmt.Printf("  malformed notes (%d):\n", len(r.NoteErrors))
+	for _, noteError := range r.NoteErrors {
+		fmt.Printf(noteErrorTemplate, noteError.Ref, noteError.Revision, noteError.Error, noteError.Note)
+	}
+}
+
+// PrintComments prints all of the comment threads of a review.
+func PrintComments(r *review.Review) error {
+	return printComments(r)
+}
+
+// PrintDetails prints a multi-line overview of a review, including all comments.
+func PrintDetails(r *review.Review) error {
+	PrintMetadata(r)
+	printCommits(r)
 	if err := printComments(r); err != nil {
 		return err
 	}
 	return nil
 }
 
+// PrintIterations prints the versions of a review, oldest first, numbered from 1.
+func PrintIterations(iterations []review.Iteration) {
+	for i, iteration := range iterations {
+		commented := "not yet commented upon"
+		if iteration.Timestamp != "" {
+			commented = "first commented upon " + reformatTimestamp(iteration.Timestamp)
+		}
+		fmt.Printf(iterationTemplate, i+1, iteration.Commit, commented)
+	}
+}
+
 // PrintJson pretty prints the given review in JSON format.
 func PrintJson(r *review.Review) error {
 	json, err := r.GetJson()
@@ -197,12 +575,38 @@ func PrintJson(r *review.Review) error {
 	return nil
 }
 
+// printIndentedJson pretty prints the given value in JSON format.
+func printIndentedJson(v interface{}) error {
+	jsonBytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(jsonBytes))
+	return nil
+}
+
+// PrintMetadataJson pretty prints the given review in JSON format, without any comments.
+func PrintMetadataJson(r *review.Review) error {
+	metadata := *r
+	metadata.Comments = nil
+	return printIndentedJson(metadata)
+}
+
+// PrintCommentsJson pretty prints the comment threads of the given review in JSON format.
+func PrintCommentsJson(r *review.Review) error {
+	comments := r.Comments
+	if comments == nil {
+		comments = []review.CommentThread{}
+	}
+	return printIndentedJson(comments)
+}
+
 // PrintDiff prints the diff of the review.
 func PrintDiff(r *review.Review, diffArgs ...string) error {
 	diff, err := r.GetDiff(diffArgs...)
 	if err != nil {
 		return err
 	}
-	fmt.Println(diff)
+	PrintRawDiff(diff)
 	return nil
 }
@@ -0,0 +1,281 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"fmt"
+	"github.com/google/git-appraise/review"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// patchAnnotationPrefix starts each of the lines that PrintPatch adds to the patches, so
+// that they can be stripped before applying the patches, such as with "sed '/^#appraise/d'".
+const patchAnnotationPrefix = "#appraise:"
+
+// hunkHeaderPattern matches the header of a hunk within a diff, capturing its first new line.
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -[0-9]+(?:,[0-9]+)? \+([0-9]+)`)
+
+// patchFile is the part of an email patch that changes a single file.
+type patchFile struct {
+	path  string
+	lines []string
+	// annotations are the lines to add after each of the lines, keyed by line index.
+	annotations map[int][]string
+}
+
+// parsedPatch is an email patch, as written by "git format-patch", split into its files.
+type parsedPatch struct {
+	// commit is the hash of the commit that the patch was made from.
+	commit string
+	// header is everything before the diff of the first file, such as the commit message.
+	header []string
+	files  []*patchFile
+	// footer is the signature at the end of the patch, if any.
+	footer []string
+}
+
+// parsePatch splits the given email patch into its files.
+func parsePatch(patch string) *parsedPatch {
+	lines := strings.Split(strings.TrimSuffix(patch, "\n"), "\n")
+	p := &parsedPatch{}
+	if fields := strings.Fields(lines[0]); len(fields) > 1 && fields[0] == "From" {
+		p.commit = fields[1]
+	}
+	for i := len(lines) - 1; i >= 0 && i >= len(lines)-3; i-- {
+		if lines[i] == "-- " {
+			lines, p.footer = lines[:i], lines[i:]
+			break
+		}
+	}
+	inMessage := true
+	var file *patchFile
+	for _, line := range lines {
+		if line == "---" {
+			inMessage = false
+		}
+		if !inMessage && strings.HasPrefix(line, "diff --git ") {
+			file = &patchFile{annotations: make(map[int][]string)}
+			if i := strings.LastIndex(line, " b/"); i >= 0 {
+				file.path = line[i+len(" b/"):]
+			}
+			p.files = append(p.files, file)
+		}
+		if file == nil {
+			p.header = append(p.header, line)
+			continue
+		}
+		if strings.HasPrefix(line, "+++ b/") && !file.hasHunks() {
+			file.path = strings.TrimPrefix(line, "+++ b/")
+		}
+		file.lines = append(file.lines, line)
+	}
+	return p
+}
+
+// hasHunks reports whether any hunks of the file have been parsed yet.
+func (file *patchFile) hasHunks() bool {
+	for _, line := range file.lines {
+		if strings.HasPrefix(line, "@@ ") {
+			return true
+		}
+	}
+	return false
+}
+
+// findLine returns the index of the given line of the new version of the file within the
+// diff, or -1 if it is not in any of the hunks.
+func (file *patchFile) findLine(line uint32) int {
+	var newLine uint64
+	inHunk := false
+	for i, diffLine := range file.lines {
+		if match := hunkHeaderPattern.FindStringSubmatch(diffLine); match != nil {
+			newLine, _ = strconv.ParseUint(match[1], 10, 32)
+			inHunk = true
+			continue
+		}
+		if !inHunk || (!strings.HasPrefix(diffLine, " ") && !strings.HasPrefix(diffLine, "+")) {
+			continue
+		}
+		if newLine == uint64(line) {
+			return i
+		}
+		newLine++
+	}
+	return -1
+}
+
+// annotate adds the given lines after the given line index of the diff of the file, or
+// at its end if the index is -1.
+func (file *patchFile) annotate(index int, lines []string) {
+	if index < 0 {
+		index = len(file.lines) - 1
+	}
+	file.annotations[index] = append(file.annotations[index], lines...)
+}
+
+// String returns the patch, including the annotations added to its files.
+func (p *parsedPatch) String() string {
+	lines := append([]string{}, p.header...)
+	for _, file := range p.files {
+		for i, line := range file.lines {
+			lines = append(lines, line)
+			lines = append(lines, file.annotations[i]...)
+		}
+	}
+	lines = append(lines, p.footer...)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// annotationLine returns an annotation line holding the given text.
+func annotationLine(text string) string {
+	return strings.TrimRight(patchAnnotationPrefix+" "+text, " ")
+}
+
+// formatPatchThread returns the annotation lines for the given comment thread, with its
+// replies indented below it.
+func formatPatchThread(thread review.CommentThread, indent string) []string {
+	var lines []string
+	if !thread.Elided {
+		statusString := "fyi"
+		if thread.Resolved != nil {
+			if *thread.Resolved {
+				statusString = "lgtm"
+			} else {
+				statusString = "needs work"
+			}
+		}
+		c := thread.Comment
+		lines = append(lines, annotationLine(fmt.Sprintf("%s%s (%s) at %s:", indent, c.Author, statusString, reformatTimestamp(c.Timestamp))))
+		for _, line := range strings.Split(strings.TrimRight(c.Description, "\n"), "\n") {
+			lines = append(lines, annotationLine(indent+"  "+line))
+		}
+		indent += "  "
+	}
+	for _, child := range thread.Children {
+		lines = append(lines, formatPatchThread(child, indent)...)
+	}
+	return lines
+}
+
+// describeAnchor returns the annotation line saying where an inline comment that could not
+// be placed on the line it was made on applies to, including its commit if it is pinned to it.
+func describeAnchor(anchor review.Anchor, pinned bool) string {
+	description := "On " + anchor.Path
+	if anchor.Line > 0 {
+		description = fmt.Sprintf("On line %d of %s", anchor.Line, anchor.Path)
+	}
+	if pinned {
+		description += fmt.Sprintf(" as of %.12s", anchor.Commit)
+	}
+	if anchor.Outdated {
+		description += fmt.Sprintf(" (outdated; as of %.12s)", anchor.Commit)
+	}
+	return annotationLine(description + ":")
+}
+
+// annotatePatches returns the given email patches of the review, as a single mailbox, with
+// the comments of the review added next to the lines that they were made on.
+func annotatePatches(r *review.Review, patches []string) string {
+	parsed := make([]*parsedPatch, len(patches))
+	for i, patch := range patches {
+		parsed[i] = parsePatch(patch)
+	}
+	// The inline comments are anchored to the review's head commit, unless they are pinned to
+	// an earlier one. The last patch that changes a file holds the version of it in the head.
+	// The returned flag is set if the file was found in the patch of the given commit.
+	findFile := func(commit, path string) (*patchFile, bool) {
+		for _, patch := range parsed {
+			if patch.commit != commit {
+				continue
+			}
+			for _, file := range patch.files {
+				if file.path == path {
+					return file, true
+				}
+			}
+		}
+		for i := len(parsed) - 1; i >= 0; i-- {
+			for _, file := range parsed[i].files {
+				if file.path == path {
+					return file, false
+				}
+			}
+		}
+		return nil, false
+	}
+
+	header := []string{
+		annotationLine(fmt.Sprintf("Review %s (%s)", r.Revision, getStatusString(r))),
+		annotationLine(fmt.Sprintf("Requested by %s to merge %s into %s", r.Request.Requester, r.Request.ReviewRef, r.Request.TargetRef)),
+	}
+	if len(r.Request.Reviewers) > 0 {
+		header = append(header, annotationLine("Reviewers: "+strings.Join(r.Request.Reviewers, ", ")))
+	}
+	header = append(header, annotationLine(""))
+	for _, line := range strings.Split(strings.TrimSpace(r.Request.Description), "\n") {
+		header = append(header, annotationLine(line))
+	}
+	for _, thread := range r.Comments {
+		lines := formatPatchThread(thread, "")
+		location := thread.Comment.Location
+		if location == nil || location.Path == "" {
+			header = append(header, annotationLine(""))
+			header = append(header, lines...)
+			continue
+		}
+		anchor := review.Anchor{Commit: location.Commit, Path: normalizePath(location.Path)}
+		if location.Range != nil {
+			anchor.Line = location.Range.StartLine
+		}
+		if anchored, err := r.AnchorComment(thread.Hash, *location); err == nil {
+			anchor = anchored
+		}
+		file, inCommit := findFile(anchor.Commit, anchor.Path)
+		if file == nil {
+			header = append(header, annotationLine(""), describeAnchor(anchor, location.Pinned))
+			header = append(header, lines...)
+			continue
+		}
+		index := -1
+		if anchor.Line > 0 && !anchor.Outdated && (inCommit || !location.Pinned) {
+			index = file.findLine(anchor.Line)
+		}
+		if index < 0 {
+			lines = append([]string{describeAnchor(anchor, location.Pinned)}, lines...)
+		}
+		file.annotate(index, lines)
+	}
+
+	var mailbox strings.Builder
+	mailbox.WriteString(strings.Join(header, "\n") + "\n")
+	for _, patch := range parsed {
+		mailbox.WriteString(patch.String())
+	}
+	return mailbox.String()
+}
+
+// PrintPatch prints the given email patches of the review, as written by "git format-patch",
+// with its details and comments added to them as lines starting with "#appraise:".
+//
+// The inline comments are added right after the lines that they were made on, or at the end
+// of the diff of their file if those lines are not in the diff. The patches can be applied
+// with "git am" once the added lines are removed.
+func PrintPatch(r *review.Review, patches []string) {
+	fmt.Print(annotatePatches(r, patches))
+}
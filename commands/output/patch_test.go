@@ -0,0 +1,89 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"strings"
+	"testing"
+)
+
+const testPatch = `From 0123456789abcdef0123456789abcdef01234567 Mon Sep 17 00:00:00 2001
+From: Test User <user@example.com>
+Subject: [PATCH] Update the files
+
+---
+ a.txt | 3 ++-
+ b.txt | 1 -
+ 2 files changed, 2 insertions(+), 2 deletions(-)
+
+diff --git a/a.txt b/a.txt
+index 4cb29ea..f04eb26 100644
+--- a/a.txt
++++ b/a.txt
+@@ -10,3 +10,4 @@ context
+ ten
+-eleven
++11
++- 
+ twelve
+diff --git a/b.txt b/b.txt
+index 4cb29ea..f04eb26 100644
+--- a/b.txt
++++ b/b.txt
+@@ -1 +0,0 @@
+-- 
+-- 
+2.39.5
+
+`
+
+func TestParsePatch(t *testing.T) {
+	p := parsePatch(testPatch)
+	if len(p.files) != 2 || p.files[0].path != "a.txt" || p.files[1].path != "b.txt" {
+		t.Fatalf("Unexpected files: %+v", p.files)
+	}
+	if len(p.footer) != 3 || p.footer[0] != "-- " {
+		t.Errorf("Unexpected footer: %q", p.footer)
+	}
+	if p.String() != testPatch {
+		t.Errorf("The patch was not reassembled as it was:\n%s", p.String())
+	}
+	for _, test := range []struct {
+		line     uint32
+		expected int
+	}{
+		{10, 5},
+		{11, 7},
+		{12, 8},
+		{13, 9},
+		{9, -1},
+		{14, -1},
+	} {
+		if index := p.files[0].findLine(test.line); index != test.expected {
+			t.Errorf("Unexpected index of line %d: got %d, want %d", test.line, index, test.expected)
+		}
+	}
+	p.files[0].annotate(7, []string{annotationLine("Why?")})
+	p.files[1].annotate(-1, []string{annotationLine("")})
+	annotated := p.String()
+	if expected := "+11\n#appraise: Why?\n+- \n"; !strings.Contains(annotated, expected) {
+		t.Errorf("The annotation is not after the line it was added to:\n%s", annotated)
+	}
+	if expected := "-- \n#appraise:\n-- \n2.39.5\n"; !strings.Contains(annotated, expected) {
+		t.Errorf("The annotation is not at the end of the diff of the file:\n%s", annotated)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"fmt"
+	"github.com/google/git-appraise/review"
+	"strings"
+)
+
+// PorcelainVersion is the version of the porcelain output format.
+//
+// The format is meant to be parsed by scripts, so any change to it that could break an
+// existing parser (other than adding new kinds of lines) has to bump this version.
+//
+// Every line of the output is a list of fields separated by tabs, the first of which
+// says what kind of line it is. Backslashes, tabs, carriage returns, and newlines within
+// a field are written as "\\", "\t", "\r", and "\n", respectively, and missing values
+// are written as empty fields. The lines are:
+//
+//	version	<version>
+//	review	<revision>	<status>	<requester>	<timestamp>	<review ref>	<target ref>	<description>
+//	reviewer	<revision>	<reviewer>
+//	dependency	<revision>	<dependency revision>	<dependency status>
+//	comment	<hash>	<parent hash>	<author>	<timestamp>	<status>	<commit>	<path>	<line>	<description>
+//
+// The "version" line always comes first. The review statuses are the same as in the
+// human readable output; the dependency statuses are "submitted", "open", and "missing";
+// the comment statuses are "fyi", "lgtm", and "needs-work".
+// Timestamps are in seconds since the epoch, and comment lines are 0 when the comment
+// is not about a particular line.
+const PorcelainVersion = 1
+
+var porcelainEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"\t", "\\t",
+	"\r", "\\r",
+	"\n", "\\n",
+)
+
+// porcelainLine returns a single line of porcelain output, with each of its fields escaped.
+func porcelainLine(kind string, fields ...string) string {
+	line := kind
+	for _, field := range fields {
+		line += "\t" + porcelainEscaper.Replace(field)
+	}
+	return line
+}
+
+func printPorcelainLine(kind string, fields ...string) {
+	fmt.Println(porcelainLine(kind, fields...))
+}
+
+// PrintPorcelainVersion prints the line that starts the porcelain output.
+func PrintPorcelainVersion() {
+	printPorcelainLine("version", fmt.Sprint(PorcelainVersion))
+}
+
+// PrintPorcelainSummary prints the porcelain line describing a single review.
+func PrintPorcelainSummary(r *review.Review) {
+	printPorcelainLine("review", r.Revision, getStatusString(r), r.Request.Requester, r.Request.Timestamp,
+		r.Request.ReviewRef, r.Request.TargetRef, r.Request.Description)
+}
+
+// PrintPorcelainOrphan prints the porcelain line describing a single orphaned review.
+func PrintPorcelainOrphan(orphan review.Orphan) {
+	printPorcelainLine("orphan", orphan.Revision, orphan.Reason, orphan.Request.Requester, orphan.Request.Timestamp,
+		orphan.Request.ReviewRef, orphan.Request.TargetRef, orphan.Request.Description)
+}
+
+// printPorcelainThread prints the porcelain lines for a comment thread and all of its replies.
+func printPorcelainThread(thread review.CommentThread, parent string) {
+	if !thread.Elided {
+		c := thread.Comment
+		status := "fyi"
+		if thread.Resolved != nil && *thread.Resolved {
+			status = "lgtm"
+		} else if thread.Resolved != nil {
+			status = "needs-work"
+		}
+		var commit, path, line string
+		if c.Location != nil {
+			commit = c.Location.Commit
+			path = normalizePath(c.Location.Path)
+			if c.Location.Range != nil {
+				line = fmt.Sprint(c.Location.Range.StartLine)
+			}
+		}
+		if line == "" {
+			line = "0"
+		}
+		printPorcelainLine("comment", thread.Hash, parent, c.Author, c.Timestamp, status, commit, path, line, c.Description)
+	}
+	for _, child := range thread.Children {
+		printPorcelainThread(child, thread.Hash)
+	}
+}
+
+// PrintPorcelainDetails prints the porcelain lines describing a review, its reviewers, its
+// dependencies, and its comments.
+func PrintPorcelainDetails(r *review.Review) {
+	PrintPorcelainSummary(r)
+	for _, reviewer := range r.Request.Reviewers {
+		printPorcelainLine("reviewer", r.Revision, reviewer)
+	}
+	if dependencies, err := r.GetDependencies(); err == nil {
+		for _, dependency := range dependencies {
+			printPorcelainLine("dependency", r.Revision, dependency.Revision, dependency.Status)
+		}
+	}
+	for _, label := range r.Labels {
+		printPorcelainLine("label", r.Revision, label)
+	}
+	for _, email := range r.Attention {
+		printPorcelainLine("attention", r.Revision, email)
+	}
+	for _, thread := range r.Comments {
+		printPorcelainThread(thread, "")
+	}
+}
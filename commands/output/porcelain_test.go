@@ -0,0 +1,37 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"testing"
+)
+
+func TestPorcelainLine(t *testing.T) {
+	for _, test := range []struct {
+		fields   []string
+		expected string
+	}{
+		{nil, "review"},
+		{[]string{"abc", ""}, "review\tabc\t"},
+		{[]string{"a\tb", "first\nsecond\r\n"}, "review\ta\\tb\tfirst\\nsecond\\r\\n"},
+		{[]string{`C:\dir\n`}, `review	C:\\dir\\n`},
+	} {
+		if line := porcelainLine("review", test.fields...); line != test.expected {
+			t.Errorf("Unexpected porcelain line for %q: got %q, want %q", test.fields, line, test.expected)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"fmt"
+	"github.com/google/git-appraise/review"
+	"strings"
+)
+
+const (
+	// Template for printing a single field of a review that matched a search.
+	searchMatchTemplate = `    %s: %s
+`
+	// snippetContext is the number of bytes of context kept on each side of the first match
+	// in a snippet, when the matching line is too long to print in full.
+	snippetContext = 40
+)
+
+// highlightMatches returns the given text with the given ranges highlighted, if the output is colored.
+//
+// The ranges must be in order of their start, but may overlap.
+func highlightMatches(text string, ranges [][]int) string {
+	if !colorEnabled {
+		return text
+	}
+	var highlighted strings.Builder
+	end := 0
+	for _, match := range ranges {
+		start, stop := match[0], match[1]
+		if start < end {
+			start = end
+		}
+		if stop > len(text) {
+			stop = len(text)
+		}
+		if stop <= start {
+			continue
+		}
+		highlighted.WriteString(text[end:start])
+		highlighted.WriteString(colorize(colorBold+colorRed, text[start:stop]))
+		end = stop
+	}
+	highlighted.WriteString(text[end:])
+	return highlighted.String()
+}
+
+// formatSnippet returns the line of the given match holding its first range, shortened to the
+// context around that range if it is too long, with the matches within it highlighted.
+func formatSnippet(match review.SearchMatch) string {
+	text := match.Text
+	if len(match.Ranges) == 0 {
+		return text
+	}
+	first := match.Ranges[0]
+	start := strings.LastIndex(text[:first[0]], "\n") + 1
+	end := len(text)
+	if newline := strings.Index(text[first[0]:], "\n"); newline >= 0 {
+		end = first[0] + newline
+	}
+	prefix, suffix := "", ""
+	if first[0]-start > snippetContext {
+		start, prefix = first[0]-snippetContext, "..."
+	}
+	if end-first[1] > snippetContext {
+		end, suffix = first[1]+snippetContext, "..."
+	}
+	// Drop any indentation before the first match.
+	for start < first[0] && (text[start] == ' ' || text[start] == '\t') {
+		start++
+	}
+	// Keep the snippet from starting or ending in the middle of a multi-byte character.
+	for start > 0 && start < len(text) && text[start]&0xC0 == 0x80 {
+		start--
+	}
+	for end < len(text) && text[end]&0xC0 == 0x80 {
+		end++
+	}
+	var ranges [][]int
+	for _, r := range match.Ranges {
+		if r[0] >= start && r[1] <= end {
+			ranges = append(ranges, []int{r[0] - start, r[1] - start})
+		}
+	}
+	return prefix + highlightMatches(strings.TrimRight(text[start:end], " \t\r"), ranges) + suffix
+}
+
+// PrintSearchResult prints the summary of a review that matched a search, followed by a
+// snippet of each of the fields that matched.
+func PrintSearchResult(result review.SearchResult) {
+	PrintSummary(&result.Review)
+	for _, match := range result.Matches {
+		field := match.Field
+		if match.CommentHash != "" {
+			field = fmt.Sprintf("%s %.12s", field, match.CommentHash)
+		}
+		fmt.Printf(searchMatchTemplate, field, formatSnippet(match))
+	}
+}
+
+// PrintSearchResultsJson pretty prints the given search results in JSON format, without the
+// comments of each review, since the matching comments are included in the matches.
+func PrintSearchResultsJson(results []review.SearchResult) error {
+	if results == nil {
+		results = []review.SearchResult{}
+	}
+	for i := range results {
+		results[i].Review.Comments = nil
+	}
+	return printIndentedJson(results)
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"github.com/google/git-appraise/review"
+	"strings"
+	"testing"
+)
+
+func TestFormatSnippet(t *testing.T) {
+	defer SetColor(ColorNever)
+	long := strings.Repeat("x", 50) + " backoff " + strings.Repeat("y", 50)
+	for _, test := range []struct {
+		match    review.SearchMatch
+		expected string
+	}{
+		{review.SearchMatch{Text: "First line\n  the backoff is\nlast", Ranges: [][]int{{17, 24}}}, "the " + colorBold + colorRed + "backoff" + colorReset + " is"},
+		{review.SearchMatch{Text: long, Ranges: [][]int{{51, 58}}}, "..." + strings.Repeat("x", 39) + " " + colorBold + colorRed + "backoff" + colorReset + " " + strings.Repeat("y", 39) + "..."},
+		{review.SearchMatch{Text: "retry/backoff.go", Ranges: [][]int{{0, 5}, {3, 13}}}, colorBold + colorRed + "retry" + colorReset + colorBold + colorRed + "/backoff" + colorReset + ".go"},
+	} {
+		SetColor(ColorAlways)
+		if snippet := formatSnippet(test.match); snippet != test.expected {
+			t.Errorf("Unexpected snippet of %q: got %q, want %q", test.match.Text, snippet, test.expected)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// PluginPrefix is the prefix of the names of the executables that provide commands which
+// are not built in, so that "git appraise foo" runs "git-appraise-foo" from the PATH.
+const PluginPrefix = "git-appraise-"
+
+// FindPlugin returns the path of the executable on the PATH that provides the given command.
+func FindPlugin(name string) (string, bool) {
+	if name == "" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, `/\`) {
+		return "", false
+	}
+	path, err := exec.LookPath(PluginPrefix + name)
+	if err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// ListPlugins returns the names of the commands provided by executables on the PATH, other
+// than those that are shadowed by built-in commands.
+func ListPlugins() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			continue
+		}
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !strings.HasPrefix(name, PluginPrefix) || entry.IsDir() {
+				continue
+			}
+			if _, err := exec.LookPath(filepath.Join(dir, name)); err != nil {
+				// The file is not executable.
+				continue
+			}
+			name = strings.TrimPrefix(name, PluginPrefix)
+			if runtime.GOOS == "windows" {
+				name = strings.TrimSuffix(name, filepath.Ext(name))
+			}
+			if _, builtin := CommandMap[name]; builtin || seen[name] || name == "" {
+				continue
+			}
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pluginEnvironment returns the environment variables that describe the repo, and the current
+// review if there is one, to a plugin.
+func pluginEnvironment(repo repository.Repo) []string {
+	if repo == nil {
+		return nil
+	}
+	env := []string{"APPRAISE_REPO_PATH=" + repo.GetPath()}
+	if r, err := review.GetCurrent(repo); err == nil && r != nil {
+		env = append(env, "APPRAISE_REVIEW_HASH="+r.Revision)
+	}
+	return env
+}
+
+// RunPlugin runs the given executable that provides a command which is not built in, with the
+// given arguments, and returns its exit code. The repo is nil if it is not run within a repo.
+//
+// The executable is given the same stdin, stdout, and stderr, and its environment also has
+// APPRAISE_REPO_PATH set to the path of the repo, and APPRAISE_REVIEW_HASH set to the hash
+// of the current review, if there is one.
+func RunPlugin(repo repository.Repo, path string, args []string) (int, error) {
+	cmd := exec.Command(path, args...)
+	cmd.Env = append(os.Environ(), pluginEnvironment(repo)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return ExitUserError, err
+	}
+	return 0, nil
+}
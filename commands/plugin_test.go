@@ -0,0 +1,84 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPlugins(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The test plugins are shell scripts")
+	}
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "output")
+	for name, mode := range map[string]os.FileMode{
+		PluginPrefix + "hello":        0755,
+		PluginPrefix + "show":         0755,
+		PluginPrefix + "not-runnable": 0644,
+	} {
+		script := "#!/bin/sh\necho \"$* $APPRAISE_REPO_PATH $APPRAISE_REVIEW_HASH\" > " + output + "\nexit 3\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(filepath.ListSeparator)+os.Getenv("PATH"))
+
+	if names := ListPlugins(); !reflect.DeepEqual(names, []string{"hello"}) {
+		t.Errorf("Unexpected plugins: %q", names)
+	}
+	if _, found := FindPlugin("not-runnable"); found {
+		t.Error("Found a plugin that is not executable")
+	}
+	if _, found := FindPlugin("missing"); found {
+		t.Error("Found a plugin that does not exist")
+	}
+	plugin, found := FindPlugin("hello")
+	if !found {
+		t.Fatal("Did not find the plugin")
+	}
+	memoryRepo := repository.NewMemoryRepoForTest()
+	memoryRepo.SetPath(dir)
+	repo := &failingRepoForTest{
+		Repo:     memoryRepo,
+		head:     repository.TestReviewRef,
+		failures: make(map[string]bool),
+	}
+	code, err := RunPlugin(repo, plugin, []string{"a", "b"})
+	if err != nil || code != 3 {
+		t.Fatalf("Unexpected result of running the plugin: %d, %v", code, err)
+	}
+	contents, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := strings.TrimSpace(string(contents)), "a b "+dir+" "+repository.TestCommitG; got != expected {
+		t.Errorf("Unexpected arguments and environment of the plugin: got %q, want %q", got, expected)
+	}
+}
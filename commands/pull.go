@@ -18,12 +18,48 @@ package commands
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/google/git-appraise/repository"
 )
 
+var pullFlagSet = flag.NewFlagSet("pull", flag.ContinueOnError)
+
+var (
+	pullDryRun  = pullFlagSet.Bool("dry-run", false, "Print the refs that would be updated, without changing anything")
+	pullTimeout = pullFlagSet.Duration("timeout", 0, "Give up on the pull (killing any git command still running) after this long, such as \"30s\"; zero means no limit")
+)
+
+// printRefDiffs prints a summary of the ref updates that would be made by a push
+// (if the push argument is true) or a pull (otherwise).
+func printRefDiffs(diffs []repository.RefDiff, push bool) {
+	if len(diffs) == 0 {
+		fmt.Println("Everything up-to-date")
+		return
+	}
+	for _, diff := range diffs {
+		verb, from, to := "fetch", diff.LocalCommit, diff.RemoteCommit
+		if push {
+			verb, from, to = "push", diff.RemoteCommit, diff.LocalCommit
+		}
+		update := fmt.Sprintf("%.12s..%.12s", from, to)
+		if from == "" {
+			update = "new ref"
+		}
+		fmt.Printf("Would %s %s: %s", verb, diff.Ref, update)
+		if diff.DifferingNotes > 0 {
+			fmt.Printf(" (%d notes differ)", diff.DifferingNotes)
+		}
+		fmt.Println()
+	}
+}
+
 // pull updates the local git-notes used for reviews with those from a remote repo.
 func pull(repo repository.Repo, args []string) error {
+	if err := pullFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = pullFlagSet.Args()
 	if len(args) > 1 {
 		return errors.New("Only pulling from one remote at a time is supported.")
 	}
@@ -32,16 +68,33 @@ func pull(repo repository.Repo, args []string) error {
 	if len(args) == 1 {
 		remote = args[0]
 	}
+	repo, cancel := withTimeout(repo, *pullTimeout)
+	defer cancel()
 
-	repo.PullNotes(remote, notesRefPattern)
-	return nil
+	if *pullDryRun || repository.IsRecordOnly(repo) {
+		diffs, err := repo.DiffRemoteRefs(remote, notesRefPattern, archiveRefPattern)
+		if err != nil {
+			return err
+		}
+		var updates []repository.RefDiff
+		for _, diff := range diffs {
+			if diff.RemoteCommit != "" {
+				updates = append(updates, diff)
+			}
+		}
+		printRefDiffs(updates, false)
+		return nil
+	}
+	return repo.PullNotesAndArchive(remote, notesRefPattern, archiveRefPattern)
 }
 
 var pullCmd = &Command{
 	Usage: func(arg0 string) {
-		fmt.Printf("Usage: %s pull [<remote>]", arg0)
+		fmt.Printf("Usage: %s pull [<option>...] [<remote>]\n\nOptions:\n", arg0)
+		pullFlagSet.PrintDefaults()
 	},
 	RunMethod: func(repo repository.Repo, args []string) error {
 		return pull(repo, args)
 	},
+	Flags: pullFlagSet,
 }
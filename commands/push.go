@@ -18,12 +18,25 @@ package commands
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/google/git-appraise/repository"
 )
 
+var pushFlagSet = flag.NewFlagSet("push", flag.ContinueOnError)
+
+var (
+	pushDryRun  = pushFlagSet.Bool("dry-run", false, "Print the refs that would be updated, without changing anything")
+	pushForce   = pushFlagSet.Bool("force", false, "Replace the remote review data with the local one, as long as the remote has not changed since it was last pulled")
+	pushTimeout = pushFlagSet.Duration("timeout", 0, "Give up on the push (killing any git command still running) after this long, such as \"30s\"; zero means no limit")
+)
+
 // push pushes the local git-notes used for reviews to a remote repo.
 func push(repo repository.Repo, args []string) error {
+	if err := pushFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = pushFlagSet.Args()
 	if len(args) > 1 {
 		return errors.New("Only pushing to one remote at a time is supported.")
 	}
@@ -32,15 +45,69 @@ func push(repo repository.Repo, args []string) error {
 	if len(args) == 1 {
 		remote = args[0]
 	}
+	repo, cancel := withTimeout(repo, *pushTimeout)
+	defer cancel()
 
-	return repo.PushNotes(remote, notesRefPattern)
+	if *pushDryRun || repository.IsRecordOnly(repo) {
+		diffs, err := repo.DiffRemoteRefs(remote, notesRefPattern, archiveRefPattern)
+		if err != nil {
+			return err
+		}
+		var updates []repository.RefDiff
+		for _, diff := range diffs {
+			if diff.LocalCommit != "" {
+				updates = append(updates, diff)
+			}
+		}
+		printRefDiffs(updates, true)
+		return nil
+	}
+
+	if *pushForce {
+		return forcePush(repo, remote)
+	}
+	return pushWithRetry(repo, remote)
+}
+
+// forcePush replaces the review data in the given remote with the local review data.
+//
+// Unlike pushWithRetry, a rejected push is not retried after pulling, since merging in
+// the remote's review data would undo whatever local change made the force necessary.
+func forcePush(repo repository.Repo, remote string) error {
+	err := repo.ForcePushNotesAndArchive(remote, notesRefPattern, archiveRefPattern)
+	if _, ok := err.(repository.PushRejectedError); ok {
+		return fmt.Errorf("Not force pushing to the remote %q, since it has review data that has not been pulled.\n"+
+			"Run \"pull\" first, and then push again.", remote)
+	}
+	return err
+}
+
+// pushWithRetry pushes the review data to the given remote. If the remote rejects
+// the push because it has review data that we do not, then that data is pulled and
+// merged in, and the push is retried once.
+func pushWithRetry(repo repository.Repo, remote string) error {
+	err := repo.PushNotesAndArchive(remote, notesRefPattern, archiveRefPattern)
+	if _, ok := err.(repository.PushRejectedError); !ok {
+		return err
+	}
+	fmt.Printf("The remote %q has new review data; pulling it before retrying the push.\n", remote)
+	if err := repo.PullNotesAndArchive(remote, notesRefPattern, archiveRefPattern); err != nil {
+		return fmt.Errorf("Failed to pull from the remote %q after the push was rejected: %v", remote, err)
+	}
+	if err := repo.PushNotesAndArchive(remote, notesRefPattern, archiveRefPattern); err != nil {
+		return fmt.Errorf("Failed to push to the remote %q, even after merging in its review data: %v\n"+
+			"Someone may be pushing to it concurrently; run \"pull\" and then try again.", remote, err)
+	}
+	return nil
 }
 
 var pushCmd = &Command{
 	Usage: func(arg0 string) {
-		fmt.Printf("Usage: %s push [<remote>]", arg0)
+		fmt.Printf("Usage: %s push [<option>...] [<remote>]\n\nOptions:\n", arg0)
+		pushFlagSet.PrintDefaults()
 	},
 	RunMethod: func(repo repository.Repo, args []string) error {
 		return push(repo, args)
 	},
+	Flags: pushFlagSet,
 }
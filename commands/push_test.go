@@ -0,0 +1,71 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"testing"
+)
+
+// rejectingRepoForTest counts the pushes and pulls of review data, and rejects the
+// given number of pushes before accepting any.
+type rejectingRepoForTest struct {
+	repository.Repo
+	rejections int
+	pushes     []string
+	pulls      []string
+}
+
+func (r *rejectingRepoForTest) PushNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	r.pushes = append(r.pushes, remote)
+	if len(r.pushes) <= r.rejections {
+		return repository.PushRejectedError{Remote: remote}
+	}
+	return nil
+}
+
+func (r *rejectingRepoForTest) PullNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	r.pulls = append(r.pulls, remote)
+	return nil
+}
+
+func TestPushWithRetry(t *testing.T) {
+	for _, test := range []struct {
+		rejections     int
+		pushes, pulls  int
+		expectingError bool
+	}{
+		{rejections: 0, pushes: 1, pulls: 0},
+		// A rejected push is retried exactly once, after pulling the remote's review data.
+		{rejections: 1, pushes: 2, pulls: 1},
+		{rejections: 2, pushes: 2, pulls: 1, expectingError: true},
+	} {
+		repo := &rejectingRepoForTest{Repo: repository.NewMemoryRepoForTest(), rejections: test.rejections}
+		_, err := captureStdout(func() error { return pushCmd.Run(repo, []string{"upstream"}) })
+		if (err != nil) != test.expectingError {
+			t.Errorf("Unexpected result of pushing after %d rejections: %v", test.rejections, err)
+		}
+		if len(repo.pushes) != test.pushes || len(repo.pulls) != test.pulls {
+			t.Errorf("Unexpected pushes %q and pulls %q after %d rejections", repo.pushes, repo.pulls, test.rejections)
+		}
+		for _, remote := range append(repo.pushes, repo.pulls...) {
+			if remote != "upstream" {
+				t.Errorf("Unexpected remote %q after %d rejections", remote, test.rejections)
+			}
+		}
+	}
+}
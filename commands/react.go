@@ -0,0 +1,95 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/comment"
+	"strings"
+)
+
+var reactFlagSet = flag.NewFlagSet("react", flag.ContinueOnError)
+
+// containsThread returns whether or not the given comment threads include a comment with the given hash.
+func containsThread(threads []review.CommentThread, hash string) bool {
+	for _, thread := range threads {
+		if thread.Hash == hash || containsThread(thread.Children, hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// findReviewForComment returns the review that contains the comment with the given hash.
+func findReviewForComment(repo repository.Repo, commentHash string) (*review.Review, error) {
+	for _, r := range review.ListAll(repo) {
+		if containsThread(r.Comments, commentHash) {
+			return &r, nil
+		}
+	}
+	return nil, fmt.Errorf("There is no comment with the hash %q.", commentHash)
+}
+
+// reactToComment adds a reaction to an existing review comment.
+func reactToComment(repo repository.Repo, args []string) error {
+	if err := reactFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = reactFlagSet.Args()
+	if len(args) != 2 {
+		return errors.New("The react command requires a comment hash and a reaction.")
+	}
+	commentHash, reaction := args[0], args[1]
+	supported := false
+	for _, r := range comment.Reactions {
+		supported = supported || r == reaction
+	}
+	if !supported {
+		return fmt.Errorf("Unsupported reaction %q; expected one of: %s", reaction, strings.Join(comment.Reactions, ", "))
+	}
+
+	r, err := findReviewForComment(repo, commentHash)
+	if err != nil {
+		return err
+	}
+	userEmail, err := repo.GetUserEmail()
+	if err != nil {
+		return err
+	}
+	c := comment.New(userEmail, "")
+	c.Parent = commentHash
+	c.Reaction = reaction
+	if err := signIfRequested(repo, false, c.Sign); err != nil {
+		return err
+	}
+	return r.AddComment(c)
+}
+
+// reactCmd defines the "react" subcommand.
+var reactCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s react <comment-hash> <%s>\n", arg0, strings.Join(comment.Reactions, "|"))
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return reactToComment(repo, args)
+	},
+	Flags: reactFlagSet,
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/comment"
+)
+
+var rejectFlagSet = flag.NewFlagSet("reject", flag.ContinueOnError)
+
+var (
+	rejectMessage  = rejectFlagSet.String("m", "", "Message to attach to the review")
+	rejectCategory = rejectFlagSet.String("category", "", "Tag the rejection with the reason for it, such as \"needs-tests\" or \"design-concern\"")
+	rejectSign     = rejectFlagSet.Bool("S", false, "Sign the comment with the configured signing key")
+	rejectAll      = rejectFlagSet.Bool("all", false, "Reject every open review that matches the --filter flag, rather than a single review")
+	rejectFilter   = rejectFlagSet.String("filter", "", "Filter flags of the \"list\" command (e.g. \"--label=stale\") selecting the reviews that --all rejects")
+	rejectConfirm  = rejectFlagSet.Bool("confirm", false, "Actually reject the reviews selected by --all, rather than only listing them")
+)
+
+// RejectOptions are the options of Reject, which match the flags of the "reject" command.
+type RejectOptions struct {
+	// Review is the hash of the review to reject, or empty for the current review.
+	Review  string
+	Message string
+	// Category tags the rejection with the reason for it, such as "needs-tests".
+	Category string
+	// Sign signs the comment with the configured signing key.
+	Sign bool
+}
+
+// Reject adds a "needs more work" comment to a review, as the "reject" command does.
+func Reject(repo repository.Repo, opts RejectOptions) error {
+	if opts.Category != "" {
+		if err := review.ValidateRejectionCategory(repo, opts.Category); err != nil {
+			return err
+		}
+	}
+
+	r, err := loadReview(repo, opts.Review)
+	if err != nil {
+		return err
+	}
+
+	rejectedCommit, err := r.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	location := comment.Location{
+		Commit: rejectedCommit,
+	}
+	resolved := false
+	userEmail, err := repo.GetUserEmail()
+	if err != nil {
+		return err
+	}
+	c := comment.New(userEmail, opts.Message)
+	c.Location = &location
+	c.Snapshot = rejectedCommit
+	c.Resolved = &resolved
+	c.Category = opts.Category
+	if err := signIfRequested(repo, opts.Sign, c.Sign); err != nil {
+		return err
+	}
+	return r.AddComment(c)
+}
+
+// rejectReview adds a "needs more work" comment to the current code review.
+func rejectReview(repo repository.Repo, args []string) error {
+	if err := rejectFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = rejectFlagSet.Args()
+	if !*rejectAll && (*rejectFilter != "" || *rejectConfirm) {
+		return errors.New("The --filter and --confirm flags can only be used with the --all flag.")
+	}
+	if *rejectAll && len(args) > 0 {
+		return errors.New("The --all flag cannot be used with a review hash.")
+	}
+	if len(args) > 1 {
+		return errors.New("Only rejecting a single review is supported.")
+	}
+	opts := RejectOptions{
+		Message:  *rejectMessage,
+		Category: *rejectCategory,
+		Sign:     *rejectSign,
+	}
+	if *rejectAll {
+		if opts.Category != "" {
+			// Check the category once, rather than failing on every review.
+			if err := review.ValidateRejectionCategory(repo, opts.Category); err != nil {
+				return err
+			}
+		}
+		return applyToMatchingReviews(repo, *rejectFilter, *rejectConfirm, "Rejected", func(revision string) error {
+			opts.Review = revision
+			return Reject(repo, opts)
+		})
+	}
+	if len(args) == 1 {
+		opts.Review = args[0]
+	}
+	return Reject(repo, opts)
+}
+
+// rejectCmd defines the "reject" subcommand.
+var rejectCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s reject [<option>...] [<commit>]\n       %s reject --all [--filter=<flags>] [--confirm] [<option>...]\n\nOptions:\n", arg0, arg0)
+		rejectFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return rejectReview(repo, args)
+	},
+	Flags: rejectFlagSet,
+}
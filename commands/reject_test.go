@@ -0,0 +1,114 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/reviewtest"
+	"testing"
+)
+
+func TestRejectWithCategory(t *testing.T) {
+	repo := repository.NewMemoryRepoForTest()
+	defer func() {
+		*rejectCategory, *listRejected, *listCategory, *commentCategory, *commentNmw = "", false, "", "", false
+	}()
+	repo.SetConfigValue(review.RejectionCategoriesConfigKey, "needs-tests,design-concern")
+	if err := rejectReview(repo, []string{"-m", "Needs a clearer design", "-category", "style", repository.TestCommitG}); err == nil {
+		t.Fatal("Unexpectedly rejected with a category that is not allowed")
+	}
+	if err := rejectReview(repo, []string{"-m", "Needs a clearer design", "-category", "design-concern", repository.TestCommitG}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.Get(repo, repository.TestCommitG)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	if !r.IsRejected("design-concern") || r.IsRejected("needs-tests") {
+		t.Fatalf("Unexpected sign-offs after rejecting: %+v", r.GetSignOffs())
+	}
+
+	if err := listReviews(repo, []string{"-category", "design-concern"}); err == nil {
+		t.Fatal("Unexpectedly allowed --category without --rejected")
+	}
+	if err := listReviews(repo, []string{"-rejected", "-category", "design-concern"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := commentOnReview(repo, []string{"-m", "Missing tests", "-category", "needs-tests", repository.TestCommitG}); err == nil {
+		t.Fatal("Unexpectedly allowed -category without -nmw")
+	}
+
+	// Rejecting without a category still works.
+	*rejectCategory = ""
+	if err := rejectReview(repo, []string{"-m", "Not yet", repository.TestCommitG}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReject(t *testing.T) {
+	repo := repository.NewMemoryRepoForTest()
+	repo.SetConfigValue(review.RejectionCategoriesConfigKey, "needs-tests")
+	if err := Reject(repo, RejectOptions{Review: repository.TestCommitG, Category: "style"}); err == nil {
+		t.Fatal("Unexpectedly rejected with a category that is not allowed")
+	}
+	if err := Reject(repo, RejectOptions{Review: repository.TestCommitG, Message: "Add tests", Category: "needs-tests"}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.Get(repo, repository.TestCommitG)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	if !r.IsRejected("needs-tests") {
+		t.Errorf("The review was not rejected: %+v", r.GetSignOffs())
+	}
+}
+
+func TestRejectAll(t *testing.T) {
+	defer func() {
+		*rejectMessage, *rejectCategory, *rejectAll, *rejectFilter, *rejectConfirm = "", "", false, "", false
+	}()
+	repo := repository.NewRepoWithHistory(map[string][]string{
+		"master":  {"A"},
+		"feature": {"A", "B"},
+		"fix":     {"A", "C"},
+	})
+	repo.SetConfigValue(review.RejectionCategoriesConfigKey, "stale")
+	for _, ref := range []string{"refs/heads/feature", "refs/heads/fix"} {
+		if _, err := reviewtest.AddReview(repo, ref, "refs/heads/master"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rejectReview(repo, []string{"-all", "-confirm", "-category", "style"}); err == nil {
+		t.Fatal("Unexpectedly rejected the reviews with a category that is not allowed")
+	}
+	if err := rejectReview(repo, []string{"-all", "-confirm", "C"}); err == nil {
+		t.Fatal("Unexpectedly allowed --all with a review hash")
+	}
+	if err := rejectReview(repo, []string{"-all", "-confirm", "-m", "Abandoned", "-category", "stale"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, revision := range []string{"B", "C"} {
+		r, err := review.Get(repo, revision)
+		if err != nil || r == nil {
+			t.Fatalf("Failed to load the review: %v", err)
+		}
+		if !r.IsRejected("stale") {
+			t.Errorf("Review %s was not rejected: %+v", revision, r.GetSignOffs())
+		}
+	}
+}
@@ -21,8 +21,11 @@ import (
 	"flag"
 	"fmt"
 	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
 	"github.com/google/git-appraise/review/request"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Template for the "request" subcommand's output.
@@ -33,17 +36,52 @@ Review Ref: %s
 Message: "%s"
 `
 
-var requestFlagSet = flag.NewFlagSet("request", flag.ExitOnError)
+// Template for the "request" subcommand's output with the --dry-run flag.
+const requestPreviewTemplate = `Dry run; no review was requested:
+Commit: %s
+Target Ref: %s
+Review Ref: %s
+Message: "%s"
+Commit range: %.12s..%.12s
+`
+
+// requestTargetConfigKey is the config key holding the default target ref of new reviews.
+const requestTargetConfigKey = "appraise.request.target"
+
+var requestFlagSet = flag.NewFlagSet("request", flag.ContinueOnError)
 
 var (
 	requestMessage          = requestFlagSet.String("m", "", "Message to attach to the review")
 	requestReviewers        = requestFlagSet.String("r", "", "Comma-separated list of reviewers")
 	requestSource           = requestFlagSet.String("source", "HEAD", "Revision to review")
-	requestTarget           = requestFlagSet.String("target", "refs/heads/master", "Revision against which to review")
+	requestTarget           = requestFlagSet.String("target", "refs/heads/master", "Revision against which to review (default from the "+requestTargetConfigKey+" setting)")
+	requestBase             = requestFlagSet.String("base", "", "Ancestor of the review ref to use as the base of the review, in place of the target ref; if this is the hash of an open review, then the new review is stacked on it")
 	requestQuiet            = requestFlagSet.Bool("quiet", false, "Suppress review summary output")
 	requestAllowUncommitted = requestFlagSet.Bool("allow-uncommitted", false, "Allow uncommitted local changes.")
+	requestUpdateBase       = requestFlagSet.Bool("update-base", false, "Update the base commit of an existing review to the current merge base of its review and target refs")
+	requestAmend            = requestFlagSet.Bool("amend", false, "Update the message, reviewers, target, dependencies, or checklist of an existing review from the -m, -r, -target, -depends-on, -checklist-item, and -require-checklist flags")
+	requestDryRun           = requestFlagSet.Bool("dry-run", false, "Print the commits and files that the review would include, without requesting it")
+	requestSign             = requestFlagSet.Bool("S", false, "Sign the request with the configured signing key")
+	requestDependsOn        = requestFlagSet.String("depends-on", "", "Comma-separated list of the revisions of reviews that have to be submitted before this one")
+	requestSnapshot         = requestFlagSet.String("snapshot", "", "Experimental: review the \"staged\" or all of the \"working\" uncommitted changes, recorded in a temporary commit on top of HEAD")
+	requestDiscardSnapshot  = requestFlagSet.Bool("discard-snapshot", false, "Remove a review of uncommitted changes, along with its temporary commit")
+	requestRequireSignoff   = requestFlagSet.Bool("require-signoff", false, "Warn about commits that are not signed off by their authors, even if "+review.RequireSignoffConfigKey+" is not set")
+	requestNoVerify         = requestFlagSet.Bool("no-verify", false, "Request the review even if its commit messages break the rules in the "+configKeyPrefix+"lint.* settings")
+	requestAutoAssign       = requestFlagSet.Bool("auto-assign", false, "Pick the reviewers from the pool listed in "+review.ReviewersPath+", unless they are given with -r")
+	requestAllowEmpty       = requestFlagSet.Bool("allow-empty", false, "Request the review even if the source has no commits that are not already in the target")
+	requestRequireChecklist = requestFlagSet.Bool("require-checklist", false, "Only count the review as accepted once every item of its checklist has been checked")
 )
 
+var (
+	requestLabels    stringList
+	requestChecklist stringList
+)
+
+func init() {
+	requestFlagSet.Var(&requestLabels, "label", "Label to add to the review; may be repeated")
+	requestFlagSet.Var(&requestChecklist, "checklist-item", "Item of the review's checklist, for reviewers to check; may be repeated")
+}
+
 // Build the template review request based solely on the parsed flag values.
 func buildRequestFromFlags(requester string) request.Request {
 	var reviewers []string
@@ -56,13 +94,441 @@ func buildRequestFromFlags(requester string) request.Request {
 	return request.New(requester, reviewers, *requestSource, *requestTarget, *requestMessage)
 }
 
+// resolveBase returns the commit named by the given base, after checking that it is an
+// ancestor of the given review ref.
+func resolveBase(repo repository.Repo, base, reviewRef string) (string, error) {
+	baseCommit, err := repo.GetCommitHash(base)
+	if err != nil {
+		return "", fmt.Errorf("Unknown base %q: %v", base, err)
+	}
+	isAncestor, err := repo.IsAncestor(baseCommit, reviewRef)
+	if err != nil {
+		return "", err
+	}
+	if !isAncestor {
+		return "", fmt.Errorf("The base %q is not an ancestor of %q.", base, reviewRef)
+	}
+	return baseCommit, nil
+}
+
+// findBaseReview returns the open review whose revision is named by the given base, or nil if
+// there is none, in which case the base is an ordinary commit.
+func findBaseReview(repo repository.Repo, base string) (*review.Review, error) {
+	baseCommit, err := repo.GetCommitHash(base)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown base %q: %v", base, err)
+	}
+	r, err := review.Get(repo, baseCommit)
+	if err != nil || r == nil || r.Submitted {
+		return nil, err
+	}
+	return r, nil
+}
+
+// resolveDependencies returns the revisions of the reviews named by the given comma-separated
+// list, after checking that depending on them would not make the review at the given revision
+// depend on itself.
+func resolveDependencies(repo repository.Repo, revision, dependsOn string) ([]string, error) {
+	var dependencies []string
+	for _, dependency := range strings.Split(dependsOn, ",") {
+		dependency = strings.TrimSpace(dependency)
+		if dependency == "" {
+			continue
+		}
+		dependencyRevision, err := repo.GetCommitHash(dependency)
+		if err != nil {
+			return nil, fmt.Errorf("Unknown dependency %q: %v", dependency, err)
+		}
+		r, err := review.Get(repo, dependencyRevision)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			return nil, fmt.Errorf("There is no review for the dependency %q.", dependency)
+		}
+		dependencies = append(dependencies, dependencyRevision)
+	}
+	if cycle := review.FindDependencyCycle(repo, revision, dependencies); cycle != nil {
+		return nil, fmt.Errorf("The dependencies would form a cycle: %s", formatDependencyCycle(cycle))
+	}
+	return dependencies, nil
+}
+
+// formatDependencyCycle returns a human friendly description of the given cycle of dependencies.
+func formatDependencyCycle(cycle []string) string {
+	var revisions []string
+	for _, revision := range cycle {
+		revisions = append(revisions, fmt.Sprintf("%.12s", revision))
+	}
+	return strings.Join(revisions, " -> ")
+}
+
+// getReviewToUpdate loads the review named by the given (optional) hash, or else the current review.
+func getReviewToUpdate(repo repository.Repo, args []string) (*review.Review, error) {
+	var r *review.Review
+	var err error
+	if len(args) > 1 {
+		return nil, errors.New("Only updating a single review is supported.")
+	}
+	if len(args) == 1 {
+		r, err = review.Get(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load the review: %v\n", err)
+	}
+	if r == nil {
+		return nil, noMatchingReview(args)
+	}
+	return r, nil
+}
+
+// writeUpdatedRequest writes a new version of the request of the given review, which supersedes
+// the previous one.
+func writeUpdatedRequest(repo repository.Repo, r *review.Review, updatedRequest request.Request) error {
+	// Any signature covers the old version of the request, so it no longer applies.
+	updatedRequest.Signature = ""
+	if err := signIfRequested(repo, *requestSign, updatedRequest.Sign); err != nil {
+		return err
+	}
+	note, err := updatedRequest.Write()
+	if err != nil {
+		return err
+	}
+	return repo.AppendNote(request.Ref, r.Revision, note)
+}
+
+// updateReviewBase rewrites the request of an existing review so that its base commit
+// is the current merge base of the review and target refs, or the given --base if there is one.
+//
+// The "args" parameter is the (optional) hash of the review to update.
+func updateReviewBase(repo repository.Repo, args []string) error {
+	r, err := getReviewToUpdate(repo, args)
+	if err != nil {
+		return err
+	}
+
+	targetHead, err := repo.ResolveRefCommit(r.Request.TargetRef)
+	if err != nil {
+		return err
+	}
+	reviewHead, err := r.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	var newBase string
+	if *requestBase != "" {
+		newBase, err = resolveBase(repo, *requestBase, reviewHead)
+	} else {
+		newBase, err = repo.MergeBase(targetHead, reviewHead)
+	}
+	if err != nil {
+		return err
+	}
+	oldBase := r.Request.BaseCommit
+	fixedBase := *requestBase != ""
+	if newBase == oldBase && fixedBase == r.Request.FixedBase {
+		fmt.Printf("The base commit of review %.12s is already up to date.\n", r.Revision)
+		return nil
+	}
+	if oldBase != "" {
+		newCommits, err := repo.ListCommitsBetween(oldBase, newBase)
+		if err != nil {
+			return err
+		}
+		if len(newCommits) > 0 {
+			fmt.Printf("Warning: the new base pulls %d commit(s) from %q into the review's base.\n", len(newCommits), r.Request.TargetRef)
+		}
+	}
+
+	// Everything else in the request (including the timestamp and requester) is left as-is.
+	updatedRequest := r.Request
+	updatedRequest.BaseCommit = newBase
+	updatedRequest.FixedBase = fixedBase
+	if err := writeUpdatedRequest(repo, r, updatedRequest); err != nil {
+		return err
+	}
+	fmt.Printf("Updated the base commit of review %.12s from %.12s to %.12s\n", r.Revision, oldBase, newBase)
+	return nil
+}
+
+// checkEmptyRequest returns the error for a request whose source has no commits to review,
+// which explains why that is. Requests that would just be empty are allowed if allowEmpty is set.
+func checkEmptyRequest(repo repository.Repo, r request.Request, allowEmpty bool) error {
+	var err error
+	if r.ReviewRef == r.TargetRef {
+		err = fmt.Errorf("Not requesting a review of %s against itself", r.ReviewRef)
+	} else if merged, mergedErr := repo.IsAncestor(r.ReviewRef, r.TargetRef); mergedErr != nil {
+		return mergedErr
+	} else if merged {
+		err = fmt.Errorf("Not requesting a review, as every commit of %s is already in %s", r.ReviewRef, r.TargetRef)
+	} else if builtOn, builtOnErr := repo.IsAncestor(r.BaseCommit, r.ReviewRef); builtOnErr != nil {
+		return builtOnErr
+	} else if !builtOn {
+		// The source has commits to review, but they cannot be listed against the base.
+		return CommandError{
+			Err: fmt.Errorf("Not requesting a review, as %s does not build on %.12s, the base of the review", r.ReviewRef, r.BaseCommit),
+			Guidance: fmt.Sprintf("Run \"git merge %s\" on the review branch, or pass --base, and then retry.",
+				strings.TrimPrefix(r.TargetRef, "refs/heads/")),
+			ExitCode: ExitPreconditionFailed,
+		}
+	} else {
+		err = fmt.Errorf("Not requesting a review, as %s has no commits after its base %.12s", r.ReviewRef, r.BaseCommit)
+	}
+	if allowEmpty {
+		return nil
+	}
+	return CommandError{
+		Err:      err,
+		Guidance: "Check out the branch to review, or pass --source or --target; pass --allow-empty to request the review anyway.",
+		ExitCode: ExitPreconditionFailed,
+	}
+}
+
+// findOpenReview returns the open review of the given review ref against the given target
+// ref, or nil if there is none.
+func findOpenReview(repo repository.Repo, reviewRef, targetRef string) *review.Review {
+	for _, r := range review.ListOpen(repo) {
+		if r.Request.ReviewRef == reviewRef && r.Request.TargetRef == targetRef {
+			r := r
+			return &r
+		}
+	}
+	return nil
+}
+
+// validateChecklist checks that the given checklist items are neither empty nor repeated, and
+// that there are some if they are required.
+func validateChecklist(items []string, required bool) error {
+	if required && len(items) == 0 {
+		return errors.New("The --require-checklist flag needs at least one --checklist-item.")
+	}
+	for i, item := range items {
+		if strings.TrimSpace(item) == "" {
+			return errors.New("Checklist items cannot be empty.")
+		}
+		for _, previous := range items[:i] {
+			if previous == item {
+				return fmt.Errorf("The checklist item %q is given more than once.", item)
+			}
+		}
+	}
+	return nil
+}
+
+// amendReview rewrites the request of an existing review with the message, reviewers, target,
+// dependencies, and checklist given on the command line. Anything not given is left as-is, as are the
+// timestamp and requester of the original request.
+//
+// The "args" parameter is the (optional) hash of the review to amend.
+func amendReview(repo repository.Repo, args []string) error {
+	if !isFlagSet(requestFlagSet, "m") && !isFlagSet(requestFlagSet, "r") &&
+		!isFlagSet(requestFlagSet, "target") && !isFlagSet(requestFlagSet, "depends-on") &&
+		!isFlagSet(requestFlagSet, "checklist-item") && !isFlagSet(requestFlagSet, "require-checklist") {
+		return errors.New("Nothing to amend; use the -m, -r, -target, -depends-on, -checklist-item, or -require-checklist flags to say what to change.")
+	}
+	r, err := getReviewToUpdate(repo, args)
+	if err != nil {
+		return err
+	}
+
+	updatedRequest := r.Request
+	if isFlagSet(requestFlagSet, "m") {
+		updatedRequest.Description = *requestMessage
+	}
+	if isFlagSet(requestFlagSet, "r") {
+		updatedRequest.Reviewers = buildRequestFromFlags("").Reviewers
+	}
+	if isFlagSet(requestFlagSet, "target") && *requestTarget != r.Request.TargetRef {
+		if err := repo.VerifyGitRef(*requestTarget); err != nil {
+			return err
+		}
+		updatedRequest.TargetRef = *requestTarget
+		if !updatedRequest.FixedBase {
+			if updatedRequest.BaseCommit, err = repo.GetCommitHash(*requestTarget); err != nil {
+				return err
+			}
+		}
+	}
+	if isFlagSet(requestFlagSet, "depends-on") {
+		if updatedRequest.DependsOn, err = resolveDependencies(repo, r.Revision, *requestDependsOn); err != nil {
+			return err
+		}
+	}
+	if isFlagSet(requestFlagSet, "checklist-item") {
+		updatedRequest.Checklist = requestChecklist
+	}
+	if isFlagSet(requestFlagSet, "require-checklist") {
+		updatedRequest.RequireChecklist = *requestRequireChecklist
+	}
+	if err := validateChecklist(updatedRequest.Checklist, updatedRequest.RequireChecklist); err != nil {
+		return err
+	}
+	updatedRequest.Amended = strconv.FormatInt(time.Now().Unix(), 10)
+	if err := writeUpdatedRequest(repo, r, updatedRequest); err != nil {
+		return err
+	}
+	if !*requestQuiet {
+		fmt.Printf("Amended review %.12s\n", r.Revision)
+	}
+	return nil
+}
+
+// requestPreview describes the review that a request would create.
+type requestPreview struct {
+	Request request.Request
+	// Commits holds the commits that the review would include, oldest first.
+	Commits []string
+	// HeadCommit is the commit that the review ref points to.
+	HeadCommit string
+	// Files holds the paths of the files changed by the review.
+	Files []string
+}
+
+// previewRequest computes the contents of the review that the given request, for the given
+// commits, would create.
+func previewRequest(repo repository.Repo, r request.Request, reviewCommits []string) (*requestPreview, error) {
+	headCommit, err := repo.GetCommitHash(r.ReviewRef)
+	if err != nil {
+		return nil, err
+	}
+	// This matches the diff shown for the review once it has been requested.
+	mergeBase, err := repo.MergeBase(r.BaseCommit, headCommit)
+	if err != nil {
+		return nil, err
+	}
+	changedFiles, err := repo.Diff(mergeBase, headCommit, "--name-only")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, file := range strings.Split(changedFiles, "\n") {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+	return &requestPreview{
+		Request:    r,
+		Commits:    reviewCommits,
+		HeadCommit: headCommit,
+		Files:      files,
+	}, nil
+}
+
+// printRequestPreview prints the review that a request would create.
+func printRequestPreview(repo repository.Repo, preview *requestPreview) error {
+	r := preview.Request
+	fmt.Printf(requestPreviewTemplate, preview.Commits[0], r.TargetRef, r.ReviewRef, r.Description, r.BaseCommit, preview.HeadCommit)
+	fmt.Printf("Commits (%d):\n", len(preview.Commits))
+	for _, commit := range preview.Commits {
+		message, err := repo.GetCommitMessage(commit)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  %.12s %s\n", commit, strings.SplitN(message, "\n", 2)[0])
+	}
+	fmt.Printf("Files changed (%d):\n", len(preview.Files))
+	for _, file := range preview.Files {
+		fmt.Printf("  %s\n", file)
+	}
+	return nil
+}
+
 // Create a new code review request.
 //
 // The "args" parameter is all of the command line arguments that followed the subcommand.
 func requestReview(repo repository.Repo, args []string) error {
-	requestFlagSet.Parse(args)
+	requestLabels = nil
+	requestChecklist = nil
+	if err := requestFlagSet.Parse(args); err != nil {
+		return err
+	}
+	if countTrue(*requestUpdateBase, *requestAmend, *requestSnapshot != "", *requestDiscardSnapshot) > 1 {
+		return errors.New("Only one of --update-base, --amend, --snapshot, or --discard-snapshot is allowed.")
+	}
+	if *requestUpdateBase {
+		return updateReviewBase(repo, requestFlagSet.Args())
+	}
+	if *requestAmend {
+		return amendReview(repo, requestFlagSet.Args())
+	}
+	if *requestDiscardSnapshot {
+		return discardSnapshotReview(repo, requestFlagSet.Args())
+	}
+	if *requestSnapshot != "" {
+		return requestSnapshotReview(repo)
+	}
+
+	opts := RequestOptions{
+		Message:          *requestMessage,
+		Reviewers:        buildRequestFromFlags("").Reviewers,
+		Source:           *requestSource,
+		Base:             *requestBase,
+		Labels:           requestLabels,
+		Checklist:        requestChecklist,
+		RequireChecklist: *requestRequireChecklist,
+		AllowUncommitted: *requestAllowUncommitted,
+		AllowEmpty:       *requestAllowEmpty,
+		AutoAssign:       *requestAutoAssign,
+		RequireSignoff:   *requestRequireSignoff,
+		NoVerify:         *requestNoVerify,
+		Sign:             *requestSign,
+		Quiet:            *requestQuiet,
+		DryRun:           *requestDryRun,
+	}
+	if isFlagSet(requestFlagSet, "target") {
+		opts.Target = *requestTarget
+	}
+	if *requestDependsOn != "" {
+		opts.DependsOn = strings.Split(*requestDependsOn, ",")
+	}
+	return Request(repo, opts)
+}
+
+// RequestOptions are the options of Request, which match the flags of the "request" command
+// that create a new review.
+type RequestOptions struct {
+	Message   string
+	Reviewers []string
+	// Source is the revision to review, or empty for HEAD.
+	Source string
+	// Target is the revision to review against, or empty for the one in the
+	// appraise.request.target setting, or else "refs/heads/master".
+	Target string
+	// Base is the ancestor of the source to use as the base of the review, in place of the
+	// target; if it is the hash of an open review, then the new review is stacked on it.
+	Base string
+	// DependsOn lists the revisions of reviews that have to be submitted before this one.
+	DependsOn []string
+	Labels    []string
+	// Checklist lists the items for reviewers to check.
+	Checklist []string
+	// RequireChecklist keeps the review from counting as accepted until every item of the
+	// checklist has been checked.
+	RequireChecklist bool
+	// AllowUncommitted allows requesting a review while there are uncommitted local changes.
+	AllowUncommitted bool
+	// AllowEmpty requests the review even if the source has no commits of its own, in which
+	// case the review is of the source's latest commit.
+	AllowEmpty bool
+	// AutoAssign picks the reviewers from the reviewer pool, unless Reviewers are given.
+	AutoAssign bool
+	// RequireSignoff warns about commits that are not signed off by their authors.
+	RequireSignoff bool
+	// NoVerify requests the review even if its commit messages break the configured rules.
+	NoVerify bool
+	// Sign signs the request with the configured signing key.
+	Sign bool
+	// Quiet suppresses the summary of the new review.
+	Quiet bool
+	// DryRun prints what the review would include, without requesting it.
+	DryRun bool
+}
 
-	if !*requestAllowUncommitted {
+// Request requests a new review, as the "request" command does.
+func Request(repo repository.Repo, opts RequestOptions) error {
+	if !opts.AllowUncommitted {
 		// Requesting a code review with uncommited local changes is usually a mistake, so
 		// we want to report that to the user instead of creating the request.
 		hasUncommitted, err := repo.HasUncommittedChanges()
@@ -78,7 +544,15 @@ func requestReview(repo repository.Repo, args []string) error {
 	if err != nil {
 		return err
 	}
-	r := buildRequestFromFlags(userEmail)
+	source := opts.Source
+	if source == "" {
+		source = "HEAD"
+	}
+	target := opts.Target
+	if target == "" {
+		target = repository.GetConfigValue(repo, requestTargetConfigKey, "refs/heads/master")
+	}
+	r := request.New(userEmail, opts.Reviewers, source, target, opts.Message)
 	if r.ReviewRef == "HEAD" {
 		headRef, err := repo.GetHeadRef()
 		if err != nil {
@@ -92,18 +566,58 @@ func requestReview(repo repository.Repo, args []string) error {
 	if err := repo.VerifyGitRef(r.ReviewRef); err != nil {
 		return err
 	}
-	base, err := repo.GetCommitHash(r.TargetRef)
+	var base string
+	var baseReview *review.Review
+	if opts.Base != "" {
+		if baseReview, err = findBaseReview(repo, opts.Base); err != nil {
+			return err
+		}
+	}
+	if baseReview != nil {
+		// The review is stacked on another one, and is based on its latest commit.
+		base, err = baseReview.GetHeadCommit()
+		if err == nil {
+			base, err = resolveBase(repo, base, r.ReviewRef)
+		}
+		r.BaseReview = baseReview.Revision
+		r.DependsOn = []string{baseReview.Revision}
+	} else if opts.Base != "" {
+		base, err = resolveBase(repo, opts.Base, r.ReviewRef)
+		r.FixedBase = true
+	} else {
+		base, err = repo.GetCommitHash(r.TargetRef)
+	}
 	if err != nil {
 		return err
 	}
 	r.BaseCommit = base
 
-	reviewCommits, err := repo.ListCommitsBetween(r.TargetRef, r.ReviewRef)
+	reviewCommits, err := repo.ListCommitsBetween(base, r.ReviewRef)
 	if err != nil {
 		return err
 	}
-	if reviewCommits == nil {
-		return errors.New("There are no commits included in the review request")
+	if len(reviewCommits) == 0 || r.ReviewRef == r.TargetRef {
+		if err := checkEmptyRequest(repo, r, opts.AllowEmpty); err != nil {
+			return err
+		}
+		if len(reviewCommits) == 0 {
+			// There is nothing to diff, so the review is of the latest commit on its own.
+			head, err := repo.GetCommitHash(r.ReviewRef)
+			if err != nil {
+				return err
+			}
+			reviewCommits = []string{head}
+			r.BaseCommit = head
+		}
+	}
+	if existing := findOpenReview(repo, r.ReviewRef, r.TargetRef); existing != nil && existing.Revision != reviewCommits[0] {
+		return CommandError{
+			Err: fmt.Errorf("Not requesting a review, as review %.12s of %s against %s is already open",
+				existing.Revision, r.ReviewRef, r.TargetRef),
+			Guidance: fmt.Sprintf("Update that review with \"git appraise request --update-base %.12s\" or "+
+				"\"git appraise request --amend %.12s\" instead.", existing.Revision, existing.Revision),
+			ExitCode: ExitPreconditionFailed,
+		}
 	}
 
 	if r.Description == "" {
@@ -114,13 +628,186 @@ func requestReview(repo repository.Repo, args []string) error {
 		r.Description = description
 	}
 
+	if len(opts.DependsOn) > 0 {
+		dependsOn, err := resolveDependencies(repo, reviewCommits[0], strings.Join(opts.DependsOn, ","))
+		if err != nil {
+			return err
+		}
+		for _, dependency := range dependsOn {
+			if dependency != r.BaseReview {
+				r.DependsOn = append(r.DependsOn, dependency)
+			}
+		}
+	}
+
+	for _, label := range opts.Labels {
+		if err := review.ValidateLabel(repo, label); err != nil {
+			return err
+		}
+	}
+	if err := validateChecklist(opts.Checklist, opts.RequireChecklist); err != nil {
+		return err
+	}
+	r.Checklist = opts.Checklist
+	r.RequireChecklist = opts.RequireChecklist
+
+	rules, err := review.ReadLintRules(repo)
+	if err != nil {
+		return err
+	}
+	var violations []review.LintViolation
+	if !rules.Empty() {
+		if violations, err = review.LintCommits(repo, rules, reviewCommits); err != nil {
+			return err
+		}
+		if len(violations) > 0 && !opts.NoVerify {
+			return lintError(violations)
+		}
+		if len(violations) > 0 {
+			fmt.Printf("Warning: requesting the review despite %d problem(s) with the commit messages.\n", len(violations))
+		}
+	}
+
+	if opts.RequireSignoff || review.SignoffRequired(repo) {
+		missing, err := review.FindMissingSignoffs(repo, reviewCommits)
+		if err != nil {
+			return err
+		}
+		if len(missing) > 0 {
+			fmt.Printf("Warning: %d commit(s) are not signed off by their authors, so the review cannot be submitted until they are:\n%s\n",
+				len(missing), formatMissingSignoffs(missing))
+		}
+	}
+
+	if opts.AutoAssign && len(opts.Reviewers) == 0 {
+		pool, err := review.ReadReviewerPool(repo)
+		if err != nil {
+			return err
+		}
+		r.Reviewers = pool.Assign(review.ListAll(repo), userEmail)
+	}
+
+	if opts.DryRun {
+		preview, err := previewRequest(repo, r, reviewCommits)
+		if err != nil {
+			return err
+		}
+		return printRequestPreview(repo, preview)
+	}
+
+	if err := writeRequest(repo, r, reviewCommits[0], userEmail, opts.Labels, opts.Sign, opts.Quiet); err != nil {
+		return err
+	}
+	if !rules.Empty() && repository.IsConfigTrue(repo, review.LintRecordConfigKey) {
+		return review.RecordLintResults(repo, reviewCommits[len(reviewCommits)-1], violations)
+	}
+	return nil
+}
+
+// writeRequest writes the given new request for the review of the given revision, along with
+// the given labels.
+func writeRequest(repo repository.Repo, r request.Request, revision, userEmail string, labels []string, sign, quiet bool) error {
+	if err := signIfRequested(repo, sign, r.Sign); err != nil {
+		return err
+	}
 	note, err := r.Write()
 	if err != nil {
 		return err
 	}
-	repo.AppendNote(request.Ref, reviewCommits[0], note)
+	if err := repo.AppendNote(request.Ref, revision, note); err != nil {
+		return err
+	}
+	if len(labels) > 0 {
+		newReview := &review.Review{Repo: repo, Revision: revision}
+		if err := newReview.SetLabels(userEmail, labels, nil); err != nil {
+			return err
+		}
+	}
+	if !quiet {
+		fmt.Printf(requestSummaryTemplate, revision, r.TargetRef, r.ReviewRef, r.Description)
+	}
+	return nil
+}
+
+// requestSnapshotReview requests a review of the uncommitted changes, which are recorded in a
+// temporary commit on top of HEAD, without touching the working tree, the index, or HEAD.
+func requestSnapshotReview(repo repository.Repo) error {
+	if *requestSnapshot != "staged" && *requestSnapshot != "working" {
+		return fmt.Errorf("Unknown snapshot %q; expected \"staged\" or \"working\".", *requestSnapshot)
+	}
+	if *requestSource != "HEAD" || *requestBase != "" || *requestDependsOn != "" || *requestDryRun {
+		return errors.New("The --snapshot flag cannot be combined with --source, --base, --depends-on, or --dry-run.")
+	}
+	userEmail, err := repo.GetUserEmail()
+	if err != nil {
+		return err
+	}
+	r := buildRequestFromFlags(userEmail)
+	if !isFlagSet(requestFlagSet, "target") {
+		// The changes are reviewed against the branch that they were made on.
+		if r.TargetRef, err = repo.GetHeadRef(); err != nil {
+			return fmt.Errorf("Unable to find the current branch to review the changes against (%v); use --target to name it.", err)
+		}
+	}
+	if err := repo.VerifyGitRef(r.TargetRef); err != nil {
+		return err
+	}
+	for _, label := range requestLabels {
+		if err := review.ValidateLabel(repo, label); err != nil {
+			return err
+		}
+	}
+	head, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		return err
+	}
+	if r.Description == "" {
+		r.Description = fmt.Sprintf("Uncommitted changes on %s", strings.TrimPrefix(r.TargetRef, "refs/heads/"))
+		if *requestSnapshot == "staged" {
+			r.Description = fmt.Sprintf("Staged changes on %s", strings.TrimPrefix(r.TargetRef, "refs/heads/"))
+		}
+	}
+	r.ReviewRef = review.SnapshotRefPrefix + strconv.FormatInt(time.Now().UnixNano(), 10)
+	snapshot, err := repo.SnapshotChanges(r.ReviewRef, r.Description, *requestSnapshot == "staged")
+	if err == repository.ErrNoChanges {
+		return fmt.Errorf("There are no %s changes to review.", *requestSnapshot)
+	} else if err != nil {
+		return err
+	}
+	// The review stays against the commit that the changes were made on, even if the branch moves on.
+	r.BaseCommit = head
+	r.FixedBase = true
+	return writeRequest(repo, r, snapshot, userEmail, requestLabels, *requestSign, *requestQuiet)
+}
+
+// discardSnapshotReview removes a review of uncommitted changes, along with its temporary commit.
+//
+// The "args" parameter is the hash of the review to discard.
+func discardSnapshotReview(repo repository.Repo, args []string) error {
+	if len(args) != 1 {
+		return errors.New("The --discard-snapshot flag requires a single review hash.")
+	}
+	r, err := getReviewToUpdate(repo, args)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(r.Request.ReviewRef, review.SnapshotRefPrefix) {
+		return fmt.Errorf("Review %.12s is not a review of uncommitted changes.", r.Revision)
+	}
+	if err := repo.DeleteRef(r.Request.ReviewRef); err != nil {
+		return err
+	}
+	now := time.Now()
+	orphan := review.Orphan{Revision: r.Revision, Request: r.Request}
+	err = review.PruneOrphans(repo, []review.Orphan{orphan}, func(notesRef string) string {
+		return gcBackupRef(now, notesRef)
+	})
+	if err != nil {
+		return err
+	}
 	if !*requestQuiet {
-		fmt.Printf(requestSummaryTemplate, reviewCommits[0], r.TargetRef, r.ReviewRef, r.Description)
+		fmt.Printf("Discarded review %.12s of uncommitted changes; its notes are saved under %q\n", r.Revision,
+			fmt.Sprintf("%s%d/", gcBackupRefPrefix, now.Unix()))
 	}
 	return nil
 }
@@ -128,10 +815,11 @@ func requestReview(repo repository.Repo, args []string) error {
 // requestCmd defines the "request" subcommand.
 var requestCmd = &Command{
 	Usage: func(arg0 string) {
-		fmt.Printf("Usage: %s request [<option>...]\n\nOptions:\n", arg0)
+		fmt.Printf("Usage: %s request [<option>...]\n       %s request --update-base [--base=<ref>] [<review-hash>]\n       %s request --amend [-m <message>] [-r <reviewers>] [-target <ref>] [-depends-on <revisions>] [<review-hash>]\n       %s request --snapshot=(staged|working) [<option>...]\n       %s request --discard-snapshot <review-hash>\n\nOptions:\n", arg0, arg0, arg0, arg0, arg0)
 		requestFlagSet.PrintDefaults()
 	},
 	RunMethod: func(repo repository.Repo, args []string) error {
 		return requestReview(repo, args)
 	},
+	Flags: requestFlagSet,
 }
@@ -17,6 +17,14 @@ limitations under the License.
 package commands
 
 import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/reviewtest"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -31,3 +39,419 @@ func TestBuildRequestFromFlags(t *testing.T) {
 		t.Fatalf("Unexpected reviewers list: '%v'", r.Reviewers)
 	}
 }
+
+func TestRequestDryRun(t *testing.T) {
+	repo := repository.NewRepoWithHistory(map[string][]string{
+		"master":  {"A"},
+		"feature": {"A", "B", "C"},
+	})
+	if err := repo.SwitchToRef("refs/heads/feature"); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { *requestDryRun = false }()
+	if err := requestReview(repo, []string{"-dry-run", "-m", "Feature", "-r", "", "-source", "HEAD", "-target", "refs/heads/master"}); err != nil {
+		t.Fatal(err)
+	}
+	if reviews := review.ListAll(repo); len(reviews) != 0 {
+		t.Fatalf("A dry run requested a review: %v", reviews)
+	}
+
+	r := buildRequestFromFlags("user@example.com")
+	r.ReviewRef = "refs/heads/feature"
+	r.BaseCommit = "A"
+	commits, err := repo.ListCommitsBetween(r.TargetRef, r.ReviewRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	preview, err := previewRequest(repo, r, commits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(preview.Commits) != 2 || len(preview.Files) != 2 || preview.Files[0] != "B.txt" || preview.Files[1] != "C.txt" {
+		t.Fatalf("Unexpected request preview: %+v", preview)
+	}
+	if preview.HeadCommit != "C" {
+		t.Fatalf("Unexpected head commit in the request preview: %q", preview.HeadCommit)
+	}
+
+	*requestDryRun = false
+	if err := requestReview(repo, []string{"-quiet", "-m", "Feature", "-r", "", "-source", "HEAD", "-target", "refs/heads/master"}); err != nil {
+		t.Fatal(err)
+	}
+	reviews := review.ListAll(repo)
+	if len(reviews) != 1 || reviews[0].Revision != preview.Commits[0] || reviews[0].Request.BaseCommit != r.BaseCommit {
+		t.Fatalf("The requested review does not match its preview: %v", reviews)
+	}
+}
+
+func TestRequestWithBase(t *testing.T) {
+	repo := repository.NewRepoWithHistory(map[string][]string{
+		"master":  {"A", "D"},
+		"feature": {"A", "B", "C"},
+	})
+	if err := repo.SwitchToRef("refs/heads/feature"); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { *requestBase = "" }()
+	if err := requestReview(repo, []string{"-quiet", "-m", "Feature", "-r", "", "-base", "master"}); err == nil {
+		t.Fatal("Unexpectedly requested a review against a base that is not an ancestor")
+	}
+	if err := requestReview(repo, []string{"-quiet", "-m", "Feature", "-r", "", "-base", "B"}); err != nil {
+		t.Fatal(err)
+	}
+	reviews := review.ListAll(repo)
+	if len(reviews) != 1 || reviews[0].Request.BaseCommit != "B" || !reviews[0].Request.FixedBase {
+		t.Fatalf("Unexpected reviews: %v", reviews)
+	}
+	if reviews[0].Revision != "C" {
+		t.Fatalf("Unexpected review revision: %q", reviews[0].Revision)
+	}
+	diff, err := reviews[0].GetDiff("--name-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(diff) != "C.txt" {
+		t.Fatalf("Unexpected diff against the chosen base: %q", diff)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	repo := repository.NewRepoWithHistory(map[string][]string{
+		"release": {"A"},
+		"feature": {"A", "B"},
+	})
+	repo.SetConfigValue(requestTargetConfigKey, "refs/heads/release")
+	if err := repo.SwitchToRef("refs/heads/release"); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := RequestOptions{Source: "refs/heads/feature", Reviewers: []string{"alice@example.com"}, Quiet: true}
+	if err := Request(repo, opts); err != nil {
+		t.Fatal(err)
+	}
+	reviews := review.ListAll(repo)
+	if len(reviews) != 1 {
+		t.Fatalf("Unexpected reviews: %+v", reviews)
+	}
+	r := reviews[0].Request
+	if reviews[0].Revision != "B" || r.ReviewRef != "refs/heads/feature" || r.TargetRef != "refs/heads/release" ||
+		r.Description != "B" || len(r.Reviewers) != 1 || r.Reviewers[0] != "alice@example.com" {
+		t.Errorf("Unexpected request: %+v", r)
+	}
+}
+
+func TestRequestFailsToWriteNote(t *testing.T) {
+	repo := repository.NewRepoWithHistory(map[string][]string{
+		"master":  {"A"},
+		"feature": {"A", "B"},
+	})
+	out, err := captureStdout(func() error {
+		return Request(noteFailingRepo{Repo: repo, failing: "B"}, RequestOptions{Source: "refs/heads/feature"})
+	})
+	if err == nil || !strings.Contains(err.Error(), "cannot write the note") {
+		t.Fatalf("Unexpected result of failing to write the request: %v", err)
+	}
+	if out != "" {
+		t.Errorf("Printed the summary of a review that was not written: %q", out)
+	}
+	if r, err := review.Get(repo, "B"); err != nil || r != nil {
+		t.Errorf("Unexpected review: %+v, %v", r, err)
+	}
+}
+
+func TestRequestEmptyRange(t *testing.T) {
+	repo := repository.NewRepoWithHistory(map[string][]string{
+		"master":   {"A", "B"},
+		"merged":   {"A"},
+		"diverged": {"A", "E"},
+		"feature":  {"A", "B", "C"},
+	})
+	for _, test := range []struct {
+		source, want string
+	}{
+		{"refs/heads/master", "against itself"},
+		{"refs/heads/merged", "already in refs/heads/master"},
+		{"refs/heads/diverged", "does not build on B"},
+	} {
+		err := Request(repo, RequestOptions{Source: test.source, Quiet: true})
+		if ExitCode(err) != ExitPreconditionFailed || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Unexpected result of requesting a review of %s: %v", test.source, err)
+		}
+	}
+	if reviews := review.ListAll(repo); len(reviews) != 0 {
+		t.Fatalf("Requested reviews of empty ranges: %+v", reviews)
+	}
+	if err := Request(repo, RequestOptions{Source: "refs/heads/merged", AllowEmpty: true, Quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.Get(repo, "A")
+	if err != nil || r == nil {
+		t.Fatalf("The empty review was not requested: %v", err)
+	}
+	if r.Request.BaseCommit != "A" {
+		t.Errorf("Unexpected base commit of the empty review: %q", r.Request.BaseCommit)
+	}
+
+	// Requesting a review of a rebased branch points at the open review rather than adding another one.
+	if err := Request(repo, RequestOptions{Source: "refs/heads/feature", Quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	repo.AddCommit("D", "D", nil, "B")
+	repo.SetRef("refs/heads/feature", "D")
+	err = Request(repo, RequestOptions{Source: "refs/heads/feature", Quiet: true})
+	if ExitCode(err) != ExitPreconditionFailed || !strings.Contains(err.Error(), "review C of refs/heads/feature") {
+		t.Fatalf("Unexpected result of requesting a duplicate review: %v", err)
+	}
+	if r, err := review.Get(repo, "D"); err != nil || r != nil {
+		t.Errorf("Requested a duplicate review: %+v, %v", r, err)
+	}
+}
+
+func TestUpdateReviewBase(t *testing.T) {
+	defer func() { *requestUpdateBase, *requestBase = false, "" }()
+	repo := repository.NewRepoWithHistory(map[string][]string{"master": {"A"}, "feature": {"A", "B"}})
+	if _, err := reviewtest.AddReview(repo, "refs/heads/feature", "refs/heads/master"); err != nil {
+		t.Fatal(err)
+	}
+	original, err := review.Get(repo, "B")
+	if err != nil || original == nil || original.Request.BaseCommit != "A" {
+		t.Fatalf("Unexpected review: %+v, %v", original, err)
+	}
+	// The target moves on, and is merged into the review, by someone else.
+	repo.AddCommit("C", "C", nil, "A")
+	repo.SetRef("refs/heads/master", "C")
+	repo.AddCommit("M", "Merge master", nil, "B", "C")
+	repo.SetRef("refs/heads/feature", "M")
+	if err := repo.SetConfigValue("user.email", "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := captureStdout(func() error { return requestReview(repo, []string{"-update-base", "B"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "from A to C") || !strings.Contains(out, "pulls 1 commit(s)") {
+		t.Errorf("Unexpected output of updating the base: %q", out)
+	}
+	updated, err := review.Get(repo, "B")
+	if err != nil || updated == nil {
+		t.Fatalf("Failed to load the updated review: %v", err)
+	}
+	if updated.Request.BaseCommit != "C" || updated.Request.FixedBase {
+		t.Errorf("The base was not updated: %+v", updated.Request)
+	}
+	if updated.Request.Timestamp != original.Request.Timestamp || updated.Request.Requester != original.Request.Requester ||
+		updated.Request.Description != original.Request.Description {
+		t.Errorf("Unexpected fields of the updated request: got %+v, want those of %+v", updated.Request, original.Request)
+	}
+
+	out, err = captureStdout(func() error { return requestReview(repo, []string{"-update-base", "B"}) })
+	if err != nil || !strings.Contains(out, "already up to date") {
+		t.Errorf("Unexpected result of updating a base that is up to date: %q, %v", out, err)
+	}
+
+	// An explicit base is kept as the base, even once the target moves on.
+	if _, err := captureStdout(func() error { return requestReview(repo, []string{"-update-base", "-base", "A", "B"}) }); err != nil {
+		t.Fatal(err)
+	}
+	if updated, err := review.Get(repo, "B"); err != nil || updated.Request.BaseCommit != "A" || !updated.Request.FixedBase ||
+		updated.Request.Requester != original.Request.Requester {
+		t.Errorf("Unexpected request after updating to an explicit base: %+v, %v", updated.Request, err)
+	}
+}
+
+func TestAmendReview(t *testing.T) {
+	repo := repository.NewMemoryRepoForTest()
+	original, err := review.Get(repo, repository.TestCommitG)
+	if err != nil || original == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+
+	defer func() { *requestAmend, *requestQuiet, *requestMessage, *requestReviewers = false, false, "", "" }()
+	if err := requestReview(repo, []string{"-amend", "-update-base", repository.TestCommitG}); err == nil {
+		t.Fatal("Unexpectedly allowed both --amend and --update-base")
+	}
+	*requestUpdateBase = false
+	if err := requestReview(repo, []string{"-quiet", "-amend", "-m", "Amended description", "-r", "alice, bob", repository.TestCommitG}); err != nil {
+		t.Fatal(err)
+	}
+	amended, err := review.Get(repo, repository.TestCommitG)
+	if err != nil || amended == nil {
+		t.Fatalf("Failed to load the amended review: %v", err)
+	}
+	if amended.Request.Description != "Amended description" || len(amended.Request.Reviewers) != 2 || amended.Request.Reviewers[1] != "bob" {
+		t.Fatalf("The review was not amended: %+v", amended.Request)
+	}
+	if amended.Request.Timestamp != original.Request.Timestamp || amended.Request.Requester != original.Request.Requester ||
+		amended.Request.TargetRef != original.Request.TargetRef || amended.Request.Amended == "" {
+		t.Fatalf("Unexpected fields of the amended request: %+v", amended.Request)
+	}
+	// The sign-offs follow the latest set of reviewers.
+	signOffs := amended.GetSignOffs()
+	var reviewers []string
+	for _, signOff := range signOffs {
+		reviewers = append(reviewers, signOff.Reviewer)
+	}
+	if strings.Join(reviewers, ",") != "alice,bob" {
+		t.Fatalf("Unexpected sign-offs after the reviewers were amended: %v", signOffs)
+	}
+	history := amended.GetRequestHistory()
+	if len(history) != 2 || history[0].Description != original.Request.Description || history[1].Description != "Amended description" {
+		t.Fatalf("Unexpected request history: %+v", history)
+	}
+}
+
+func TestRequestStacked(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("The git command line tool is not installed")
+	}
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "config", "user.email", "user@example.com")
+	runGit(t, dir, "config", "user.name", "Test User")
+	runGit(t, dir, "checkout", "-q", "-b", "master")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "First commit")
+	repo, err := repository.NewGitRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var revisions []string
+	for _, file := range []string{"a.txt", "b.txt"} {
+		runGit(t, dir, "checkout", "-q", "-b", "feature-"+file)
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+		runGit(t, dir, "add", file)
+		runGit(t, dir, "commit", "-q", "-m", "Add "+file)
+		args := []string{"-quiet", "-m", "Add " + file, "-r", "", "-target", "refs/heads/master"}
+		if len(revisions) > 0 {
+			args = append(args, "-base", revisions[0])
+		}
+		if err := requestReview(repo, args); err != nil {
+			t.Fatal(err)
+		}
+		revisions = append(revisions, strings.TrimSpace(runGit(t, dir, "rev-parse", "HEAD")))
+	}
+	*requestBase = ""
+
+	stacked, err := review.Get(repo, revisions[1])
+	if err != nil || stacked == nil {
+		t.Fatalf("Failed to load the stacked review: %v", err)
+	}
+	if stacked.Request.BaseReview != revisions[0] || len(stacked.Request.DependsOn) != 1 || stacked.Request.DependsOn[0] != revisions[0] ||
+		stacked.Request.FixedBase || stacked.Request.TargetRef != "refs/heads/master" {
+		t.Fatalf("Unexpected request for the stacked review: %+v", stacked.Request)
+	}
+	checkDiff := func() {
+		diff, err := stacked.GetDiff("--name-only")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(diff) != "b.txt" {
+			t.Fatalf("Unexpected diff of the stacked review: %q", diff)
+		}
+	}
+	checkDiff()
+	err = checkDependencies(repo, stacked, false)
+	if commandErr, ok := err.(CommandError); !ok || commandErr.ExitCode != ExitPreconditionFailed {
+		t.Fatalf("Unexpected result of submitting a review stacked on an open one: %v", err)
+	}
+
+	// Once the review underneath lands, the stacked one is compared against the target ref.
+	runGit(t, dir, "checkout", "-q", "master")
+	runGit(t, dir, "merge", "-q", "--ff-only", "feature-a.txt")
+	if stacked, err = review.Get(repo, revisions[1]); err != nil || stacked == nil {
+		t.Fatalf("Failed to reload the stacked review: %v", err)
+	}
+	checkDiff()
+	if err := checkDependencies(repo, stacked, false); err != nil {
+		t.Fatalf("Unexpected result of submitting a review whose base has landed: %v", err)
+	}
+}
+
+func TestRequestSnapshot(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("The git command line tool is not installed")
+	}
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "config", "user.email", "user@example.com")
+	runGit(t, dir, "config", "user.name", "Test User")
+	runGit(t, dir, "checkout", "-q", "-b", "master")
+	for _, file := range []string{"a.txt", "b.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+		runGit(t, dir, "add", file)
+	}
+	runGit(t, dir, "commit", "-q", "-m", "First commit")
+	repo, err := repository.NewGitRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { *requestSnapshot, *requestDiscardSnapshot = "", false }()
+	if err := requestReview(repo, []string{"-quiet", "-r", "", "-snapshot", "working"}); err == nil {
+		t.Fatal("Unexpectedly requested a review without any changes")
+	}
+
+	// Stage a change to one file, and leave a change to the other unstaged.
+	for _, file := range []string{"a.txt", "b.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(file+" changed"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runGit(t, dir, "add", "a.txt")
+	head := runGit(t, dir, "rev-parse", "HEAD")
+	status := runGit(t, dir, "status", "--porcelain")
+	for _, snapshot := range []string{"staged", "working"} {
+		if err := requestReview(repo, []string{"-quiet", "-m", "", "-r", "", "-snapshot", snapshot}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runGit(t, dir, "rev-parse", "HEAD") != head || runGit(t, dir, "status", "--porcelain") != status {
+		t.Fatal("Requesting a review of the uncommitted changes changed HEAD or the working tree")
+	}
+
+	reviews := review.ListAll(repo)
+	if len(reviews) != 2 {
+		t.Fatalf("Unexpected reviews: %v", reviews)
+	}
+	files := make(map[string]string)
+	for _, r := range reviews {
+		if !strings.HasPrefix(r.Request.ReviewRef, review.SnapshotRefPrefix) || r.Request.TargetRef != "refs/heads/master" {
+			t.Fatalf("Unexpected request for the uncommitted changes: %+v", r.Request)
+		}
+		diff, err := r.GetDiff("--name-only")
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[r.Request.Description] = strings.Join(strings.Fields(diff), ",")
+	}
+	if files["Staged changes on master"] != "a.txt" || files["Uncommitted changes on master"] != "a.txt,b.txt" {
+		t.Fatalf("Unexpected files under review: %v", files)
+	}
+	if orphans, err := review.FindOrphans(repo); err != nil || len(orphans) != 0 {
+		t.Fatalf("Reviews of uncommitted changes were reported as orphaned: %v, %v", orphans, err)
+	}
+
+	*requestSnapshot = ""
+	if err := requestReview(repo, []string{"-quiet", "-discard-snapshot", reviews[0].Revision}); err != nil {
+		t.Fatal(err)
+	}
+	if reviews := review.ListAll(repo); len(reviews) != 1 {
+		t.Fatalf("The discarded review is still listed: %v", reviews)
+	}
+	if err := repo.VerifyGitRef(reviews[0].Request.ReviewRef); err == nil {
+		t.Fatal("The temporary commit of the discarded review is still referenced")
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/commands/output"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+)
+
+var searchFlagSet = flag.NewFlagSet("search", flag.ContinueOnError)
+
+var (
+	searchOpenOnly = searchFlagSet.Bool("open-only", false, "Search only the open reviews")
+	searchAll      = searchFlagSet.Bool("all", false, "Search all reviews, including the submitted ones (the default)")
+	searchRegex    = searchFlagSet.Bool("regex", false, "Treat each term as a regular expression")
+	searchJson     = searchFlagSet.Bool("json", false, "Format the output as JSON")
+	searchNoCache  = searchFlagSet.Bool("no-cache", false, "Rebuild the cache of parsed reviews from scratch")
+	searchColor    = colorFlag(searchFlagSet)
+)
+
+// searchReviews lists the reviews whose descriptions, comments, commented file paths,
+// requester, or reviewers match every one of the given terms, ignoring case.
+func searchReviews(repo repository.Repo, args []string) error {
+	if err := searchFlagSet.Parse(args); err != nil {
+		return err
+	}
+	terms := searchFlagSet.Args()
+	if len(terms) == 0 {
+		return errors.New("Nothing to search for; give at least one term.")
+	}
+	if *searchOpenOnly && *searchAll {
+		return errors.New("Only one of --open-only or --all is allowed.")
+	}
+	query, err := review.NewSearchQuery(terms, *searchRegex)
+	if err != nil {
+		return fmt.Errorf("Invalid search term: %v", err)
+	}
+	if err := setupColor(repo, *searchColor); err != nil {
+		return err
+	}
+
+	var results []review.SearchResult
+	found := 0
+	progress := &listProgress{}
+	defer progress.clear()
+	review.WalkAllCached(repo, *searchNoCache, func(r review.Review) {
+		if *searchOpenOnly && !r.IsOpen() {
+			return
+		}
+		matches := query.Match(&r)
+		if matches == nil {
+			return
+		}
+		found++
+		result := review.SearchResult{Review: r, Matches: matches}
+		if *searchJson {
+			results = append(results, result)
+			return
+		}
+		progress.clear()
+		output.PrintSearchResult(result)
+	}, progress.update)
+	progress.clear()
+	if *searchJson {
+		return output.PrintSearchResultsJson(results)
+	}
+	fmt.Printf("Found %d matching reviews\n", found)
+	return nil
+}
+
+// searchCmd defines the "search" subcommand.
+var searchCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s search [<option>...] <term>...\n\nOptions:\n", arg0)
+		searchFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return searchReviews(repo, args)
+	},
+	Flags: searchFlagSet,
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"testing"
+)
+
+func TestSearchReviews(t *testing.T) {
+	repo := repository.NewMemoryRepoForTest()
+	defer func() {
+		*searchOpenOnly, *searchAll, *searchRegex, *searchJson = false, false, false, false
+	}()
+	if err := searchReviews(repo, nil); err == nil {
+		t.Fatal("Unexpectedly searched for nothing")
+	}
+	if err := searchReviews(repo, []string{"-open-only", "-all", "ojarjur"}); err == nil {
+		t.Fatal("Unexpectedly allowed both --open-only and --all")
+	}
+	*searchOpenOnly, *searchAll = false, false
+	if err := searchReviews(repo, []string{"-regex", "oj(arjur"}); err == nil {
+		t.Fatal("Unexpectedly accepted a malformed regular expression")
+	}
+	if err := searchReviews(repo, []string{"-regex=false", "-json", "ojarjur"}); err != nil {
+		t.Fatal(err)
+	}
+}
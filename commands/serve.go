@@ -0,0 +1,125 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/server"
+	"net/http"
+	"strings"
+)
+
+// remoteURLFlag is the name of the flag with which read-only commands read the reviews from
+// a git-appraise server, rather than from the local repository.
+const remoteURLFlag = "remote-url"
+
+var serveFlagSet = flag.NewFlagSet("serve", flag.ContinueOnError)
+
+var (
+	serveAddress  = serveFlagSet.String("addr", "localhost:8080", "Address on which to listen for HTTP requests")
+	serveInterval = serveFlagSet.Duration("interval", server.DefaultPollInterval, "How often to check for updated reviews")
+)
+
+var serveAllowedOrigins stringList
+
+func init() {
+	serveFlagSet.Var(&serveAllowedOrigins, "allow-origin", "Origin of a web page (such as \"https://dashboard.example.com\") that may connect to the updates, "+
+		"in addition to those served from the same host; may be repeated")
+}
+
+// serveReviews runs an HTTP server for the reviews in the repository, until the repo's
+// context is done, such as when the process is interrupted.
+func serveReviews(repo repository.Repo, args []string) error {
+	serveAllowedOrigins = nil
+	if err := serveFlagSet.Parse(args); err != nil {
+		return err
+	}
+	if len(serveFlagSet.Args()) > 0 {
+		return errors.New("The serve command does not take any arguments.")
+	}
+	if *serveInterval <= 0 {
+		return errors.New("The -interval flag must be positive.")
+	}
+	fmt.Printf("Serving review updates at ws://%s%s\n", *serveAddress, server.UpdatesPath)
+	fmt.Printf("Serving the reviews to \"--%s http://%s\"\n", remoteURLFlag, *serveAddress)
+	s := server.New(repo, *serveInterval)
+	s.AllowOrigins(serveAllowedOrigins...)
+	httpServer := &http.Server{Addr: *serveAddress, Handler: s}
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-repo.Context().Done():
+			httpServer.Shutdown(context.Background())
+		case <-stopped:
+		}
+	}()
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// openRemoteRepo returns the read-only repository served at the given URL, whose requests are
+// stopped along with those of the given (local) repo, if there is one.
+func openRemoteRepo(repo repository.Repo, url string) (repository.Repo, error) {
+	remote, err := server.NewRemoteRepo(url)
+	if err != nil {
+		return nil, err
+	}
+	if repo != nil {
+		remote = remote.WithContext(repo.Context())
+	}
+	return remote, nil
+}
+
+// UsesRemoteRepo returns whether or not the given arguments of the given command name a
+// git-appraise server to read the reviews from, in which case the command does not need
+// to be run from within a git repo.
+func UsesRemoteRepo(command string, args []string) bool {
+	subcommand, ok := CommandMap[command]
+	if !ok || subcommand.Flags == nil || subcommand.Flags.Lookup(remoteURLFlag) == nil {
+		return false
+	}
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == remoteURLFlag || strings.HasPrefix(name, remoteURLFlag+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// serveCmd defines the "serve" subcommand.
+var serveCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s serve [<option>...]\n\nOptions:\n", arg0)
+		serveFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return serveReviews(repo, args)
+	},
+	Flags:       serveFlagSet,
+	LongRunning: true,
+}
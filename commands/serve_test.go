@@ -0,0 +1,45 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"github.com/google/git-appraise/repository"
+	"testing"
+	"time"
+)
+
+func TestServeStopsWhenInterrupted(t *testing.T) {
+	defer resetFlags(serveFlagSet)
+	ctx, cancel := context.WithCancel(context.Background())
+	repo := repository.NewMemoryRepoForTest().WithContext(ctx)
+	done := make(chan error)
+	go func() {
+		_, err := captureStdout(func() error { return serveReviews(repo, []string{"-addr", "localhost:0"}) })
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("The server kept running after it was interrupted")
+	}
+}
@@ -23,21 +23,83 @@ import (
 	"github.com/google/git-appraise/commands/output"
 	"github.com/google/git-appraise/repository"
 	"github.com/google/git-appraise/review"
+	"io/ioutil"
+	"os"
 	"strings"
 )
 
-var showFlagSet = flag.NewFlagSet("show", flag.ExitOnError)
+var showFlagSet = flag.NewFlagSet("show", flag.ContinueOnError)
 var showJsonOutput = showFlagSet.Bool("json", false, "Format the output as JSON")
+var showPorcelainOutput = showFlagSet.Bool("porcelain", false, "Format the output as stable, tab separated lines for scripts, and never prompt for anything")
 var showDiffOutput = showFlagSet.Bool("diff", false, "Show the current diff for the review")
 var showDiffOptions = showFlagSet.String("diff-opts", "", "Options to pass to the diff tool; can only be used with the --diff option")
+var showCommentsOnly = showFlagSet.Bool("comments-only", false, "Only show the comments of the review")
+var showVerbose = showFlagSet.Bool("verbose", false, "Also show any malformed notes that were skipped")
+var showMetadataOnly = showFlagSet.Bool("metadata-only", false, "Only show the metadata of the review, without its comments")
+var showUnresolvedOnly = showFlagSet.Bool("unresolved-only", false, "Only show the comment threads that have unaddressed comments")
+var showResolvedOnly = showFlagSet.Bool("resolved-only", false, "Only show the comment threads that are resolved")
+var showHistory = showFlagSet.Bool("history", false, "Show every version of the review's request, oldest first")
+var showCITemplate = showFlagSet.String("ci-template", "", "File holding a Go text/template with which to render each CI report, in place of the latest build status")
+var showPatch = showFlagSet.Bool("patch", false, "Show the review's commits as email patches annotated with its comments, on lines starting with \"#appraise:\"; once those lines are removed, \"git am\" can apply the patches")
+var showFiles = showFlagSet.Bool("files", false, "List the files changed by the review, marking those that you have viewed")
+var showRemote = showFlagSet.String(remoteURLFlag, "", "Read the review from the git-appraise server (see \"serve\") at this URL, rather than from the local repository")
+var showColor = colorFlag(showFlagSet)
 
 // showReview prints the current code review.
 func showReview(repo repository.Repo, args []string) error {
-	showFlagSet.Parse(args)
+	if err := showFlagSet.Parse(args); err != nil {
+		return err
+	}
 	args = showFlagSet.Args()
 	if *showDiffOptions != "" && !*showDiffOutput {
 		return errors.New("The --diff-opts flag can only be used if the --diff flag is set.")
 	}
+	if *showCommentsOnly && *showMetadataOnly {
+		return errors.New("Only one of --comments-only or --metadata-only is allowed.")
+	}
+	if *showDiffOutput && (*showCommentsOnly || *showMetadataOnly) {
+		return errors.New("The --diff flag cannot be combined with --comments-only or --metadata-only.")
+	}
+	if *showUnresolvedOnly && *showResolvedOnly {
+		return errors.New("Only one of --unresolved-only or --resolved-only is allowed.")
+	}
+	if *showPorcelainOutput && (*showJsonOutput || *showDiffOutput || *showCommentsOnly || *showMetadataOnly) {
+		return errors.New("The --porcelain flag cannot be combined with --json, --diff, --comments-only, or --metadata-only.")
+	}
+	if *showHistory && (*showPorcelainOutput || *showDiffOutput || *showCommentsOnly || *showMetadataOnly) {
+		return errors.New("The --history flag cannot be combined with --porcelain, --diff, --comments-only, or --metadata-only.")
+	}
+	if *showFiles && (*showHistory || *showPorcelainOutput || *showDiffOutput || *showCommentsOnly || *showMetadataOnly) {
+		return errors.New("The --files flag cannot be combined with --history, --porcelain, --diff, --comments-only, or --metadata-only.")
+	}
+	if *showPatch && (*showFiles || *showHistory || *showPorcelainOutput || *showJsonOutput || *showDiffOutput || *showCommentsOnly || *showMetadataOnly) {
+		return errors.New("The --patch flag cannot be combined with --files, --history, --porcelain, --json, --diff, --comments-only, or --metadata-only.")
+	}
+	if *showRemote != "" {
+		if len(args) == 0 {
+			return fmt.Errorf("The review to show has to be given when using the --%s flag.", remoteURLFlag)
+		}
+		remote, err := openRemoteRepo(repo, *showRemote)
+		if err != nil {
+			return err
+		}
+		repo = remote
+	}
+	if *showPorcelainOutput {
+		disableInteraction()
+	}
+	if err := setupColor(repo, *showColor); err != nil {
+		return err
+	}
+	if *showCITemplate != "" {
+		templateBytes, err := ioutil.ReadFile(*showCITemplate)
+		if err != nil {
+			return err
+		}
+		if err := output.SetCITemplate(string(templateBytes)); err != nil {
+			return fmt.Errorf("Failed to parse the CI template %q: %v", *showCITemplate, err)
+		}
+	}
 
 	var r *review.Review
 	var err error
@@ -55,11 +117,61 @@ func showReview(repo repository.Repo, args []string) error {
 		return fmt.Errorf("Failed to load the review: %v\n", err)
 	}
 	if r == nil {
-		return errors.New("There is no matching review.")
+		return noMatchingReview(args)
+	}
+	if *showUnresolvedOnly {
+		r.Comments = review.FilterThreads(r.Comments, review.CommentThread.IsUnresolved)
+	} else if *showResolvedOnly {
+		r.Comments = review.FilterThreads(r.Comments, review.CommentThread.IsResolved)
+	}
+	if *showHistory {
+		if *showJsonOutput {
+			return output.PrintRequestHistoryJson(r)
+		}
+		output.PrintRequestHistory(r)
+		return nil
+	}
+	if *showPatch {
+		patches, err := getReviewPatches(repo, r)
+		if err != nil {
+			return err
+		}
+		output.PrintPatch(r, patches)
+		return nil
+	}
+	if *showFiles {
+		userEmail, err := repo.GetUserEmail()
+		if err != nil {
+			return err
+		}
+		files, err := r.GetChangedFiles(userEmail)
+		if err != nil {
+			return err
+		}
+		if *showJsonOutput {
+			return output.PrintChangedFilesJson(files)
+		}
+		output.PrintChangedFiles(files)
+		return nil
 	}
 	if *showJsonOutput {
+		if *showCommentsOnly {
+			return output.PrintCommentsJson(r)
+		}
+		if *showMetadataOnly {
+			return output.PrintMetadataJson(r)
+		}
 		return output.PrintJson(r)
 	}
+	if *showPorcelainOutput {
+		// Scripts get the full hash of the review, even if it was abbreviated on the command line.
+		if revision, err := repo.GetCommitHash(r.Revision); err == nil {
+			r.Revision = revision
+		}
+		output.PrintPorcelainVersion()
+		output.PrintPorcelainDetails(r)
+		return nil
+	}
 	if *showDiffOutput {
 		var diffArgs []string
 		if *showDiffOptions != "" {
@@ -67,7 +179,58 @@ func showReview(repo repository.Repo, args []string) error {
 		}
 		return output.PrintDiff(r, diffArgs...)
 	}
-	return output.PrintDetails(r)
+	if !*showMetadataOnly && *showRemote == "" {
+		// Record where the inline comments are in the latest revision of the review, so that
+		// they follow the lines they were made on as the review ref continues to move.
+		if _, err := r.UpdateAnchors(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to record the positions of the inline comments: %v\n", err)
+		}
+	}
+	if *showCommentsOnly {
+		return output.PrintComments(r)
+	}
+	if *showMetadataOnly {
+		output.PrintMetadata(r)
+	} else if err := output.PrintDetails(r); err != nil {
+		return err
+	}
+	if *showVerbose {
+		output.PrintNoteErrors(r)
+	}
+	return nil
+}
+
+// getReviewPatches returns the commits of the given review as email patches, as written by "git format-patch".
+func getReviewPatches(repo repository.Repo, r *review.Review) ([]string, error) {
+	baseCommit, err := r.GetBaseCommit()
+	if err != nil {
+		return nil, err
+	}
+	headCommit, err := r.GetHeadCommit()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", "git-appraise-patch-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	paths, err := repo.FormatPatch(baseCommit, headCommit, dir, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("Review %.12s has no commits to show as patches.", r.Revision)
+	}
+	var patches []string
+	for _, path := range paths {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, string(contents))
+	}
+	return patches, nil
 }
 
 // showCmd defines the "show" subcommand.
@@ -79,4 +242,5 @@ var showCmd = &Command{
 	RunMethod: func(repo repository.Repo, args []string) error {
 		return showReview(repo, args)
 	},
+	Flags: showFlagSet,
 }
@@ -0,0 +1,194 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/reviewtest"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShowPatch(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("The git command line tool is not installed")
+	}
+	defer func() { *showPatch = false }()
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "config", "user.email", "user@example.com")
+	runGit(t, dir, "config", "user.name", "Test User")
+	runGit(t, dir, "checkout", "-q", "-b", "master")
+	writeFile := func(contents string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		runGit(t, dir, "add", "a.txt")
+	}
+	writeFile("one\ntwo\nthree\n")
+	runGit(t, dir, "commit", "-q", "-m", "First commit")
+	runGit(t, dir, "checkout", "-q", "-b", "feature")
+	writeFile("one\n2\nthree\n")
+	runGit(t, dir, "commit", "-q", "-m", "Change the second line")
+	writeFile("one\n2\nthree\nfour\n")
+	runGit(t, dir, "commit", "-q", "-m", "Add a fourth line")
+	repo, err := repository.NewGitRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Request(repo, RequestOptions{Target: "refs/heads/master", Message: "Update a.txt", Quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Comment(repo, CommentOptions{Message: "Spell it out", File: "a.txt", Line: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Comment(repo, CommentOptions{Message: "Looks fine overall"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := showReview(repo, []string{"-patch", "-json"}); err == nil {
+		t.Fatal("Unexpectedly allowed combining --patch and --json")
+	}
+	*showJsonOutput = false
+	patch, err := captureStdout(func() error {
+		return showReview(repo, []string{"-patch"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(patch, "#appraise:   Looks fine overall\n") {
+		t.Errorf("The review comment is missing from the patch:\n%s", patch)
+	}
+	if !strings.Contains(patch, "\n 2\n#appraise: user@example.com (fyi)") {
+		t.Errorf("The inline comment does not follow the line it was made on:\n%s", patch)
+	}
+
+	// Once the annotations are removed, the patches apply on top of the target.
+	var stripped []string
+	for _, line := range strings.Split(patch, "\n") {
+		if !strings.HasPrefix(line, "#appraise:") {
+			stripped = append(stripped, line)
+		}
+	}
+	mailbox := filepath.Join(dir, "review.mbox")
+	if err := ioutil.WriteFile(mailbox, []byte(strings.Join(stripped, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, dir, "checkout", "-q", "-b", "applied", "master")
+	runGit(t, dir, "am", "-q", mailbox)
+	if diff := runGit(t, dir, "diff", "feature", "applied"); diff != "" {
+		t.Errorf("The applied patches differ from the review:\n%s", diff)
+	}
+}
+
+func TestShowCommits(t *testing.T) {
+	repo := repository.NewRepoWithHistory(map[string][]string{"master": {"A"}, "feature": {"A", "B", "C"}})
+	if _, err := reviewtest.AddReview(repo, "refs/heads/feature", "refs/heads/master"); err != nil {
+		t.Fatal(err)
+	}
+	details, err := captureStdout(func() error { return showReview(repo, []string{"B"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(details, "  commits (2):\n    B B (user@example.com)\n    C C (user@example.com)\n") {
+		t.Errorf("The commits of the review are not listed in order:\n%s", details)
+	}
+
+	// A stored base commit leaves out the commits before it.
+	r, err := review.Get(repo, "B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	updatedRequest := r.Request
+	updatedRequest.BaseCommit, updatedRequest.FixedBase = "B", true
+	if err := writeUpdatedRequest(repo, r, updatedRequest); err != nil {
+		t.Fatal(err)
+	}
+	details, err = captureStdout(func() error { return showReview(repo, []string{"B"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(details, "  commits (1):\n    C C (user@example.com)\n") {
+		t.Errorf("The commits before the stored base commit are listed:\n%s", details)
+	}
+}
+
+func TestShowCommentsOrMetadataOnly(t *testing.T) {
+	defer resetFlags(showFlagSet)
+	repo := repository.NewMemoryRepoForTest()
+	if _, err := reviewtest.AddComment(repo, repository.TestCommitG, comment.Comment{Description: "Needs a test"}); err != nil {
+		t.Fatal(err)
+	}
+	show := func(args ...string) (string, error) {
+		resetFlags(showFlagSet)
+		return captureStdout(func() error { return showReview(repo, append(args, repository.TestCommitG)) })
+	}
+	const metadata = `"refs/heads/ojarjur/mychange" -> "refs/heads/master"`
+
+	for _, args := range [][]string{
+		{"-comments-only", "-metadata-only"},
+		{"-json", "-comments-only", "-metadata-only"},
+		{"-diff", "-comments-only"},
+		{"-porcelain", "-metadata-only"},
+	} {
+		if _, err := show(args...); err == nil {
+			t.Errorf("Unexpectedly allowed showing a review with %q", args)
+		}
+	}
+
+	details, err := show()
+	if err != nil || !strings.Contains(details, metadata) || !strings.Contains(details, "Needs a test") {
+		t.Fatalf("Unexpected details of the review: %q, %v", details, err)
+	}
+	comments, err := show("-comments-only")
+	if err != nil || strings.Contains(comments, metadata) || !strings.Contains(comments, "Needs a test") {
+		t.Errorf("Unexpected comments of the review: %q, %v", comments, err)
+	}
+	onlyMetadata, err := show("-metadata-only")
+	if err != nil || !strings.Contains(onlyMetadata, metadata) || strings.Contains(onlyMetadata, "Needs a test") {
+		t.Errorf("Unexpected metadata of the review: %q, %v", onlyMetadata, err)
+	}
+
+	// With --json, they print just the comment threads, or the review without them.
+	commentsJSON, err := show("-json", "-comments-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var threads []review.CommentThread
+	if err := json.Unmarshal([]byte(commentsJSON), &threads); err != nil || len(threads) != 1 || threads[0].Comment.Description != "Needs a test" {
+		t.Errorf("Unexpected comments of the review as JSON: %q, %v", commentsJSON, err)
+	}
+	metadataJSON, err := show("-json", "-metadata-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r review.Review
+	if err := json.Unmarshal([]byte(metadataJSON), &r); err != nil || r.Request.TargetRef != "refs/heads/master" || len(r.Comments) != 0 {
+		t.Errorf("Unexpected metadata of the review as JSON: %q, %v", metadataJSON, err)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+var statsFlagSet = flag.NewFlagSet("stats", flag.ContinueOnError)
+
+var (
+	statsJsonOutput = statsFlagSet.Bool("json", false, "Format the output as JSON")
+	statsSince      = statsFlagSet.String("since", "", "Only include reviews requested since the given date (YYYY-MM-DD) or duration ago (e.g. 720h)")
+)
+
+// reviewStats holds the aggregate metrics computed over a set of reviews.
+type reviewStats struct {
+	Reviews                    int            `json:"reviews"`
+	OpenReviews                int            `json:"openReviews"`
+	AcceptedReviews            int            `json:"acceptedReviews"`
+	AverageSecondsToAcceptance float64        `json:"averageSecondsToAcceptance"`
+	MedianCommentCount         float64        `json:"medianCommentCount"`
+	OpenReviewsPerReviewer     map[string]int `json:"openReviewsPerReviewer"`
+}
+
+// parseSince parses the value of the --since flag, relative to the given time.
+func parseSince(since string, now time.Time) (time.Time, error) {
+	if since == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", since); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(since)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid --since value %q; expected a date (YYYY-MM-DD) or a duration.", since)
+	}
+	return now.Add(-d), nil
+}
+
+// parseTimestamp parses a timestamp stored in a git note.
+func parseTimestamp(timestamp string) (time.Time, error) {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// countComments returns the number of comments within the given threads, including replies.
+func countComments(threads []review.CommentThread) int {
+	count := 0
+	for _, thread := range threads {
+		count += 1 + countComments(thread.Children)
+	}
+	return count
+}
+
+// getAcceptanceTime returns the time of the latest accepting comment on an accepted review.
+func getAcceptanceTime(r review.Review) (time.Time, bool) {
+	if r.Resolved == nil || !*r.Resolved {
+		return time.Time{}, false
+	}
+	var acceptance time.Time
+	found := false
+	for _, thread := range r.Comments {
+		if thread.Comment.Resolved == nil || !*thread.Comment.Resolved {
+			continue
+		}
+		t, err := parseTimestamp(thread.Comment.Timestamp)
+		if err == nil && (!found || t.After(acceptance)) {
+			acceptance = t
+			found = true
+		}
+	}
+	return acceptance, found
+}
+
+// computeStats computes the aggregate metrics for all of the given reviews requested at or after the given time.
+func computeStats(reviews []review.Review, since time.Time) reviewStats {
+	stats := reviewStats{
+		OpenReviewsPerReviewer: make(map[string]int),
+	}
+	var totalSecondsToAcceptance float64
+	var commentCounts []int
+	for _, r := range reviews {
+		requested, err := parseTimestamp(r.Request.Timestamp)
+		if err != nil || requested.Before(since) {
+			continue
+		}
+		stats.Reviews++
+		commentCounts = append(commentCounts, countComments(r.Comments))
+		if r.IsOpen() {
+			stats.OpenReviews++
+			for _, reviewer := range r.Request.Reviewers {
+				stats.OpenReviewsPerReviewer[reviewer]++
+			}
+		}
+		if accepted, ok := getAcceptanceTime(r); ok {
+			stats.AcceptedReviews++
+			totalSecondsToAcceptance += accepted.Sub(requested).Seconds()
+		}
+	}
+	if stats.AcceptedReviews > 0 {
+		stats.AverageSecondsToAcceptance = totalSecondsToAcceptance / float64(stats.AcceptedReviews)
+	}
+	if len(commentCounts) > 0 {
+		sort.Ints(commentCounts)
+		middle := len(commentCounts) / 2
+		if len(commentCounts)%2 == 1 {
+			stats.MedianCommentCount = float64(commentCounts[middle])
+		} else {
+			stats.MedianCommentCount = float64(commentCounts[middle-1]+commentCounts[middle]) / 2
+		}
+	}
+	return stats
+}
+
+// printStats prints the given metrics as a table.
+func printStats(stats reviewStats) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Reviews:\t%d\n", stats.Reviews)
+	fmt.Fprintf(w, "Open reviews:\t%d\n", stats.OpenReviews)
+	fmt.Fprintf(w, "Accepted reviews:\t%d\n", stats.AcceptedReviews)
+	timeToAcceptance := time.Duration(stats.AverageSecondsToAcceptance) * time.Second
+	fmt.Fprintf(w, "Average time to acceptance:\t%s\n", timeToAcceptance)
+	fmt.Fprintf(w, "Median comment count:\t%g\n", stats.MedianCommentCount)
+	w.Flush()
+
+	var reviewers []string
+	for reviewer := range stats.OpenReviewsPerReviewer {
+		reviewers = append(reviewers, reviewer)
+	}
+	sort.Strings(reviewers)
+	fmt.Println("Open reviews per reviewer:")
+	for _, reviewer := range reviewers {
+		fmt.Fprintf(w, "  %s\t%d\n", reviewer, stats.OpenReviewsPerReviewer[reviewer])
+	}
+	w.Flush()
+}
+
+// showStats prints aggregate metrics about all of the reviews in the repo.
+func showStats(repo repository.Repo, args []string) error {
+	if err := statsFlagSet.Parse(args); err != nil {
+		return err
+	}
+	since, err := parseSince(*statsSince, time.Now())
+	if err != nil {
+		return err
+	}
+	stats := computeStats(review.ListAll(repo), since)
+	if *statsJsonOutput {
+		jsonBytes, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonBytes))
+		return nil
+	}
+	printStats(stats)
+	return nil
+}
+
+// statsCmd defines the "stats" subcommand.
+var statsCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s stats [<option>...]\n\nOptions:\n", arg0)
+		statsFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return showStats(repo, args)
+	},
+	Flags: statsFlagSet,
+}
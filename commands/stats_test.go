@@ -0,0 +1,47 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"testing"
+	"time"
+)
+
+func TestComputeStats(t *testing.T) {
+	repo := repository.NewMemoryRepoForTest()
+	stats := computeStats(review.ListAll(repo), time.Time{})
+	if stats.Reviews != 3 || stats.OpenReviews != 1 || stats.AcceptedReviews != 2 {
+		t.Fatalf("Unexpected review counts: %+v", stats)
+	}
+	// Review "B" was accepted immediately, and review "D" was accepted a second after being requested.
+	if stats.AverageSecondsToAcceptance != 0.5 {
+		t.Fatalf("Unexpected average time to acceptance: %v", stats.AverageSecondsToAcceptance)
+	}
+	if stats.MedianCommentCount != 1 {
+		t.Fatalf("Unexpected median comment count: %v", stats.MedianCommentCount)
+	}
+	if len(stats.OpenReviewsPerReviewer) != 1 || stats.OpenReviewsPerReviewer["ojarjur"] != 1 {
+		t.Fatalf("Unexpected open reviews per reviewer: %v", stats.OpenReviewsPerReviewer)
+	}
+
+	stats = computeStats(review.ListAll(repo), time.Unix(3, 0))
+	if stats.Reviews != 1 || stats.AcceptedReviews != 0 {
+		t.Fatalf("Unexpected review counts after filtering: %+v", stats)
+	}
+}
@@ -17,29 +17,395 @@ limitations under the License.
 package commands
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/google/git-appraise/repository"
 	"github.com/google/git-appraise/review"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
-var submitFlagSet = flag.NewFlagSet("submit", flag.ExitOnError)
+// submitStrategyConfigKey is the config key holding the default way to submit reviews: one
+// of "merge", "rebase", "squash", or "ff" (fast-forward).
+const submitStrategyConfigKey = "appraise.submit.strategy"
+
+// submitStateFile is the name of the file (inside of the git directory) that records a
+// submission which stopped because of conflicts, until "submit --continue" finishes it.
+const submitStateFile = "appraise-submit"
+
+// submitStrategies lists the possible values of the submitStrategyConfigKey setting.
+var submitStrategies = []string{"merge", "rebase", "squash", "ff"}
+
+// SubmitStrategy is the way that Submit lands a review on its target ref.
+type SubmitStrategy string
+
+const (
+	// SubmitDefault uses the strategy in the appraise.submit.strategy setting, or else SubmitFF.
+	SubmitDefault SubmitStrategy = ""
+	// SubmitMerge creates a merge of the source and target refs.
+	SubmitMerge SubmitStrategy = "merge"
+	// SubmitRebase rebases the source ref onto the target ref.
+	SubmitRebase SubmitStrategy = "rebase"
+	// SubmitSquash squashes the source ref into a single commit on the target ref.
+	SubmitSquash SubmitStrategy = "squash"
+	// SubmitFF fast-forwards the target ref to the source ref.
+	SubmitFF SubmitStrategy = "ff"
+)
+
+// SubmitOptions are the options of Submit, which match the flags of the "submit" command.
+type SubmitOptions struct {
+	Strategy SubmitStrategy
+	// Autostash stashes any uncommitted changes before submitting, and restores them afterward.
+	Autostash bool
+	// Sign signs the commits created by the merge, rebase, or squash strategies.
+	Sign bool
+	// TBR (to be reviewed) submits the review even if it has not been accepted.
+	TBR bool
+	// Strict refuses to submit a review whose dependencies have not all been submitted.
+	Strict bool
+	// RequireSignoff refuses to submit a review with commits that are not signed off.
+	RequireSignoff bool
+	// Signoff adds a Signed-off-by trailer to the commit created by the merge or squash strategies.
+	Signoff bool
+	// KeepReviewRef keeps the review ref once the review has been submitted.
+	KeepReviewRef bool
+	// DeleteRemote also deletes the review ref from the remote that it tracks.
+	DeleteRemote bool
+	// Wait returns an error with ExitNotYetAccepted if the review has not been accepted yet.
+	Wait bool
+	// NoVerifyRefs does not require the source and target refs to exist locally.
+	NoVerifyRefs bool
+	// Onto submits the review onto the given ref, in place of the target ref of its request,
+	// which is left unchanged.
+	Onto string
+}
+
+var submitFlagSet = flag.NewFlagSet("submit", flag.ContinueOnError)
 
 var (
-	submitMerge  = submitFlagSet.Bool("merge", false, "Create a merge of the source and target refs.")
-	submitRebase = submitFlagSet.Bool("rebase", false, "Rebase the source ref onto the target ref.")
-	submitTBR    = submitFlagSet.Bool("tbr", false, "(To be reviewed) Force the submission of a review that has not been accepted.")
+	submitAutostash      = submitFlagSet.Bool("autostash", false, "Stash any uncommitted changes before submitting, and restore them afterward.")
+	submitMerge          = submitFlagSet.Bool("merge", false, "Create a merge of the source and target refs.")
+	submitRebase         = submitFlagSet.Bool("rebase", false, "Rebase the source ref onto the target ref.")
+	submitSquash         = submitFlagSet.Bool("squash", false, "Squash the source ref into a single commit on the target ref.")
+	submitFF             = submitFlagSet.Bool("ff", false, "Fast-forward the target ref to the source ref; this is the default unless the "+submitStrategyConfigKey+" setting says otherwise.")
+	submitSign           = submitFlagSet.Bool("S", false, "Sign the commits created by --merge, --rebase, or --squash, even if commit.gpgsign is not set.")
+	submitTBR            = submitFlagSet.Bool("tbr", false, "(To be reviewed) Force the submission of a review that has not been accepted.")
+	submitStrict         = submitFlagSet.Bool("strict", false, "Refuse to submit a review whose dependencies have not all been submitted, instead of just warning about them.")
+	submitRequireSignoff = submitFlagSet.Bool("require-signoff", false, "Refuse to submit a review with commits that are not signed off by their authors, even if "+review.RequireSignoffConfigKey+" is not set.")
+	submitSignoff        = submitFlagSet.Bool("signoff", false, "Add your Signed-off-by trailer to the commit created by --merge or --squash.")
+	submitKeepReviewRef  = submitFlagSet.Bool("keep-review-ref", false, "Keep the review ref once the review has been submitted, rather than deleting it.")
+	submitDeleteRemote   = submitFlagSet.Bool("delete-remote", false, "Also delete the review ref from the remote that it tracks (or \"origin\") once the review has been submitted.")
+	submitWait           = submitFlagSet.Bool("wait", false, fmt.Sprintf("If the review has not been accepted yet, exit with code %d rather than failing, so that scripts can try again later.", ExitNotYetAccepted))
+	// Skipping the ref verification means that a target ref which is missing locally is resolved
+	// through the remote-tracking refs, so a stale remote ref can lead to a submission on top of
+	// an unexpected commit.
+	submitNoVerifyRefs = submitFlagSet.Bool("no-verify-refs", false, "Do not require the source and target refs to exist locally. Use with care: this can submit against an unexpected commit.")
+	submitOnto         = submitFlagSet.String("onto", "", "Submit onto the given ref rather than the target ref of the review, such as to land an approved fix on a release branch. The review keeps its original target.")
+	submitContinue     = submitFlagSet.Bool("continue", false, "Finish a submission that stopped because of conflicts, once they have been resolved and marked as such with \"git add\".")
+	submitAbort        = submitFlagSet.Bool("abort", false, "Give up on a submission that stopped because of conflicts, and check out what was checked out before it.")
 )
 
-// Submit the current code review request.
+// pendingSubmit is a submission that stopped because of conflicts, with the merge or squash
+// left in progress on the target ref for the user to resolve.
+type pendingSubmit struct {
+	Revision  string `json:"revision"`
+	TargetRef string `json:"targetRef"`
+	// OriginalHead is what was checked out before the submission, for "submit --abort" to restore.
+	OriginalHead  string   `json:"originalHead"`
+	Sign          bool     `json:"sign,omitempty"`
+	Messages      []string `json:"messages,omitempty"`
+	KeepReviewRef bool     `json:"keepReviewRef,omitempty"`
+	DeleteRemote  bool     `json:"deleteRemote,omitempty"`
+}
+
+// submitStatePath returns the path of the file recording the pending submission of the given repo.
+func submitStatePath(repo repository.Repo) (string, error) {
+	gitDir, err := repo.GetGitDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(gitDir, submitStateFile), nil
+}
+
+// loadPendingSubmit returns the submission that stopped because of conflicts, or nil if there is none.
+func loadPendingSubmit(repo repository.Repo) (*pendingSubmit, error) {
+	path, err := submitStatePath(repo)
+	if err != nil {
+		return nil, err
+	}
+	stateBytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var pending pendingSubmit
+	if err := json.Unmarshal(stateBytes, &pending); err != nil {
+		return nil, fmt.Errorf("Failed to parse the pending submission %q: %v", path, err)
+	}
+	return &pending, nil
+}
+
+// savePendingSubmit records the given submission as stopped because of conflicts.
+func savePendingSubmit(repo repository.Repo, pending pendingSubmit) error {
+	path, err := submitStatePath(repo)
+	if err != nil {
+		return err
+	}
+	stateBytes, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, stateBytes, 0644)
+}
+
+// clearPendingSubmit forgets the submission that stopped because of conflicts, unless the
+// repo only records what would be changed.
+func clearPendingSubmit(repo repository.Repo) error {
+	if repository.IsRecordOnly(repo) {
+		return nil
+	}
+	path, err := submitStatePath(repo)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// countTrue returns the number of the given flags that are set.
+func countTrue(flags ...bool) int {
+	count := 0
+	for _, flag := range flags {
+		if flag {
+			count++
+		}
+	}
+	return count
+}
+
+// getOriginalHead returns what is checked out: the current ref, or the commit if HEAD is detached.
+func getOriginalHead(repo repository.Repo) (string, error) {
+	if ref, err := repo.GetHeadRef(); err == nil {
+		return ref, nil
+	}
+	return repo.GetCommitHash("HEAD")
+}
+
+// restoreOriginalHead undoes a submission that failed after switching to the target ref,
+// by aborting whatever the submission left in progress and checking out originalHead.
 //
-// The "args" parameter contains all of the command line arguments that followed the subcommand.
-func submitReview(repo repository.Repo, args []string) error {
-	submitFlagSet.Parse(args)
+// The returned error is always non-nil, and includes the instructions for recovering
+// manually if originalHead could not be restored.
+func restoreOriginalHead(repo repository.Repo, originalHead string, rebase bool, submitErr error) error {
+	err := repo.AbortMerge()
+	if err == nil {
+		err = repo.SwitchToRef(originalHead)
+	}
+	checkout := strings.TrimPrefix(originalHead, "refs/heads/")
+	if err != nil {
+		abort := "git merge --abort"
+		if rebase {
+			abort = "git rebase --abort"
+		}
+		return fmt.Errorf("%v\nFailed to restore the original HEAD (%s): %v\nTo recover, run:\n    %s\n    git checkout %s",
+			submitErr, checkout, err, abort, checkout)
+	}
+	fmt.Fprintf(os.Stderr, "The submission failed, so the original HEAD (%s) was checked out again.\n", checkout)
+	return submitErr
+}
+
+// listConflicts returns the given files with conflicts, one per indented line.
+func listConflicts(conflicts []string) string {
+	return "    " + strings.Join(conflicts, "\n    ")
+}
+
+// mergeInProgressError returns the error for there already being a merge of the given
+// kind ("merge" or "rebase") in progress, with the given files still in conflict.
+//
+// A second submission is refused while one is in progress, since switching refs would
+// either fail or carry the half-merged files along with it.
+func mergeInProgressError(kind string, conflicts []string) error {
+	guidance := fmt.Sprintf("Finish it with \"git %s --continue\", or give up on it with \"git %s --abort\", and then submit again.", kind, kind)
+	if len(conflicts) > 0 {
+		guidance = fmt.Sprintf("The files with conflicts are:\n%s\nResolve them, mark each one as resolved with \"git add <file>\", "+
+			"and run \"git %s --continue\"; or give up on it with \"git %s --abort\". Then submit again.",
+			listConflicts(conflicts), kind, kind)
+	}
+	return CommandError{
+		Err:      fmt.Errorf("Not submitting as a %s is already in progress", kind),
+		Guidance: guidance,
+		ExitCode: ExitPreconditionFailed,
+	}
+}
+
+// pendingSubmitGuidance describes how to finish or give up on a submission that stopped
+// with the given files still in conflict.
+func pendingSubmitGuidance(conflicts []string) string {
+	guidance := "Run \"git appraise submit --continue\" to finish it"
+	if len(conflicts) > 0 {
+		guidance = fmt.Sprintf("The files with conflicts are:\n%s\nResolve them, mark each one as resolved with \"git add <file>\", "+
+			"and run \"git appraise submit --continue\" to finish it", listConflicts(conflicts))
+	}
+	return guidance + "; or give up on it with \"git appraise submit --abort\"."
+}
+
+// mergeConflictError adds the files with conflicts to the error from a submission that
+// failed because of them, along with how to resolve them on the review branch instead.
+func mergeConflictError(submitErr error, conflicts []string, target string) error {
+	return CommandError{
+		Err: submitErr,
+		Guidance: fmt.Sprintf("The submission conflicted in:\n%s\nRun \"git merge %s\" on the review branch, resolve the conflicts there "+
+			"with \"git add <file>\" and \"git merge --continue\", and then submit again.",
+			listConflicts(conflicts), strings.TrimPrefix(target, "refs/heads/")),
+		ExitCode: ExitPreconditionFailed,
+	}
+}
+
+// submitCommitMessages returns the paragraphs of the message of the commit that submits the
+// given review. A merge commit names the review in its subject, while a squashed commit takes
+// its subject from the review's description, so that it reads like the commits it replaces.
+func submitCommitMessages(r *review.Review, squash bool) []string {
+	if !squash || firstLine(r.Request.Description) == "" {
+		return []string{fmt.Sprintf("Submitting review %.12s", r.Revision), r.Request.Description}
+	}
+	messages := []string{firstLine(r.Request.Description)}
+	if parts := strings.SplitN(strings.TrimSpace(r.Request.Description), "\n", 2); len(parts) == 2 && strings.TrimSpace(parts[1]) != "" {
+		messages = append(messages, strings.TrimSpace(parts[1]))
+	}
+	return append(messages, "Review: "+r.Revision)
+}
+
+// checkDependencies reports the dependencies of the given review that have not been submitted,
+// along with any cycle among them. These are only warnings unless strict is set, or unless the
+// review is stacked on one that has not been submitted.
+func checkDependencies(repo repository.Repo, r *review.Review, strict bool) error {
+	var problems []string
+	if cycle := review.FindDependencyCycle(repo, r.Revision, r.Request.DependsOn); cycle != nil {
+		problems = append(problems, "The dependencies of the review form a cycle: "+formatDependencyCycle(cycle))
+	}
+	dependencies, err := r.GetDependencies()
+	if err != nil {
+		return err
+	}
+	for _, dependency := range dependencies {
+		if dependency.Revision == r.Request.BaseReview && dependency.Status == review.DependencyOpen {
+			// Submitting a stacked review would also submit the commits of the review under it.
+			return CommandError{
+				Err:      fmt.Errorf("Not submitting as the review is stacked on review %.12s, which has not been submitted", dependency.Revision),
+				Guidance: "Submit that review first.",
+				ExitCode: ExitPreconditionFailed,
+			}
+		}
+		if dependency.Status != review.DependencySubmitted {
+			problems = append(problems, fmt.Sprintf("The dependency %.12s has not been submitted (%s).", dependency.Revision, dependency.Status))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	if strict {
+		return CommandError{
+			Err:      errors.New("Not submitting as the review depends on reviews that have not been submitted"),
+			Guidance: strings.Join(problems, "\n") + "\nSubmit those first, or submit without --strict.",
+			ExitCode: ExitPreconditionFailed,
+		}
+	}
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", problem)
+	}
+	return nil
+}
+
+// formatMissingSignoffs lists the given commits that are not signed off, one per line.
+func formatMissingSignoffs(missing []review.MissingSignoff) string {
+	var lines []string
+	for _, m := range missing {
+		lines = append(lines, "    "+m.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cleanUpReviewRef deletes the review ref of a review that was just submitted, along with
+// the branch that it tracks on a remote if deleteRemote is set.
+//
+// The ref is only deleted if it can be reached from the target ref, so that no commits are
+// lost (such as after a squash, which leaves the original commits out of the target ref).
+// Since the review has already been submitted, failing to delete it only warns.
+func cleanUpReviewRef(repo repository.Repo, r *review.Review, deleteRemote bool) {
+	ref := r.Request.ReviewRef
+	if !strings.HasPrefix(ref, "refs/heads/") || ref == r.Request.TargetRef || repo.VerifyGitRef(ref) != nil {
+		return
+	}
+	landed, err := repo.IsAncestor(ref, r.Request.TargetRef)
+	if err != nil || !landed {
+		fmt.Printf("Kept the review ref %s, as it cannot be reached from %s.\n", ref, r.Request.TargetRef)
+		return
+	}
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+	remote := repository.GetConfigValue(repo, "branch."+branch+".remote", "origin")
+	remoteRef := repository.GetConfigValue(repo, "branch."+branch+".merge", ref)
+	if err := repo.DeleteRef(ref); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to delete the review ref %s: %v\n", ref, err)
+		return
+	}
+	fmt.Printf("Deleted the review ref %s.\n", ref)
+	if deleteRemote {
+		if err := repo.DeleteRemoteRef(remote, remoteRef); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			return
+		}
+		fmt.Printf("Deleted %s from the remote %q.\n", remoteRef, remote)
+	}
+}
 
-	if *submitMerge && *submitRebase {
-		return errors.New("Only one of --merge or --rebase is allowed.")
+// notYetAccepted returns the error for "submit --wait" when the given review has not been accepted yet.
+func notYetAccepted(r *review.Review) error {
+	return CommandError{
+		Err:      fmt.Errorf("Review %.12s has not been accepted yet", r.Revision),
+		Guidance: "Run \"git appraise submit --wait\" again later.",
+		ExitCode: ExitNotYetAccepted,
+	}
+}
+
+// Submit submits the current review, as the "submit" command does.
+func Submit(repo repository.Repo, opts SubmitOptions) error {
+	if opts.KeepReviewRef && opts.DeleteRemote {
+		return errors.New("The --keep-review-ref and --delete-remote flags cannot be combined.")
+	}
+	strategy := opts.Strategy
+	if strategy == SubmitDefault {
+		strategy = SubmitStrategy(repository.GetConfigValue(repo, submitStrategyConfigKey, "ff"))
+		if !isSubmitStrategy(strategy) {
+			return fmt.Errorf("Unknown submit strategy %q in the %s setting; expected one of: %s",
+				strategy, submitStrategyConfigKey, strings.Join(submitStrategies, ", "))
+		}
+	} else if !isSubmitStrategy(strategy) {
+		return fmt.Errorf("Unknown submit strategy %q; expected one of: %s", strategy, strings.Join(submitStrategies, ", "))
+	}
+	merge := strategy == SubmitMerge
+	rebase := strategy == SubmitRebase
+	squash := strategy == SubmitSquash
+	if err := requireWorktree(repo, "submit"); err != nil {
+		return err
+	}
+	if kind, conflicts, err := repo.MergeInProgress(); err != nil {
+		return err
+	} else if kind != "" {
+		if pending, err := loadPendingSubmit(repo); err == nil && pending != nil {
+			return CommandError{
+				Err:      fmt.Errorf("Not submitting as the submission of review %.12s is still in progress", pending.Revision),
+				Guidance: pendingSubmitGuidance(conflicts),
+				ExitCode: ExitPreconditionFailed,
+			}
+		}
+		return mergeInProgressError(kind, conflicts)
 	}
 
 	r, err := review.GetCurrent(repo)
@@ -47,50 +413,337 @@ func submitReview(repo repository.Repo, args []string) error {
 		return err
 	}
 	if r == nil {
-		return errors.New("There is nothing to submit")
+		return review.ErrNoCurrentReview
+	}
+
+	if !opts.TBR && (r.Resolved == nil || !*r.Resolved) {
+		if opts.Wait {
+			return notYetAccepted(r)
+		}
+		if r.AwaitingChecklist {
+			return CommandError{
+				Err: fmt.Errorf("Not submitting as %d item(s) of the review's checklist have not been checked: %s",
+					len(r.UncheckedItems()), strings.Join(r.UncheckedItems(), "; ")),
+				Guidance: "Check them with \"git appraise check\", or pass --tbr to submit it anyway.",
+				ExitCode: ExitPreconditionFailed,
+			}
+		}
+		return review.ErrReviewNotAccepted
+	}
+	if required := review.RequiredApprovals(repo); !opts.TBR && r.CountApprovals() < required {
+		if opts.Wait {
+			return notYetAccepted(r)
+		}
+		return CommandError{
+			Err: fmt.Errorf("Not submitting as the review has been accepted by %d reviewer(s), but the %s setting requires %d",
+				r.CountApprovals(), review.RequiredApprovalsConfigKey, required),
+			Guidance: "Ask more reviewers to run \"git appraise accept\", or pass --tbr to submit it anyway.",
+			ExitCode: ExitPreconditionFailed,
+		}
+	}
+	if review.SignaturesRequired(repo) {
+		if unverified := r.GetUnverifiedAcceptances(); len(unverified) > 0 {
+			return fmt.Errorf("Not submitting as %d accepting comment(s) do not have a good signature, which %s requires; run \"verify\" for details.",
+				len(unverified), review.RequireSignaturesConfigKey)
+		}
 	}
 
-	if !*submitTBR && (r.Resolved == nil || !*r.Resolved) {
-		return errors.New("Not submitting as the review has not yet been accepted.")
+	if err := checkDependencies(repo, r, opts.Strict); err != nil {
+		return err
+	}
+	if opts.RequireSignoff || review.SignoffRequired(repo) {
+		missing, err := r.FindMissingSignoffs()
+		if err != nil {
+			return err
+		}
+		if len(missing) > 0 {
+			return CommandError{
+				Err:      fmt.Errorf("Not submitting as %d commit(s) are not signed off by their authors:\n%s", len(missing), formatMissingSignoffs(missing)),
+				Guidance: "Add the sign-offs with \"git commit --amend --signoff\" or \"git rebase --signoff\", and then retry.",
+				ExitCode: ExitPreconditionFailed,
+			}
+		}
+	}
+	var submitMessages []string
+	if opts.Signoff {
+		if !merge && !squash {
+			return errors.New("The --signoff flag can only be used with --merge or --squash, which create a new commit.")
+		}
+		userEmail, err := repo.GetUserEmail()
+		if err != nil {
+			return err
+		}
+		submitMessages = append(submitMessages, review.FormatSignoff(repository.GetConfigValue(repo, "user.name", ""), userEmail))
 	}
 
-	target := r.Request.TargetRef
+	targetRef := r.Request.TargetRef
+	if opts.Onto != "" && opts.Onto != targetRef {
+		targetRef = opts.Onto
+		fmt.Fprintf(os.Stderr, "Warning: review %.12s was requested against %s, but is being submitted onto %s; "+
+			"what was approved is its diff against %s, not against %s.\n",
+			r.Revision, r.Request.TargetRef, targetRef, r.Request.TargetRef, targetRef)
+	}
+	target := targetRef
 	source := r.Request.ReviewRef
-	if err := repo.VerifyGitRef(target); err != nil {
+	if opts.NoVerifyRefs {
+		// Compare the raw commits, since the refs may not exist locally.
+		target, err = repo.ResolveRefCommit(targetRef)
+		if err != nil {
+			return err
+		}
+		source, err = r.GetHeadCommit()
+		if err != nil {
+			return err
+		}
+	} else {
+		if err := repo.VerifyGitRef(target); err != nil {
+			return err
+		}
+		if err := repo.VerifyGitRef(source); err != nil {
+			return err
+		}
+	}
+
+	isAncestor, err := repo.IsAncestor(target, source)
+	if err != nil {
 		return err
 	}
-	if err := repo.VerifyGitRef(source); err != nil {
+	if !isAncestor && !merge && !squash {
+		// Merging and squashing create a new commit on the target ref, so only the
+		// other strategies need the review to be up to date with it.
+		return CommandError{
+			Err:      repository.ErrNotFastForward,
+			Guidance: fmt.Sprintf("Run \"git merge %s\" on the review branch, and then retry.", strings.TrimPrefix(targetRef, "refs/heads/")),
+			ExitCode: ExitPreconditionFailed,
+		}
+	}
+
+	createsCommits := merge || rebase || squash
+	if createsCommits && (opts.Sign || repository.IsConfigTrue(repo, repository.CommitSigningConfigKey)) {
+		// Check that signing works before touching any refs, since otherwise a failure
+		// would leave the target ref checked out with a half-finished merge or rebase.
+		if _, err := repo.SignPayload([]byte(fmt.Sprintf("Submitting review %s\n", r.Revision))); err != nil {
+			return fmt.Errorf("Not submitting as the commits could not be signed: %v", err)
+		}
+	}
+
+	err = withCleanWorktree(repo, "submit", opts.Autostash, func() error {
+		originalHead, err := getOriginalHead(repo)
+		if err != nil {
+			return err
+		}
+		if err := repo.SwitchToRef(targetRef); err != nil {
+			return err
+		}
+		messages := append(submitCommitMessages(r, squash), submitMessages...)
+		if merge {
+			err = repo.MergeRef(source, false, opts.Sign, messages...)
+		} else if rebase {
+			err = repo.RebaseRef(source, opts.Sign)
+		} else if squash {
+			err = repo.SquashRef(source, opts.Sign, messages...)
+		} else {
+			err = repo.MergeRef(source, true, false)
+		}
+		if err != nil {
+			if _, conflicts, conflictsErr := repo.MergeInProgress(); conflictsErr == nil && len(conflicts) > 0 {
+				if (merge || squash) && !opts.Autostash {
+					// Leave the merge for the user to resolve, and "submit --continue" to finish.
+					pending := pendingSubmit{
+						Revision:      r.Revision,
+						TargetRef:     targetRef,
+						OriginalHead:  originalHead,
+						Sign:          opts.Sign,
+						Messages:      messages,
+						KeepReviewRef: opts.KeepReviewRef,
+						DeleteRemote:  opts.DeleteRemote,
+					}
+					if saveErr := savePendingSubmit(repo, pending); saveErr == nil {
+						return CommandError{
+							Err:      fmt.Errorf("%v\nThe submission of review %.12s stopped because of conflicts", err, r.Revision),
+							Guidance: pendingSubmitGuidance(conflicts),
+							ExitCode: ExitPreconditionFailed,
+						}
+					}
+				}
+				err = mergeConflictError(err, conflicts, targetRef)
+			}
+			return restoreOriginalHead(repo, originalHead, rebase, err)
+		}
+		return nil
+	})
+	if err != nil {
 		return err
 	}
+	finishSubmit(repo, r, targetRef, opts.KeepReviewRef, opts.DeleteRemote)
+	return nil
+}
 
-	isAncestor, err := repo.IsAncestor(target, source)
+// finishSubmit cleans up after the given review was submitted onto targetRef.
+func finishSubmit(repo repository.Repo, r *review.Review, targetRef string, keepReviewRef, deleteRemote bool) {
+	if targetRef != r.Request.TargetRef {
+		// The review is still open against its own target, so its ref is still needed.
+		fmt.Printf("Submitted review %.12s onto %s; it remains open against %s.\n", r.Revision, targetRef, r.Request.TargetRef)
+		return
+	}
+	if !keepReviewRef {
+		cleanUpReviewRef(repo, r, deleteRemote)
+	}
+}
+
+// noPendingSubmit is the error for "submit --continue" or "submit --abort" when there is
+// no submission that stopped because of conflicts.
+var noPendingSubmit = CommandError{
+	Err:      errors.New("There is no submission in progress"),
+	Guidance: "Run \"git appraise submit\" to submit the current review.",
+	ExitCode: ExitUserError,
+}
+
+// ContinueSubmit finishes a submission that stopped because of conflicts, once they have
+// been resolved, as the "submit --continue" command does.
+func ContinueSubmit(repo repository.Repo) error {
+	if err := requireWorktree(repo, "submit"); err != nil {
+		return err
+	}
+	pending, err := loadPendingSubmit(repo)
+	if err != nil {
+		return err
+	}
+	if pending == nil {
+		return noPendingSubmit
+	}
+	kind, conflicts, err := repo.MergeInProgress()
+	if err != nil {
+		return err
+	}
+	if kind != "merge" {
+		if err := clearPendingSubmit(repo); err != nil {
+			return err
+		}
+		return CommandError{
+			Err:      fmt.Errorf("The submission of review %.12s has no merge in progress to continue", pending.Revision),
+			Guidance: fmt.Sprintf("If the merge was committed or aborted some other way, check %s, and submit again if needed.", pending.TargetRef),
+			ExitCode: ExitPreconditionFailed,
+		}
+	}
+	if len(conflicts) > 0 {
+		return CommandError{
+			Err:      fmt.Errorf("Not continuing the submission as %d file(s) still have conflicts", len(conflicts)),
+			Guidance: pendingSubmitGuidance(conflicts),
+			ExitCode: ExitPreconditionFailed,
+		}
+	}
+	r, err := review.Get(repo, pending.Revision)
 	if err != nil {
 		return err
 	}
-	if !isAncestor {
-		return errors.New("Refusing to submit a non-fast-forward review. First merge the target ref.")
+	if r == nil {
+		return fmt.Errorf("The review %.12s of the submission in progress no longer exists", pending.Revision)
+	}
+	if err := repo.CommitMerge(pending.Sign, pending.Messages...); err != nil {
+		return err
 	}
+	if err := clearPendingSubmit(repo); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to clear the finished submission: %v\n", err)
+	}
+	fmt.Printf("Submitted review %.12s.\n", r.Revision)
+	finishSubmit(repo, r, pending.TargetRef, pending.KeepReviewRef, pending.DeleteRemote)
+	return nil
+}
 
-	if err := repo.SwitchToRef(target); err != nil {
+// AbortSubmit gives up on a submission that stopped because of conflicts, and checks out
+// what was checked out before it, as the "submit --abort" command does.
+func AbortSubmit(repo repository.Repo) error {
+	if err := requireWorktree(repo, "submit"); err != nil {
 		return err
 	}
-	if *submitMerge {
-		submitMessage := fmt.Sprintf("Submitting review %.12s", r.Revision)
-		return repo.MergeRef(source, false, submitMessage, r.Request.Description)
-	} else if *submitRebase {
-		return repo.RebaseRef(source)
-	} else {
-		return repo.MergeRef(source, true)
+	pending, err := loadPendingSubmit(repo)
+	if err != nil {
+		return err
+	}
+	if pending == nil {
+		return noPendingSubmit
+	}
+	if kind, _, err := repo.MergeInProgress(); err != nil {
+		return err
+	} else if kind != "" {
+		if err := repo.AbortMerge(); err != nil {
+			return err
+		}
+	}
+	if err := repo.SwitchToRef(pending.OriginalHead); err != nil {
+		return err
+	}
+	if err := clearPendingSubmit(repo); err != nil {
+		return err
+	}
+	fmt.Printf("Gave up on submitting review %.12s, and checked out %s again.\n",
+		pending.Revision, strings.TrimPrefix(pending.OriginalHead, "refs/heads/"))
+	return nil
+}
+
+// isSubmitStrategy reports whether the given strategy is one of the known ones.
+func isSubmitStrategy(strategy SubmitStrategy) bool {
+	for _, known := range submitStrategies {
+		if string(strategy) == known {
+			return true
+		}
+	}
+	return false
+}
+
+// submitReview submits the current code review request.
+//
+// The "args" parameter contains all of the command line arguments that followed the subcommand.
+func submitReview(repo repository.Repo, args []string) error {
+	if err := submitFlagSet.Parse(args); err != nil {
+		return err
+	}
+
+	if *submitContinue && *submitAbort {
+		return errors.New("Only one of --continue or --abort is allowed.")
+	} else if *submitContinue {
+		return ContinueSubmit(repo)
+	} else if *submitAbort {
+		return AbortSubmit(repo)
+	}
+	if countTrue(*submitMerge, *submitRebase, *submitSquash, *submitFF) > 1 {
+		return errors.New("Only one of --merge, --rebase, --squash, or --ff is allowed.")
+	}
+	opts := SubmitOptions{
+		Autostash:      *submitAutostash,
+		Sign:           *submitSign,
+		TBR:            *submitTBR,
+		Strict:         *submitStrict,
+		RequireSignoff: *submitRequireSignoff,
+		Signoff:        *submitSignoff,
+		KeepReviewRef:  *submitKeepReviewRef,
+		DeleteRemote:   *submitDeleteRemote,
+		Wait:           *submitWait,
+		NoVerifyRefs:   *submitNoVerifyRefs,
+		Onto:           *submitOnto,
+	}
+	switch {
+	case *submitMerge:
+		opts.Strategy = SubmitMerge
+	case *submitRebase:
+		opts.Strategy = SubmitRebase
+	case *submitSquash:
+		opts.Strategy = SubmitSquash
+	case *submitFF:
+		opts.Strategy = SubmitFF
 	}
+	return Submit(repo, opts)
 }
 
 // submitCmd defines the "submit" subcommand.
 var submitCmd = &Command{
 	Usage: func(arg0 string) {
-		fmt.Printf("Usage: %s submit [<option>...]\n\nOptions:\n", arg0)
+		fmt.Printf("Usage: %s submit [<option>...]\n       %s submit --continue\n       %s submit --abort\n\nOptions:\n", arg0, arg0, arg0)
 		submitFlagSet.PrintDefaults()
 	},
 	RunMethod: func(repo repository.Repo, args []string) error {
 		return submitReview(repo, args)
 	},
+	Flags: submitFlagSet,
 }
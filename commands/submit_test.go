@@ -0,0 +1,780 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/request"
+	"github.com/google/git-appraise/review/reviewtest"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// failingRepoForTest wraps the mock repo to track HEAD, and to fail the named operations.
+type failingRepoForTest struct {
+	repository.Repo
+	head    string
+	aborted bool
+	// merging is the kind of merge in progress, and conflicts are the files that a failed
+	// merge, rebase, or squash leaves in conflict.
+	merging   string
+	conflicts []string
+	// failures holds the operations that should fail, such as "MergeRef", or "SwitchToRef <ref>".
+	failures map[string]bool
+}
+
+func (r *failingRepoForTest) fail(operation string) error {
+	if r.failures[operation] {
+		return errors.New(operation + " failed")
+	}
+	return nil
+}
+
+func (r *failingRepoForTest) GetHeadRef() (string, error) { return r.head, nil }
+
+// IsAncestor treats the mock review as up to date with its target, so that it can be
+// submitted, unless "FastForward" is one of the failures.
+func (r *failingRepoForTest) IsAncestor(ancestor, descendant string) (bool, error) {
+	if ancestor == repository.TestTargetRef && descendant == repository.TestReviewRef {
+		return !r.failures["FastForward"], nil
+	}
+	return r.Repo.IsAncestor(ancestor, descendant)
+}
+
+func (r *failingRepoForTest) SwitchToRef(ref string) error {
+	if err := r.fail("SwitchToRef " + ref); err != nil {
+		return err
+	}
+	r.head = ref
+	return nil
+}
+
+func (r *failingRepoForTest) MergeRef(ref string, fastForward, sign bool, messages ...string) error {
+	err := r.fail("MergeRef")
+	if err != nil && len(r.conflicts) > 0 {
+		r.merging = "merge"
+	}
+	return err
+}
+
+func (r *failingRepoForTest) RebaseRef(ref string, sign bool) error { return r.fail("RebaseRef") }
+
+func (r *failingRepoForTest) SquashRef(ref string, sign bool, messages ...string) error {
+	return r.fail("SquashRef")
+}
+
+func (r *failingRepoForTest) AbortMerge() error {
+	if err := r.fail("AbortMerge"); err != nil {
+		return err
+	}
+	r.aborted = true
+	r.merging = ""
+	return nil
+}
+
+func (r *failingRepoForTest) MergeInProgress() (string, []string, error) {
+	if r.merging == "" {
+		return "", nil, nil
+	}
+	return r.merging, r.conflicts, nil
+}
+
+func TestSubmitRestoresHeadOnFailure(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		args        []string
+		failures    []string
+		wantHead    string
+		wantAborted bool
+		// wantErr is a substring of the expected error, and is empty if submitting should succeed.
+		wantErr string
+	}{
+		{"success", []string{"-merge"}, nil, repository.TestTargetRef, false, ""},
+		{"switch", []string{"-merge"}, []string{"SwitchToRef " + repository.TestTargetRef}, repository.TestReviewRef, false, "SwitchToRef"},
+		{"fast-forward", nil, []string{"MergeRef"}, repository.TestReviewRef, true, "MergeRef failed"},
+		{"merge", []string{"-merge"}, []string{"MergeRef"}, repository.TestReviewRef, true, "MergeRef failed"},
+		{"rebase", []string{"-rebase"}, []string{"RebaseRef"}, repository.TestReviewRef, true, "RebaseRef failed"},
+		{"squash", []string{"-squash"}, []string{"SquashRef"}, repository.TestReviewRef, true, "SquashRef failed"},
+		{"abort", []string{"-merge"}, []string{"MergeRef", "AbortMerge"}, repository.TestTargetRef, false,
+			"To recover, run:\n    git merge --abort\n    git checkout ojarjur/mychange"},
+		{"restore", []string{"-rebase"}, []string{"RebaseRef", "SwitchToRef " + repository.TestReviewRef}, repository.TestTargetRef, true,
+			"To recover, run:\n    git rebase --abort\n    git checkout ojarjur/mychange"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			*submitMerge, *submitRebase, *submitSquash = false, false, false
+			repo := &failingRepoForTest{
+				Repo:     repository.NewMemoryRepoForTest(),
+				head:     repository.TestReviewRef,
+				failures: make(map[string]bool),
+			}
+			for _, failure := range test.failures {
+				repo.failures[failure] = true
+			}
+			err := submitReview(repo, append([]string{"-tbr"}, test.args...))
+			if test.wantErr == "" && err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("Unexpected error; got %v, want one containing %q", err, test.wantErr)
+			}
+			if repo.head != test.wantHead {
+				t.Fatalf("Unexpected HEAD after submitting: got %q, want %q", repo.head, test.wantHead)
+			}
+			if repo.aborted != test.wantAborted {
+				t.Fatalf("Unexpected abort status: got %v, want %v", repo.aborted, test.wantAborted)
+			}
+		})
+	}
+}
+
+func TestSubmitErrors(t *testing.T) {
+	defer func() { *submitTBR, *submitWait, *submitRequireSignoff, *submitSignoff = false, false, false, false }()
+	for _, test := range []struct {
+		name     string
+		head     string
+		args     []string
+		failures []string
+		wantCode int
+		// wantGuidance holds substrings of the expected error.
+		wantGuidance []string
+	}{
+		{"no review", repository.TestTargetRef, []string{"-tbr"}, nil, ExitUserError, []string{"no current review", "git appraise list"}},
+		{"not accepted", repository.TestReviewRef, nil, nil, ExitPreconditionFailed, []string{"git appraise accept", "--tbr"}},
+		{"not yet accepted", repository.TestReviewRef, []string{"-wait"}, nil, ExitNotYetAccepted, []string{"not been accepted yet", "--wait"}},
+		{"not fast-forward", repository.TestReviewRef, []string{"-tbr"}, []string{"FastForward"}, ExitPreconditionFailed,
+			[]string{"fast-forwarded", "git merge master"}},
+		{"signoff without a new commit", repository.TestReviewRef, []string{"-tbr", "-signoff"}, nil, ExitUserError, []string{"--merge or --squash"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			*submitMerge, *submitRebase, *submitSquash, *submitTBR, *submitWait = false, false, false, false, false
+			*submitRequireSignoff, *submitSignoff = false, false
+			repo := &failingRepoForTest{
+				Repo:     repository.NewMemoryRepoForTest(),
+				head:     test.head,
+				failures: make(map[string]bool),
+			}
+			for _, failure := range test.failures {
+				repo.failures[failure] = true
+			}
+			err := submitCmd.Run(repo, test.args)
+			if err == nil {
+				t.Fatal("Unexpectedly submitted the review")
+			}
+			if code := ExitCode(err); code != test.wantCode {
+				t.Errorf("Unexpected exit code for %q: got %d, want %d", err, code, test.wantCode)
+			}
+			for _, guidance := range test.wantGuidance {
+				if !strings.Contains(err.Error(), guidance) {
+					t.Errorf("The error %q does not contain %q", err, guidance)
+				}
+			}
+			if repo.head != test.head {
+				t.Errorf("The refused submission changed HEAD to %q", repo.head)
+			}
+		})
+	}
+}
+
+func TestSubmitWithConflicts(t *testing.T) {
+	defer func() { *submitTBR, *submitMerge = false, false }()
+	for _, test := range []struct {
+		name    string
+		merging string
+		// wantGuidance holds substrings of the expected error.
+		wantGuidance []string
+		wantAborted  bool
+	}{
+		{"in progress", "rebase", []string{"rebase is already in progress", "    main.go\n    README.md\n", "git rebase --continue"}, false},
+		{"conflicted", "", []string{"MergeRef failed", "conflicted in:\n    main.go\n    README.md\n", "git merge master"}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			*submitMerge, *submitRebase, *submitSquash = false, false, false
+			repo := &failingRepoForTest{
+				Repo:      repository.NewMemoryRepoForTest(),
+				head:      repository.TestReviewRef,
+				merging:   test.merging,
+				conflicts: []string{"main.go", "README.md"},
+				failures:  map[string]bool{"MergeRef": true},
+			}
+			err := submitCmd.Run(repo, []string{"-tbr", "-merge"})
+			if err == nil {
+				t.Fatal("Unexpectedly submitted the review")
+			}
+			if code := ExitCode(err); code != ExitPreconditionFailed {
+				t.Errorf("Unexpected exit code for %q: got %d, want %d", err, code, ExitPreconditionFailed)
+			}
+			for _, guidance := range test.wantGuidance {
+				if !strings.Contains(err.Error(), guidance) {
+					t.Errorf("The error %q does not contain %q", err, guidance)
+				}
+			}
+			if repo.head != repository.TestReviewRef || repo.aborted != test.wantAborted {
+				t.Errorf("Unexpected state after the failed submission: HEAD %q, aborted %v", repo.head, repo.aborted)
+			}
+		})
+	}
+}
+
+func TestSubmitWithUncommittedChanges(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("The git command line tool is not installed")
+	}
+	*submitAutostash = false
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "config", "user.email", "user@example.com")
+	runGit(t, dir, "config", "user.name", "Test User")
+	runGit(t, dir, "checkout", "-q", "-b", "release")
+	if err := ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("Committed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, dir, "add", "file.txt")
+	runGit(t, dir, "commit", "-q", "-m", "First commit")
+	runGit(t, dir, "checkout", "-q", "-b", "feature")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "Feature commit")
+
+	repo, err := repository.NewGitRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := requestReview(repo, []string{"-quiet", "-m", "Feature", "-r", "", "-target", "refs/heads/release"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("Modified\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "untracked.txt"), []byte("Untracked\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := submitReview(repo, []string{"-tbr"}); err == nil || !strings.Contains(err.Error(), "--autostash") {
+		t.Fatalf("Unexpected result of submitting with uncommitted changes: %v", err)
+	}
+	if head, _ := repo.GetHeadRef(); head != "refs/heads/feature" {
+		t.Fatalf("A refused submission changed HEAD to %q", head)
+	}
+
+	if err := submitReview(repo, []string{"-tbr", "-autostash"}); err != nil {
+		t.Fatal(err)
+	}
+	if head, _ := repo.GetHeadRef(); head != "refs/heads/release" {
+		t.Fatalf("Unexpected HEAD after submitting: %q", head)
+	}
+	for path, expected := range map[string]string{"file.txt": "Modified\n", "untracked.txt": "Untracked\n"} {
+		if contents, err := ioutil.ReadFile(filepath.Join(dir, path)); err != nil || string(contents) != expected {
+			t.Fatalf("The uncommitted changes to %q were not restored: %q, %v", path, contents, err)
+		}
+	}
+	if stashes := runGit(t, dir, "stash", "list"); stashes != "" {
+		t.Fatalf("The autostash was left behind: %q", stashes)
+	}
+}
+
+func TestSubmitRequiresSignoff(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("The git command line tool is not installed")
+	}
+	defer func() { *submitTBR, *submitRequireSignoff, *submitSignoff, *submitMerge = false, false, false, false }()
+	*submitMerge, *submitRebase, *submitSquash, *submitAutostash = false, false, false, false
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "config", "user.email", "user@example.com")
+	runGit(t, dir, "config", "user.name", "Test User")
+	runGit(t, dir, "checkout", "-q", "-b", "release")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "First commit")
+	runGit(t, dir, "checkout", "-q", "-b", "feature")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "--signoff", "-m", "Signed commit")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "Unsigned commit")
+	unsigned := runGit(t, dir, "rev-parse", "HEAD")
+
+	repo, err := repository.NewGitRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := requestReview(repo, []string{"-quiet", "-m", "Feature", "-r", "", "-target", "refs/heads/release"}); err != nil {
+		t.Fatal(err)
+	}
+	err = submitReview(repo, []string{"-tbr", "-require-signoff"})
+	if code := ExitCode(err); err == nil || code != ExitPreconditionFailed {
+		t.Fatalf("Unexpected result of submitting unsigned commits: %v (exit code %d)", err, code)
+	}
+	if !strings.Contains(err.Error(), unsigned[:12]+" Unsigned commit") || strings.Contains(err.Error(), "Signed commit") {
+		t.Fatalf("The error does not list just the unsigned commit: %q", err)
+	}
+	if head, _ := repo.GetHeadRef(); head != "refs/heads/feature" {
+		t.Fatalf("A refused submission changed HEAD to %q", head)
+	}
+
+	runGit(t, dir, "commit", "-q", "--amend", "--allow-empty", "--signoff", "--no-edit")
+	if err := submitReview(repo, []string{"-tbr", "-require-signoff", "-merge", "-signoff"}); err != nil {
+		t.Fatal(err)
+	}
+	if message := runGit(t, dir, "log", "-1", "--format=%B", "refs/heads/release"); !strings.Contains(message, "Signed-off-by: Test User <user@example.com>") {
+		t.Fatalf("The merge commit is not signed off: %q", message)
+	}
+}
+
+func TestSubmitCleansUpReviewRef(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("The git command line tool is not installed")
+	}
+	defer func() {
+		*submitTBR, *submitKeepReviewRef, *submitDeleteRemote, *submitSquash = false, false, false, false
+	}()
+	*submitMerge, *submitRebase, *submitSquash, *submitAutostash = false, false, false, false
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	remoteDir, local := filepath.Join(dir, "remote.git"), filepath.Join(dir, "local")
+	runGit(t, dir, "init", "-q", "--bare", remoteDir)
+	runGit(t, dir, "init", "-q", local)
+	runGit(t, local, "config", "user.email", "user@example.com")
+	runGit(t, local, "config", "user.name", "Test User")
+	runGit(t, local, "remote", "add", "origin", remoteDir)
+	runGit(t, local, "checkout", "-q", "-b", "release")
+	runGit(t, local, "commit", "-q", "--allow-empty", "-m", "First commit")
+	repo, err := repository.NewGitRepo(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	branchExists := func(dir, branch string) bool {
+		return strings.TrimSpace(runGit(t, dir, "branch", "--list", branch)) != ""
+	}
+	submitBranch := func(branch string, args ...string) {
+		runGit(t, local, "checkout", "-q", "-b", branch, "release")
+		if err := ioutil.WriteFile(filepath.Join(local, branch+".txt"), []byte(branch+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		runGit(t, local, "add", branch+".txt")
+		runGit(t, local, "commit", "-q", "-m", "Change on "+branch)
+		runGit(t, local, "push", "-q", "-u", "origin", branch)
+		if err := requestReview(repo, []string{"-quiet", "-m", branch, "-r", "", "-target", "refs/heads/release"}); err != nil {
+			t.Fatal(err)
+		}
+		*submitKeepReviewRef, *submitDeleteRemote, *submitSquash = false, false, false
+		if err := submitReview(repo, append([]string{"-tbr"}, args...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	submitBranch("deleted")
+	if branchExists(local, "deleted") || !branchExists(remoteDir, "deleted") {
+		t.Fatal("Expected only the local review ref to be deleted")
+	}
+	submitBranch("kept", "-keep-review-ref")
+	if !branchExists(local, "kept") {
+		t.Fatal("The review ref was deleted despite --keep-review-ref")
+	}
+	submitBranch("squashed", "-squash")
+	if !branchExists(local, "squashed") {
+		t.Fatal("The review ref was deleted even though its commits were squashed")
+	}
+	submitBranch("remote", "-delete-remote")
+	if branchExists(local, "remote") || branchExists(remoteDir, "remote") {
+		t.Fatal("Expected both the local and the remote review refs to be deleted")
+	}
+	if err := submitReview(repo, []string{"-tbr", "-keep-review-ref", "-delete-remote"}); err == nil {
+		t.Fatal("Unexpectedly allowed both --keep-review-ref and --delete-remote")
+	}
+}
+
+func TestSubmitWithDependencies(t *testing.T) {
+	defer func() { *submitTBR, *submitStrict = false, false }()
+	for _, test := range []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{"warn", []string{"-tbr"}, false},
+		{"strict", []string{"-tbr", "-strict"}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			*submitMerge, *submitRebase, *submitSquash, *submitStrict = false, false, false, false
+			repo := &failingRepoForTest{
+				Repo:     repository.NewMemoryRepoForTest(),
+				head:     repository.TestReviewRef,
+				failures: make(map[string]bool),
+			}
+			r, err := review.Get(repo, repository.TestCommitG)
+			if err != nil {
+				t.Fatal(err)
+			}
+			updatedRequest := r.Request
+			updatedRequest.DependsOn = []string{repository.TestCommitA}
+			note, err := updatedRequest.Write()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.AppendNote(request.Ref, repository.TestCommitG, note); err != nil {
+				t.Fatal(err)
+			}
+			err = submitCmd.Run(repo, test.args)
+			if !test.wantErr {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Unexpectedly submitted a review with a missing dependency")
+			}
+			if code := ExitCode(err); code != ExitPreconditionFailed {
+				t.Errorf("Unexpected exit code for %q: got %d, want %d", err, code, ExitPreconditionFailed)
+			}
+			if !strings.Contains(err.Error(), "The dependency A has not been submitted (missing).") {
+				t.Errorf("The error %q does not list the missing dependency", err)
+			}
+			if repo.head != repository.TestReviewRef {
+				t.Errorf("The refused submission changed HEAD to %q", repo.head)
+			}
+		})
+	}
+}
+
+func TestSubmitConfigDefaults(t *testing.T) {
+	defer func() { *submitMerge, *submitRebase, *submitSquash = false, false, false }()
+	newRepo := func(config map[string]string) *failingRepoForTest {
+		*submitMerge, *submitRebase, *submitSquash = false, false, false
+		repo := &failingRepoForTest{
+			Repo:     repository.NewMemoryRepoForTest(),
+			head:     repository.TestReviewRef,
+			failures: map[string]bool{"SquashRef": true},
+		}
+		for key, value := range config {
+			if err := repo.SetConfigValue(key, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r, err := review.Get(repo, repository.TestCommitG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resolved := true
+		if err := r.AddComment(comment.Comment{Timestamp: "0000000010", Author: "alice@example.com", Resolved: &resolved}); err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	}
+
+	if err := submitCmd.Run(newRepo(map[string]string{submitStrategyConfigKey: "squash"}), nil); err == nil || !strings.Contains(err.Error(), "SquashRef failed") {
+		t.Errorf("The configured submit strategy was not used: %v", err)
+	}
+	if err := submitCmd.Run(newRepo(map[string]string{submitStrategyConfigKey: "squash"}), []string{"-ff"}); err != nil {
+		t.Errorf("The --ff flag did not win over the configured submit strategy: %v", err)
+	}
+	*submitFF = false
+	err := submitCmd.Run(newRepo(map[string]string{review.RequiredApprovalsConfigKey: "2"}), nil)
+	if err == nil || !strings.Contains(err.Error(), "accepted by 1 reviewer(s)") {
+		t.Errorf("Unexpected error for a review without enough approvals: %v", err)
+	}
+	if code := ExitCode(err); code != ExitPreconditionFailed {
+		t.Errorf("Unexpected exit code for %q: got %d, want %d", err, code, ExitPreconditionFailed)
+	}
+	if err := submitCmd.Run(newRepo(map[string]string{review.RequiredApprovalsConfigKey: "1"}), nil); err != nil {
+		t.Errorf("Unexpected error for a review with enough approvals: %v", err)
+	}
+}
+
+func TestSubmitOptions(t *testing.T) {
+	newRepo := func() *failingRepoForTest {
+		repo := &failingRepoForTest{
+			Repo:     repository.NewMemoryRepoForTest(),
+			head:     repository.TestReviewRef,
+			failures: map[string]bool{"SquashRef": true},
+		}
+		if err := Accept(repo, AcceptOptions{Review: repository.TestCommitG}); err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	}
+
+	if err := Submit(newRepo(), SubmitOptions{KeepReviewRef: true, DeleteRemote: true}); err == nil {
+		t.Error("Unexpectedly allowed keeping and deleting the review ref")
+	}
+	if err := Submit(newRepo(), SubmitOptions{Strategy: "octopus"}); err == nil || !strings.Contains(err.Error(), "Unknown submit strategy") {
+		t.Errorf("Unexpected error for an unknown submit strategy: %v", err)
+	}
+	if err := Submit(newRepo(), SubmitOptions{Strategy: SubmitSquash}); err == nil || !strings.Contains(err.Error(), "SquashRef failed") {
+		t.Errorf("The submit strategy was not used: %v", err)
+	}
+	if *submitSquash {
+		t.Error("Submitting the review changed the flags of the submit command")
+	}
+	repo := newRepo()
+	if err := repo.SetConfigValue(submitStrategyConfigKey, "squash"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Submit(repo, SubmitOptions{Strategy: SubmitFF}); err != nil {
+		t.Errorf("The strategy option did not win over the configured submit strategy: %v", err)
+	}
+}
+
+func TestAcceptAndSubmit(t *testing.T) {
+	defer func() { *acceptAndSubmit = false }()
+	for _, test := range []struct {
+		name     string
+		failures []string
+		wantHead string
+		wantErr  string
+	}{
+		{"success", nil, repository.TestTargetRef, ""},
+		{"submit failed", []string{"MergeRef"}, repository.TestReviewRef, "Accepted review G, but failed to submit it: MergeRef failed"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			*submitMerge, *submitRebase, *submitSquash, *submitTBR = false, false, false, false
+			repo := &failingRepoForTest{
+				Repo:     repository.NewMemoryRepoForTest(),
+				head:     repository.TestReviewRef,
+				failures: make(map[string]bool),
+			}
+			for _, failure := range test.failures {
+				repo.failures[failure] = true
+			}
+			err := acceptReview(repo, []string{"-and-submit"})
+			if test.wantErr == "" && err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("Unexpected error; got %v, want one containing %q", err, test.wantErr)
+			}
+			if repo.head != test.wantHead {
+				t.Fatalf("Unexpected HEAD after accepting and submitting: got %q, want %q", repo.head, test.wantHead)
+			}
+			// The acceptance stands even if the submission failed.
+			r, err := review.Get(repo, repository.TestCommitG)
+			if err != nil || r == nil || r.Resolved == nil || !*r.Resolved {
+				t.Fatalf("The review was not accepted: %v", err)
+			}
+		})
+	}
+}
+
+func TestSubmitOnto(t *testing.T) {
+	repo := repository.NewRepoWithHistory(map[string][]string{
+		"master":  {"A"},
+		"release": {"A"},
+		"old":     {"A", "C"},
+		"feature": {"A", "B"},
+	})
+	revision, err := reviewtest.AddReview(repo, "refs/heads/feature", "refs/heads/master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SwitchToRef("refs/heads/feature"); err != nil {
+		t.Fatal(err)
+	}
+	err = Submit(repo, SubmitOptions{TBR: true, Onto: "refs/heads/old"})
+	if ExitCode(err) != ExitPreconditionFailed || !strings.Contains(err.Error(), "git merge old") {
+		t.Fatalf("Unexpected result of submitting onto a ref that is not an ancestor of the review: %v", err)
+	}
+	if err := Submit(repo, SubmitOptions{TBR: true, Onto: "refs/heads/release"}); err != nil {
+		t.Fatal(err)
+	}
+	if release, err := repo.GetCommitHash("refs/heads/release"); err != nil || release != "B" {
+		t.Errorf("The review was not submitted onto the release branch: %q, %v", release, err)
+	}
+	if master, err := repo.GetCommitHash("refs/heads/master"); err != nil || master != "A" {
+		t.Errorf("The target of the review was changed: %q, %v", master, err)
+	}
+	r, err := review.Get(repo, revision)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	if r.Request.TargetRef != "refs/heads/master" || r.Submitted {
+		t.Errorf("Submitting onto another ref changed the review: %+v", r)
+	}
+	if err := repo.VerifyGitRef("refs/heads/feature"); err != nil {
+		t.Errorf("The review ref was deleted even though the review is still open: %v", err)
+	}
+}
+
+func TestSubmitContinueAfterConflicts(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("The git command line tool is not installed")
+	}
+	defer os.Setenv("GIT_EDITOR", os.Getenv("GIT_EDITOR"))
+	os.Setenv("GIT_EDITOR", "true")
+	defer resetFlags(submitFlagSet)
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "config", "user.email", "user@example.com")
+	runGit(t, dir, "config", "user.name", "Test User")
+	runGit(t, dir, "checkout", "-q", "-b", "release")
+	writeFile := func(contents string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		runGit(t, dir, "add", "file.txt")
+	}
+	writeFile("Base\n")
+	runGit(t, dir, "commit", "-q", "-m", "First commit")
+	repo, err := repository.NewGitRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	submit := func(args ...string) error {
+		resetFlags(submitFlagSet)
+		return submitReview(repo, args)
+	}
+	// conflictingReview requests a review of a new branch that conflicts with the release branch.
+	conflictingReview := func(branch string) string {
+		runGit(t, dir, "checkout", "-q", "-b", branch, "release")
+		writeFile(branch + "\n")
+		runGit(t, dir, "commit", "-q", "-m", "Change on "+branch)
+		if err := requestReview(repo, []string{"-quiet", "-m", "Change on " + branch, "-r", "", "-target", "refs/heads/release"}); err != nil {
+			t.Fatal(err)
+		}
+		runGit(t, dir, "checkout", "-q", "release")
+		writeFile("Release after " + branch + "\n")
+		runGit(t, dir, "commit", "-q", "-m", "Change on release")
+		runGit(t, dir, "checkout", "-q", branch)
+		return strings.TrimSpace(runGit(t, dir, "rev-parse", branch))
+	}
+	statePath := filepath.Join(dir, ".git", submitStateFile)
+
+	revision := conflictingReview("feature")
+	err = submit("-tbr", "-merge")
+	if err == nil || ExitCode(err) != ExitPreconditionFailed {
+		t.Fatalf("Unexpected result of submitting with conflicts: %v", err)
+	}
+	for _, guidance := range []string{"    file.txt\n", "git appraise submit --continue", "git appraise submit --abort"} {
+		if !strings.Contains(err.Error(), guidance) {
+			t.Errorf("The error %q does not contain %q", err, guidance)
+		}
+	}
+	if head, _ := repo.GetHeadRef(); head != "refs/heads/release" {
+		t.Fatalf("The conflicted merge was not left in progress on the target ref, but on %q", head)
+	}
+	if err := submit("-continue"); err == nil || !strings.Contains(err.Error(), "still have conflicts") {
+		t.Fatalf("Unexpectedly continued with unresolved conflicts: %v", err)
+	}
+	writeFile("Resolved\n")
+	if err := submit("-tbr", "-merge"); err == nil || !strings.Contains(err.Error(), "still in progress") {
+		t.Fatalf("Unexpectedly started another submission: %v", err)
+	}
+	if err := submit("-continue"); err != nil {
+		t.Fatal(err)
+	}
+	if kind, _, err := repo.MergeInProgress(); err != nil || kind != "" {
+		t.Fatalf("The merge is still in progress: %q, %v", kind, err)
+	}
+	if r, err := review.Get(repo, revision); err != nil || r == nil || !r.Submitted {
+		t.Fatalf("The review was not submitted: %+v, %v", r, err)
+	}
+	if message := runGit(t, dir, "log", "-1", "--format=%B", "release"); !strings.Contains(message, "Change on feature") {
+		t.Errorf("Unexpected message of the merge commit: %q", message)
+	}
+	if branches := runGit(t, dir, "branch", "--list", "feature"); branches != "" {
+		t.Errorf("The review ref was not cleaned up: %q", branches)
+	}
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Errorf("The finished submission was left behind: %v", err)
+	}
+	if err := submit("-continue"); ExitCode(err) != ExitUserError {
+		t.Errorf("Unexpected result of continuing with no submission in progress: %v", err)
+	}
+
+	conflictingReview("abandoned")
+	before := strings.TrimSpace(runGit(t, dir, "rev-parse", "release"))
+	if err := submit("-tbr", "-squash"); err == nil || !strings.Contains(err.Error(), "git appraise submit --abort") {
+		t.Fatalf("Unexpected result of squashing with conflicts: %v", err)
+	}
+	if err := submit("-abort"); err != nil {
+		t.Fatal(err)
+	}
+	if head, _ := repo.GetHeadRef(); head != "refs/heads/abandoned" {
+		t.Fatalf("Unexpected HEAD after giving up on the submission: %q", head)
+	}
+	if kind, _, err := repo.MergeInProgress(); err != nil || kind != "" {
+		t.Fatalf("The squash is still in progress: %q, %v", kind, err)
+	}
+	if after := strings.TrimSpace(runGit(t, dir, "rev-parse", "release")); after != before {
+		t.Fatalf("The abandoned submission moved the target ref from %s to %s", before, after)
+	}
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Errorf("The abandoned submission was left behind: %v", err)
+	}
+}
+
+func TestSubmitSquashMessage(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("The git command line tool is not installed")
+	}
+	defer os.Setenv("GIT_EDITOR", os.Getenv("GIT_EDITOR"))
+	os.Setenv("GIT_EDITOR", "true")
+	defer resetFlags(submitFlagSet)
+	resetFlags(submitFlagSet)
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "config", "user.email", "user@example.com")
+	runGit(t, dir, "config", "user.name", "Test User")
+	runGit(t, dir, "checkout", "-q", "-b", "release")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "First commit")
+	runGit(t, dir, "checkout", "-q", "-b", "feature")
+	for _, subject := range []string{"Feature commit", "Fix the feature"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "feature.txt"), []byte(subject+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		runGit(t, dir, "add", "feature.txt")
+		runGit(t, dir, "commit", "-q", "-m", subject)
+	}
+	repo, err := repository.NewGitRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := requestReview(repo, []string{"-quiet", "-m", "Add the feature\n\nWith some details.", "-r", "", "-target", "refs/heads/release"}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.GetCurrent(repo)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	if err := submitReview(repo, []string{"-tbr", "-squash", "-signoff"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "Add the feature\n\nWith some details.\n\nReview: " + r.Revision + "\n\nSigned-off-by: Test User <user@example.com>\n"
+	if message := strings.TrimRight(runGit(t, dir, "log", "-1", "--format=%B", "release"), "\n") + "\n"; message != expected {
+		t.Fatalf("Unexpected message of the squashed commit: got %q, want %q", message, expected)
+	}
+	head := strings.TrimSpace(runGit(t, dir, "rev-parse", "release"))
+	if reviews, err := review.FindForCommit(repo, head); err != nil || len(reviews) != 1 || reviews[0].Revision != r.Revision {
+		t.Fatalf("The squashed commit does not lead back to the review: %v, %v", reviews, err)
+	}
+}
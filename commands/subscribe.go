@@ -0,0 +1,112 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+)
+
+var subscribeFlagSet = flag.NewFlagSet("subscribe", flag.ContinueOnError)
+var unsubscribeFlagSet = flag.NewFlagSet("unsubscribe", flag.ContinueOnError)
+
+// setWatching records that the current user started, or stopped, watching the given review.
+func setWatching(repo repository.Repo, revision string, watching bool) error {
+	r, err := review.Get(repo, revision)
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %v\n", err)
+	}
+	if r == nil {
+		return errors.New("There is no matching review.")
+	}
+	userEmail, err := repo.GetUserEmail()
+	if err != nil || userEmail == "" {
+		return errors.New("Unable to determine your identity; set it with \"git config user.email <email>\".")
+	}
+	isWatching := false
+	for _, watcher := range r.GetWatchers() {
+		if watcher == userEmail {
+			isWatching = true
+		}
+	}
+	if isWatching == watching {
+		if watching {
+			fmt.Printf("You are already watching review %.12s.\n", r.Revision)
+		} else {
+			fmt.Printf("You are not watching review %.12s.\n", r.Revision)
+		}
+		return nil
+	}
+	if err := r.SetWatching(userEmail, watching); err != nil {
+		return err
+	}
+	if watching {
+		fmt.Printf("Watching review %.12s.\n", r.Revision)
+	} else {
+		fmt.Printf("No longer watching review %.12s.\n", r.Revision)
+	}
+	return nil
+}
+
+// subscribeReview starts the current user watching a review.
+func subscribeReview(repo repository.Repo, args []string) error {
+	if err := subscribeFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = subscribeFlagSet.Args()
+	if len(args) != 1 {
+		return errors.New("The subscribe command requires a single review hash.")
+	}
+	return setWatching(repo, args[0], true)
+}
+
+// unsubscribeReview stops the current user from watching a review.
+func unsubscribeReview(repo repository.Repo, args []string) error {
+	if err := unsubscribeFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = unsubscribeFlagSet.Args()
+	if len(args) != 1 {
+		return errors.New("The unsubscribe command requires a single review hash.")
+	}
+	return setWatching(repo, args[0], false)
+}
+
+// subscribeCmd defines the "subscribe" subcommand.
+var subscribeCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s subscribe <review-hash>\n", arg0)
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return subscribeReview(repo, args)
+	},
+	Flags: subscribeFlagSet,
+}
+
+// unsubscribeCmd defines the "unsubscribe" subcommand.
+var unsubscribeCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s unsubscribe <review-hash>\n", arg0)
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return unsubscribeReview(repo, args)
+	},
+	Flags: unsubscribeFlagSet,
+}
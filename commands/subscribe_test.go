@@ -0,0 +1,67 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"reflect"
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	repo := repository.NewMemoryRepoForTest()
+	watchers := func() []string {
+		r, err := loadReview(repo, repository.TestCommitG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.GetWatchers()
+	}
+	if _, err := captureStdout(func() error { return subscribeCmd.Run(repo, []string{repository.TestCommitG}) }); err != nil {
+		t.Fatal(err)
+	}
+	if got := watchers(); !reflect.DeepEqual(got, []string{"user@example.com"}) {
+		t.Fatalf("Unexpected watchers after subscribing: %q", got)
+	}
+	if _, err := captureStdout(func() error { return unsubscribeCmd.Run(repo, []string{repository.TestCommitG}) }); err != nil {
+		t.Fatal(err)
+	}
+	if got := watchers(); len(got) != 0 {
+		t.Fatalf("Unexpected watchers after unsubscribing: %q", got)
+	}
+	if err := subscribeCmd.Run(repo, nil); err == nil {
+		t.Error("Unexpectedly subscribed without a review hash")
+	}
+}
+
+func TestWatchRejectsReviewHash(t *testing.T) {
+	defer func() { *watchExec = "" }()
+	repo := repository.NewMemoryRepoForTest()
+	// The daemon does not take the review hashes that subscribe does.
+	for _, args := range [][]string{
+		{repository.TestCommitG},
+		{"-exec", "true", repository.TestCommitG},
+	} {
+		*watchExec = ""
+		if err := watchCmd.Run(repo, args); ExitCode(err) != ExitUserError {
+			t.Errorf("Unexpected result of running watch with %q: %v", args, err)
+		}
+	}
+	if r, err := loadReview(repo, repository.TestCommitG); err != nil || len(r.GetWatchers()) != 0 {
+		t.Errorf("Running watch with a review hash subscribed to it: %v, %v", r.GetWatchers(), err)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"strings"
+	"unicode"
+)
+
+// syncRemotesConfigKey is the git config key listing the remotes to sync with.
+const syncRemotesConfigKey = "appraise.remotes"
+
+var syncFlagSet = flag.NewFlagSet("sync", flag.ContinueOnError)
+
+var (
+	syncRemote  = syncFlagSet.String("remote", "", "Only sync with the given remote")
+	syncTimeout = syncFlagSet.Duration("timeout", 0, "Give up on pulling from, or pushing to, each remote after this long, such as \"30s\"; zero means no limit")
+)
+
+// getSyncRemotes returns the remotes to sync with; either those listed in the
+// "appraise.remotes" config, or every configured remote if that is unset.
+func getSyncRemotes(repo repository.Repo) ([]string, error) {
+	configValues, err := repo.GetConfigValues(syncRemotesConfigKey)
+	if err != nil {
+		return nil, err
+	}
+	var remotes []string
+	for _, value := range configValues {
+		remotes = append(remotes, strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})...)
+	}
+	if len(remotes) > 0 {
+		return remotes, nil
+	}
+	return repo.ListRemotes()
+}
+
+// syncReviews pulls the review data from every remote, merges it, and pushes the result back out.
+func syncReviews(repo repository.Repo, args []string) error {
+	if err := syncFlagSet.Parse(args); err != nil {
+		return err
+	}
+	if len(syncFlagSet.Args()) > 0 {
+		return errors.New("The sync command does not take any arguments; use -remote to restrict it to one remote.")
+	}
+
+	var remotes []string
+	if *syncRemote != "" {
+		remotes = []string{*syncRemote}
+	} else {
+		var err error
+		remotes, err = getSyncRemotes(repo)
+		if err != nil {
+			return err
+		}
+	}
+	if len(remotes) == 0 {
+		return errors.New("There are no remotes to sync with.")
+	}
+
+	// First pull from every remote, so that each push includes the review data from all of them.
+	failures := make(map[string]error)
+	// Each remote gets its own timeout, so that one unreachable remote does not stop the others.
+	for _, remote := range remotes {
+		remoteRepo, cancel := withTimeout(repo, *syncTimeout)
+		if err := remoteRepo.PullNotesAndArchive(remote, notesRefPattern, archiveRefPattern); err != nil {
+			failures[remote] = fmt.Errorf("failed to pull: %v", err)
+		}
+		cancel()
+	}
+	for _, remote := range remotes {
+		if failures[remote] != nil {
+			continue
+		}
+		remoteRepo, cancel := withTimeout(repo, *syncTimeout)
+		if err := pushWithRetry(remoteRepo, remote); err != nil {
+			failures[remote] = fmt.Errorf("failed to push: %v", err)
+		}
+		cancel()
+	}
+
+	fmt.Println("Sync results:")
+	for _, remote := range remotes {
+		if err := failures[remote]; err != nil {
+			fmt.Printf("  %s: %v\n", remote, err)
+		} else {
+			fmt.Printf("  %s: ok\n", remote)
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("Failed to sync with %d of %d remotes.", len(failures), len(remotes))
+	}
+	return nil
+}
+
+// syncCmd defines the "sync" subcommand.
+var syncCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s sync [<option>...]\n\nOptions:\n", arg0)
+		syncFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return syncReviews(repo, args)
+	},
+	Flags: syncFlagSet,
+}
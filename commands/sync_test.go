@@ -0,0 +1,64 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/request"
+	"github.com/google/git-appraise/review/reviewtest"
+	"strings"
+	"testing"
+)
+
+func TestSyncWithFailingRemote(t *testing.T) {
+	defer func() { *syncRemote = "" }()
+	history := map[string][]string{"master": {"A"}, "feature": {"A", "B"}}
+	local := repository.NewRepoWithHistory(history)
+	if _, err := reviewtest.AddReview(local, "refs/heads/feature", "refs/heads/master"); err != nil {
+		t.Fatal(err)
+	}
+	first, second := repository.NewRepoWithHistory(history), repository.NewRepoWithHistory(history)
+	if err := first.AppendNote(comment.Ref, "B", repository.Note(`{"timestamp": "0000000001", "author": "alice@example.com", "description": "From the first remote"}`)); err != nil {
+		t.Fatal(err)
+	}
+	local.AddRemote("first", first)
+	local.AddRemote("second", second)
+	// The "missing" remote does not exist, so pulling from it fails.
+	if err := local.SetConfigValue(syncRemotesConfigKey, "first, missing second"); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := captureStdout(func() error { return syncCmd.Run(local, nil) })
+	if err == nil {
+		t.Fatal("Unexpectedly synced with a missing remote")
+	}
+	for _, expected := range []string{"first: ok", "missing: failed to pull", "second: ok"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("The sync results do not include %q:\n%s", expected, out)
+		}
+	}
+	// The other remotes get the review, along with the review data pulled from each other.
+	for name, repo := range map[string]*repository.MemoryRepo{"local": local, "first": first, "second": second} {
+		if len(repo.GetNotes(request.Ref, "B")) != 1 {
+			t.Errorf("The %s repo is missing the review", name)
+		}
+		if len(repo.GetNotes(comment.Ref, "B")) != 1 {
+			t.Errorf("The %s repo is missing the comment from the first remote", name)
+		}
+	}
+}
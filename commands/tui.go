@@ -0,0 +1,646 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/commands/output"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+)
+
+var tuiFlagSet = flag.NewFlagSet("tui", flag.ContinueOnError)
+
+var (
+	tuiFilter  = addReviewFilterFlags(tuiFlagSet)
+	tuiNoCache = tuiFlagSet.Bool("no-cache", false, "Rebuild the cache of parsed reviews from scratch")
+)
+
+// ANSI escape sequences used to draw the terminal UI.
+const (
+	tuiEnterScreen = "\x1b[?1049h\x1b[?25l"
+	tuiLeaveScreen = "\x1b[?25h\x1b[?1049l"
+	tuiClear       = "\x1b[H\x1b[2J"
+	tuiReverse     = "\x1b[7m"
+	tuiReset       = "\x1b[0m"
+)
+
+// tuiSummaryFormat is the template for the line of each review in the review list.
+var tuiSummaryFormat = template.Must(output.NewSummaryFormat(
+	`{{printf "%-11s" .Status}} {{printf "%.12s" .Revision}}  {{printf "%2d" .OpenComments}} open  {{printf "%-24.24s" .Requester}}`))
+
+// tuiComment is a comment that can be selected in the detail pane, to reply to or resolve it.
+type tuiComment struct {
+	hash    string
+	author  string
+	summary string
+}
+
+// tuiDetail is the state of the detail pane, which shows a single review.
+type tuiDetail struct {
+	review   *review.Review
+	lines    []string
+	scroll   int
+	comments []tuiComment
+	// selected is the index of the selected comment, or -1 if none is selected.
+	selected int
+}
+
+// tui is the state of the terminal UI.
+type tui struct {
+	repo    repository.Repo
+	matches func(review.Review) bool
+	in      *bufio.Reader
+	out     io.Writer
+	width   int
+	height  int
+	// setRaw switches the terminal in and out of the mode in which each key is read as soon
+	// as it is pressed, without being echoed.
+	setRaw func(raw bool) error
+	// rebuild is set if the cache of parsed reviews should be rebuilt whenever they are read.
+	rebuild bool
+	// journal is set if each action should be recorded in the journal, so that "undo" can
+	// roll it back on its own.
+	journal bool
+
+	reviews  []review.Review
+	selected int
+	scroll   int
+	detail   *tuiDetail
+	status   string
+}
+
+// isTerminalFile returns whether the given file is a terminal.
+func isTerminalFile(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// runStty runs the stty command on the terminal, returning its output.
+func runStty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// terminalSize returns the number of rows and columns of the terminal, defaulting to 24x80.
+func terminalSize() (int, int) {
+	size, err := runStty("size")
+	if err != nil {
+		return 24, 80
+	}
+	fields := strings.Fields(size)
+	if len(fields) != 2 {
+		return 24, 80
+	}
+	rows, rowsErr := strconv.Atoi(fields[0])
+	cols, colsErr := strconv.Atoi(fields[1])
+	if rowsErr != nil || colsErr != nil || rows < 5 || cols < 20 {
+		return 24, 80
+	}
+	return rows, cols
+}
+
+// captureStdout returns everything that the given function prints to stdout.
+//
+// This lets the terminal UI show the same output as the "show" command in its detail pane.
+func captureStdout(print func() error) (string, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	captured := make(chan string)
+	go func() {
+		var contents bytes.Buffer
+		io.Copy(&contents, reader)
+		reader.Close()
+		captured <- contents.String()
+	}()
+	stdout := os.Stdout
+	os.Stdout = writer
+	printErr := print()
+	os.Stdout = stdout
+	writer.Close()
+	return <-captured, printErr
+}
+
+// resetFlags restores the flags of a command to their defaults, so that running the command
+// again does not reuse the values given to it the last time.
+func resetFlags(flagSet *flag.FlagSet) {
+	flagSet.VisitAll(func(f *flag.Flag) {
+		if list, ok := f.Value.(*stringList); ok {
+			*list = nil
+			return
+		}
+		f.Value.Set(f.DefValue)
+	})
+}
+
+// fitLine expands the tabs in the given line, and truncates it to the given width.
+func fitLine(line string, width int) string {
+	line = strings.Replace(strings.TrimRight(line, "\r"), "\t", "    ", -1)
+	if utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	return string([]rune(line)[:width])
+}
+
+// flattenComments lists the comments of the given threads, along with all of their replies.
+func flattenComments(threads []review.CommentThread) []tuiComment {
+	var comments []tuiComment
+	for _, thread := range threads {
+		if thread.Comment.Reaction == "" {
+			comments = append(comments, tuiComment{
+				hash:    thread.Hash,
+				author:  thread.Comment.Author,
+				summary: firstLine(thread.Comment.Description),
+			})
+		}
+		comments = append(comments, flattenComments(thread.Children)...)
+	}
+	return comments
+}
+
+// readKey reads a single key press, returning either the character typed, or the
+// name of a special key ("up", "down", "pgup", "pgdn", "enter", or "esc").
+func (t *tui) readKey() (string, error) {
+	r, _, err := t.in.ReadRune()
+	if err != nil {
+		return "", err
+	}
+	switch r {
+	case '\r', '\n':
+		return "enter", nil
+	case 3, 4:
+		// Ctrl-C and Ctrl-D, which do not send signals in the raw mode.
+		return "q", nil
+	case 0x1b:
+		if t.in.Buffered() == 0 {
+			return "esc", nil
+		}
+		if next, _ := t.in.ReadByte(); next != '[' && next != 'O' {
+			return "esc", nil
+		}
+		code, _ := t.in.ReadByte()
+		switch code {
+		case 'A':
+			return "up", nil
+		case 'B':
+			return "down", nil
+		case '5', '6':
+			t.in.ReadByte() // The trailing "~".
+			if code == '5' {
+				return "pgup", nil
+			}
+			return "pgdn", nil
+		}
+		return "esc", nil
+	}
+	return string(r), nil
+}
+
+// readLine reads a line of text typed by the user, outside of the raw mode.
+func (t *tui) readLine(prompt string) string {
+	fmt.Fprint(t.out, prompt)
+	line, _ := t.in.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
+// load reads the reviews matching the filter, keeping the same review selected if it still matches.
+func (t *tui) load() {
+	var selectedRevision string
+	if t.selected < len(t.reviews) {
+		selectedRevision = t.reviews[t.selected].Revision
+	}
+	t.reviews = nil
+	review.WalkAllCached(t.repo, t.rebuild, func(r review.Review) {
+		if t.matches(r) {
+			t.reviews = append(t.reviews, r)
+		}
+	}, nil)
+	t.selected = 0
+	for i, r := range t.reviews {
+		if r.Revision == selectedRevision {
+			t.selected = i
+		}
+	}
+}
+
+// openDetail shows the given review in the detail pane, as the "show" command would show it.
+func (t *tui) openDetail(revision string) error {
+	r, err := review.Get(t.repo, revision)
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		return fmt.Errorf("The review %.12s no longer exists.", revision)
+	}
+	text, err := captureStdout(func() error {
+		if err := output.PrintDetails(r); err != nil {
+			return err
+		}
+		fmt.Println()
+		return output.PrintDiff(r)
+	})
+	if err != nil {
+		return err
+	}
+	previous := t.detail
+	t.detail = &tuiDetail{
+		review:   r,
+		lines:    strings.Split(strings.TrimRight(text, "\n"), "\n"),
+		comments: flattenComments(r.Comments),
+		selected: -1,
+	}
+	if previous != nil && previous.review.Revision == revision {
+		t.detail.scroll = previous.scroll
+		if previous.selected < len(t.detail.comments) {
+			t.detail.selected = previous.selected
+		}
+	}
+	return nil
+}
+
+// bodyHeight returns the number of lines available to the list or detail pane.
+func (t *tui) bodyHeight() int {
+	if t.height < 3 {
+		return 1
+	}
+	return t.height - 2
+}
+
+// render draws the current state of the UI.
+func (t *tui) render() {
+	var screen bytes.Buffer
+	screen.WriteString(tuiClear)
+	var header string
+	var body []string
+	if t.detail == nil {
+		header = fmt.Sprintf("%d review(s)  [j/k] move  [enter] open  [c] comment  [a] accept  [x] reject  [s] submit  [g] reload  [q] quit", len(t.reviews))
+		if t.selected < t.scroll {
+			t.scroll = t.selected
+		} else if t.selected >= t.scroll+t.bodyHeight() {
+			t.scroll = t.selected - t.bodyHeight() + 1
+		}
+		for i := t.scroll; i < len(t.reviews) && i < t.scroll+t.bodyHeight(); i++ {
+			summary, err := output.FormatSummary(tuiSummaryFormat, &t.reviews[i])
+			if err != nil {
+				summary = err.Error()
+			}
+			line := fitLine(summary+"  "+firstLine(t.reviews[i].Request.Description), t.width)
+			if i == t.selected {
+				line = tuiReverse + line + strings.Repeat(" ", t.width-utf8.RuneCountInString(line)) + tuiReset
+			}
+			body = append(body, line)
+		}
+		if len(t.reviews) == 0 {
+			body = append(body, "No matching reviews.")
+		}
+	} else {
+		header = fmt.Sprintf("Review %.12s  [j/k] scroll  [n/p] select comment  [c] comment  [r] reply  [R] resolve  [a] accept  [x] reject  [s] submit  [q] back",
+			t.detail.review.Revision)
+		end := t.detail.scroll + t.bodyHeight()
+		if end > len(t.detail.lines) {
+			end = len(t.detail.lines)
+		}
+		for _, line := range t.detail.lines[t.detail.scroll:end] {
+			body = append(body, fitLine(line, t.width))
+		}
+	}
+	screen.WriteString(tuiReverse + fitLine(header, t.width) + tuiReset + "\n")
+	for _, line := range body {
+		screen.WriteString(line + "\n")
+	}
+	for i := len(body); i < t.bodyHeight(); i++ {
+		screen.WriteString("\n")
+	}
+	status := t.status
+	if status == "" && t.detail != nil && t.detail.selected >= 0 {
+		selected := t.detail.comments[t.detail.selected]
+		status = fmt.Sprintf("Comment %d/%d by %s: %s", t.detail.selected+1, len(t.detail.comments), selected.author, selected.summary)
+	}
+	screen.WriteString(fitLine(status, t.width))
+	fmt.Fprint(t.out, screen.String())
+}
+
+// currentRevision returns the revision of the review that actions apply to, or "" if there is none.
+func (t *tui) currentRevision() string {
+	if t.detail != nil {
+		return t.detail.review.Revision
+	}
+	if t.selected < len(t.reviews) {
+		return t.reviews[t.selected].Revision
+	}
+	return ""
+}
+
+// runAction leaves the UI to run a command, exactly as it would be run from the command line,
+// with the arguments built by the given function from what the user types.
+//
+// The function returns no arguments if the action was cancelled.
+func (t *tui) runAction(flagSet *flag.FlagSet, run func(repository.Repo, []string) error, buildArgs func() []string) error {
+	if err := t.setRaw(false); err != nil {
+		return err
+	}
+	fmt.Fprint(t.out, tuiLeaveScreen)
+	if args := buildArgs(); args != nil {
+		resetFlags(flagSet)
+		var err error
+		if t.journal {
+			err = runJournaled(t.repo, flagSet.Name(), args, run)
+		} else {
+			err = run(t.repo, args)
+		}
+		if err != nil {
+			fmt.Fprintf(t.out, "%s\n", describeError(err).Error())
+		}
+		t.readLine("Press enter to return to git-appraise.")
+	}
+	fmt.Fprint(t.out, tuiEnterScreen)
+	if err := t.setRaw(true); err != nil {
+		return err
+	}
+	t.load()
+	if t.detail != nil {
+		return t.openDetail(t.detail.review.Revision)
+	}
+	return nil
+}
+
+// act runs the action bound to the given key, if there is one.
+func (t *tui) act(key string) error {
+	revision := t.currentRevision()
+	if revision == "" {
+		return nil
+	}
+	var parent string
+	if t.detail != nil && t.detail.selected >= 0 {
+		parent = t.detail.comments[t.detail.selected].hash
+	}
+	withMessage := func(prompt string, flags ...string) func() []string {
+		return func() []string {
+			message := t.readLine(prompt)
+			if message == "" {
+				return nil
+			}
+			return append(append(flags, "-m", message), revision)
+		}
+	}
+	switch key {
+	case "c":
+		return t.runAction(commentFlagSet, commentOnReview, withMessage(fmt.Sprintf("Comment on review %.12s (empty to cancel): ", revision)))
+	case "r", "R":
+		if t.detail == nil {
+			t.status = "Open the review with enter to reply to its comments."
+			return nil
+		}
+		if parent == "" {
+			t.status = "Select a comment with n/p first."
+			return nil
+		}
+		if key == "r" {
+			return t.runAction(commentFlagSet, commentOnReview, withMessage(fmt.Sprintf("Reply to comment %.12s (empty to cancel): ", parent), "-p", parent))
+		}
+		return t.runAction(commentFlagSet, commentOnReview, func() []string {
+			message := t.readLine(fmt.Sprintf("Resolve comment %.12s with the message (empty for \"Done\"): ", parent))
+			if message == "" {
+				message = "Done"
+			}
+			return []string{"-p", parent, "-lgtm", "-m", message, revision}
+		})
+	case "a":
+		return t.runAction(acceptFlagSet, acceptReview, func() []string {
+			return []string{"-m", t.readLine(fmt.Sprintf("Accept review %.12s with the message (optional): ", revision)), revision}
+		})
+	case "x":
+		return t.runAction(rejectFlagSet, rejectReview, func() []string {
+			message := t.readLine(fmt.Sprintf("Reject review %.12s with the message (empty to cancel): ", revision))
+			if message == "" {
+				return nil
+			}
+			args := []string{"-m", message}
+			if category := t.readLine("Category of the rejection (optional): "); category != "" {
+				args = append(args, "-category", category)
+			}
+			return append(args, revision)
+		})
+	case "s":
+		current, err := review.GetCurrent(t.repo)
+		if err != nil || current == nil || current.Revision != revision {
+			t.status = fmt.Sprintf("Check out the branch of review %.12s to submit it.", revision)
+			return nil
+		}
+		return t.runAction(submitFlagSet, submitReview, func() []string {
+			if !strings.HasPrefix(strings.ToLower(t.readLine(fmt.Sprintf("Submit review %.12s? [y/N] ", revision))), "y") {
+				return nil
+			}
+			return []string{}
+		})
+	}
+	return nil
+}
+
+// handleKey updates the state of the UI for a key press, returning whether to quit.
+func (t *tui) handleKey(key string) (bool, error) {
+	t.status = ""
+	if t.detail != nil {
+		detail := t.detail
+		maxScroll := len(detail.lines) - t.bodyHeight()
+		if maxScroll < 0 {
+			maxScroll = 0
+		}
+		switch key {
+		case "q", "esc":
+			t.detail = nil
+			return false, nil
+		case "j", "down":
+			detail.scroll++
+		case "k", "up":
+			detail.scroll--
+		case " ", "pgdn":
+			detail.scroll += t.bodyHeight()
+		case "b", "pgup":
+			detail.scroll -= t.bodyHeight()
+		case "n", "p":
+			if len(detail.comments) == 0 {
+				t.status = "The review has no comments."
+				break
+			}
+			if key == "n" {
+				detail.selected = (detail.selected + 1) % len(detail.comments)
+			} else if detail.selected <= 0 {
+				detail.selected = len(detail.comments) - 1
+			} else {
+				detail.selected--
+			}
+			hash := detail.comments[detail.selected].hash
+			for i, line := range detail.lines {
+				if strings.Contains(line, hash) {
+					detail.scroll = i
+					break
+				}
+			}
+		default:
+			return false, t.act(key)
+		}
+		if detail.scroll > maxScroll {
+			detail.scroll = maxScroll
+		}
+		if detail.scroll < 0 {
+			detail.scroll = 0
+		}
+		return false, nil
+	}
+	switch key {
+	case "q", "esc":
+		return true, nil
+	case "j", "down":
+		if t.selected < len(t.reviews)-1 {
+			t.selected++
+		}
+	case "k", "up":
+		if t.selected > 0 {
+			t.selected--
+		}
+	case "pgdn":
+		t.selected += t.bodyHeight()
+		if t.selected >= len(t.reviews) {
+			t.selected = len(t.reviews) - 1
+		}
+	case "pgup":
+		t.selected -= t.bodyHeight()
+	case "g":
+		t.load()
+	case "enter":
+		if t.selected < len(t.reviews) {
+			return false, t.openDetail(t.reviews[t.selected].Revision)
+		}
+	default:
+		return false, t.act(key)
+	}
+	if t.selected < 0 {
+		t.selected = 0
+	}
+	return false, nil
+}
+
+// loop draws the UI and handles key presses until the user quits.
+func (t *tui) loop() error {
+	for {
+		t.render()
+		key, err := t.readKey()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		quit, err := t.handleKey(key)
+		if err != nil {
+			t.status = strings.Replace(describeError(err).Error(), "\n", " ", -1)
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+// runTUI runs the interactive terminal UI.
+func runTUI(repo repository.Repo, args []string) error {
+	*tuiFilter.labels = nil
+	if err := tuiFlagSet.Parse(args); err != nil {
+		return err
+	}
+	if len(tuiFlagSet.Args()) > 0 {
+		return errors.New("The tui command does not take any arguments.")
+	}
+	if !isTerminalFile(os.Stdin) || !isTerminalFile(os.Stdout) {
+		return CommandError{
+			Err:      errors.New("The tui command needs to be run in a terminal."),
+			Guidance: "Use \"git appraise list\" and \"git appraise show\" instead, such as in scripts.",
+			ExitCode: ExitUserError,
+		}
+	}
+	matches, err := tuiFilter.build(repo)
+	if err != nil {
+		return err
+	}
+	// The detail pane is drawn a line at a time, so colors would throw off its layout.
+	if err := output.SetColor(output.ColorNever); err != nil {
+		return err
+	}
+	saved, err := runStty("-g")
+	if err != nil {
+		return fmt.Errorf("Failed to read the terminal settings: %v", err)
+	}
+	rows, cols := terminalSize()
+	t := &tui{
+		repo:    repo,
+		matches: matches,
+		in:      bufio.NewReader(os.Stdin),
+		out:     os.Stdout,
+		width:   cols,
+		height:  rows,
+		rebuild: *tuiNoCache,
+		journal: true,
+		setRaw: func(raw bool) error {
+			if raw {
+				_, err := runStty("-icanon", "-echo", "-isig", "min", "1")
+				return err
+			}
+			_, err := runStty(saved)
+			return err
+		},
+	}
+	t.load()
+	if err := t.setRaw(true); err != nil {
+		return err
+	}
+	fmt.Fprint(t.out, tuiEnterScreen)
+	defer func() {
+		fmt.Fprint(t.out, tuiLeaveScreen)
+		t.setRaw(false)
+	}()
+	return t.loop()
+}
+
+// tuiCmd defines the "tui" subcommand.
+var tuiCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s tui [<option>...]\n\n"+
+			"Browses, comments on, accepts, rejects, and submits reviews in an interactive terminal UI.\n\nOptions:\n", arg0)
+		tuiFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return runTUI(repo, args)
+	},
+	Flags: tuiFlagSet,
+	// Each action is journaled separately instead.
+	LongRunning: true,
+}
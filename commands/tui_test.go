@@ -0,0 +1,108 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTUIRequiresTerminal(t *testing.T) {
+	file, err := ioutil.TempFile("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	stdout := os.Stdout
+	os.Stdout = file
+	err = runTUI(repository.NewMemoryRepoForTest(), nil)
+	os.Stdout = stdout
+	if code := ExitCode(err); err == nil || code != ExitUserError {
+		t.Fatalf("Unexpected result of running the tui outside of a terminal: %v (exit code %d)", err, code)
+	}
+}
+
+func newTestTUI(repo repository.Repo, keys string) (*tui, *bytes.Buffer) {
+	var out bytes.Buffer
+	return &tui{
+		repo:    repo,
+		matches: func(r review.Review) bool { return !r.Submitted },
+		in:      bufio.NewReader(strings.NewReader(keys)),
+		out:     &out,
+		width:   100,
+		height:  20,
+		setRaw:  func(bool) error { return nil },
+		rebuild: true,
+	}, &out
+}
+
+func TestTUINavigation(t *testing.T) {
+	repo := repository.NewMemoryRepoForTest()
+	ui, out := newTestTUI(repo, "jk\x1b[B\nnq")
+	ui.load()
+	if len(ui.reviews) != 1 || ui.reviews[0].Revision != repository.TestCommitG {
+		t.Fatalf("Unexpected reviews: %+v", ui.reviews)
+	}
+	if err := ui.loop(); err != nil {
+		t.Fatal(err)
+	}
+	if ui.detail != nil {
+		t.Errorf("The detail pane is still open")
+	}
+	screens := out.String()
+	if !strings.Contains(screens, "pending     "+repository.TestCommitG) {
+		t.Errorf("The review list does not show the review:\n%s", screens)
+	}
+	if !strings.Contains(screens, "Review "+repository.TestCommitG+" ") {
+		t.Errorf("The detail pane was not opened:\n%s", screens)
+	}
+}
+
+func TestTUIReject(t *testing.T) {
+	defer func() { *rejectCategory = "" }()
+	repo := repository.NewMemoryRepoForTest()
+	ui, out := newTestTUI(repo, "xNeeds tests\n\n\nq")
+	ui.load()
+	if err := ui.loop(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Resolved == nil || *r.Resolved {
+		t.Fatalf("The review was not rejected:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "rejected    "+repository.TestCommitG) {
+		t.Errorf("The review list was not reloaded after rejecting the review:\n%s", out.String())
+	}
+}
+
+func TestTUIReplyNeedsComment(t *testing.T) {
+	ui, _ := newTestTUI(repository.NewMemoryRepoForTest(), "r")
+	ui.load()
+	if quit, err := ui.handleKey("r"); quit || err != nil || !strings.Contains(ui.status, "Open the review") {
+		t.Fatalf("Unexpected result of replying from the review list: %v, %v, %q", quit, err, ui.status)
+	}
+}
@@ -0,0 +1,432 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// undoJournalFile is the file (inside of the git directory) holding the journal of the
+	// latest operations, which the "undo" command rolls back.
+	undoJournalFile = "appraise/journal"
+	// undoJournalSize is the number of operations kept in the journal.
+	undoJournalSize = 20
+)
+
+var undoFlagSet = flag.NewFlagSet("undo", flag.ContinueOnError)
+
+var undoList = undoFlagSet.Bool("list", false, "List the operations in the journal, newest first, rather than undoing the latest one")
+
+// journalRef describes how an operation changed a single ref.
+type journalRef struct {
+	Ref string `json:"ref"`
+	// Before and After are what the ref pointed to before and after the operation; either
+	// is empty if the ref did not exist then.
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// journalNote is a note written by an operation.
+type journalNote struct {
+	Ref      string `json:"ref"`
+	Revision string `json:"revision"`
+	Note     string `json:"note"`
+}
+
+// journalEntry describes an operation that can be undone.
+type journalEntry struct {
+	// Command is the command line of the operation, such as "accept -m LGTM".
+	Command   string       `json:"command"`
+	Timestamp string       `json:"timestamp"`
+	Refs      []journalRef `json:"refs"`
+	// OriginalHead is what was checked out before the operation, if it checked out anything else.
+	OriginalHead string        `json:"originalHead,omitempty"`
+	Notes        []journalNote `json:"notes,omitempty"`
+	// Pushed is set once the changes made by the operation have been pushed, after which
+	// it can no longer be undone.
+	Pushed bool `json:"pushed,omitempty"`
+}
+
+// journalPath returns the path of the journal of the given repo.
+func journalPath(repo repository.Repo) (string, error) {
+	gitDir, err := repo.GetGitDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(gitDir, undoJournalFile), nil
+}
+
+// readJournal returns the operations in the journal, oldest first.
+func readJournal(repo repository.Repo) ([]journalEntry, error) {
+	path, err := journalPath(repo)
+	if err != nil {
+		return nil, err
+	}
+	journalBytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entries []journalEntry
+	if err := json.Unmarshal(journalBytes, &entries); err != nil {
+		return nil, fmt.Errorf("Failed to parse the journal %q: %v", path, err)
+	}
+	return entries, nil
+}
+
+// writeJournal replaces the journal with the given operations, dropping all but the
+// latest undoJournalSize of them.
+func writeJournal(repo repository.Repo, entries []journalEntry) error {
+	path, err := journalPath(repo)
+	if err != nil {
+		return err
+	}
+	if len(entries) > undoJournalSize {
+		entries = entries[len(entries)-undoJournalSize:]
+	}
+	journalBytes, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, journalBytes, 0644)
+}
+
+// newJournalEntry returns the journal entry of the operation that made the given changes,
+// or nil if it did not change any notes or branches.
+//
+// Changes to the config, the stash, and remote repos are not undone, so they are left out.
+func newJournalEntry(repo repository.Repo, command string, mutations []repository.Mutation) (*journalEntry, error) {
+	entry := &journalEntry{
+		Command:   command,
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+	}
+	before := make(map[string]string)
+	var refs []string
+	for _, mutation := range mutations {
+		if mutation.Ref == "HEAD" {
+			if entry.OriginalHead == "" {
+				entry.OriginalHead = mutation.Previous
+			}
+			continue
+		}
+		if !strings.HasPrefix(mutation.Ref, "refs/") || strings.Contains(mutation.Ref, "*") || mutation.Ref == "refs/stash" {
+			continue
+		}
+		if _, ok := before[mutation.Ref]; !ok {
+			before[mutation.Ref] = mutation.Previous
+			refs = append(refs, mutation.Ref)
+		}
+		if mutation.Operation == "AppendNote" {
+			entry.Notes = append(entry.Notes, journalNote{
+				Ref:      mutation.Ref,
+				Revision: mutation.Revision,
+				Note:     string(mutation.Note),
+			})
+		}
+	}
+	for _, ref := range refs {
+		after, err := repo.GetNotesTip(ref)
+		if err != nil {
+			return nil, err
+		}
+		if after != before[ref] {
+			entry.Refs = append(entry.Refs, journalRef{Ref: ref, Before: before[ref], After: after})
+		}
+	}
+	if entry.OriginalHead != "" {
+		if head, err := getOriginalHead(repo); err == nil && head == entry.OriginalHead {
+			entry.OriginalHead = ""
+		}
+	}
+	if len(entry.Refs) == 0 && entry.OriginalHead == "" {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// recordInJournal adds the operation that made the given changes to the journal. If the
+// changes include a push, then the operations before it are marked as pushed.
+func recordInJournal(repo repository.Repo, command string, mutations []repository.Mutation) error {
+	entry, err := newJournalEntry(repo, command, mutations)
+	if err != nil {
+		return err
+	}
+	pushed := false
+	for _, mutation := range mutations {
+		pushed = pushed || mutation.Operation == "PushNotesAndArchive" || mutation.Operation == "ForcePushNotesAndArchive"
+	}
+	if entry == nil && !pushed {
+		return nil
+	}
+	entries, err := readJournal(repo)
+	if err != nil {
+		return err
+	}
+	if pushed {
+		for i := range entries {
+			entries[i].Pushed = true
+		}
+	}
+	if entry != nil {
+		entries = append(entries, *entry)
+	}
+	return writeJournal(repo, entries)
+}
+
+// RunAndJournal executes a command like Run, and then records the changes that it made to
+// notes and branches in the journal, so that the "undo" command can roll them back.
+//
+// Long running commands are run without being journaled.
+func (cmd *Command) RunAndJournal(repo repository.Repo, name string, args []string) error {
+	if cmd.LongRunning {
+		return cmd.Run(repo, args)
+	}
+	return runJournaled(repo, name, args, cmd.Run)
+}
+
+// runJournaled runs the given function with the given arguments, and then records the
+// changes that it made in the journal as the command of the given name.
+func runJournaled(repo repository.Repo, name string, args []string, run func(repository.Repo, []string) error) error {
+	recorder := repository.NewMutationRecorder(repo, false)
+	err := run(recorder, args)
+	command := strings.Join(append([]string{name}, args...), " ")
+	if journalErr := recordInJournal(repo, command, recorder.Mutations()); journalErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record the changes for \"undo\": %v\n", journalErr)
+	}
+	return err
+}
+
+// printJournal prints the operations in the journal, newest first.
+func printJournal(entries []journalEntry) {
+	if len(entries) == 0 {
+		fmt.Println("There are no operations to undo")
+		return
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		var pushed string
+		if entry.Pushed {
+			pushed = " (pushed, so it cannot be undone)"
+		}
+		fmt.Printf("%d: %s %q%s\n", len(entries)-i, formatBlameTimestamp(entry.Timestamp), entry.Command, pushed)
+		for _, ref := range entry.Refs {
+			fmt.Printf("  %s: %.12s -> %.12s\n", ref.Ref, orNone(ref.Before), orNone(ref.After))
+		}
+		if entry.OriginalHead != "" {
+			fmt.Printf("  HEAD: %s\n", entry.OriginalHead)
+		}
+	}
+}
+
+// orNone returns the given value of a ref, or "(none)" if it did not exist.
+func orNone(value string) string {
+	if value == "" {
+		return "(none)"
+	}
+	return value
+}
+
+// isPublished returns whether the value that an operation left the given ref at has reached
+// a remote, as far as the remote-tracking refs show. That is the case once any client sharing
+// the notes has pushed them (not just this one), or once a branch has been pushed with git.
+func isPublished(repo repository.Repo, ref journalRef) (bool, error) {
+	if ref.After == "" {
+		return false, nil
+	}
+	remotes, err := repo.ListRemotes()
+	if err != nil {
+		return false, err
+	}
+	for _, remote := range remotes {
+		var remoteRefs map[string]string
+		if strings.HasPrefix(ref.Ref, "refs/notes/") {
+			// The notes are reachable from whichever remote notes ref they were pushed to.
+			remoteRefs, err = repo.ListRefs("refs/notes/" + remote + "/")
+		} else if strings.HasPrefix(ref.Ref, "refs/heads/") {
+			remoteRef := "refs/remotes/" + remote + "/" + strings.TrimPrefix(ref.Ref, "refs/heads/")
+			remoteRefs, err = repo.ListRefs(remoteRef)
+			if value, ok := remoteRefs[remoteRef]; ok {
+				remoteRefs = map[string]string{remoteRef: value}
+			} else {
+				remoteRefs = nil
+			}
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, value := range remoteRefs {
+			if reachable, err := repo.IsAncestor(ref.After, value); err != nil {
+				return false, err
+			} else if reachable {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// rollBack resets the refs changed by the given operation to what they were before it,
+// and checks out the given head, which is what was checked out before it.
+//
+// If the head is one of the refs that the operation moved, then the ref's previous commit
+// is checked out while resetting it, so that the worktree follows it.
+func rollBack(repo repository.Repo, entry journalEntry, head string) error {
+	// Restore the deleted refs first, since the original HEAD may be one of them.
+	var checkedOut *journalRef
+	for i, ref := range entry.Refs {
+		if ref.After == "" {
+			if err := repo.UpdateRef(ref.Ref, ref.Before, ref.After); err != nil {
+				return err
+			}
+		} else if ref.Ref == head {
+			checkedOut = &entry.Refs[i]
+		}
+	}
+	if checkedOut != nil {
+		if err := repo.SwitchToRef(checkedOut.Before); err != nil {
+			return err
+		}
+	} else if entry.OriginalHead != "" {
+		if err := repo.SwitchToRef(entry.OriginalHead); err != nil {
+			return err
+		}
+	}
+	for _, ref := range entry.Refs {
+		if ref.After != "" {
+			if err := repo.UpdateRef(ref.Ref, ref.Before, ref.After); err != nil {
+				return err
+			}
+		}
+	}
+	if checkedOut != nil {
+		return repo.SwitchToRef(head)
+	}
+	return nil
+}
+
+// undo rolls back the latest operation in the journal.
+func undo(repo repository.Repo, args []string) error {
+	if err := undoFlagSet.Parse(args); err != nil {
+		return err
+	}
+	if len(undoFlagSet.Args()) > 0 {
+		return errors.New("The undo command does not take any arguments.")
+	}
+	entries, err := readJournal(repo)
+	if err != nil {
+		return err
+	}
+	if *undoList {
+		printJournal(entries)
+		return nil
+	}
+	if len(entries) == 0 {
+		return errors.New("There are no operations to undo.")
+	}
+	entry := entries[len(entries)-1]
+	if entry.Pushed {
+		return CommandError{
+			Err:      fmt.Errorf("Cannot undo %q, as its changes have since been pushed", entry.Command),
+			Guidance: "Make a new change that reverses it instead, such as rejecting a review that was accepted by mistake.",
+			ExitCode: ExitPreconditionFailed,
+		}
+	}
+	head := entry.OriginalHead
+	if head == "" {
+		head, _ = repo.GetHeadRef()
+	}
+	switchesHead := entry.OriginalHead != ""
+	for _, ref := range entry.Refs {
+		current, err := repo.GetNotesTip(ref.Ref)
+		if err != nil {
+			return err
+		}
+		if current != ref.After {
+			return CommandError{
+				Err:      fmt.Errorf("Cannot undo %q, as %s has been changed since then", entry.Command, ref.Ref),
+				Guidance: "Only the latest change to a ref can be undone; see \"git appraise undo -list\".",
+				ExitCode: ExitPreconditionFailed,
+			}
+		}
+		if published, err := isPublished(repo, ref); err != nil {
+			return err
+		} else if published {
+			return CommandError{
+				Err:      fmt.Errorf("Cannot undo %q, as its changes to %s have since been pushed", entry.Command, ref.Ref),
+				Guidance: "Make a new change that reverses it instead, such as rejecting a review that was accepted by mistake.",
+				ExitCode: ExitPreconditionFailed,
+			}
+		}
+		if ref.Ref == head && ref.Before == "" {
+			return fmt.Errorf("Cannot undo %q, as it created %s, which is checked out; check out another branch first.", entry.Command, ref.Ref)
+		}
+		switchesHead = switchesHead || (ref.Ref == head && ref.After != "")
+	}
+
+	if switchesHead {
+		err = withCleanWorktree(repo, "undo", false, func() error {
+			return rollBack(repo, entry, head)
+		})
+	} else {
+		err = rollBack(repo, entry, head)
+	}
+	if err != nil {
+		return err
+	}
+	if !repository.IsRecordOnly(repo) {
+		if err := writeJournal(repo, entries[:len(entries)-1]); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Undid %q\n", entry.Command)
+	for _, ref := range entry.Refs {
+		fmt.Printf("  %s: %.12s -> %.12s\n", ref.Ref, orNone(ref.After), orNone(ref.Before))
+	}
+	if entry.OriginalHead != "" {
+		fmt.Printf("  checked out %s\n", strings.TrimPrefix(entry.OriginalHead, "refs/heads/"))
+	}
+	return nil
+}
+
+// undoCmd defines the "undo" subcommand.
+var undoCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s undo [-list]\n\n"+
+			"Rolls back the latest operation of this client that changed the review notes or branches,\n"+
+			"unless it has since been pushed, or the refs that it changed have changed again.\n\nOptions:\n", arg0)
+		undoFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return undo(repo, args)
+	},
+	Flags: undoFlagSet,
+}
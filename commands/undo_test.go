@@ -0,0 +1,214 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/reviewtest"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newUndoTestRepo(t *testing.T) (*repository.MemoryRepo, func()) {
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repository.NewRepoWithHistory(map[string][]string{"master": {"A"}, "feature": {"A", "B"}})
+	repo.SetPath(dir)
+	if _, err := reviewtest.AddReview(repo, "refs/heads/feature", "refs/heads/master"); err != nil {
+		t.Fatal(err)
+	}
+	return repo, func() { os.RemoveAll(dir) }
+}
+
+func TestUndo(t *testing.T) {
+	defer func() {
+		*acceptMessage, *undoList = "", false
+	}()
+	repo, cleanup := newUndoTestRepo(t)
+	defer cleanup()
+	if err := undo(repo, nil); err == nil {
+		t.Fatal("Unexpectedly undid an operation with an empty journal")
+	}
+
+	if err := acceptCmd.RunAndJournal(repo, "accept", []string{"-m", "LGTM", "B"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := listCmd.RunAndJournal(repo, "list", nil); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := readJournal(repo); err != nil || len(entries) != 1 || entries[0].Command != "accept -m LGTM B" {
+		t.Fatalf("Unexpected journal: %+v, %v", entries, err)
+	}
+	list, err := captureStdout(func() error { return undo(repo, []string{"-list"}) })
+	if err != nil || !strings.Contains(list, `"accept -m LGTM B"`) {
+		t.Errorf("Unexpected journal listing: %q, %v", list, err)
+	}
+	*undoList = false
+	if err := undo(repo, nil); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := review.Get(repo, "B"); err != nil || len(r.Comments) != 0 || r.Resolved != nil {
+		t.Fatalf("The acceptance was not undone: %+v, %v", r, err)
+	}
+	if entries, err := readJournal(repo); err != nil || len(entries) != 0 {
+		t.Errorf("The undone operation is still in the journal: %+v, %v", entries, err)
+	}
+
+	// Notes written since then by another client cannot be silently dropped.
+	if err := acceptCmd.RunAndJournal(repo, "accept", []string{"-m", "LGTM", "B"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reviewtest.AddComment(repo, "B", comment.Comment{Description: "One more thing"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := undo(repo, nil); ExitCode(err) != ExitPreconditionFailed {
+		t.Fatalf("Unexpected result of undoing an operation whose ref has moved: %v", err)
+	}
+
+	// Neither can pushed changes.
+	if err := acceptCmd.RunAndJournal(repo, "accept", []string{"-m", "LGTM", "B"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordInJournal(repo, "push", []repository.Mutation{{Operation: "PushNotesAndArchive", Ref: "refs/notes/devtools/*"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := undo(repo, nil); ExitCode(err) != ExitPreconditionFailed {
+		t.Fatalf("Unexpected result of undoing a pushed operation: %v", err)
+	}
+}
+
+func TestUndoPushedElsewhere(t *testing.T) {
+	defer func() {
+		*acceptMessage = ""
+	}()
+	repo, cleanup := newUndoTestRepo(t)
+	defer cleanup()
+	remote := repository.NewRepoWithHistory(map[string][]string{"master": {"A"}})
+	repo.AddRemote("origin", remote)
+	if err := acceptCmd.RunAndJournal(repo, "accept", []string{"-m", "LGTM", "B"}); err != nil {
+		t.Fatal(err)
+	}
+	// Pushing the notes without the journal, as another client sharing the repo would, does
+	// not mark the operation as pushed.
+	if err := repo.PushNotesAndArchive("origin", notesRefPattern, archiveRefPattern); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := readJournal(repo); err != nil || len(entries) != 1 || entries[0].Pushed {
+		t.Fatalf("Unexpected journal: %+v, %v", entries, err)
+	}
+
+	// Once they are fetched, the remote-tracking notes refs show that they were pushed.
+	if err := repo.PullNotesAndArchive("origin", notesRefPattern, archiveRefPattern); err != nil {
+		t.Fatal(err)
+	}
+	if err := undo(repo, nil); ExitCode(err) != ExitPreconditionFailed {
+		t.Fatalf("Unexpected result of undoing an operation whose notes are on the remote: %v", err)
+	}
+	if r, err := review.Get(repo, "B"); err != nil || r.Resolved == nil || !*r.Resolved {
+		t.Errorf("The pushed acceptance was undone: %+v, %v", r, err)
+	}
+}
+
+func TestUndoSubmit(t *testing.T) {
+	defer func() {
+		*submitTBR = false
+	}()
+	*submitMerge, *submitRebase, *submitSquash, *submitKeepReviewRef = false, false, false, false
+	repo, cleanup := newUndoTestRepo(t)
+	defer cleanup()
+	if err := repo.SwitchToRef("refs/heads/feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := submitCmd.RunAndJournal(repo, "submit", []string{"-tbr"}); err != nil {
+		t.Fatal(err)
+	}
+	if master, err := repo.GetCommitHash("refs/heads/master"); err != nil || master != "B" {
+		t.Fatalf("The review was not submitted: %q, %v", master, err)
+	}
+	if err := undo(repo, nil); err != nil {
+		t.Fatal(err)
+	}
+	if master, err := repo.GetCommitHash("refs/heads/master"); err != nil || master != "A" {
+		t.Errorf("The target ref was not restored: %q, %v", master, err)
+	}
+	if feature, err := repo.GetCommitHash("refs/heads/feature"); err != nil || feature != "B" {
+		t.Errorf("The review ref was not restored: %q, %v", feature, err)
+	}
+	if head, err := repo.GetHeadRef(); err != nil || head != "refs/heads/feature" {
+		t.Errorf("The original HEAD was not restored: %q, %v", head, err)
+	}
+
+	// Undoing a change to the checked out branch also updates the worktree.
+	if err := repo.SwitchToRef("refs/heads/master"); err != nil {
+		t.Fatal(err)
+	}
+	repo.SetRef("refs/heads/master", "B")
+	if err := writeJournal(repo, []journalEntry{{
+		Command: "merge",
+		Refs:    []journalRef{{Ref: "refs/heads/master", Before: "A", After: "B"}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := undo(repo, nil); err != nil {
+		t.Fatal(err)
+	}
+	if master, err := repo.GetCommitHash("HEAD"); err != nil || master != "A" {
+		t.Errorf("The checked out ref was not restored: %q, %v", master, err)
+	}
+	if head, err := repo.GetHeadRef(); err != nil || head != "refs/heads/master" {
+		t.Errorf("The restored ref is no longer checked out: %q, %v", head, err)
+	}
+}
+
+func TestUndoTUIActions(t *testing.T) {
+	defer func() {
+		*acceptMessage, *commentMessage = "", ""
+	}()
+	repo, cleanup := newUndoTestRepo(t)
+	defer cleanup()
+	// Long running commands are not journaled as a whole.
+	if err := tuiCmd.RunAndJournal(repo, "tui", nil); err == nil {
+		t.Fatal("Unexpectedly ran the tui outside of a terminal")
+	}
+	if entries, err := readJournal(repo); err != nil || len(entries) != 0 {
+		t.Fatalf("Unexpected journal after running the tui: %+v, %v", entries, err)
+	}
+
+	// Each action taken in the tui is journaled on its own instead.
+	ui, out := newTestTUI(repo, "cNeeds tests\n\naLGTM\n\nq")
+	ui.journal = true
+	ui.load()
+	if err := ui.loop(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readJournal(repo)
+	if err != nil || len(entries) != 2 || entries[0].Command != "comment -m Needs tests B" || entries[1].Command != "accept -m LGTM B" {
+		t.Fatalf("Unexpected journal after commenting and accepting in the tui: %+v, %v\n%s", entries, err, out.String())
+	}
+	if err := undo(repo, nil); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := review.Get(repo, "B"); err != nil || len(r.Comments) != 1 || r.Resolved != nil {
+		t.Errorf("Unexpected review after undoing the acceptance: %+v, %v", r, err)
+	}
+}
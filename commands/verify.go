@@ -0,0 +1,116 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+)
+
+var verifyFlagSet = flag.NewFlagSet("verify", flag.ContinueOnError)
+
+var verifyJsonOutput = verifyFlagSet.Bool("json", false, "Format the output as JSON")
+
+// describeSignature returns a human friendly description of the given note signature.
+func describeSignature(signature review.NoteSignature) string {
+	note := fmt.Sprintf("%s %.12s by %q", signature.Kind, signature.ID, signature.Author)
+	if signature.Accepting {
+		note += " (accepting)"
+	}
+	switch signature.Status {
+	case review.SignatureGood:
+		return fmt.Sprintf("%s: good signature from %q", note, signature.Signer)
+	case review.SignatureBad:
+		return fmt.Sprintf("%s: BAD signature: %s", note, signature.Error)
+	default:
+		return fmt.Sprintf("%s: unsigned", note)
+	}
+}
+
+// verifyReview checks the signatures of every request and comment in a review.
+//
+// This fails if any signature is bad, or if any note is unsigned while the
+// "appraise.requireSignatures" git config setting is enabled.
+func verifyReview(repo repository.Repo, args []string) error {
+	if err := verifyFlagSet.Parse(args); err != nil {
+		return err
+	}
+	args = verifyFlagSet.Args()
+
+	var r *review.Review
+	var err error
+	if len(args) > 1 {
+		return errors.New("Only verifying a single review is supported.")
+	}
+	if len(args) == 1 {
+		r, err = review.Get(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %v\n", err)
+	}
+	if r == nil {
+		return noMatchingReview(args)
+	}
+
+	signatures := r.VerifySignatures()
+	counts := make(map[string]int)
+	for _, signature := range signatures {
+		counts[signature.Status]++
+	}
+	if *verifyJsonOutput {
+		if signatures == nil {
+			signatures = []review.NoteSignature{}
+		}
+		jsonBytes, err := json.MarshalIndent(signatures, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonBytes))
+	} else {
+		for _, signature := range signatures {
+			fmt.Println(describeSignature(signature))
+		}
+		fmt.Printf("%d good, %d bad, and %d unsigned note(s)\n",
+			counts[review.SignatureGood], counts[review.SignatureBad], counts[review.SignatureUnsigned])
+	}
+	if counts[review.SignatureBad] > 0 {
+		return fmt.Errorf("Review %.12s has %d badly signed note(s).", r.Revision, counts[review.SignatureBad])
+	}
+	if counts[review.SignatureUnsigned] > 0 && review.SignaturesRequired(repo) {
+		return fmt.Errorf("Review %.12s has %d unsigned note(s), but %s is set.",
+			r.Revision, counts[review.SignatureUnsigned], review.RequireSignaturesConfigKey)
+	}
+	return nil
+}
+
+// verifyCmd defines the "verify" subcommand.
+var verifyCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s verify [<option>...] [<review-hash>]\n\nOptions:\n", arg0)
+		verifyFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return verifyReview(repo, args)
+	},
+	Flags: verifyFlagSet,
+}
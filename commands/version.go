@@ -0,0 +1,141 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/analyses"
+	"github.com/google/git-appraise/review/ci"
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/request"
+	"runtime"
+	"runtime/debug"
+)
+
+// The version of the build, which is set with the linker's -X flag, such as:
+//
+//	go build -ldflags "-X github.com/google/git-appraise/commands.Version=0.1.0 \
+//	    -X github.com/google/git-appraise/commands.Commit=$(git rev-parse HEAD) \
+//	    -X github.com/google/git-appraise/commands.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Anything left unset is read from the build information that the go tool records, if any.
+var (
+	Version   string
+	Commit    string
+	BuildDate string
+)
+
+var versionFlagSet = flag.NewFlagSet("version", flag.ContinueOnError)
+
+var versionJsonOutput = versionFlagSet.Bool("json", false, "Format the output as JSON")
+
+// VersionInfo describes the build of the review tool, and the note formats that it understands.
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	GoVersion string `json:"goVersion"`
+	// SchemaVersions holds the latest format version of each kind of note that can be read.
+	SchemaVersions map[string]int `json:"schemaVersions"`
+}
+
+// GetVersionInfo returns the description of this build of the review tool.
+func GetVersionInfo() VersionInfo {
+	info := VersionInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		SchemaVersions: map[string]int{
+			"analyses": analyses.FormatVersion,
+			"ci":       ci.FormatVersion,
+			"comment":  comment.FormatVersion,
+			"request":  request.FormatVersion,
+		},
+	}
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && buildInfo.Main.Version != "(devel)" {
+			info.Version = buildInfo.Main.Version
+		}
+		for _, setting := range buildInfo.Settings {
+			if setting.Key == "vcs.revision" && info.Commit == "" {
+				info.Commit = setting.Value
+			} else if setting.Key == "vcs.time" && info.BuildDate == "" {
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "devel"
+	}
+	return info
+}
+
+// String returns a single line summary of the build, such as for debug logs.
+func (info VersionInfo) String() string {
+	description := "git-appraise " + info.Version
+	if info.Commit != "" {
+		description += fmt.Sprintf(" (commit %.12s)", info.Commit)
+	}
+	return description
+}
+
+// printVersion prints the version of the review tool.
+func printVersion(args []string) error {
+	if err := versionFlagSet.Parse(args); err != nil {
+		return err
+	}
+	if len(versionFlagSet.Args()) > 0 {
+		return errors.New("The version command does not take any arguments.")
+	}
+	info := GetVersionInfo()
+	if *versionJsonOutput {
+		jsonBytes, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonBytes))
+		return nil
+	}
+	fmt.Printf("git-appraise version %s\n", info.Version)
+	if info.Commit != "" {
+		fmt.Printf("commit: %s\n", info.Commit)
+	}
+	if info.BuildDate != "" {
+		fmt.Printf("built: %s\n", info.BuildDate)
+	}
+	fmt.Printf("go: %s\n", info.GoVersion)
+	fmt.Printf("note formats: request v%d, comment v%d, ci v%d, analyses v%d\n",
+		info.SchemaVersions["request"], info.SchemaVersions["comment"], info.SchemaVersions["ci"], info.SchemaVersions["analyses"])
+	return nil
+}
+
+// versionCmd defines the "version" subcommand.
+var versionCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s version [<option>...]\n\nOptions:\n", arg0)
+		versionFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return printVersion(args)
+	},
+	Flags: versionFlagSet,
+}
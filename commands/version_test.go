@@ -0,0 +1,41 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/review/request"
+	"testing"
+)
+
+func TestGetVersionInfo(t *testing.T) {
+	defer func(version, commit string) { Version, Commit = version, commit }(Version, Commit)
+	Version, Commit = "", ""
+	if info := GetVersionInfo(); info.Version == "" || info.GoVersion == "" {
+		t.Errorf("Missing version information: %+v", info)
+	}
+	Version, Commit = "1.2.3", "0123456789abcdef0123456789abcdef01234567"
+	info := GetVersionInfo()
+	if info.Version != "1.2.3" || info.Commit != Commit {
+		t.Errorf("The version set at build time was not used: %+v", info)
+	}
+	if info.SchemaVersions["request"] != request.FormatVersion {
+		t.Errorf("Unexpected request format version: got %d, want %d", info.SchemaVersions["request"], request.FormatVersion)
+	}
+	if description := info.String(); description != "git-appraise 1.2.3 (commit 0123456789ab)" {
+		t.Errorf("Unexpected description of the version: %q", description)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+)
+
+var viewedFlagSet = flag.NewFlagSet("viewed", flag.ContinueOnError)
+
+var (
+	viewedUnview = viewedFlagSet.Bool("unview", false, "Clear the viewed marker from the files, rather than setting it")
+	viewedReview = viewedFlagSet.String("review", "", "Hash of the review whose files to mark, rather than the current one")
+)
+
+// markViewed marks the given files of a review as viewed (or no longer viewed) by the current user.
+func markViewed(repo repository.Repo, args []string) error {
+	*viewedUnview, *viewedReview = false, ""
+	if err := viewedFlagSet.Parse(args); err != nil {
+		return err
+	}
+	paths := viewedFlagSet.Args()
+	if len(paths) == 0 {
+		return errors.New("No files given; list the paths of the files to mark as viewed.")
+	}
+
+	var r *review.Review
+	var err error
+	if *viewedReview != "" {
+		r, err = review.Get(repo, *viewedReview)
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %v\n", err)
+	}
+	if r == nil {
+		if *viewedReview != "" {
+			return noMatchingReview([]string{*viewedReview})
+		}
+		return noMatchingReview(nil)
+	}
+	userEmail, err := repo.GetUserEmail()
+	if err != nil {
+		return err
+	}
+	if err := r.SetViewed(userEmail, paths, !*viewedUnview); err != nil {
+		return err
+	}
+	if *viewedUnview {
+		fmt.Printf("Marked %d file(s) of review %.12s as not viewed.\n", len(paths), r.Revision)
+	} else {
+		fmt.Printf("Marked %d file(s) of review %.12s as viewed.\n", len(paths), r.Revision)
+	}
+	return nil
+}
+
+// viewedCmd defines the "viewed" subcommand.
+var viewedCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s viewed [-unview] [-review <review-hash>] <path>...\n\nOptions:\n", arg0)
+		viewedFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return markViewed(repo, args)
+	},
+	Flags: viewedFlagSet,
+}
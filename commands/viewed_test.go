@@ -0,0 +1,64 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/reviewtest"
+	"reflect"
+	"testing"
+)
+
+func TestMarkViewed(t *testing.T) {
+	repo := repository.NewRepoWithHistory(map[string][]string{"master": {"A"}})
+	repo.SetRef("refs/heads/feature", repo.AddCommit("B", "Add a and b", map[string]string{"a.txt": "a", "b.txt": "b"}, "A"))
+	revision, err := reviewtest.AddReview(repo, "refs/heads/feature", "refs/heads/master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := markViewed(repo, []string{"-review", revision, "c.txt"}); err == nil {
+		t.Fatal("Unexpectedly marked a file that the review does not change as viewed")
+	}
+	if err := markViewed(repo, []string{"-review", revision, "a.txt", "./b.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	repo.SetRef("refs/heads/feature", repo.AddCommit("C", "Change b", map[string]string{"b.txt": "b, again"}, "B"))
+	if err := markViewed(repo, []string{"-unview", "-review", revision, "a.txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := review.Get(repo, revision)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	files, err := r.GetChangedFiles("user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []review.ChangedFile{
+		{Path: "a.txt", Status: review.FileNotViewed},
+		{Path: "b.txt", Status: review.FileChangedSinceViewed},
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("Unexpected changed files: got %+v, want %+v", files, expected)
+	}
+	if files, err := r.GetChangedFiles("someone-else@example.com"); err != nil || files[1].Status != review.FileNotViewed {
+		t.Fatalf("Another reviewer's markers were used: %+v, %v", files, err)
+	}
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/ci"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// watchCursorFile is the name of the file (inside of the git directory) that
+// records which review heads have already been handled by the watcher.
+const watchCursorFile = "appraise-watch"
+
+// watchAgent is the agent name used for CI reports written by the watcher.
+const watchAgent = "git-appraise-watch"
+
+var watchFlagSet = flag.NewFlagSet("watch", flag.ContinueOnError)
+
+var (
+	watchInterval = watchFlagSet.Duration("interval", 30*time.Second, "How often to poll the remote for new or updated reviews")
+	watchExec     = watchFlagSet.String("exec", "", "Shell command to run for each new or updated review")
+	watchRemote   = watchFlagSet.String("remote", "origin", "Remote repo from which to fetch reviews")
+	watchReportCI = watchFlagSet.Bool("report-ci", false, "Record the exit status of the command as a CI report on the review")
+)
+
+// watchJob represents a single run of the watch command against one review head.
+type watchJob struct {
+	review     review.Review
+	headCommit string
+}
+
+// watcher tracks which reviews have been handled, and which are currently being handled.
+//
+// At most one run is in flight for each review. If a review is updated while its
+// command is still running, then only the newest update is run once the current run
+// finishes; any intermediate updates are coalesced away.
+type watcher struct {
+	repo       repository.Repo
+	command    string
+	remote     string
+	reportCI   bool
+	cursorPath string
+
+	mutex sync.Mutex
+	// cursor maps each review revision to the last head commit that completed a run.
+	cursor map[string]string
+	// latest maps each review revision to the last head commit that was scheduled.
+	latest  map[string]string
+	running map[string]bool
+	pending map[string]watchJob
+}
+
+// newWatcher creates a watcher, loading the cursor of previously handled reviews if it exists.
+func newWatcher(repo repository.Repo, command, remote string, reportCI bool) (*watcher, error) {
+	gitDir, err := repo.GetGitDir()
+	if err != nil {
+		return nil, err
+	}
+	w := &watcher{
+		repo:       repo,
+		command:    command,
+		remote:     remote,
+		reportCI:   reportCI,
+		cursorPath: filepath.Join(gitDir, watchCursorFile),
+		cursor:     make(map[string]string),
+		latest:     make(map[string]string),
+		running:    make(map[string]bool),
+		pending:    make(map[string]watchJob),
+	}
+	cursorBytes, err := ioutil.ReadFile(w.cursorPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(cursorBytes, &w.cursor); err != nil {
+			return nil, fmt.Errorf("Failed to parse the watch cursor %q: %v", w.cursorPath, err)
+		}
+	}
+	for revision, headCommit := range w.cursor {
+		w.latest[revision] = headCommit
+	}
+	return w, nil
+}
+
+// saveCursor writes the cursor out to disk, unless this is a dry run. The caller must
+// hold the watcher's mutex.
+func (w *watcher) saveCursor() error {
+	if repository.IsRecordOnly(w.repo) {
+		return nil
+	}
+	cursorBytes, err := json.Marshal(w.cursor)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(w.cursorPath, cursorBytes, 0644)
+}
+
+// poll fetches the latest reviews from the remote, and schedules a run for each
+// open review whose head has changed since it was last seen.
+func (w *watcher) poll() {
+	if err := w.repo.Fetch(w.remote); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to fetch from %q: %v\n", w.remote, err)
+	}
+	if err := w.repo.PullNotesAndArchive(w.remote, notesRefPattern, archiveRefPattern); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to pull the review notes from %q: %v\n", w.remote, err)
+	}
+	for _, r := range review.ListOpen(w.repo) {
+		headCommit, err := r.GetHeadCommit()
+		if err != nil {
+			continue
+		}
+		w.schedule(watchJob{review: r, headCommit: headCommit})
+	}
+}
+
+// schedule starts a run for the given job, unless that head has already been
+// scheduled, or a run for the same review is already in progress.
+func (w *watcher) schedule(job watchJob) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	revision := job.review.Revision
+	if w.latest[revision] == job.headCommit {
+		return
+	}
+	w.latest[revision] = job.headCommit
+	if w.running[revision] {
+		w.pending[revision] = job
+		return
+	}
+	w.running[revision] = true
+	go w.runAll(job)
+}
+
+// runAll runs the given job, followed by any job for the same review that was
+// scheduled while it was running.
+func (w *watcher) runAll(job watchJob) {
+	revision := job.review.Revision
+	for {
+		w.run(job)
+
+		w.mutex.Lock()
+		w.cursor[revision] = job.headCommit
+		if err := w.saveCursor(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to save the watch cursor: %v\n", err)
+		}
+		next, ok := w.pending[revision]
+		if !ok {
+			delete(w.running, revision)
+			w.mutex.Unlock()
+			return
+		}
+		delete(w.pending, revision)
+		w.mutex.Unlock()
+		job = next
+	}
+}
+
+// jobEnvironment returns the environment variables describing the review being run.
+func jobEnvironment(job watchJob) []string {
+	r := job.review
+	env := []string{
+		"APPRAISE_REVIEW_HASH=" + r.Revision,
+		"APPRAISE_REVIEW_REF=" + r.Request.ReviewRef,
+		"APPRAISE_TARGET_REF=" + r.Request.TargetRef,
+		"APPRAISE_HEAD_COMMIT=" + job.headCommit,
+		"APPRAISE_REQUESTER=" + r.Request.Requester,
+		"APPRAISE_DESCRIPTION=" + r.Request.Description,
+	}
+	if baseCommit, err := r.GetBaseCommit(); err == nil {
+		env = append(env, "APPRAISE_BASE_COMMIT="+baseCommit)
+	}
+	return env
+}
+
+// run checks out the head of the job's review into a temporary worktree, and runs the command there.
+func (w *watcher) run(job watchJob) {
+	fmt.Printf("Running %q for review %.12s at %.12s\n", w.command, job.review.Revision, job.headCommit)
+	tempDir, err := ioutil.TempDir("", "git-appraise-watch-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create a temporary directory: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(tempDir)
+	worktree := filepath.Join(tempDir, "worktree")
+	if err := w.repo.AddWorktree(worktree, job.headCommit); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to check out %.12s: %v\n", job.headCommit, err)
+		return
+	}
+	defer w.repo.RemoveWorktree(worktree)
+
+	cmd := shellCommand(w.command)
+	cmd.Dir = worktree
+	cmd.Env = append(os.Environ(), jobEnvironment(job)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	status := ci.StatusSuccess
+	if err := cmd.Run(); err != nil {
+		status = ci.StatusFailure
+	}
+	fmt.Printf("Review %.12s at %.12s: %s\n", job.review.Revision, job.headCommit, status)
+	if w.reportCI {
+		if err := w.writeReport(job, status); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to record the CI report: %v\n", err)
+		}
+	}
+}
+
+// writeReport records the given status as a CI report on the job's head commit,
+// and pushes it to the remote.
+func (w *watcher) writeReport(job watchJob, status string) error {
+	report := ci.Report{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Status:    status,
+		Agent:     watchAgent,
+	}
+	note, err := report.Write()
+	if err != nil {
+		return err
+	}
+	if err := w.repo.AppendNote(ci.Ref, job.headCommit, note); err != nil {
+		return err
+	}
+	return w.repo.PushNotesAndArchive(w.remote, notesRefPattern, archiveRefPattern)
+}
+
+// watchReviews polls for new and updated reviews until the repo's context is done, such
+// as when the process is interrupted.
+func watchReviews(repo repository.Repo, args []string) error {
+	if err := watchFlagSet.Parse(args); err != nil {
+		return err
+	}
+	if len(watchFlagSet.Args()) > 0 {
+		return CommandError{
+			Err:      errors.New("The watch command does not take any arguments."),
+			Guidance: "To follow the updates to a single review, run \"git appraise subscribe <review-hash>\".",
+			ExitCode: ExitUserError,
+		}
+	}
+	if *watchExec == "" {
+		return errors.New("The watch command requires the -exec flag.")
+	}
+	if *watchInterval <= 0 {
+		return errors.New("The -interval flag must be positive.")
+	}
+	w, err := newWatcher(repo, *watchExec, *watchRemote, *watchReportCI)
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(*watchInterval)
+	defer ticker.Stop()
+	for {
+		w.poll()
+		select {
+		case <-repo.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchCmd defines the "watch" subcommand.
+var watchCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s watch -exec <command> [<option>...]\n\nOptions:\n", arg0)
+		watchFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return watchReviews(repo, args)
+	},
+	Flags:       watchFlagSet,
+	LongRunning: true,
+}
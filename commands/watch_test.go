@@ -0,0 +1,197 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/reviewtest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// worktreeRepoForTest is a MemoryRepo whose worktrees are empty directories, so that
+// commands can be run in them.
+type worktreeRepoForTest struct {
+	*repository.MemoryRepo
+}
+
+func (r worktreeRepoForTest) AddWorktree(path, commit string) error {
+	if err := r.MemoryRepo.AddWorktree(path, commit); err != nil {
+		return err
+	}
+	return os.MkdirAll(path, 0755)
+}
+
+// newWatchTestRepo returns a repo holding a review of a branch with three commits, and
+// the temporary directory holding its git directory and the log of the watch command.
+func newWatchTestRepo(t *testing.T) (worktreeRepoForTest, *review.Review, string) {
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repository.NewRepoWithHistory(map[string][]string{"master": {"A"}, "feature": {"A", "B", "C", "D"}})
+	repo.SetPath(dir)
+	if err := os.MkdirAll(filepath.Join(dir, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	revision, err := reviewtest.AddReview(repo, "refs/heads/feature", "refs/heads/master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.Get(repo, revision)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v, %v", r, err)
+	}
+	return worktreeRepoForTest{repo}, r, dir
+}
+
+// newLoggingWatcher returns a watcher whose command logs the head commit of each run.
+func newLoggingWatcher(t *testing.T, repo repository.Repo, logPath string) *watcher {
+	w, err := newWatcher(repo, "echo $APPRAISE_HEAD_COMMIT >> '"+logPath+"'", "origin", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// waitForWatcher waits until the watcher has no runs in progress.
+func waitForWatcher(t *testing.T, w *watcher) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		w.mutex.Lock()
+		running := len(w.running)
+		w.mutex.Unlock()
+		if running == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for the watch command to finish")
+}
+
+// readWatchLog returns the head commits that the watch command was run for, in order.
+func readWatchLog(t *testing.T, logPath string) []string {
+	contents, err := ioutil.ReadFile(logPath)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return strings.Fields(string(contents))
+}
+
+func TestWatcherCoalescesUpdates(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The watch command is a shell command")
+	}
+	repo, r, dir := newWatchTestRepo(t)
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "log")
+	w := newLoggingWatcher(t, repo, logPath)
+	if _, err := captureStdout(func() error {
+		// The first update starts a run right away, and the ones made while it is
+		// running are coalesced into a single run of the newest head.
+		for _, head := range []string{"B", "C", "D", "D"} {
+			w.schedule(watchJob{review: *r, headCommit: head})
+		}
+		waitForWatcher(t, w)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if runs := readWatchLog(t, logPath); strings.Join(runs, " ") != "B D" {
+		t.Errorf("Unexpected runs of the watch command: %q", runs)
+	}
+	if w.cursor[r.Revision] != "D" {
+		t.Errorf("Unexpected cursor after the runs: %q", w.cursor)
+	}
+}
+
+func TestWatcherCursorSurvivesRestart(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The watch command is a shell command")
+	}
+	repo, r, dir := newWatchTestRepo(t)
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "log")
+	if _, err := captureStdout(func() error {
+		w := newLoggingWatcher(t, repo, logPath)
+		w.schedule(watchJob{review: *r, headCommit: "C"})
+		waitForWatcher(t, w)
+
+		// A new watcher, such as after the process restarts, skips the head that was
+		// already handled, but runs the command for the next one.
+		restarted := newLoggingWatcher(t, repo, logPath)
+		if restarted.cursor[r.Revision] != "C" {
+			t.Errorf("The cursor was not restored: %q", restarted.cursor)
+		}
+		restarted.schedule(watchJob{review: *r, headCommit: "C"})
+		waitForWatcher(t, restarted)
+		restarted.schedule(watchJob{review: *r, headCommit: "D"})
+		waitForWatcher(t, restarted)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if runs := readWatchLog(t, logPath); strings.Join(runs, " ") != "C D" {
+		t.Errorf("Unexpected runs of the watch command: %q", runs)
+	}
+}
+
+func TestWatchStopsWhenInterrupted(t *testing.T) {
+	defer resetFlags(watchFlagSet)
+	repo, _, dir := newWatchTestRepo(t)
+	defer os.RemoveAll(dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan error)
+	go func() {
+		done <- watchReviews(repo.WithContext(ctx), []string{"-exec", "true", "-interval", "1h"})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("The watch command kept running after it was interrupted")
+	}
+}
+
+func TestWatcherDryRunKeepsCursor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The watch command is a shell command")
+	}
+	repo, r, dir := newWatchTestRepo(t)
+	defer os.RemoveAll(dir)
+	w := newLoggingWatcher(t, repository.NewMutationRecorder(repo, true), filepath.Join(dir, "log"))
+	if _, err := captureStdout(func() error {
+		w.schedule(watchJob{review: *r, headCommit: "C"})
+		waitForWatcher(t, w)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git", watchCursorFile)); !os.IsNotExist(err) {
+		t.Errorf("A dry run wrote the watch cursor: %v", err)
+	}
+}
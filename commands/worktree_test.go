@@ -0,0 +1,96 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Failed to run git %v: %v\n%s", args, err, out)
+	}
+	return string(out)
+}
+
+func TestReviewFromLinkedWorktree(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("The git command line tool is not installed")
+	}
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mainPath := filepath.Join(dir, "main")
+	worktreePath := filepath.Join(dir, "worktree")
+	runGit(t, dir, "init", "-q", mainPath)
+	runGit(t, mainPath, "config", "user.email", "user@example.com")
+	runGit(t, mainPath, "config", "user.name", "Test User")
+	runGit(t, mainPath, "checkout", "-q", "-b", "master")
+	runGit(t, mainPath, "commit", "-q", "--allow-empty", "-m", "First commit")
+	runGit(t, mainPath, "branch", "release")
+	runGit(t, mainPath, "worktree", "add", "-q", "-b", "feature", worktreePath)
+	runGit(t, worktreePath, "commit", "-q", "--allow-empty", "-m", "Feature commit")
+
+	mainRepo, err := repository.NewGitRepo(mainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktreeRepo, err := repository.NewGitRepo(worktreePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mainGitDir, _ := mainRepo.GetGitDir()
+	worktreeGitDir, _ := worktreeRepo.GetGitDir()
+	if mainGitDir != worktreeGitDir {
+		t.Fatalf("The worktree uses a different git dir (%q) than the main repo (%q)", worktreeGitDir, mainGitDir)
+	}
+
+	if err := requestReview(worktreeRepo, []string{"-quiet", "-m", "Feature", "-r", "", "-source", "HEAD", "-target", "refs/heads/release"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := commentOnReview(worktreeRepo, []string{"-m", "Comment from a worktree"}); err != nil {
+		t.Fatal(err)
+	}
+	reviews := review.ListAll(mainRepo)
+	if len(reviews) != 1 || len(reviews[0].Comments) != 1 {
+		t.Fatalf("The main repo did not see the review written from the worktree: %v", reviews)
+	}
+
+	if err := submitReview(worktreeRepo, []string{"-tbr"}); err != nil {
+		t.Fatal(err)
+	}
+	if head, _ := worktreeRepo.GetHeadRef(); head != "refs/heads/release" {
+		t.Fatalf("Unexpected worktree HEAD after submitting: %q", head)
+	}
+	if head, _ := mainRepo.GetHeadRef(); head != "refs/heads/master" {
+		t.Fatalf("Submitting from the worktree changed the main repo's HEAD to %q", head)
+	}
+	if err := worktreeRepo.SwitchToRef("refs/heads/master"); err == nil {
+		t.Fatal("Unexpectedly switched to a ref checked out in another worktree")
+	}
+}
@@ -26,31 +26,77 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/google/git-appraise/commands"
 	"github.com/google/git-appraise/repository"
 	"os"
+	"os/signal"
 	"sort"
 	"strings"
 )
 
-const usageMessageTemplate = `Usage: %s <command>
+const usageMessageTemplate = `Usage: %s [-v | -vv] [--dry-run] <command>
 
 Where <command> is one of:
   %s
 
-For individual command usage, run:
+%sFor individual command usage, run:
   %s help <command>
+
+The -v (or --verbose) flag logs every git command that is run to stderr, and
+-vv also logs the beginning of each command's output. Setting the APPRAISE_DEBUG
+environment variable to 1 or 2 does the same.
+
+The --dry-run flag runs the command without changing anything, and prints the
+notes that it would have written and the refs that it would have updated.
 `
 
+const pluginsMessageTemplate = `Or one of these external commands, provided by the %s<command>
+executables on the PATH:
+  %s
+
+`
+
+// parseGlobalFlags removes the leading verbosity and dry run flags from the given arguments
+// (not including the program name), and returns the remaining arguments along with the
+// requested verbosity, and whether or not a dry run was requested.
+func parseGlobalFlags(args []string) ([]string, int, bool) {
+	flagVerbosity := 0
+	dryRun := false
+	for ; len(args) > 0; args = args[1:] {
+		if args[0] == "--dry-run" {
+			dryRun = true
+		} else if args[0] == "-v" || args[0] == "--verbose" {
+			flagVerbosity++
+		} else if args[0] == "-vv" {
+			flagVerbosity += 2
+		} else {
+			break
+		}
+	}
+	verbosity := repository.DebugVerbosity()
+	if flagVerbosity > verbosity {
+		verbosity = flagVerbosity
+	}
+	return args, verbosity, dryRun
+}
+
 func usage() {
 	command := os.Args[0]
 	var subcommands []string
 	for subcommand := range commands.CommandMap {
-		subcommands = append(subcommands, subcommand)
+		// Commands starting with "__" are only meant to be run by other tools.
+		if !strings.HasPrefix(subcommand, "__") {
+			subcommands = append(subcommands, subcommand)
+		}
 	}
 	sort.Strings(subcommands)
-	fmt.Printf(usageMessageTemplate, command, strings.Join(subcommands, "\n  "), command)
+	var plugins string
+	if names := commands.ListPlugins(); len(names) > 0 {
+		plugins = fmt.Sprintf(pluginsMessageTemplate, commands.PluginPrefix, strings.Join(names, "\n  "))
+	}
+	fmt.Printf(usageMessageTemplate, command, strings.Join(subcommands, "\n  "), plugins, command)
 }
 
 func help() {
@@ -60,6 +106,14 @@ func help() {
 	}
 	subcommand, ok := commands.CommandMap[os.Args[2]]
 	if !ok {
+		if plugin, found := commands.FindPlugin(os.Args[2]); found {
+			// External commands are expected to describe themselves, as git's do.
+			code, err := commands.RunPlugin(nil, plugin, []string{"--help"})
+			if err != nil {
+				fmt.Println(err.Error())
+			}
+			os.Exit(code)
+		}
 		fmt.Printf("Unknown command %q\n", os.Args[2])
 		usage()
 		return
@@ -68,6 +122,13 @@ func help() {
 }
 
 func main() {
+	args, verbosity, dryRun := parseGlobalFlags(os.Args[1:])
+	os.Args = append([]string{os.Args[0]}, args...)
+	if verbosity > 0 {
+		repository.DefaultCommandLogger = repository.NewCommandLogger(os.Stderr, verbosity)
+		// Logging the build makes it possible to tell which client wrote what, when comparing logs.
+		fmt.Fprintln(os.Stderr, commands.GetVersionInfo())
+	}
 	if len(os.Args) < 2 {
 		usage()
 		return
@@ -76,24 +137,80 @@ func main() {
 		help()
 		return
 	}
+	if os.Args[1] == "--version" {
+		os.Args[1] = "version"
+	}
+	if os.Args[1] == "completion" || os.Args[1] == "version" {
+		// These do not depend on the repo, so they can be run from anywhere.
+		if err := commands.CommandMap[os.Args[1]].Run(nil, os.Args[2:]); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(commands.ExitCode(err))
+		}
+		return
+	}
 	cwd, err := os.Getwd()
 	if err != nil {
 		fmt.Printf("Unable to get the current working directory: %q\n", err)
-		return
+		os.Exit(commands.ExitRepositoryError)
 	}
-	repo, err := repository.NewGitRepo(cwd)
-	if err != nil {
-		fmt.Printf("%s must be run from within a git repo.\n", os.Args[0])
-		return
+	var repo repository.Repo
+	gitRepo, err := repository.NewGitRepo(cwd)
+	if err == nil {
+		repo = gitRepo
 	}
 	subcommand, ok := commands.CommandMap[os.Args[1]]
+	if !ok {
+		if plugin, found := commands.FindPlugin(os.Args[1]); found {
+			if dryRun {
+				fmt.Printf("The --dry-run flag is not supported by the external command %q.\n", os.Args[1])
+				os.Exit(commands.ExitUserError)
+			}
+			if err != nil {
+				// External commands can be run from anywhere, like the git commands they mimic.
+				repo = nil
+			}
+			code, err := commands.RunPlugin(repo, plugin, os.Args[2:])
+			if err != nil {
+				fmt.Println(err.Error())
+			}
+			os.Exit(code)
+		}
+	}
+	if err != nil {
+		if !commands.UsesRemoteRepo(os.Args[1], os.Args[2:]) {
+			fmt.Printf("%s must be run from within a git repo.\n", os.Args[0])
+			os.Exit(commands.ExitRepositoryError)
+		}
+		// The command reads from a remote server, so it does not need a local repo.
+		repo = nil
+	}
 	if !ok {
 		fmt.Printf("Unknown command: %q\n", os.Args[1])
 		usage()
-		return
+		os.Exit(commands.ExitUserError)
+	}
+	// An interrupt kills any git command that is running, and makes those that follow fail,
+	// so that the command returns an error rather than just dying. A second interrupt exits
+	// right away.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if repo != nil {
+		repo = repo.WithContext(ctx)
+	}
+	run := subcommand.Run
+	if dryRun && repo != nil {
+		run = subcommand.DryRun
+	} else if repo != nil && os.Args[1] != "undo" {
+		// Record what the command changes, so that "undo" can roll it back.
+		run = func(repo repository.Repo, args []string) error {
+			return subcommand.RunAndJournal(repo, os.Args[1], args)
+		}
 	}
-	if err := subcommand.Run(repo, os.Args[2:]); err != nil {
+	if err := run(repo, os.Args[2:]); err != nil {
 		fmt.Println(err.Error())
-		os.Exit(1)
+		os.Exit(commands.ExitCode(err))
 	}
 }
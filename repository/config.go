@@ -0,0 +1,162 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"strings"
+)
+
+// SharedConfigPath is the path, within a repo, of the optional file holding the team-wide
+// defaults for the "appraise.*" settings.
+//
+// The file uses the same format as git config files, and is read as it was committed at HEAD,
+// so that everyone working on the same commit gets the same defaults.
+const SharedConfigPath = ".appraise/config"
+
+// sharedConfigKeyPrefix is the prefix of the keys that may be set in the shared config file.
+const sharedConfigKeyPrefix = "appraise."
+
+// ConfigValue is a single value of a config key, along with where it was set.
+type ConfigValue struct {
+	Value string
+	// Origin is the scope of the git config that the value was set in (such as "global" or
+	// "local"), or SharedConfigPath if it was set in the shared config file.
+	Origin string
+}
+
+// splitConfigKey splits the given config key into its lower-cased section and name, and its subsection.
+func splitConfigKey(key string) (section, subsection, name string) {
+	first, last := strings.Index(key, "."), strings.LastIndex(key, ".")
+	if first < 0 {
+		return strings.ToLower(key), "", ""
+	}
+	if first < last {
+		subsection = key[first+1 : last]
+	}
+	return strings.ToLower(key[:first]), subsection, strings.ToLower(key[last+1:])
+}
+
+// parseConfigValue returns the given value from a config file with its quotes, escapes, and
+// trailing comment removed.
+func parseConfigValue(raw string) string {
+	var value strings.Builder
+	quoted := false
+	pendingSpace := ""
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '\\' && i+1 < len(raw):
+			i++
+			value.WriteString(pendingSpace)
+			pendingSpace = ""
+			switch raw[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			default:
+				value.WriteByte(raw[i])
+			}
+		case c == '"':
+			quoted = !quoted
+		case !quoted && (c == '#' || c == ';'):
+			return value.String()
+		case !quoted && (c == ' ' || c == '\t'):
+			// Whitespace is only kept between words, and not at the end of the value.
+			if value.Len() > 0 {
+				pendingSpace += string(c)
+			}
+		default:
+			value.WriteString(pendingSpace)
+			pendingSpace = ""
+			value.WriteByte(c)
+		}
+	}
+	return value.String()
+}
+
+// parseConfigFile returns all of the values set for the given key in the given contents of
+// a file in the git config format.
+func parseConfigFile(contents, key string) []string {
+	wantSection, wantSubsection, wantName := splitConfigKey(key)
+	var values []string
+	var section, subsection string
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			header := line[1:]
+			if end := strings.LastIndex(header, "]"); end >= 0 {
+				header = header[:end]
+			}
+			if space := strings.IndexAny(header, " \t"); space >= 0 {
+				// A section header of the form [section "subsection"].
+				section = strings.ToLower(header[:space])
+				subsection = parseConfigValue(strings.TrimSpace(header[space:]))
+			} else if dot := strings.Index(header, "."); dot >= 0 {
+				// The deprecated form [section.subsection], whose subsection is lower-cased.
+				section, subsection = strings.ToLower(header[:dot]), strings.ToLower(header[dot+1:])
+			} else {
+				section, subsection = strings.ToLower(header), ""
+			}
+			continue
+		}
+		name, value := line, "true"
+		if equals := strings.Index(line, "="); equals >= 0 {
+			name, value = strings.TrimSpace(line[:equals]), parseConfigValue(strings.TrimSpace(line[equals+1:]))
+		}
+		if section == wantSection && subsection == wantSubsection && strings.ToLower(name) == wantName {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// GetConfigWithDefaults returns all of the values set for the given config key, along with
+// where each was set.
+//
+// The git config (with its usual precedence of the system, global, and local scopes) always
+// wins; only if the key is not set there are the values in the shared config file returned.
+func GetConfigWithDefaults(repo Repo, key string) ([]ConfigValue, error) {
+	values, err := repo.GetConfigValuesWithOrigin(key)
+	if err != nil || len(values) > 0 || !strings.HasPrefix(strings.ToLower(key), sharedConfigKeyPrefix) {
+		return values, err
+	}
+	contents, err := repo.Show("HEAD", SharedConfigPath)
+	if err != nil {
+		// There are no shared defaults.
+		return nil, nil
+	}
+	for _, value := range parseConfigFile(contents, key) {
+		values = append(values, ConfigValue{Value: value, Origin: SharedConfigPath})
+	}
+	return values, nil
+}
+
+// GetConfigValue returns the value of the given config key, including its shared default,
+// or the given default if it is not set.
+//
+// As with git itself, the last value set for the key wins.
+func GetConfigValue(repo Repo, key, defaultValue string) string {
+	values, err := GetConfigWithDefaults(repo, key)
+	if err != nil || len(values) == 0 {
+		return defaultValue
+	}
+	return values[len(values)-1].Value
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testSharedConfig = `# Team-wide defaults
+[appraise]
+	requiredApprovals = 2 ; two reviewers
+	notifyEmail = "team@example.com"
+[appraise "submit"]
+	strategy = squash
+[Appraise.Request]
+	target = refs/heads/main
+[appraise "Request"]
+	target = refs/heads/other
+`
+
+func TestParseConfigFile(t *testing.T) {
+	for key, want := range map[string][]string{
+		"appraise.requiredApprovals": {"2"},
+		"APPRAISE.REQUIREDAPPROVALS": {"2"},
+		"appraise.notifyEmail":       {"team@example.com"},
+		"appraise.submit.strategy":   {"squash"},
+		"appraise.request.target":    {"refs/heads/main"},
+		"appraise.Request.target":    {"refs/heads/other"},
+		"appraise.strategy":          nil,
+	} {
+		if values := parseConfigFile(testSharedConfig, key); !reflect.DeepEqual(values, want) {
+			t.Errorf("Unexpected values for %q: got %q, want %q", key, values, want)
+		}
+	}
+	if value := parseConfigValue(`"a \"quoted\" value" # comment`); value != `a "quoted" value` {
+		t.Errorf("Unexpected parsed value: %q", value)
+	}
+}
+
+func TestGetConfigWithDefaults(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	if err := os.MkdirAll(filepath.Join(repo.Path, ".appraise"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(repo.Path, SharedConfigPath), []byte(testSharedConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"add", SharedConfigPath},
+		{"commit", "-q", "-m", "Add the shared config"},
+		{"config", "appraise.submit.strategy", "rebase"},
+	} {
+		if _, err := repo.runGitCommand(args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	values, err := GetConfigWithDefaults(repo, "appraise.submit.strategy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []ConfigValue{{Value: "rebase", Origin: "local"}}; !reflect.DeepEqual(values, want) {
+		t.Fatalf("The git config did not win over the shared config: %v", values)
+	}
+	values, err = GetConfigWithDefaults(repo, "appraise.requiredApprovals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []ConfigValue{{Value: "2", Origin: SharedConfigPath}}; !reflect.DeepEqual(values, want) {
+		t.Fatalf("Unexpected shared config values: %v", values)
+	}
+	if value := GetConfigValue(repo, "appraise.color", "auto"); value != "auto" {
+		t.Fatalf("Unexpected value for an unset key: %q", value)
+	}
+}
@@ -18,45 +18,265 @@ limitations under the License.
 package repository
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
-const branchRefPrefix = "refs/heads/"
+const (
+	branchRefPrefix = "refs/heads/"
+
+	// endOfOptions separates the options of a git command from the revisions that follow, so
+	// that a revision which starts with "-" cannot be mistaken for an option.
+	endOfOptions = "--end-of-options"
+
+	// notesScratchRefPrefix is the prefix for the temporary refs used while updating notes.
+	notesScratchRefPrefix = "refs/notes/appraise-scratch/"
+
+	// maxNotesUpdateAttempts bounds the number of times a notes update is retried when it
+	// races with another writer.
+	maxNotesUpdateAttempts = 10
+
+	// notesLockFile is the name of the lock file (inside of the git directory) that
+	// serializes note writes made by git-appraise.
+	notesLockFile = "appraise-notes.lock"
+	// notesLockTimeout is how long to wait for another process to release the notes lock.
+	notesLockTimeout = 30 * time.Second
+	// staleNotesLockAge is the age after which a notes lock is assumed to have been
+	// left behind by a process that crashed.
+	staleNotesLockAge = 5 * time.Minute
+
+	// commandWaitDelay is how long to wait for the output of a killed git command to be
+	// closed, in case it was inherited by a process that outlived it.
+	commandWaitDelay = 5 * time.Second
+
+	// DefaultNotesNamespace is the prefix of the notes refs that hold the review data,
+	// unless the "appraise.namespace" git config setting specifies a different one.
+	DefaultNotesNamespace = "refs/notes/devtools/"
+	// defaultArchiveNamespace is the prefix of the refs that archive reviewed commits.
+	defaultArchiveNamespace = "refs/devtools/"
+	// NamespaceConfigKey is the git config key holding a custom notes namespace.
+	NamespaceConfigKey = "appraise.namespace"
+
+	// shallowFile is the name of the file (inside of the git directory) that lists the
+	// commits whose parents were left out of a shallow clone.
+	shallowFile = "shallow"
+)
+
+// notesScratchRefCounter is used to give each scratch notes ref a unique name within the process.
+var notesScratchRefCounter uint64
 
 // GitRepo represents an instance of a (local) git repository.
 type GitRepo struct {
 	Path string
+
+	// notesNamespace and archiveNamespace replace DefaultNotesNamespace and
+	// defaultArchiveNamespace in the refs passed to the GitRepo methods, if set.
+	notesNamespace   string
+	archiveNamespace string
+
+	// deepenAttempted records whether fetching the history missing from a shallow clone
+	// has already been tried.
+	deepenAttempted bool
+
+	// Logger, if set, is told about every git command that is run.
+	Logger CommandLogger
+
+	// ctx, if set, kills any git command that is still running once it is done.
+	ctx context.Context
+}
+
+// splitLines splits the given output of a git command into lines, accepting either
+// "\n" or "\r\n" as the line ending, since git on Windows may be configured to use the latter.
+func splitLines(out string) []string {
+	lines := strings.Split(out, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// gitCommand returns the git command with the given arguments, to be run in the repo,
+// which is killed if the repo's context is done before it finishes.
+//
+// The command is left in the review tool's process group, rather than being given its own,
+// since git may prompt for credentials on the terminal, which a background process group
+// cannot read from. That way, an interrupt from the terminal reaches the command (along with
+// any ssh or credential helper that it runs) directly.
+func (repo *GitRepo) gitCommand(args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(repo.Context(), "git", args...)
+	cmd.Dir = repo.Path
+	cmd.WaitDelay = commandWaitDelay
+	return cmd
+}
+
+// runCommand runs the given git command, and tells the repo's logger (if any) about it.
+//
+// The stdout and stderr arguments hold whatever output of the command is captured, or
+// are nil if that output is not captured. If the command is stopped because the repo's
+// context is done, then the returned error is a CanceledError.
+func (repo *GitRepo) runCommand(cmd *exec.Cmd, stdout, stderr *bytes.Buffer) error {
+	start := time.Now()
+	err := cmd.Run()
+	if repo.Logger != nil {
+		trace := CommandTrace{
+			Args:     cmd.Args[1:],
+			Dir:      cmd.Dir,
+			Duration: time.Since(start),
+			ExitCode: exitCode(err),
+		}
+		if stdout != nil {
+			trace.Stdout = stdout.Bytes()
+		}
+		if stderr != nil {
+			trace.Stderr = stderr.Bytes()
+		}
+		repo.Logger.LogCommand(trace)
+	}
+	if ctxErr := repo.Context().Err(); err != nil && ctxErr != nil {
+		return CanceledError{Operation: formatCommand(cmd.Args[1:]), Err: ctxErr}
+	}
+	return err
+}
+
+// runCapturingOutput runs the given git command and returns its stdout, with its stderr
+// included in the error (as with exec.Cmd.Output) if the command fails.
+func (repo *GitRepo) runCapturingOutput(cmd *exec.Cmd) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := repo.runCommand(cmd, &stdout, &stderr)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exitErr.Stderr = stderr.Bytes()
+	}
+	return stdout.Bytes(), err
 }
 
 // Run the given git command and return its stdout, or an error if the command fails.
 func (repo *GitRepo) runGitCommand(args ...string) (string, error) {
-	cmd := exec.Command("git", args...)
-	cmd.Dir = repo.Path
-	out, err := cmd.Output()
-	return strings.Trim(string(out), "\n"), err
+	cmd := repo.gitCommand(args...)
+	out, err := repo.runCapturingOutput(cmd)
+	return strings.Trim(string(out), "\r\n"), err
 }
 
 // Run the given git command using the same stdin, stdout, and stderr as the review tool.
 func (repo *GitRepo) runGitCommandInline(args ...string) error {
-	cmd := exec.Command("git", args...)
-	cmd.Dir = repo.Path
+	cmd := repo.gitCommand(args...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	return repo.runCommand(cmd, nil, nil)
+}
+
+// Run the given git command using the same stdin, stdout, and stderr as the review tool,
+// while also capturing the stderr output so that it can be inspected.
+func (repo *GitRepo) runGitCommandInlineWithStderr(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := repo.gitCommand(args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	err := repo.runCommand(cmd, nil, &stderr)
+	return stderr.String(), err
+}
+
+// Run the given git command, feeding it the given stdin, and return its stdout.
+func (repo *GitRepo) runGitCommandWithStdin(stdin []byte, args ...string) (string, error) {
+	out, err := repo.runGitCommandWithStdinRaw(stdin, args...)
+	return strings.Trim(string(out), "\r\n"), err
+}
+
+// Run the given git command, feeding it the given stdin, and return its unmodified stdout.
+func (repo *GitRepo) runGitCommandWithStdinRaw(stdin []byte, args ...string) ([]byte, error) {
+	cmd := repo.gitCommand(args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	return repo.runCapturingOutput(cmd)
+}
+
+// getObjectTypes returns the types of the given objects, using a single "git cat-file" process.
+//
+// Objects that do not exist in the repository are left out of the result.
+func (repo *GitRepo) getObjectTypes(objects []string) (map[string]string, error) {
+	types := make(map[string]string)
+	if len(objects) == 0 {
+		return types, nil
+	}
+	out, err := repo.runGitCommandWithStdin([]byte(strings.Join(objects, "\n")+"\n"), "cat-file", "--batch-check=%(objectname) %(objecttype)")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range splitLines(out) {
+		// Missing objects are reported as "<object> missing".
+		lineParts := strings.Split(line, " ")
+		if len(lineParts) == 2 && lineParts[1] != "missing" {
+			types[lineParts[0]] = lineParts[1]
+		}
+	}
+	return types, nil
+}
+
+// readBlobs returns the contents of the given blobs, keyed by blob hash, using a single
+// "git cat-file" process. Blobs that do not exist in the repository are left out of the result.
+func (repo *GitRepo) readBlobs(blobs []string) (map[string]string, error) {
+	contents := make(map[string]string)
+	if len(blobs) == 0 {
+		return contents, nil
+	}
+	out, err := repo.runGitCommandWithStdinRaw([]byte(strings.Join(blobs, "\n")+"\n"), "cat-file", "--batch")
+	if err != nil {
+		return nil, err
+	}
+	// Each object is written as a "<object> <type> <size>" line, followed by the contents
+	// and a newline. Missing objects are reported as just a "<object> missing" line.
+	for len(out) > 0 {
+		headerEnd := bytes.IndexByte(out, '\n')
+		if headerEnd < 0 {
+			return nil, fmt.Errorf("Truncated output from git cat-file: %q", out)
+		}
+		header := strings.Split(string(out[:headerEnd]), " ")
+		out = out[headerEnd+1:]
+		if len(header) == 2 && header[1] == "missing" {
+			continue
+		}
+		if len(header) != 3 {
+			return nil, fmt.Errorf("Unexpected output from git cat-file: %q", strings.Join(header, " "))
+		}
+		size, err := strconv.Atoi(header[2])
+		if err != nil || size+1 > len(out) {
+			return nil, fmt.Errorf("Unexpected object size from git cat-file: %q", strings.Join(header, " "))
+		}
+		contents[header[0]] = string(out[:size])
+		out = out[size+1:]
+	}
+	return contents, nil
 }
 
 // NewGitRepo determines if the given working directory is inside of a git repository,
 // and returns the corresponding GitRepo instance if it is.
 func NewGitRepo(path string) (*GitRepo, error) {
-	repo := &GitRepo{Path: path}
+	repo := &GitRepo{Path: path, Logger: DefaultCommandLogger}
 	_, err := repo.runGitCommand("rev-parse")
 	if err == nil {
+		namespaces, err := repo.GetConfigValues(NamespaceConfigKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(namespaces) > 0 {
+			repo.setNamespace(namespaces[len(namespaces)-1])
+		}
 		return repo, nil
 	}
 	if _, ok := err.(*exec.ExitError); ok {
@@ -65,11 +285,197 @@ func NewGitRepo(path string) (*GitRepo, error) {
 	return nil, err
 }
 
+// parseNamespace returns the notes and archive namespaces for the given notes namespace setting.
+//
+// The namespace is normalized to the form "refs/notes/<name>/", and the corresponding
+// archive refs are stored under "refs/<name>/". If the setting is empty, then so are both
+// of the returned namespaces.
+func parseNamespace(namespace string) (string, string) {
+	name := strings.Trim(strings.TrimPrefix(namespace, "refs/notes/"), "/")
+	if name == "" {
+		return "", ""
+	}
+	return "refs/notes/" + name + "/", "refs/" + name + "/"
+}
+
+// translateNamespace translates a ref (or ref pattern) in the default namespaces into the given ones.
+func translateNamespace(ref, notesNamespace, archiveNamespace string) string {
+	if notesNamespace != "" && strings.HasPrefix(ref, DefaultNotesNamespace) {
+		return notesNamespace + strings.TrimPrefix(ref, DefaultNotesNamespace)
+	}
+	if archiveNamespace != "" && strings.HasPrefix(ref, defaultArchiveNamespace) {
+		return archiveNamespace + strings.TrimPrefix(ref, defaultArchiveNamespace)
+	}
+	return ref
+}
+
+// setNamespace configures the repo to store its review data under the given notes namespace.
+func (repo *GitRepo) setNamespace(namespace string) {
+	repo.notesNamespace, repo.archiveNamespace = parseNamespace(namespace)
+}
+
+// namespaced translates a ref (or ref pattern) in the default namespaces into the configured ones.
+func (repo *GitRepo) namespaced(ref string) string {
+	return translateNamespace(ref, repo.notesNamespace, repo.archiveNamespace)
+}
+
 // GetPath returns the path to the repo.
 func (repo *GitRepo) GetPath() string {
 	return repo.Path
 }
 
+// Context returns the context that kills the repo's git commands once it is done.
+func (repo *GitRepo) Context() context.Context {
+	if repo.ctx == nil {
+		return context.Background()
+	}
+	return repo.ctx
+}
+
+// WithContext returns a copy of the repo whose git commands are killed once the given context is done.
+func (repo *GitRepo) WithContext(ctx context.Context) Repo {
+	copied := *repo
+	copied.ctx = ctx
+	return &copied
+}
+
+// IsBare returns whether or not the repository is bare, i.e. has no working tree.
+func (repo *GitRepo) IsBare() (bool, error) {
+	out, err := repo.runGitCommand("rev-parse", "--is-bare-repository")
+	if err != nil {
+		return false, err
+	}
+	return out == "true", nil
+}
+
+// IsShallow returns whether or not the repository is a shallow clone, missing some of its history.
+func (repo *GitRepo) IsShallow() (bool, error) {
+	shallowCommits, err := repo.getShallowCommits()
+	return len(shallowCommits) > 0, err
+}
+
+// getShallowCommits returns the commits whose parents are missing from a shallow clone.
+//
+// This is empty if the repository has its complete history.
+func (repo *GitRepo) getShallowCommits() (map[string]bool, error) {
+	shallowCommits := make(map[string]bool)
+	gitDir, err := repo.GetGitDir()
+	if err != nil {
+		return nil, err
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(gitDir, shallowFile))
+	if os.IsNotExist(err) {
+		return shallowCommits, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, commit := range splitLines(strings.TrimSpace(string(contents))) {
+		if commit != "" {
+			shallowCommits[commit] = true
+		}
+	}
+	return shallowCommits, nil
+}
+
+// hasHistory determines if the given revisions, along with all of their ancestors, are present.
+func (repo *GitRepo) hasHistory(shallowCommits map[string]bool, revisions []string) bool {
+	args := append(append([]string{"rev-list"}, revisions...), "--")
+	out, err := repo.runGitCommand(args...)
+	if err != nil {
+		// At least one of the revisions is missing.
+		return false
+	}
+	for _, commit := range splitLines(out) {
+		if shallowCommits[commit] {
+			return false
+		}
+	}
+	return true
+}
+
+// getDefaultRemote returns the remote to fetch missing history from, or "" if there is none.
+//
+// That is "origin", if it exists, or else the only remote of the repository.
+func (repo *GitRepo) getDefaultRemote() string {
+	remotes, err := repo.ListRemotes()
+	if err != nil {
+		return ""
+	}
+	for _, remote := range remotes {
+		if remote == "origin" {
+			return remote
+		}
+	}
+	if len(remotes) == 1 {
+		return remotes[0]
+	}
+	return ""
+}
+
+// deepen fetches the full history of the given revisions from the default remote, and
+// returns whether or not that succeeded.
+//
+// Branches are fetched into the corresponding remote-tracking refs; other revisions are
+// fetched along with the refs configured for the remote. This is only attempted once, since
+// it is slow, and any later attempt would most likely fail in the same way.
+func (repo *GitRepo) deepen(revisions []string) bool {
+	if repo.deepenAttempted {
+		return false
+	}
+	repo.deepenAttempted = true
+	remote := repo.getDefaultRemote()
+	if remote == "" {
+		return false
+	}
+	args := []string{"fetch", "-q", "--no-tags", "--unshallow", remote}
+	for _, revision := range revisions {
+		if strings.HasPrefix(revision, branchRefPrefix) {
+			branch := strings.TrimPrefix(revision, branchRefPrefix)
+			args = append(args, fmt.Sprintf("+%s:refs/remotes/%s/%s", revision, remote, branch))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Fetching the history missing from this shallow clone from the remote %q.\n", remote)
+	_, err := repo.runGitCommand(args...)
+	return err == nil
+}
+
+// requireHistory ensures that the given revisions, along with all of their ancestors, are present
+// for the given operation.
+//
+// This only matters in a shallow clone, where any missing history is fetched from the remote. If
+// that is not possible, then the returned error is a ShallowRepoError.
+func (repo *GitRepo) requireHistory(operation string, revisions ...string) error {
+	shallowCommits, err := repo.getShallowCommits()
+	if err != nil || len(shallowCommits) == 0 {
+		return err
+	}
+	if repo.hasHistory(shallowCommits, revisions) {
+		return nil
+	}
+	if repo.deepen(revisions) {
+		if shallowCommits, err := repo.getShallowCommits(); err == nil && repo.hasHistory(shallowCommits, revisions) {
+			return nil
+		}
+	}
+	return ShallowRepoError{Operation: operation}
+}
+
+// GetGitDir returns the path to the directory holding the repo's git metadata.
+//
+// For a linked worktree, this is the common directory shared by every worktree
+// of the repository, rather than the worktree's private git directory.
+func (repo *GitRepo) GetGitDir() (string, error) {
+	gitDir, err := repo.runGitCommand("rev-parse", "--git-common-dir")
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(repo.Path, gitDir)
+	}
+	return gitDir, nil
+}
+
 // GetRepoStateHash returns a hash which embodies the entire current state of a repository.
 func (repo *GitRepo) GetRepoStateHash() (string, error) {
 	stateSummary, error := repo.runGitCommand("show-ref")
@@ -81,8 +487,67 @@ func (repo *GitRepo) GetUserEmail() (string, error) {
 	return repo.runGitCommand("config", "user.email")
 }
 
+// GetConfigValues returns all of the values set for the given git config key.
+//
+// If the key is not set, then the returned slice is empty.
+func (repo *GitRepo) GetConfigValues(key string) ([]string, error) {
+	out, err := repo.runGitCommand("config", "--get-all", key)
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		// Exit code 1 means that the key was not set.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return splitLines(out), nil
+}
+
+// GetConfigValuesWithOrigin returns all of the values set for the given git config key,
+// along with the scope of the git config that each was set in.
+func (repo *GitRepo) GetConfigValuesWithOrigin(key string) ([]ConfigValue, error) {
+	out, err := repo.runGitCommand("config", "--show-scope", "--get-all", key)
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var values []ConfigValue
+	for _, line := range splitLines(out) {
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Unexpected output from git config: %q", line)
+		}
+		values = append(values, ConfigValue{Value: parts[1], Origin: parts[0]})
+	}
+	return values, nil
+}
+
+// SetConfigValue sets the given git config key to the given value in the repository's own config,
+// replacing any values that were previously set for it there.
+func (repo *GitRepo) SetConfigValue(key, value string) error {
+	_, err := repo.runGitCommand("config", "--replace-all", key, value)
+	return err
+}
+
+// ListRemotes returns the names of all of the remotes configured for the repo.
+func (repo *GitRepo) ListRemotes() ([]string, error) {
+	out, err := repo.runGitCommand("remote")
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		return nil, nil
+	}
+	return splitLines(out), nil
+}
+
 // HasUncommittedChanges returns true if there are local, uncommitted changes.
 func (repo *GitRepo) HasUncommittedChanges() (bool, error) {
+	if bare, err := repo.IsBare(); err != nil || bare {
+		// A bare repository has no working tree, and so cannot have any uncommitted changes.
+		return false, err
+	}
 	out, err := repo.runGitCommand("status", "--porcelain")
 	if err != nil {
 		return false, err
@@ -93,6 +558,106 @@ func (repo *GitRepo) HasUncommittedChanges() (bool, error) {
 	return false, nil
 }
 
+// StashChanges saves the local, uncommitted changes (including untracked files) under
+// the given message, and then reverts them, leaving the working tree clean.
+//
+// The returned stash identifies the saved changes, and is empty if there were none.
+func (repo *GitRepo) StashChanges(message string) (string, error) {
+	previous, _ := repo.runGitCommand("rev-parse", "-q", "--verify", "refs/stash")
+	if _, err := repo.runGitCommand("stash", "push", "--include-untracked", "-m", message); err != nil {
+		return "", err
+	}
+	stash, _ := repo.runGitCommand("rev-parse", "-q", "--verify", "refs/stash")
+	if stash == previous {
+		return "", nil
+	}
+	return stash, nil
+}
+
+// RestoreStash reapplies the changes saved by StashChanges, and then drops the stash.
+//
+// If the changes cannot be reapplied, then the stash is kept so that they are not lost.
+func (repo *GitRepo) RestoreStash(stash string) error {
+	out, err := repo.runGitCommand("stash", "list", "--format=%H")
+	if err != nil {
+		return err
+	}
+	for i, entry := range splitLines(out) {
+		if entry == stash {
+			return repo.runGitCommandInline("stash", "pop", fmt.Sprintf("stash@{%d}", i))
+		}
+	}
+	return fmt.Errorf("The stash %.12s no longer exists.", stash)
+}
+
+// SnapshotChanges records either the staged changes, or all of the uncommitted changes to
+// tracked files, in a new commit on top of HEAD, and points the given (new) ref at it.
+//
+// This leaves the working tree, the index, and HEAD untouched.
+func (repo *GitRepo) SnapshotChanges(ref, message string, staged bool) (string, error) {
+	head, err := repo.runGitCommand("rev-parse", "--verify", "HEAD^{commit}")
+	if err != nil {
+		return "", err
+	}
+	headTree, err := repo.runGitCommand("rev-parse", "--verify", head+"^{tree}")
+	if err != nil {
+		return "", err
+	}
+	var tree string
+	if staged {
+		tree, err = repo.runGitCommand("write-tree")
+	} else {
+		// Unlike "stash push", "stash create" neither reverts the changes nor records them in the stash list.
+		var stash string
+		if stash, err = repo.runGitCommand("stash", "create"); err == nil && stash != "" {
+			tree, err = repo.runGitCommand("rev-parse", "--verify", stash+"^{tree}")
+		} else {
+			tree = headTree
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if tree == headTree {
+		return "", ErrNoChanges
+	}
+	commit, err := repo.runGitCommand("commit-tree", tree, "-p", head, "-m", message)
+	if err != nil {
+		return "", err
+	}
+	if _, err := repo.runGitCommand("update-ref", ref, commit, ""); err != nil {
+		return "", err
+	}
+	return commit, nil
+}
+
+// DeleteRef deletes the given ref.
+func (repo *GitRepo) DeleteRef(ref string) error {
+	_, err := repo.runGitCommand("update-ref", "-d", ref)
+	return err
+}
+
+// UpdateRef points the given ref at the given commit, or deletes it if the commit is
+// empty, but only if the ref still points at oldValue (or does not exist, if that is empty).
+func (repo *GitRepo) UpdateRef(ref, newValue, oldValue string) error {
+	ref = repo.namespaced(ref)
+	var err error
+	if newValue == "" {
+		_, err = repo.runGitCommand("update-ref", "-d", ref, oldValue)
+	} else {
+		_, err = repo.runGitCommand("update-ref", ref, newValue, oldValue)
+	}
+	return err
+}
+
+// DeleteRemoteRef deletes the given ref from the given remote repo.
+func (repo *GitRepo) DeleteRemoteRef(remote, ref string) error {
+	if err := repo.runGitCommandInline("push", remote, "--delete", ref); err != nil {
+		return fmt.Errorf("Failed to delete %s from the remote '%s': %v", ref, remote, err)
+	}
+	return nil
+}
+
 // VerifyCommit verifies that the supplied hash points to a known commit.
 func (repo *GitRepo) VerifyCommit(hash string) error {
 	out, err := repo.runGitCommand("cat-file", "-t", hash)
@@ -109,6 +674,9 @@ func (repo *GitRepo) VerifyCommit(hash string) error {
 // VerifyGitRef verifies that the supplied ref points to a known commit.
 func (repo *GitRepo) VerifyGitRef(ref string) error {
 	_, err := repo.runGitCommand("show-ref", "--verify", ref)
+	if _, ok := err.(*exec.ExitError); ok {
+		return RefMissingError{Ref: ref}
+	}
 	return err
 }
 
@@ -119,7 +687,7 @@ func (repo *GitRepo) GetHeadRef() (string, error) {
 
 // GetCommitHash returns the hash of the commit pointed to by the given ref.
 func (repo *GitRepo) GetCommitHash(ref string) (string, error) {
-	return repo.runGitCommand("show", "-s", "--format=%H", ref)
+	return repo.runGitCommand("show", "-s", "--format=%H", endOfOptions, ref)
 }
 
 // ResolveRefCommit returns the commit pointed to by the given ref, which may be a remote ref.
@@ -142,7 +710,7 @@ func (repo *GitRepo) ResolveRefCommit(ref string) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		matchingRefs := strings.Split(matchingOutput, "\n")
+		matchingRefs := splitLines(matchingOutput)
 		if len(matchingRefs) == 1 && matchingRefs[0] != "" {
 			// There is exactly one match
 			return repo.GetCommitHash(matchingRefs[0])
@@ -154,17 +722,17 @@ func (repo *GitRepo) ResolveRefCommit(ref string) (string, error) {
 
 // GetCommitMessage returns the message stored in the commit pointed to by the given ref.
 func (repo *GitRepo) GetCommitMessage(ref string) (string, error) {
-	return repo.runGitCommand("show", "-s", "--format=%B", ref)
+	return repo.runGitCommand("show", "-s", "--format=%B", endOfOptions, ref)
 }
 
 // GetCommitTime returns the commit time of the commit pointed to by the given ref.
 func (repo *GitRepo) GetCommitTime(ref string) (string, error) {
-	return repo.runGitCommand("show", "-s", "--format=%ct", ref)
+	return repo.runGitCommand("show", "-s", "--format=%ct", endOfOptions, ref)
 }
 
 // GetLastParent returns the last parent of the given commit (as ordered by git).
 func (repo *GitRepo) GetLastParent(ref string) (string, error) {
-	return repo.runGitCommand("rev-list", "--skip", "1", "-n", "1", ref)
+	return repo.runGitCommand("rev-list", "--skip", "1", "-n", "1", endOfOptions, ref)
 }
 
 // GetCommitDetails returns the details of a commit's metadata.
@@ -174,7 +742,7 @@ func (repo GitRepo) GetCommitDetails(ref string) (*CommitDetails, error) {
 		if err != nil {
 			return ""
 		}
-		result, err = repo.runGitCommand("show", "-s", ref, fmt.Sprintf("--format=tformat:%s", formatString))
+		result, err = repo.runGitCommand("show", "-s", fmt.Sprintf("--format=tformat:%s", formatString), endOfOptions, ref)
 		return result
 	}
 
@@ -201,12 +769,26 @@ func (repo GitRepo) GetCommitDetails(ref string) (*CommitDetails, error) {
 
 // MergeBase determines if the first commit that is an ancestor of the two arguments.
 func (repo *GitRepo) MergeBase(a, b string) (string, error) {
-	return repo.runGitCommand("merge-base", a, b)
+	return repo.runGitCommand("merge-base", endOfOptions, a, b)
 }
 
 // IsAncestor determines if the first argument points to a commit that is an ancestor of the second.
+//
+// In a shallow clone, a negative answer is only given if the full history of the descendant is present.
 func (repo *GitRepo) IsAncestor(ancestor, descendant string) (bool, error) {
-	_, err := repo.runGitCommand("merge-base", "--is-ancestor", ancestor, descendant)
+	isAncestor, err := repo.isAncestor(ancestor, descendant)
+	if err != nil || isAncestor {
+		return isAncestor, err
+	}
+	if err := repo.requireHistory("determine commit ancestry", descendant); err != nil {
+		return false, err
+	}
+	return repo.isAncestor(ancestor, descendant)
+}
+
+// isAncestor implements IsAncestor, without regard for any history missing from a shallow clone.
+func (repo *GitRepo) isAncestor(ancestor, descendant string) (bool, error) {
+	_, err := repo.runGitCommand("merge-base", "--is-ancestor", endOfOptions, ancestor, descendant)
 	if err == nil {
 		return true, nil
 	}
@@ -216,24 +798,96 @@ func (repo *GitRepo) IsAncestor(ancestor, descendant string) (bool, error) {
 	return false, fmt.Errorf("Error while trying to determine commit ancestry: %v", err)
 }
 
+// FindAncestors determines which of the given commits are ancestors of the given descendant.
+//
+// This lists the entire history of the descendant once, rather than running a separate
+// "git merge-base" for each of the commits.
+func (repo *GitRepo) FindAncestors(commits []string, descendant string) (map[string]bool, error) {
+	ancestors := make(map[string]bool)
+	if len(commits) == 0 {
+		return ancestors, nil
+	}
+	// Like IsAncestor, commits are only reported as non-ancestors if the history is present.
+	if err := repo.requireHistory("determine commit ancestry", descendant); err != nil {
+		return nil, err
+	}
+	out, err := repo.runGitCommand("rev-list", endOfOptions, descendant, "--")
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			// Like IsAncestor, an unknown descendant has no ancestors.
+			return ancestors, nil
+		}
+		return nil, fmt.Errorf("Error while trying to determine commit ancestry: %v", err)
+	}
+	history := make(map[string]bool)
+	for _, commit := range splitLines(out) {
+		history[commit] = true
+	}
+	for _, commit := range commits {
+		if history[commit] {
+			ancestors[commit] = true
+		}
+	}
+	return ancestors, nil
+}
+
 // Diff computes the diff between two given commits.
 func (repo *GitRepo) Diff(left, right string, diffArgs ...string) (string, error) {
 	args := []string{"diff"}
 	args = append(args, diffArgs...)
-	args = append(args, fmt.Sprintf("%s..%s", left, right))
+	args = append(args, endOfOptions, fmt.Sprintf("%s..%s", left, right))
 	return repo.runGitCommand(args...)
 }
 
 // Show returns the contents of the given file at the given commit.
 func (repo *GitRepo) Show(commit, path string) (string, error) {
-	return repo.runGitCommand("show", fmt.Sprintf("%s:%s", commit, path))
+	return repo.runGitCommand("show", endOfOptions, fmt.Sprintf("%s:%s", commit, path))
+}
+
+// getOtherWorktreeForBranch returns the path of a worktree, other than the current one,
+// that has the given branch checked out, or the empty string if there is none.
+func (repo *GitRepo) getOtherWorktreeForBranch(branchRef string) (string, error) {
+	currentWorktree, err := repo.runGitCommand("rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	out, err := repo.runGitCommand("worktree", "list", "--porcelain")
+	if err != nil {
+		return "", err
+	}
+	var worktree string
+	for _, line := range splitLines(out) {
+		if strings.HasPrefix(line, "worktree ") {
+			worktree = strings.TrimPrefix(line, "worktree ")
+		} else if line == "branch "+branchRef && filepath.Clean(worktree) != filepath.Clean(currentWorktree) {
+			return worktree, nil
+		}
+	}
+	return "", nil
+}
+
+// IsSubmodule returns whether or not the given path is a submodule at the given commit.
+func (repo *GitRepo) IsSubmodule(commit, path string) (bool, error) {
+	out, err := repo.runGitCommand("ls-tree", endOfOptions, commit, "--", path)
+	if err != nil {
+		return false, err
+	}
+	// Submodules are recorded in the tree as "gitlink" entries, with the mode 160000.
+	return strings.HasPrefix(out, "160000 commit "), nil
 }
 
-// SwitchToRef changes the currently-checked-out ref.
+// SwitchToRef changes the ref checked out in the current worktree.
 func (repo *GitRepo) SwitchToRef(ref string) error {
 	// If the ref starts with "refs/heads/", then we have to trim that prefix,
 	// or else we will wind up in a detached HEAD state.
 	if strings.HasPrefix(ref, branchRefPrefix) {
+		worktree, err := repo.getOtherWorktreeForBranch(ref)
+		if err != nil {
+			return err
+		}
+		if worktree != "" {
+			return fmt.Errorf("The ref %q is already checked out in the worktree at %q; run this from that worktree instead.", ref, worktree)
+		}
 		ref = ref[len(branchRefPrefix):]
 	}
 	_, err := repo.runGitCommand("checkout", ref)
@@ -246,128 +900,1018 @@ func (repo *GitRepo) SwitchToRef(ref string) error {
 // current ref should only move forward, as opposed to creating a bubble merge.
 // The messages argument(s) provide text that should be included in the default
 // merge commit message (separated by blank lines).
-func (repo *GitRepo) MergeRef(ref string, fastForward bool, messages ...string) error {
+//
+// If sign is true, then any commit created is signed, as if the "commit.gpgsign"
+// git config setting were enabled; otherwise that setting is honored.
+func (repo *GitRepo) MergeRef(ref string, fastForward, sign bool, messages ...string) error {
 	args := []string{"merge"}
 	if fastForward {
 		args = append(args, "--ff", "--ff-only")
 	} else {
 		args = append(args, "--no-ff")
 	}
+	if sign {
+		args = append(args, "-S")
+	}
 	if len(messages) > 0 {
 		commitMessage := strings.Join(messages, "\n\n")
 		args = append(args, "-e", "-m", commitMessage)
 	}
 	args = append(args, ref)
+	if fastForward {
+		// Check first, since otherwise the only sign of this is git's own message.
+		if isAncestor, err := repo.IsAncestor("HEAD", ref); err == nil && !isAncestor {
+			return ErrNotFastForward
+		}
+	}
 	return repo.runGitCommandInline(args...)
 }
 
 // RebaseRef rebases the given ref into the current one.
-func (repo *GitRepo) RebaseRef(ref string) error {
+//
+// If sign is true, then the rebased commits are signed.
+func (repo *GitRepo) RebaseRef(ref string, sign bool) error {
+	if sign {
+		return repo.runGitCommandInline("rebase", "-i", "-S", ref)
+	}
 	return repo.runGitCommandInline("rebase", "-i", ref)
 }
 
-// ListCommitsBetween returns the list of commits between the two given revisions.
-//
-// The "from" parameter is the starting point (exclusive), and the "to" parameter
-// is the ending point (inclusive). If the commit pointed to by the "from" parameter
+// AbortMerge abandons a merge, rebase, or squash (by MergeRef, RebaseRef, or SquashRef)
+// that failed partway, restoring the current ref and the working tree to how they were
+// before it started.
+func (repo *GitRepo) AbortMerge() error {
+	rebasing, err := repo.isRebasing()
+	if err != nil {
+		return err
+	}
+	if rebasing {
+		_, err := repo.runGitCommand("rebase", "--abort")
+		return err
+	}
+	// Unlike "merge --abort", this also undoes a squash, which leaves no MERGE_HEAD behind.
+	_, err = repo.runGitCommand("reset", "--merge")
+	return err
+}
+
+// gitPathExists returns whether the given path within the git directory (such as "MERGE_HEAD") exists.
+func (repo *GitRepo) gitPathExists(name string) (bool, error) {
+	path, err := repo.runGitCommand("rev-parse", "--git-path", name)
+	if err != nil {
+		return false, err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(repo.Path, path)
+	}
+	_, err = os.Stat(path)
+	return err == nil, nil
+}
+
+// isRebasing returns whether a rebase has been started but not yet finished.
+func (repo *GitRepo) isRebasing() (bool, error) {
+	for _, rebaseDir := range []string{"rebase-merge", "rebase-apply"} {
+		if exists, err := repo.gitPathExists(rebaseDir); err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+// MergeInProgress returns the kind of merge ("merge" or "rebase") that was started in
+// the working tree but not yet finished, or "" if there is none, along with the files
+// that still have unresolved conflicts.
+func (repo *GitRepo) MergeInProgress() (string, []string, error) {
+	if bare, err := repo.IsBare(); err != nil || bare {
+		return "", nil, err
+	}
+	out, err := repo.runGitCommand("diff", "--name-only", "--diff-filter=U", "-z")
+	if err != nil {
+		return "", nil, err
+	}
+	var conflicts []string
+	for _, path := range strings.Split(out, "\x00") {
+		if path != "" {
+			conflicts = append(conflicts, path)
+		}
+	}
+	if rebasing, err := repo.isRebasing(); err != nil {
+		return "", nil, err
+	} else if rebasing {
+		return "rebase", conflicts, nil
+	}
+	// A squash that conflicted leaves no MERGE_HEAD behind, only the conflicts themselves.
+	if merging, err := repo.gitPathExists("MERGE_HEAD"); err != nil {
+		return "", nil, err
+	} else if merging || len(conflicts) > 0 {
+		return "merge", conflicts, nil
+	}
+	return "", nil, nil
+}
+
+// SquashRef squashes the changes in the given ref into a single new commit on the current one.
+//
+// The messages argument(s) provide the commit message (separated by blank lines).
+// If sign is true, then the new commit is signed.
+func (repo *GitRepo) SquashRef(ref string, sign bool, messages ...string) error {
+	if err := repo.runGitCommandInline("merge", "--squash", ref); err != nil {
+		return err
+	}
+	args := []string{"commit"}
+	if sign {
+		args = append(args, "-S")
+	}
+	if len(messages) > 0 {
+		args = append(args, "-e", "-m", strings.Join(messages, "\n\n"))
+	}
+	return repo.runGitCommandInline(args...)
+}
+
+// CommitMerge commits a merge or squash (by MergeRef or SquashRef) that stopped
+// partway because of conflicts, once those have been resolved.
+//
+// The messages argument(s) provide the commit message (separated by blank lines).
+// If sign is true, then the new commit is signed.
+func (repo *GitRepo) CommitMerge(sign bool, messages ...string) error {
+	args := []string{"commit"}
+	if sign {
+		args = append(args, "-S")
+	}
+	if len(messages) > 0 {
+		args = append(args, "-e", "-m", strings.Join(messages, "\n\n"))
+	} else {
+		args = append(args, "--no-edit")
+	}
+	return repo.runGitCommandInline(args...)
+}
+
+// ListCommitsBetween returns the list of commits between the two given revisions.
+//
+// The "from" parameter is the starting point (exclusive), and the "to" parameter
+// is the ending point (inclusive). If the commit pointed to by the "from" parameter
 // is not an ancestor of the commit pointed to by the "to" parameter, then the
 // merge base of the two is used as the starting point.
 //
 // The generated list is in chronological order (with the oldest commit first).
 func (repo *GitRepo) ListCommitsBetween(from, to string) ([]string, error) {
-	out, err := repo.runGitCommand("rev-list", "--reverse", "--ancestry-path", from+".."+to)
+	out, err := repo.runGitCommand("rev-list", "--reverse", "--ancestry-path", endOfOptions, from+".."+to)
+	if err != nil {
+		// In a shallow clone, the starting point may be missing, along with the commits after it.
+		if historyErr := repo.requireHistory("list the commits of the review", from, to); historyErr != nil {
+			return nil, historyErr
+		}
+		out, err = repo.runGitCommand("rev-list", "--reverse", "--ancestry-path", endOfOptions, from+".."+to)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		return nil, nil
+	}
+	return splitLines(out), nil
+}
+
+// ListCommits returns the details of the commits between the two given revisions, which
+// are the same commits, in the same order, as those returned by ListCommitsBetween.
+//
+// Unlike calling GetCommitDetails for each commit, this runs a single git command.
+func (repo *GitRepo) ListCommits(from, to string) ([]Commit, error) {
+	args := []string{"log", "--reverse", "--ancestry-path", "--format=tformat:%H%x00%T%x00%at%x00%an%x00%ae%x00%P%x00%s", endOfOptions, from + ".." + to}
+	out, err := repo.runGitCommand(args...)
+	if err != nil {
+		if historyErr := repo.requireHistory("list the commits of the review", from, to); historyErr != nil {
+			return nil, historyErr
+		}
+		out, err = repo.runGitCommand(args...)
+	}
 	if err != nil {
 		return nil, err
 	}
 	if out == "" {
 		return nil, nil
 	}
-	return strings.Split(out, "\n"), nil
+	var commits []Commit
+	for _, line := range splitLines(out) {
+		fields := strings.SplitN(line, "\x00", 7)
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("Unexpected output from git log: %q", line)
+		}
+		commits = append(commits, Commit{
+			Hash: fields[0],
+			CommitDetails: CommitDetails{
+				Tree:        fields[1],
+				Time:        fields[2],
+				Author:      fields[3],
+				AuthorEmail: fields[4],
+				Parents:     strings.Fields(fields[5]),
+				Summary:     fields[6],
+			},
+		})
+	}
+	return commits, nil
+}
+
+// Placeholders that "git format-patch --cover-letter" leaves for the subject and body of the cover letter.
+const (
+	coverLetterSubjectPlaceholder = "*** SUBJECT HERE ***"
+	coverLetterBodyPlaceholder    = "*** BLURB HERE ***"
+)
+
+// fillCoverLetter replaces the placeholders in the cover letter at the given path with the
+// first line of the given text, and the rest of it.
+func fillCoverLetter(path, text string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	subject, body := text, ""
+	if i := strings.Index(text, "\n"); i >= 0 {
+		subject, body = text[:i], strings.TrimSpace(text[i+1:])
+	}
+	filled := strings.Replace(string(contents), coverLetterSubjectPlaceholder, subject, 1)
+	filled = strings.Replace(filled, coverLetterBodyPlaceholder, body, 1)
+	return ioutil.WriteFile(path, []byte(filled), 0644)
+}
+
+// FormatPatch writes the commits between the two given revisions to the given directory
+// as a series of email patches, and returns the paths of the written files in order.
+//
+// The trailers are added to the patches with "git interpret-trailers", which only rewrites
+// the commit messages at the start of each patch.
+func (repo *GitRepo) FormatPatch(from, to, outputDir string, trailers []string, coverLetter string) ([]string, error) {
+	args := []string{"format-patch", "-o", outputDir}
+	if coverLetter != "" {
+		args = append(args, "--cover-letter")
+	}
+	out, err := repo.runGitCommand(append(args, from+".."+to)...)
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		return nil, nil
+	}
+	paths := splitLines(out)
+	patches := paths
+	if coverLetter != "" {
+		if err := fillCoverLetter(paths[0], coverLetter); err != nil {
+			return nil, err
+		}
+		patches = paths[1:]
+	}
+	if len(trailers) > 0 && len(patches) > 0 {
+		args := []string{"interpret-trailers", "--in-place"}
+		for _, trailer := range trailers {
+			args = append(args, "--trailer", trailer)
+		}
+		if _, err := repo.runGitCommand(append(args, patches...)...); err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
 }
 
 // GetNotes uses the "git" command-line tool to read the notes from the given ref for a given revision.
 func (repo *GitRepo) GetNotes(notesRef, revision string) []Note {
 	var notes []Note
-	rawNotes, err := repo.runGitCommand("notes", "--ref", notesRef, "show", revision)
+	rawNotes, err := repo.runGitCommand("notes", "--ref", repo.namespaced(notesRef), "show", revision)
 	if err != nil {
 		// We just assume that this means there are no notes
 		return nil
 	}
-	for _, line := range strings.Split(rawNotes, "\n") {
+	for _, line := range splitLines(rawNotes) {
 		notes = append(notes, Note([]byte(line)))
 	}
 	return notes
 }
 
+// GetAllNotes reads all of the notes from the given ref, keyed by the annotated object.
+//
+// The note blobs are all read by a single "git cat-file" process, rather than running
+// a separate "git notes show" for each annotated object.
+func (repo *GitRepo) GetAllNotes(notesRef string) (map[string][]Note, error) {
+	blobs, err := repo.ListNotes(notesRef)
+	if err != nil {
+		return nil, err
+	}
+	var blobHashes []string
+	for _, blob := range blobs {
+		blobHashes = append(blobHashes, blob)
+	}
+	contents, err := repo.readBlobs(blobHashes)
+	if err != nil {
+		return nil, err
+	}
+	notes := make(map[string][]Note)
+	for object, blob := range blobs {
+		blobContents, ok := contents[blob]
+		if !ok {
+			continue
+		}
+		// This matches the splitting done in GetNotes.
+		for _, line := range splitLines(strings.Trim(blobContents, "\r\n")) {
+			notes[object] = append(notes[object], Note(line))
+		}
+	}
+	return notes, nil
+}
+
+// lockNotes acquires the lock that serializes writes to notes refs by git-appraise,
+// and returns the function that releases it.
+func (repo *GitRepo) lockNotes() (func(), error) {
+	gitDir, err := repo.GetGitDir()
+	if err != nil {
+		return nil, err
+	}
+	return lockNotesDir(gitDir)
+}
+
+// lockNotesDir acquires the notes lock inside of the given git directory, and returns
+// the function that releases it.
+//
+// The lock is a plain file, so that it is shared by every implementation of Repo.
+func lockNotesDir(gitDir string) (func(), error) {
+	lockPath := filepath.Join(gitDir, notesLockFile)
+	deadline := time.Now().Add(notesLockTimeout)
+	for {
+		lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(lockFile, "%d\n", os.Getpid())
+			lockFile.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > staleNotesLockAge {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Timed out waiting for the lock %q; if no other git-appraise command is running, delete it and try again", lockPath)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// updateNotes applies the given update to the given notes ref, without losing any
+// concurrent updates made by other processes.
+//
+// Writes from git-appraise are serialized using a lock file. Since other tools may
+// write notes without taking that lock, the update is also performed against a private,
+// scratch copy of the notes ref, which is then swapped in only if the notes ref has not
+// moved in the meantime. If it has moved, then the update is re-applied on top of the
+// new notes, up to a bounded number of times.
+func (repo *GitRepo) updateNotes(notesRef string, update func(scratchRef string) error) error {
+	notesRef = repo.namespaced(notesRef)
+	unlock, err := repo.lockNotes()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	for attempt := 0; attempt < maxNotesUpdateAttempts; attempt++ {
+		// If the notes ref does not exist yet, then this is empty, which tells
+		// update-ref below that the ref must still not exist when it is created.
+		oldTip, _ := repo.runGitCommand("rev-parse", "-q", "--verify", notesRef)
+		scratchRef := fmt.Sprintf("%s%d-%d", notesScratchRefPrefix, os.Getpid(), atomic.AddUint64(&notesScratchRefCounter, 1))
+		if oldTip != "" {
+			if _, err := repo.runGitCommand("update-ref", scratchRef, oldTip); err != nil {
+				return err
+			}
+		}
+		err := update(scratchRef)
+		newTip, _ := repo.runGitCommand("rev-parse", "-q", "--verify", scratchRef)
+		repo.runGitCommand("update-ref", "-d", scratchRef)
+		if err != nil {
+			return err
+		}
+		if _, err := repo.runGitCommand("update-ref", notesRef, newTip, oldTip); err == nil {
+			return nil
+		}
+		// Someone else updated the notes ref while we were writing; back off and try again.
+		time.Sleep(time.Duration(rand.Intn(10*(attempt+1))) * time.Millisecond)
+	}
+	return fmt.Errorf("Failed to update the notes ref %q after %d attempts, due to concurrent updates", notesRef, maxNotesUpdateAttempts)
+}
+
 // AppendNote appends a note to a revision under the given ref.
 func (repo *GitRepo) AppendNote(notesRef, revision string, note Note) error {
-	_, err := repo.runGitCommand("notes", "--ref", notesRef, "append", "-m", string(note), revision)
-	return err
+	return repo.updateNotes(notesRef, func(scratchRef string) error {
+		_, err := repo.runGitCommand("notes", "--ref", scratchRef, "append", "-m", string(note), revision)
+		return err
+	})
+}
+
+// StoreBlob writes the given contents to a git blob and returns its hash.
+//
+// The blob is also recorded as a note (annotating itself) under the given notes
+// ref, so that it is transferred along with the notes when they are pushed or pulled.
+func (repo *GitRepo) StoreBlob(notesRef string, contents []byte) (string, error) {
+	hash, err := repo.runGitCommandWithStdin(contents, "hash-object", "-w", "--stdin")
+	if err != nil {
+		return "", err
+	}
+	err = repo.updateNotes(notesRef, func(scratchRef string) error {
+		_, err := repo.runGitCommand("notes", "--ref", scratchRef, "add", "-f", "-C", hash, hash)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hash, nil
 }
 
 // ListNotedRevisions returns the collection of revisions that are annotated by notes in the given ref.
 func (repo *GitRepo) ListNotedRevisions(notesRef string) []string {
-	var revisions []string
-	notesListOut, err := repo.runGitCommand("notes", "--ref", notesRef, "list")
+	notes, err := repo.ListNotes(notesRef)
 	if err != nil {
 		return nil
 	}
-	notesList := strings.Split(notesListOut, "\n")
-	for _, notePair := range notesList {
-		noteParts := strings.SplitN(notePair, " ", 2)
-		if len(noteParts) == 2 {
-			objHash := noteParts[1]
-			objType, err := repo.runGitCommand("cat-file", "-t", objHash)
-			// If a note points to an object that we do not know about (yet), then err will not
-			// be nil. We can safely just ignore those notes.
-			if err == nil && objType == "commit" {
-				revisions = append(revisions, objHash)
-			}
+	var objects []string
+	for object := range notes {
+		objects = append(objects, object)
+	}
+	sort.Strings(objects)
+	objectTypes, err := repo.getObjectTypes(objects)
+	if err != nil {
+		return nil
+	}
+	var revisions []string
+	for _, object := range objects {
+		// If a note points to an object that we do not know about (yet), then it has
+		// no type. We can safely just ignore those notes.
+		if objectTypes[object] == "commit" {
+			revisions = append(revisions, object)
 		}
 	}
 	return revisions
 }
 
-// PushNotes pushes git notes to a remote repo.
-func (repo *GitRepo) PushNotes(remote, notesRefPattern string) error {
-	refspec := fmt.Sprintf("%s:%s", notesRefPattern, notesRefPattern)
+// ListNotesRefs returns the sorted names of the notes refs matching the given pattern.
+func (repo *GitRepo) ListNotesRefs(refPattern string) ([]string, error) {
+	refs, err := repo.listLocalRefs(repo.namespaced(refPattern))
+	if err != nil {
+		return nil, err
+	}
+	var refNames []string
+	for ref := range refs {
+		refNames = append(refNames, ref)
+	}
+	sort.Strings(refNames)
+	return refNames, nil
+}
+
+// ListNotes returns the hash of the note blob for every object annotated in the given notes ref,
+// keyed by the hash of the annotated object.
+func (repo *GitRepo) ListNotes(notesRef string) (map[string]string, error) {
+	out, err := repo.runGitCommand("notes", "--ref", repo.namespaced(notesRef), "list")
+	if err != nil {
+		return nil, err
+	}
+	notes := make(map[string]string)
+	for _, line := range splitLines(out) {
+		lineParts := strings.Split(line, " ")
+		if len(lineParts) == 2 {
+			notes[lineParts[1]] = lineParts[0]
+		}
+	}
+	return notes, nil
+}
+
+// SetNotes replaces the notes annotating the given object under the given ref. If
+// there are no notes given, then the existing notes are removed.
+func (repo *GitRepo) SetNotes(notesRef, revision string, notes []Note) error {
+	var lines []string
+	for _, note := range notes {
+		lines = append(lines, string(note))
+	}
+	return repo.updateNotes(notesRef, func(scratchRef string) error {
+		var err error
+		if len(lines) == 0 {
+			_, err = repo.runGitCommand("notes", "--ref", scratchRef, "remove", "--ignore-missing", revision)
+		} else {
+			_, err = repo.runGitCommand("notes", "--ref", scratchRef, "add", "-f", "-m", strings.Join(lines, "\n"), revision)
+		}
+		return err
+	})
+}
+
+// CompactNotes rewrites every note under the given ref using the given function, in a
+// single new commit on the notes ref, and records the previous tip of the notes ref
+// under the given backup ref. Objects left without any notes are no longer annotated.
+//
+// If the function does not change any notes, then neither ref is updated.
+func (repo *GitRepo) CompactNotes(notesRef, backupRef string, compact func(object string, notes []Note) []Note) error {
+	return repo.updateNotes(notesRef, func(scratchRef string) error {
+		oldTip, _ := repo.runGitCommand("rev-parse", "-q", "--verify", scratchRef)
+		blobs, err := repo.listNotesTree(oldTip)
+		if err != nil {
+			return err
+		}
+		var objects []string
+		for object := range blobs {
+			objects = append(objects, object)
+		}
+		sort.Strings(objects)
+		changed := false
+		// The notes tree is written without any fan out, which git notes reads just the same.
+		var tree bytes.Buffer
+		for _, object := range objects {
+			contents, err := repo.runGitCommand("cat-file", "-p", blobs[object])
+			if err != nil {
+				return err
+			}
+			var notes []Note
+			for _, line := range splitLines(contents) {
+				notes = append(notes, Note(line))
+			}
+			var lines []string
+			for _, note := range compact(object, notes) {
+				lines = append(lines, string(note))
+			}
+			blob := blobs[object]
+			if compacted := strings.Join(lines, "\n"); compacted != contents {
+				changed = true
+				if len(lines) == 0 {
+					continue
+				}
+				blob, err = repo.runGitCommandWithStdin([]byte(compacted+"\n"), "hash-object", "-w", "--stdin")
+				if err != nil {
+					return err
+				}
+			}
+			fmt.Fprintf(&tree, "100644 blob %s\t%s\n", blob, object)
+		}
+		if !changed {
+			return nil
+		}
+		treeHash, err := repo.runGitCommandWithStdin(tree.Bytes(), "mktree")
+		if err != nil {
+			return err
+		}
+		newTip, err := repo.runGitCommand("commit-tree", treeHash, "-p", oldTip, "-m", "Notes compacted by 'git appraise gc'")
+		if err != nil {
+			return err
+		}
+		if _, err := repo.runGitCommand("update-ref", backupRef, oldTip); err != nil {
+			return err
+		}
+		_, err = repo.runGitCommand("update-ref", scratchRef, newTip)
+		return err
+	})
+}
+
+// InitNotesRef creates the given notes ref, with no notes, if it does not already exist.
+//
+// The returned boolean indicates whether or not the ref was created.
+func (repo *GitRepo) InitNotesRef(notesRef string) (bool, error) {
+	created := false
+	err := repo.updateNotes(notesRef, func(scratchRef string) error {
+		created = false
+		if _, err := repo.runGitCommand("rev-parse", "-q", "--verify", scratchRef); err == nil {
+			return nil
+		}
+		emptyTree, err := repo.runGitCommandWithStdin(nil, "mktree")
+		if err != nil {
+			return err
+		}
+		commit, err := repo.runGitCommand("commit-tree", emptyTree, "-m", "Notes initialized by 'git appraise init'")
+		if err != nil {
+			return err
+		}
+		created = true
+		_, err = repo.runGitCommand("update-ref", scratchRef, commit)
+		return err
+	})
+	return created, err
+}
+
+// ConfigureFetchRefSpecs adds the refspecs used by PullNotesAndArchive to the fetch
+// configuration of the given remote, so that the notes and archive refs are also
+// fetched by "git fetch". Refspecs that are already configured are not added again.
+//
+// The returned slice holds the refspecs that were added.
+func (repo *GitRepo) ConfigureFetchRefSpecs(remote, notesRefPattern, archiveRefPattern string) ([]string, error) {
+	key := "remote." + remote + ".fetch"
+	existing, err := repo.GetConfigValues(key)
+	if err != nil {
+		return nil, err
+	}
+	configured := make(map[string]bool)
+	for _, refSpec := range existing {
+		configured[refSpec] = true
+	}
+	var added []string
+	for _, refSpec := range getPullRefSpecs(remote, repo.namespaced(notesRefPattern), repo.namespaced(archiveRefPattern)) {
+		if configured[refSpec] {
+			continue
+		}
+		if _, err := repo.runGitCommand("config", "--add", key, refSpec); err != nil {
+			return added, err
+		}
+		added = append(added, refSpec)
+	}
+	return added, nil
+}
+
+// ListRefs returns the objects that the refs matching the given pattern point to, keyed by ref.
+func (repo *GitRepo) ListRefs(refPattern string) (map[string]string, error) {
+	return repo.listLocalRefs(refPattern)
+}
+
+// listLocalRefs returns the values of the local refs matching the given pattern.
+func (repo *GitRepo) listLocalRefs(refPattern string) (map[string]string, error) {
+	out, err := repo.runGitCommand("for-each-ref", "--format=%(refname) %(objectname)", refPattern)
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]string)
+	for _, line := range splitLines(out) {
+		lineParts := strings.Split(line, " ")
+		if len(lineParts) == 2 {
+			refs[lineParts[0]] = lineParts[1]
+		}
+	}
+	return refs, nil
+}
+
+// listRemoteRefs returns the values of the refs in the given remote matching the given pattern.
+func (repo *GitRepo) listRemoteRefs(remote, refPattern string) (map[string]string, error) {
+	out, err := repo.runGitCommand("ls-remote", remote, refPattern)
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]string)
+	for _, line := range splitLines(out) {
+		lineParts := strings.Split(line, "\t")
+		if len(lineParts) == 2 {
+			refs[lineParts[1]] = lineParts[0]
+		}
+	}
+	return refs, nil
+}
+
+// listNotesTree returns the blob IDs of the notes in the given notes commit, keyed by annotated object.
+func (repo *GitRepo) listNotesTree(notesCommit string) (map[string]string, error) {
+	notes := make(map[string]string)
+	if notesCommit == "" {
+		return notes, nil
+	}
+	out, err := repo.runGitCommand("ls-tree", "-r", notesCommit)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range splitLines(out) {
+		lineParts := strings.Split(line, "\t")
+		if len(lineParts) == 2 {
+			// Notes trees may fan out the annotated object names into subdirectories.
+			object := strings.Replace(lineParts[1], "/", "", -1)
+			entryParts := strings.Split(lineParts[0], " ")
+			notes[object] = entryParts[len(entryParts)-1]
+		}
+	}
+	return notes, nil
+}
+
+// GetNotesTip returns the commit that the given notes ref currently points to, or
+// the empty string if the ref does not exist.
+func (repo *GitRepo) GetNotesTip(notesRef string) (string, error) {
+	tip, err := repo.runGitCommand("rev-parse", "-q", "--verify", repo.namespaced(notesRef))
+	if err != nil {
+		// The ref does not exist (yet).
+		return "", nil
+	}
+	return tip, nil
+}
+
+// ListChangedNotes returns the sorted list of annotated objects whose notes differ
+// between two commits of a notes ref. Either commit may be the empty string, which
+// stands for there being no notes at all.
+func (repo *GitRepo) ListChangedNotes(leftCommit, rightCommit string) ([]string, error) {
+	leftNotes, err := repo.listNotesTree(leftCommit)
+	if err != nil {
+		return nil, err
+	}
+	rightNotes, err := repo.listNotesTree(rightCommit)
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	for object, blob := range leftNotes {
+		if rightNotes[object] != blob {
+			changed = append(changed, object)
+		}
+	}
+	for object := range rightNotes {
+		if _, ok := leftNotes[object]; !ok {
+			changed = append(changed, object)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// countDifferingNotes returns the number of annotated objects whose notes differ between two notes commits.
+func (repo *GitRepo) countDifferingNotes(leftCommit, rightCommit string) (int, error) {
+	changed, err := repo.ListChangedNotes(leftCommit, rightCommit)
+	return len(changed), err
+}
+
+// DiffRemoteRefs reports the refs matching the given patterns whose values differ
+// between the local repo and the given remote, without updating any local refs.
+func (repo *GitRepo) DiffRemoteRefs(remote string, refPatterns ...string) ([]RefDiff, error) {
+	var diffs []RefDiff
+	for _, refPattern := range refPatterns {
+		refPattern = repo.namespaced(refPattern)
+		localRefs, err := repo.listLocalRefs(refPattern)
+		if err != nil {
+			return nil, err
+		}
+		remoteRefs, err := repo.listRemoteRefs(remote, refPattern)
+		if err != nil {
+			return nil, err
+		}
+		allRefs := make(map[string]bool)
+		for ref := range localRefs {
+			allRefs[ref] = true
+		}
+		for ref := range remoteRefs {
+			allRefs[ref] = true
+		}
+		var refs []string
+		for ref := range allRefs {
+			refs = append(refs, ref)
+		}
+		sort.Strings(refs)
+		for _, ref := range refs {
+			diff := RefDiff{
+				Ref:          ref,
+				LocalCommit:  localRefs[ref],
+				RemoteCommit: remoteRefs[ref],
+			}
+			if diff.LocalCommit == diff.RemoteCommit {
+				continue
+			}
+			if strings.HasPrefix(ref, "refs/notes/") {
+				if diff.RemoteCommit != "" {
+					if _, err := repo.runGitCommand("cat-file", "-e", diff.RemoteCommit); err != nil {
+						// Fetch the remote objects without storing them under any ref.
+						if _, err := repo.runGitCommand("fetch", "--no-tags", remote, ref); err != nil {
+							return nil, err
+						}
+					}
+				}
+				diff.DifferingNotes, err = repo.countDifferingNotes(diff.LocalCommit, diff.RemoteCommit)
+				if err != nil {
+					return nil, err
+				}
+			}
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs, nil
+}
+
+// isPushRejection determines if the given stderr output of "git push" indicates
+// that the push was rejected due to the remote refs having diverged.
+func isPushRejection(stderr string) bool {
+	return strings.Contains(stderr, "[rejected]") ||
+		strings.Contains(stderr, "non-fast-forward") ||
+		strings.Contains(stderr, "fetch first")
+}
+
+// PushNotesAndArchive pushes the given notes and archive refs to a remote repo.
+//
+// If the remote rejects the push because its refs have diverged from the local
+// ones, then the returned error is a PushRejectedError.
+func (repo *GitRepo) PushNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	notesRefPattern = repo.namespaced(notesRefPattern)
+	archiveRefPattern = repo.namespaced(archiveRefPattern)
+	notesRefspec := fmt.Sprintf("%s:%s", notesRefPattern, notesRefPattern)
+	archiveRefspec := fmt.Sprintf("%s:%s", archiveRefPattern, archiveRefPattern)
+	localRefs, err := repo.listLocalRefs(notesRefPattern)
+	if err != nil {
+		return err
+	}
 
 	// The push is liable to fail if the user forgot to do a pull first, so
 	// we treat errors as user errors rather than fatal errors.
-	err := repo.runGitCommandInline("push", remote, refspec)
+	stderr, err := repo.runGitCommandInlineWithStderr("push", remote, notesRefspec, archiveRefspec)
 	if err != nil {
+		if isPushRejection(stderr) {
+			return PushRejectedError{Remote: remote}
+		}
 		return fmt.Errorf("Failed to push to the remote '%s': %v", remote, err)
 	}
+	return repo.updateRemoteNotesRefs(remote, localRefs)
+}
+
+// updateRemoteNotesRefs records that the given notes refs were pushed to the given remote
+// with the given values, by updating the corresponding remote-tracking notes refs.
+//
+// Those are what ForcePushNotesAndArchive expects the remote refs to be, so this keeps
+// a push from making the next forced push look like it would overwrite unknown data.
+func (repo *GitRepo) updateRemoteNotesRefs(remote string, refs map[string]string) error {
+	for ref, value := range refs {
+		if _, err := repo.runGitCommand("update-ref", getRemoteNotesRef(remote, ref), value); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// ForcePushNotesAndArchive pushes the given notes and archive refs to a remote repo, like
+// PushNotesAndArchive, except that the remote notes refs are replaced even if the local
+// ones do not descend from them (such as after rewriting the local notes).
+//
+// As a safety check, a remote notes ref is only replaced if it still has the value that was
+// last pulled from it. Otherwise, the returned error is a PushRejectedError.
+func (repo *GitRepo) ForcePushNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	notesRefPattern = repo.namespaced(notesRefPattern)
+	archiveRefPattern = repo.namespaced(archiveRefPattern)
+	localRefs, err := repo.listLocalRefs(notesRefPattern)
+	if err != nil {
+		return err
+	}
+	var refs []string
+	for ref := range localRefs {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	args := []string{"push"}
+	for _, ref := range refs {
+		// The lease is the value pulled into the remote-tracking notes ref,
+		// which is empty (meaning that the ref must not exist) if it was never pulled.
+		expected, err := repo.GetNotesTip(getRemoteNotesRef(remote, ref))
+		if err != nil {
+			return err
+		}
+		args = append(args, fmt.Sprintf("--force-with-lease=%s:%s", ref, expected))
+	}
+	args = append(args, remote)
+	for _, ref := range refs {
+		args = append(args, fmt.Sprintf("%s:%s", ref, ref))
+	}
+	args = append(args, fmt.Sprintf("%s:%s", archiveRefPattern, archiveRefPattern))
+	stderr, err := repo.runGitCommandInlineWithStderr(args...)
+	if err != nil {
+		if isPushRejection(stderr) {
+			return PushRejectedError{Remote: remote}
+		}
+		return fmt.Errorf("Failed to push to the remote '%s': %v", remote, err)
+	}
+	return repo.updateRemoteNotesRefs(remote, localRefs)
+}
+
+// Fetch updates the remote-tracking refs from the given remote repo.
+func (repo *GitRepo) Fetch(remote string) error {
+	return repo.runGitCommandInline("fetch", remote)
+}
+
+// AddWorktree checks out the given commit into a new, detached worktree at the given path.
+func (repo *GitRepo) AddWorktree(path, commit string) error {
+	_, err := repo.runGitCommand("worktree", "add", "--detach", path, commit)
+	return err
+}
+
+// RemoveWorktree removes a worktree previously created with AddWorktree.
+func (repo *GitRepo) RemoveWorktree(path string) error {
+	_, err := repo.runGitCommand("worktree", "remove", "--force", path)
+	return err
+}
+
 func getRemoteNotesRef(remote, localNotesRef string) string {
 	relativeNotesRef := strings.TrimPrefix(localNotesRef, "refs/notes/")
 	return "refs/notes/" + remote + "/" + relativeNotesRef
 }
 
-// PullNotes fetches the contents of the given notes ref from a remote repo,
-// and then merges them with the corresponding local notes using the
-// "cat_sort_uniq" strategy.
-func (repo *GitRepo) PullNotes(remote, notesRefPattern string) error {
-	remoteNotesRefPattern := getRemoteNotesRef(remote, notesRefPattern)
-	fetchRefSpec := fmt.Sprintf("+%s:%s", notesRefPattern, remoteNotesRefPattern)
-	err := repo.runGitCommandInline("fetch", remote, fetchRefSpec)
+// getPullRefSpecs returns the refspecs that fetch the given (already namespaced) notes and
+// archive refs from a remote repo: the notes into remote-tracking notes refs, from which they
+// are then merged, and the archives directly into the local archive refs.
+func getPullRefSpecs(remote, notesRefPattern, archiveRefPattern string) []string {
+	return []string{
+		fmt.Sprintf("+%s:%s", notesRefPattern, getRemoteNotesRef(remote, notesRefPattern)),
+		fmt.Sprintf("%s:%s", archiveRefPattern, archiveRefPattern),
+	}
+}
+
+// mergeNotesRef merges the notes in the given remote notes ref into the given local notes ref.
+//
+// Rather than using one of git's built-in notes merge strategies, this takes the union of
+// the notes for each annotated object (see unionNotes), which can never conflict.
+func (repo *GitRepo) mergeNotesRef(localRef, remoteRef string) error {
+	unlock, err := repo.lockNotes()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	remoteTip, err := repo.runGitCommand("rev-parse", "--verify", remoteRef)
 	if err != nil {
 		return err
 	}
+	localTip, err := repo.runGitCommand("rev-parse", "--verify", "-q", localRef)
+	if err != nil || localTip == "" {
+		_, err := repo.runGitCommand("update-ref", localRef, remoteTip, "")
+		return err
+	}
+	if localTip == remoteTip {
+		return nil
+	}
+	if isAncestor, err := repo.IsAncestor(remoteTip, localTip); err != nil || isAncestor {
+		return err
+	}
+	if isAncestor, err := repo.IsAncestor(localTip, remoteTip); err != nil {
+		return err
+	} else if isAncestor {
+		_, err := repo.runGitCommand("update-ref", localRef, remoteTip, localTip)
+		return err
+	}
+
+	localNotes, err := repo.listNotesTree(localTip)
+	if err != nil {
+		return err
+	}
+	remoteNotes, err := repo.listNotesTree(remoteTip)
+	if err != nil {
+		return err
+	}
+	mergedNotes := make(map[string]string)
+	for object, blob := range localNotes {
+		mergedNotes[object] = blob
+	}
+	for object, remoteBlob := range remoteNotes {
+		localBlob, ok := mergedNotes[object]
+		if !ok || localBlob == remoteBlob {
+			mergedNotes[object] = remoteBlob
+			continue
+		}
+		localContents, err := repo.runGitCommand("cat-file", "blob", localBlob)
+		if err != nil {
+			return err
+		}
+		remoteContents, err := repo.runGitCommand("cat-file", "blob", remoteBlob)
+		if err != nil {
+			return err
+		}
+		mergedContents := unionNotes(localContents, remoteContents) + "\n"
+		mergedBlob, err := repo.runGitCommandWithStdin([]byte(mergedContents), "hash-object", "-w", "--stdin")
+		if err != nil {
+			return err
+		}
+		mergedNotes[object] = mergedBlob
+	}
+
+	var objects []string
+	for object := range mergedNotes {
+		objects = append(objects, object)
+	}
+	sort.Strings(objects)
+	var treeEntries bytes.Buffer
+	for _, object := range objects {
+		fmt.Fprintf(&treeEntries, "100644 blob %s\t%s\n", mergedNotes[object], object)
+	}
+	tree, err := repo.runGitCommandWithStdin(treeEntries.Bytes(), "mktree")
+	if err != nil {
+		return err
+	}
+	mergeMessage := fmt.Sprintf("Merged notes from %s", remoteRef)
+	mergeCommit, err := repo.runGitCommand("commit-tree", tree, "-p", localTip, "-p", remoteTip, "-m", mergeMessage)
+	if err != nil {
+		return err
+	}
+	_, err = repo.runGitCommand("update-ref", localRef, mergeCommit, localTip)
+	return err
+}
+
+// PullNotesAndArchive fetches the contents of the given notes and archive refs
+// from a remote repo, and then merges the notes with the corresponding local notes
+// by taking the union of the notes for each annotated object.
+//
+// If a custom namespace is configured, but the remote also has review data in the
+// default namespace, then a warning is printed, since that data is not pulled.
+func (repo *GitRepo) PullNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	if repo.notesNamespace != "" && strings.HasPrefix(notesRefPattern, DefaultNotesNamespace) {
+		defaultRefs, err := repo.listRemoteRefs(remote, notesRefPattern)
+		if err == nil && len(defaultRefs) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: the remote %q has reviews under %q, which are ignored because %s is set to %q.\n",
+				remote, DefaultNotesNamespace, NamespaceConfigKey, repo.notesNamespace)
+		}
+	}
+	notesRefPattern = repo.namespaced(notesRefPattern)
+	archiveRefPattern = repo.namespaced(archiveRefPattern)
+	fetchArgs := append([]string{"fetch", "--no-tags", remote}, getPullRefSpecs(remote, notesRefPattern, archiveRefPattern)...)
+	if err := repo.runGitCommandInline(fetchArgs...); err != nil {
+		return err
+	}
 
 	remoteRefs, err := repo.runGitCommand("ls-remote", remote, notesRefPattern)
 	if err != nil {
 		return err
 	}
-	for _, line := range strings.Split(remoteRefs, "\n") {
+	for _, line := range splitLines(remoteRefs) {
 		lineParts := strings.Split(line, "\t")
 		if len(lineParts) == 2 {
 			ref := lineParts[1]
 			remoteRef := getRemoteNotesRef(remote, ref)
-			_, err := repo.runGitCommand("notes", "--ref", ref, "merge", remoteRef, "-s", "cat_sort_uniq")
-			if err != nil {
+			if err := repo.mergeNotesRef(ref, remoteRef); err != nil {
 				return err
 			}
 		}
@@ -0,0 +1,853 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestGitRepo creates a git repo in a temporary directory, with a single commit on master.
+//
+// The returned function removes the temporary directory.
+func newTestGitRepo(t testing.TB) (*GitRepo, func()) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("The git command line tool is not installed")
+	}
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	repo := &GitRepo{Path: dir}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"config", "user.email", "user@example.com"},
+		{"config", "user.name", "Test User"},
+		{"checkout", "-q", "-b", "master"},
+		{"commit", "-q", "--allow-empty", "-m", "First commit"},
+	} {
+		if _, err := repo.runGitCommand(args...); err != nil {
+			cleanup()
+			t.Fatalf("Failed to run git %v: %v", args, err)
+		}
+	}
+	return repo, cleanup
+}
+
+// appendNotesConcurrently appends the given number of distinct notes to the given
+// revision, all at once, and verifies that none of them were lost.
+func appendNotesConcurrently(t *testing.T, repo Repo, revision string, count int) {
+	var wg sync.WaitGroup
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- repo.AppendNote(TestCommentsRef, revision, Note(fmt.Sprintf(`{"description": "Comment %d"}`, i)))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	written := make(map[string]bool)
+	for _, note := range repo.GetNotes(TestCommentsRef, revision) {
+		written[string(note)] = true
+	}
+	for i := 0; i < count; i++ {
+		if !written[fmt.Sprintf(`{"description": "Comment %d"}`, i)] {
+			t.Fatalf("Comment %d was lost; wrote %d notes, but only read back %v", i, count, written)
+		}
+	}
+}
+
+func TestConcurrentNoteWritesToMockRepo(t *testing.T) {
+	appendNotesConcurrently(t, NewMockRepoForTest(), TestCommitG, 100)
+}
+
+func TestConcurrentNoteWritesToGitRepo(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	head, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendNotesConcurrently(t, repo, head, 20)
+	if refs, _ := repo.listLocalRefs(notesScratchRefPrefix); len(refs) != 0 {
+		t.Fatalf("Scratch notes refs were left behind: %v", refs)
+	}
+}
+
+func TestBareRepo(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	if bare, err := repo.IsBare(); err != nil || bare {
+		t.Fatalf("Unexpected result for a non-bare repo: %v, %v", bare, err)
+	}
+	bareRepo := &GitRepo{Path: repo.Path + "/bare.git"}
+	if _, err := repo.runGitCommand("clone", "-q", "--bare", repo.Path, bareRepo.Path); err != nil {
+		t.Fatal(err)
+	}
+	if bare, err := bareRepo.IsBare(); err != nil || !bare {
+		t.Fatalf("Unexpected result for a bare repo: %v, %v", bare, err)
+	}
+	if changes, err := bareRepo.HasUncommittedChanges(); err != nil || changes {
+		t.Fatalf("Unexpected uncommitted changes in a bare repo: %v, %v", changes, err)
+	}
+}
+
+func TestSubmoduleDiff(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	sub, subCleanup := newTestGitRepo(t)
+	defer subCleanup()
+	for _, args := range [][]string{
+		{"-c", "protocol.file.allow=always", "submodule", "add", "-q", sub.Path, "sub"},
+		{"commit", "-q", "-m", "Add a submodule"},
+	} {
+		if _, err := repo.runGitCommand(args...); err != nil {
+			t.Fatalf("Failed to run git %v: %v", args, err)
+		}
+	}
+	head, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isSubmodule, err := repo.IsSubmodule(head, "sub"); err != nil || !isSubmodule {
+		t.Fatalf("Failed to detect the submodule: %v, %v", isSubmodule, err)
+	}
+	if isSubmodule, err := repo.IsSubmodule(head, ".gitmodules"); err != nil || isSubmodule {
+		t.Fatalf("Unexpectedly detected a file as a submodule: %v, %v", isSubmodule, err)
+	}
+	checkout := &GitRepo{Path: filepath.Join(repo.Path, "sub")}
+	if _, err := checkout.runGitCommand("-c", "user.email=user@example.com", "-c", "user.name=Test User", "commit", "-q", "--allow-empty", "-m", "Second commit"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.runGitCommand("commit", "-q", "-a", "-m", "Bump the submodule"); err != nil {
+		t.Fatal(err)
+	}
+	diff, err := repo.Diff(head, "HEAD", "--submodule=log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, "Submodule sub ") || !strings.Contains(diff, "> Second commit") {
+		t.Fatalf("Unexpected submodule diff: %q", diff)
+	}
+	// Without asking for the summary, the diff can be applied as a patch.
+	if diff, err = repo.Diff(head, "HEAD"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, "+Subproject commit ") {
+		t.Fatalf("Unexpected plain submodule diff: %q", diff)
+	}
+}
+
+func TestCustomNamespace(t *testing.T) {
+	testRepo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	if _, err := testRepo.runGitCommand("config", NamespaceConfigKey, "devtools-frontend"); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := NewGitRepo(testRepo.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AppendNote(TestCommentsRef, head, Note(TestDiscussB)); err != nil {
+		t.Fatal(err)
+	}
+	if notes := repo.GetNotes(TestCommentsRef, head); len(notes) != 1 || string(notes[0]) != TestDiscussB {
+		t.Fatalf("Unexpected notes read back from a custom namespace: %q", notes)
+	}
+	if revisions := repo.ListNotedRevisions(TestCommentsRef); len(revisions) != 1 || revisions[0] != head {
+		t.Fatalf("Unexpected noted revisions in a custom namespace: %v", revisions)
+	}
+	if err := repo.VerifyGitRef("refs/notes/devtools-frontend/discuss"); err != nil {
+		t.Fatalf("The note was not written to the custom namespace: %v", err)
+	}
+	if err := repo.VerifyGitRef(TestCommentsRef); err == nil {
+		t.Fatal("The note was unexpectedly written to the default namespace")
+	}
+	if archive := repo.namespaced("refs/devtools/archives/reviews"); archive != "refs/devtools-frontend/archives/reviews" {
+		t.Fatalf("Unexpected archive ref in a custom namespace: %q", archive)
+	}
+}
+
+func TestCompactNotes(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	head, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := repo.AppendNote(TestCommentsRef, head, Note(TestDiscussB)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldTip, _ := repo.GetNotesTip(TestCommentsRef)
+	dedupe := func(object string, notes []Note) []Note {
+		return []Note{Note(TestDiscussB)}
+	}
+	if err := repo.CompactNotes(TestCommentsRef, "refs/test-backup", dedupe); err != nil {
+		t.Fatal(err)
+	}
+	if notes := repo.GetNotes(TestCommentsRef, head); len(notes) != 1 || string(notes[0]) != TestDiscussB {
+		t.Fatalf("Unexpected notes after compaction: %q", notes)
+	}
+	newTip, _ := repo.GetNotesTip(TestCommentsRef)
+	if parent, err := repo.runGitCommand("rev-parse", newTip+"^"); err != nil || parent != oldTip {
+		t.Fatalf("The compaction was not a single commit on top of %q: %q, %v", oldTip, parent, err)
+	}
+	if backup, err := repo.runGitCommand("rev-parse", "refs/test-backup"); err != nil || backup != oldTip {
+		t.Fatalf("Unexpected backup ref: %q, %v", backup, err)
+	}
+	if err := repo.CompactNotes(TestCommentsRef, "refs/test-backup", dedupe); err != nil {
+		t.Fatal(err)
+	}
+	if tip, _ := repo.GetNotesTip(TestCommentsRef); tip != newTip {
+		t.Fatal("An unchanged compaction created a new notes commit")
+	}
+}
+
+func TestBulkReads(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	first, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.runGitCommand("commit", "-q", "--allow-empty", "-m", "Second commit"); err != nil {
+		t.Fatal(err)
+	}
+	second, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, revision := range []string{first, second, first} {
+		if err := repo.AppendNote(TestRequestsRef, revision, Note(TestRequestB)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Blobs annotate themselves, so they are noted, but should not be listed as revisions.
+	blob, err := repo.StoreBlob(TestRequestsRef, []byte("Contents ending in blank lines\n\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allNotes, err := repo.GetAllNotes(TestRequestsRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allNotes) != 3 {
+		t.Fatalf("Unexpected notes: %q", allNotes)
+	}
+	for _, object := range []string{first, second, blob} {
+		if expected := repo.GetNotes(TestRequestsRef, object); fmt.Sprintf("%q", allNotes[object]) != fmt.Sprintf("%q", expected) {
+			t.Errorf("Unexpected notes for %q: got %q, expected %q", object, allNotes[object], expected)
+		}
+	}
+	if notes, err := repo.GetAllNotes(TestCommentsRef); err != nil || len(notes) != 0 {
+		t.Fatalf("Unexpected notes for a missing notes ref: %q, %v", notes, err)
+	}
+	if revisions := repo.ListNotedRevisions(TestRequestsRef); len(revisions) != 2 {
+		t.Fatalf("Unexpected noted revisions: %q", revisions)
+	}
+
+	ancestors, err := repo.FindAncestors([]string{first, second, blob}, first)
+	if err != nil || len(ancestors) != 1 || !ancestors[first] {
+		t.Fatalf("Unexpected ancestors of the first commit: %v, %v", ancestors, err)
+	}
+	ancestors, err = repo.FindAncestors([]string{first, second}, "refs/heads/master")
+	if err != nil || len(ancestors) != 2 {
+		t.Fatalf("Unexpected ancestors of master: %v, %v", ancestors, err)
+	}
+	if ancestors, err := repo.FindAncestors([]string{first}, "refs/heads/missing"); err != nil || len(ancestors) != 0 {
+		t.Fatalf("Unexpected ancestors of a missing ref: %v, %v", ancestors, err)
+	}
+}
+
+// benchmarkReviewCount is the number of reviews in the synthetic repository used by the benchmarks.
+const benchmarkReviewCount = 10000
+
+// newBenchmarkGitRepo creates a git repo with the given number of commits on master, each of
+// which has a review request and a comment. The commits are returned in order.
+//
+// The history and notes are written with "git fast-import", since creating them one command
+// at a time would take longer than the benchmarks themselves.
+func newBenchmarkGitRepo(b *testing.B, count int) (*GitRepo, []string, func()) {
+	repo, cleanup := newTestGitRepo(b)
+	var stream bytes.Buffer
+	writeData := func(data string) {
+		fmt.Fprintf(&stream, "data %d\n%s\n", len(data), data)
+	}
+	for i := 1; i <= count; i++ {
+		fmt.Fprintf(&stream, "commit refs/heads/master\nmark :%d\ncommitter Test User <user@example.com> %d +0000\n", i, 1000000000+i)
+		writeData(fmt.Sprintf("Commit %d", i))
+		if i == 1 {
+			stream.WriteString("from refs/heads/master^0\n")
+		}
+	}
+	for _, ref := range []string{TestRequestsRef, TestCommentsRef} {
+		fmt.Fprintf(&stream, "commit %s\ncommitter Test User <user@example.com> 1000000000 +0000\n", ref)
+		writeData("Synthetic notes")
+		for i := 1; i <= count; i++ {
+			fmt.Fprintf(&stream, "N inline :%d\n", i)
+			if ref == TestRequestsRef {
+				writeData(TestRequestB + "\n")
+			} else {
+				writeData(TestDiscussB + "\n")
+			}
+		}
+	}
+	if _, err := repo.runGitCommandWithStdin(stream.Bytes(), "fast-import", "--quiet", "--force"); err != nil {
+		cleanup()
+		b.Fatal(err)
+	}
+	out, err := repo.runGitCommand("rev-list", "--reverse", "refs/heads/master")
+	if err != nil {
+		cleanup()
+		b.Fatal(err)
+	}
+	// Skip the initial commit, which has no notes.
+	return repo, strings.Split(out, "\n")[1:], cleanup
+}
+
+// BenchmarkReviewData compares reading the data for every review one object at a time,
+// with reading it in bulk.
+func BenchmarkReviewData(b *testing.B) {
+	repo, commits, cleanup := newBenchmarkGitRepo(b, benchmarkReviewCount)
+	defer cleanup()
+	b.ResetTimer()
+	b.Run("NotesPerObject", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for _, commit := range commits {
+				if repo.VerifyCommit(commit) != nil {
+					b.Fatalf("Missing commit %q", commit)
+				}
+				for _, ref := range []string{TestRequestsRef, TestCommentsRef} {
+					if len(repo.GetNotes(ref, commit)) == 0 {
+						b.Fatalf("Missing notes for %q", commit)
+					}
+				}
+			}
+		}
+	})
+	b.Run("NotesInBulk", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			if revisions := repo.ListNotedRevisions(TestRequestsRef); len(revisions) != len(commits) {
+				b.Fatalf("Unexpected number of noted revisions: %d", len(revisions))
+			}
+			for _, ref := range []string{TestRequestsRef, TestCommentsRef} {
+				if notes, err := repo.GetAllNotes(ref); err != nil || len(notes) != len(commits) {
+					b.Fatalf("Unexpected number of notes: %d, %v", len(notes), err)
+				}
+			}
+		}
+	})
+	b.Run("AncestryPerObject", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for _, commit := range commits {
+				if isAncestor, err := repo.IsAncestor(commit, "refs/heads/master"); err != nil || !isAncestor {
+					b.Fatalf("Unexpected ancestry for %q: %v, %v", commit, isAncestor, err)
+				}
+			}
+		}
+	})
+	b.Run("AncestryInBulk", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			if ancestors, err := repo.FindAncestors(commits, "refs/heads/master"); err != nil || len(ancestors) != len(commits) {
+				b.Fatalf("Unexpected number of ancestors: %d, %v", len(ancestors), err)
+			}
+		}
+	})
+}
+
+func TestListCommits(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	for _, message := range []string{"Second commit", "Third commit\n\nWith a body"} {
+		if _, err := repo.runGitCommand("commit", "-q", "--allow-empty", "-m", message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hashes, err := repo.ListCommitsBetween("HEAD~2", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits, err := repo.ListCommits("HEAD~2", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != len(hashes) {
+		t.Fatalf("Unexpected commits: got %+v, want %q", commits, hashes)
+	}
+	for i, commit := range commits {
+		details, err := repo.GetCommitDetails(hashes[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := (Commit{Hash: hashes[i], CommitDetails: *details}); !reflect.DeepEqual(commit, expected) {
+			t.Errorf("Unexpected commit: got %+v, want %+v", commit, expected)
+		}
+	}
+	if commits, err := repo.ListCommits("HEAD", "HEAD"); err != nil || commits != nil {
+		t.Errorf("Unexpected commits of an empty range: %+v, %v", commits, err)
+	}
+}
+
+func TestInitialization(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	if _, err := repo.runGitCommand("remote", "add", "origin", repo.Path); err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		created, err := repo.InitNotesRef(TestRequestsRef)
+		if err != nil || created != (attempt == 0) {
+			t.Fatalf("Unexpected result from initializing the notes ref (attempt %d): %v, %v", attempt, created, err)
+		}
+		added, err := repo.ConfigureFetchRefSpecs("origin", "refs/notes/devtools/*", "refs/devtools/archives/*")
+		if err != nil || len(added) != 2*(1-attempt) {
+			t.Fatalf("Unexpected refspecs added (attempt %d): %q, %v", attempt, added, err)
+		}
+	}
+	if notes, err := repo.GetAllNotes(TestRequestsRef); err != nil || len(notes) != 0 {
+		t.Fatalf("Unexpected notes in an initialized notes ref: %q, %v", notes, err)
+	}
+	refSpecs, err := repo.GetConfigValues("remote.origin.fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"+refs/heads/*:refs/remotes/origin/*",
+		"+refs/notes/devtools/*:refs/notes/origin/devtools/*",
+		"refs/devtools/archives/*:refs/devtools/archives/*",
+	}
+	if fmt.Sprintf("%q", refSpecs) != fmt.Sprintf("%q", expected) {
+		t.Fatalf("Unexpected fetch refspecs: %q", refSpecs)
+	}
+	// The configured refspecs must not get in the way of pulling.
+	if err := repo.AppendNote(TestCommentsRef, "HEAD", Note(TestDiscussB)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.PullNotesAndArchive("origin", "refs/notes/devtools/*", "refs/devtools/archives/*"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	for out, expected := range map[string][]string{
+		"":                   {""},
+		"one":                {"one"},
+		"one\ntwo":           {"one", "two"},
+		"one\r\ntwo":         {"one", "two"},
+		"one\r\n\r\ntwo\r\n": {"one", "", "two", ""},
+		"carriage\rreturn":   {"carriage\rreturn"},
+	} {
+		if lines := splitLines(out); fmt.Sprintf("%q", lines) != fmt.Sprintf("%q", expected) {
+			t.Errorf("Unexpected lines for %q: got %q, expected %q", out, lines, expected)
+		}
+	}
+}
+
+func TestNotesWithCRLF(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	blob, err := repo.runGitCommandWithStdin([]byte(TestDiscussB+"\r\n\r\n"+TestDiscussD+"\r\n"), "hash-object", "-w", "--stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.runGitCommand("notes", "--ref", TestCommentsRef, "add", "-C", blob, "HEAD"); err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("%q", []Note{Note(TestDiscussB), Note(""), Note(TestDiscussD)})
+	if notes := repo.GetNotes(TestCommentsRef, head); fmt.Sprintf("%q", notes) != expected {
+		t.Fatalf("Unexpected notes: %q", notes)
+	}
+	allNotes, err := repo.GetAllNotes(TestCommentsRef)
+	if err != nil || fmt.Sprintf("%q", allNotes[head]) != expected {
+		t.Fatalf("Unexpected notes read in bulk: %q, %v", allNotes, err)
+	}
+}
+
+// testSquashRef squashes a branch with two commits onto master, using the given repo,
+// and verifies the resulting commit using the git command line tool.
+//
+// If the commit should be signed, then its signature is verified as well.
+func testSquashRef(t *testing.T, gitRepo *GitRepo, repo Repo, sign bool) {
+	// Accept the commit message without opening an editor.
+	defer os.Setenv("GIT_EDITOR", os.Getenv("GIT_EDITOR"))
+	os.Setenv("GIT_EDITOR", "true")
+	for _, args := range [][]string{
+		{"checkout", "-q", "-b", "squashed", "master"},
+		{"commit", "-q", "--allow-empty", "-m", "Squashed commit one"},
+		{"commit", "-q", "--allow-empty", "-m", "Squashed commit two"},
+		{"checkout", "-q", "master"},
+	} {
+		if _, err := gitRepo.runGitCommand(args...); err != nil {
+			t.Fatalf("Failed to run git %v: %v", args, err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(gitRepo.Path, "file.txt"), []byte("squashed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"checkout", "-q", "squashed"},
+		{"add", "file.txt"},
+		{"commit", "-q", "-m", "Squashed commit three"},
+		{"checkout", "-q", "master"},
+	} {
+		if _, err := gitRepo.runGitCommand(args...); err != nil {
+			t.Fatalf("Failed to run git %v: %v", args, err)
+		}
+	}
+	oldHead, err := gitRepo.runGitCommand("rev-parse", "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SquashRef("refs/heads/squashed", sign, "Submitting review", "Description"); err != nil {
+		t.Fatal(err)
+	}
+	if sign || IsConfigTrue(gitRepo, CommitSigningConfigKey) {
+		if out, err := gitRepo.runGitCommand("verify-commit", "master"); err != nil {
+			t.Fatalf("Failed to verify the signature of the squashed commit: %v\n%s", err, out)
+		}
+	} else if signature, err := gitRepo.runGitCommand("log", "-1", "--format=%GS", "master"); err != nil || signature != "" {
+		t.Fatalf("Unexpected signature of the squashed commit: %q, %v", signature, err)
+	}
+	if parents, err := gitRepo.runGitCommand("rev-parse", "master^@"); err != nil || parents != oldHead {
+		t.Fatalf("Unexpected parents of the squashed commit: %q, %v", parents, err)
+	}
+	if message, err := gitRepo.runGitCommand("log", "-1", "--format=%B", "master"); err != nil || message != "Submitting review\n\nDescription" {
+		t.Fatalf("Unexpected message of the squashed commit: %q, %v", message, err)
+	}
+	if diff, err := gitRepo.runGitCommand("diff", "master", "squashed"); err != nil || diff != "" {
+		t.Fatalf("Unexpected difference between the squashed commit and the ref: %q, %v", diff, err)
+	}
+	if status, err := gitRepo.runGitCommand("status", "--porcelain"); err != nil || status != "" {
+		t.Fatalf("Unexpected changes left in the worktree: %q, %v", status, err)
+	}
+}
+
+func TestSquashRef(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	testSquashRef(t, repo, repo, false)
+}
+
+func TestSignedSquashRef(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	configureSSHSigning(t, repo)
+	testSquashRef(t, repo, repo, true)
+}
+
+func TestSignedMergeRef(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	configureSSHSigning(t, repo)
+	defer os.Setenv("GIT_EDITOR", os.Getenv("GIT_EDITOR"))
+	os.Setenv("GIT_EDITOR", "true")
+	for _, args := range [][]string{
+		{"checkout", "-q", "-b", "merged", "master"},
+		{"commit", "-q", "--allow-empty", "-m", "Merged commit"},
+		{"checkout", "-q", "master"},
+	} {
+		if _, err := repo.runGitCommand(args...); err != nil {
+			t.Fatalf("Failed to run git %v: %v", args, err)
+		}
+	}
+	if err := repo.MergeRef("refs/heads/merged", false, true, "Submitting review"); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := repo.runGitCommand("verify-commit", "master"); err != nil {
+		t.Fatalf("Failed to verify the signature of the merge commit: %v\n%s", err, out)
+	}
+}
+
+// newTestShallowClone creates a shallow clone, with only the last commit, of a repo with three commits.
+//
+// The returned function removes both repositories.
+func TestMergeInProgress(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	if kind, conflicts, err := repo.MergeInProgress(); err != nil || kind != "" || conflicts != nil {
+		t.Fatalf("Unexpected merge in a clean repo: %q, %q, %v", kind, conflicts, err)
+	}
+	writeAndCommit := func(contents string) {
+		if err := ioutil.WriteFile(filepath.Join(repo.Path, "file.txt"), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, args := range [][]string{{"add", "file.txt"}, {"commit", "-q", "-m", contents}} {
+			if _, err := repo.runGitCommand(args...); err != nil {
+				t.Fatalf("Failed to run git %v: %v", args, err)
+			}
+		}
+	}
+	if _, err := repo.runGitCommand("checkout", "-q", "-b", "other"); err != nil {
+		t.Fatal(err)
+	}
+	writeAndCommit("other\n")
+	if _, err := repo.runGitCommand("checkout", "-q", "master"); err != nil {
+		t.Fatal(err)
+	}
+	writeAndCommit("master\n")
+	if _, err := repo.runGitCommand("merge", "other"); err == nil {
+		t.Fatal("Unexpectedly merged the conflicting change")
+	}
+	kind, conflicts, err := repo.MergeInProgress()
+	if err != nil || kind != "merge" || len(conflicts) != 1 || conflicts[0] != "file.txt" {
+		t.Fatalf("Unexpected merge in progress: %q, %q, %v", kind, conflicts, err)
+	}
+	if err := repo.AbortMerge(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.runGitCommand("rebase", "other"); err == nil {
+		t.Fatal("Unexpectedly rebased onto the conflicting change")
+	}
+	kind, conflicts, err = repo.MergeInProgress()
+	if err != nil || kind != "rebase" || len(conflicts) != 1 {
+		t.Fatalf("Unexpected rebase in progress: %q, %q, %v", kind, conflicts, err)
+	}
+	if err := repo.AbortMerge(); err != nil {
+		t.Fatal(err)
+	}
+	if kind, _, err := repo.MergeInProgress(); err != nil || kind != "" {
+		t.Fatalf("The aborted rebase is still in progress: %q, %v", kind, err)
+	}
+}
+
+func newTestShallowClone(t *testing.T) (*GitRepo, *GitRepo, func()) {
+	repo, cleanup := newTestGitRepo(t)
+	for _, args := range [][]string{
+		{"commit", "-q", "--allow-empty", "-m", "Second commit"},
+		{"commit", "-q", "--allow-empty", "-m", "Third commit"},
+	} {
+		if _, err := repo.runGitCommand(args...); err != nil {
+			cleanup()
+			t.Fatalf("Failed to run git %v: %v", args, err)
+		}
+	}
+	clone := &GitRepo{Path: repo.Path + "-shallow"}
+	if _, err := repo.runGitCommand("clone", "-q", "--depth", "1", "file://"+filepath.ToSlash(repo.Path), clone.Path); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return repo, clone, func() {
+		os.RemoveAll(clone.Path)
+		cleanup()
+	}
+}
+
+func TestShallowClone(t *testing.T) {
+	repo, clone, cleanup := newTestShallowClone(t)
+	defer cleanup()
+	first, err := repo.runGitCommand("rev-list", "--max-parents=0", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shallow, err := repo.IsShallow(); err != nil || shallow {
+		t.Fatalf("Unexpectedly detected a shallow clone: %v, %v", shallow, err)
+	}
+	if shallow, err := clone.IsShallow(); err != nil || !shallow {
+		t.Fatalf("Failed to detect a shallow clone: %v, %v", shallow, err)
+	}
+	// Without a remote, the missing history cannot be fetched.
+	if _, err := clone.runGitCommand("remote", "rename", "origin", "upstream"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clone.runGitCommand("remote", "add", "other", repo.Path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clone.IsAncestor(first, "HEAD"); err == nil {
+		t.Fatal("Unexpectedly determined ancestry with missing history")
+	} else if _, ok := err.(ShallowRepoError); !ok {
+		t.Fatalf("Unexpected error for missing history: %v", err)
+	}
+	if _, err := clone.ListCommitsBetween(first, "HEAD"); err == nil {
+		t.Fatal("Unexpectedly listed commits with missing history")
+	} else if _, ok := err.(ShallowRepoError); !ok {
+		t.Fatalf("Unexpected error for missing history: %v", err)
+	}
+	// With a remote, the missing history is fetched on demand.
+	clone = &GitRepo{Path: clone.Path}
+	if _, err := clone.runGitCommand("remote", "remove", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if isAncestor, err := clone.IsAncestor(first, "refs/heads/master"); err != nil || !isAncestor {
+		t.Fatalf("Unexpected ancestry after fetching the missing history: %v, %v", isAncestor, err)
+	}
+	if commits, err := clone.ListCommitsBetween(first, "HEAD"); err != nil || len(commits) != 2 {
+		t.Fatalf("Unexpected commits after fetching the missing history: %q, %v", commits, err)
+	}
+	if shallow, err := clone.IsShallow(); err != nil || shallow {
+		t.Fatalf("The shallow clone was not deepened: %v, %v", shallow, err)
+	}
+}
+
+func TestForcePushNotesAndArchive(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	remote := &GitRepo{Path: repo.Path + "-remote.git"}
+	defer os.RemoveAll(remote.Path)
+	if _, err := repo.runGitCommand("clone", "-q", "--bare", repo.Path, remote.Path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.runGitCommand("remote", "add", "origin", remote.Path); err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const notesRef = "refs/notes/devtools/discuss"
+	const notesPattern = "refs/notes/devtools/*"
+	const archivePattern = "refs/devtools/archives/*"
+	if err := repo.AppendNote(notesRef, head, Note("First")); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.PushNotesAndArchive("origin", notesPattern, archivePattern); err != nil {
+		t.Fatal(err)
+	}
+	pushed, err := repo.GetCommitHash(notesRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace the local notes with unrelated history, which a regular push must refuse to publish.
+	rewriteNotes := func(contents string) string {
+		if _, err := repo.runGitCommand("update-ref", "-d", notesRef); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.AppendNote(notesRef, head, Note(contents)); err != nil {
+			t.Fatal(err)
+		}
+		rewritten, err := repo.GetCommitHash(notesRef)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rewritten
+	}
+	rewritten := rewriteNotes("Second")
+	if err := repo.PushNotesAndArchive("origin", notesPattern, archivePattern); err == nil {
+		t.Fatal("Unexpectedly overwrote the remote notes without forcing")
+	} else if _, ok := err.(PushRejectedError); !ok {
+		t.Fatalf("Unexpected error for a non-fast-forward push: %v", err)
+	}
+	if err := repo.ForcePushNotesAndArchive("origin", notesPattern, archivePattern); err != nil {
+		t.Fatalf("Failed to force push over the notes that were last pushed: %v", err)
+	}
+	if value, err := remote.GetCommitHash(notesRef); err != nil || value != rewritten {
+		t.Fatalf("Unexpected remote notes after a forced push: %q, %v", value, err)
+	}
+
+	// Once the remote has changed in a way that was never pulled, even a forced push is refused.
+	if _, err := remote.runGitCommand("update-ref", notesRef, pushed); err != nil {
+		t.Fatal(err)
+	}
+	rewriteNotes("Third")
+	if err := repo.ForcePushNotesAndArchive("origin", notesPattern, archivePattern); err == nil {
+		t.Fatal("Unexpectedly overwrote remote notes that were never pulled")
+	} else if _, ok := err.(PushRejectedError); !ok {
+		t.Fatalf("Unexpected error for a stale lease: %v", err)
+	}
+	if value, err := remote.GetCommitHash(notesRef); err != nil || value != pushed {
+		t.Fatalf("The remote notes were modified by a rejected push: %q, %v", value, err)
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	// The shell is replaced by sleep, which does not hold on to the command's output, so
+	// the command finishes as soon as git itself is killed.
+	if _, err := repo.runGitCommand("config", "alias.hang", "!exec sleep 5 >/dev/null 2>&1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := repo.WithContext(ctx).(*GitRepo).runGitCommand("hang")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Unexpected error from a command that timed out: %v", err)
+	}
+	if expected := "Timed out running git hang"; err.Error() != expected {
+		t.Fatalf("Unexpected error message: got %q, want %q", err.Error(), expected)
+	}
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Fatalf("The command was not killed when it timed out; it took %v", elapsed)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := repo.WithContext(canceled).GetCommitHash("HEAD"); !errors.Is(err, context.Canceled) ||
+		!strings.HasPrefix(err.Error(), "Interrupted while running git show") {
+		t.Fatalf("Unexpected error from an interrupted command: %v", err)
+	}
+	// The original repo is unaffected by the contexts of its copies.
+	if _, err := repo.GetCommitHash("HEAD"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevisionsAreNotOptions(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	output := filepath.Join(t.TempDir(), "leaked")
+	if _, err := repo.Diff("--output="+output, "HEAD"); err == nil {
+		t.Error("Unexpectedly diffed against an option")
+	}
+	if _, err := repo.Show("--output="+output, "path"); err == nil {
+		t.Error("Unexpectedly showed a file of an option")
+	}
+	if _, err := repo.MergeBase("--output="+output, "HEAD"); err == nil {
+		t.Error("Unexpectedly found the merge base of an option")
+	}
+	if _, err := repo.GetCommitDetails("--output=" + output); err == nil {
+		t.Error("Unexpectedly read the details of an option")
+	}
+	if _, err := repo.ListCommits("--output="+output, "HEAD"); err == nil {
+		t.Error("Unexpectedly listed the commits after an option")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("Git wrote to %s: %v", output, err)
+	}
+}
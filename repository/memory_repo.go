@@ -0,0 +1,1830 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryEpoch is the time of the first commit created in a MemoryRepo. Each later commit
+// is a second newer, so that commits are ordered by when they were created, as in git.
+const memoryEpoch = 1500000000
+
+// memoryCommit is a commit of a MemoryRepo, holding the full contents of its tree.
+type memoryCommit struct {
+	Message     string            `json:"message"`
+	Time        int64             `json:"time"`
+	Author      string            `json:"author"`
+	AuthorEmail string            `json:"authorEmail"`
+	Parents     []string          `json:"parents,omitempty"`
+	Files       map[string]string `json:"files,omitempty"`
+}
+
+// memoryNotesCommit is a commit of a notes ref in a MemoryRepo, holding the contents
+// of the note blob for every annotated object.
+type memoryNotesCommit struct {
+	Time    int64             `json:"time"`
+	Parents []string          `json:"parents,omitempty"`
+	Notes   map[string]string `json:"notes,omitempty"`
+}
+
+// memoryRepoState is the state of a MemoryRepo, which is shared by the copies of it
+// returned by WithContext.
+type memoryRepoState struct {
+	mu           sync.Mutex
+	path         string
+	head         string
+	refs         map[string]string
+	commits      map[string]memoryCommit
+	notesCommits map[string]memoryNotesCommit
+	config       map[string][]string
+	remotes      map[string]*MemoryRepo
+	clock        int64
+}
+
+// MemoryRepo is an implementation of Repo that keeps its commits, refs, and notes in memory,
+// for tests that need them to behave like those of a real git repo.
+//
+// Unlike the repo returned by NewMockRepoForTest, commits have parents and file contents,
+// HEAD and the refs are updated by SwitchToRef, MergeRef, RebaseRef, and SquashRef, and
+// every change to a notes ref is recorded as a new commit of it. The conformance tests
+// in memory_repo_test.go run the same scenarios against a GitRepo to keep the two in sync.
+//
+// A MemoryRepo never has uncommitted changes, and its diffs have a single hunk per file.
+type MemoryRepo struct {
+	*memoryRepoState
+	ctx context.Context
+}
+
+// NewRepoWithHistory returns a MemoryRepo holding the given branches, each of which is given
+// as the names of its commits, oldest first. Branches that start with the same commits share
+// them, so {"master": {"A", "B"}, "feature": {"A", "C"}} creates a feature branch that forks
+// from master after "A".
+//
+// The name of each commit is also its hash and its message, and each commit adds a file
+// named "<name>.txt". HEAD is "refs/heads/master", and the user is "user@example.com".
+//
+// This panics if a commit is given different parents in different branches, since it is
+// only meant for setting up tests.
+func NewRepoWithHistory(branches map[string][]string) *MemoryRepo {
+	repo := &MemoryRepo{
+		memoryRepoState: &memoryRepoState{
+			head:         "refs/heads/master",
+			refs:         make(map[string]string),
+			commits:      make(map[string]memoryCommit),
+			notesCommits: make(map[string]memoryNotesCommit),
+			config: map[string][]string{
+				"user.email": []string{"user@example.com"},
+				"user.name":  []string{"Test User"},
+			},
+			remotes: make(map[string]*MemoryRepo),
+		},
+		ctx: context.Background(),
+	}
+	var names []string
+	for name := range branches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parent := ""
+		for _, commit := range branches[name] {
+			if existing, ok := repo.commits[commit]; !ok {
+				var parents []string
+				if parent != "" {
+					parents = []string{parent}
+				}
+				repo.AddCommit(commit, commit, map[string]string{commit + ".txt": commit + "\n"}, parents...)
+			} else if (len(existing.Parents) == 0 && parent != "") || (len(existing.Parents) > 0 && existing.Parents[0] != parent) {
+				panic(fmt.Sprintf("The commit %q has different parents in different branches", commit))
+			}
+			parent = commit
+		}
+		if parent != "" {
+			repo.SetRef(branchRef(name), parent)
+		}
+	}
+	return repo
+}
+
+// NewMemoryRepoForTest returns a MemoryRepo holding the same history, refs, and notes as the
+// repo returned by NewMockRepoForTest, for the tests written against that repo.
+func NewMemoryRepoForTest() *MemoryRepo {
+	repo := NewRepoWithHistory(nil)
+	for _, commit := range []struct {
+		name, message string
+		parents       []string
+	}{
+		{TestCommitA, "First commit", nil},
+		{TestCommitB, "Second commit", []string{TestCommitA}},
+		{TestCommitC, "No, I'm the second commit", []string{TestCommitA}},
+		{TestCommitD, "Fourth commit", []string{TestCommitB, TestCommitC}},
+		{TestCommitE, "Fifth commit", []string{TestCommitD}},
+		{TestCommitF, "Sixth commit", []string{TestCommitE}},
+		{TestCommitG, "No, I'm the sixth commit", []string{TestCommitE}},
+		{TestCommitH, "Seventh commit", []string{TestCommitG, TestCommitF}},
+		{TestCommitI, "Eighth commit", []string{TestCommitH}},
+		{TestCommitJ, "No, I'm the eighth commit", []string{TestCommitF}},
+	} {
+		repo.AddCommit(commit.name, commit.message, map[string]string{commit.name + ".txt": commit.name + "\n"}, commit.parents...)
+	}
+	repo.SetRef(TestTargetRef, TestCommitJ)
+	repo.SetRef(TestReviewRef, TestCommitI)
+	for _, note := range []struct {
+		ref, revision, note string
+	}{
+		{TestRequestsRef, TestCommitB, TestRequestB},
+		{TestRequestsRef, TestCommitD, TestRequestD},
+		{TestRequestsRef, TestCommitG, TestRequestG},
+		{TestCommentsRef, TestCommitB, TestDiscussB},
+		{TestCommentsRef, TestCommitD, TestDiscussD},
+	} {
+		if err := repo.AppendNote(note.ref, note.revision, Note(note.note)); err != nil {
+			panic(err.Error())
+		}
+	}
+	return repo
+}
+
+// branchRef returns the full name of the given branch, which may already be a full ref.
+func branchRef(name string) string {
+	if strings.HasPrefix(name, "refs/") {
+		return name
+	}
+	return branchRefPrefix + name
+}
+
+// AddCommit adds a commit with the given name, message, and parents, whose files are those
+// of its first parent updated with the given files, and returns its hash. The hash is the
+// name, unless the name is empty, in which case one is generated. No ref is updated.
+//
+// This panics if a parent does not exist, or a commit with the given name already does.
+func (r *MemoryRepo) AddCommit(name, message string, files map[string]string, parents ...string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.commits[name]; ok {
+		panic(fmt.Sprintf("The commit %q already exists", name))
+	}
+	tree := make(map[string]string)
+	for i, parent := range parents {
+		parentCommit, ok := r.commits[parent]
+		if !ok {
+			panic(fmt.Sprintf("The parent commit %q does not exist", parent))
+		}
+		if i == 0 {
+			for path, contents := range parentCommit.Files {
+				tree[path] = contents
+			}
+		}
+	}
+	for path, contents := range files {
+		tree[path] = contents
+	}
+	return r.createCommit(name, message, parents, tree, "", "")
+}
+
+// SetRef points the given ref at the given revision, creating the ref if needed.
+//
+// This panics if the revision does not exist.
+func (r *MemoryRepo) SetRef(ref, revision string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	commit, err := r.resolve(revision)
+	if err != nil {
+		panic(err.Error())
+	}
+	r.refs[ref] = commit
+}
+
+// SetPath sets the path of the repo, whose ".git" subdirectory is then returned by GetGitDir.
+//
+// Until this is called, the repo has no path, and GetGitDir returns an error, so that
+// nothing (such as the review cache) is written to the file system on its behalf.
+func (r *MemoryRepo) SetPath(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.path = path
+}
+
+// AddRemote adds the given repo as a remote with the given name.
+func (r *MemoryRepo) AddRemote(name string, remote *MemoryRepo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.remotes[name] = remote
+}
+
+// tick returns the time for the next object that is created.
+func (r *MemoryRepo) tick() int64 {
+	r.clock++
+	return memoryEpoch + r.clock
+}
+
+// lastConfigValue returns the last value set for the given config key, as git does.
+func (r *MemoryRepo) lastConfigValue(key string) string {
+	values := r.config[key]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// createCommit creates a commit, with a generated hash if the given name is empty, and
+// returns its hash. The author defaults to the configured user.
+func (r *MemoryRepo) createCommit(name, message string, parents []string, files map[string]string, author, authorEmail string) string {
+	if author == "" {
+		author, authorEmail = r.lastConfigValue("user.name"), r.lastConfigValue("user.email")
+	}
+	commit := memoryCommit{
+		Message:     strings.Trim(message, "\r\n"),
+		Time:        r.tick(),
+		Author:      author,
+		AuthorEmail: authorEmail,
+		Parents:     parents,
+		Files:       files,
+	}
+	if name == "" {
+		commitJSON, _ := json.Marshal(commit)
+		name = fmt.Sprintf("%x", sha1.Sum(commitJSON))
+	}
+	r.commits[name] = commit
+	return name
+}
+
+// resolve returns the commit (or notes commit) that the given revision refers to. The revision
+// may be a ref, a branch or tag name, a (prefix of a) hash, or "HEAD", followed by any number
+// of "^" and "~<n>" suffixes.
+func (r *MemoryRepo) resolve(revision string) (string, error) {
+	name, suffix := revision, ""
+	if i := strings.IndexAny(revision, "^~"); i > 0 {
+		name, suffix = revision[:i], revision[i:]
+	}
+	hash, err := r.resolveName(name)
+	if err != nil {
+		return "", err
+	}
+	for suffix != "" {
+		steps := 1
+		operator := suffix[0]
+		suffix = suffix[1:]
+		digits := len(suffix) - len(strings.TrimLeft(suffix, "0123456789"))
+		if digits > 0 {
+			steps, _ = strconv.Atoi(suffix[:digits])
+			suffix = suffix[digits:]
+		}
+		if operator == '^' && steps > 1 {
+			parents := r.parentsOf(hash)
+			if len(parents) < steps {
+				return "", fmt.Errorf("Unknown revision %q", revision)
+			}
+			hash = parents[steps-1]
+			continue
+		}
+		for i := 0; i < steps; i++ {
+			parents := r.parentsOf(hash)
+			if len(parents) == 0 {
+				return "", fmt.Errorf("Unknown revision %q", revision)
+			}
+			hash = parents[0]
+		}
+	}
+	return hash, nil
+}
+
+// resolveName implements resolve for a revision without any suffixes.
+func (r *MemoryRepo) resolveName(name string) (string, error) {
+	if name == "HEAD" {
+		if commit, ok := r.refs[r.head]; ok {
+			return commit, nil
+		}
+		if _, ok := r.commits[r.head]; ok {
+			return r.head, nil
+		}
+		return "", RefMissingError{Ref: "HEAD"}
+	}
+	for _, ref := range []string{name, "refs/" + name, "refs/tags/" + name, branchRefPrefix + name, "refs/remotes/" + name} {
+		if commit, ok := r.refs[ref]; ok {
+			return commit, nil
+		}
+	}
+	if _, ok := r.commits[name]; ok {
+		return name, nil
+	}
+	if _, ok := r.notesCommits[name]; ok {
+		return name, nil
+	}
+	if len(name) >= 4 {
+		var matches []string
+		for hash := range r.commits {
+			if strings.HasPrefix(hash, name) {
+				matches = append(matches, hash)
+			}
+		}
+		if len(matches) == 1 {
+			return matches[0], nil
+		}
+	}
+	return "", fmt.Errorf("Unknown revision %q", name)
+}
+
+// getCommit returns the commit that the given revision refers to, along with its hash.
+func (r *MemoryRepo) getCommit(revision string) (string, memoryCommit, error) {
+	hash, err := r.resolve(revision)
+	if err != nil {
+		return "", memoryCommit{}, err
+	}
+	commit, ok := r.commits[hash]
+	if !ok {
+		return "", memoryCommit{}, fmt.Errorf("The revision %q is not a commit", revision)
+	}
+	return hash, commit, nil
+}
+
+// parentsOf returns the parents of the given commit or notes commit.
+func (r *MemoryRepo) parentsOf(hash string) []string {
+	if commit, ok := r.commits[hash]; ok {
+		return commit.Parents
+	}
+	return r.notesCommits[hash].Parents
+}
+
+// timeOf returns the time of the given commit or notes commit.
+func (r *MemoryRepo) timeOf(hash string) int64 {
+	if commit, ok := r.commits[hash]; ok {
+		return commit.Time
+	}
+	return r.notesCommits[hash].Time
+}
+
+// ancestors returns the set of the given commit and all of its ancestors.
+func (r *MemoryRepo) ancestors(hash string) map[string]bool {
+	ancestors := make(map[string]bool)
+	queue := []string{hash}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		if ancestors[next] {
+			continue
+		}
+		ancestors[next] = true
+		queue = append(queue, r.parentsOf(next)...)
+	}
+	return ancestors
+}
+
+// mergeBase implements MergeBase for resolved commits, returning the empty string if the
+// commits have no common ancestor.
+func (r *MemoryRepo) mergeBase(a, b string) string {
+	aAncestors, bAncestors := r.ancestors(a), r.ancestors(b)
+	var common []string
+	for hash := range aAncestors {
+		if bAncestors[hash] {
+			common = append(common, hash)
+		}
+	}
+	// The best common ancestors are those that are not an ancestor of another one.
+	var best []string
+	for _, candidate := range common {
+		isBest := true
+		for _, other := range common {
+			if other != candidate && r.ancestors(other)[candidate] {
+				isBest = false
+				break
+			}
+		}
+		if isBest {
+			best = append(best, candidate)
+		}
+	}
+	if len(best) == 0 {
+		return ""
+	}
+	sort.Slice(best, func(i, j int) bool {
+		if r.timeOf(best[i]) != r.timeOf(best[j]) {
+			return r.timeOf(best[i]) > r.timeOf(best[j])
+		}
+		return best[i] < best[j]
+	})
+	return best[0]
+}
+
+// listCommitsBetween implements ListCommitsBetween for resolved commits.
+//
+// As with "git rev-list --ancestry-path", this is empty if "from" is not an ancestor of "to".
+func (r *MemoryRepo) listCommitsBetween(from, to string) []string {
+	excluded := r.ancestors(from)
+	included := make(map[string]bool)
+	for hash := range r.ancestors(to) {
+		// Only the commits on a path from "from" to "to" are listed, as with "--ancestry-path".
+		if !excluded[hash] && (from == "" || r.ancestors(hash)[from]) {
+			included[hash] = true
+		}
+	}
+	// Order the commits so that every commit comes after its parents, and otherwise by time.
+	var commits []string
+	emitted := make(map[string]bool)
+	for len(commits) < len(included) {
+		var ready []string
+		for hash := range included {
+			if emitted[hash] {
+				continue
+			}
+			isReady := true
+			for _, parent := range r.parentsOf(hash) {
+				if included[parent] && !emitted[parent] {
+					isReady = false
+				}
+			}
+			if isReady {
+				ready = append(ready, hash)
+			}
+		}
+		sort.Slice(ready, func(i, j int) bool {
+			if r.timeOf(ready[i]) != r.timeOf(ready[j]) {
+				return r.timeOf(ready[i]) < r.timeOf(ready[j])
+			}
+			return ready[i] < ready[j]
+		})
+		emitted[ready[0]] = true
+		commits = append(commits, ready[0])
+	}
+	return commits
+}
+
+// headCommit returns the commit that HEAD points to.
+func (r *MemoryRepo) headCommit() (string, error) {
+	return r.resolve("HEAD")
+}
+
+// setHead points the current branch (or HEAD itself, if it is detached) at the given commit.
+func (r *MemoryRepo) setHead(commit string) {
+	if strings.HasPrefix(r.head, "refs/") {
+		r.refs[r.head] = commit
+	} else {
+		r.head = commit
+	}
+}
+
+// filesOf returns the files of the given commit, which are empty if there is no commit.
+func (r *MemoryRepo) filesOf(hash string) map[string]string {
+	return r.commits[hash].Files
+}
+
+// mergeFiles merges the changes made to the base files by either side, failing if both
+// sides changed the same file differently.
+func mergeFiles(base, ours, theirs map[string]string) (map[string]string, error) {
+	merged := make(map[string]string)
+	paths := make(map[string]bool)
+	for _, files := range []map[string]string{base, ours, theirs} {
+		for path := range files {
+			paths[path] = true
+		}
+	}
+	var conflicts []string
+	for path := range paths {
+		baseContents, inBase := base[path]
+		ourContents, inOurs := ours[path]
+		theirContents, inTheirs := theirs[path]
+		switch {
+		case inTheirs == inBase && theirContents == baseContents, inOurs == inTheirs && ourContents == theirContents:
+			if inOurs {
+				merged[path] = ourContents
+			}
+		case inOurs == inBase && ourContents == baseContents:
+			if inTheirs {
+				merged[path] = theirContents
+			}
+		default:
+			conflicts = append(conflicts, path)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return nil, fmt.Errorf("Merge conflict in %s", strings.Join(conflicts, ", "))
+	}
+	return merged, nil
+}
+
+// sameFiles returns whether the two given trees have the same files.
+func sameFiles(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, contents := range a {
+		if otherContents, ok := b[path]; !ok || otherContents != contents {
+			return false
+		}
+	}
+	return true
+}
+
+// gitBlobHash returns the hash that git gives a blob with the given contents.
+func gitBlobHash(contents string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprintf("blob %d\x00%s", len(contents), contents))))
+}
+
+// GetPath returns the path to the repo.
+func (r *MemoryRepo) GetPath() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.path
+}
+
+// Context returns the context that stops the repo's operations once it is done.
+func (r *MemoryRepo) Context() context.Context { return r.ctx }
+
+// WithContext returns a copy of the repo, sharing its state, with the given context.
+func (r *MemoryRepo) WithContext(ctx context.Context) Repo {
+	return &MemoryRepo{memoryRepoState: r.memoryRepoState, ctx: ctx}
+}
+
+// IsBare returns whether or not the repository is bare, i.e. has no working tree.
+func (r *MemoryRepo) IsBare() (bool, error) { return false, nil }
+
+// IsShallow returns whether or not the repository is a shallow clone, missing some of its history.
+func (r *MemoryRepo) IsShallow() (bool, error) { return false, nil }
+
+// GetGitDir returns the path to the directory holding the repo's git metadata.
+func (r *MemoryRepo) GetGitDir() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.path == "" {
+		return "", errors.New("The in-memory repo has no git directory")
+	}
+	return filepath.Join(r.path, ".git"), nil
+}
+
+// GetRepoStateHash returns a hash which embodies the entire current state of a repository.
+//
+// Since commits never change, this is a hash of HEAD and the refs.
+func (r *MemoryRepo) GetRepoStateHash() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stateJSON, err := json.Marshal(map[string]interface{}{"head": r.head, "refs": r.refs})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha1.Sum(stateJSON)), nil
+}
+
+// GetUserEmail returns the email address that the user has used to configure git.
+func (r *MemoryRepo) GetUserEmail() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	email := r.lastConfigValue("user.email")
+	if email == "" {
+		return "", errors.New("The user.email config setting is not set")
+	}
+	return email, nil
+}
+
+// GetConfigValues returns all of the values set for the given git config key.
+func (r *MemoryRepo) GetConfigValues(key string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.config[key]...), nil
+}
+
+// GetConfigValuesWithOrigin returns all of the values set for the given git config key, which are all local.
+func (r *MemoryRepo) GetConfigValuesWithOrigin(key string) ([]ConfigValue, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var values []ConfigValue
+	for _, value := range r.config[key] {
+		values = append(values, ConfigValue{Value: value, Origin: "local"})
+	}
+	return values, nil
+}
+
+// SetConfigValue sets the given git config key to the given value, replacing any previous values.
+func (r *MemoryRepo) SetConfigValue(key, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.config[key] = []string{value}
+	return nil
+}
+
+// SignPayload signs the given data with the user's signing key, and returns the armored signature.
+//
+// As with the mock repo, the signature is just the user's email address along with a hash of the data.
+func (r *MemoryRepo) SignPayload(payload []byte) (string, error) {
+	email, err := r.GetUserEmail()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("mock signature by %s of %x", email, sha1.Sum(payload)), nil
+}
+
+// VerifySignature verifies the given armored signature of the given data, and returns the identity of the signer.
+func (r *MemoryRepo) VerifySignature(payload []byte, signature string) (string, error) {
+	var signer, hash string
+	if _, err := fmt.Sscanf(signature, "mock signature by %s of %s", &signer, &hash); err != nil {
+		return "", fmt.Errorf("Malformed mock signature %q", signature)
+	}
+	if hash != fmt.Sprintf("%x", sha1.Sum(payload)) {
+		return "", errors.New("The signature does not match the data.")
+	}
+	return signer, nil
+}
+
+// ListRemotes returns the names of all of the remotes configured for the repo.
+func (r *MemoryRepo) ListRemotes() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var remotes []string
+	for remote := range r.remotes {
+		remotes = append(remotes, remote)
+	}
+	sort.Strings(remotes)
+	return remotes, nil
+}
+
+// HasUncommittedChanges returns true if there are local, uncommitted changes, which there never are.
+func (r *MemoryRepo) HasUncommittedChanges() (bool, error) { return false, nil }
+
+// StashChanges saves the local, uncommitted changes under the given message, of which there are none.
+func (r *MemoryRepo) StashChanges(message string) (string, error) { return "", nil }
+
+// RestoreStash reapplies the changes saved by StashChanges.
+func (r *MemoryRepo) RestoreStash(stash string) error { return nil }
+
+// SnapshotChanges records the uncommitted changes in a new commit, but there are none.
+func (r *MemoryRepo) SnapshotChanges(ref, message string, staged bool) (string, error) {
+	return "", ErrNoChanges
+}
+
+// DeleteRef deletes the given ref.
+func (r *MemoryRepo) DeleteRef(ref string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.refs[ref]; !ok {
+		return RefMissingError{Ref: ref}
+	}
+	delete(r.refs, ref)
+	return nil
+}
+
+// UpdateRef points the given ref at the given commit, or deletes it if the commit is
+// empty, but only if the ref still points at oldValue (or does not exist, if that is empty).
+func (r *MemoryRepo) UpdateRef(ref, newValue, oldValue string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if current := r.refs[ref]; current != oldValue {
+		return fmt.Errorf("Cannot update %s, as it points at %q rather than %q", ref, current, oldValue)
+	}
+	if newValue == "" {
+		delete(r.refs, ref)
+		return nil
+	}
+	_, isCommit := r.commits[newValue]
+	_, isNotesCommit := r.notesCommits[newValue]
+	if !isCommit && !isNotesCommit {
+		return fmt.Errorf("Unknown commit %q", newValue)
+	}
+	r.refs[ref] = newValue
+	return nil
+}
+
+// DeleteRemoteRef deletes the given ref from the given remote repo.
+func (r *MemoryRepo) DeleteRemoteRef(remote, ref string) error {
+	remoteRepo, err := r.getRemote(remote)
+	if err != nil {
+		return err
+	}
+	return remoteRepo.DeleteRef(ref)
+}
+
+// VerifyCommit verifies that the supplied hash points to a known commit.
+func (r *MemoryRepo) VerifyCommit(hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, _, err := r.getCommit(hash)
+	return err
+}
+
+// VerifyGitRef verifies that the supplied ref points to a known commit.
+func (r *MemoryRepo) VerifyGitRef(ref string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ref == "HEAD" {
+		_, err := r.headCommit()
+		return err
+	}
+	if _, ok := r.refs[ref]; !ok {
+		return RefMissingError{Ref: ref}
+	}
+	return nil
+}
+
+// GetHeadRef returns the ref that is the current HEAD.
+func (r *MemoryRepo) GetHeadRef() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !strings.HasPrefix(r.head, "refs/") {
+		return "", errors.New("HEAD is detached")
+	}
+	return r.head, nil
+}
+
+// GetCommitHash returns the hash of the commit pointed to by the given ref.
+func (r *MemoryRepo) GetCommitHash(ref string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.resolve(ref)
+}
+
+// ResolveRefCommit returns the commit pointed to by the given ref, which may be a remote ref.
+//
+// If a branch does not exist locally, then it is looked up in the remote-tracking refs,
+// as long as exactly one remote has it.
+func (r *MemoryRepo) ResolveRefCommit(ref string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if commit, ok := r.refs[ref]; ok {
+		return commit, nil
+	}
+	if strings.HasPrefix(ref, branchRefPrefix) {
+		branch := strings.TrimPrefix(ref, branchRefPrefix)
+		var matches []string
+		for candidate := range r.refs {
+			if strings.HasPrefix(candidate, "refs/remotes/") && strings.HasSuffix(candidate, "/"+branch) &&
+				strings.Count(strings.TrimPrefix(candidate, "refs/remotes/"), "/") == strings.Count(branch, "/")+1 {
+				matches = append(matches, candidate)
+			}
+		}
+		if len(matches) == 1 {
+			return r.refs[matches[0]], nil
+		}
+		return "", fmt.Errorf("Unable to find a git ref matching the pattern %q", "**/"+branch)
+	}
+	return "", fmt.Errorf("Unknown git ref %q", ref)
+}
+
+// GetCommitMessage returns the message stored in the commit pointed to by the given ref.
+func (r *MemoryRepo) GetCommitMessage(ref string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, commit, err := r.getCommit(ref)
+	return commit.Message, err
+}
+
+// GetCommitTime returns the commit time of the commit pointed to by the given ref.
+func (r *MemoryRepo) GetCommitTime(ref string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, commit, err := r.getCommit(ref)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(commit.Time, 10), nil
+}
+
+// GetLastParent returns the last parent of the given commit (as ordered by git), which is
+// the most recent one.
+func (r *MemoryRepo) GetLastParent(ref string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, commit, err := r.getCommit(ref)
+	if err != nil {
+		return "", err
+	}
+	last := ""
+	for _, parent := range commit.Parents {
+		if last == "" || r.timeOf(parent) >= r.timeOf(last) {
+			last = parent
+		}
+	}
+	return last, nil
+}
+
+// GetCommitDetails returns the details of a commit's metadata.
+func (r *MemoryRepo) GetCommitDetails(ref string) (*CommitDetails, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, commit, err := r.getCommit(ref)
+	if err != nil {
+		return nil, err
+	}
+	treeJSON, err := json.Marshal(commit.Files)
+	if err != nil {
+		return nil, err
+	}
+	// As with git, a commit without parents has a single, empty parent.
+	parents := []string{""}
+	if len(commit.Parents) > 0 {
+		parents = append([]string(nil), commit.Parents...)
+	}
+	return &CommitDetails{
+		Author:      commit.Author,
+		AuthorEmail: commit.AuthorEmail,
+		Tree:        fmt.Sprintf("%x", sha1.Sum(treeJSON)),
+		Time:        strconv.FormatInt(commit.Time, 10),
+		Parents:     parents,
+		Summary:     strings.SplitN(commit.Message, "\n", 2)[0],
+	}, nil
+}
+
+// MergeBase determines if the first commit that is an ancestor of the two arguments.
+func (r *MemoryRepo) MergeBase(a, b string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	aCommit, err := r.resolve(a)
+	if err != nil {
+		return "", err
+	}
+	bCommit, err := r.resolve(b)
+	if err != nil {
+		return "", err
+	}
+	base := r.mergeBase(aCommit, bCommit)
+	if base == "" {
+		return "", fmt.Errorf("The commits %q and %q have no common ancestor", a, b)
+	}
+	return base, nil
+}
+
+// IsAncestor determines if the first argument points to a commit that is an ancestor of the second.
+//
+// As with git, unknown commits are not ancestors of anything.
+func (r *MemoryRepo) IsAncestor(ancestor, descendant string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ancestorCommit, err := r.resolve(ancestor)
+	if err != nil {
+		return false, nil
+	}
+	descendantCommit, err := r.resolve(descendant)
+	if err != nil {
+		return false, nil
+	}
+	return r.ancestors(descendantCommit)[ancestorCommit], nil
+}
+
+// FindAncestors determines which of the given commits are ancestors of the given descendant.
+func (r *MemoryRepo) FindAncestors(commits []string, descendant string) (map[string]bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	descendantCommit, err := r.resolve(descendant)
+	if err != nil {
+		return nil, err
+	}
+	descendantAncestors := r.ancestors(descendantCommit)
+	ancestors := make(map[string]bool)
+	for _, commit := range commits {
+		if hash, err := r.resolve(commit); err == nil && descendantAncestors[hash] {
+			ancestors[commit] = true
+		}
+	}
+	return ancestors, nil
+}
+
+// changedPaths returns the sorted paths of the files that differ between the two trees.
+func changedPaths(left, right map[string]string) []string {
+	var paths []string
+	for path, contents := range left {
+		if rightContents, ok := right[path]; !ok || rightContents != contents {
+			paths = append(paths, path)
+		}
+	}
+	for path := range right {
+		if _, ok := left[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// splitContents splits the contents of a file into its lines.
+func splitContents(contents string) []string {
+	if contents == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(contents, "\n"), "\n")
+}
+
+// hunkRange formats the start and length of one side of a hunk header.
+func hunkRange(length int) string {
+	switch length {
+	case 0:
+		return "0,0"
+	case 1:
+		return "1"
+	}
+	return fmt.Sprintf("1,%d", length)
+}
+
+// unifiedDiff returns the diff of a single file, as a single hunk that covers the whole file.
+func unifiedDiff(path, oldContents string, inOld bool, newContents string, inNew bool) string {
+	var diff strings.Builder
+	fmt.Fprintf(&diff, "diff --git a/%s b/%s\n", path, path)
+	from, to := "a/"+path, "b/"+path
+	if !inOld {
+		diff.WriteString("new file mode 100644\n")
+		from = "/dev/null"
+	}
+	if !inNew {
+		diff.WriteString("deleted file mode 100644\n")
+		to = "/dev/null"
+	}
+	fmt.Fprintf(&diff, "--- %s\n+++ %s\n", from, to)
+	oldLines, newLines := splitContents(oldContents), splitContents(newContents)
+	fmt.Fprintf(&diff, "@@ -%s +%s @@\n", hunkRange(len(oldLines)), hunkRange(len(newLines)))
+	// The lines common to both sides are those of their longest common subsequence.
+	common := make([][]int, len(oldLines)+1)
+	for i := range common {
+		common[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(oldLines) || j < len(newLines) {
+		switch {
+		case i < len(oldLines) && j < len(newLines) && oldLines[i] == newLines[j]:
+			diff.WriteString(" " + oldLines[i] + "\n")
+			i++
+			j++
+		case j < len(newLines) && (i == len(oldLines) || common[i][j+1] >= common[i+1][j]):
+			diff.WriteString("+" + newLines[j] + "\n")
+			j++
+		default:
+			diff.WriteString("-" + oldLines[i] + "\n")
+			i++
+		}
+	}
+	return diff.String()
+}
+
+// diff implements Diff for the trees of resolved commits.
+func diffFiles(left, right map[string]string, diffArgs ...string) string {
+	nameOnly, nameStatus, separator, filter := false, false, "\n", ""
+	for _, arg := range diffArgs {
+		switch {
+		case arg == "--name-only":
+			nameOnly = true
+		case arg == "--name-status":
+			nameStatus = true
+		case arg == "-z":
+			separator = "\x00"
+		case strings.HasPrefix(arg, "--diff-filter="):
+			filter = strings.TrimPrefix(arg, "--diff-filter=")
+		}
+	}
+	var out strings.Builder
+	for _, path := range changedPaths(left, right) {
+		oldContents, inOld := left[path]
+		newContents, inNew := right[path]
+		status := "M"
+		if !inOld {
+			status = "A"
+		} else if !inNew {
+			status = "D"
+		}
+		if filter != "" && !strings.Contains(filter, status) {
+			continue
+		}
+		switch {
+		case nameOnly:
+			out.WriteString(path + separator)
+		case nameStatus && separator == "\x00":
+			out.WriteString(status + separator + path + separator)
+		case nameStatus:
+			out.WriteString(status + "\t" + path + separator)
+		default:
+			out.WriteString(unifiedDiff(path, oldContents, inOld, newContents, inNew))
+		}
+	}
+	return strings.Trim(out.String(), "\r\n")
+}
+
+// Diff computes the diff between two given commits.
+//
+// Of the git diff options, only "--name-only", "--name-status", "-z", and "--diff-filter"
+// are supported. Renames are never detected.
+func (r *MemoryRepo) Diff(left, right string, diffArgs ...string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	leftHash, _, err := r.getCommit(left)
+	if err != nil {
+		return "", err
+	}
+	rightHash, _, err := r.getCommit(right)
+	if err != nil {
+		return "", err
+	}
+	return diffFiles(r.filesOf(leftHash), r.filesOf(rightHash), diffArgs...), nil
+}
+
+// Show returns the contents of the given file at the given commit.
+func (r *MemoryRepo) Show(commit, path string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, c, err := r.getCommit(commit)
+	if err != nil {
+		return "", err
+	}
+	contents, ok := c.Files[path]
+	if !ok {
+		return "", fmt.Errorf("The path %q does not exist in %q", path, commit)
+	}
+	return strings.Trim(contents, "\r\n"), nil
+}
+
+// IsSubmodule returns whether or not the given path is a submodule at the given commit.
+func (r *MemoryRepo) IsSubmodule(commit, path string) (bool, error) { return false, nil }
+
+// SwitchToRef changes the currently-checked-out ref.
+//
+// Anything other than a branch is checked out as a detached HEAD.
+func (r *MemoryRepo) SwitchToRef(ref string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, branch := range []string{ref, branchRefPrefix + ref} {
+		if _, ok := r.refs[branch]; ok && strings.HasPrefix(branch, branchRefPrefix) {
+			r.head = branch
+			return nil
+		}
+	}
+	commit, _, err := r.getCommit(ref)
+	if err != nil {
+		return err
+	}
+	r.head = commit
+	return nil
+}
+
+// mergeMessage returns the default message of a commit merging the given ref.
+func mergeMessage(ref string) string {
+	if strings.HasPrefix(ref, branchRefPrefix) {
+		return fmt.Sprintf("Merge branch '%s'", strings.TrimPrefix(ref, branchRefPrefix))
+	}
+	return fmt.Sprintf("Merge commit '%s'", ref)
+}
+
+// MergeRef merges the given ref into the current one.
+//
+// The ref argument is the ref to merge, and fastForward indicates that the
+// current ref should only move forward, as opposed to creating a bubble merge.
+// The messages argument(s) provide text that should be included in the default
+// merge commit message (separated by blank lines).
+func (r *MemoryRepo) MergeRef(ref string, fastForward, sign bool, messages ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	head, err := r.headCommit()
+	if err != nil {
+		return err
+	}
+	other, _, err := r.getCommit(ref)
+	if err != nil {
+		return err
+	}
+	if r.ancestors(head)[other] {
+		// Already up to date.
+		return nil
+	}
+	if fastForward {
+		if !r.ancestors(other)[head] {
+			return ErrNotFastForward
+		}
+		r.setHead(other)
+		return nil
+	}
+	files, err := mergeFiles(r.filesOf(r.mergeBase(head, other)), r.filesOf(head), r.filesOf(other))
+	if err != nil {
+		return err
+	}
+	message := mergeMessage(ref)
+	if len(messages) > 0 {
+		message = strings.Join(messages, "\n\n")
+	}
+	r.setHead(r.createCommit("", message, []string{head, other}, files, "", ""))
+	return nil
+}
+
+// RebaseRef rebases the current ref onto the given one, as "git rebase" does.
+//
+// Merge commits are dropped, as are commits whose changes are already in the given ref.
+func (r *MemoryRepo) RebaseRef(ref string, sign bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	head, err := r.headCommit()
+	if err != nil {
+		return err
+	}
+	onto, _, err := r.getCommit(ref)
+	if err != nil {
+		return err
+	}
+	if r.ancestors(head)[onto] {
+		// Already up to date.
+		return nil
+	}
+	newHead := onto
+	for _, hash := range r.listCommitsBetween(r.mergeBase(onto, head), head) {
+		commit := r.commits[hash]
+		if len(commit.Parents) != 1 {
+			continue
+		}
+		files, err := mergeFiles(r.filesOf(commit.Parents[0]), r.filesOf(newHead), commit.Files)
+		if err != nil {
+			return err
+		}
+		if sameFiles(files, r.filesOf(newHead)) {
+			continue
+		}
+		newHead = r.createCommit("", commit.Message, []string{newHead}, files, commit.Author, commit.AuthorEmail)
+	}
+	r.setHead(newHead)
+	return nil
+}
+
+// SquashRef squashes the changes in the given ref into a single new commit on the current one.
+//
+// The messages argument(s) provide the commit message (separated by blank lines).
+func (r *MemoryRepo) SquashRef(ref string, sign bool, messages ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	head, err := r.headCommit()
+	if err != nil {
+		return err
+	}
+	other, _, err := r.getCommit(ref)
+	if err != nil {
+		return err
+	}
+	files, err := mergeFiles(r.filesOf(r.mergeBase(head, other)), r.filesOf(head), r.filesOf(other))
+	if err != nil {
+		return err
+	}
+	if sameFiles(files, r.filesOf(head)) {
+		return errors.New("There is nothing to commit, as the changes are already merged")
+	}
+	message := "Squashed commit of " + ref
+	if len(messages) > 0 {
+		message = strings.Join(messages, "\n\n")
+	}
+	r.setHead(r.createCommit("", message, []string{head}, files, "", ""))
+	return nil
+}
+
+// CommitMerge commits a merge or squash that stopped partway because of conflicts, which
+// never happens in a MemoryRepo.
+func (r *MemoryRepo) CommitMerge(sign bool, messages ...string) error {
+	return errors.New("There is no merge in progress in the in-memory repo")
+}
+
+// AbortMerge abandons a merge, rebase, or squash that failed partway, which never leaves
+// anything behind in a MemoryRepo.
+func (r *MemoryRepo) AbortMerge() error { return nil }
+
+// MergeInProgress returns the kind of merge that was started but not yet finished, of which there are none.
+func (r *MemoryRepo) MergeInProgress() (string, []string, error) { return "", nil, nil }
+
+// ListCommitsBetween returns the list of commits between the two given revisions.
+//
+// The "from" parameter is the starting point (exclusive), and the "to" parameter
+// is the ending point (inclusive). As with GitRepo, only the commits that descend
+// from the starting point are included.
+//
+// The generated list is in chronological order (with the oldest commit first).
+func (r *MemoryRepo) ListCommitsBetween(from, to string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fromCommit, err := r.resolve(from)
+	if err != nil {
+		return nil, err
+	}
+	toCommit, err := r.resolve(to)
+	if err != nil {
+		return nil, err
+	}
+	commits := r.listCommitsBetween(fromCommit, toCommit)
+	if len(commits) == 0 {
+		return nil, nil
+	}
+	return commits, nil
+}
+
+// ListCommits returns the details of the commits between the two given revisions, which
+// are the same commits, in the same order, as those returned by ListCommitsBetween.
+func (r *MemoryRepo) ListCommits(from, to string) ([]Commit, error) {
+	return listCommitDetails(r, from, to)
+}
+
+// patchFileName returns the name that "git format-patch" gives the patch with the given
+// number and subject.
+func patchFileName(number int, subject string) string {
+	var name strings.Builder
+	dash := false
+	for _, c := range subject {
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || c == '.' || c == '_' {
+			if dash && name.Len() > 0 {
+				name.WriteRune('-')
+			}
+			name.WriteRune(c)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	slug := strings.TrimRight(name.String(), ".")
+	if len(slug) > 52 {
+		slug = slug[:52]
+	}
+	return fmt.Sprintf("%04d-%s.patch", number, slug)
+}
+
+// FormatPatch writes the commits between the two given revisions to the given directory
+// as a series of email patches, as "git format-patch" does, and returns the paths of the
+// written files in order. Merge commits are left out.
+func (r *MemoryRepo) FormatPatch(from, to, outputDir string, trailers []string, coverLetter string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fromCommit, err := r.resolve(from)
+	if err != nil {
+		return nil, err
+	}
+	toCommit, err := r.resolve(to)
+	if err != nil {
+		return nil, err
+	}
+	var commits []string
+	for _, hash := range r.listCommitsBetween(fromCommit, toCommit) {
+		if len(r.commits[hash].Parents) <= 1 {
+			commits = append(commits, hash)
+		}
+	}
+	if len(commits) == 0 {
+		return nil, nil
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, err
+	}
+	subjectPrefix := func(number int) string {
+		if len(commits) == 1 && coverLetter == "" {
+			return "[PATCH]"
+		}
+		return fmt.Sprintf("[PATCH %d/%d]", number, len(commits))
+	}
+	var paths []string
+	writePatch := func(number int, hash string, commit memoryCommit, message, diff string) error {
+		parts := strings.SplitN(message, "\n", 2)
+		subject, body := parts[0], ""
+		if len(parts) > 1 {
+			body = strings.Trim(parts[1], "\n")
+		}
+		var patch strings.Builder
+		fmt.Fprintf(&patch, "From %s Mon Sep 17 00:00:00 2001\n", hash)
+		fmt.Fprintf(&patch, "From: %s <%s>\n", commit.Author, commit.AuthorEmail)
+		fmt.Fprintf(&patch, "Date: %s\n", time.Unix(commit.Time, 0).UTC().Format(time.RFC1123Z))
+		fmt.Fprintf(&patch, "Subject: %s %s\n\n", subjectPrefix(number), subject)
+		if body != "" {
+			patch.WriteString(body + "\n")
+		}
+		if diff != "" {
+			patch.WriteString("---\n" + diff + "\n")
+		}
+		patch.WriteString("-- \n2.0.0\n\n")
+		path := filepath.Join(outputDir, patchFileName(number, subject))
+		if number == 0 {
+			path = filepath.Join(outputDir, "0000-cover-letter.patch")
+		}
+		paths = append(paths, path)
+		return ioutil.WriteFile(path, []byte(patch.String()), 0644)
+	}
+	if coverLetter != "" {
+		if err := writePatch(0, toCommit, r.commits[toCommit], coverLetter, ""); err != nil {
+			return nil, err
+		}
+	}
+	for i, hash := range commits {
+		commit := r.commits[hash]
+		message := commit.Message
+		if len(trailers) > 0 {
+			message += "\n\n" + strings.Join(trailers, "\n")
+		}
+		diff := diffFiles(r.filesOf(commit.firstParent()), commit.Files)
+		if err := writePatch(i+1, hash, commit, message, diff); err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// firstParent returns the first parent of the commit, or the empty string if it has none.
+func (c memoryCommit) firstParent() string {
+	if len(c.Parents) == 0 {
+		return ""
+	}
+	return c.Parents[0]
+}
+
+// notesOf returns the note blobs of the given notes ref, keyed by the annotated object.
+func (r *MemoryRepo) notesOf(notesRef string) map[string]string {
+	return r.notesCommits[r.refs[notesRef]].Notes
+}
+
+// parseNotes splits the contents of a note blob into the notes it holds, one per line.
+func parseNotes(blob string) []Note {
+	var notes []Note
+	for _, line := range splitLines(strings.Trim(blob, "\r\n")) {
+		notes = append(notes, Note(line))
+	}
+	return notes
+}
+
+// writeNotes records the given note blobs as a new commit of the given notes ref.
+func (r *MemoryRepo) writeNotes(notesRef string, notes map[string]string, parents ...string) {
+	if tip, ok := r.refs[notesRef]; ok && len(parents) == 0 {
+		parents = []string{tip}
+	}
+	commit := memoryNotesCommit{Time: r.tick(), Parents: parents, Notes: notes}
+	commitJSON, _ := json.Marshal(commit)
+	hash := fmt.Sprintf("%x", sha1.Sum(append([]byte(notesRef), commitJSON...)))
+	r.notesCommits[hash] = commit
+	r.refs[notesRef] = hash
+}
+
+// updateNotes records a new commit of the given notes ref, in which the note blob annotating
+// the given object is replaced by the given one, or removed if that is empty.
+func (r *MemoryRepo) updateNotes(notesRef, object, blob string) {
+	notes := make(map[string]string)
+	for annotated, existing := range r.notesOf(notesRef) {
+		notes[annotated] = existing
+	}
+	if blob == "" {
+		delete(notes, object)
+	} else {
+		notes[object] = blob
+	}
+	r.writeNotes(notesRef, notes)
+}
+
+// GetNotes reads the notes from the given ref that annotate the given revision.
+func (r *MemoryRepo) GetNotes(notesRef, revision string) []Note {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	object, err := r.resolve(revision)
+	if err != nil {
+		object = revision
+	}
+	blob, ok := r.notesOf(notesRef)[object]
+	if !ok {
+		return nil
+	}
+	return parseNotes(blob)
+}
+
+// GetAllNotes reads all of the notes from the given ref, keyed by the annotated object.
+func (r *MemoryRepo) GetAllNotes(notesRef string) (map[string][]Note, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	notes := make(map[string][]Note)
+	for object, blob := range r.notesOf(notesRef) {
+		notes[object] = parseNotes(blob)
+	}
+	return notes, nil
+}
+
+// AppendNote appends a note to a revision under the given ref.
+//
+// As with "git notes append", the note is separated from any existing ones by a blank line.
+func (r *MemoryRepo) AppendNote(notesRef, revision string, note Note) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	object, err := r.resolve(revision)
+	if err != nil {
+		return err
+	}
+	blob := strings.Trim(string(note), "\r\n") + "\n"
+	if existing, ok := r.notesOf(notesRef)[object]; ok {
+		blob = existing + "\n" + blob
+	}
+	r.updateNotes(notesRef, object, blob)
+	return nil
+}
+
+// StoreBlob writes the given contents to a git blob and returns its hash.
+//
+// The blob is recorded as a note (annotating itself) under the given notes ref.
+func (r *MemoryRepo) StoreBlob(notesRef string, contents []byte) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	hash := gitBlobHash(string(contents))
+	r.updateNotes(notesRef, hash, string(contents))
+	return hash, nil
+}
+
+// ListNotedRevisions returns the collection of revisions that are annotated by notes in the given ref.
+func (r *MemoryRepo) ListNotedRevisions(notesRef string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var revisions []string
+	for object := range r.notesOf(notesRef) {
+		if _, ok := r.commits[object]; ok {
+			revisions = append(revisions, object)
+		}
+	}
+	sort.Strings(revisions)
+	return revisions
+}
+
+// matchesRefPattern returns whether the given ref matches the given pattern, as with
+// "git for-each-ref": either as a glob whose wildcards do not match slashes, or literally,
+// either completely or up to a slash.
+func matchesRefPattern(pattern, ref string) bool {
+	if strings.ContainsAny(pattern, "*?[") {
+		matched, _ := path.Match(pattern, ref)
+		return matched
+	}
+	return ref == pattern || strings.HasPrefix(ref, strings.TrimSuffix(pattern, "/")+"/")
+}
+
+// matchesRefSpec returns whether the given ref matches the source of a refspec, in which
+// a trailing "*" matches the rest of the ref, slashes included.
+func matchesRefSpec(pattern, ref string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(ref, strings.TrimSuffix(pattern, "*"))
+	}
+	return ref == pattern
+}
+
+// matchingRefs returns the refs matching the given pattern, along with their values.
+func (r *MemoryRepo) matchingRefs(pattern string, matches func(pattern, ref string) bool) map[string]string {
+	refs := make(map[string]string)
+	for ref, value := range r.refs {
+		if matches(pattern, ref) {
+			refs[ref] = value
+		}
+	}
+	return refs
+}
+
+// ListNotesRefs returns the sorted names of the notes refs matching the given pattern.
+func (r *MemoryRepo) ListNotesRefs(refPattern string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var refs []string
+	for ref, value := range r.matchingRefs(refPattern, matchesRefPattern) {
+		if _, ok := r.notesCommits[value]; ok {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Strings(refs)
+	return refs, nil
+}
+
+// ListRefs returns the objects that the refs matching the given pattern point to, keyed by ref.
+func (r *MemoryRepo) ListRefs(refPattern string) (map[string]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.matchingRefs(refPattern, matchesRefPattern), nil
+}
+
+// ListNotes returns the hash of the note blob for every object annotated in the given notes ref,
+// keyed by the hash of the annotated object.
+func (r *MemoryRepo) ListNotes(notesRef string) (map[string]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	notes := make(map[string]string)
+	for object, blob := range r.notesOf(notesRef) {
+		notes[object] = gitBlobHash(blob)
+	}
+	return notes, nil
+}
+
+// SetNotes replaces the notes annotating the given object under the given ref. If
+// there are no notes given, then the existing notes are removed.
+func (r *MemoryRepo) SetNotes(notesRef, revision string, notes []Note) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	object, err := r.resolve(revision)
+	if err != nil {
+		object = revision
+	}
+	if len(notes) == 0 {
+		if _, ok := r.notesOf(notesRef)[object]; ok {
+			r.updateNotes(notesRef, object, "")
+		}
+		return nil
+	}
+	var lines []string
+	for _, note := range notes {
+		lines = append(lines, string(note))
+	}
+	r.updateNotes(notesRef, object, strings.Join(lines, "\n")+"\n")
+	return nil
+}
+
+// CompactNotes rewrites every note under the given ref using the given function, in a
+// single new commit on the notes ref, and records the previous tip of the notes ref
+// under the given backup ref. Objects left without any notes are no longer annotated.
+//
+// If the function does not change any notes, then neither ref is updated.
+func (r *MemoryRepo) CompactNotes(notesRef, backupRef string, compact func(object string, notes []Note) []Note) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	oldTip, ok := r.refs[notesRef]
+	if !ok {
+		return nil
+	}
+	newNotes := make(map[string]string)
+	changed := false
+	for object, blob := range r.notesOf(notesRef) {
+		var lines []string
+		for _, note := range compact(object, parseNotes(blob)) {
+			lines = append(lines, string(note))
+		}
+		compacted := ""
+		if len(lines) > 0 {
+			compacted = strings.Join(lines, "\n") + "\n"
+			newNotes[object] = compacted
+		}
+		if strings.Trim(compacted, "\r\n") != strings.Trim(blob, "\r\n") {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	r.writeNotes(notesRef, newNotes)
+	r.refs[backupRef] = oldTip
+	return nil
+}
+
+// getRemote returns the remote repo with the given name.
+func (r *MemoryRepo) getRemote(remote string) (*MemoryRepo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	remoteRepo, ok := r.remotes[remote]
+	if !ok {
+		return nil, fmt.Errorf("Unknown remote %q", remote)
+	}
+	return remoteRepo, nil
+}
+
+// copyObjects copies all of the commits of the given repo into this one.
+//
+// Both repos must be locked.
+func (r *MemoryRepo) copyObjects(from *MemoryRepo) {
+	for hash, commit := range from.commits {
+		r.commits[hash] = commit
+	}
+	for hash, commit := range from.notesCommits {
+		r.notesCommits[hash] = commit
+	}
+	if from.clock > r.clock {
+		r.clock = from.clock
+	}
+}
+
+// withRemote runs the given function with both this repo and the given remote locked.
+func (r *MemoryRepo) withRemote(remote string, f func(remoteRepo *MemoryRepo) error) error {
+	remoteRepo, err := r.getRemote(remote)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if remoteRepo.memoryRepoState != r.memoryRepoState {
+		remoteRepo.mu.Lock()
+		defer remoteRepo.mu.Unlock()
+	}
+	return f(remoteRepo)
+}
+
+// Fetch updates the remote-tracking refs from the given remote repo.
+func (r *MemoryRepo) Fetch(remote string) error {
+	return r.withRemote(remote, func(remoteRepo *MemoryRepo) error {
+		r.copyObjects(remoteRepo)
+		for ref, value := range remoteRepo.matchingRefs(branchRefPrefix+"*", matchesRefSpec) {
+			r.refs["refs/remotes/"+remote+"/"+strings.TrimPrefix(ref, branchRefPrefix)] = value
+		}
+		return nil
+	})
+}
+
+// AddWorktree checks out the given commit into a new, detached worktree at the given path.
+func (r *MemoryRepo) AddWorktree(path, commit string) error { return r.VerifyCommit(commit) }
+
+// RemoveWorktree removes a worktree previously created with AddWorktree.
+func (r *MemoryRepo) RemoveWorktree(path string) error { return nil }
+
+// GetNotesTip returns the commit that the given notes ref currently points to, or
+// the empty string if the ref does not exist.
+func (r *MemoryRepo) GetNotesTip(notesRef string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.refs[notesRef], nil
+}
+
+// ListChangedNotes returns the sorted list of annotated objects whose notes differ
+// between two commits of a notes ref. Either commit may be the empty string, which
+// stands for there being no notes at all.
+func (r *MemoryRepo) ListChangedNotes(leftCommit, rightCommit string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return changedPaths(r.notesCommits[leftCommit].Notes, r.notesCommits[rightCommit].Notes), nil
+}
+
+// DiffRemoteRefs reports the refs matching the given patterns whose values differ
+// between the local repo and the given remote, without updating any local refs.
+func (r *MemoryRepo) DiffRemoteRefs(remote string, refPatterns ...string) ([]RefDiff, error) {
+	var diffs []RefDiff
+	err := r.withRemote(remote, func(remoteRepo *MemoryRepo) error {
+		for _, refPattern := range refPatterns {
+			localRefs, remoteRefs := r.matchingRefs(refPattern, matchesRefPattern), remoteRepo.matchingRefs(refPattern, matchesRefPattern)
+			allRefs := make(map[string]bool)
+			for ref := range localRefs {
+				allRefs[ref] = true
+			}
+			for ref := range remoteRefs {
+				allRefs[ref] = true
+			}
+			var refs []string
+			for ref := range allRefs {
+				refs = append(refs, ref)
+			}
+			sort.Strings(refs)
+			for _, ref := range refs {
+				diff := RefDiff{Ref: ref, LocalCommit: localRefs[ref], RemoteCommit: remoteRefs[ref]}
+				if diff.LocalCommit == diff.RemoteCommit {
+					continue
+				}
+				if strings.HasPrefix(ref, "refs/notes/") {
+					diff.DifferingNotes = len(changedPaths(r.notesCommits[diff.LocalCommit].Notes,
+						remoteRepo.notesCommits[diff.RemoteCommit].Notes))
+				}
+				diffs = append(diffs, diff)
+			}
+		}
+		return nil
+	})
+	return diffs, err
+}
+
+// pushRefs copies the given refs to the remote repo, after checking that every update is a
+// fast-forward, unless the remote ref has the expected value given for it (if any).
+//
+// Both repos must be locked.
+func (r *MemoryRepo) pushRefs(remote string, remoteRepo *MemoryRepo, refs map[string]string, expected map[string]string) error {
+	for ref, value := range refs {
+		remoteValue, ok := remoteRepo.refs[ref]
+		if lease, forced := expected[ref]; forced {
+			if remoteValue != lease {
+				return PushRejectedError{Remote: remote}
+			}
+		} else if ok && !r.ancestors(value)[remoteValue] {
+			return PushRejectedError{Remote: remote}
+		}
+	}
+	remoteRepo.copyObjects(r)
+	for ref, value := range refs {
+		remoteRepo.refs[ref] = value
+	}
+	return nil
+}
+
+// PushNotesAndArchive pushes the given notes and archive refs to a remote repo.
+//
+// If the remote rejects the push because its refs have diverged from the local
+// ones, then the returned error is a PushRejectedError.
+func (r *MemoryRepo) PushNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	return r.withRemote(remote, func(remoteRepo *MemoryRepo) error {
+		notesRefs := r.matchingRefs(notesRefPattern, matchesRefSpec)
+		refs := r.matchingRefs(archiveRefPattern, matchesRefSpec)
+		for ref, value := range notesRefs {
+			refs[ref] = value
+		}
+		if err := r.pushRefs(remote, remoteRepo, refs, nil); err != nil {
+			return err
+		}
+		for ref, value := range notesRefs {
+			r.refs[getRemoteNotesRef(remote, ref)] = value
+		}
+		return nil
+	})
+}
+
+// ForcePushNotesAndArchive pushes the given notes and archive refs to a remote repo,
+// replacing the remote notes refs if they are still at the values last pulled from them.
+func (r *MemoryRepo) ForcePushNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	return r.withRemote(remote, func(remoteRepo *MemoryRepo) error {
+		notesRefs := r.matchingRefs(notesRefPattern, matchesRefSpec)
+		refs := r.matchingRefs(archiveRefPattern, matchesRefSpec)
+		expected := make(map[string]string)
+		for ref, value := range notesRefs {
+			refs[ref] = value
+			expected[ref] = r.refs[getRemoteNotesRef(remote, ref)]
+		}
+		if err := r.pushRefs(remote, remoteRepo, refs, expected); err != nil {
+			return err
+		}
+		for ref, value := range notesRefs {
+			r.refs[getRemoteNotesRef(remote, ref)] = value
+		}
+		return nil
+	})
+}
+
+// InitNotesRef creates the given notes ref, with no notes, if it does not already exist.
+func (r *MemoryRepo) InitNotesRef(notesRef string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.refs[notesRef]; ok {
+		return false, nil
+	}
+	r.writeNotes(notesRef, nil)
+	return true, nil
+}
+
+// ConfigureFetchRefSpecs adds the refspecs used by PullNotesAndArchive to the fetch
+// configuration of the given remote, unless they are already configured.
+func (r *MemoryRepo) ConfigureFetchRefSpecs(remote, notesRefPattern, archiveRefPattern string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := "remote." + remote + ".fetch"
+	var added []string
+	for _, refSpec := range getPullRefSpecs(remote, notesRefPattern, archiveRefPattern) {
+		configured := false
+		for _, existing := range r.config[key] {
+			configured = configured || existing == refSpec
+		}
+		if !configured {
+			r.config[key] = append(r.config[key], refSpec)
+			added = append(added, refSpec)
+		}
+	}
+	return added, nil
+}
+
+// PullNotesAndArchive fetches the contents of the given notes and archive refs
+// from a remote repo, and then merges the notes with the corresponding local notes
+// by taking the union of the notes for each annotated object.
+func (r *MemoryRepo) PullNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	return r.withRemote(remote, func(remoteRepo *MemoryRepo) error {
+		for ref, value := range remoteRepo.matchingRefs(archiveRefPattern, matchesRefSpec) {
+			if local, ok := r.refs[ref]; ok && !remoteRepo.ancestors(value)[local] {
+				return fmt.Errorf("The archive ref %q cannot be fast-forwarded to that of the remote %q", ref, remote)
+			}
+		}
+		r.copyObjects(remoteRepo)
+		for ref, value := range remoteRepo.matchingRefs(archiveRefPattern, matchesRefSpec) {
+			r.refs[ref] = value
+		}
+		for ref, remoteTip := range remoteRepo.matchingRefs(notesRefPattern, matchesRefSpec) {
+			r.refs[getRemoteNotesRef(remote, ref)] = remoteTip
+			localTip, ok := r.refs[ref]
+			switch {
+			case !ok || r.ancestors(remoteTip)[localTip]:
+				r.refs[ref] = remoteTip
+			case r.ancestors(localTip)[remoteTip]:
+			default:
+				merged := make(map[string]string)
+				for object, blob := range r.notesOf(ref) {
+					merged[object] = blob
+				}
+				for object, blob := range r.notesCommits[remoteTip].Notes {
+					merged[object] = unionNotes(merged[object], blob) + "\n"
+				}
+				r.writeNotes(ref, merged, localTip, remoteTip)
+			}
+		}
+		return nil
+	})
+}
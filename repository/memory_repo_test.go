@@ -0,0 +1,539 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// conformanceRepo is a repo for the conformance tests, along with a way of building its history.
+type conformanceRepo struct {
+	Repo
+	// commit adds a commit with the given name as its message, which adds the file "<name>.txt"
+	// to the files of its first parent, and records its hash under the name.
+	commit func(name string, parents ...string)
+	// setRef points the given ref at the named commit, and checks out "refs/heads/master".
+	setRef func(ref, name string)
+	// hashes holds the hash of each named commit.
+	hashes map[string]string
+}
+
+// name returns the name of the commit with the given hash, or else its message, so that
+// the results of the two kinds of repos can be compared.
+func (repo *conformanceRepo) name(t *testing.T, hash string) string {
+	for name, named := range repo.hashes {
+		if named == hash {
+			return name
+		}
+	}
+	message, err := repo.GetCommitMessage(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "(" + message + ")"
+}
+
+// names returns the names of the commits with the given hashes.
+func (repo *conformanceRepo) names(t *testing.T, hashes []string) []string {
+	var names []string
+	for _, hash := range hashes {
+		names = append(names, repo.name(t, hash))
+	}
+	return names
+}
+
+func newMemoryConformanceRepo(t *testing.T) *conformanceRepo {
+	memoryRepo := NewRepoWithHistory(nil)
+	repo := &conformanceRepo{Repo: memoryRepo, hashes: make(map[string]string)}
+	repo.commit = func(name string, parents ...string) {
+		var parentHashes []string
+		for _, parent := range parents {
+			parentHashes = append(parentHashes, repo.hashes[parent])
+		}
+		repo.hashes[name] = memoryRepo.AddCommit("", name, map[string]string{name + ".txt": name + "\n"}, parentHashes...)
+	}
+	repo.setRef = func(ref, name string) {
+		memoryRepo.SetRef(ref, repo.hashes[name])
+	}
+	return repo
+}
+
+func newGitConformanceRepo(t *testing.T) (*conformanceRepo, func()) {
+	gitRepo, cleanup := newTestGitRepo(t)
+	repo := &conformanceRepo{Repo: gitRepo, hashes: make(map[string]string)}
+	indexFile := filepath.Join(gitRepo.Path, ".git", "conformance-index")
+	// Every commit is a second newer than the last, as in a MemoryRepo, so that both are
+	// ordered the same way.
+	clock := 0
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = gitRepo.Path
+		date := fmt.Sprintf("%d +0000", memoryEpoch+clock)
+		cmd.Env = append(os.Environ(), "GIT_INDEX_FILE="+indexFile, "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("Failed to run git %v: %v", args, err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	repo.commit = func(name string, parents ...string) {
+		clock++
+		if len(parents) == 0 {
+			git("read-tree", "--empty")
+		} else {
+			git("read-tree", repo.hashes[parents[0]])
+		}
+		blob, err := gitRepo.runGitCommandWithStdin([]byte(name+"\n"), "hash-object", "-w", "--stdin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		git("update-index", "--add", "--cacheinfo", "100644,"+blob+","+name+".txt")
+		args := []string{"commit-tree", git("write-tree"), "-m", name}
+		for _, parent := range parents {
+			args = append(args, "-p", repo.hashes[parent])
+		}
+		repo.hashes[name] = git(args...)
+	}
+	repo.setRef = func(ref, name string) {
+		git("update-ref", ref, repo.hashes[name])
+		if _, err := gitRepo.runGitCommand("checkout", "-q", "-f", "master"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo, cleanup
+}
+
+// forEachConformanceRepo runs the given scenario against both a MemoryRepo and a GitRepo.
+func forEachConformanceRepo(t *testing.T, scenario func(t *testing.T, repo *conformanceRepo)) {
+	t.Run("memory", func(t *testing.T) {
+		scenario(t, newMemoryConformanceRepo(t))
+	})
+	t.Run("git", func(t *testing.T) {
+		// Accept the commit messages of merges and rebases without opening an editor.
+		defer os.Setenv("GIT_EDITOR", os.Getenv("GIT_EDITOR"))
+		os.Setenv("GIT_EDITOR", "true")
+		repo, cleanup := newGitConformanceRepo(t)
+		defer cleanup()
+		scenario(t, repo)
+	})
+}
+
+// buildForkedHistory builds the following history, with "master" at "E" and "feature" at "C":
+//
+//	A--B--D--E
+//	 \      /
+//	  C----
+func buildForkedHistory(repo *conformanceRepo) {
+	repo.commit("A")
+	repo.commit("B", "A")
+	repo.commit("C", "A")
+	repo.commit("D", "B")
+	repo.commit("E", "D", "C")
+	repo.setRef("refs/heads/feature", "C")
+	repo.setRef("refs/heads/master", "E")
+}
+
+func TestConformanceAncestry(t *testing.T) {
+	forEachConformanceRepo(t, func(t *testing.T, repo *conformanceRepo) {
+		buildForkedHistory(repo)
+		for _, test := range []struct {
+			ancestor, descendant string
+			expected             bool
+		}{
+			{"A", "E", true},
+			{"C", "E", true},
+			{"C", "D", false},
+			{"E", "A", false},
+			{"B", "B", true},
+		} {
+			isAncestor, err := repo.IsAncestor(repo.hashes[test.ancestor], repo.hashes[test.descendant])
+			if err != nil || isAncestor != test.expected {
+				t.Errorf("Unexpected ancestry of %s and %s: %v, %v", test.ancestor, test.descendant, isAncestor, err)
+			}
+		}
+		if base, err := repo.MergeBase(repo.hashes["D"], "refs/heads/feature"); err != nil || repo.name(t, base) != "A" {
+			t.Errorf("Unexpected merge base: %q, %v", base, err)
+		}
+		for _, test := range []struct {
+			from, to string
+			expected []string
+		}{
+			{"A", "E", []string{"B", "C", "D", "E"}},
+			{"B", "E", []string{"D", "E"}},
+			{"C", "E", []string{"E"}},
+			{"C", "D", nil},
+			{"E", "E", nil},
+		} {
+			commits, err := repo.ListCommitsBetween(repo.hashes[test.from], repo.hashes[test.to])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names := repo.names(t, commits); !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("Unexpected commits between %s and %s: %q", test.from, test.to, names)
+			}
+		}
+		ancestors, err := repo.FindAncestors([]string{repo.hashes["B"], repo.hashes["D"]}, "refs/heads/feature")
+		if err != nil || len(ancestors) != 0 {
+			t.Errorf("Unexpected ancestors of the feature branch: %v, %v", ancestors, err)
+		}
+		if parent, err := repo.GetLastParent(repo.hashes["E"]); err != nil || repo.name(t, parent) != "D" {
+			t.Errorf("Unexpected last parent: %q, %v", parent, err)
+		}
+	})
+}
+
+func TestConformanceRefs(t *testing.T) {
+	forEachConformanceRepo(t, func(t *testing.T, repo *conformanceRepo) {
+		buildForkedHistory(repo)
+		if err := repo.VerifyGitRef("refs/heads/feature"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.VerifyGitRef("refs/heads/missing"); err == nil {
+			t.Errorf("Verified a missing ref")
+		}
+		// As with "git for-each-ref", wildcards do not match slashes, and literal patterns match
+		// whole components.
+		for pattern, expected := range map[string]int{"refs/heads": 2, "refs/heads/*": 2, "refs/*/feature": 1, "refs/*": 0, "refs/hea": 0} {
+			if refs, err := repo.ListRefs(pattern); err != nil || len(refs) != expected {
+				t.Errorf("Unexpected refs matching %q: %v, %v", pattern, refs, err)
+			}
+		}
+		if err := repo.SwitchToRef("refs/heads/feature"); err != nil {
+			t.Fatal(err)
+		}
+		if head, err := repo.GetHeadRef(); err != nil || head != "refs/heads/feature" {
+			t.Errorf("Unexpected HEAD after switching to the feature branch: %q, %v", head, err)
+		}
+		if head, err := repo.GetCommitHash("HEAD"); err != nil || repo.name(t, head) != "C" {
+			t.Errorf("Unexpected HEAD commit: %q, %v", head, err)
+		}
+		if err := repo.SwitchToRef(repo.hashes["B"]); err != nil {
+			t.Fatal(err)
+		}
+		if head, err := repo.GetHeadRef(); err == nil {
+			t.Errorf("HEAD is not detached after switching to a commit: %q", head)
+		}
+		if err := repo.DeleteRef("refs/heads/feature"); err != nil {
+			t.Fatal(err)
+		}
+		refs, err := repo.ListRefs("refs/heads/*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(refs) != 1 || repo.name(t, refs["refs/heads/master"]) != "E" {
+			t.Errorf("Unexpected refs after deleting the feature branch: %v", refs)
+		}
+		if contents, err := repo.Show(repo.hashes["D"], "B.txt"); err != nil || contents != "B" {
+			t.Errorf("Unexpected contents of a file: %q, %v", contents, err)
+		}
+		if _, err := repo.Show(repo.hashes["D"], "C.txt"); err == nil {
+			t.Errorf("Showed a file that does not exist in the commit")
+		}
+		if diff, err := repo.Diff(repo.hashes["A"], repo.hashes["D"], "--name-only"); err != nil || diff != "B.txt\nD.txt" {
+			t.Errorf("Unexpected diff: %q, %v", diff, err)
+		}
+	})
+}
+
+func TestConformanceMergeRef(t *testing.T) {
+	forEachConformanceRepo(t, func(t *testing.T, repo *conformanceRepo) {
+		repo.commit("A")
+		repo.commit("B", "A")
+		repo.commit("C", "B")
+		repo.setRef("refs/heads/feature", "C")
+		repo.setRef("refs/heads/other", "A")
+		repo.setRef("refs/heads/master", "B")
+		if err := repo.MergeRef("refs/heads/feature", true, false); err != nil {
+			t.Fatal(err)
+		}
+		if head, err := repo.GetCommitHash("refs/heads/master"); err != nil || repo.name(t, head) != "C" {
+			t.Errorf("The target was not fast-forwarded: %q, %v", head, err)
+		}
+
+		repo.commit("D", "A")
+		repo.setRef("refs/heads/other", "D")
+		if err := repo.MergeRef("refs/heads/other", true, false); err != ErrNotFastForward {
+			t.Errorf("Unexpected result of fast-forwarding to a diverged ref: %v", err)
+		}
+		if err := repo.MergeRef("refs/heads/other", false, false, "Submitting other", "Description"); err != nil {
+			t.Fatal(err)
+		}
+		details, err := repo.GetCommitDetails("refs/heads/master")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parents := repo.names(t, details.Parents); !reflect.DeepEqual(parents, []string{"C", "D"}) {
+			t.Errorf("Unexpected parents of the merge commit: %q", parents)
+		}
+		if message, err := repo.GetCommitMessage("refs/heads/master"); err != nil || message != "Submitting other\n\nDescription" {
+			t.Errorf("Unexpected message of the merge commit: %q, %v", message, err)
+		}
+		if diff, err := repo.Diff(repo.hashes["C"], "refs/heads/master", "--name-only"); err != nil || diff != "D.txt" {
+			t.Errorf("Unexpected changes merged: %q, %v", diff, err)
+		}
+	})
+}
+
+func TestConformanceRebaseRef(t *testing.T) {
+	forEachConformanceRepo(t, func(t *testing.T, repo *conformanceRepo) {
+		repo.commit("A")
+		repo.commit("B", "A")
+		repo.commit("C", "A")
+		repo.commit("D", "C")
+		repo.setRef("refs/heads/feature", "D")
+		repo.setRef("refs/heads/master", "B")
+		if err := repo.SwitchToRef("refs/heads/feature"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.RebaseRef("refs/heads/master", false); err != nil {
+			t.Fatal(err)
+		}
+		commits, err := repo.ListCommitsBetween(repo.hashes["B"], "refs/heads/feature")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names := repo.names(t, commits); !reflect.DeepEqual(names, []string{"(C)", "(D)"}) {
+			t.Errorf("Unexpected commits after rebasing: %q", names)
+		}
+		if diff, err := repo.Diff(repo.hashes["D"], "refs/heads/feature", "--name-status"); err != nil || diff != "A\tB.txt" {
+			t.Errorf("Unexpected changes after rebasing: %q, %v", diff, err)
+		}
+		if err := repo.SwitchToRef("refs/heads/master"); err != nil {
+			t.Fatal(err)
+		}
+		// Rebasing onto a descendant fast-forwards, as "submit --rebase" relies on.
+		if err := repo.RebaseRef("refs/heads/feature", false); err != nil {
+			t.Fatal(err)
+		}
+		master, err := repo.GetCommitHash("refs/heads/master")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if feature, err := repo.GetCommitHash("refs/heads/feature"); err != nil || feature != master {
+			t.Errorf("The target was not fast-forwarded to the rebased ref: %q, %q, %v", master, feature, err)
+		}
+	})
+}
+
+func TestConformanceSquashRef(t *testing.T) {
+	forEachConformanceRepo(t, func(t *testing.T, repo *conformanceRepo) {
+		repo.commit("A")
+		repo.commit("B", "A")
+		repo.commit("C", "B")
+		repo.setRef("refs/heads/feature", "C")
+		repo.setRef("refs/heads/master", "A")
+		if err := repo.SquashRef("refs/heads/feature", false, "Submitting feature", "Description"); err != nil {
+			t.Fatal(err)
+		}
+		if parent, err := repo.GetLastParent("refs/heads/master"); err != nil || repo.name(t, parent) != "A" {
+			t.Errorf("Unexpected parent of the squashed commit: %q, %v", parent, err)
+		}
+		if message, err := repo.GetCommitMessage("refs/heads/master"); err != nil || message != "Submitting feature\n\nDescription" {
+			t.Errorf("Unexpected message of the squashed commit: %q, %v", message, err)
+		}
+		if diff, err := repo.Diff("refs/heads/master", "refs/heads/feature"); err != nil || diff != "" {
+			t.Errorf("Unexpected difference between the squashed commit and the ref: %q, %v", diff, err)
+		}
+	})
+}
+
+func TestConformanceNotes(t *testing.T) {
+	forEachConformanceRepo(t, func(t *testing.T, repo *conformanceRepo) {
+		repo.commit("A")
+		repo.commit("B", "A")
+		repo.setRef("refs/heads/master", "B")
+		revision := repo.hashes["B"]
+		if tip, err := repo.GetNotesTip(TestCommentsRef); err != nil || tip != "" {
+			t.Errorf("Unexpected tip of a missing notes ref: %q, %v", tip, err)
+		}
+		if notes := repo.GetNotes(TestCommentsRef, revision); len(notes) != 0 {
+			t.Errorf("Unexpected notes before any were written: %q", notes)
+		}
+		if err := repo.AppendNote(TestCommentsRef, revision, Note(`{"description": "First"}`)); err != nil {
+			t.Fatal(err)
+		}
+		firstTip, err := repo.GetNotesTip(TestCommentsRef)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.AppendNote(TestCommentsRef, revision, Note(`{"description": "Second"}`)); err != nil {
+			t.Fatal(err)
+		}
+		expected := []Note{Note(`{"description": "First"}`), Note(""), Note(`{"description": "Second"}`)}
+		if notes := repo.GetNotes(TestCommentsRef, revision); !reflect.DeepEqual(notes, expected) {
+			t.Errorf("Unexpected notes: %q", notes)
+		}
+		if all, err := repo.GetAllNotes(TestCommentsRef); err != nil || !reflect.DeepEqual(all[revision], expected) {
+			t.Errorf("Unexpected notes read in bulk: %q, %v", all, err)
+		}
+		blobs, err := repo.ListNotes(TestCommentsRef)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blob := gitBlobHash("{\"description\": \"First\"}\n\n{\"description\": \"Second\"}\n"); len(blobs) != 1 || blobs[revision] != blob {
+			t.Errorf("Unexpected note blobs: %v, want %q", blobs, blob)
+		}
+		if revisions := repo.ListNotedRevisions(TestCommentsRef); !reflect.DeepEqual(revisions, []string{revision}) {
+			t.Errorf("Unexpected noted revisions: %q", revisions)
+		}
+		secondTip, err := repo.GetNotesTip(TestCommentsRef)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed, err := repo.ListChangedNotes(firstTip, secondTip); err != nil || !reflect.DeepEqual(changed, []string{revision}) {
+			t.Errorf("Unexpected changed notes: %q, %v", changed, err)
+		}
+		if changed, err := repo.ListChangedNotes(secondTip, secondTip); err != nil || len(changed) != 0 {
+			t.Errorf("Unexpected changed notes of a single commit: %q, %v", changed, err)
+		}
+		if isAncestor, err := repo.IsAncestor(firstTip, secondTip); err != nil || !isAncestor {
+			t.Errorf("The notes commits do not form a history: %v, %v", isAncestor, err)
+		}
+
+		blob, err := repo.StoreBlob(TestCommentsRef, []byte("attachment\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blob != gitBlobHash("attachment\n") {
+			t.Errorf("Unexpected hash of a stored blob: %q", blob)
+		}
+		if revisions := repo.ListNotedRevisions(TestCommentsRef); !reflect.DeepEqual(revisions, []string{revision}) {
+			t.Errorf("A stored blob was listed as a noted revision: %q", revisions)
+		}
+		if err := repo.SetNotes(TestCommentsRef, revision, nil); err != nil {
+			t.Fatal(err)
+		}
+		if notes := repo.GetNotes(TestCommentsRef, revision); len(notes) != 0 {
+			t.Errorf("Unexpected notes after removing them: %q", notes)
+		}
+		if created, err := repo.InitNotesRef(TestRequestsRef); err != nil || !created {
+			t.Errorf("The notes ref was not initialized: %v, %v", created, err)
+		}
+		if refs, err := repo.ListNotesRefs("refs/notes/devtools/*"); err != nil || !reflect.DeepEqual(refs, []string{TestCommentsRef, TestRequestsRef}) {
+			t.Errorf("Unexpected notes refs: %q, %v", refs, err)
+		}
+	})
+}
+
+func TestConformanceCompactNotes(t *testing.T) {
+	forEachConformanceRepo(t, func(t *testing.T, repo *conformanceRepo) {
+		repo.commit("A")
+		repo.setRef("refs/heads/master", "A")
+		revision := repo.hashes["A"]
+		for _, note := range []string{"keep", "drop"} {
+			if err := repo.AppendNote(TestCommentsRef, revision, Note(note)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		oldTip, err := repo.GetNotesTip(TestCommentsRef)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backupRef := "refs/notes/devtools/backups/discuss"
+		err = repo.CompactNotes(TestCommentsRef, backupRef, func(object string, notes []Note) []Note {
+			var kept []Note
+			for _, note := range notes {
+				if string(note) == "keep" {
+					kept = append(kept, note)
+				}
+			}
+			return kept
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if notes := repo.GetNotes(TestCommentsRef, revision); !reflect.DeepEqual(notes, []Note{Note("keep")}) {
+			t.Errorf("Unexpected notes after compacting them: %q", notes)
+		}
+		if backup, err := repo.GetNotesTip(backupRef); err != nil || backup != oldTip {
+			t.Errorf("Unexpected backup of the notes: %q, %v", backup, err)
+		}
+	})
+}
+
+func TestMemoryRepoRemotes(t *testing.T) {
+	remote := NewRepoWithHistory(map[string][]string{"master": {"A"}})
+	local := NewRepoWithHistory(map[string][]string{"master": {"A"}})
+	local.AddRemote("origin", remote)
+	if err := remote.AppendNote(TestCommentsRef, "A", Note("remote")); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.AppendNote(TestCommentsRef, "A", Note("local")); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.PushNotesAndArchive("origin", "refs/notes/devtools/*", "refs/devtools/archives/*"); err == nil {
+		t.Errorf("Pushed notes that diverged from the remote ones")
+	}
+	if err := local.PullNotesAndArchive("origin", "refs/notes/devtools/*", "refs/devtools/archives/*"); err != nil {
+		t.Fatal(err)
+	}
+	if notes := local.GetNotes(TestCommentsRef, "A"); !reflect.DeepEqual(notes, []Note{Note("local"), Note("remote")}) {
+		t.Errorf("Unexpected notes after pulling: %q", notes)
+	}
+	if err := local.PushNotesAndArchive("origin", "refs/notes/devtools/*", "refs/devtools/archives/*"); err != nil {
+		t.Fatal(err)
+	}
+	if notes := remote.GetNotes(TestCommentsRef, "A"); !reflect.DeepEqual(notes, []Note{Note("local"), Note("remote")}) {
+		t.Errorf("Unexpected remote notes after pushing: %q", notes)
+	}
+
+	remote.AddCommit("B", "B", nil, "A")
+	remote.SetRef("refs/heads/master", "B")
+	if err := local.Fetch("origin"); err != nil {
+		t.Fatal(err)
+	}
+	if commit, err := local.ResolveRefCommit("refs/heads/feature"); err == nil {
+		t.Errorf("Resolved a branch that no remote has: %q", commit)
+	}
+	local.DeleteRef("refs/heads/master")
+	if commit, err := local.ResolveRefCommit("refs/heads/master"); err != nil || commit != "B" {
+		t.Errorf("Unexpected commit of the remote branch: %q, %v", commit, err)
+	}
+}
+
+func TestMemoryRepoFormatPatch(t *testing.T) {
+	repo := NewRepoWithHistory(map[string][]string{"master": {"A", "B", "C"}})
+	dir, err := ioutil.TempDir("", "git-appraise-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	paths, err := repo.FormatPatch("A", "C", dir, []string{"Reviewed-by: user@example.com"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 || filepath.Base(paths[0]) != "0001-B.patch" {
+		t.Fatalf("Unexpected patches: %q", paths)
+	}
+	patch, err := ioutil.ReadFile(paths[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"From C ", "Subject: [PATCH 2/2] C\n", "Reviewed-by: user@example.com\n---\n",
+		"--- /dev/null\n+++ b/C.txt\n@@ -0,0 +1 @@\n+C\n"} {
+		if !strings.Contains(string(patch), expected) {
+			t.Errorf("The patch does not contain %q:\n%s", expected, patch)
+		}
+	}
+}
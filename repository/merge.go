@@ -0,0 +1,50 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// unionNotes merges the contents of two notes annotating the same object.
+//
+// Every line of a note is an independent, append-only JSON value, so the merged
+// note is simply every distinct line from either side: first the local lines and
+// then any remote lines not already present. Lines are compared by their compacted
+// JSON form, so values that only differ in insignificant whitespace are de-duplicated.
+func unionNotes(local, remote string) string {
+	seen := make(map[string]bool)
+	var lines []string
+	for _, line := range append(splitLines(local), splitLines(remote)...) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		key := line
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, []byte(line)); err == nil {
+			key = compacted.String()
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"testing"
+)
+
+const (
+	testRemote         = "origin"
+	testNotesPattern   = "refs/notes/devtools/*"
+	testArchivePattern = "refs/devtools/archives/*"
+
+	testRemoteDiscussB = `{"timestamp": "0000000005", "author": "someone", "location": {"commit": "B"}, "description": "Remote comment"}`
+	testRemoteDiscussG = `{"timestamp": "0000000006", "author": "someone", "location": {"commit": "G"}, "description": "Remote comment"}`
+	// testReformattedDiscussB is the same JSON value as TestDiscussB, with different whitespace.
+	testReformattedDiscussB = `{"timestamp":"0000000001","author":"ojarjur","location":{"commit":"B"},"resolved":true}`
+)
+
+func pullWithRemoteNotes(t *testing.T, remoteNotes map[string]string) Repo {
+	repo := NewMockRepoForTest()
+	repo.(mockRepoForTest).Remotes[testRemote] = map[string]map[string]string{
+		TestCommentsRef: remoteNotes,
+	}
+	if err := repo.PullNotesAndArchive(testRemote, testNotesPattern, testArchivePattern); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func validateNotes(t *testing.T, repo Repo, revision string, expected ...string) {
+	var notes []string
+	for _, note := range repo.GetNotes(TestCommentsRef, revision) {
+		if string(note) != "" {
+			notes = append(notes, string(note))
+		}
+	}
+	if len(notes) != len(expected) {
+		t.Fatalf("Unexpected notes for %q: %q", revision, notes)
+	}
+	for i, note := range notes {
+		if note != expected[i] {
+			t.Fatalf("Unexpected notes for %q: %q", revision, notes)
+		}
+	}
+}
+
+func TestMergeNotesOnTheSameObject(t *testing.T) {
+	repo := pullWithRemoteNotes(t, map[string]string{
+		TestCommitB: TestDiscussB + "\n" + testRemoteDiscussB,
+	})
+	validateNotes(t, repo, TestCommitB, TestDiscussB, testRemoteDiscussB)
+	validateNotes(t, repo, TestCommitD, TestDiscussD)
+}
+
+func TestMergeNotesOnDisjointObjects(t *testing.T) {
+	repo := pullWithRemoteNotes(t, map[string]string{
+		TestCommitG: testRemoteDiscussG,
+	})
+	validateNotes(t, repo, TestCommitB, TestDiscussB)
+	validateNotes(t, repo, TestCommitD, TestDiscussD)
+	validateNotes(t, repo, TestCommitG, testRemoteDiscussG)
+}
+
+func TestMergeIdenticalNotes(t *testing.T) {
+	repo := pullWithRemoteNotes(t, map[string]string{
+		TestCommitB: testReformattedDiscussB,
+		TestCommitD: TestDiscussD,
+	})
+	validateNotes(t, repo, TestCommitB, TestDiscussB)
+	validateNotes(t, repo, TestCommitD, TestDiscussD)
+}
+
+func TestUnionNotesWithCRLF(t *testing.T) {
+	merged := unionNotes(TestDiscussB+"\r\n\r\n"+TestDiscussD+"\r\n", TestDiscussB+"\n")
+	if expected := TestDiscussB + "\n" + TestDiscussD; merged != expected {
+		t.Fatalf("Unexpected union of notes with CRLF line endings: %q", merged)
+	}
+}
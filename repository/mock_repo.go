@@ -17,10 +17,14 @@ limitations under the License.
 package repository
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 )
 
 // Constants used for testing.
@@ -72,6 +76,13 @@ type mockRepoForTest struct {
 	Refs    map[string]string            `json:"refs,omitempty"`
 	Commits map[string]mockCommit        `json:"commits,omitempty"`
 	Notes   map[string]map[string]string `json:"notes,omitempty"`
+	Config  map[string][]string          `json:"config,omitempty"`
+	// Remotes holds the notes in each remote repo, keyed by remote, then notes ref, then revision.
+	Remotes map[string]map[string]map[string]string `json:"remotes,omitempty"`
+	// notesMutex guards the Notes map, so that notes may be written concurrently.
+	notesMutex *sync.Mutex
+	// notesSnapshots holds the notes of each tip returned by GetNotesTip, keyed by that tip.
+	notesSnapshots map[string]map[string]string
 }
 
 func NewMockRepoForTest() Repo {
@@ -126,7 +137,11 @@ func NewMockRepoForTest() Repo {
 		Parents: []string{TestCommitF},
 	}
 	return mockRepoForTest{
-		Head: TestTargetRef,
+		Head:           TestTargetRef,
+		Config:         make(map[string][]string),
+		notesMutex:     &sync.Mutex{},
+		notesSnapshots: make(map[string]map[string]string),
+		Remotes:        make(map[string]map[string]map[string]string),
 		Refs: map[string]string{
 			TestTargetRef: TestCommitJ,
 			TestReviewRef: TestCommitI,
@@ -160,6 +175,25 @@ func NewMockRepoForTest() Repo {
 // GetPath returns the path to the repo.
 func (r mockRepoForTest) GetPath() string { return "~/mockRepo/" }
 
+// Context returns the context that stops the repo's operations once it is done.
+func (r mockRepoForTest) Context() context.Context { return context.Background() }
+
+// WithContext returns the repo itself, since none of its operations can be stopped.
+func (r mockRepoForTest) WithContext(ctx context.Context) Repo { return r }
+
+// IsBare returns whether or not the repository is bare, i.e. has no working tree.
+func (r mockRepoForTest) IsBare() (bool, error) { return false, nil }
+
+// IsShallow returns whether or not the repository is a shallow clone, missing some of its history.
+func (r mockRepoForTest) IsShallow() (bool, error) { return false, nil }
+
+// GetGitDir returns the path to the directory holding the repo's git metadata.
+//
+// The mock repo has no such directory, so that nothing is ever written to disk on its behalf.
+func (r mockRepoForTest) GetGitDir() (string, error) {
+	return "", errors.New("The mock repo has no git directory")
+}
+
 // GetRepoStateHash returns a hash which embodies the entire current state of a repository.
 func (r mockRepoForTest) GetRepoStateHash() (string, error) {
 	repoJson, err := json.Marshal(r)
@@ -172,9 +206,113 @@ func (r mockRepoForTest) GetRepoStateHash() (string, error) {
 // GetUserEmail returns the email address that the user has used to configure git.
 func (r mockRepoForTest) GetUserEmail() (string, error) { return "user@example.com", nil }
 
+// GetConfigValues returns all of the values set for the given git config key.
+func (r mockRepoForTest) GetConfigValues(key string) ([]string, error) { return r.Config[key], nil }
+
+// GetConfigValuesWithOrigin returns all of the values set for the given git config key, which are all local.
+func (r mockRepoForTest) GetConfigValuesWithOrigin(key string) ([]ConfigValue, error) {
+	var values []ConfigValue
+	for _, value := range r.Config[key] {
+		values = append(values, ConfigValue{Value: value, Origin: "local"})
+	}
+	return values, nil
+}
+
+// SetConfigValue sets the given git config key to the given value, replacing any previous values.
+func (r mockRepoForTest) SetConfigValue(key, value string) error {
+	r.Config[key] = []string{value}
+	return nil
+}
+
+// SignPayload signs the given data with the user's signing key, and returns the armored signature.
+//
+// The mock signature is just the user's email address along with a hash of the data.
+func (r mockRepoForTest) SignPayload(payload []byte) (string, error) {
+	email, err := r.GetUserEmail()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("mock signature by %s of %x", email, sha1.Sum(payload)), nil
+}
+
+// VerifySignature verifies the given armored signature of the given data, and returns the identity of the signer.
+func (r mockRepoForTest) VerifySignature(payload []byte, signature string) (string, error) {
+	var signer, hash string
+	if _, err := fmt.Sscanf(signature, "mock signature by %s of %s", &signer, &hash); err != nil {
+		return "", fmt.Errorf("Malformed mock signature %q", signature)
+	}
+	if hash != fmt.Sprintf("%x", sha1.Sum(payload)) {
+		return "", errors.New("The signature does not match the data.")
+	}
+	return signer, nil
+}
+
+// ListRemotes returns the names of all of the remotes configured for the repo.
+func (r mockRepoForTest) ListRemotes() ([]string, error) {
+	var remotes []string
+	for remote := range r.Remotes {
+		remotes = append(remotes, remote)
+	}
+	sort.Strings(remotes)
+	return remotes, nil
+}
+
 // HasUncommittedChanges returns true if there are local, uncommitted changes.
 func (r mockRepoForTest) HasUncommittedChanges() (bool, error) { return false, nil }
 
+// StashChanges saves the local, uncommitted changes under the given message, of which the mock has none.
+func (r mockRepoForTest) StashChanges(message string) (string, error) { return "", nil }
+
+// RestoreStash reapplies the changes saved by StashChanges.
+func (r mockRepoForTest) RestoreStash(stash string) error { return nil }
+
+// SnapshotChanges records the uncommitted changes in a new commit, but the mock has none.
+func (r mockRepoForTest) SnapshotChanges(ref, message string, staged bool) (string, error) {
+	return "", ErrNoChanges
+}
+
+// DeleteRef deletes the given ref.
+func (r mockRepoForTest) DeleteRef(ref string) error {
+	delete(r.Refs, ref)
+	return nil
+}
+
+// UpdateRef points the given ref at the given commit, or deletes it if the commit is empty,
+// but only if the ref still points at oldValue. For the notes refs, the values are the tips
+// returned by GetNotesTip.
+func (r mockRepoForTest) UpdateRef(ref, newValue, oldValue string) error {
+	current, err := r.GetNotesTip(ref)
+	if err != nil {
+		return err
+	}
+	if current == "" {
+		current = r.Refs[ref]
+	}
+	if current != oldValue {
+		return fmt.Errorf("Cannot update %s, as it points at %q rather than %q", ref, current, oldValue)
+	}
+	r.notesMutex.Lock()
+	defer r.notesMutex.Unlock()
+	if snapshot, ok := r.notesSnapshots[newValue]; ok {
+		notes := make(map[string]string)
+		for revision, note := range snapshot {
+			notes[revision] = note
+		}
+		r.Notes[ref] = notes
+		return nil
+	}
+	delete(r.Notes, ref)
+	if newValue == "" {
+		delete(r.Refs, ref)
+	} else {
+		r.Refs[ref] = newValue
+	}
+	return nil
+}
+
+// DeleteRemoteRef deletes the given ref from the given remote repo, which the mock repo does not have.
+func (r mockRepoForTest) DeleteRemoteRef(remote, ref string) error { return nil }
+
 func (r mockRepoForTest) resolveLocalRef(ref string) (string, error) {
 	if commit, ok := r.Refs[ref]; ok {
 		return commit, nil
@@ -182,7 +320,7 @@ func (r mockRepoForTest) resolveLocalRef(ref string) (string, error) {
 	if _, ok := r.Commits[ref]; ok {
 		return ref, nil
 	}
-	return "", fmt.Errorf("The ref %q does not exist", ref)
+	return "", RefMissingError{Ref: ref}
 }
 
 // VerifyCommit verifies that the supplied hash points to a known commit.
@@ -200,10 +338,20 @@ func (r mockRepoForTest) VerifyGitRef(ref string) error {
 }
 
 // GetHeadRef returns the ref that is the current HEAD.
-func (r mockRepoForTest) GetHeadRef() (string, error) { return r.Head, nil }
+//
+// If the Head field holds a commit rather than a ref, then HEAD is treated as detached.
+func (r mockRepoForTest) GetHeadRef() (string, error) {
+	if _, ok := r.Commits[r.Head]; ok {
+		return "", errors.New("HEAD is detached")
+	}
+	return r.Head, nil
+}
 
 // GetCommitHash returns the hash of the commit pointed to by the given ref.
 func (r mockRepoForTest) GetCommitHash(ref string) (string, error) {
+	if ref == "HEAD" {
+		return r.resolveLocalRef(r.Head)
+	}
 	err := r.VerifyGitRef(ref)
 	if err != nil {
 		return "", err
@@ -311,6 +459,21 @@ func (r mockRepoForTest) IsAncestor(ancestor, descendant string) (bool, error) {
 	return false, nil
 }
 
+// FindAncestors determines which of the given commits are ancestors of the given descendant.
+func (r mockRepoForTest) FindAncestors(commits []string, descendant string) (map[string]bool, error) {
+	ancestors := make(map[string]bool)
+	for _, commit := range commits {
+		isAncestor, err := r.IsAncestor(commit, descendant)
+		if err != nil {
+			return nil, err
+		}
+		if isAncestor {
+			ancestors[commit] = true
+		}
+	}
+	return ancestors, nil
+}
+
 // MergeBase determines if the first commit that is an ancestor of the two arguments.
 func (r mockRepoForTest) MergeBase(a, b string) (string, error) {
 	ancestors, err := r.ancestors(a)
@@ -335,6 +498,9 @@ func (r mockRepoForTest) Show(commit, path string) (string, error) {
 	return fmt.Sprintf("%s:%s", commit, path), nil
 }
 
+// IsSubmodule returns whether or not the given path is a submodule at the given commit.
+func (r mockRepoForTest) IsSubmodule(commit, path string) (bool, error) { return false, nil }
+
 // SwitchToRef changes the currently-checked-out ref.
 func (r mockRepoForTest) SwitchToRef(ref string) error {
 	r.Head = ref
@@ -345,10 +511,26 @@ func (r mockRepoForTest) SwitchToRef(ref string) error {
 //
 // The ref argument is the ref to merge, and fastForward indicates that the
 // current ref should only move forward, as opposed to creating a bubble merge.
-func (r mockRepoForTest) MergeRef(ref string, fastForward bool, messages ...string) error { return nil }
+func (r mockRepoForTest) MergeRef(ref string, fastForward, sign bool, messages ...string) error {
+	return nil
+}
 
 // RebaseRef rebases the given ref into the current one.
-func (r mockRepoForTest) RebaseRef(ref string) error { return nil }
+func (r mockRepoForTest) RebaseRef(ref string, sign bool) error { return nil }
+
+// SquashRef squashes the changes in the given ref into a single new commit on the current one.
+func (r mockRepoForTest) SquashRef(ref string, sign bool, messages ...string) error {
+	return nil
+}
+
+// CommitMerge commits a merge or squash that stopped partway because of conflicts.
+func (r mockRepoForTest) CommitMerge(sign bool, messages ...string) error { return nil }
+
+// AbortMerge abandons a merge, rebase, or squash that failed partway.
+func (r mockRepoForTest) AbortMerge() error { return nil }
+
+// MergeInProgress returns the kind of merge that was started but not yet finished.
+func (r mockRepoForTest) MergeInProgress() (string, []string, error) { return "", nil, nil }
 
 // ListCommitsBetween returns the list of commits between the two given revisions.
 //
@@ -360,8 +542,22 @@ func (r mockRepoForTest) RebaseRef(ref string) error { return nil }
 // The generated list is in chronological order (with the oldest commit first).
 func (r mockRepoForTest) ListCommitsBetween(from, to string) ([]string, error) { return nil, nil }
 
+// ListCommits returns the details of the commits between the two given revisions.
+func (r mockRepoForTest) ListCommits(from, to string) ([]Commit, error) {
+	return listCommitDetails(r, from, to)
+}
+
+// FormatPatch writes the commits between the two given revisions as a series of email patches.
+//
+// Since there are no commits between any two mock revisions, this writes nothing.
+func (r mockRepoForTest) FormatPatch(from, to, outputDir string, trailers []string, coverLetter string) ([]string, error) {
+	return nil, nil
+}
+
 // GetNotes reads the notes from the given ref that annotate the given revision.
 func (r mockRepoForTest) GetNotes(notesRef, revision string) []Note {
+	r.notesMutex.Lock()
+	defer r.notesMutex.Unlock()
 	notesText := r.Notes[notesRef][revision]
 	var notes []Note
 	for _, line := range strings.Split(notesText, "\n") {
@@ -370,16 +566,48 @@ func (r mockRepoForTest) GetNotes(notesRef, revision string) []Note {
 	return notes
 }
 
+// GetAllNotes reads all of the notes from the given ref, keyed by the annotated object.
+func (r mockRepoForTest) GetAllNotes(notesRef string) (map[string][]Note, error) {
+	r.notesMutex.Lock()
+	defer r.notesMutex.Unlock()
+	notes := make(map[string][]Note)
+	for revision, notesText := range r.Notes[notesRef] {
+		for _, line := range strings.Split(notesText, "\n") {
+			notes[revision] = append(notes[revision], Note(line))
+		}
+	}
+	return notes, nil
+}
+
 // AppendNote appends a note to a revision under the given ref.
 func (r mockRepoForTest) AppendNote(ref, revision string, note Note) error {
+	r.notesMutex.Lock()
+	defer r.notesMutex.Unlock()
+	if _, ok := r.Notes[ref]; !ok {
+		r.Notes[ref] = make(map[string]string)
+	}
 	existingNotes := r.Notes[ref][revision]
 	newNotes := existingNotes + "\n" + string(note)
 	r.Notes[ref][revision] = newNotes
 	return nil
 }
 
+// StoreBlob writes the given contents to a git blob and returns its hash.
+func (r mockRepoForTest) StoreBlob(notesRef string, contents []byte) (string, error) {
+	hash := fmt.Sprintf("%x", sha1.Sum(contents))
+	r.notesMutex.Lock()
+	defer r.notesMutex.Unlock()
+	if _, ok := r.Notes[notesRef]; !ok {
+		r.Notes[notesRef] = make(map[string]string)
+	}
+	r.Notes[notesRef][hash] = string(contents)
+	return hash, nil
+}
+
 // ListNotedRevisions returns the collection of revisions that are annotated by notes in the given ref.
 func (r mockRepoForTest) ListNotedRevisions(notesRef string) []string {
+	r.notesMutex.Lock()
+	defer r.notesMutex.Unlock()
 	var revisions []string
 	for revision := range r.Notes[notesRef] {
 		if _, ok := r.Commits[revision]; ok {
@@ -389,10 +617,228 @@ func (r mockRepoForTest) ListNotedRevisions(notesRef string) []string {
 	return revisions
 }
 
-// PushNotes pushes git notes to a remote repo.
-func (r mockRepoForTest) PushNotes(remote, notesRefPattern string) error { return nil }
+// ListNotesRefs returns the sorted names of the notes refs matching the given pattern.
+func (r mockRepoForTest) ListNotesRefs(refPattern string) ([]string, error) {
+	r.notesMutex.Lock()
+	defer r.notesMutex.Unlock()
+	prefix := strings.TrimSuffix(refPattern, "*")
+	var refs []string
+	for ref := range r.Notes {
+		if strings.HasPrefix(ref, prefix) {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Strings(refs)
+	return refs, nil
+}
+
+// ListRefs returns the objects that the refs matching the given pattern point to, keyed by ref.
+func (r mockRepoForTest) ListRefs(refPattern string) (map[string]string, error) {
+	prefix := strings.TrimSuffix(refPattern, "*")
+	refs := make(map[string]string)
+	for ref, commit := range r.Refs {
+		if strings.HasPrefix(ref, prefix) {
+			refs[ref] = commit
+		}
+	}
+	return refs, nil
+}
+
+// ListNotes returns the hash of the note blob for every object annotated in the given notes ref,
+// keyed by the hash of the annotated object.
+func (r mockRepoForTest) ListNotes(notesRef string) (map[string]string, error) {
+	r.notesMutex.Lock()
+	defer r.notesMutex.Unlock()
+	notes := make(map[string]string)
+	for object, text := range r.Notes[notesRef] {
+		notes[object] = fmt.Sprintf("%x", sha1.Sum([]byte(text)))
+	}
+	return notes, nil
+}
+
+// SetNotes replaces the notes annotating the given object under the given ref. If
+// there are no notes given, then the existing notes are removed.
+func (r mockRepoForTest) SetNotes(notesRef, revision string, notes []Note) error {
+	r.notesMutex.Lock()
+	defer r.notesMutex.Unlock()
+	if len(notes) == 0 {
+		delete(r.Notes[notesRef], revision)
+		return nil
+	}
+	if _, ok := r.Notes[notesRef]; !ok {
+		r.Notes[notesRef] = make(map[string]string)
+	}
+	var lines []string
+	for _, note := range notes {
+		lines = append(lines, string(note))
+	}
+	r.Notes[notesRef][revision] = strings.Join(lines, "\n")
+	return nil
+}
+
+// CompactNotes rewrites every note under the given ref using the given function, in a
+// single new commit on the notes ref, and records the previous tip of the notes ref
+// under the given backup ref. Objects left without any notes are no longer annotated.
+//
+// If the function does not change any notes, then neither ref is updated.
+func (r mockRepoForTest) CompactNotes(notesRef, backupRef string, compact func(object string, notes []Note) []Note) error {
+	r.notesMutex.Lock()
+	defer r.notesMutex.Unlock()
+	oldNotes := make(map[string]string)
+	newNotes := make(map[string]string)
+	changed := false
+	for object, text := range r.Notes[notesRef] {
+		oldNotes[object] = text
+		var notes []Note
+		for _, line := range strings.Split(text, "\n") {
+			notes = append(notes, Note(line))
+		}
+		var lines []string
+		for _, note := range compact(object, notes) {
+			lines = append(lines, string(note))
+		}
+		compacted := strings.Join(lines, "\n")
+		if compacted != text {
+			changed = true
+		}
+		if len(lines) > 0 {
+			newNotes[object] = compacted
+		}
+	}
+	if !changed {
+		return nil
+	}
+	// The mock repo has no notes commits, so the backup ref points to a snapshot of the old notes.
+	oldNotesBytes, err := json.Marshal(oldNotes)
+	if err != nil {
+		return err
+	}
+	oldTip := fmt.Sprintf("%x", sha1.Sum(append([]byte(notesRef), oldNotesBytes...)))
+	r.notesSnapshots[oldTip] = oldNotes
+	r.Refs[backupRef] = oldTip
+	r.Notes[notesRef] = newNotes
+	return nil
+}
+
+// Fetch updates the remote-tracking refs from the given remote repo.
+func (r mockRepoForTest) Fetch(remote string) error { return nil }
+
+// AddWorktree checks out the given commit into a new, detached worktree at the given path.
+func (r mockRepoForTest) AddWorktree(path, commit string) error { return r.VerifyCommit(commit) }
+
+// RemoveWorktree removes a worktree previously created with AddWorktree.
+func (r mockRepoForTest) RemoveWorktree(path string) error { return nil }
+
+// GetNotesTip returns the commit that the given notes ref currently points to, or
+// the empty string if the ref does not exist.
+//
+// The mock repo has no notes commits, so this returns a hash of the current notes,
+// and remembers them so that they can be compared by ListChangedNotes.
+func (r mockRepoForTest) GetNotesTip(notesRef string) (string, error) {
+	r.notesMutex.Lock()
+	defer r.notesMutex.Unlock()
+	notes, ok := r.Notes[notesRef]
+	if !ok {
+		return "", nil
+	}
+	snapshot := make(map[string]string)
+	for revision, note := range notes {
+		snapshot[revision] = note
+	}
+	snapshotBytes, err := json.Marshal(snapshot)
+	if err != nil {
+		return "", err
+	}
+	tip := fmt.Sprintf("%x", sha1.Sum(append([]byte(notesRef), snapshotBytes...)))
+	r.notesSnapshots[tip] = snapshot
+	return tip, nil
+}
+
+// ListChangedNotes returns the sorted list of annotated objects whose notes differ
+// between two commits of a notes ref. Either commit may be the empty string, which
+// stands for there being no notes at all.
+func (r mockRepoForTest) ListChangedNotes(leftCommit, rightCommit string) ([]string, error) {
+	r.notesMutex.Lock()
+	defer r.notesMutex.Unlock()
+	leftNotes, rightNotes := r.notesSnapshots[leftCommit], r.notesSnapshots[rightCommit]
+	var changed []string
+	for revision, note := range leftNotes {
+		if rightNote, ok := rightNotes[revision]; !ok || rightNote != note {
+			changed = append(changed, revision)
+		}
+	}
+	for revision := range rightNotes {
+		if _, ok := leftNotes[revision]; !ok {
+			changed = append(changed, revision)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// DiffRemoteRefs reports the refs matching the given patterns whose values differ
+// between the local repo and the given remote, without updating any local refs.
+func (r mockRepoForTest) DiffRemoteRefs(remote string, refPatterns ...string) ([]RefDiff, error) {
+	return nil, nil
+}
+
+// PushNotesAndArchive pushes the given notes and archive refs to a remote repo.
+func (r mockRepoForTest) PushNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	return nil
+}
+
+// ForcePushNotesAndArchive pushes the given notes and archive refs to a remote repo,
+// replacing the remote notes refs if they are still at the values last pulled from them.
+func (r mockRepoForTest) ForcePushNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	return nil
+}
+
+// InitNotesRef creates the given notes ref, with no notes, if it does not already exist.
+func (r mockRepoForTest) InitNotesRef(notesRef string) (bool, error) {
+	r.notesMutex.Lock()
+	defer r.notesMutex.Unlock()
+	if _, ok := r.Notes[notesRef]; ok {
+		return false, nil
+	}
+	r.Notes[notesRef] = make(map[string]string)
+	return true, nil
+}
+
+// ConfigureFetchRefSpecs adds the refspecs used by PullNotesAndArchive to the fetch
+// configuration of the given remote, unless they are already configured.
+func (r mockRepoForTest) ConfigureFetchRefSpecs(remote, notesRefPattern, archiveRefPattern string) ([]string, error) {
+	key := "remote." + remote + ".fetch"
+	var added []string
+	for _, refSpec := range getPullRefSpecs(remote, notesRefPattern, archiveRefPattern) {
+		configured := false
+		for _, existing := range r.Config[key] {
+			configured = configured || existing == refSpec
+		}
+		if !configured {
+			r.Config[key] = append(r.Config[key], refSpec)
+			added = append(added, refSpec)
+		}
+	}
+	return added, nil
+}
 
-// PullNotes fetches the contents of the given notes ref from a remote repo,
-// and then merges them with the corresponding local notes using the
-// "cat_sort_uniq" strategy.
-func (r mockRepoForTest) PullNotes(remote, notesRefPattern string) error { return nil }
+// PullNotesAndArchive fetches the contents of the given notes and archive refs
+// from a remote repo, and then merges the notes with the corresponding local notes
+// by taking the union of the notes for each annotated object.
+func (r mockRepoForTest) PullNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	r.notesMutex.Lock()
+	defer r.notesMutex.Unlock()
+	notesRefPrefix := strings.TrimSuffix(notesRefPattern, "*")
+	for ref, remoteNotes := range r.Remotes[remote] {
+		if !strings.HasPrefix(ref, notesRefPrefix) {
+			continue
+		}
+		if _, ok := r.Notes[ref]; !ok {
+			r.Notes[ref] = make(map[string]string)
+		}
+		for revision, notes := range remoteNotes {
+			r.Notes[ref][revision] = unionNotes(r.Notes[ref][revision], notes)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,467 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Mutation describes a single change to a repository, made through a MutationRecorder.
+type Mutation struct {
+	// Operation is the name of the Repo method that makes the change, such as "AppendNote".
+	Operation string
+	// Ref is the ref (or config key) that the change updates, if any.
+	Ref string
+	// Previous is what Ref pointed to before the change, if Ref is a single ref (or "HEAD",
+	// in which case it is the checked out ref, or the commit if HEAD was detached). It is
+	// empty if the ref did not exist, or if the change was only recorded.
+	Previous string
+	// Revision is the object whose notes the change updates, for the notes operations.
+	Revision string
+	// Note holds the note being written, for AppendNote, or the notes being set, for SetNotes.
+	Note Note
+	// Description describes the change for people, such as "merge refs/heads/feature into refs/heads/master".
+	Description string
+}
+
+// mutationLog holds the mutations recorded by a MutationRecorder, which is shared with the
+// copies returned by WithContext.
+type mutationLog struct {
+	mutex     sync.Mutex
+	mutations []Mutation
+	// head is the ref that HEAD would point to, if a SwitchToRef was only recorded.
+	head string
+}
+
+// MutationRecorder wraps a Repo, and records every change that is made through it. All
+// of the reads are passed through to the wrapped repo.
+//
+// In record-only mode, the changes are recorded but not made, so that a command can be run
+// to see what it would do. Each such write reports success, and returns a plausible result
+// (such as the hash that a stored blob would have), but the reads that follow it still see
+// the repository as it was. The exception is the temporary worktrees used to inspect commits,
+// which are still added and removed.
+type MutationRecorder struct {
+	Repo
+	recordOnly bool
+	log        *mutationLog
+}
+
+// NewMutationRecorder returns a MutationRecorder that wraps the given repo, and if recordOnly
+// is true, does not actually change it.
+func NewMutationRecorder(repo Repo, recordOnly bool) *MutationRecorder {
+	return &MutationRecorder{Repo: repo, recordOnly: recordOnly, log: &mutationLog{}}
+}
+
+// IsRecordOnly returns whether or not the given repo is a MutationRecorder in record-only mode,
+// for the commands that can describe what they would do better than their mutations can.
+func IsRecordOnly(repo Repo) bool {
+	recorder, ok := repo.(*MutationRecorder)
+	return ok && recorder.recordOnly
+}
+
+// Mutations returns the changes recorded so far, in the order that they were made.
+func (r *MutationRecorder) Mutations() []Mutation {
+	r.log.mutex.Lock()
+	defer r.log.mutex.Unlock()
+	return append([]Mutation(nil), r.log.mutations...)
+}
+
+// record makes the given change by calling apply, unless the recorder is in record-only
+// mode, and records it if it succeeds.
+func (r *MutationRecorder) record(mutation Mutation, apply func() error) error {
+	if !r.recordOnly {
+		mutation.Previous = r.currentValue(mutation.Ref)
+		if err := apply(); err != nil {
+			return err
+		}
+	}
+	r.log.mutex.Lock()
+	defer r.log.mutex.Unlock()
+	r.log.mutations = append(r.log.mutations, mutation)
+	return nil
+}
+
+// currentValue returns what the given ref currently points to, as described for Mutation.Previous.
+func (r *MutationRecorder) currentValue(ref string) string {
+	if ref == "HEAD" {
+		if head, err := r.Repo.GetHeadRef(); err == nil {
+			return head
+		}
+		head, _ := r.Repo.GetCommitHash("HEAD")
+		return head
+	}
+	if !strings.HasPrefix(ref, "refs/") || strings.Contains(ref, "*") {
+		return ""
+	}
+	value, _ := r.Repo.GetNotesTip(ref)
+	return value
+}
+
+// currentHead returns the ref that HEAD points to, taking into account any recorded SwitchToRef.
+func (r *MutationRecorder) currentHead() string {
+	r.log.mutex.Lock()
+	head := r.log.head
+	r.log.mutex.Unlock()
+	if head != "" {
+		return head
+	}
+	head, err := r.Repo.GetHeadRef()
+	if err != nil {
+		return "HEAD"
+	}
+	return head
+}
+
+// WithContext returns a copy of the recorder, which records to the same list of mutations,
+// wrapping a copy of the repo whose operations are stopped once the given context is done.
+func (r *MutationRecorder) WithContext(ctx context.Context) Repo {
+	return &MutationRecorder{Repo: r.Repo.WithContext(ctx), recordOnly: r.recordOnly, log: r.log}
+}
+
+// SetConfigValue sets the given git config key to the given value in the repository's own config.
+func (r *MutationRecorder) SetConfigValue(key, value string) error {
+	return r.record(Mutation{
+		Operation:   "SetConfigValue",
+		Ref:         key,
+		Description: fmt.Sprintf("set %s to %q", key, value),
+	}, func() error {
+		return r.Repo.SetConfigValue(key, value)
+	})
+}
+
+// StashChanges saves the local, uncommitted changes, and then reverts them.
+//
+// In record-only mode, nothing is stashed, so the returned stash is empty.
+func (r *MutationRecorder) StashChanges(message string) (string, error) {
+	var stash string
+	err := r.record(Mutation{
+		Operation:   "StashChanges",
+		Ref:         "refs/stash",
+		Description: fmt.Sprintf("stash the uncommitted changes as %q", message),
+	}, func() (err error) {
+		stash, err = r.Repo.StashChanges(message)
+		return err
+	})
+	return stash, err
+}
+
+// RestoreStash reapplies the changes saved by StashChanges, and then drops the stash.
+func (r *MutationRecorder) RestoreStash(stash string) error {
+	return r.record(Mutation{
+		Operation:   "RestoreStash",
+		Ref:         "refs/stash",
+		Description: fmt.Sprintf("restore the stashed changes %.12s", stash),
+	}, func() error {
+		return r.Repo.RestoreStash(stash)
+	})
+}
+
+// SnapshotChanges records the uncommitted changes in a new commit, and points the given ref at it.
+//
+// This cannot be done in record-only mode, as there is no commit to return.
+func (r *MutationRecorder) SnapshotChanges(ref, message string, staged bool) (string, error) {
+	if r.recordOnly {
+		return "", ReadOnlyError{Operation: "snapshot the uncommitted changes"}
+	}
+	var commit string
+	err := r.record(Mutation{
+		Operation:   "SnapshotChanges",
+		Ref:         ref,
+		Description: fmt.Sprintf("point %s at a snapshot of the uncommitted changes", ref),
+	}, func() (err error) {
+		commit, err = r.Repo.SnapshotChanges(ref, message, staged)
+		return err
+	})
+	return commit, err
+}
+
+// DeleteRef deletes the given ref.
+func (r *MutationRecorder) DeleteRef(ref string) error {
+	return r.record(Mutation{
+		Operation:   "DeleteRef",
+		Ref:         ref,
+		Description: "delete " + ref,
+	}, func() error {
+		return r.Repo.DeleteRef(ref)
+	})
+}
+
+// UpdateRef points the given ref at the given commit, or deletes it if the commit is empty,
+// but only if the ref still points at oldValue.
+func (r *MutationRecorder) UpdateRef(ref, newValue, oldValue string) error {
+	description := fmt.Sprintf("point %s at %.12s", ref, newValue)
+	if newValue == "" {
+		description = "delete " + ref
+	}
+	return r.record(Mutation{
+		Operation:   "UpdateRef",
+		Ref:         ref,
+		Description: description,
+	}, func() error {
+		return r.Repo.UpdateRef(ref, newValue, oldValue)
+	})
+}
+
+// DeleteRemoteRef deletes the given ref from the given remote repo.
+func (r *MutationRecorder) DeleteRemoteRef(remote, ref string) error {
+	return r.record(Mutation{
+		Operation:   "DeleteRemoteRef",
+		Ref:         ref,
+		Description: fmt.Sprintf("delete %s from the remote %q", ref, remote),
+	}, func() error {
+		return r.Repo.DeleteRemoteRef(remote, ref)
+	})
+}
+
+// SwitchToRef changes the currently-checked-out ref.
+func (r *MutationRecorder) SwitchToRef(ref string) error {
+	err := r.record(Mutation{
+		Operation:   "SwitchToRef",
+		Ref:         "HEAD",
+		Description: "check out " + ref,
+	}, func() error {
+		return r.Repo.SwitchToRef(ref)
+	})
+	if err == nil && r.recordOnly {
+		r.log.mutex.Lock()
+		r.log.head = ref
+		r.log.mutex.Unlock()
+	}
+	return err
+}
+
+// MergeRef merges the given ref into the current one.
+func (r *MutationRecorder) MergeRef(ref string, fastForward, sign bool, messages ...string) error {
+	head := r.currentHead()
+	description := fmt.Sprintf("merge %s into %s", ref, head)
+	if fastForward {
+		description = fmt.Sprintf("fast-forward %s to %s", head, ref)
+	}
+	return r.record(Mutation{
+		Operation:   "MergeRef",
+		Ref:         head,
+		Description: description,
+	}, func() error {
+		return r.Repo.MergeRef(ref, fastForward, sign, messages...)
+	})
+}
+
+// RebaseRef rebases the given ref into the current one.
+func (r *MutationRecorder) RebaseRef(ref string, sign bool) error {
+	head := r.currentHead()
+	return r.record(Mutation{
+		Operation:   "RebaseRef",
+		Ref:         head,
+		Description: fmt.Sprintf("rebase %s into %s", ref, head),
+	}, func() error {
+		return r.Repo.RebaseRef(ref, sign)
+	})
+}
+
+// SquashRef squashes the changes in the given ref into a single new commit on the current one.
+func (r *MutationRecorder) SquashRef(ref string, sign bool, messages ...string) error {
+	head := r.currentHead()
+	return r.record(Mutation{
+		Operation:   "SquashRef",
+		Ref:         head,
+		Description: fmt.Sprintf("squash %s into a single commit on %s", ref, head),
+	}, func() error {
+		return r.Repo.SquashRef(ref, sign, messages...)
+	})
+}
+
+// CommitMerge commits a merge or squash that stopped partway because of conflicts.
+func (r *MutationRecorder) CommitMerge(sign bool, messages ...string) error {
+	head := r.currentHead()
+	return r.record(Mutation{
+		Operation:   "CommitMerge",
+		Ref:         head,
+		Description: fmt.Sprintf("commit the merge in progress on %s", head),
+	}, func() error {
+		return r.Repo.CommitMerge(sign, messages...)
+	})
+}
+
+// AbortMerge abandons a merge, rebase, or squash that failed partway.
+func (r *MutationRecorder) AbortMerge() error {
+	return r.record(Mutation{
+		Operation:   "AbortMerge",
+		Ref:         "HEAD",
+		Description: "abort the merge in progress",
+	}, func() error {
+		return r.Repo.AbortMerge()
+	})
+}
+
+// AppendNote appends a note to a revision under the given ref.
+func (r *MutationRecorder) AppendNote(ref, revision string, note Note) error {
+	return r.record(Mutation{
+		Operation:   "AppendNote",
+		Ref:         ref,
+		Revision:    revision,
+		Note:        note,
+		Description: fmt.Sprintf("append a note to %s under %s", revision, ref),
+	}, func() error {
+		return r.Repo.AppendNote(ref, revision, note)
+	})
+}
+
+// StoreBlob writes the given contents to a git blob, recorded under the given notes ref,
+// and returns its hash.
+func (r *MutationRecorder) StoreBlob(notesRef string, contents []byte) (string, error) {
+	hash := gitBlobHash(string(contents))
+	err := r.record(Mutation{
+		Operation:   "StoreBlob",
+		Ref:         notesRef,
+		Revision:    hash,
+		Description: fmt.Sprintf("store a blob of %d bytes as %s under %s", len(contents), hash, notesRef),
+	}, func() (err error) {
+		hash, err = r.Repo.StoreBlob(notesRef, contents)
+		return err
+	})
+	return hash, err
+}
+
+// SetNotes replaces the notes annotating the given object under the given ref.
+func (r *MutationRecorder) SetNotes(notesRef, revision string, notes []Note) error {
+	description := fmt.Sprintf("replace the notes on %s under %s", revision, notesRef)
+	if len(notes) == 0 {
+		description = fmt.Sprintf("remove the notes on %s under %s", revision, notesRef)
+	}
+	var joined [][]byte
+	for _, note := range notes {
+		joined = append(joined, note)
+	}
+	return r.record(Mutation{
+		Operation:   "SetNotes",
+		Ref:         notesRef,
+		Revision:    revision,
+		Note:        Note(bytes.Join(joined, []byte("\n"))),
+		Description: description,
+	}, func() error {
+		return r.Repo.SetNotes(notesRef, revision, notes)
+	})
+}
+
+// CompactNotes rewrites every note under the given ref using the given function, and records
+// the previous tip of the notes ref under the given backup ref.
+func (r *MutationRecorder) CompactNotes(notesRef, backupRef string, compact func(object string, notes []Note) []Note) error {
+	return r.record(Mutation{
+		Operation:   "CompactNotes",
+		Ref:         notesRef,
+		Description: fmt.Sprintf("rewrite the notes under %s, keeping the old ones as %s", notesRef, backupRef),
+	}, func() error {
+		return r.Repo.CompactNotes(notesRef, backupRef, compact)
+	})
+}
+
+// Fetch updates the remote-tracking refs from the given remote repo.
+func (r *MutationRecorder) Fetch(remote string) error {
+	return r.record(Mutation{
+		Operation:   "Fetch",
+		Ref:         "refs/remotes/" + remote + "/*",
+		Description: fmt.Sprintf("fetch the branches of the remote %q", remote),
+	}, func() error {
+		return r.Repo.Fetch(remote)
+	})
+}
+
+// PushNotesAndArchive pushes the given notes and archive refs to a remote repo.
+func (r *MutationRecorder) PushNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	return r.record(Mutation{
+		Operation:   "PushNotesAndArchive",
+		Ref:         notesRefPattern,
+		Description: fmt.Sprintf("push %s and %s to the remote %q", notesRefPattern, archiveRefPattern, remote),
+	}, func() error {
+		return r.Repo.PushNotesAndArchive(remote, notesRefPattern, archiveRefPattern)
+	})
+}
+
+// ForcePushNotesAndArchive pushes the given notes and archive refs to a remote repo, replacing
+// the remote notes refs even if the local ones do not descend from them.
+func (r *MutationRecorder) ForcePushNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	return r.record(Mutation{
+		Operation:   "ForcePushNotesAndArchive",
+		Ref:         notesRefPattern,
+		Description: fmt.Sprintf("force push %s and %s to the remote %q", notesRefPattern, archiveRefPattern, remote),
+	}, func() error {
+		return r.Repo.ForcePushNotesAndArchive(remote, notesRefPattern, archiveRefPattern)
+	})
+}
+
+// InitNotesRef creates the given notes ref, with no notes, if it does not already exist.
+//
+// In record-only mode, this reports that the ref was created if it does not exist yet.
+func (r *MutationRecorder) InitNotesRef(notesRef string) (bool, error) {
+	if r.recordOnly {
+		if tip, err := r.Repo.GetNotesTip(notesRef); err != nil || tip != "" {
+			return false, err
+		}
+	}
+	created := true
+	err := r.record(Mutation{
+		Operation:   "InitNotesRef",
+		Ref:         notesRef,
+		Description: "create " + notesRef,
+	}, func() (err error) {
+		created, err = r.Repo.InitNotesRef(notesRef)
+		return err
+	})
+	return created, err
+}
+
+// ConfigureFetchRefSpecs adds the refspecs used by PullNotesAndArchive to the fetch
+// configuration of the given remote.
+//
+// In record-only mode, no refspecs are reported as added.
+func (r *MutationRecorder) ConfigureFetchRefSpecs(remote, notesRefPattern, archiveRefPattern string) ([]string, error) {
+	var added []string
+	err := r.record(Mutation{
+		Operation:   "ConfigureFetchRefSpecs",
+		Ref:         "remote." + remote + ".fetch",
+		Description: fmt.Sprintf("fetch %s and %s from the remote %q by default", notesRefPattern, archiveRefPattern, remote),
+	}, func() (err error) {
+		added, err = r.Repo.ConfigureFetchRefSpecs(remote, notesRefPattern, archiveRefPattern)
+		return err
+	})
+	return added, err
+}
+
+// PullNotesAndArchive fetches the given notes and archive refs from a remote repo, and merges
+// them with the local ones.
+func (r *MutationRecorder) PullNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	return r.record(Mutation{
+		Operation:   "PullNotesAndArchive",
+		Ref:         notesRefPattern,
+		Description: fmt.Sprintf("pull %s and %s from the remote %q", notesRefPattern, archiveRefPattern, remote),
+	}, func() error {
+		return r.Repo.PullNotesAndArchive(remote, notesRefPattern, archiveRefPattern)
+	})
+}
+
+// String returns the description of the mutation, followed by the note that it writes, if any.
+func (m Mutation) String() string {
+	if len(m.Note) == 0 {
+		return m.Description
+	}
+	return m.Description + ":\n" + strings.TrimRight(string(m.Note), "\n")
+}
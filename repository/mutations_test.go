@@ -0,0 +1,78 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"testing"
+)
+
+func TestMutationRecorder(t *testing.T) {
+	repo := NewRepoWithHistory(map[string][]string{
+		"master":  {"A"},
+		"feature": {"A", "B"},
+	})
+	dryRun := NewMutationRecorder(repo, true)
+	if !IsRecordOnly(dryRun) || IsRecordOnly(repo) {
+		t.Fatal("Unexpected record-only mode")
+	}
+	if err := dryRun.AppendNote("refs/notes/test", "B", Note("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dryRun.SwitchToRef("refs/heads/master"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dryRun.MergeRef("refs/heads/feature", true, false); err != nil {
+		t.Fatal(err)
+	}
+	if notes := repo.GetNotes("refs/notes/test", "B"); len(notes) != 0 {
+		t.Errorf("The note was written in record-only mode: %q", notes)
+	}
+	if head, _ := repo.GetHeadRef(); head != "refs/heads/master" {
+		t.Errorf("HEAD was moved in record-only mode: %q", head)
+	}
+	mutations := dryRun.WithContext(repo.Context()).(*MutationRecorder).Mutations()
+	expected := []string{
+		"append a note to B under refs/notes/test:\nfirst",
+		"check out refs/heads/master",
+		"fast-forward refs/heads/master to refs/heads/feature",
+	}
+	if len(mutations) != len(expected) {
+		t.Fatalf("Unexpected mutations: %+v", mutations)
+	}
+	for i, mutation := range mutations {
+		if mutation.String() != expected[i] {
+			t.Errorf("Unexpected mutation %d: got %q, want %q", i, mutation.String(), expected[i])
+		}
+	}
+
+	recorder := NewMutationRecorder(repo, false)
+	if err := recorder.AppendNote("refs/notes/test", "B", Note("second")); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.MergeRef("refs/heads/feature", true, false); err != nil {
+		t.Fatal(err)
+	}
+	if notes := repo.GetNotes("refs/notes/test", "B"); len(notes) != 1 || string(notes[0]) != "second" {
+		t.Errorf("The note was not written: %q", notes)
+	}
+	if master, _ := repo.GetCommitHash("refs/heads/master"); master != "B" {
+		t.Errorf("The merge was not made: master is at %q", master)
+	}
+	if mutations := recorder.Mutations(); len(mutations) != 2 || mutations[1].Operation != "MergeRef" || mutations[1].Ref != "refs/heads/master" {
+		t.Errorf("Unexpected mutations: %+v", mutations)
+	}
+}
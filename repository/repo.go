@@ -17,6 +17,16 @@ limitations under the License.
 // Package repository contains helper methods for working with a Git repo.
 package repository
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CommitSigningConfigKey is the git config key that, if true, signs every commit that git creates.
+const CommitSigningConfigKey = "commit.gpgsign"
+
 // Note represents the contents of a git-note
 type Note []byte
 
@@ -29,20 +39,178 @@ type CommitDetails struct {
 	Summary     string   `json:"summary,omitempty"`
 }
 
+// Commit describes a single commit, as listed by ListCommits.
+type Commit struct {
+	Hash string `json:"hash"`
+	CommitDetails
+}
+
+// RefDiff describes a ref whose value differs between the local repo and a remote.
+//
+// Either of LocalCommit or RemoteCommit is empty if the ref only exists on the other side.
+// DifferingNotes is the number of annotated objects whose notes differ, and is only
+// computed for notes refs.
+type RefDiff struct {
+	Ref            string
+	LocalCommit    string
+	RemoteCommit   string
+	DifferingNotes int
+}
+
+// ErrNotFastForward is returned when a ref cannot be fast-forwarded to another, because it
+// is not an ancestor of it.
+var ErrNotFastForward = errors.New("The target ref cannot be fast-forwarded to the review ref")
+
+// ErrNoChanges is returned when snapshotting the uncommitted changes, but there are none.
+var ErrNoChanges = errors.New("There are no uncommitted changes")
+
+// RefMissingError is returned when a ref that an operation needs does not exist.
+type RefMissingError struct {
+	Ref string
+}
+
+func (e RefMissingError) Error() string {
+	return fmt.Sprintf("The ref %q does not exist", e.Ref)
+}
+
+// PushRejectedError is returned when a push is rejected because the remote refs
+// have diverged from the local ones.
+type PushRejectedError struct {
+	Remote string
+}
+
+func (e PushRejectedError) Error() string {
+	return fmt.Sprintf("The remote %q rejected the push because it has review data that is not yet in the local repo", e.Remote)
+}
+
+// ShallowRepoError is returned when an operation needs history that is missing from a
+// shallow clone, and that could not be fetched.
+type ShallowRepoError struct {
+	// Operation describes what the missing history was needed for.
+	Operation string
+}
+
+func (e ShallowRepoError) Error() string {
+	return fmt.Sprintf("The repository is a shallow clone, and does not have the history needed to %s", e.Operation)
+}
+
+// ReadOnlyError is returned when an operation would modify a repository that can only be
+// read, such as one served by a remote git-appraise server.
+type ReadOnlyError struct {
+	// Operation describes what could not be done, such as "append a note".
+	Operation string
+}
+
+func (e ReadOnlyError) Error() string {
+	return fmt.Sprintf("Cannot %s, as the repository is read-only", e.Operation)
+}
+
+// CanceledError is returned when an operation is stopped because its context is done,
+// either because it timed out or because it was interrupted.
+type CanceledError struct {
+	// Operation describes what was stopped, such as the git command line that was running.
+	Operation string
+	Err       error
+}
+
+func (e CanceledError) Error() string {
+	if e.Err == context.DeadlineExceeded {
+		return fmt.Sprintf("Timed out running %s", e.Operation)
+	}
+	return fmt.Sprintf("Interrupted while running %s", e.Operation)
+}
+
+// Unwrap returns the reason that the operation was stopped, so that errors.Is can check for
+// context.DeadlineExceeded or context.Canceled.
+func (e CanceledError) Unwrap() error {
+	return e.Err
+}
+
 // Repo represents a source code repository.
 type Repo interface {
 	// GetPath returns the path to the repo.
 	GetPath() string
 
+	// Context returns the context that stops the repo's operations once it is done.
+	Context() context.Context
+
+	// WithContext returns a copy of the repo whose operations are stopped once the given
+	// context is done. Any git commands that are running at the time are killed.
+	WithContext(ctx context.Context) Repo
+
+	// IsBare returns whether or not the repository is bare, i.e. has no working tree.
+	IsBare() (bool, error)
+
+	// IsShallow returns whether or not the repository is a shallow clone, missing some of its history.
+	IsShallow() (bool, error)
+
+	// GetGitDir returns the path to the directory holding the repo's git metadata.
+	GetGitDir() (string, error)
+
 	// GetRepoStateHash returns a hash which embodies the entire current state of a repository.
 	GetRepoStateHash() (string, error)
 
 	// GetUserEmail returns the email address that the user has used to configure git.
 	GetUserEmail() (string, error)
 
+	// GetConfigValues returns all of the values set for the given git config key.
+	//
+	// If the key is not set, then the returned slice is empty.
+	GetConfigValues(key string) ([]string, error)
+
+	// GetConfigValuesWithOrigin returns all of the values set for the given git config key,
+	// along with the scope of the git config (such as "global" or "local") that each was set in.
+	GetConfigValuesWithOrigin(key string) ([]ConfigValue, error)
+
+	// SetConfigValue sets the given git config key to the given value in the repository's own config,
+	// replacing any values that were previously set for it there.
+	SetConfigValue(key, value string) error
+
+	// SignPayload signs the given data with the user's signing key, and returns the armored signature.
+	//
+	// The signature format and key are configured the same way as for signing commits, using
+	// the "gpg.format" and "user.signingkey" git config settings.
+	SignPayload(payload []byte) (string, error)
+
+	// VerifySignature verifies the given armored signature of the given data, and returns the
+	// identity of the signer.
+	VerifySignature(payload []byte, signature string) (string, error)
+
+	// ListRemotes returns the names of all of the remotes configured for the repo.
+	ListRemotes() ([]string, error)
+
 	// HasUncommittedChanges returns true if there are local, uncommitted changes.
 	HasUncommittedChanges() (bool, error)
 
+	// StashChanges saves the local, uncommitted changes (including untracked files) under
+	// the given message, and then reverts them, leaving the working tree clean.
+	//
+	// The returned stash identifies the saved changes, and is empty if there were none.
+	StashChanges(message string) (string, error)
+
+	// RestoreStash reapplies the changes saved by StashChanges, and then drops the stash.
+	//
+	// If the changes cannot be reapplied, then the stash is kept so that they are not lost.
+	RestoreStash(stash string) error
+
+	// SnapshotChanges records either the staged changes, or all of the uncommitted changes to
+	// tracked files, in a new commit on top of HEAD, and points the given (new) ref at it.
+	//
+	// This leaves the working tree, the index, and HEAD untouched.
+	SnapshotChanges(ref, message string, staged bool) (string, error)
+
+	// DeleteRef deletes the given ref.
+	DeleteRef(ref string) error
+
+	// UpdateRef points the given ref at the given commit, or deletes it if the commit is
+	// empty, but only if the ref still points at oldValue (or does not exist, if that is
+	// empty). Notes and archive refs are translated into the configured namespace, as they
+	// are by GetNotesTip.
+	UpdateRef(ref, newValue, oldValue string) error
+
+	// DeleteRemoteRef deletes the given ref from the given remote repo.
+	DeleteRemoteRef(remote, ref string) error
+
 	// VerifyCommit verifies that the supplied hash points to a known commit.
 	VerifyCommit(hash string) error
 
@@ -84,12 +252,24 @@ type Repo interface {
 	// IsAncestor determines if the first argument points to a commit that is an ancestor of the second.
 	IsAncestor(ancestor, descendant string) (bool, error)
 
+	// FindAncestors determines which of the given commits are ancestors of the given descendant.
+	//
+	// This is equivalent to calling IsAncestor for each of the commits, but is much faster
+	// for large numbers of commits. Commits that are not ancestors are left out of the result.
+	FindAncestors(commits []string, descendant string) (map[string]bool, error)
+
 	// Diff computes the diff between two given commits.
+	//
+	// Changes to submodules are summarized by the range of submodule commits,
+	// along with their subjects if the submodule is checked out.
 	Diff(left, right string, diffArgs ...string) (string, error)
 
 	// Show returns the contents of the given file at the given commit.
 	Show(commit, path string) (string, error)
 
+	// IsSubmodule returns whether or not the given path is a submodule at the given commit.
+	IsSubmodule(commit, path string) (bool, error)
+
 	// SwitchToRef changes the currently-checked-out ref.
 	SwitchToRef(ref string) error
 
@@ -99,10 +279,38 @@ type Repo interface {
 	// current ref should only move forward, as opposed to creating a bubble merge.
 	// The messages argument(s) provide text that should be included in the default
 	// merge commit message (separated by blank lines).
-	MergeRef(ref string, fastForward bool, messages ...string) error
+	//
+	// If sign is true, then any commit created is signed, as if the "commit.gpgsign"
+	// git config setting were enabled; otherwise that setting is honored.
+	MergeRef(ref string, fastForward, sign bool, messages ...string) error
 
 	// RebaseRef rebases the given ref into the current one.
-	RebaseRef(ref string) error
+	//
+	// If sign is true, then the rebased commits are signed.
+	RebaseRef(ref string, sign bool) error
+
+	// SquashRef squashes the changes in the given ref into a single new commit on the current one.
+	//
+	// The messages argument(s) provide the commit message (separated by blank lines).
+	// If sign is true, then the new commit is signed.
+	SquashRef(ref string, sign bool, messages ...string) error
+
+	// CommitMerge commits a merge or squash (by MergeRef or SquashRef) that stopped
+	// partway because of conflicts, once those have been resolved.
+	//
+	// The messages argument(s) provide the commit message (separated by blank lines).
+	// If sign is true, then the new commit is signed.
+	CommitMerge(sign bool, messages ...string) error
+
+	// AbortMerge abandons a merge, rebase, or squash (by MergeRef, RebaseRef, or SquashRef)
+	// that failed partway, restoring the current ref and the working tree to how they were
+	// before it started.
+	AbortMerge() error
+
+	// MergeInProgress returns the kind of merge ("merge" or "rebase") that was started in
+	// the working tree but not yet finished, or "" if there is none, along with the files
+	// that still have unresolved conflicts.
+	MergeInProgress() (string, []string, error)
 
 	// ListCommitsBetween returns the list of commits between the two given revisions.
 	//
@@ -114,20 +322,141 @@ type Repo interface {
 	// The generated list is in chronological order (with the oldest commit first).
 	ListCommitsBetween(from, to string) ([]string, error)
 
+	// ListCommits returns the details of the commits between the two given revisions, which
+	// are the same commits, in the same order, as those returned by ListCommitsBetween.
+	ListCommits(from, to string) ([]Commit, error)
+
+	// FormatPatch writes the commits between the two given revisions to the given directory
+	// as a series of email patches, as "git format-patch" does, and returns the paths of the
+	// written files in order.
+	//
+	// The given trailers (such as "Reviewed-by: ...") are added to the message of every patch.
+	// If a cover letter is given, then it is written first, with its first line as the subject
+	// and the rest as the body.
+	FormatPatch(from, to, outputDir string, trailers []string, coverLetter string) ([]string, error)
+
 	// GetNotes reads the notes from the given ref that annotate the given revision.
 	GetNotes(notesRef, revision string) []Note
 
+	// GetAllNotes reads all of the notes from the given ref, keyed by the annotated object.
+	//
+	// This is equivalent to calling GetNotes for each of the objects annotated under the
+	// notes ref, but is much faster when there are a large number of them.
+	GetAllNotes(notesRef string) (map[string][]Note, error)
+
 	// AppendNote appends a note to a revision under the given ref.
 	AppendNote(ref, revision string, note Note) error
 
+	// StoreBlob writes the given contents to a git blob and returns its hash.
+	//
+	// The blob is also recorded as a note (annotating itself) under the given notes
+	// ref, so that it is transferred along with the notes when they are pushed or pulled.
+	StoreBlob(notesRef string, contents []byte) (string, error)
+
 	// ListNotedRevisions returns the collection of revisions that are annotated by notes in the given ref.
 	ListNotedRevisions(notesRef string) []string
 
-	// PushNotes pushes git notes to a remote repo.
-	PushNotes(remote, notesRefPattern string) error
+	// ListNotesRefs returns the sorted names of the notes refs matching the given pattern.
+	ListNotesRefs(refPattern string) ([]string, error)
+
+	// ListRefs returns the objects that the refs matching the given pattern point to, keyed by ref.
+	ListRefs(refPattern string) (map[string]string, error)
+
+	// ListNotes returns the hash of the note blob for every object annotated in the given notes ref,
+	// keyed by the hash of the annotated object.
+	ListNotes(notesRef string) (map[string]string, error)
+
+	// SetNotes replaces the notes annotating the given object under the given ref. If
+	// there are no notes given, then the existing notes are removed.
+	SetNotes(notesRef, revision string, notes []Note) error
+
+	// CompactNotes rewrites every note under the given ref using the given function, in a
+	// single new commit on the notes ref, and records the previous tip of the notes ref
+	// under the given backup ref. Objects left without any notes are no longer annotated.
+	//
+	// If the function does not change any notes, then neither ref is updated.
+	CompactNotes(notesRef, backupRef string, compact func(object string, notes []Note) []Note) error
+
+	// Fetch updates the remote-tracking refs from the given remote repo.
+	Fetch(remote string) error
+
+	// AddWorktree checks out the given commit into a new, detached worktree at the given path.
+	AddWorktree(path, commit string) error
+
+	// RemoveWorktree removes a worktree previously created with AddWorktree.
+	RemoveWorktree(path string) error
+
+	// GetNotesTip returns the commit that the given notes ref currently points to, or
+	// the empty string if the ref does not exist.
+	GetNotesTip(notesRef string) (string, error)
+
+	// ListChangedNotes returns the sorted list of annotated objects whose notes differ
+	// between two commits of a notes ref. Either commit may be the empty string, which
+	// stands for there being no notes at all.
+	ListChangedNotes(leftCommit, rightCommit string) ([]string, error)
+
+	// DiffRemoteRefs reports the refs matching the given patterns whose values differ
+	// between the local repo and the given remote, without updating any local refs.
+	DiffRemoteRefs(remote string, refPatterns ...string) ([]RefDiff, error)
+
+	// PushNotesAndArchive pushes the given notes and archive refs to a remote repo.
+	//
+	// If the remote rejects the push because its refs have diverged from the local
+	// ones, then the returned error is a PushRejectedError.
+	PushNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error
+
+	// ForcePushNotesAndArchive pushes the given notes and archive refs to a remote repo, like
+	// PushNotesAndArchive, except that the remote notes refs are replaced even if the local
+	// ones do not descend from them (such as after rewriting the local notes).
+	//
+	// As a safety check, a remote notes ref is only replaced if it still has the value that was
+	// last pulled from it. Otherwise, the returned error is a PushRejectedError.
+	ForcePushNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error
+
+	// InitNotesRef creates the given notes ref, with no notes, if it does not already exist.
+	//
+	// The returned boolean indicates whether or not the ref was created.
+	InitNotesRef(notesRef string) (bool, error)
+
+	// ConfigureFetchRefSpecs adds the refspecs used by PullNotesAndArchive to the fetch
+	// configuration of the given remote, so that the notes and archive refs are also
+	// fetched by "git fetch". Refspecs that are already configured are not added again.
+	//
+	// The returned slice holds the refspecs that were added.
+	ConfigureFetchRefSpecs(remote, notesRefPattern, archiveRefPattern string) ([]string, error)
+
+	// PullNotesAndArchive fetches the contents of the given notes and archive refs
+	// from a remote repo, and then merges the notes with the corresponding local notes
+	// by taking the union of the notes for each annotated object.
+	PullNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error
+}
+
+// listCommitDetails implements ListCommits with a call to GetCommitDetails for each commit,
+// for repos that can read those cheaply.
+func listCommitDetails(repo Repo, from, to string) ([]Commit, error) {
+	hashes, err := repo.ListCommitsBetween(from, to)
+	if err != nil {
+		return nil, err
+	}
+	var commits []Commit
+	for _, hash := range hashes {
+		details, err := repo.GetCommitDetails(hash)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, Commit{Hash: hash, CommitDetails: *details})
+	}
+	return commits, nil
+}
 
-	// PullNotes fetches the contents of the given notes ref from a remote repo,
-	// and then merges them with the corresponding local notes using the
-	// "cat_sort_uniq" strategy.
-	PullNotes(remote, notesRefPattern string) error
+// IsConfigTrue returns whether or not the given boolean config setting is enabled, either in
+// the git config or by its shared default.
+//
+// As with git itself, the last value set for the key wins.
+func IsConfigTrue(repo Repo, key string) bool {
+	switch strings.ToLower(GetConfigValue(repo, key, "false")) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
 }
@@ -0,0 +1,197 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// openPGPFormat and sshFormat are the supported values of the "gpg.format" git config setting.
+	openPGPFormat = "openpgp"
+	sshFormat     = "ssh"
+
+	// sshSignatureHeader starts every armored SSH signature.
+	sshSignatureHeader = "-----BEGIN SSH SIGNATURE-----"
+	// sshSigningNamespace is the namespace of SSH signatures, which is the same one that git uses.
+	sshSigningNamespace = "git"
+)
+
+// signingConfig is the part of a repository needed to sign and verify data like git does.
+type signingConfig interface {
+	GetConfigValues(key string) ([]string, error)
+	GetUserEmail() (string, error)
+}
+
+// getLastConfigValue returns the value of the given git config key, or the given default if it is not set.
+//
+// As with git itself, the last value set for the key wins.
+func getLastConfigValue(config signingConfig, key, defaultValue string) string {
+	values, err := config.GetConfigValues(key)
+	if err != nil || len(values) == 0 {
+		return defaultValue
+	}
+	return values[len(values)-1]
+}
+
+// expandHome expands a leading "~/" in the given path to the user's home directory.
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
+
+// writeTempFile writes the given contents to a new temporary file, and returns its path.
+func writeTempFile(contents string) (string, error) {
+	file, err := ioutil.TempFile("", "git-appraise-signature-")
+	if err != nil {
+		return "", err
+	}
+	_, err = file.WriteString(contents)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// runSigningProgram runs the given signing program, feeding it the given payload, and returns its stdout.
+func runSigningProgram(payload []byte, program string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(program, args...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("Failed to run %s: %v\n%s", program, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// signPayload signs the given payload with the user's signing key, using the signature format configured for git.
+func signPayload(config signingConfig, payload []byte) (string, error) {
+	key := getLastConfigValue(config, "user.signingkey", "")
+	switch format := getLastConfigValue(config, "gpg.format", openPGPFormat); format {
+	case openPGPFormat:
+		if key == "" {
+			// Like git, fall back to the key matching the user's email address.
+			email, err := config.GetUserEmail()
+			if err != nil {
+				return "", err
+			}
+			key = email
+		}
+		program := getLastConfigValue(config, "gpg.openpgp.program", getLastConfigValue(config, "gpg.program", "gpg"))
+		return runSigningProgram(payload, program, "--status-fd=2", "-bsau", key)
+	case sshFormat:
+		if key == "" {
+			return "", errors.New("Signing with SSH requires the user.signingkey git config setting.")
+		}
+		keyFile := expandHome(key)
+		if strings.HasPrefix(key, "key::") || strings.HasPrefix(key, "ssh-") {
+			// The key is given literally, so that the private key is only held by the SSH agent.
+			tempFile, err := writeTempFile(strings.TrimPrefix(key, "key::") + "\n")
+			if err != nil {
+				return "", err
+			}
+			defer os.Remove(tempFile)
+			keyFile = tempFile
+		}
+		program := getLastConfigValue(config, "gpg.ssh.program", "ssh-keygen")
+		return runSigningProgram(payload, program, "-Y", "sign", "-n", sshSigningNamespace, "-f", keyFile)
+	default:
+		return "", fmt.Errorf("Unsupported signature format %q; only %q and %q are supported.", format, openPGPFormat, sshFormat)
+	}
+}
+
+// verifyOpenPGPSignature verifies the given OpenPGP signature file, and returns the user ID of the signer.
+func verifyOpenPGPSignature(config signingConfig, payload []byte, signatureFile string) (string, error) {
+	program := getLastConfigValue(config, "gpg.openpgp.program", getLastConfigValue(config, "gpg.program", "gpg"))
+	status, err := runSigningProgram(payload, program, "--status-fd=1", "--verify", signatureFile, "-")
+	for _, line := range splitLines(status) {
+		// The status line is "[GNUPG:] GOODSIG <key ID> <user ID>".
+		if fields := strings.SplitN(line, " ", 4); err == nil && len(fields) == 4 && fields[1] == "GOODSIG" {
+			return fields[3], nil
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return "", errors.New("The signature could not be verified.")
+}
+
+// verifySSHSignature verifies the given SSH signature file against the configured allowed signers,
+// and returns the principal that the signing key belongs to.
+func verifySSHSignature(config signingConfig, payload []byte, signatureFile string) (string, error) {
+	allowedSigners := getLastConfigValue(config, "gpg.ssh.allowedSignersFile", "")
+	if allowedSigners == "" {
+		return "", errors.New("Verifying SSH signatures requires the gpg.ssh.allowedSignersFile git config setting.")
+	}
+	allowedSigners = expandHome(allowedSigners)
+	program := getLastConfigValue(config, "gpg.ssh.program", "ssh-keygen")
+	principals, err := runSigningProgram(nil, program, "-Y", "find-principals", "-f", allowedSigners, "-s", signatureFile)
+	if err != nil {
+		return "", errors.New("The signing key is not one of the allowed signers.")
+	}
+	principal := splitLines(strings.TrimSpace(principals))[0]
+	if _, err := runSigningProgram(payload, program, "-Y", "verify", "-f", allowedSigners, "-I", principal,
+		"-n", sshSigningNamespace, "-s", signatureFile); err != nil {
+		return "", err
+	}
+	return principal, nil
+}
+
+// verifySignature verifies the given signature of the given payload, and returns the identity of the signer.
+//
+// The format of the signature is detected from its contents, rather than the "gpg.format"
+// setting, since the notes of a single review may be signed by users with different setups.
+func verifySignature(config signingConfig, payload []byte, signature string) (string, error) {
+	signatureFile, err := writeTempFile(signature)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(signatureFile)
+	if strings.HasPrefix(strings.TrimSpace(signature), sshSignatureHeader) {
+		return verifySSHSignature(config, payload, signatureFile)
+	}
+	return verifyOpenPGPSignature(config, payload, signatureFile)
+}
+
+// SignPayload signs the given data with the user's signing key, and returns the armored signature.
+func (repo *GitRepo) SignPayload(payload []byte) (string, error) {
+	return signPayload(repo, payload)
+}
+
+// VerifySignature verifies the given armored signature of the given data, and returns the identity of the signer.
+func (repo *GitRepo) VerifySignature(payload []byte, signature string) (string, error) {
+	return verifySignature(repo, payload, signature)
+}
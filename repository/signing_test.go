@@ -0,0 +1,89 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// configureSSHSigning generates an SSH signing key for the given repo, and configures
+// git to sign with it, and to accept its signatures from "user@example.com".
+func configureSSHSigning(t *testing.T, repo *GitRepo) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("The ssh-keygen tool is not installed")
+	}
+	keyFile := filepath.Join(repo.Path, ".git", "signing-key")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "", "-f", keyFile).CombinedOutput(); err != nil {
+		t.Fatalf("Failed to generate a signing key: %v\n%s", err, out)
+	}
+	publicKey, err := ioutil.ReadFile(keyFile + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowedSigners := filepath.Join(repo.Path, ".git", "allowed-signers")
+	if err := ioutil.WriteFile(allowedSigners, []byte("user@example.com "+string(publicKey)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"config", "gpg.format", "ssh"},
+		{"config", "user.signingkey", keyFile},
+		{"config", "gpg.ssh.allowedSignersFile", allowedSigners},
+	} {
+		if _, err := repo.runGitCommand(args...); err != nil {
+			t.Fatalf("Failed to run git %v: %v", args, err)
+		}
+	}
+}
+
+func TestSSHSigning(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	configureSSHSigning(t, repo)
+
+	payload := []byte(`{"description":"Signed comment"}`)
+	signature, err := repo.SignPayload(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(signature, sshSignatureHeader) {
+		t.Fatalf("Unexpected signature: %q", signature)
+	}
+	if signer, err := repo.VerifySignature(payload, signature); err != nil || signer != "user@example.com" {
+		t.Fatalf("Failed to verify a signature: %q, %v", signer, err)
+	}
+	if signer, err := repo.VerifySignature([]byte(`{"description":"Forged comment"}`), signature); err == nil {
+		t.Fatalf("Unexpectedly verified the signature of different data, signed by %q", signer)
+	}
+}
+
+func TestMockSigning(t *testing.T) {
+	repo := NewMockRepoForTest()
+	signature, err := repo.SignPayload([]byte("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer, err := repo.VerifySignature([]byte("payload"), signature); err != nil || signer != "user@example.com" {
+		t.Fatalf("Failed to verify a mock signature: %q, %v", signer, err)
+	}
+	if _, err := repo.VerifySignature([]byte("other payload"), signature); err == nil {
+		t.Fatal("Unexpectedly verified the mock signature of different data")
+	}
+}
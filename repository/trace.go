@@ -0,0 +1,138 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DebugEnvVar is the environment variable that, if set to a verbosity (such as 1 or 2),
+// logs the git commands run by every GitRepo to stderr.
+const DebugEnvVar = "APPRAISE_DEBUG"
+
+// maxLoggedOutput is the number of bytes of each output stream of a command that are logged.
+const maxLoggedOutput = 512
+
+// CommandTrace describes a single git command run by a GitRepo.
+type CommandTrace struct {
+	// Args holds the arguments passed to git.
+	Args []string
+	// Dir is the working directory that git was run in.
+	Dir      string
+	Duration time.Duration
+	// ExitCode is the exit status of git, or -1 if it could not be run at all.
+	ExitCode int
+	// Stdout and Stderr hold the output of git, if it was captured rather than passed through.
+	Stdout []byte
+	Stderr []byte
+}
+
+// CommandLogger is told about every git command that a GitRepo runs.
+type CommandLogger interface {
+	LogCommand(trace CommandTrace)
+}
+
+// DefaultCommandLogger is the logger given to new GitRepos, and is nil if commands are not logged.
+var DefaultCommandLogger CommandLogger
+
+// DebugVerbosity returns the verbosity requested by the DebugEnvVar environment variable,
+// which is 0 if it is unset, and 1 if it is set to anything other than a number.
+func DebugVerbosity() int {
+	value := os.Getenv(DebugEnvVar)
+	if value == "" {
+		return 0
+	}
+	verbosity, err := strconv.Atoi(value)
+	if err != nil {
+		return 1
+	}
+	return verbosity
+}
+
+// writerLogger is a CommandLogger that writes the commands to an io.Writer.
+type writerLogger struct {
+	mutex     sync.Mutex
+	writer    io.Writer
+	verbosity int
+}
+
+// NewCommandLogger returns a CommandLogger that writes every command line, along with its working
+// directory, duration, and exit code, to the given writer. Verbosities of 2 and up also write
+// the beginning of each command's output.
+func NewCommandLogger(writer io.Writer, verbosity int) CommandLogger {
+	return &writerLogger{writer: writer, verbosity: verbosity}
+}
+
+// quoteArg quotes the given command line argument if it would otherwise be ambiguous.
+func quoteArg(arg string) string {
+	if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$*?;&|<>()") {
+		return strconv.Quote(arg)
+	}
+	return arg
+}
+
+// formatCommand returns the command line of the git command with the given arguments.
+func formatCommand(args []string) string {
+	quoted := []string{"git"}
+	for _, arg := range args {
+		quoted = append(quoted, quoteArg(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// truncateOutput returns the given output as a quoted string, shortened to maxLoggedOutput bytes.
+func truncateOutput(output []byte) string {
+	if len(output) <= maxLoggedOutput {
+		return strconv.Quote(string(output))
+	}
+	return fmt.Sprintf("%s... (%d more bytes)", strconv.Quote(string(output[:maxLoggedOutput])), len(output)-maxLoggedOutput)
+}
+
+// LogCommand writes the given command to the logger's writer.
+func (logger *writerLogger) LogCommand(trace CommandTrace) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	fmt.Fprintf(logger.writer, "git-appraise: [%s] %s (%v, exit %d)\n",
+		trace.Dir, formatCommand(trace.Args), trace.Duration.Round(time.Microsecond), trace.ExitCode)
+	if logger.verbosity < 2 {
+		return
+	}
+	if len(trace.Stdout) > 0 {
+		fmt.Fprintf(logger.writer, "git-appraise:     stdout: %s\n", truncateOutput(trace.Stdout))
+	}
+	if len(trace.Stderr) > 0 {
+		fmt.Fprintf(logger.writer, "git-appraise:     stderr: %s\n", truncateOutput(trace.Stderr))
+	}
+}
+
+// exitCode returns the exit status of a command that returned the given error.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recordingLogger is a CommandLogger that keeps every command it is told about.
+type recordingLogger struct {
+	traces []CommandTrace
+}
+
+func (logger *recordingLogger) LogCommand(trace CommandTrace) {
+	logger.traces = append(logger.traces, trace)
+}
+
+func TestCommandTrace(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	logger := &recordingLogger{}
+	repo.Logger = logger
+	head, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.VerifyGitRef("refs/heads/missing"); err == nil {
+		t.Fatal("Unexpectedly verified a missing ref")
+	}
+	if len(logger.traces) != 2 {
+		t.Fatalf("Unexpected command traces: %+v", logger.traces)
+	}
+	first, second := logger.traces[0], logger.traces[1]
+	if !reflect.DeepEqual(first.Args, []string{"show", "-s", "--format=%H", "--end-of-options", "HEAD"}) || first.Dir != repo.Path ||
+		first.ExitCode != 0 || strings.TrimSpace(string(first.Stdout)) != head {
+		t.Fatalf("Unexpected trace of a successful command: %+v", first)
+	}
+	if second.ExitCode == 0 || len(second.Stderr) == 0 {
+		t.Fatalf("Unexpected trace of a failed command: %+v", second)
+	}
+}
+
+func TestForcePushCommandLine(t *testing.T) {
+	repo, cleanup := newTestGitRepo(t)
+	defer cleanup()
+	remote := &GitRepo{Path: repo.Path + "-remote.git"}
+	defer os.RemoveAll(remote.Path)
+	if _, err := repo.runGitCommand("init", "-q", "--bare", remote.Path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.runGitCommand("remote", "add", "origin", remote.Path); err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AppendNote("refs/notes/devtools/discuss", head, Note("Note")); err != nil {
+		t.Fatal(err)
+	}
+	logger := &recordingLogger{}
+	repo.Logger = logger
+	if err := repo.ForcePushNotesAndArchive("origin", "refs/notes/devtools/*", "refs/devtools/archives/*"); err != nil {
+		t.Fatal(err)
+	}
+	// A ref that was never pulled is only pushed if the remote does not have it either.
+	expected := []string{"push", "--force-with-lease=refs/notes/devtools/discuss:", "origin",
+		"refs/notes/devtools/discuss:refs/notes/devtools/discuss", "refs/devtools/archives/*:refs/devtools/archives/*"}
+	for _, trace := range logger.traces {
+		if len(trace.Args) > 0 && trace.Args[0] == "push" {
+			if !reflect.DeepEqual(trace.Args, expected) {
+				t.Fatalf("Unexpected push command line: got %q, want %q", trace.Args, expected)
+			}
+			return
+		}
+	}
+	t.Fatalf("No push was run: %+v", logger.traces)
+}
+
+func TestCommandLogger(t *testing.T) {
+	trace := CommandTrace{
+		Args:     []string{"notes", "add", "-m", "A message"},
+		Dir:      "/repo",
+		ExitCode: 1,
+		Stdout:   []byte(strings.Repeat("x", maxLoggedOutput+10)),
+		Stderr:   []byte("error: failed\n"),
+	}
+	var quiet bytes.Buffer
+	NewCommandLogger(&quiet, 1).LogCommand(trace)
+	if expected := "git-appraise: [/repo] git notes add -m \"A message\" (0s, exit 1)\n"; quiet.String() != expected {
+		t.Fatalf("Unexpected log: got %q, want %q", quiet.String(), expected)
+	}
+	var verbose bytes.Buffer
+	NewCommandLogger(&verbose, 2).LogCommand(trace)
+	if lines := strings.Split(verbose.String(), "\n"); len(lines) != 4 ||
+		!strings.HasSuffix(lines[1], "... (10 more bytes)") || lines[2] != `git-appraise:     stderr: "error: failed\n"` {
+		t.Fatalf("Unexpected verbose log: %q", verbose.String())
+	}
+}
@@ -20,6 +20,7 @@ package analyses
 import (
 	"encoding/json"
 	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/schema"
 	"io/ioutil"
 	"net/http"
 	"sort"
@@ -38,9 +39,41 @@ const (
 // Every field is optional.
 type Report struct {
 	Timestamp string `json:"timestamp,omitempty"`
-	URL       string `json:"url,omitempty"`
+	// URL is where the results of the analyses are fetched from, unless they are included
+	// in the report as AnalyzeResponse.
+	URL             string            `json:"url,omitempty"`
+	AnalyzeResponse []AnalyzeResponse `json:"analyze_response,omitempty"`
 	// Version represents the version of the metadata format.
 	Version int `json:"v,omitempty"`
+	// Extensions holds any fields that are not understood by this version of the tool, so
+	// that they are preserved if the note is rewritten.
+	Extensions schema.Extensions `json:"-"`
+}
+
+// UnmarshalJSON parses a analysis report, keeping any fields that are not understood in the Extensions field.
+func (report *Report) UnmarshalJSON(data []byte) error {
+	type plainReport Report
+	var plain plainReport
+	if err := json.Unmarshal(data, &plain); err != nil {
+		return err
+	}
+	extensions, err := schema.Split(data, plain)
+	if err != nil {
+		return err
+	}
+	*report = Report(plain)
+	report.Extensions = extensions
+	return nil
+}
+
+// MarshalJSON serializes a analysis report, including any fields that were not understood when it was parsed.
+func (report Report) MarshalJSON() ([]byte, error) {
+	type plainReport Report
+	bytes, err := json.Marshal(plainReport(report))
+	if err != nil {
+		return nil, err
+	}
+	return report.Extensions.AppendTo(bytes)
 }
 
 type LocationRange struct {
@@ -68,7 +101,7 @@ type ReportDetails struct {
 
 func (lintReport Report) GetLintReportResult() ([]AnalyzeResponse, error) {
 	if lintReport.URL == "" {
-		return nil, nil
+		return lintReport.AnalyzeResponse, nil
 	}
 	res, err := http.Get(lintReport.URL)
 	if err != nil {
@@ -95,6 +128,12 @@ func Parse(note repository.Note) (Report, error) {
 	return report, err
 }
 
+// Write writes an analysis report as a JSON-formatted git note.
+func (report Report) Write() (repository.Note, error) {
+	bytes, err := json.Marshal(report)
+	return repository.Note(bytes), err
+}
+
 // GetLatestAnalysesReport takes a collection of analysis reports, and returns the one with the most recent timestamp.
 func GetLatestAnalysesReport(reports []Report) (*Report, error) {
 	timestampReportMap := make(map[int]*Report)
@@ -121,6 +160,9 @@ func ParseAllValid(notes []repository.Note) []Report {
 	var reports []Report
 	for _, note := range notes {
 		report, err := Parse(note)
+		if err == nil && report.Version > FormatVersion {
+			schema.WarnUnsupportedVersion("analysis report", report.Version, FormatVersion)
+		}
 		if err == nil && report.Version == FormatVersion {
 			reports = append(reports, report)
 		}
@@ -0,0 +1,283 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"encoding/json"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/comment"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AnchorsRef defines the git-notes ref holding the positions that inline comments were
+// re-anchored to in later revisions of their reviews. These supplement, rather than replace,
+// the original locations of the comments.
+const AnchorsRef = "refs/notes/devtools/anchors"
+
+// maxAnchorDiffCells bounds the size of the table used to diff the changed part of a file
+// when re-anchoring a comment, so that comments on huge rewrites do not take long to show.
+// Comments beyond that bound are treated as outdated.
+const maxAnchorDiffCells = 4 * 1024 * 1024
+
+// Anchor is the position of an inline comment within the current version of a review.
+type Anchor struct {
+	// Commit is the commit whose version of the file the line number refers to.
+	Commit string `json:"commit"`
+	// Path is the path of the file, which differs from that of the comment if the file was renamed.
+	Path string `json:"path,omitempty"`
+	// Line is the (1-based) line number that the comment applies to.
+	Line uint32 `json:"line,omitempty"`
+	// Outdated is set if the line commented upon has since been changed or removed, in
+	// which case the commit, path, and line are the last ones known to hold that line.
+	Outdated bool `json:"outdated,omitempty"`
+}
+
+// anchorNote is the format of the notes in AnchorsRef, each of which records where a
+// comment was anchored to when the review's head was at a given commit.
+type anchorNote struct {
+	// Comment is the hash of the comment.
+	Comment string `json:"comment"`
+	// Head is the head commit of the review that the comment was anchored to.
+	Head      string `json:"head"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Anchor
+}
+
+// parseAnchorNotes parses the valid anchor notes from the given notes, keyed by comment hash.
+func parseAnchorNotes(notes []repository.Note) map[string][]anchorNote {
+	anchors := make(map[string][]anchorNote)
+	for _, note := range notes {
+		var anchor anchorNote
+		if err := json.Unmarshal([]byte(note), &anchor); err != nil || anchor.Comment == "" || anchor.Head == "" || anchor.Commit == "" {
+			continue
+		}
+		anchors[anchor.Comment] = append(anchors[anchor.Comment], anchor)
+	}
+	return anchors
+}
+
+// translateLine returns the (1-based) line number in the new lines that corresponds to the
+// given line number in the old lines, or false if that line was changed or removed.
+//
+// Lines are matched using the longest common subsequence of the old and new lines, after
+// setting aside the common prefix and suffix, which is what line-based diffs do.
+func translateLine(oldLines, newLines []string, line int) (int, bool) {
+	index := line - 1
+	if index < 0 || index >= len(oldLines) {
+		return 0, false
+	}
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	if index < prefix {
+		return line, true
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix &&
+		oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+	if index >= len(oldLines)-suffix {
+		return line + len(newLines) - len(oldLines), true
+	}
+
+	a := oldLines[prefix : len(oldLines)-suffix]
+	b := newLines[prefix : len(newLines)-suffix]
+	if len(a)*len(b) > maxAnchorDiffCells {
+		return 0, false
+	}
+	// common[i][j] holds the length of the longest common subsequence of a[i:] and b[j:].
+	common := make([][]int32, len(a)+1)
+	for i := range common {
+		common[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+	target := index - prefix
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			if i == target {
+				return prefix + j + 1, true
+			}
+			i++
+			j++
+		case common[i+1][j] >= common[i][j+1]:
+			if i == target {
+				return 0, false
+			}
+			i++
+		default:
+			j++
+		}
+	}
+	return 0, false
+}
+
+// findRenamedPath returns the path that the given file was renamed to between the two given
+// commits, or false if it was not renamed (in which case it was most likely deleted).
+func (r *Review) findRenamedPath(from, to, path string) (string, bool) {
+	out, err := r.Repo.Diff(from, to, "-z", "--name-status", "-M", "--diff-filter=R")
+	if err != nil {
+		return "", false
+	}
+	// Each rename is listed as a status (such as "R087"), the old path, and the new
+	// path, each of which is terminated by a NUL character.
+	fields := strings.Split(out, "\x00")
+	for i := 0; i+2 < len(fields); i += 3 {
+		if strings.HasPrefix(strings.TrimSpace(fields[i]), "R") && fields[i+1] == path {
+			return fields[i+2], true
+		}
+	}
+	return "", false
+}
+
+// FindRenamedPath returns the path that the given file was renamed to by the review, between
+// its base and head commits, or false if the review did not rename it.
+func (r *Review) FindRenamedPath(path string) (string, bool) {
+	baseCommit, err := r.GetBaseCommit()
+	if err != nil {
+		return "", false
+	}
+	headCommit, err := r.GetHeadCommit()
+	if err != nil {
+		return "", false
+	}
+	return r.findRenamedPath(baseCommit, headCommit, comment.NormalizePath(path))
+}
+
+// translateAnchor follows the line of the given anchor to its position in the given commit.
+func (r *Review) translateAnchor(anchor Anchor, commit string) (Anchor, error) {
+	oldContents, err := r.Repo.Show(anchor.Commit, anchor.Path)
+	if err != nil {
+		return anchor, err
+	}
+	path := anchor.Path
+	newContents, err := r.Repo.Show(commit, path)
+	if err != nil {
+		renamed, ok := r.findRenamedPath(anchor.Commit, commit, path)
+		if ok {
+			path = renamed
+			newContents, err = r.Repo.Show(commit, path)
+		}
+		if err != nil {
+			// The file no longer exists in the review.
+			anchor.Outdated = true
+			return anchor, nil
+		}
+	}
+	line, ok := translateLine(strings.Split(oldContents, "\n"), strings.Split(newContents, "\n"), int(anchor.Line))
+	if !ok {
+		anchor.Outdated = true
+		return anchor, nil
+	}
+	return Anchor{Commit: commit, Path: path, Line: uint32(line)}, nil
+}
+
+// findAnchor implements AnchorComment, and also reports whether or not the returned anchor
+// was read from the review's anchor notes, rather than computed.
+func (r *Review) findAnchor(commentHash string, location comment.Location, anchors map[string][]anchorNote) (Anchor, bool, error) {
+	anchor := Anchor{Commit: location.Commit, Path: comment.NormalizePath(location.Path)}
+	if location.Path == "" || location.Range == nil || location.Range.StartLine == 0 {
+		return anchor, false, nil
+	}
+	anchor.Line = location.Range.StartLine
+	if location.Pinned {
+		// The comment is about that commit in particular, so it stays there.
+		return anchor, false, nil
+	}
+	headCommit, err := r.GetHeadCommit()
+	if err != nil || headCommit == location.Commit {
+		return anchor, false, nil
+	}
+	// Start from the latest position that the line is known to have been at, since following
+	// the line through every revision of the review is more accurate than skipping ahead.
+	var latestTimestamp int64 = -1
+	for _, stored := range anchors[commentHash] {
+		if stored.Head == headCommit {
+			return stored.Anchor, true, nil
+		}
+		timestamp, err := strconv.ParseInt(stored.Timestamp, 10, 64)
+		if err == nil && !stored.Outdated && timestamp > latestTimestamp {
+			anchor, latestTimestamp = stored.Anchor, timestamp
+		}
+	}
+	anchor, err = r.translateAnchor(anchor, headCommit)
+	return anchor, false, err
+}
+
+// AnchorComment follows the line that an inline comment was made on to its position in
+// the review's head commit, in case later revisions of the review have added or removed
+// lines before it, or renamed the file. The comment itself is left as is.
+//
+// The positions recorded by UpdateAnchors are used when available. If the location is
+// not on a specific line, is pinned to its commit, or the review has not changed since
+// the comment was made, then the anchor is just the given location.
+func (r *Review) AnchorComment(commentHash string, location comment.Location) (Anchor, error) {
+	anchor, _, err := r.findAnchor(commentHash, location, parseAnchorNotes(r.Repo.GetNotes(AnchorsRef, r.Revision)))
+	return anchor, err
+}
+
+// UpdateAnchors records the positions of the review's inline comments in its head commit,
+// so that each time the review ref moves (such as when its commits are rebased or amended),
+// the comments are re-anchored from where they last were rather than from where they were made.
+//
+// Comments whose positions have already been recorded for the head commit are skipped, as
+// are the comments whose files cannot be read. The number of positions recorded is returned.
+func (r *Review) UpdateAnchors() (int, error) {
+	if r.Unavailable {
+		return 0, nil
+	}
+	headCommit, err := r.GetHeadCommit()
+	if err != nil {
+		return 0, err
+	}
+	anchors := parseAnchorNotes(r.Repo.GetNotes(AnchorsRef, r.Revision))
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	count := 0
+	for _, thread := range r.Comments {
+		location := thread.Comment.Location
+		if location == nil || location.Pinned || location.Commit == headCommit {
+			continue
+		}
+		anchor, stored, err := r.findAnchor(thread.Hash, *location, anchors)
+		if err != nil || stored || anchor.Line == 0 {
+			continue
+		}
+		note, err := json.Marshal(anchorNote{Comment: thread.Hash, Head: headCommit, Timestamp: timestamp, Anchor: anchor})
+		if err != nil {
+			return count, err
+		}
+		if err := r.Repo.AppendNote(AnchorsRef, r.Revision, repository.Note(note)); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/comment"
+	"strings"
+	"testing"
+)
+
+func TestTranslateLine(t *testing.T) {
+	old := strings.Split("a\nb\nc\nd\ne", "\n")
+	for _, test := range []struct {
+		description string
+		new         string
+		line        int
+		expected    int
+		ok          bool
+	}{
+		{"unchanged", "a\nb\nc\nd\ne", 3, 3, true},
+		{"lines added above", "x\ny\na\nb\nc\nd\ne", 3, 5, true},
+		{"lines removed above", "c\nd\ne", 3, 1, true},
+		{"lines added below", "a\nb\nc\nx\nd\ne", 3, 3, true},
+		{"lines added above and below", "x\na\nb\ny\nc\nz\nd\ne", 3, 5, true},
+		{"line removed", "a\nb\nd\ne", 3, 0, false},
+		{"line changed", "a\nb\nC\nd\ne", 3, 0, false},
+		{"neighbors changed", "a\nB\nc\nD\ne", 3, 3, true},
+		{"file emptied", "", 3, 0, false},
+		{"line out of range", "a\nb\nc\nd\ne", 6, 0, false},
+	} {
+		line, ok := translateLine(old, strings.Split(test.new, "\n"), test.line)
+		if line != test.expected || ok != test.ok {
+			t.Errorf("%s: expected line %d (%v), got %d (%v)", test.description, test.expected, test.ok, line, ok)
+		}
+	}
+}
+
+func TestAnchorComment(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	r, err := Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	location := comment.Location{Commit: repository.TestCommitI, Path: "foo", Range: &comment.Range{StartLine: 1}}
+	if anchor, err := r.AnchorComment("hash", location); err != nil || anchor != (Anchor{Commit: repository.TestCommitI, Path: "foo", Line: 1}) {
+		t.Fatalf("Unexpected anchor for a comment on the head commit: %+v, %v", anchor, err)
+	}
+	// The mock repo's files have different contents at every commit.
+	location.Commit = repository.TestCommitG
+	if anchor, err := r.AnchorComment("hash", location); err != nil || anchor != (Anchor{Commit: repository.TestCommitG, Path: "foo", Line: 1, Outdated: true}) {
+		t.Fatalf("Unexpected anchor for a comment on a changed line: %+v, %v", anchor, err)
+	}
+	// Comments pinned to a commit stay on it.
+	location.Pinned = true
+	if anchor, err := r.AnchorComment("hash", location); err != nil || anchor != (Anchor{Commit: repository.TestCommitG, Path: "foo", Line: 1}) {
+		t.Fatalf("Unexpected anchor for a comment pinned to its commit: %+v, %v", anchor, err)
+	}
+}
+
+// filesRepoForTest overrides the contents of the mock repo's files, and the renames between its commits.
+type filesRepoForTest struct {
+	repository.Repo
+	// files holds the contents of each file, keyed by commit and then path.
+	files map[string]map[string]string
+	// renames holds the output of "git diff -z --name-status" for each pair of commits.
+	renames map[[2]string]string
+}
+
+func (repo filesRepoForTest) Show(commit, path string) (string, error) {
+	contents, ok := repo.files[commit][path]
+	if !ok {
+		return "", fmt.Errorf("There is no file %q at %q", path, commit)
+	}
+	return contents, nil
+}
+
+func (repo filesRepoForTest) Diff(left, right string, diffArgs ...string) (string, error) {
+	return repo.renames[[2]string{left, right}], nil
+}
+
+func TestUpdateAnchors(t *testing.T) {
+	repo := filesRepoForTest{
+		Repo: repository.NewMockRepoForTest(),
+		files: map[string]map[string]string{
+			repository.TestCommitG: {
+				"shifted.go": "a\nb\nc\n",
+				"old.go":     "x\ny\nz\n",
+				"deleted.go": "d\n",
+				"changed.go": "e\nf\ng\n",
+			},
+			repository.TestCommitI: {
+				"shifted.go": "new\nlines\na\nb\nc\n",
+				"new.go":     "w\nx\ny\nz\n",
+				"changed.go": "e\nF\ng\n",
+			},
+		},
+		renames: map[[2]string]string{
+			{repository.TestCommitG, repository.TestCommitI}: "R087\x00old.go\x00new.go\x00",
+		},
+	}
+	r, err := Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The review's head is TestCommitI, and the comments are all made on TestCommitG.
+	r.Comments = nil
+	for _, path := range []string{"shifted.go", "old.go", "deleted.go", "changed.go"} {
+		c := comment.New("user@example.com", "Comment on "+path)
+		c.Location = &comment.Location{Commit: repository.TestCommitG, Path: path, Range: &comment.Range{StartLine: 2}}
+		r.Comments = append(r.Comments, CommentThread{Hash: path, Comment: c})
+	}
+	expected := map[string]Anchor{
+		"shifted.go": {Commit: repository.TestCommitI, Path: "shifted.go", Line: 4},
+		"old.go":     {Commit: repository.TestCommitI, Path: "new.go", Line: 3},
+		"deleted.go": {Commit: repository.TestCommitG, Path: "deleted.go", Line: 2, Outdated: true},
+		"changed.go": {Commit: repository.TestCommitG, Path: "changed.go", Line: 2, Outdated: true},
+	}
+	for _, thread := range r.Comments {
+		if anchor, err := r.AnchorComment(thread.Hash, *thread.Comment.Location); err != nil || anchor != expected[thread.Hash] {
+			t.Errorf("Unexpected anchor for the comment on %q: %+v, %v", thread.Hash, anchor, err)
+		}
+	}
+
+	if count, err := r.UpdateAnchors(); err != nil || count != 4 {
+		t.Fatalf("Unexpected result of recording the anchors: %d, %v", count, err)
+	}
+	if count, err := r.UpdateAnchors(); err != nil || count != 0 {
+		t.Fatalf("Unexpectedly recorded the anchors again: %d, %v", count, err)
+	}
+	// Once recorded, the anchors no longer depend on the original files.
+	delete(repo.files, repository.TestCommitG)
+	for _, thread := range r.Comments {
+		if anchor, err := r.AnchorComment(thread.Hash, *thread.Comment.Location); err != nil || anchor != expected[thread.Hash] {
+			t.Errorf("Unexpected recorded anchor for the comment on %q: %+v, %v", thread.Hash, anchor, err)
+		}
+	}
+	// The original locations of the comments are left untouched.
+	for _, thread := range r.Comments {
+		if thread.Comment.Location.Commit != repository.TestCommitG || thread.Comment.Location.Path != thread.Hash {
+			t.Errorf("Unexpected change to the location of the comment on %q: %+v", thread.Hash, thread.Comment.Location)
+		}
+	}
+}
+
+func TestFindRenamedPath(t *testing.T) {
+	repo := filesRepoForTest{Repo: repository.NewMockRepoForTest(), renames: make(map[[2]string]string)}
+	r, err := Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseCommit, err := r.GetBaseCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	headCommit, err := r.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.renames[[2]string{baseCommit, headCommit}] = "R100\x00old/path.go\x00new/path.go\x00R075\x00other.go\x00renamed.go\x00"
+	if renamed, ok := r.FindRenamedPath("old/path.go"); !ok || renamed != "new/path.go" {
+		t.Fatalf("Unexpected rename of a renamed file: %q, %v", renamed, ok)
+	}
+	if renamed, ok := r.FindRenamedPath("other.go"); !ok || renamed != "renamed.go" {
+		t.Fatalf("Unexpected rename of a renamed file: %q, %v", renamed, ok)
+	}
+	if renamed, ok := r.FindRenamedPath("new/path.go"); ok {
+		t.Fatalf("Unexpected rename of a file that was not renamed: %q", renamed)
+	}
+}
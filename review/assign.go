@@ -0,0 +1,169 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ReviewersPath is the path, within a repo, of the optional file listing the pool of reviewers
+// that new reviews may be automatically assigned to.
+//
+// As with the shared config file, it is read as it was committed at HEAD. Each line holds
+// either the email address of a reviewer, or a "policy = <policy>" or "count = <n>" setting.
+// Blank lines and lines starting with "#" are ignored.
+const ReviewersPath = ".appraise/reviewers"
+
+const (
+	// AssignRoundRobin assigns each new review to the reviewers following those assigned
+	// the most recently requested review.
+	AssignRoundRobin = "round-robin"
+	// AssignLeastLoaded assigns each new review to the reviewers with the fewest open reviews.
+	AssignLeastLoaded = "least-loaded"
+)
+
+// ReviewerPool is the pool of reviewers that new reviews may be automatically assigned to.
+type ReviewerPool struct {
+	Reviewers []string
+	// Policy is either AssignRoundRobin or AssignLeastLoaded.
+	Policy string
+	// Count is the number of reviewers assigned to each review.
+	Count int
+}
+
+// parseReviewerPool parses the given contents of the reviewers file.
+func parseReviewerPool(contents string) (*ReviewerPool, error) {
+	pool := &ReviewerPool{Policy: AssignLeastLoaded, Count: 1}
+	for i, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		equals := strings.Index(line, "=")
+		if equals < 0 {
+			pool.Reviewers = append(pool.Reviewers, line)
+			continue
+		}
+		key, value := strings.TrimSpace(line[:equals]), strings.TrimSpace(line[equals+1:])
+		switch key {
+		case "policy":
+			if value != AssignRoundRobin && value != AssignLeastLoaded {
+				return nil, fmt.Errorf("%s:%d: unknown policy %q; expected %q or %q", ReviewersPath, i+1, value, AssignRoundRobin, AssignLeastLoaded)
+			}
+			pool.Policy = value
+		case "count":
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 1 {
+				return nil, fmt.Errorf("%s:%d: invalid count %q", ReviewersPath, i+1, value)
+			}
+			pool.Count = count
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown setting %q", ReviewersPath, i+1, key)
+		}
+	}
+	if len(pool.Reviewers) == 0 {
+		return nil, fmt.Errorf("%s does not list any reviewers", ReviewersPath)
+	}
+	return pool, nil
+}
+
+// ReadReviewerPool reads the pool of reviewers from the reviewers file committed at HEAD.
+func ReadReviewerPool(repo repository.Repo) (*ReviewerPool, error) {
+	contents, err := repo.Show("HEAD", ReviewersPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the pool of reviewers from %s: %v", ReviewersPath, err)
+	}
+	return parseReviewerPool(contents)
+}
+
+// Assign picks the reviewers for a new review by the given requester, from the pool and
+// according to its policy, based on the existing reviews.
+//
+// The requester is never assigned their own review, so fewer than Count reviewers are
+// returned if the pool is not large enough.
+func (pool *ReviewerPool) Assign(reviews []Review, requester string) []string {
+	candidates := make([]string, 0, len(pool.Reviewers))
+	for _, reviewer := range pool.Reviewers {
+		if reviewer != requester {
+			candidates = append(candidates, reviewer)
+		}
+	}
+	if pool.Policy == AssignRoundRobin {
+		candidates = pool.rotate(candidates, reviews)
+	} else {
+		load := make(map[string]int)
+		for _, r := range reviews {
+			if r.Submitted {
+				continue
+			}
+			for _, reviewer := range r.Request.Reviewers {
+				load[reviewer]++
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return load[candidates[i]] < load[candidates[j]]
+		})
+	}
+	if len(candidates) > pool.Count {
+		candidates = candidates[:pool.Count]
+	}
+	return candidates
+}
+
+// rotate returns the given candidates, starting after the last of them to be assigned the
+// most recently requested review.
+func (pool *ReviewerPool) rotate(candidates []string, reviews []Review) []string {
+	var latest *Review
+	var latestTimestamp int64
+	for i, r := range reviews {
+		timestamp, err := strconv.ParseInt(r.Request.Timestamp, 10, 64)
+		if err != nil || (latest != nil && timestamp < latestTimestamp) {
+			continue
+		}
+		for _, reviewer := range r.Request.Reviewers {
+			if indexOf(candidates, reviewer) >= 0 {
+				latest, latestTimestamp = &reviews[i], timestamp
+				break
+			}
+		}
+	}
+	if latest == nil {
+		return candidates
+	}
+	last := -1
+	for _, reviewer := range latest.Request.Reviewers {
+		if index := indexOf(candidates, reviewer); index > last {
+			last = index
+		}
+	}
+	start := (last + 1) % len(candidates)
+	return append(candidates[start:], candidates[:start]...)
+}
+
+// indexOf returns the index of the given value in the given list, or -1 if it is not in it.
+func indexOf(list []string, value string) int {
+	for i, item := range list {
+		if item == value {
+			return i
+		}
+	}
+	return -1
+}
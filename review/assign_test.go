@@ -0,0 +1,61 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"github.com/google/git-appraise/review/request"
+	"reflect"
+	"testing"
+)
+
+func TestParseReviewerPool(t *testing.T) {
+	pool, err := parseReviewerPool("# The reviewers\npolicy = round-robin\ncount = 2\n\nalice\nbob\ncarol\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &ReviewerPool{Reviewers: []string{"alice", "bob", "carol"}, Policy: AssignRoundRobin, Count: 2}
+	if !reflect.DeepEqual(pool, expected) {
+		t.Errorf("Unexpected pool: got %+v, want %+v", pool, expected)
+	}
+	for _, contents := range []string{"", "policy = random\nalice", "count = 0\nalice", "owner = alice"} {
+		if _, err := parseReviewerPool(contents); err == nil {
+			t.Errorf("Unexpectedly parsed the reviewers file %q", contents)
+		}
+	}
+}
+
+func TestAssign(t *testing.T) {
+	newReview := func(timestamp string, submitted bool, reviewers ...string) Review {
+		return Review{Request: request.Request{Timestamp: timestamp, Reviewers: reviewers}, Submitted: submitted}
+	}
+	reviews := []Review{
+		newReview("0000000003", false, "alice"),
+		newReview("0000000001", false, "alice", "bob"),
+		newReview("0000000002", true, "carol"),
+	}
+	pool := &ReviewerPool{Reviewers: []string{"alice", "bob", "carol", "dave"}, Policy: AssignLeastLoaded, Count: 2}
+	if assigned := pool.Assign(reviews, "dave"); !reflect.DeepEqual(assigned, []string{"carol", "bob"}) {
+		t.Errorf("Unexpected least loaded reviewers: %v", assigned)
+	}
+	pool.Policy = AssignRoundRobin
+	if assigned := pool.Assign(reviews, "dave"); !reflect.DeepEqual(assigned, []string{"bob", "carol"}) {
+		t.Errorf("Unexpected round robin reviewers: %v", assigned)
+	}
+	if assigned := pool.Assign(nil, "alice"); !reflect.DeepEqual(assigned, []string{"bob", "carol"}) {
+		t.Errorf("Unexpected round robin reviewers for the first review: %v", assigned)
+	}
+}
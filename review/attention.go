@@ -0,0 +1,196 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/request"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// AttentionRef defines the git-notes ref recording explicit changes to the attention set of
+// each review: the people whose turn it is to act on it.
+const AttentionRef = "refs/notes/devtools/attention"
+
+// attentionNote is the format of the notes in AttentionRef, each of which records that
+// someone was added to or removed from the attention set of a review.
+type attentionNote struct {
+	Timestamp string `json:"timestamp"`
+	Author    string `json:"author,omitempty"`
+	Email     string `json:"email"`
+	Added     bool   `json:"added"`
+}
+
+// attentionEvent is a single entry in the history of a review that changes its attention set.
+type attentionEvent struct {
+	timestamp int64
+	// order breaks ties between events with the same timestamp: requests come before
+	// comments, which come before explicit changes.
+	order   int
+	request *request.Request
+	comment *CommentThread
+	note    *attentionNote
+}
+
+// collectAttentionEvents appends an event for each of the comments in the given threads.
+func collectAttentionEvents(events []attentionEvent, threads []CommentThread) []attentionEvent {
+	for i := range threads {
+		timestamp, err := strconv.ParseInt(threads[i].Comment.Timestamp, 10, 64)
+		if err == nil {
+			events = append(events, attentionEvent{timestamp: timestamp, order: 1, comment: &threads[i]})
+		}
+		events = collectAttentionEvents(events, threads[i].Children)
+	}
+	return events
+}
+
+// computeAttention replays the history of a review to find its attention set.
+//
+// Requesting a review puts its reviewers in the attention set. Anyone other than the
+// requester commenting on it removes themselves, and adds the requester, and the requester
+// commenting in turn hands it back to everyone who has commented since the requester last
+// did. Accepting a review only removes the person accepting it. The notes in AttentionRef
+// then add or remove people explicitly.
+func computeAttention(requests []request.Request, threads []CommentThread, notes []repository.Note) []string {
+	var events []attentionEvent
+	for i := range requests {
+		timestamp, err := strconv.ParseInt(requests[i].Timestamp, 10, 64)
+		if requests[i].Amended != "" {
+			timestamp, err = strconv.ParseInt(requests[i].Amended, 10, 64)
+		}
+		if err == nil {
+			events = append(events, attentionEvent{timestamp: timestamp, request: &requests[i]})
+		}
+	}
+	events = collectAttentionEvents(events, threads)
+	for _, note := range notes {
+		var parsed attentionNote
+		if err := json.Unmarshal([]byte(note), &parsed); err != nil || parsed.Email == "" {
+			continue
+		}
+		if timestamp, err := strconv.ParseInt(parsed.Timestamp, 10, 64); err == nil {
+			events = append(events, attentionEvent{timestamp: timestamp, order: 2, note: &parsed})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].timestamp != events[j].timestamp {
+			return events[i].timestamp < events[j].timestamp
+		}
+		return events[i].order < events[j].order
+	})
+
+	attention := make(map[string]bool)
+	// waiting holds everyone who has handed the review back to the requester since the requester last commented.
+	waiting := make(map[string]bool)
+	var requester string
+	var reviewers map[string]bool
+	for _, event := range events {
+		switch {
+		case event.request != nil:
+			requester = event.request.Requester
+			// Reviewers who are added by a later version of the request join the attention set,
+			// and those who are removed leave it.
+			current := make(map[string]bool)
+			for _, reviewer := range event.request.Reviewers {
+				current[reviewer] = true
+				if !reviewers[reviewer] {
+					attention[reviewer] = true
+				}
+			}
+			for reviewer := range reviewers {
+				if !current[reviewer] {
+					delete(attention, reviewer)
+					delete(waiting, reviewer)
+				}
+			}
+			reviewers = current
+		case event.comment != nil:
+			author := event.comment.Comment.Author
+			if author == "" {
+				continue
+			}
+			delete(attention, author)
+			if author == requester {
+				for email := range waiting {
+					attention[email] = true
+				}
+				waiting = make(map[string]bool)
+			} else if resolved := event.comment.Comment.Resolved; resolved == nil || !*resolved {
+				waiting[author] = true
+				if requester != "" {
+					attention[requester] = true
+				}
+			}
+		case event.note != nil:
+			if event.note.Added {
+				attention[event.note.Email] = true
+			} else {
+				delete(attention, event.note.Email)
+				delete(waiting, event.note.Email)
+			}
+		}
+	}
+	var result []string
+	for email := range attention {
+		result = append(result, email)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// SetAttention records that the given author added the given people to, and removed the
+// other given people from, the attention set of the review.
+func (r *Review) SetAttention(author string, added, removed []string) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	var notes []attentionNote
+	for _, email := range removed {
+		notes = append(notes, attentionNote{timestamp, author, email, false})
+	}
+	for _, email := range added {
+		notes = append(notes, attentionNote{timestamp, author, email, true})
+	}
+	for _, note := range notes {
+		if note.Email == "" {
+			return errors.New("Cannot add or remove an empty email address from the attention set.")
+		}
+	}
+	for _, note := range notes {
+		noteBytes, err := json.Marshal(note)
+		if err != nil {
+			return err
+		}
+		if err := r.Repo.AppendNote(AttentionRef, r.Revision, repository.Note(noteBytes)); err != nil {
+			return err
+		}
+	}
+	r.Attention = computeAttention(r.GetRequestHistory(), r.Comments, r.Repo.GetNotes(AttentionRef, r.Revision))
+	return nil
+}
+
+// NeedsAttentionFrom determines if the given user is in the attention set of the review.
+func (r *Review) NeedsAttentionFrom(user string) bool {
+	for _, email := range r.Attention {
+		if email == user {
+			return true
+		}
+	}
+	return false
+}
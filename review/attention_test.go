@@ -0,0 +1,88 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/request"
+	"reflect"
+	"testing"
+)
+
+func TestComputeAttention(t *testing.T) {
+	accepted, rejected := true, false
+	requests := []request.Request{
+		{Timestamp: "0000000001", Requester: "author", Reviewers: []string{"alice", "bob"}},
+	}
+	threads := []CommentThread{
+		{
+			Comment: comment.Comment{Timestamp: "0000000002", Author: "alice", Resolved: &rejected},
+			Children: []CommentThread{
+				{Comment: comment.Comment{Timestamp: "0000000004", Author: "author"}},
+			},
+		},
+		{Comment: comment.Comment{Timestamp: "0000000003", Author: "bob", Resolved: &accepted}},
+	}
+	for _, test := range []struct {
+		description string
+		threads     []CommentThread
+		notes       []repository.Note
+		expected    []string
+	}{
+		{"requested", nil, nil, []string{"alice", "bob"}},
+		{"reviewer commented", []CommentThread{{Comment: threads[0].Comment}}, nil, []string{"author", "bob"}},
+		{"reviewed and replied", threads, nil, []string{"alice"}},
+		{"explicitly changed", threads, []repository.Note{
+			repository.Note(`{"timestamp": "0000000005", "email": "alice", "added": false}`),
+			repository.Note(`{"timestamp": "0000000005", "email": "carol", "added": true}`),
+			repository.Note(`not a note`),
+		}, []string{"carol"}},
+	} {
+		if attention := computeAttention(requests, test.threads, test.notes); !reflect.DeepEqual(attention, test.expected) {
+			t.Errorf("Unexpected attention set when %s: got %v, want %v", test.description, attention, test.expected)
+		}
+	}
+	amended := append(requests, request.Request{Timestamp: "0000000001", Amended: "0000000006", Requester: "author", Reviewers: []string{"bob", "dave"}})
+	if attention := computeAttention(amended, threads, nil); !reflect.DeepEqual(attention, []string{"dave"}) {
+		t.Errorf("Unexpected attention set after changing the reviewers: %v", attention)
+	}
+}
+
+func TestSetAttention(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	r, err := Get(repo, repository.TestCommitG)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	if !reflect.DeepEqual(r.Attention, []string{"ojarjur"}) {
+		t.Fatalf("Unexpected initial attention set: %v", r.Attention)
+	}
+	if err := r.SetAttention("ojarjur", []string{"alice@example.com"}, []string{"ojarjur"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetAttention("ojarjur", []string{""}, nil); err == nil {
+		t.Fatal("Unexpectedly added an empty email address")
+	}
+	r, err = Get(repo, repository.TestCommitG)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to reload the review: %v", err)
+	}
+	if !r.NeedsAttentionFrom("alice@example.com") || r.NeedsAttentionFrom("ojarjur") {
+		t.Fatalf("Unexpected attention set: %v", r.Attention)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/analyses"
+	"github.com/google/git-appraise/review/ci"
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/request"
+	"strings"
+)
+
+// bulkRepo wraps a repository with the review data for many reviews, read in bulk, so
+// that loading those reviews does not require running a separate git command for each
+// of their notes, ancestry checks, and branches.
+//
+// Anything that was not read in bulk is passed through to the wrapped repository.
+type bulkRepo struct {
+	repository.Repo
+	// notes holds all of the notes under each of the review notes refs.
+	notes map[string]map[string][]repository.Note
+	// queried holds, for each target ref, the revisions whose ancestry was checked.
+	queried map[string]map[string]bool
+	// ancestors holds, for each target ref, the queried revisions that are its ancestors.
+	ancestors map[string]map[string]bool
+	// branches holds the commits that the local branches point to.
+	branches map[string]string
+	// shallow records whether the repository is a shallow clone.
+	shallow bool
+}
+
+// newBulkRepo reads the data needed to load the reviews for the given revisions in bulk.
+//
+// If that fails, the given repository is returned as is, which is slower but still correct.
+func newBulkRepo(repo repository.Repo, revisions []string) repository.Repo {
+	bulk := bulkRepo{
+		Repo:      repo,
+		notes:     make(map[string]map[string][]repository.Note),
+		queried:   make(map[string]map[string]bool),
+		ancestors: make(map[string]map[string]bool),
+	}
+	for _, ref := range []string{request.Ref, comment.Ref, LabelsRef, AttentionRef, ChecklistRef, ci.Ref, analyses.Ref} {
+		notes, err := repo.GetAllNotes(ref)
+		if err != nil {
+			return repo
+		}
+		bulk.notes[ref] = notes
+	}
+	for _, revision := range revisions {
+		requests := request.ParseAllValid(bulk.notes[request.Ref][revision])
+		if requests == nil {
+			continue
+		}
+		targetRef := requests[len(requests)-1].TargetRef
+		if bulk.queried[targetRef] == nil {
+			bulk.queried[targetRef] = make(map[string]bool)
+		}
+		bulk.queried[targetRef][revision] = true
+	}
+	for targetRef, queried := range bulk.queried {
+		var commits []string
+		for commit := range queried {
+			commits = append(commits, commit)
+		}
+		ancestors, err := repo.FindAncestors(commits, targetRef)
+		if err != nil {
+			// Leave the ancestry checks for this target to the wrapped repository.
+			delete(bulk.queried, targetRef)
+			continue
+		}
+		bulk.ancestors[targetRef] = ancestors
+	}
+	branches, err := repo.ListRefs("refs/heads")
+	if err != nil {
+		return repo
+	}
+	bulk.branches = branches
+	if bulk.shallow, err = repo.IsShallow(); err != nil {
+		return repo
+	}
+	return bulk
+}
+
+// GetNotes returns the notes read in bulk, if the given ref was read.
+func (repo bulkRepo) GetNotes(notesRef, revision string) []repository.Note {
+	if notes, ok := repo.notes[notesRef]; ok {
+		return notes[revision]
+	}
+	return repo.Repo.GetNotes(notesRef, revision)
+}
+
+// IsAncestor returns the ancestry found in bulk, if it was checked for the given commits.
+func (repo bulkRepo) IsAncestor(ancestor, descendant string) (bool, error) {
+	if repo.queried[descendant][ancestor] {
+		return repo.ancestors[descendant][ancestor], nil
+	}
+	return repo.Repo.IsAncestor(ancestor, descendant)
+}
+
+// IsShallow returns whether or not the repository is a shallow clone, as read when the bulk data was.
+func (repo bulkRepo) IsShallow() (bool, error) {
+	return repo.shallow, nil
+}
+
+// ResolveRefCommit returns the commit of the given ref, if it is a local branch.
+//
+// Branches always point to commits, so their values can be used as is.
+func (repo bulkRepo) ResolveRefCommit(ref string) (string, error) {
+	if commit, ok := repo.branches[ref]; ok && strings.HasPrefix(ref, "refs/heads/") {
+		return commit, nil
+	}
+	return repo.Repo.ResolveRefCommit(ref)
+}
+
+// getAll loads the reviews for the given revisions, skipping those that are not reviews.
+//
+// The notes, and other git data, for all of the reviews are read in bulk.
+func getAll(repo repository.Repo, revisions []string) []Review {
+	bulk := newBulkRepo(repo, revisions)
+	var reviews []Review
+	for _, revision := range revisions {
+		review, err := Get(bulk, revision)
+		if err == nil && review != nil {
+			review.Repo = repo
+			reviews = append(reviews, *review)
+		}
+	}
+	return reviews
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"encoding/json"
+	"github.com/google/git-appraise/repository"
+	"sort"
+	"testing"
+)
+
+func TestBulkLoadingMatchesGet(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	revisions := repo.ListNotedRevisions(repository.TestRequestsRef)
+	sort.Strings(revisions)
+	var reviews []Review
+	for _, revision := range revisions {
+		r, err := Get(repo, revision)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r != nil {
+			reviews = append(reviews, *r)
+		}
+	}
+	bulkReviews := getAll(repo, revisions)
+	for _, r := range bulkReviews {
+		if _, ok := r.Repo.(bulkRepo); ok {
+			t.Fatalf("The review %q was not loaded from the original repository", r.Revision)
+		}
+	}
+	expectedJson, err := json.Marshal(reviews)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bulkJson, err := json.Marshal(bulkReviews)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reviews) == 0 || string(bulkJson) != string(expectedJson) {
+		t.Fatalf("The reviews loaded in bulk %s do not match the individually loaded reviews %s", bulkJson, expectedJson)
+	}
+}
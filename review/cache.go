@@ -0,0 +1,370 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"encoding/json"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/analyses"
+	"github.com/google/git-appraise/review/ci"
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/request"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// cacheDir is the directory (inside of the git directory) that holds the review cache.
+	cacheDir = "appraise-cache"
+	// cacheFile is the name of the review cache file inside of the cache directory.
+	cacheFile = "reviews.json"
+	// cacheVersion is the version of the cache format; caches with any other version are rebuilt.
+	cacheVersion = 9
+	// bulkLoadThreshold is the number of stale reviews above which their data is read in bulk.
+	bulkLoadThreshold = 10
+)
+
+// cacheEntry holds a single cached review, along with the state it was computed from.
+type cacheEntry struct {
+	// Review is nil if the revision has request notes, but could not be loaded as a review.
+	Review *Review `json:"review,omitempty"`
+	// HeadCommit is the commit whose CI and analyses notes were read.
+	HeadCommit string `json:"headCommit,omitempty"`
+	// Dependencies holds the values of the refs that the review was computed from.
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+}
+
+// reviewCache holds the parsed reviews, as of the given tips of the notes refs.
+type reviewCache struct {
+	Version   int                   `json:"version"`
+	NotesTips map[string]string     `json:"notesTips"`
+	Entries   map[string]cacheEntry `json:"entries"`
+}
+
+// refsByName indexes the refs of a repository, so that the refs a review depends on can be found quickly.
+type refsByName struct {
+	refs map[string]string
+	// bySuffix maps every trailing sequence of path components of a ref, such as
+	// "master" or "origin/master", to the refs ending with it.
+	bySuffix map[string][]string
+}
+
+func newRefsByName(refs map[string]string) refsByName {
+	index := refsByName{refs: refs, bySuffix: make(map[string][]string)}
+	for ref := range refs {
+		for i := 0; i < len(ref); i++ {
+			if ref[i] == '/' {
+				index.bySuffix[ref[i+1:]] = append(index.bySuffix[ref[i+1:]], ref)
+			}
+		}
+	}
+	return index
+}
+
+// dependencies returns the values of the refs that loading the given review consults.
+//
+// This includes the review's target and review refs, along with any ref (such as a
+// remote-tracking branch) that could stand in for one of them if it does not exist locally.
+func (index refsByName) dependencies(r *Review) map[string]string {
+	dependencies := make(map[string]string)
+	for _, ref := range []string{r.Request.TargetRef, r.Request.ReviewRef} {
+		if ref == "" {
+			continue
+		}
+		dependencies[ref] = index.refs[ref]
+		for _, similarRef := range index.bySuffix[strings.TrimPrefix(ref, "refs/heads/")] {
+			dependencies[similarRef] = index.refs[similarRef]
+		}
+	}
+	return dependencies
+}
+
+// isCurrent determines if none of the given ref values have changed.
+func (index refsByName) isCurrent(dependencies map[string]string) bool {
+	for ref, value := range dependencies {
+		if index.refs[ref] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// readCache reads the review cache from the given path, returning an empty cache if it cannot be read.
+func readCache(cachePath string) reviewCache {
+	empty := reviewCache{
+		Version:   cacheVersion,
+		NotesTips: make(map[string]string),
+		Entries:   make(map[string]cacheEntry),
+	}
+	cacheBytes, err := ioutil.ReadFile(cachePath)
+	if err != nil {
+		return empty
+	}
+	var cache reviewCache
+	if err := json.Unmarshal(cacheBytes, &cache); err != nil || cache.Version != cacheVersion ||
+		cache.NotesTips == nil || cache.Entries == nil {
+		return empty
+	}
+	return cache
+}
+
+// writeCache atomically replaces the review cache at the given path.
+func writeCache(cachePath string, cache reviewCache) error {
+	cacheBytes, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return err
+	}
+	tempFile, err := ioutil.TempFile(filepath.Dir(cachePath), cacheFile)
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.Write(cacheBytes)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+	return os.Rename(tempFile.Name(), cachePath)
+}
+
+// hasNotes determines if any of the given notes are not blank.
+func hasNotes(notes []repository.Note) bool {
+	for _, note := range notes {
+		if strings.TrimSpace(string(note)) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// changedObjects returns the objects whose notes under the given ref changed since the cached tip.
+func changedObjects(repo repository.Repo, ref string, cache reviewCache, tips map[string]string) (map[string]bool, error) {
+	changed := make(map[string]bool)
+	if cache.NotesTips[ref] == tips[ref] {
+		return changed, nil
+	}
+	objects, err := repo.ListChangedNotes(cache.NotesTips[ref], tips[ref])
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		changed[object] = true
+	}
+	return changed, nil
+}
+
+// updateCache brings the given cache up to date with the repository, re-reading only those
+// reviews whose notes, or the refs they depend on, have changed since it was written.
+//
+// If visit is non-nil, then it is called with each of the reviews, in order of revision, as
+// soon as that review is up to date. Any error is returned before visit is first called. If
+// progress is non-nil, then it is called after each review that is re-read, with the number
+// re-read so far, and the number that need to be.
+func updateCache(repo repository.Repo, cache reviewCache, visit func(Review), progress func(parsed, total int)) (reviewCache, error) {
+	tips := make(map[string]string)
+	for _, ref := range []string{request.Ref, comment.Ref, LabelsRef, AttentionRef, ChecklistRef, ci.Ref, analyses.Ref} {
+		tip, err := repo.GetNotesTip(ref)
+		if err != nil {
+			return cache, err
+		}
+		tips[ref] = tip
+	}
+	refs, err := repo.ListRefs("refs")
+	if err != nil {
+		return cache, err
+	}
+	index := newRefsByName(refs)
+
+	stale := make(map[string]bool)
+	for _, ref := range []string{request.Ref, comment.Ref, LabelsRef, AttentionRef, ChecklistRef} {
+		changed, err := changedObjects(repo, ref, cache, tips)
+		if err != nil {
+			return cache, err
+		}
+		for revision := range changed {
+			stale[revision] = true
+		}
+	}
+	changedHeads := make(map[string]bool)
+	for _, ref := range []string{ci.Ref, analyses.Ref} {
+		changed, err := changedObjects(repo, ref, cache, tips)
+		if err != nil {
+			return cache, err
+		}
+		for commit := range changed {
+			changedHeads[commit] = true
+		}
+	}
+	for revision, entry := range cache.Entries {
+		// Revisions that could not be loaded are retried, since they may be loadable once more objects are fetched.
+		if entry.Review == nil || entry.Review.Unavailable || changedHeads[entry.HeadCommit] || !index.isCurrent(entry.Dependencies) {
+			stale[revision] = true
+		}
+	}
+
+	loader := repo
+	isCommit := func(revision string) bool { return repo.VerifyCommit(revision) == nil }
+	// In a shallow clone, reviews of missing commits are loaded anyway, and marked as unavailable.
+	shallow, err := repo.IsShallow()
+	if err != nil {
+		return cache, err
+	}
+	if len(stale) > bulkLoadThreshold {
+		var revisions []string
+		for revision := range stale {
+			revisions = append(revisions, revision)
+		}
+		loader = newBulkRepo(repo, revisions)
+		commits := make(map[string]bool)
+		for _, revision := range repo.ListNotedRevisions(request.Ref) {
+			commits[revision] = true
+		}
+		isCommit = func(revision string) bool { return commits[revision] }
+	}
+	revisions := make([]string, 0, len(cache.Entries)+len(stale))
+	for revision := range cache.Entries {
+		if !stale[revision] {
+			revisions = append(revisions, revision)
+		}
+	}
+	for revision := range stale {
+		revisions = append(revisions, revision)
+	}
+	sort.Strings(revisions)
+	parsed := 0
+	for _, revision := range revisions {
+		if stale[revision] {
+			cache.Entries = updateCacheEntry(repo, loader, cache.Entries, revision, shallow || isCommit(revision), index)
+			parsed++
+			if progress != nil {
+				progress(parsed, len(stale))
+			}
+		}
+		if entry, ok := cache.Entries[revision]; ok && entry.Review != nil && visit != nil {
+			r := *entry.Review
+			r.Repo = repo
+			visit(r)
+		}
+	}
+	cache.NotesTips = tips
+	return cache, nil
+}
+
+// updateCacheEntry re-reads the review for the given revision into the given cache entries.
+//
+// Like ListAll, this skips notes on objects that are not (yet) known commits, unless load
+// is set. Those are kept in the cache without a review, so that they are retried later, as
+// are the reviews that are unavailable in a shallow clone.
+func updateCacheEntry(repo, loader repository.Repo, entries map[string]cacheEntry, revision string, load bool, index refsByName) map[string]cacheEntry {
+	delete(entries, revision)
+	if !hasNotes(loader.GetNotes(request.Ref, revision)) {
+		return entries
+	}
+	var r *Review
+	if load {
+		var err error
+		if r, err = Get(loader, revision); err == nil && r == nil {
+			return entries
+		}
+	}
+	entry := cacheEntry{}
+	if r != nil {
+		entry.Review = r
+		entry.HeadCommit, _ = r.GetHeadCommit()
+		entry.Dependencies = index.dependencies(r)
+		r.Repo = repo
+	}
+	entries[revision] = entry
+	return entries
+}
+
+// getCachePath returns the path of the review cache for the given repository.
+func getCachePath(repo repository.Repo) (string, error) {
+	gitDir, err := repo.GetGitDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(gitDir, cacheDir, cacheFile), nil
+}
+
+// walkAllCached implements WalkAllCached, using the cache at the given path.
+func walkAllCached(repo repository.Repo, cachePath string, rebuild bool, visit func(Review), progress func(parsed, total int)) {
+	cache := readCache(cachePath)
+	if rebuild {
+		cache = readCache("")
+	}
+	cache, err := updateCache(repo, cache, visit, progress)
+	if err != nil {
+		// The cached tips may refer to notes commits that no longer exist, so start over.
+		cache, err = updateCache(repo, readCache(""), visit, progress)
+		if err != nil {
+			for _, r := range ListAll(repo) {
+				visit(r)
+			}
+			return
+		}
+	}
+	// Failing to write the cache only makes the next listing slower.
+	writeCache(cachePath, cache)
+}
+
+// listAllCached implements ListAllCached, using the cache at the given path.
+func listAllCached(repo repository.Repo, cachePath string, rebuild bool) []Review {
+	var reviews []Review
+	walkAllCached(repo, cachePath, rebuild, func(r Review) {
+		reviews = append(reviews, r)
+	}, nil)
+	return reviews
+}
+
+// ListAllCached returns all reviews stored in the git-notes, like ListAll, sorted by revision.
+//
+// The reviews are cached inside of the git directory, keyed by the tips of the notes refs, so
+// only the reviews whose notes (or target and review refs) have changed since the last call are
+// re-read. If rebuild is true, then the cache is ignored, and rebuilt from scratch.
+func ListAllCached(repo repository.Repo, rebuild bool) []Review {
+	cachePath, err := getCachePath(repo)
+	if err != nil {
+		return ListAll(repo)
+	}
+	return listAllCached(repo, cachePath, rebuild)
+}
+
+// WalkAllCached calls visit with each of the reviews stored in the git-notes, like
+// ListAllCached, but as soon as each review has been read, rather than once all of them have.
+//
+// The reviews are visited in order of revision. If progress is non-nil, then it is called
+// after each review that is re-read (rather than taken from the cache), with the number
+// re-read so far, and the number that need to be.
+func WalkAllCached(repo repository.Repo, rebuild bool, visit func(Review), progress func(parsed, total int)) {
+	cachePath, err := getCachePath(repo)
+	if err != nil {
+		for _, r := range ListAll(repo) {
+			visit(r)
+		}
+		return
+	}
+	walkAllCached(repo, cachePath, rebuild, visit, progress)
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/ci"
+	"github.com/google/git-appraise/review/comment"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// checkCachedListing verifies that the cached listing of reviews matches the uncached one.
+func checkCachedListing(t *testing.T, repo repository.Repo, cachePath string, rebuild bool) []Review {
+	uncached := ListAll(repo)
+	sort.Slice(uncached, func(i, j int) bool { return uncached[i].Revision < uncached[j].Revision })
+	cached := listAllCached(repo, cachePath, rebuild)
+	uncachedJson, err := json.Marshal(uncached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cachedJson, err := json.Marshal(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cachedJson) != string(uncachedJson) {
+		t.Fatalf("The cached listing %s does not match the uncached listing %s", cachedJson, uncachedJson)
+	}
+	return cached
+}
+
+func TestListAllCached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-appraise-cache-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cachePath := filepath.Join(dir, cacheDir, cacheFile)
+	repo := repository.NewMockRepoForTest()
+
+	if reviews := checkCachedListing(t, repo, cachePath, false); len(reviews) != 3 {
+		t.Fatalf("Unexpected reviews: %v", reviews)
+	}
+	if _, err := os.Stat(cachePath); err != nil {
+		t.Fatalf("The cache was not written: %v", err)
+	}
+	checkCachedListing(t, repo, cachePath, false)
+
+	// A new comment, and a new CI report on the head commit of an open review.
+	if err := repo.AppendNote(comment.Ref, repository.TestCommitG, repository.Note(repository.TestDiscussD)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AppendNote(ci.Ref, repository.TestCommitI, repository.Note(`{"timestamp": "0000000009", "status": "success"}`)); err != nil {
+		t.Fatal(err)
+	}
+	reviews := checkCachedListing(t, repo, cachePath, false)
+	if len(reviews[2].Comments) != 1 || len(reviews[2].Reports) != 1 {
+		t.Fatalf("The cached review was not updated: %+v", reviews[2])
+	}
+
+	// Compacting the notes, as "gc" does.
+	dedupe := func(object string, notes []repository.Note) []repository.Note {
+		var kept []repository.Note
+		for _, note := range notes {
+			if len(note) > 0 {
+				kept = append(kept, note)
+			}
+		}
+		return kept
+	}
+	if err := repo.CompactNotes(comment.Ref, "refs/test-backup", dedupe); err != nil {
+		t.Fatal(err)
+	}
+	checkCachedListing(t, repo, cachePath, false)
+
+	if err := ioutil.WriteFile(cachePath, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkCachedListing(t, repo, cachePath, false)
+	checkCachedListing(t, repo, cachePath, true)
+}
+
+func TestWalkAllCached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-appraise-cache-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cachePath := filepath.Join(dir, cacheDir, cacheFile)
+	repo := repository.NewMockRepoForTest()
+
+	// walk returns the revisions visited, and the progress reported, in the order they happened.
+	walk := func() []string {
+		var events []string
+		walkAllCached(repo, cachePath, false, func(r Review) {
+			events = append(events, r.Revision)
+		}, func(parsed, total int) {
+			events = append(events, fmt.Sprintf("%d/%d", parsed, total))
+		})
+		return events
+	}
+	// Each review is visited as soon as it has been read, rather than after all of them.
+	expected := []string{"1/3", repository.TestCommitB, "2/3", repository.TestCommitD, "3/3", repository.TestCommitG}
+	if events := walk(); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("Unexpected walk of an empty cache: got %v, want %v", events, expected)
+	}
+	expected = []string{repository.TestCommitB, repository.TestCommitD, repository.TestCommitG}
+	if events := walk(); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("Unexpected walk of an up to date cache: got %v, want %v", events, expected)
+	}
+}
+
+func TestCacheDependencies(t *testing.T) {
+	index := newRefsByName(map[string]string{
+		"refs/heads/master":                 "A",
+		"refs/remotes/origin/master":        "B",
+		"refs/remotes/origin/ojarjur/other": "C",
+	})
+	r := &Review{}
+	r.Request.TargetRef = "refs/heads/master"
+	r.Request.ReviewRef = "refs/heads/ojarjur/mychange"
+	dependencies := index.dependencies(r)
+	expected := map[string]string{
+		"refs/heads/master":           "A",
+		"refs/remotes/origin/master":  "B",
+		"refs/heads/ojarjur/mychange": "",
+	}
+	if len(dependencies) != len(expected) {
+		t.Fatalf("Unexpected dependencies: %v", dependencies)
+	}
+	for ref, value := range expected {
+		if dependencies[ref] != value {
+			t.Fatalf("Unexpected dependencies: %v", dependencies)
+		}
+	}
+	if !index.isCurrent(dependencies) {
+		t.Fatal("Unchanged dependencies were reported as changed")
+	}
+	for _, changedRefs := range []map[string]string{
+		{"refs/heads/master": "D", "refs/remotes/origin/master": "B"},
+		{"refs/heads/master": "A", "refs/remotes/origin/master": "B", "refs/heads/ojarjur/mychange": "E"},
+	} {
+		if newRefsByName(changedRefs).isCurrent(dependencies) {
+			t.Fatalf("Changed dependencies %v were reported as current", changedRefs)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChecklistRef defines the git-notes ref recording the checklist items checked, and unchecked, on each review.
+const ChecklistRef = "refs/notes/devtools/checklist"
+
+// checklistNote is the format of the notes in ChecklistRef, each of which records that an
+// item of a review's checklist was checked or unchecked.
+//
+// Since notes are merged by concatenating them, the latest note for each item is the one that counts.
+type checklistNote struct {
+	Timestamp string `json:"timestamp"`
+	Author    string `json:"author,omitempty"`
+	Item      string `json:"item"`
+	Checked   bool   `json:"checked"`
+}
+
+// ChecklistItem is a single item of a review's checklist.
+type ChecklistItem struct {
+	Item    string `json:"item"`
+	Checked bool   `json:"checked"`
+	// CheckedBy and Timestamp record who checked the item, and when.
+	CheckedBy string `json:"checkedBy,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+}
+
+// parseChecklist returns the given items of a checklist, each checked if its latest note in
+// the given notes checks it. Notes for items that are not in the checklist are ignored.
+func parseChecklist(items []string, notes []repository.Note) []ChecklistItem {
+	latest := make(map[string]checklistNote)
+	latestTimestamps := make(map[string]int64)
+	for _, note := range notes {
+		var parsed checklistNote
+		if err := json.Unmarshal([]byte(note), &parsed); err != nil || parsed.Item == "" {
+			continue
+		}
+		timestamp, err := strconv.ParseInt(parsed.Timestamp, 10, 64)
+		if err != nil {
+			continue
+		}
+		if previous, ok := latestTimestamps[parsed.Item]; !ok || timestamp >= previous {
+			latest[parsed.Item], latestTimestamps[parsed.Item] = parsed, timestamp
+		}
+	}
+	var checklist []ChecklistItem
+	for _, item := range items {
+		checklistItem := ChecklistItem{Item: item}
+		if note, ok := latest[item]; ok && note.Checked {
+			checklistItem.Checked = true
+			checklistItem.CheckedBy = note.Author
+			checklistItem.Timestamp = note.Timestamp
+		}
+		checklist = append(checklist, checklistItem)
+	}
+	return checklist
+}
+
+// UncheckedItems returns the items of the review's checklist that have not been checked.
+func (r *Review) UncheckedItems() []string {
+	var unchecked []string
+	for _, item := range r.Checklist {
+		if !item.Checked {
+			unchecked = append(unchecked, item.Item)
+		}
+	}
+	return unchecked
+}
+
+// FindChecklistItem returns the item of the review's checklist that the given argument
+// names, which is either the item itself, or its number, counting from 1.
+func (r *Review) FindChecklistItem(arg string) (string, error) {
+	if len(r.Checklist) == 0 {
+		return "", fmt.Errorf("Review %.12s has no checklist.", r.Revision)
+	}
+	if number, err := strconv.Atoi(arg); err == nil && number >= 1 && number <= len(r.Checklist) {
+		return r.Checklist[number-1].Item, nil
+	}
+	var items []string
+	for i, item := range r.Checklist {
+		if item.Item == arg {
+			return item.Item, nil
+		}
+		items = append(items, fmt.Sprintf("%d. %s", i+1, item.Item))
+	}
+	return "", fmt.Errorf("The checklist of review %.12s has no item %q; expected one of:\n  %s", r.Revision, arg, strings.Join(items, "\n  "))
+}
+
+// SetChecked records that the given author checked, or unchecked, the given item of the
+// review's checklist.
+func (r *Review) SetChecked(author, item string, checked bool) error {
+	if _, err := r.FindChecklistItem(item); err != nil {
+		return err
+	}
+	noteBytes, err := json.Marshal(checklistNote{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Author:    author,
+		Item:      item,
+		Checked:   checked,
+	})
+	if err != nil {
+		return err
+	}
+	if err := r.Repo.AppendNote(ChecklistRef, r.Revision, repository.Note(noteBytes)); err != nil {
+		return err
+	}
+	r.Checklist = parseChecklist(r.Request.Checklist, r.Repo.GetNotes(ChecklistRef, r.Revision))
+	return nil
+}
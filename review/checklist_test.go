@@ -0,0 +1,54 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"github.com/google/git-appraise/repository"
+	"reflect"
+	"testing"
+)
+
+func TestParseChecklist(t *testing.T) {
+	notes := []repository.Note{
+		repository.Note(`{"timestamp": "0000000003", "author": "bob@example.com", "item": "Docs updated", "checked": false}`),
+		repository.Note(`{"timestamp": "0000000001", "author": "alice@example.com", "item": "Docs updated", "checked": true}`),
+		repository.Note(`{"timestamp": "0000000002", "author": "alice@example.com", "item": "Tests added", "checked": true}`),
+		repository.Note(`{"timestamp": "0000000004", "author": "alice@example.com", "item": "Removed item", "checked": true}`),
+		repository.Note(`not json`),
+	}
+	expected := []ChecklistItem{
+		{Item: "Tests added", Checked: true, CheckedBy: "alice@example.com", Timestamp: "0000000002"},
+		{Item: "Docs updated"},
+	}
+	if checklist := parseChecklist([]string{"Tests added", "Docs updated"}, notes); !reflect.DeepEqual(checklist, expected) {
+		t.Fatalf("Unexpected checklist: %+v", checklist)
+	}
+}
+
+func TestFindChecklistItem(t *testing.T) {
+	r := &Review{Checklist: []ChecklistItem{{Item: "Tests added"}, {Item: "2"}}}
+	for arg, expected := range map[string]string{"1": "Tests added", "Tests added": "Tests added", "2": "2"} {
+		if item, err := r.FindChecklistItem(arg); err != nil || item != expected {
+			t.Errorf("Unexpected item for %q: %q, %v", arg, item, err)
+		}
+	}
+	for _, arg := range []string{"0", "3", "Docs updated"} {
+		if item, err := r.FindChecklistItem(arg); err == nil {
+			t.Errorf("Unexpectedly found the item %q for %q", item, arg)
+		}
+	}
+}
@@ -20,6 +20,7 @@ package ci
 import (
 	"encoding/json"
 	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/schema"
 	"sort"
 	"strconv"
 )
@@ -47,6 +48,35 @@ type Report struct {
 	Agent     string `json:"agent,omitempty"`
 	// Version represents the version of the metadata format.
 	Version int `json:"v,omitempty"`
+	// Extensions holds any fields that are not understood by this version of the tool, so
+	// that they are preserved if the note is rewritten.
+	Extensions schema.Extensions `json:"-"`
+}
+
+// UnmarshalJSON parses a CI report, keeping any fields that are not understood in the Extensions field.
+func (report *Report) UnmarshalJSON(data []byte) error {
+	type plainReport Report
+	var plain plainReport
+	if err := json.Unmarshal(data, &plain); err != nil {
+		return err
+	}
+	extensions, err := schema.Split(data, plain)
+	if err != nil {
+		return err
+	}
+	*report = Report(plain)
+	report.Extensions = extensions
+	return nil
+}
+
+// MarshalJSON serializes a CI report, including any fields that were not understood when it was parsed.
+func (report Report) MarshalJSON() ([]byte, error) {
+	type plainReport Report
+	bytes, err := json.Marshal(plainReport(report))
+	if err != nil {
+		return nil, err
+	}
+	return report.Extensions.AppendTo(bytes)
 }
 
 // Parse parses a CI report from a git note.
@@ -85,6 +115,9 @@ func ParseAllValid(notes []repository.Note) []Report {
 	var reports []Report
 	for _, note := range notes {
 		report, err := Parse(note)
+		if err == nil && report.Version > FormatVersion {
+			schema.WarnUnsupportedVersion("CI report", report.Version, FormatVersion)
+		}
 		if err == nil && report.Version == FormatVersion {
 			if report.Status == "" || report.Status == StatusSuccess || report.Status == StatusFailure {
 				reports = append(reports, report)
@@ -93,3 +126,9 @@ func ParseAllValid(notes []repository.Note) []Report {
 	}
 	return reports
 }
+
+// Write writes a CI report as a JSON-formatted git note.
+func (report Report) Write() (repository.Note, error) {
+	bytes, err := json.Marshal(report)
+	return repository.Note(bytes), err
+}
@@ -83,3 +83,15 @@ func TestCIReport(t *testing.T) {
 		t.Fatal("This is not the latest ", latestReport)
 	}
 }
+
+func TestRoundTripPreservesUnknownFields(t *testing.T) {
+	note := repository.Note(`{"timestamp":"4","status":"success","agent":"bot","duration":"5m"}`)
+	report, err := Parse(note)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := report.Write()
+	if err != nil || string(written) != string(note) {
+		t.Fatalf("Round-tripping the report changed it to %q, %v", written, err)
+	}
+}
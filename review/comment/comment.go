@@ -20,15 +20,32 @@ package comment
 import (
 	"crypto/sha1"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/schema"
+	"path"
 	"strconv"
+	"strings"
 	"time"
 )
 
 // Ref defines the git-notes ref that we expect to contain review comments.
 const Ref = "refs/notes/devtools/discuss"
 
+// AttachmentsRef defines the git-notes ref used to hold the contents of file attachments.
+const AttachmentsRef = "refs/notes/devtools/attachments"
+
+// Reactions that can be attached to a comment.
+const (
+	ReactionThumbsUp   = "thumbsup"
+	ReactionThumbsDown = "thumbsdown"
+	ReactionEyes       = "eyes"
+)
+
+// Reactions lists all of the supported reactions.
+var Reactions = []string{ReactionThumbsUp, ReactionThumbsDown, ReactionEyes}
+
 // FormatVersion defines the latest version of the comment format supported by the tool.
 const FormatVersion = 0
 
@@ -44,6 +61,33 @@ type Location struct {
 	Path string `json:"path,omitempty"`
 	// If the range is omitted, then the location represents an entire file.
 	Range *Range `json:"range,omitempty"`
+	// Pinned is set if the comment is about the file as it is in the given commit, such as
+	// one of the earlier commits of a multi-commit review. Otherwise the commit is just the
+	// latest one in the review when the comment was made, and the comment follows its line
+	// as the review changes.
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// NormalizePath converts a file path into the form stored in comment locations, which
+// uses forward slashes as separators regardless of the operating system, so that the
+// locations of comments written on any platform match on every other one.
+//
+// Backslashes are always treated as separators, since that is what they almost always are.
+func NormalizePath(filePath string) string {
+	if filePath == "" {
+		return ""
+	}
+	return strings.TrimPrefix(path.Clean(strings.Replace(filePath, "\\", "/", -1)), "./")
+}
+
+// Attachment represents a link or a file attached to a comment.
+type Attachment struct {
+	// URL links to an external resource, such as a design doc.
+	URL string `json:"url,omitempty"`
+	// Blob is the hash of the git blob that holds the contents of an attached file.
+	Blob string `json:"blob,omitempty"`
+	// Name is the name of the attached file.
+	Name string `json:"name,omitempty"`
 }
 
 // Comment represents a review comment, and can occur in any of the following contexts:
@@ -68,8 +112,61 @@ type Comment struct {
 	// has been addressed. Otherwise, the parent is the commit, and this means that the
 	// change has been accepted. If the resolved bit is unset, then the comment is only an FYI.
 	Resolved *bool `json:"resolved,omitempty"`
+	// Attachments are links or files that accompany the comment.
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// If reaction is provided, then the comment is only a reaction (such as "thumbsup")
+	// to its parent comment, rather than a response. Reactions are aggregated on their
+	// parent comments, and do not affect whether or not a thread is resolved.
+	Reaction string `json:"reaction,omitempty"`
+	// If scope is provided on an accepting comment, then it describes which parts of the
+	// change are accepted, such as "the API, but not the tests".
+	Scope string `json:"scope,omitempty"`
+	// The conditional bit indicates that an accepting comment does not take effect until
+	// the requester of the review has responded to it.
+	Conditional bool `json:"conditional,omitempty"`
+	// If category is provided on a rejecting comment, then it tags the reason for the
+	// rejection, such as "needs-tests" or "design-concern".
+	Category string `json:"category,omitempty"`
+	// Snapshot is the head commit of the review when the comment was made, so that later
+	// versions of the review can be compared against what the author had seen.
+	Snapshot string `json:"snapshot,omitempty"`
+	// If message ID is provided, then the comment was imported from the email with that
+	// Message-Id, so that importing the same email again does not duplicate the comment.
+	MessageID string `json:"messageId,omitempty"`
+	// Signature is an optional (armored) signature of the rest of the comment, made by
+	// its author. See SignedPayload for exactly what is signed.
+	Signature string `json:"signature,omitempty"`
 	// Version represents the version of the metadata format.
 	Version int `json:"v,omitempty"`
+	// Extensions holds any fields that are not understood by this version of the tool, so
+	// that they are preserved if the note is rewritten.
+	Extensions schema.Extensions `json:"-"`
+}
+
+// UnmarshalJSON parses a review comment, keeping any fields that are not understood in the Extensions field.
+func (comment *Comment) UnmarshalJSON(data []byte) error {
+	type plainComment Comment
+	var plain plainComment
+	if err := json.Unmarshal(data, &plain); err != nil {
+		return err
+	}
+	extensions, err := schema.Split(data, plain)
+	if err != nil {
+		return err
+	}
+	*comment = Comment(plain)
+	comment.Extensions = extensions
+	return nil
+}
+
+// MarshalJSON serializes a review comment, including any fields that were not understood when it was parsed.
+func (comment Comment) MarshalJSON() ([]byte, error) {
+	type plainComment Comment
+	bytes, err := json.Marshal(plainComment(comment))
+	if err != nil {
+		return nil, err
+	}
+	return comment.Extensions.AppendTo(bytes)
 }
 
 // New returns a new comment with the given description message.
@@ -99,6 +196,9 @@ func ParseAllValid(notes []repository.Note) map[string]Comment {
 	comments := make(map[string]Comment)
 	for _, note := range notes {
 		comment, err := Parse(note)
+		if err == nil && comment.Version > FormatVersion {
+			schema.WarnUnsupportedVersion("review comment", comment.Version, FormatVersion)
+		}
 		if err == nil && comment.Version == FormatVersion {
 			hash, err := comment.Hash()
 			if err == nil {
@@ -135,3 +235,36 @@ func (comment Comment) Hash() (string, error) {
 	bytes, err := comment.serialize()
 	return fmt.Sprintf("%x", sha1.Sum(bytes)), err
 }
+
+// SignedPayload returns the data covered by the comment's signature, which is the
+// comment as it would be written without a signature.
+func (comment Comment) SignedPayload() ([]byte, error) {
+	comment.Signature = ""
+	return comment.serialize()
+}
+
+// Sign signs the comment using the user's signing key, replacing any previous signature.
+func (comment *Comment) Sign(repo repository.Repo) error {
+	payload, err := comment.SignedPayload()
+	if err != nil {
+		return err
+	}
+	signature, err := repo.SignPayload(payload)
+	if err != nil {
+		return err
+	}
+	comment.Signature = signature
+	return nil
+}
+
+// VerifySignature verifies the comment's signature, and returns the identity of the signer.
+func (comment Comment) VerifySignature(repo repository.Repo) (string, error) {
+	if comment.Signature == "" {
+		return "", errors.New("The comment is not signed.")
+	}
+	payload, err := comment.SignedPayload()
+	if err != nil {
+		return "", err
+	}
+	return repo.VerifySignature(payload, comment.Signature)
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package comment
+
+import (
+	"github.com/google/git-appraise/repository"
+	"testing"
+)
+
+func TestRoundTripPreservesUnknownFields(t *testing.T) {
+	note := repository.Note(`{"timestamp":"0000000001","author":"ojarjur","description":"Comment","edited":{"timestamp":"0000000002"}}`)
+	comments := ParseAllValid([]repository.Note{note})
+	if len(comments) != 1 {
+		t.Fatalf("Unexpected comments: %v", comments)
+	}
+	for hash, c := range comments {
+		written, err := c.Write()
+		if err != nil || string(written) != string(note) {
+			t.Fatalf("Round-tripping the comment changed it to %q, %v", written, err)
+		}
+		if rehash, err := c.Hash(); err != nil || rehash != hash {
+			t.Fatalf("Round-tripping the comment changed its hash from %q to %q, %v", hash, rehash, err)
+		}
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	for path, expected := range map[string]string{
+		"":                   "",
+		"README.md":          "README.md",
+		"./README.md":        "README.md",
+		"commands/list.go":   "commands/list.go",
+		`commands\list.go`:   "commands/list.go",
+		`.\commands\list.go`: "commands/list.go",
+		"commands//list.go":  "commands/list.go",
+	} {
+		if normalized := NormalizePath(path); normalized != expected {
+			t.Errorf("Unexpected normalization of %q: got %q, expected %q", path, normalized, expected)
+		}
+	}
+}
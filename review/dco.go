@@ -0,0 +1,97 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"strings"
+)
+
+// RequireSignoffConfigKey is the git config key that, if true, requires every commit in a
+// review to have a "Signed-off-by" trailer from its author (as for the Developer Certificate
+// of Origin) before the review can be submitted.
+const RequireSignoffConfigKey = "appraise.requireSignoff"
+
+// signoffTrailer is the key of the trailer that records a sign-off in a commit message.
+const signoffTrailer = "Signed-off-by:"
+
+// SignoffRequired returns whether or not the commits in reviews have to be signed off by their authors.
+func SignoffRequired(repo repository.Repo) bool {
+	return repository.IsConfigTrue(repo, RequireSignoffConfigKey)
+}
+
+// MissingSignoff describes a commit that is not signed off by its author.
+type MissingSignoff struct {
+	Commit  string `json:"commit"`
+	Author  string `json:"author"`
+	Summary string `json:"summary"`
+}
+
+func (m MissingSignoff) String() string {
+	return fmt.Sprintf("%.12s %s (%s)", m.Commit, m.Summary, m.Author)
+}
+
+// hasSignoff determines if the given commit message has a sign-off trailer by the given author.
+func hasSignoff(message, authorEmail string) bool {
+	for _, line := range strings.Split(message, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) < len(signoffTrailer) || !strings.EqualFold(line[:len(signoffTrailer)], signoffTrailer) {
+			continue
+		}
+		if strings.Contains(strings.ToLower(line), "<"+strings.ToLower(authorEmail)+">") {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatSignoff returns the sign-off trailer for the given name and email address.
+func FormatSignoff(name, email string) string {
+	if name == "" {
+		return fmt.Sprintf("%s <%s>", signoffTrailer, email)
+	}
+	return fmt.Sprintf("%s %s <%s>", signoffTrailer, name, email)
+}
+
+// FindMissingSignoffs returns the given commits that are not signed off by their authors.
+func FindMissingSignoffs(repo repository.Repo, commits []string) ([]MissingSignoff, error) {
+	var missing []MissingSignoff
+	for _, commit := range commits {
+		message, err := repo.GetCommitMessage(commit)
+		if err != nil {
+			return nil, err
+		}
+		details, err := repo.GetCommitDetails(commit)
+		if err != nil {
+			return nil, err
+		}
+		if !hasSignoff(message, details.AuthorEmail) {
+			missing = append(missing, MissingSignoff{commit, details.AuthorEmail, details.Summary})
+		}
+	}
+	return missing, nil
+}
+
+// FindMissingSignoffs returns the commits in the review that are not signed off by their authors.
+func (r *Review) FindMissingSignoffs() ([]MissingSignoff, error) {
+	commits, err := r.GetCommits()
+	if err != nil {
+		return nil, err
+	}
+	return FindMissingSignoffs(r.Repo, commits)
+}
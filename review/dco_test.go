@@ -0,0 +1,42 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"testing"
+)
+
+func TestHasSignoff(t *testing.T) {
+	for _, test := range []struct {
+		message  string
+		author   string
+		expected bool
+	}{
+		{"Fix a bug\n\nSigned-off-by: Alice <alice@example.com>\n", "alice@example.com", true},
+		{"Fix a bug\n\nsigned-off-by: Alice <ALICE@example.com>", "alice@example.com", true},
+		{"Fix a bug\n\nSigned-off-by: Bob <bob@example.com>", "alice@example.com", false},
+		{"Fix a bug for alice@example.com", "alice@example.com", false},
+		{"Fix a bug\n\nAcked-by: Alice <alice@example.com>", "alice@example.com", false},
+	} {
+		if got := hasSignoff(test.message, test.author); got != test.expected {
+			t.Errorf("Unexpected sign-off status of %q by %q: got %v, want %v", test.message, test.author, got, test.expected)
+		}
+	}
+	if signoff := FormatSignoff("Alice", "alice@example.com"); signoff != "Signed-off-by: Alice <alice@example.com>" {
+		t.Errorf("Unexpected sign-off trailer: %q", signoff)
+	}
+}
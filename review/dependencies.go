@@ -0,0 +1,93 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/request"
+)
+
+// Possible values for the status of a dependency.
+const (
+	DependencySubmitted = "submitted"
+	DependencyOpen      = "open"
+	DependencyMissing   = "missing"
+)
+
+// Dependency describes one of the reviews that a review depends on.
+type Dependency struct {
+	Revision string `json:"revision"`
+	// Status is one of DependencySubmitted, DependencyOpen, or DependencyMissing.
+	Status string `json:"status"`
+	// Review is the review depended on, and is nil if there is no review of the revision.
+	Review *Review `json:"-"`
+}
+
+// getDependsOn returns the revisions that the latest request for the given review depends on.
+func getDependsOn(repo repository.Repo, revision string) []string {
+	requests := request.ParseAllValid(repo.GetNotes(request.Ref, revision))
+	if len(requests) == 0 {
+		return nil
+	}
+	return requests[len(requests)-1].DependsOn
+}
+
+// FindDependencyCycle returns a cycle of dependencies that would start and end at the
+// given revision, if it depended on the given revisions, or nil if there would be none.
+//
+// The cycle is returned as the revisions along it, starting and ending with the given one.
+func FindDependencyCycle(repo repository.Repo, revision string, dependsOn []string) []string {
+	visited := make(map[string]bool)
+	var find func(path []string, dependsOn []string) []string
+	find = func(path []string, dependsOn []string) []string {
+		for _, dependency := range dependsOn {
+			if dependency == revision {
+				return append(path, dependency)
+			}
+			if visited[dependency] {
+				continue
+			}
+			visited[dependency] = true
+			if cycle := find(append(path, dependency), getDependsOn(repo, dependency)); cycle != nil {
+				return cycle
+			}
+		}
+		return nil
+	}
+	return find([]string{revision}, dependsOn)
+}
+
+// GetDependencies returns the current state of each of the reviews that this one depends on.
+func (r *Review) GetDependencies() ([]Dependency, error) {
+	var dependencies []Dependency
+	for _, revision := range r.Request.DependsOn {
+		dependency := Dependency{Revision: revision, Status: DependencyMissing}
+		dependsOn, err := Get(r.Repo, revision)
+		if err != nil {
+			return nil, err
+		}
+		if dependsOn != nil {
+			dependency.Review = dependsOn
+			dependency.Status = DependencyOpen
+			if dependsOn.Submitted {
+				dependency.Status = DependencySubmitted
+			}
+		}
+		dependencies = append(dependencies, dependency)
+	}
+	return dependencies, nil
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/request"
+	"reflect"
+	"testing"
+)
+
+// addDependencies appends a request note making the review at the given revision depend on the given ones.
+func addDependencies(t *testing.T, repo repository.Repo, revision string, dependsOn ...string) {
+	r, err := Get(repo, revision)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review %q: %v", revision, err)
+	}
+	updatedRequest := r.Request
+	updatedRequest.DependsOn = dependsOn
+	note, err := updatedRequest.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AppendNote(request.Ref, revision, note); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetDependencies(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	addDependencies(t, repo, repository.TestCommitD, repository.TestCommitB, repository.TestCommitG, repository.TestCommitA)
+	r, err := Get(repo, repository.TestCommitD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dependencies, err := r.GetDependencies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statuses []string
+	for _, dependency := range dependencies {
+		statuses = append(statuses, dependency.Revision+":"+dependency.Status)
+	}
+	expected := []string{"B:" + DependencySubmitted, "G:" + DependencyOpen, "A:" + DependencyMissing}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Fatalf("Unexpected dependencies: got %v, want %v", statuses, expected)
+	}
+}
+
+func TestFindDependencyCycle(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	addDependencies(t, repo, repository.TestCommitG, repository.TestCommitD)
+	addDependencies(t, repo, repository.TestCommitD, repository.TestCommitB)
+	if cycle := FindDependencyCycle(repo, repository.TestCommitG, []string{repository.TestCommitD}); cycle != nil {
+		t.Fatalf("Unexpected dependency cycle: %v", cycle)
+	}
+	cycle := FindDependencyCycle(repo, repository.TestCommitB, []string{repository.TestCommitG})
+	expected := []string{repository.TestCommitB, repository.TestCommitG, repository.TestCommitD, repository.TestCommitB}
+	if !reflect.DeepEqual(cycle, expected) {
+		t.Fatalf("Unexpected dependency cycle: got %v, want %v", cycle, expected)
+	}
+	if cycle := FindDependencyCycle(repo, repository.TestCommitB, []string{repository.TestCommitB}); len(cycle) != 2 {
+		t.Fatalf("A review depending on itself was not reported as a cycle: %v", cycle)
+	}
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/ci"
+	"github.com/google/git-appraise/review/comment"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DuplicateGroup is a set of open reviews of essentially the same change.
+type DuplicateGroup struct {
+	// Survivor is the review that the others should be merged into, which is the one
+	// requested most recently.
+	Survivor Review
+	// Duplicates holds the other reviews, oldest first.
+	Duplicates []Review
+	// Reasons describes why the reviews were found to be duplicates, such as
+	// "same review ref refs/heads/feature".
+	Reasons []string
+}
+
+// changedLines returns the set of lines added or removed by the given diff, each prefixed
+// with the path of its file, so that the same line changed in two files is counted twice.
+func changedLines(diff string) map[string]bool {
+	lines := make(map[string]bool)
+	var path string
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "+++ ") || strings.HasPrefix(line, "--- ") {
+			if name := strings.TrimPrefix(line[4:], "b/"); name != "/dev/null" {
+				path = name
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
+			lines[path+"\x00"+line] = true
+		}
+	}
+	return lines
+}
+
+// diffSimilarity returns the fraction of the lines changed by either of two diffs that are
+// changed by both, from 0 for unrelated diffs to 1 for identical ones.
+func diffSimilarity(left, right map[string]bool) float64 {
+	if len(left) == 0 || len(right) == 0 {
+		return 0
+	}
+	shared := 0
+	for line := range left {
+		if right[line] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(left)+len(right)-shared)
+}
+
+// requestedAt returns the time at which the given review was requested, in seconds, or 0 if
+// that is not known.
+func requestedAt(r Review) int64 {
+	timestamp, _ := strconv.ParseInt(r.Request.Timestamp, 10, 64)
+	return timestamp
+}
+
+// FindDuplicates groups together the open reviews among the given ones that are of the same
+// change: those with the same review ref or the same head commit, and those whose diffs are
+// at least as similar as the given threshold (see diffSimilarity). A threshold above 1 only
+// compares the refs and commits.
+//
+// Reviews whose diffs cannot be computed are only compared by their refs and commits.
+func FindDuplicates(reviews []Review, threshold float64) []DuplicateGroup {
+	var open []Review
+	for _, r := range reviews {
+		if r.IsOpen() {
+			open = append(open, r)
+		}
+	}
+	sort.Slice(open, func(i, j int) bool {
+		if requestedAt(open[i]) != requestedAt(open[j]) {
+			return requestedAt(open[i]) < requestedAt(open[j])
+		}
+		return open[i].Revision < open[j].Revision
+	})
+
+	heads := make([]string, len(open))
+	diffs := make([]map[string]bool, len(open))
+	for i := range open {
+		heads[i], _ = open[i].GetHeadCommit()
+		if threshold <= 1 {
+			if diff, err := open[i].GetDiff(); err == nil {
+				diffs[i] = changedLines(diff)
+			}
+		}
+	}
+
+	// Link up the duplicates with a union-find, so that the groups are transitive.
+	parents := make([]int, len(open))
+	for i := range parents {
+		parents[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+	reasons := make(map[int][]string)
+	for i := range open {
+		for j := i + 1; j < len(open); j++ {
+			var reason string
+			if ref := open[i].Request.ReviewRef; ref != "" && ref == open[j].Request.ReviewRef {
+				reason = "same review ref " + ref
+			} else if heads[i] != "" && heads[i] == heads[j] {
+				reason = fmt.Sprintf("same head commit %.12s", heads[i])
+			} else if similarity := diffSimilarity(diffs[i], diffs[j]); similarity > 0 && similarity >= threshold {
+				reason = fmt.Sprintf("%.0f%% similar diffs", similarity*100)
+			} else {
+				continue
+			}
+			reason = fmt.Sprintf("%.12s and %.12s: %s", open[i].Revision, open[j].Revision, reason)
+			root, other := find(i), find(j)
+			if root != other {
+				parents[other] = root
+				reasons[root] = append(reasons[root], reasons[other]...)
+				delete(reasons, other)
+			}
+			reasons[root] = append(reasons[root], reason)
+		}
+	}
+
+	var groups []DuplicateGroup
+	members := make(map[int][]Review)
+	var roots []int
+	for i := range open {
+		root := find(i)
+		if _, ok := reasons[root]; !ok {
+			continue
+		}
+		if len(members[root]) == 0 {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], open[i])
+	}
+	for _, root := range roots {
+		group := members[root]
+		groups = append(groups, DuplicateGroup{
+			Survivor:   group[len(group)-1],
+			Duplicates: group[:len(group)-1],
+			Reasons:    reasons[root],
+		})
+	}
+	return groups
+}
+
+// appendMissingNotes appends each of the given notes that the given revision does not already
+// have under the given ref, as identified by the given key function, and returns how many
+// were appended.
+func appendMissingNotes(repo repository.Repo, ref, revision string, notes []repository.Note, key func(repository.Note) (string, bool)) (int, error) {
+	existing := make(map[string]bool)
+	for _, note := range repo.GetNotes(ref, revision) {
+		if k, ok := key(note); ok {
+			existing[k] = true
+		}
+	}
+	appended := 0
+	for _, note := range notes {
+		k, ok := key(note)
+		if !ok || existing[k] {
+			continue
+		}
+		existing[k] = true
+		if err := repo.AppendNote(ref, revision, note); err != nil {
+			return appended, err
+		}
+		appended++
+	}
+	return appended, nil
+}
+
+// CopyDiscussion copies the comments and CI reports of the review of the given duplicate
+// revision to the review of the given survivor revision, skipping any that it already has,
+// and returns the number of notes copied.
+//
+// Comments keep their hashes, so replies still point at the comments that they reply to.
+func CopyDiscussion(repo repository.Repo, duplicate, survivor string) (int, error) {
+	var commentNotes []repository.Note
+	for _, note := range repo.GetNotes(comment.Ref, duplicate) {
+		for _, line := range bytes.Split(note, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				commentNotes = append(commentNotes, repository.Note(line))
+			}
+		}
+	}
+	copied, err := appendMissingNotes(repo, comment.Ref, survivor, commentNotes, func(note repository.Note) (string, bool) {
+		c, err := comment.Parse(note)
+		if err != nil {
+			return "", false
+		}
+		hash, err := c.Hash()
+		return hash, err == nil
+	})
+	if err != nil {
+		return copied, err
+	}
+	copiedReports, err := appendMissingNotes(repo, ci.Ref, survivor, repo.GetNotes(ci.Ref, duplicate), func(note repository.Note) (string, bool) {
+		return string(bytes.TrimSpace(note)), true
+	})
+	return copied + copiedReports, err
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/request"
+	"testing"
+)
+
+func TestDiffSimilarity(t *testing.T) {
+	left := changedLines("--- a/main.go\n+++ b/main.go\n@@ -1 +1,2 @@\n one\n-two\n+three\n+four\n")
+	right := changedLines("--- a/main.go\n+++ b/main.go\n@@ -1 +1 @@\n one\n-two\n+three\n")
+	other := changedLines("--- a/util.go\n+++ b/util.go\n@@ -1 +1 @@\n one\n-two\n+three\n")
+	if similarity := diffSimilarity(left, right); similarity < 0.66 || similarity > 0.67 {
+		t.Errorf("Unexpected similarity of overlapping diffs: %v", similarity)
+	}
+	if similarity := diffSimilarity(right, other); similarity != 0 {
+		t.Errorf("The same lines changed in different files were counted as similar: %v", similarity)
+	}
+	if similarity := diffSimilarity(right, right); similarity != 1 {
+		t.Errorf("Unexpected similarity of identical diffs: %v", similarity)
+	}
+	if similarity := diffSimilarity(nil, nil); similarity != 0 {
+		t.Errorf("Empty diffs were counted as similar: %v", similarity)
+	}
+}
+
+// addRequest requests a review of the given revision from the given ref, at the given time.
+func addRequest(t *testing.T, repo repository.Repo, revision, reviewRef, timestamp string) {
+	r := request.New("user@example.com", nil, reviewRef, "refs/heads/master", "Add the feature")
+	r.Timestamp = timestamp
+	note, err := r.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AppendNote(request.Ref, revision, note); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	repo := repository.NewRepoWithHistory(map[string][]string{"master": {"A"}})
+	repo.AddCommit("B", "Add the feature", map[string]string{"feature.go": "one\ntwo\n"}, "A")
+	repo.AddCommit("C", "Add the feature, take two", map[string]string{"feature.go": "one\n2\n"}, "A")
+	repo.AddCommit("D", "Add the feature, again", map[string]string{"feature.go": "one\n2\n"}, "A")
+	repo.AddCommit("E", "Something else", map[string]string{"other.go": "one\n"}, "A")
+	repo.SetRef("refs/heads/feature", "C")
+	repo.SetRef("refs/heads/retry", "D")
+	repo.SetRef("refs/heads/other", "E")
+	// The feature branch was force pushed after B was requested, and then requested again.
+	addRequest(t, repo, "B", "refs/heads/feature", "100")
+	addRequest(t, repo, "C", "refs/heads/feature", "200")
+	addRequest(t, repo, "D", "refs/heads/retry", "300")
+	addRequest(t, repo, "E", "refs/heads/other", "400")
+
+	groups := FindDuplicates(ListAll(repo), 2)
+	if len(groups) != 1 || groups[0].Survivor.Revision != "C" || len(groups[0].Duplicates) != 1 || groups[0].Duplicates[0].Revision != "B" {
+		t.Fatalf("Unexpected duplicates by ref: %+v", groups)
+	}
+	groups = FindDuplicates(ListAll(repo), 0.9)
+	if len(groups) != 1 || groups[0].Survivor.Revision != "D" || len(groups[0].Duplicates) != 2 || len(groups[0].Reasons) != 3 {
+		t.Fatalf("Unexpected duplicates by ref and diff: %+v", groups)
+	}
+
+	if _, err := CopyDiscussion(repo, "B", "D"); err != nil {
+		t.Fatal(err)
+	}
+	c := comment.New("reviewer@example.com", "Why two?")
+	note, err := c.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AppendNote(comment.Ref, "B", note); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := CopyDiscussion(repo, "B", "D"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	survivor, err := Get(repo, "D")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(survivor.Comments) != 1 || survivor.Comments[0].Comment.Description != "Why two?" {
+		t.Errorf("Unexpected comments after copying them: %+v", survivor.Comments)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"github.com/google/git-appraise/review/comment"
+	"sort"
+)
+
+// Iteration is one version of a review, identified by the head commit of the review at the time.
+type Iteration struct {
+	Commit string `json:"commit"`
+	// Timestamp is the time of the first comment made on this version, and is empty for a
+	// version that has not been commented upon.
+	Timestamp string `json:"timestamp,omitempty"`
+}
+
+// getSnapshot returns the head commit of the review when the given comment was made.
+//
+// Comments written before snapshots were recorded fall back to the commit they were made
+// on, which is the head commit of the review at the time for comments made by this tool.
+func getSnapshot(c comment.Comment) string {
+	if c.Snapshot != "" {
+		return c.Snapshot
+	}
+	if c.Location != nil {
+		return c.Location.Commit
+	}
+	return ""
+}
+
+// collectSnapshots adds the snapshot of every comment in the given threads to the given
+// iterations, or only of the comments by the given author if it is not empty.
+func collectSnapshots(threads []CommentThread, author string, iterations []Iteration) []Iteration {
+	for _, thread := range threads {
+		c := thread.Comment
+		if snapshot := getSnapshot(c); snapshot != "" && !thread.Elided && (author == "" || c.Author == author) {
+			iterations = append(iterations, Iteration{Commit: snapshot, Timestamp: c.Timestamp})
+		}
+		iterations = collectSnapshots(thread.Children, author, iterations)
+	}
+	return iterations
+}
+
+// GetIterations returns the versions of the review that have been commented upon, oldest
+// first, followed by its current head commit if that has not been commented upon yet.
+func (r *Review) GetIterations() ([]Iteration, error) {
+	snapshots := collectSnapshots(r.Comments, "", nil)
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Timestamp < snapshots[j].Timestamp
+	})
+	var iterations []Iteration
+	seen := make(map[string]bool)
+	for _, snapshot := range snapshots {
+		if !seen[snapshot.Commit] {
+			seen[snapshot.Commit] = true
+			iterations = append(iterations, snapshot)
+		}
+	}
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		return nil, err
+	}
+	if !seen[head] {
+		iterations = append(iterations, Iteration{Commit: head})
+	}
+	return iterations, nil
+}
+
+// GetLastSnapshot returns the head commit of the review at the time of the given author's
+// latest comment on it, or an empty string if they have not commented on it.
+func (r *Review) GetLastSnapshot(author string) string {
+	var lastSnapshot, lastTimestamp string
+	for _, snapshot := range collectSnapshots(r.Comments, author, nil) {
+		if snapshot.Timestamp >= lastTimestamp {
+			lastSnapshot, lastTimestamp = snapshot.Commit, snapshot.Timestamp
+		}
+	}
+	return lastSnapshot
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/comment"
+	"reflect"
+	"testing"
+)
+
+func TestGetIterations(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	r, err := Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inline := comment.Comment{Timestamp: "0000000001", Author: "alice@example.com", Snapshot: repository.TestCommitG,
+		Location: &comment.Location{Commit: repository.TestCommitG, Path: "foo", Range: &comment.Range{StartLine: 1}}}
+	// Comments written before snapshots were recorded only have the commit they were made on.
+	legacy := comment.Comment{Timestamp: "0000000002", Author: "bob@example.com",
+		Location: &comment.Location{Commit: repository.TestCommitH}}
+	latest := comment.Comment{Timestamp: "0000000003", Author: "alice@example.com", Snapshot: repository.TestCommitH,
+		Location: &comment.Location{Commit: repository.TestCommitH}}
+	for _, c := range []comment.Comment{latest, inline, legacy} {
+		if err := r.AddComment(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err = Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iterations, err := r.GetIterations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Iteration{
+		{Commit: repository.TestCommitG, Timestamp: "0000000001"},
+		{Commit: repository.TestCommitH, Timestamp: "0000000002"},
+		{Commit: repository.TestCommitI},
+	}
+	if !reflect.DeepEqual(iterations, expected) {
+		t.Fatalf("Unexpected iterations: got %v, want %v", iterations, expected)
+	}
+	for author, want := range map[string]string{
+		"alice@example.com": repository.TestCommitH,
+		"bob@example.com":   repository.TestCommitH,
+		"carol@example.com": "",
+	} {
+		if snapshot := r.GetLastSnapshot(author); snapshot != want {
+			t.Errorf("Unexpected last snapshot for %q: got %q, want %q", author, snapshot, want)
+		}
+	}
+}
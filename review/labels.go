@@ -0,0 +1,174 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// LabelsRef defines the git-notes ref recording the labels added to, and removed from, each review.
+const LabelsRef = "refs/notes/devtools/labels"
+
+// LabelsConfigKey is the config key holding the labels (separated by commas or spaces) that
+// may be used. If it is not set, then any label with a valid name may be used.
+const LabelsConfigKey = "appraise.labels"
+
+// labelNamePattern matches the valid label names.
+var labelNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// labelNote is the format of the notes in LabelsRef, each of which records that a label was
+// added to or removed from a review.
+//
+// Since notes are merged by concatenating them, the latest note for each label is the one that counts.
+type labelNote struct {
+	Timestamp string `json:"timestamp"`
+	Author    string `json:"author,omitempty"`
+	Label     string `json:"label"`
+	Added     bool   `json:"added"`
+}
+
+// parseLabels returns the sorted list of the labels whose latest note in the given notes adds them.
+func parseLabels(notes []repository.Note) []string {
+	latest := make(map[string]labelNote)
+	latestTimestamps := make(map[string]int64)
+	for _, note := range notes {
+		var parsed labelNote
+		if err := json.Unmarshal([]byte(note), &parsed); err != nil || parsed.Label == "" {
+			continue
+		}
+		timestamp, err := strconv.ParseInt(parsed.Timestamp, 10, 64)
+		if err != nil {
+			continue
+		}
+		if previous, ok := latestTimestamps[parsed.Label]; !ok || timestamp >= previous {
+			latest[parsed.Label], latestTimestamps[parsed.Label] = parsed, timestamp
+		}
+	}
+	var labels []string
+	for label, note := range latest {
+		if note.Added {
+			labels = append(labels, label)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// splitLabels splits the given list of labels, separated by commas or spaces.
+func splitLabels(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// listAllowed returns the names (separated by commas or spaces) allowed by the given config
+// setting, which are none if the setting is not set.
+func listAllowed(repo repository.Repo, key string) ([]string, error) {
+	values, err := repository.GetConfigWithDefaults(repo, key)
+	if err != nil {
+		return nil, err
+	}
+	var allowed []string
+	for _, value := range values {
+		allowed = append(allowed, splitLabels(value.Value)...)
+	}
+	return allowed, nil
+}
+
+// ValidateLabelName checks that the given label is made up of letters, digits, dots, dashes,
+// and underscores, starting with a letter or digit.
+func ValidateLabelName(label string) error {
+	if !labelNamePattern.MatchString(label) {
+		return fmt.Errorf("Invalid label %q; labels are made up of letters, digits, \".\", \"-\", and \"_\", and start with a letter or digit.", label)
+	}
+	return nil
+}
+
+// ValidateLabel checks that the given label has a valid name, and that it is one of the
+// labels allowed by the LabelsConfigKey setting, if that is set.
+func ValidateLabel(repo repository.Repo, label string) error {
+	if err := ValidateLabelName(label); err != nil {
+		return err
+	}
+	allowed, err := listAllowed(repo, LabelsConfigKey)
+	if err != nil || len(allowed) == 0 {
+		return err
+	}
+	for _, allowedLabel := range allowed {
+		if label == allowedLabel {
+			return nil
+		}
+	}
+	return fmt.Errorf("The label %q is not allowed by the %s setting; expected one of: %s", label, LabelsConfigKey, strings.Join(allowed, ", "))
+}
+
+// SetLabels records that the given author added the given labels to, and removed the other
+// given labels from, the review. Every label is validated before any is recorded, although
+// labels that are no longer allowed can still be removed.
+func (r *Review) SetLabels(author string, added, removed []string) error {
+	for _, label := range added {
+		if err := ValidateLabel(r.Repo, label); err != nil {
+			return err
+		}
+	}
+	for _, label := range removed {
+		if err := ValidateLabelName(label); err != nil {
+			return err
+		}
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	var notes []labelNote
+	for _, label := range removed {
+		notes = append(notes, labelNote{timestamp, author, label, false})
+	}
+	for _, label := range added {
+		notes = append(notes, labelNote{timestamp, author, label, true})
+	}
+	for _, note := range notes {
+		noteBytes, err := json.Marshal(note)
+		if err != nil {
+			return err
+		}
+		if err := r.Repo.AppendNote(LabelsRef, r.Revision, repository.Note(noteBytes)); err != nil {
+			return err
+		}
+	}
+	r.Labels = parseLabels(r.Repo.GetNotes(LabelsRef, r.Revision))
+	return nil
+}
+
+// HasLabels determines if the review has every one of the given labels.
+func (r *Review) HasLabels(labels []string) bool {
+	for _, label := range labels {
+		found := false
+		for _, reviewLabel := range r.Labels {
+			found = found || reviewLabel == label
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"github.com/google/git-appraise/repository"
+	"reflect"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	notes := []repository.Note{
+		repository.Note(`{"timestamp": "0000000003", "label": "docs", "added": false}`),
+		repository.Note(`{"timestamp": "0000000001", "label": "docs", "added": true}`),
+		repository.Note(`{"timestamp": "0000000002", "label": "security", "added": true}`),
+		repository.Note(`{"timestamp": "0000000004", "label": "release-blocker", "added": true}`),
+		repository.Note(`not json`),
+	}
+	if labels := parseLabels(notes); !reflect.DeepEqual(labels, []string{"release-blocker", "security"}) {
+		t.Fatalf("Unexpected labels: %v", labels)
+	}
+}
+
+func TestValidateLabel(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	for _, label := range []string{"", "-docs", "has space", "semi;colon"} {
+		if err := ValidateLabel(repo, label); err == nil {
+			t.Errorf("The invalid label %q was accepted", label)
+		}
+	}
+	if err := ValidateLabel(repo, "release-1.2_rc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetConfigValue(LabelsConfigKey, "docs, security"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateLabel(repo, "security"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateLabel(repo, "release-1.2_rc"); err == nil {
+		t.Fatal("A label outside of the allowed set was accepted")
+	}
+}
+
+func TestSetLabels(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	r, err := Get(repo, repository.TestCommitG)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	if err := r.SetLabels("alice@example.com", []string{"docs", "security"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetLabels("alice@example.com", nil, []string{"bad label"}); err == nil {
+		t.Fatal("An invalid label was removed")
+	}
+	r, err = Get(repo, repository.TestCommitG)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to reload the review: %v", err)
+	}
+	if !reflect.DeepEqual(r.Labels, []string{"docs", "security"}) || !r.HasLabels([]string{"security", "docs"}) || r.HasLabels([]string{"docs", "other"}) {
+		t.Fatalf("Unexpected labels: %v", r.Labels)
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/analyses"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// LintMaxSubjectLengthConfigKey is the config key holding the maximum length of the
+	// subject line of each commit message.
+	LintMaxSubjectLengthConfigKey = "appraise.lint.maxSubjectLength"
+	// LintRequiredPatternConfigKey is the config key holding a regular expression that every
+	// commit message has to match, such as a ticket ID.
+	LintRequiredPatternConfigKey = "appraise.lint.requiredPattern"
+	// LintForbiddenPrefixesConfigKey is the config key holding the prefixes (separated by
+	// commas or spaces) that subject lines may not start with, such as "WIP" or "fixup!".
+	LintForbiddenPrefixesConfigKey = "appraise.lint.forbiddenPrefixes"
+	// LintRequiredTrailersConfigKey is the config key holding the trailers (separated by
+	// commas or spaces) that every commit message has to have, such as "Bug".
+	LintRequiredTrailersConfigKey = "appraise.lint.requiredTrailers"
+	// LintRecordConfigKey is the config key that, if true, records the results of checking
+	// the commit messages of new reviews as an analysis report.
+	LintRecordConfigKey = "appraise.lint.record"
+)
+
+// Names of the commit message rules, which are the categories of their violations.
+const (
+	LintRuleSubjectLength   = "subject-length"
+	LintRuleRequiredPattern = "required-pattern"
+	LintRuleForbiddenPrefix = "forbidden-prefix"
+	LintRuleRequiredTrailer = "required-trailer"
+)
+
+// LintRules are the rules that commit messages have to follow, as configured.
+type LintRules struct {
+	// MaxSubjectLength is the maximum length of subject lines, or zero for no maximum.
+	MaxSubjectLength  int
+	RequiredPattern   *regexp.Regexp
+	ForbiddenPrefixes []string
+	RequiredTrailers  []string
+}
+
+// Empty returns whether there are no rules to check.
+func (rules *LintRules) Empty() bool {
+	return rules.MaxSubjectLength == 0 && rules.RequiredPattern == nil &&
+		len(rules.ForbiddenPrefixes) == 0 && len(rules.RequiredTrailers) == 0
+}
+
+// ReadLintRules reads the commit message rules from the config, which are none by default.
+func ReadLintRules(repo repository.Repo) (*LintRules, error) {
+	rules := &LintRules{}
+	if value := repository.GetConfigValue(repo, LintMaxSubjectLengthConfigKey, ""); value != "" {
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 0 {
+			return nil, fmt.Errorf("Invalid %s %q; expected a number that is zero or more.", LintMaxSubjectLengthConfigKey, value)
+		}
+		rules.MaxSubjectLength = length
+	}
+	if value := repository.GetConfigValue(repo, LintRequiredPatternConfigKey, ""); value != "" {
+		pattern, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s %q: %v", LintRequiredPatternConfigKey, value, err)
+		}
+		rules.RequiredPattern = pattern
+	}
+	var err error
+	if rules.ForbiddenPrefixes, err = listAllowed(repo, LintForbiddenPrefixesConfigKey); err != nil {
+		return nil, err
+	}
+	if rules.RequiredTrailers, err = listAllowed(repo, LintRequiredTrailersConfigKey); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// LintViolation describes a commit whose message breaks one of the rules.
+type LintViolation struct {
+	Commit  string `json:"commit"`
+	Summary string `json:"summary"`
+	// Rule is the name of the rule that was broken, such as LintRuleSubjectLength.
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (v LintViolation) String() string {
+	return fmt.Sprintf("%.12s %s: %s (%s)", v.Commit, v.Summary, v.Message, v.Rule)
+}
+
+// hasTrailer determines if the given commit message has a trailer with the given key.
+func hasTrailer(message, key string) bool {
+	prefix := strings.ToLower(strings.TrimSuffix(key, ":")) + ":"
+	for _, line := range strings.Split(message, "\n") {
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(line)), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns the ways in which the given commit message breaks the rules.
+func (rules *LintRules) Check(commit, message string) []LintViolation {
+	summary := strings.SplitN(strings.TrimSpace(message), "\n", 2)[0]
+	var violations []LintViolation
+	violate := func(rule, format string, args ...interface{}) {
+		violations = append(violations, LintViolation{commit, summary, rule, fmt.Sprintf(format, args...)})
+	}
+	if length := len([]rune(summary)); rules.MaxSubjectLength > 0 && length > rules.MaxSubjectLength {
+		violate(LintRuleSubjectLength, "the subject is %d characters long, which is more than %d", length, rules.MaxSubjectLength)
+	}
+	if rules.RequiredPattern != nil && !rules.RequiredPattern.MatchString(message) {
+		violate(LintRuleRequiredPattern, "the message does not match %q", rules.RequiredPattern.String())
+	}
+	for _, prefix := range rules.ForbiddenPrefixes {
+		if strings.HasPrefix(strings.ToLower(summary), strings.ToLower(prefix)) {
+			violate(LintRuleForbiddenPrefix, "the subject starts with %q", prefix)
+		}
+	}
+	for _, trailer := range rules.RequiredTrailers {
+		if !hasTrailer(message, trailer) {
+			violate(LintRuleRequiredTrailer, "the message has no %q trailer", strings.TrimSuffix(trailer, ":"))
+		}
+	}
+	return violations
+}
+
+// LintCommits returns the ways in which the messages of the given commits break the rules.
+func LintCommits(repo repository.Repo, rules *LintRules, commits []string) ([]LintViolation, error) {
+	var violations []LintViolation
+	for _, commit := range commits {
+		message, err := repo.GetCommitMessage(commit)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, rules.Check(commit, message)...)
+	}
+	return violations, nil
+}
+
+// RecordLintResults records the given results of checking the commit messages of a review
+// as an analysis report on its head commit, which is shown with the review.
+func RecordLintResults(repo repository.Repo, headCommit string, violations []LintViolation) error {
+	var notes []analyses.Note
+	for _, violation := range violations {
+		notes = append(notes, analyses.Note{
+			Category:    violation.Rule,
+			Description: fmt.Sprintf("%.12s %s: %s", violation.Commit, violation.Summary, violation.Message),
+		})
+	}
+	report := analyses.Report{
+		Timestamp:       strconv.FormatInt(time.Now().Unix(), 10),
+		AnalyzeResponse: []analyses.AnalyzeResponse{{Notes: notes}},
+	}
+	note, err := report.Write()
+	if err != nil {
+		return err
+	}
+	return repo.AppendNote(analyses.Ref, headCommit, note)
+}
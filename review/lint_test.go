@@ -0,0 +1,68 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"github.com/google/git-appraise/repository"
+	"reflect"
+	"testing"
+)
+
+func TestLintRules(t *testing.T) {
+	repo := repository.NewRepoWithHistory(map[string][]string{"master": {"A"}})
+	if rules, err := ReadLintRules(repo); err != nil || !rules.Empty() {
+		t.Fatalf("Unexpected rules without any settings: %+v, %v", rules, err)
+	}
+	for key, value := range map[string]string{
+		LintMaxSubjectLengthConfigKey:  "20",
+		LintRequiredPatternConfigKey:   `PROJ-\d+`,
+		LintForbiddenPrefixesConfigKey: "WIP, fixup! squash!",
+		LintRequiredTrailersConfigKey:  "Bug",
+	} {
+		if err := repo.SetConfigValue(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rules, err := ReadLintRules(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		message string
+		broken  []string
+	}{
+		{"Fix PROJ-12\n\nBug: 12\n", nil},
+		{"Fix the bug in the parser for PROJ-12\n\nbug: 12", []string{LintRuleSubjectLength}},
+		{"wip: fix it\n\nBug: 12", []string{LintRuleRequiredPattern, LintRuleForbiddenPrefix}},
+		{"fixup! Fix PROJ-12", []string{LintRuleForbiddenPrefix, LintRuleRequiredTrailer}},
+	} {
+		var broken []string
+		for _, violation := range rules.Check("A", test.message) {
+			broken = append(broken, violation.Rule)
+		}
+		if !reflect.DeepEqual(broken, test.broken) {
+			t.Errorf("Unexpected rules broken by %q: got %q, want %q", test.message, broken, test.broken)
+		}
+	}
+
+	if err := repo.SetConfigValue(LintRequiredPatternConfigKey, "PROJ-("); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadLintRules(repo); err == nil {
+		t.Error("Unexpectedly accepted an invalid pattern")
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/request"
+	"sort"
+)
+
+const (
+	// OrphanMissingCommit is the reason given for an orphaned review whose commit is no
+	// longer in the repository.
+	OrphanMissingCommit = "missing commit"
+	// OrphanUnreachable is the reason given for an orphaned review whose commit is still in
+	// the repository, but cannot be reached from any branch or tag, such as after a rebase.
+	OrphanUnreachable = "unreachable"
+)
+
+// SnapshotRefPrefix is the prefix of the refs holding the temporary commits of the reviews of
+// uncommitted changes.
+//
+// This is outside of the review data namespace, so those commits are neither read nor pushed.
+const SnapshotRefPrefix = "refs/appraise/snapshots/"
+
+// orphanRefPatterns are the refs from which the commit of a review has to be reachable for
+// the review not to be orphaned.
+var orphanRefPatterns = []string{"refs/heads", "refs/tags", "refs/remotes", SnapshotRefPrefix}
+
+// orphanNotesRefs are the notes refs holding data keyed by the revision of a review, which
+// are removed when an orphaned review is pruned.
+var orphanNotesRefs = []string{request.Ref, comment.Ref, LabelsRef, AttentionRef, ChecklistRef, WatchersRef, AnchorsRef, ViewedRef}
+
+// Orphan describes a review whose commit can no longer be reached, typically because the
+// history of its branch was rewritten.
+type Orphan struct {
+	Revision string          `json:"revision"`
+	Request  request.Request `json:"request"`
+	Reason   string          `json:"reason"`
+}
+
+// FindOrphans returns the reviews whose commits are either missing, or are not reachable
+// from any branch or tag (or, for reviews of uncommitted changes, their snapshot), sorted
+// by revision.
+//
+// In a shallow clone, missing commits are expected, so those reviews are not reported.
+func FindOrphans(repo repository.Repo) ([]Orphan, error) {
+	shallow, err := repo.IsShallow()
+	if err != nil {
+		return nil, err
+	}
+	annotated, err := repo.ListNotes(request.Ref)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []Orphan
+	candidates := make(map[string]request.Request)
+	var remaining []string
+	for revision := range annotated {
+		requests := request.ParseAllValid(repo.GetNotes(request.Ref, revision))
+		if requests == nil {
+			continue
+		}
+		latest := requests[len(requests)-1]
+		if repo.VerifyCommit(revision) != nil {
+			if !shallow {
+				orphans = append(orphans, Orphan{revision, latest, OrphanMissingCommit})
+			}
+			continue
+		}
+		candidates[revision] = latest
+		remaining = append(remaining, revision)
+	}
+	for _, pattern := range orphanRefPatterns {
+		refs, err := repo.ListRefs(pattern)
+		if err != nil {
+			return nil, err
+		}
+		var refNames []string
+		for ref := range refs {
+			refNames = append(refNames, ref)
+		}
+		sort.Strings(refNames)
+		for _, ref := range refNames {
+			if len(remaining) == 0 {
+				break
+			}
+			reachable, err := repo.FindAncestors(remaining, ref)
+			if err != nil {
+				return nil, err
+			}
+			var unreached []string
+			for _, revision := range remaining {
+				if !reachable[revision] {
+					unreached = append(unreached, revision)
+				}
+			}
+			remaining = unreached
+		}
+	}
+	for _, revision := range remaining {
+		orphans = append(orphans, Orphan{revision, candidates[revision], OrphanUnreachable})
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].Revision < orphans[j].Revision
+	})
+	return orphans, nil
+}
+
+// PruneOrphans removes the requests, comments, and other notes of the given orphaned reviews.
+//
+// The previous tip of each notes ref that is rewritten is recorded under the ref returned
+// by the given function, so that the pruned notes can be recovered.
+func PruneOrphans(repo repository.Repo, orphans []Orphan, backupRef func(notesRef string) string) error {
+	pruned := make(map[string]bool)
+	for _, orphan := range orphans {
+		pruned[orphan.Revision] = true
+	}
+	for _, notesRef := range orphanNotesRefs {
+		annotated, err := repo.ListNotes(notesRef)
+		if err != nil {
+			return err
+		}
+		affected := false
+		for object := range annotated {
+			affected = affected || pruned[object]
+		}
+		if !affected {
+			continue
+		}
+		err = repo.CompactNotes(notesRef, backupRef(notesRef), func(object string, notes []repository.Note) []repository.Note {
+			if pruned[object] {
+				return nil
+			}
+			return notes
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
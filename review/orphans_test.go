@@ -0,0 +1,73 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/request"
+	"testing"
+)
+
+func TestFindAndPruneOrphans(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	orphans, err := FindOrphans(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Fatalf("Unexpected orphans in the mock repo: %v", orphans)
+	}
+
+	missing := "0123456789abcdef0123456789abcdef01234567"
+	note := repository.Note(`{"timestamp": "0000000001", "targetRef": "refs/heads/master", "description": "Rebased away"}`)
+	if err := repo.AppendNote(request.Ref, missing, note); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AppendNote(WatchersRef, missing, repository.Note(`{"timestamp": "0000000002", "watcher": "alice@example.com", "watching": true}`)); err != nil {
+		t.Fatal(err)
+	}
+	orphans, err = FindOrphans(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 || orphans[0].Revision != missing || orphans[0].Reason != OrphanMissingCommit || orphans[0].Request.Description != "Rebased away" {
+		t.Fatalf("Unexpected orphans: %v", orphans)
+	}
+
+	var backups []string
+	if err := PruneOrphans(repo, orphans, func(notesRef string) string {
+		backup := "refs/backup/" + notesRef
+		backups = append(backups, backup)
+		return backup
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Unexpected notes refs rewritten: %v", backups)
+	}
+	for _, notesRef := range []string{request.Ref, WatchersRef} {
+		if annotated, err := repo.ListNotes(notesRef); err != nil || annotated[missing] != "" {
+			t.Fatalf("The notes of the orphaned review were not pruned from %q: %v", notesRef, err)
+		}
+	}
+	if r, err := Get(repo, repository.TestCommitG); err != nil || r == nil {
+		t.Fatalf("Pruning the orphaned review removed another one: %v", err)
+	}
+	if orphans, err := FindOrphans(repo); err != nil || len(orphans) != 0 {
+		t.Fatalf("Unexpected orphans after pruning: %v, %v", orphans, err)
+	}
+}
@@ -19,7 +19,10 @@ package request
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/schema"
+	"sort"
 	"strconv"
 	"time"
 )
@@ -50,6 +53,59 @@ type Request struct {
 	// This allows someone viewing that submitted review to find the diff against which the
 	// code was reviewed.
 	BaseCommit string `json:"baseCommit,omitempty"`
+	// FixedBase is set if the requester chose the BaseCommit explicitly, in which case it is
+	// used as the base of the review even before the review is submitted.
+	FixedBase bool `json:"fixedBase,omitempty"`
+	// BaseReview is the revision of the review that this one is stacked on, if any. Until that
+	// review is submitted, its latest commit is used as the base of this one, so that the
+	// commits under review in it are left out; afterwards, the target ref is used as usual.
+	BaseReview string `json:"baseReview,omitempty"`
+	// Amended is the time at which the request was last amended, if ever. The Timestamp and
+	// Requester fields always keep the values from when the review was first requested.
+	Amended string `json:"amended,omitempty"`
+	// DependsOn holds the revisions of the reviews that have to be submitted before this one.
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// SupersededBy is the revision of the review that replaced this one, such as when the
+	// two were merged as duplicates. A superseded review is abandoned, so it is no longer
+	// open even though it was never submitted.
+	SupersededBy string `json:"supersededBy,omitempty"`
+	// Checklist holds the items that reviewers tick off, such as "Tests added".
+	Checklist []string `json:"checklist,omitempty"`
+	// RequireChecklist keeps the review from counting as accepted until every item of its
+	// checklist has been checked.
+	RequireChecklist bool `json:"requireChecklist,omitempty"`
+	// Signature is an optional (armored) signature of the rest of the request, made by
+	// its requester. See SignedPayload for exactly what is signed.
+	Signature string `json:"signature,omitempty"`
+	// Extensions holds any fields that are not understood by this version of the tool, so
+	// that they are preserved if the note is rewritten.
+	Extensions schema.Extensions `json:"-"`
+}
+
+// UnmarshalJSON parses a review request, keeping any fields that are not understood in the Extensions field.
+func (request *Request) UnmarshalJSON(data []byte) error {
+	type plainRequest Request
+	var plain plainRequest
+	if err := json.Unmarshal(data, &plain); err != nil {
+		return err
+	}
+	extensions, err := schema.Split(data, plain)
+	if err != nil {
+		return err
+	}
+	*request = Request(plain)
+	request.Extensions = extensions
+	return nil
+}
+
+// MarshalJSON serializes a review request, including any fields that were not understood when it was parsed.
+func (request Request) MarshalJSON() ([]byte, error) {
+	type plainRequest Request
+	bytes, err := json.Marshal(plainRequest(request))
+	if err != nil {
+		return nil, err
+	}
+	return request.Extensions.AppendTo(bytes)
 }
 
 // New returns a new request.
@@ -79,19 +135,72 @@ func Parse(note repository.Note) (Request, error) {
 // request from each one. Any notes that are not valid review requests get
 // ignored, as we expect the git notes to be a heterogenous list, with only
 // some of them being review requests.
+//
+// The requests are returned in the order they were written (by when they were last
+// amended, if ever), so that the last one is the current version of the request even
+// when competing amendments have been merged together.
 func ParseAllValid(notes []repository.Note) []Request {
 	var requests []Request
 	for _, note := range notes {
 		request, err := Parse(note)
+		if err == nil && request.Version > FormatVersion {
+			schema.WarnUnsupportedVersion("review request", request.Version, FormatVersion)
+		}
 		if err == nil && request.Version == FormatVersion && request.TargetRef != "" {
 			requests = append(requests, request)
 		}
 	}
+	sort.SliceStable(requests, func(i, j int) bool {
+		return requests[i].writtenAt() < requests[j].writtenAt()
+	})
 	return requests
 }
 
+// writtenAt returns the time at which this version of the request was written, or 0 if that is unknown.
+func (request Request) writtenAt() int64 {
+	timestamp := request.Timestamp
+	if request.Amended != "" {
+		timestamp = request.Amended
+	}
+	parsed, _ := strconv.ParseInt(timestamp, 10, 64)
+	return parsed
+}
+
 // Write writes a review request as a JSON-formatted git note.
 func (request *Request) Write() (repository.Note, error) {
 	bytes, err := json.Marshal(request)
 	return repository.Note(bytes), err
 }
+
+// SignedPayload returns the data covered by the request's signature, which is the
+// request as it would be written without a signature.
+func (request Request) SignedPayload() ([]byte, error) {
+	request.Signature = ""
+	return json.Marshal(request)
+}
+
+// Sign signs the request using the user's signing key, replacing any previous signature.
+func (request *Request) Sign(repo repository.Repo) error {
+	payload, err := request.SignedPayload()
+	if err != nil {
+		return err
+	}
+	signature, err := repo.SignPayload(payload)
+	if err != nil {
+		return err
+	}
+	request.Signature = signature
+	return nil
+}
+
+// VerifySignature verifies the request's signature, and returns the identity of the signer.
+func (request Request) VerifySignature(repo repository.Repo) (string, error) {
+	if request.Signature == "" {
+		return "", errors.New("The request is not signed.")
+	}
+	payload, err := request.SignedPayload()
+	if err != nil {
+		return "", err
+	}
+	return repo.VerifySignature(payload, request.Signature)
+}
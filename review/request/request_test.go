@@ -0,0 +1,64 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package request
+
+import (
+	"github.com/google/git-appraise/repository"
+	"testing"
+)
+
+func TestRoundTripPreservesUnknownFields(t *testing.T) {
+	note := repository.Note(`{"timestamp":"0000000001","targetRef":"refs/heads/master","labels":["bug"],"priority":1}`)
+	r, err := Parse(note)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := r.Write()
+	if err != nil || string(written) != string(note) {
+		t.Fatalf("Round-tripping the request changed it to %q, %v", written, err)
+	}
+
+	r.Description = "Modified"
+	written, err = r.Write()
+	expected := repository.Note(`{"timestamp":"0000000001","targetRef":"refs/heads/master","description":"Modified","labels":["bug"],"priority":1}`)
+	if err != nil || string(written) != string(expected) {
+		t.Fatalf("Modifying the request changed it to %q, %v", written, err)
+	}
+}
+
+func TestParseAllValidSkipsNewerVersions(t *testing.T) {
+	requests := ParseAllValid([]repository.Note{
+		repository.Note(`{"targetRef":"refs/heads/master","v":1}`),
+		repository.Note(`{"targetRef":"refs/heads/master"}`),
+	})
+	if len(requests) != 1 || requests[0].Version != FormatVersion {
+		t.Fatalf("Unexpected requests: %+v", requests)
+	}
+}
+
+func TestParseAllValidTakesNewestAmendment(t *testing.T) {
+	notes := []repository.Note{
+		repository.Note(`{"timestamp":"0000000001","targetRef":"refs/heads/master","description":"original"}`),
+		// Competing amendments, merged in the opposite order to when they were written.
+		repository.Note(`{"timestamp":"0000000001","amended":"0000000005","targetRef":"refs/heads/master","description":"newer"}`),
+		repository.Note(`{"timestamp":"0000000001","amended":"0000000003","targetRef":"refs/heads/master","description":"older"}`),
+	}
+	requests := ParseAllValid(notes)
+	if len(requests) != 3 || requests[0].Description != "original" || requests[2].Description != "newer" {
+		t.Fatalf("Unexpected order of the requests: %+v", requests)
+	}
+}
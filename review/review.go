@@ -20,13 +20,25 @@ package review
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/git-appraise/repository"
 	"github.com/google/git-appraise/review/analyses"
 	"github.com/google/git-appraise/review/ci"
 	"github.com/google/git-appraise/review/comment"
 	"github.com/google/git-appraise/review/request"
+	"github.com/google/git-appraise/review/schema"
+	"regexp"
 	"sort"
+	"strings"
+)
+
+var (
+	// ErrNoCurrentReview is returned when a command acts on the current review, but the
+	// current branch is not under review.
+	ErrNoCurrentReview = errors.New("There is no current review")
+	// ErrReviewNotAccepted is returned when submitting a review that has not been accepted.
+	ErrReviewNotAccepted = errors.New("The review has not yet been accepted")
 )
 
 // CommentThread represents the tree-based hierarchy of comments.
@@ -37,11 +49,75 @@ import (
 // FYI only, and that there are no unaddressed comments. If it is set to true,
 // then that means that there are no unaddressed comments, and that the root
 // comment has its resolved bit set to true.
+//
+// Reactions to the root comment are not included in the children; instead, the
+// Reactions field holds the number of distinct authors for each reaction.
+//
+// The Elided bit is only set by FilterThreads, on threads that were filtered
+// out but are still needed to hold some of their descendants.
 type CommentThread struct {
-	Hash     string          `json:"hash,omitempty"`
-	Comment  comment.Comment `json:"comment"`
-	Children []CommentThread `json:"children,omitempty"`
-	Resolved *bool           `json:"resolved,omitempty"`
+	Hash      string          `json:"hash,omitempty"`
+	Comment   comment.Comment `json:"comment"`
+	Children  []CommentThread `json:"children,omitempty"`
+	Reactions map[string]int  `json:"reactions,omitempty"`
+	Resolved  *bool           `json:"resolved,omitempty"`
+	Elided    bool            `json:"elided,omitempty"`
+}
+
+// IsResolved determines if the thread has no unaddressed comments, and its root comment is resolved.
+func (thread CommentThread) IsResolved() bool {
+	return thread.Resolved != nil && *thread.Resolved
+}
+
+// IsUnresolved determines if the thread has an unaddressed comment.
+//
+// Threads that are only FYIs are neither resolved nor unresolved.
+func (thread CommentThread) IsUnresolved() bool {
+	return thread.Resolved != nil && !*thread.Resolved
+}
+
+// FilterThreads returns the given comment threads, keeping only those for which keep returns true.
+//
+// Kept threads are returned whole. A thread that is not kept, but which has kept descendants,
+// is still returned with the Elided bit set, so that those descendants can be shown in the
+// context of their thread.
+func FilterThreads(threads []CommentThread, keep func(CommentThread) bool) []CommentThread {
+	var filtered []CommentThread
+	for _, thread := range threads {
+		if !keep(thread) {
+			thread.Children = FilterThreads(thread.Children, keep)
+			if len(thread.Children) == 0 {
+				continue
+			}
+			thread.Elided = true
+		}
+		filtered = append(filtered, thread)
+	}
+	return filtered
+}
+
+// NoteError describes a line of a note that could not be parsed.
+type NoteError struct {
+	Ref      string `json:"ref"`
+	Revision string `json:"revision"`
+	Note     string `json:"note"`
+	Error    string `json:"error"`
+}
+
+// findNoteErrors returns the lines in the given notes that could not be parsed.
+func findNoteErrors(ref, revision string, notes []repository.Note) []NoteError {
+	var noteErrors []NoteError
+	for _, note := range notes {
+		if err := schema.CheckWellFormed(note); err != nil {
+			noteErrors = append(noteErrors, NoteError{
+				Ref:      ref,
+				Revision: revision,
+				Note:     string(note),
+				Error:    err.Error(),
+			})
+		}
+	}
+	return noteErrors
 }
 
 // Review represents the entire state of a code review.
@@ -53,24 +129,52 @@ type CommentThread struct {
 // Reviews also include a list of build-and-test status reports. Those
 // correspond to either the current commit in the review ref (for pending
 // reviews), or to the last commented-upon commit (for submitted reviews).
+//
+// Any malformed notes found while reading the review are skipped, and are
+// recorded in the NoteErrors field.
 type Review struct {
-	Repo      repository.Repo   `json:"-"`
-	Revision  string            `json:"revision"`
-	Request   request.Request   `json:"request"`
-	Comments  []CommentThread   `json:"comments,omitempty"`
-	Resolved  *bool             `json:"resolved,omitempty"`
-	Submitted bool              `json:"submitted"`
-	Reports   []ci.Report       `json:"reports,omitempty"`
-	Analyses  []analyses.Report `json:"analyses,omitempty"`
+	Repo       repository.Repo   `json:"-"`
+	Revision   string            `json:"revision"`
+	Request    request.Request   `json:"request"`
+	Comments   []CommentThread   `json:"comments,omitempty"`
+	Resolved   *bool             `json:"resolved,omitempty"`
+	Submitted  bool              `json:"submitted"`
+	Reports    []ci.Report       `json:"reports,omitempty"`
+	Analyses   []analyses.Report `json:"analyses,omitempty"`
+	NoteErrors []NoteError       `json:"noteErrors,omitempty"`
+	// Labels holds the sorted labels that the review currently has.
+	Labels []string `json:"labels,omitempty"`
+	// Attention holds the sorted email addresses of everyone whose turn it is to act on the review.
+	Attention []string `json:"attention,omitempty"`
+	// AwaitingResponse indicates that the review would be accepted, but for a conditional
+	// acceptance that the requester has not yet responded to.
+	AwaitingResponse bool `json:"awaitingResponse,omitempty"`
+	// Checklist holds the items of the request's checklist, and whether each has been checked.
+	Checklist []ChecklistItem `json:"checklist,omitempty"`
+	// AwaitingChecklist indicates that the review would be accepted, but for the items of
+	// its required checklist that have not yet been checked.
+	AwaitingChecklist bool `json:"awaitingChecklist,omitempty"`
+	// Unavailable indicates that the review's commit, or the history needed to tell whether
+	// it was submitted, is missing from a shallow clone.
+	Unavailable bool `json:"unavailable,omitempty"`
 }
 
 type byTimestamp []CommentThread
 
-// Interface methods for sorting comment threads by timestamp
+// Interface methods for sorting comment threads by timestamp.
+//
+// Ties are broken first by author and then by comment hash, so that the resulting
+// order is total, and does not depend on the order in which the comments were read.
 func (threads byTimestamp) Len() int      { return len(threads) }
 func (threads byTimestamp) Swap(i, j int) { threads[i], threads[j] = threads[j], threads[i] }
 func (threads byTimestamp) Less(i, j int) bool {
-	return threads[i].Comment.Timestamp < threads[j].Comment.Timestamp
+	if threads[i].Comment.Timestamp != threads[j].Comment.Timestamp {
+		return threads[i].Comment.Timestamp < threads[j].Comment.Timestamp
+	}
+	if threads[i].Comment.Author != threads[j].Comment.Author {
+		return threads[i].Comment.Author < threads[j].Comment.Author
+	}
+	return threads[i].Hash < threads[j].Hash
 }
 
 // updateThreadsStatus calculates the aggregate status of a sequence of comment threads.
@@ -82,7 +186,9 @@ func updateThreadsStatus(threads []CommentThread) *bool {
 	sort.Sort(byTimestamp(threads))
 	noUnresolved := true
 	var result *bool
-	for _, thread := range threads {
+	for i := range threads {
+		// Update the thread in place, so that its status is kept.
+		thread := &threads[i]
 		thread.updateResolvedStatus()
 		if thread.Resolved != nil {
 			noUnresolved = noUnresolved && *thread.Resolved
@@ -114,6 +220,23 @@ func (thread *CommentThread) updateResolvedStatus() {
 	thread.Resolved = resolved
 }
 
+// hasReplyFrom determines if any of the replies in the given thread were written by the given author.
+func (thread *CommentThread) hasReplyFrom(author string) bool {
+	for _, child := range thread.Children {
+		if child.Comment.Author == author || child.hasReplyFrom(author) {
+			return true
+		}
+	}
+	return false
+}
+
+// AwaitsResponseFrom determines if the thread is a conditional acceptance that the given
+// author (the requester of the review) has not yet responded to.
+func (thread *CommentThread) AwaitsResponseFrom(author string) bool {
+	accepted := thread.Comment.Resolved != nil && *thread.Comment.Resolved
+	return accepted && thread.Comment.Conditional && !thread.hasReplyFrom(author)
+}
+
 // mutableThread is an internal-only data structure used to store partially constructed comment threads.
 type mutableThread struct {
 	Hash     string
@@ -126,13 +249,27 @@ type mutableThread struct {
 // (fully constructed comment thread).
 func fixMutableThread(mutableThread *mutableThread) CommentThread {
 	var children []CommentThread
+	var reactions map[string]int
+	reactors := make(map[string]bool)
 	for _, mutableChild := range mutableThread.Children {
+		if reaction := mutableChild.Comment.Reaction; reaction != "" {
+			reactor := reaction + " " + mutableChild.Comment.Author
+			if !reactors[reactor] {
+				reactors[reactor] = true
+				if reactions == nil {
+					reactions = make(map[string]int)
+				}
+				reactions[reaction]++
+			}
+			continue
+		}
 		children = append(children, fixMutableThread(mutableChild))
 	}
 	return CommentThread{
-		Hash:     mutableThread.Hash,
-		Comment:  mutableThread.Comment,
-		Children: children,
+		Hash:      mutableThread.Hash,
+		Comment:   mutableThread.Comment,
+		Children:  children,
+		Reactions: reactions,
 	}
 }
 
@@ -155,7 +292,9 @@ func buildCommentThreads(commentsByHash map[string]comment.Comment) []CommentThr
 	var rootHashes []string
 	for hash, thread := range threadsByHash {
 		if thread.Comment.Parent == "" {
-			rootHashes = append(rootHashes, hash)
+			if thread.Comment.Reaction == "" {
+				rootHashes = append(rootHashes, hash)
+			}
 		} else {
 			parent, ok := threadsByHash[thread.Comment.Parent]
 			if ok {
@@ -174,6 +313,7 @@ func buildCommentThreads(commentsByHash map[string]comment.Comment) []CommentThr
 // and then builds the corresponding tree-structured comment threads.
 func (r *Review) loadComments() []CommentThread {
 	commentNotes := r.Repo.GetNotes(comment.Ref, r.Revision)
+	r.NoteErrors = append(r.NoteErrors, findNoteErrors(comment.Ref, r.Revision, commentNotes)...)
 	commentsByHash := comment.ParseAllValid(commentNotes)
 	return buildCommentThreads(commentsByHash)
 }
@@ -188,70 +328,224 @@ func Get(repo repository.Repo, revision string) (*Review, error) {
 		return nil, nil
 	}
 	review := Review{
-		Repo:     repo,
-		Revision: revision,
-		Request:  requests[len(requests)-1],
+		Repo:       repo,
+		Revision:   revision,
+		Request:    requests[len(requests)-1],
+		NoteErrors: findNoteErrors(request.Ref, revision, requestNotes),
 	}
 	review.Comments = review.loadComments()
+	review.Labels = parseLabels(repo.GetNotes(LabelsRef, revision))
+	review.Attention = computeAttention(requests, review.Comments, repo.GetNotes(AttentionRef, revision))
 	review.Resolved = updateThreadsStatus(review.Comments)
+	if review.Resolved != nil && *review.Resolved {
+		for _, thread := range review.Comments {
+			if thread.AwaitsResponseFrom(review.Request.Requester) {
+				resolved := false
+				review.Resolved = &resolved
+				review.AwaitingResponse = true
+				break
+			}
+		}
+	}
+	review.Checklist = parseChecklist(review.Request.Checklist, repo.GetNotes(ChecklistRef, revision))
+	if review.Resolved != nil && *review.Resolved && review.Request.RequireChecklist && len(review.UncheckedItems()) > 0 {
+		resolved := false
+		review.Resolved = &resolved
+		review.AwaitingChecklist = true
+	}
 	submitted, err := repo.IsAncestor(revision, review.Request.TargetRef)
-	if err != nil {
+	if _, ok := err.(repository.ShallowRepoError); ok {
+		// The rest of the review can still be shown, just not whether it was submitted.
+		review.Unavailable = true
+	} else if err != nil {
 		return nil, err
 	}
 	review.Submitted = submitted
+	if !submitted && !review.Unavailable {
+		if shallow, err := repo.IsShallow(); err == nil && shallow && repo.VerifyCommit(revision) != nil {
+			review.Unavailable = true
+		}
+	}
 	currentCommit, err := review.GetHeadCommit()
 	if err == nil {
-		review.Reports = ci.ParseAllValid(repo.GetNotes(ci.Ref, currentCommit))
-		review.Analyses = analyses.ParseAllValid(repo.GetNotes(analyses.Ref, currentCommit))
+		ciNotes := repo.GetNotes(ci.Ref, currentCommit)
+		analysesNotes := repo.GetNotes(analyses.Ref, currentCommit)
+		review.Reports = ci.ParseAllValid(ciNotes)
+		review.Analyses = analyses.ParseAllValid(analysesNotes)
+		review.NoteErrors = append(review.NoteErrors, findNoteErrors(ci.Ref, currentCommit, ciNotes)...)
+		review.NoteErrors = append(review.NoteErrors, findNoteErrors(analyses.Ref, currentCommit, analysesNotes)...)
 	}
 	return &review, nil
 }
 
-// ListAll returns all reviews stored in the git-notes.
-func ListAll(repo repository.Repo) []Review {
-	var reviews []Review
-	for _, revision := range repo.ListNotedRevisions(request.Ref) {
-		review, err := Get(repo, revision)
-		if err == nil && review != nil {
-			reviews = append(reviews, *review)
+// GetRequestHistory returns every version of the review's request, oldest first.
+//
+// The last version is the one held in the Request field.
+func (r *Review) GetRequestHistory() []request.Request {
+	return request.ParseAllValid(r.Repo.GetNotes(request.Ref, r.Revision))
+}
+
+// listRevisions returns the revisions that have review requests.
+//
+// In a shallow clone, this includes the revisions whose commits are missing, so that their
+// reviews can still be listed.
+func listRevisions(repo repository.Repo) []string {
+	revisions := repo.ListNotedRevisions(request.Ref)
+	if shallow, err := repo.IsShallow(); err != nil || !shallow {
+		return revisions
+	}
+	notes, err := repo.ListNotes(request.Ref)
+	if err != nil {
+		return revisions
+	}
+	for _, revision := range revisions {
+		delete(notes, revision)
+	}
+	for object := range notes {
+		if repo.VerifyCommit(object) != nil {
+			revisions = append(revisions, object)
 		}
 	}
-	return reviews
+	sort.Strings(revisions)
+	return revisions
+}
+
+// ListAll returns all reviews stored in the git-notes.
+func ListAll(repo repository.Repo) []Review {
+	return getAll(repo, listRevisions(repo))
+}
+
+// IsOpen returns whether or not the review is still open, meaning that it has neither been
+// submitted nor been superseded by another review.
+func (r *Review) IsOpen() bool {
+	return !r.Submitted && r.Request.SupersededBy == ""
 }
 
-// ListOpen returns all reviews that are not yet incorporated into their target refs.
+// ListOpen returns all reviews that are not yet incorporated into their target refs, and
+// have not been superseded by other reviews.
 func ListOpen(repo repository.Repo) []Review {
 	var openReviews []Review
 	for _, review := range ListAll(repo) {
-		if !review.Submitted {
+		if review.IsOpen() {
 			openReviews = append(openReviews, review)
 		}
 	}
 	return openReviews
 }
 
+// AmbiguousReviewError is returned when there are multiple open reviews that could be
+// the current review.
+type AmbiguousReviewError struct {
+	// Head is the ref (or, for a detached HEAD, the commit) that the reviews matched.
+	Head string
+	// Candidates holds the hashes of the matching reviews.
+	Candidates []string
+}
+
+func (e AmbiguousReviewError) Error() string {
+	var candidates []string
+	for _, candidate := range e.Candidates {
+		candidates = append(candidates, fmt.Sprintf("%.12s", candidate))
+	}
+	return fmt.Sprintf("There are %d open reviews for %q; specify one of the following review hashes explicitly: %s", len(e.Candidates), e.Head, strings.Join(candidates, ", "))
+}
+
+// getCurrentForDetachedHead returns the open review whose head commit is the given commit.
+func getCurrentForDetachedHead(repo repository.Repo, headCommit string) (*Review, error) {
+	var matchingReviews []Review
+	for _, review := range ListOpen(repo) {
+		if reviewHead, err := review.GetHeadCommit(); err == nil && reviewHead == headCommit {
+			matchingReviews = append(matchingReviews, review)
+		}
+	}
+	return pickCurrent(matchingReviews, headCommit)
+}
+
+// pickCurrent returns the only review in the given list, nil if the list is empty,
+// or an AmbiguousReviewError if there are several.
+func pickCurrent(matchingReviews []Review, head string) (*Review, error) {
+	if matchingReviews == nil {
+		return nil, nil
+	}
+	if len(matchingReviews) != 1 {
+		var candidates []string
+		for _, review := range matchingReviews {
+			candidates = append(candidates, review.Revision)
+		}
+		return nil, AmbiguousReviewError{Head: head, Candidates: candidates}
+	}
+	r := &matchingReviews[0]
+	return r, nil
+}
+
 // GetCurrent returns the current, open code review.
 //
-// If there are multiple matching reviews, then an error is returned.
+// That is the open review for the checked out ref or, if HEAD is detached, the open
+// review whose head commit is checked out. If there is no such review, then both
+// return values are nil, and if there are multiple matching reviews, then the error
+// is an AmbiguousReviewError.
 func GetCurrent(repo repository.Repo) (*Review, error) {
-	reviewRef, err := repo.GetHeadRef()
+	bare, err := repo.IsBare()
 	if err != nil {
 		return nil, err
 	}
+	if bare {
+		return nil, errors.New("There is no current review in a bare repository; specify the review hash explicitly.")
+	}
+	reviewRef, err := repo.GetHeadRef()
+	if err != nil {
+		headCommit, headErr := repo.GetCommitHash("HEAD")
+		if headErr != nil {
+			return nil, err
+		}
+		return getCurrentForDetachedHead(repo, headCommit)
+	}
 	var matchingReviews []Review
 	for _, review := range ListOpen(repo) {
 		if review.Request.ReviewRef == reviewRef {
 			matchingReviews = append(matchingReviews, review)
 		}
 	}
-	if matchingReviews == nil {
-		return nil, nil
+	return pickCurrent(matchingReviews, reviewRef)
+}
+
+// submitMessagePattern matches the subject of the merge commits created by the submit command,
+// or the "Review:" line of its squash commits, which name the review that was submitted.
+var submitMessagePattern = regexp.MustCompile(`(?m)\ASubmitting review ([0-9a-f]+)|^Review: ([0-9a-f]{40})$`)
+
+// FindForCommit returns the reviews that resulted in the given commit.
+//
+// Those are the reviews whose revision or head commit is the given commit or, for a merge
+// commit, one of the merged parents, along with a review named in the message of a merge
+// or squash commit created by the submit command. Rebased commits are not matched, since
+// they keep no record of the review they came from.
+func FindForCommit(repo repository.Repo, commit string) ([]Review, error) {
+	details, err := repo.GetCommitDetails(commit)
+	if err != nil {
+		return nil, err
 	}
-	if len(matchingReviews) != 1 {
-		return nil, fmt.Errorf("There are %d open reviews for the ref \"%s\"", len(matchingReviews), reviewRef)
+	candidates := map[string]bool{commit: true}
+	if len(details.Parents) > 1 {
+		// The first parent is the previous commit to the target, rather than part of the review.
+		for _, parent := range details.Parents[1:] {
+			candidates[parent] = true
+		}
 	}
-	r := &matchingReviews[0]
-	return r, nil
+	var submittedRevision string
+	if message, err := repo.GetCommitMessage(commit); err == nil {
+		if match := submitMessagePattern.FindStringSubmatch(message); match != nil {
+			submittedRevision = match[1] + match[2]
+		}
+	}
+	var matchingReviews []Review
+	for _, r := range ListAll(repo) {
+		if submittedRevision != "" && strings.HasPrefix(r.Revision, submittedRevision) || candidates[r.Revision] {
+			matchingReviews = append(matchingReviews, r)
+		} else if head, err := r.GetHeadCommit(); err == nil && candidates[head] {
+			matchingReviews = append(matchingReviews, r)
+		}
+	}
+	return matchingReviews, nil
 }
 
 // GetBuildStatusMessage returns a string of the current build-and-test status
@@ -359,8 +653,37 @@ func (r *Review) GetHeadCommit() (string, error) {
 	return r.Repo.ResolveRefCommit(r.Request.ReviewRef)
 }
 
+// getStackedBase returns the merge base of the review with the latest commit of the review that
+// it is stacked on, or "" if the latter has since been submitted (or is gone).
+func (r *Review) getStackedBase() (string, error) {
+	base, err := Get(r.Repo, r.Request.BaseReview)
+	if err != nil || base == nil || base.Submitted {
+		return "", err
+	}
+	baseHead, err := base.GetHeadCommit()
+	if err != nil {
+		return "", err
+	}
+	reviewHead, err := r.GetHeadCommit()
+	if err != nil {
+		return "", err
+	}
+	return r.Repo.MergeBase(baseHead, reviewHead)
+}
+
 // GetBaseCommit returns the commit against which a review should be compared.
+//
+// For a review stacked on another one that has not yet been submitted, this is where it
+// forks from the latest commit of that other review.
 func (r *Review) GetBaseCommit() (string, error) {
+	if r.Request.FixedBase && r.Request.BaseCommit != "" {
+		return r.Request.BaseCommit, nil
+	}
+	if !r.Submitted && r.Request.BaseReview != "" {
+		if base, err := r.getStackedBase(); err != nil || base != "" {
+			return base, err
+		}
+	}
 	if r.Submitted {
 		if r.Request.BaseCommit != "" {
 			return r.Request.BaseCommit, nil
@@ -389,7 +712,47 @@ func (r *Review) GetBaseCommit() (string, error) {
 	return r.Repo.MergeBase(leftHandSide, rightHandSide)
 }
 
-// GetDiff returns the diff for a review.
+// GetCommits returns the commits of a review, oldest first.
+func (r *Review) GetCommits() ([]string, error) {
+	baseCommit, err := r.GetBaseCommit()
+	if err != nil {
+		return nil, err
+	}
+	headCommit, err := r.GetHeadCommit()
+	if err != nil {
+		return nil, err
+	}
+	return r.Repo.ListCommitsBetween(baseCommit, headCommit)
+}
+
+// GetCommitLog returns the details of the commits of a review, oldest first.
+func (r *Review) GetCommitLog() ([]repository.Commit, error) {
+	baseCommit, err := r.GetBaseCommit()
+	if err != nil {
+		return nil, err
+	}
+	headCommit, err := r.GetHeadCommit()
+	if err != nil {
+		return nil, err
+	}
+	return r.Repo.ListCommits(baseCommit, headCommit)
+}
+
+// WithSubmoduleLog adds "--submodule=log" to the given diff arguments, unless they already
+// say how to show submodules, so that the changes to submodules are summarized by the range
+// of submodule commits, along with their subjects if the submodule is checked out.
+//
+// This is only meant for diffs that are shown to people, since the summaries cannot be applied.
+func WithSubmoduleLog(diffArgs []string) []string {
+	for _, arg := range diffArgs {
+		if strings.HasPrefix(arg, "--submodule") {
+			return diffArgs
+		}
+	}
+	return append([]string{"--submodule=log"}, diffArgs...)
+}
+
+// GetDiff returns the diff for a review, for showing it, with the changes to submodules summarized.
 func (r *Review) GetDiff(diffArgs ...string) (string, error) {
 	var baseCommit, headCommit string
 	baseCommit, err := r.GetBaseCommit()
@@ -397,11 +760,44 @@ func (r *Review) GetDiff(diffArgs ...string) (string, error) {
 		headCommit, err = r.GetHeadCommit()
 	}
 	if err == nil {
-		return r.Repo.Diff(baseCommit, headCommit, diffArgs...)
+		return r.Repo.Diff(baseCommit, headCommit, WithSubmoduleLog(diffArgs)...)
 	}
 	return "", err
 }
 
+// findSubmoduleSummary returns the lines of the given diff that summarize the changes to the
+// given submodule, or nil if the diff does not change it.
+func findSubmoduleSummary(diff, path string) []string {
+	var summary []string
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "Submodule "+path+" ") {
+			summary = append(summary, line)
+		} else if len(summary) > 0 && (strings.HasPrefix(line, "  > ") || strings.HasPrefix(line, "  < ")) {
+			summary = append(summary, line)
+		} else if len(summary) > 0 {
+			break
+		}
+	}
+	return summary
+}
+
+// GetSubmoduleSummary returns a summary of how the review changes the given submodule, as of
+// the given commit.
+//
+// This is the range of submodule commits between the review's base and the given commit, along
+// with the subjects of those commits if the submodule is initialized locally.
+func (r *Review) GetSubmoduleSummary(commit, path string) ([]string, error) {
+	baseCommit, err := r.GetBaseCommit()
+	if err != nil {
+		return nil, err
+	}
+	diff, err := r.Repo.Diff(baseCommit, commit, "--submodule=log")
+	if err != nil {
+		return nil, err
+	}
+	return findSubmoduleSummary(diff, path), nil
+}
+
 // AddComment adds the given comment to the review.
 func (r *Review) AddComment(c comment.Comment) error {
 	commentNote, err := c.Write()
@@ -409,6 +805,5 @@ func (r *Review) AddComment(c comment.Comment) error {
 		return err
 	}
 
-	r.Repo.AppendNote(comment.Ref, r.Revision, commentNote)
-	return nil
+	return r.Repo.AppendNote(comment.Ref, r.Revision, commentNote)
 }
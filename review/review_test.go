@@ -17,9 +17,13 @@ limitations under the License.
 package review
 
 import (
+	"fmt"
 	"github.com/google/git-appraise/repository"
 	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/request"
+	"reflect"
 	"sort"
+	"strings"
 	"testing"
 )
 
@@ -56,7 +60,7 @@ func TestCommentSorting(t *testing.T) {
 		descriptions = append(descriptions, thread.Comment.Description)
 	}
 	if !(descriptions[0] == "First" && descriptions[1] == "Second" && descriptions[2] == "Third" && descriptions[3] == "Fourth") {
-		t.Fatalf("Comment thread ordering failed. Got %s", sampleThreads)
+		t.Fatalf("Comment thread ordering failed. Got %v", sampleThreads)
 	}
 }
 
@@ -535,6 +539,43 @@ func TestBuildCommentThreads(t *testing.T) {
 	}
 }
 
+func TestBuildCommentThreadsWithReactions(t *testing.T) {
+	accepted := true
+	root := comment.Comment{
+		Timestamp:   "012345",
+		Resolved:    &accepted,
+		Description: "root",
+	}
+	rootHash, err := root.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commentsByHash := map[string]comment.Comment{rootHash: root}
+	for i, reaction := range []comment.Comment{
+		{Timestamp: "012346", Author: "a", Parent: rootHash, Reaction: comment.ReactionThumbsUp},
+		{Timestamp: "012347", Author: "b", Parent: rootHash, Reaction: comment.ReactionThumbsUp},
+		{Timestamp: "012348", Author: "a", Parent: rootHash, Reaction: comment.ReactionThumbsUp},
+		{Timestamp: "012349", Author: "a", Parent: rootHash, Reaction: comment.ReactionThumbsDown},
+	} {
+		hash, err := reaction.Hash()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		commentsByHash[hash] = reaction
+	}
+	threads := buildCommentThreads(commentsByHash)
+	if len(threads) != 1 || len(threads[0].Children) != 0 {
+		t.Fatalf("Unexpected threads: %v", threads)
+	}
+	reactions := threads[0].Reactions
+	if len(reactions) != 2 || reactions[comment.ReactionThumbsUp] != 2 || reactions[comment.ReactionThumbsDown] != 1 {
+		t.Fatalf("Unexpected reactions: %v", reactions)
+	}
+	if status := updateThreadsStatus(threads); status == nil || !*status {
+		t.Fatalf("Reactions unexpectedly changed the thread status")
+	}
+}
+
 func TestGetHeadCommit(t *testing.T) {
 	repo := repository.NewMockRepoForTest()
 
@@ -613,4 +654,340 @@ func TestGetBaseCommit(t *testing.T) {
 	if pendingReviewBase != repository.TestCommitF {
 		t.Fatal("Unexpected base commit computed for a pending review.")
 	}
+
+	pendingReview.Request.BaseCommit = repository.TestCommitC
+	pendingReview.Request.FixedBase = true
+	fixedBase, err := pendingReview.GetBaseCommit()
+	if err != nil {
+		t.Fatal("Unable to compute the base commit for a pending review with a fixed base: ", err)
+	}
+	if fixedBase != repository.TestCommitC {
+		t.Fatal("Unexpected base commit computed for a pending review with a fixed base.")
+	}
+}
+
+func TestCommentOrderingIsIndependentOfMergeOrder(t *testing.T) {
+	// Comments written offline in two separate clones, with colliding timestamps.
+	firstClone := []comment.Comment{
+		comment.Comment{Timestamp: "0000000010", Author: "bob", Description: "First from bob"},
+		comment.Comment{Timestamp: "0000000010", Author: "bob", Description: "Second from bob"},
+	}
+	secondClone := []comment.Comment{
+		comment.Comment{Timestamp: "0000000010", Author: "alice", Description: "From alice"},
+		comment.Comment{Timestamp: "0000000009", Author: "carol", Description: "From carol"},
+	}
+	renderAfterMerge := func(comments ...[]comment.Comment) string {
+		repo := repository.NewMockRepoForTest()
+		for _, clone := range comments {
+			for _, c := range clone {
+				note, err := c.Write()
+				if err != nil {
+					t.Fatal(err)
+				}
+				repo.AppendNote(comment.Ref, repository.TestCommitG, note)
+			}
+		}
+		r, err := Get(repo, repository.TestCommitG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json, err := r.GetJson()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return json
+	}
+	for i := 0; i < 10; i++ {
+		if renderAfterMerge(firstClone, secondClone) != renderAfterMerge(secondClone, firstClone) {
+			t.Fatal("Comment rendering depends on the order in which the clones were merged")
+		}
+	}
+}
+
+func TestGetCurrentForDetachedHead(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	r, err := getCurrentForDetachedHead(repo, repository.TestCommitI)
+	if err != nil || r == nil || r.Revision != repository.TestCommitG {
+		t.Fatalf("Failed to find the review for a detached HEAD: %v, %v", r, err)
+	}
+	r, err = getCurrentForDetachedHead(repo, repository.TestCommitJ)
+	if err != nil || r != nil {
+		t.Fatalf("Unexpectedly found a review for a detached HEAD: %v, %v", r, err)
+	}
+
+	if err := repo.AppendNote(request.Ref, repository.TestCommitH, repository.Note(repository.TestRequestG)); err != nil {
+		t.Fatal(err)
+	}
+	r, err = getCurrentForDetachedHead(repo, repository.TestCommitI)
+	ambiguousErr, ok := err.(AmbiguousReviewError)
+	if !ok || r != nil {
+		t.Fatalf("Failed to detect ambiguous reviews for a detached HEAD: %v, %v", r, err)
+	}
+	if len(ambiguousErr.Candidates) != 2 || ambiguousErr.Head != repository.TestCommitI {
+		t.Fatalf("Unexpected ambiguous review error: %+v", ambiguousErr)
+	}
+}
+
+func TestConditionalAcceptance(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	resolved := true
+	acceptance := comment.New("reviewer", "LGTM, once the typo is fixed")
+	acceptance.Timestamp = "0000000005"
+	acceptance.Location = &comment.Location{Commit: repository.TestCommitI}
+	acceptance.Resolved = &resolved
+	acceptance.Scope = "the API"
+	acceptance.Conditional = true
+	acceptanceNote, err := acceptance.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AppendNote(comment.Ref, repository.TestCommitG, acceptanceNote); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Resolved == nil || *r.Resolved || !r.AwaitingResponse {
+		t.Fatalf("Unexpected status for a conditionally accepted review: %v, %v", r.Resolved, r.AwaitingResponse)
+	}
+	if len(r.Comments) != 1 || r.Comments[0].Comment.Scope != "the API" || !r.Comments[0].AwaitsResponseFrom("ojarjur") {
+		t.Fatalf("Unexpected comments: %+v", r.Comments)
+	}
+
+	acceptanceHash, err := acceptance.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := comment.New("ojarjur", "Fixed")
+	response.Timestamp = "0000000006"
+	response.Parent = acceptanceHash
+	responseNote, err := response.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AppendNote(comment.Ref, repository.TestCommitG, responseNote); err != nil {
+		t.Fatal(err)
+	}
+	r, err = Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Resolved == nil || !*r.Resolved || r.AwaitingResponse {
+		t.Fatalf("Unexpected status once the requester responded: %v, %v", r.Resolved, r.AwaitingResponse)
+	}
+}
+
+// shallowRepoForTest simulates a shallow clone that is missing some of the mock repo's commits.
+type shallowRepoForTest struct {
+	repository.Repo
+	// missing holds the commits that are not available.
+	missing map[string]bool
+	// truncated holds the refs whose history is incomplete.
+	truncated map[string]bool
+}
+
+func (repo shallowRepoForTest) IsShallow() (bool, error) { return true, nil }
+
+func (repo shallowRepoForTest) VerifyCommit(hash string) error {
+	if repo.missing[hash] {
+		return fmt.Errorf("Unknown commit %q", hash)
+	}
+	return repo.Repo.VerifyCommit(hash)
+}
+
+func (repo shallowRepoForTest) IsAncestor(ancestor, descendant string) (bool, error) {
+	if repo.truncated[descendant] {
+		return false, repository.ShallowRepoError{Operation: "determine commit ancestry"}
+	}
+	if repo.missing[ancestor] {
+		return false, nil
+	}
+	return repo.Repo.IsAncestor(ancestor, descendant)
+}
+
+func TestUnavailableReviews(t *testing.T) {
+	repo := shallowRepoForTest{
+		Repo:      repository.NewMockRepoForTest(),
+		missing:   map[string]bool{repository.TestCommitG: true},
+		truncated: make(map[string]bool),
+	}
+	r, err := Get(repo, repository.TestCommitG)
+	if err != nil || r == nil || !r.Unavailable || r.Submitted {
+		t.Fatalf("Unexpected review for a missing commit: %+v, %v", r, err)
+	}
+	r, err = Get(repo, repository.TestCommitB)
+	if err != nil || r == nil || r.Unavailable || !r.Submitted {
+		t.Fatalf("Unexpected review for an available commit: %+v, %v", r, err)
+	}
+	repo.truncated["refs/heads/master"] = true
+	r, err = Get(repo, repository.TestCommitB)
+	if err != nil || r == nil || !r.Unavailable {
+		t.Fatalf("Unexpected review with missing history: %+v, %v", r, err)
+	}
+}
+
+func TestGetSignOffs(t *testing.T) {
+	accepted := true
+	rejected := false
+	r := Review{
+		Request: request.Request{Reviewers: []string{"alice", "bob", "carol"}},
+		Comments: []CommentThread{
+			{
+				Comment: comment.Comment{Author: "alice", Timestamp: "0000000001", Resolved: &rejected, Category: "style"},
+				Children: []CommentThread{
+					{Comment: comment.Comment{Author: "alice", Timestamp: "0000000003", Resolved: &accepted}},
+					{Comment: comment.Comment{Author: "bob", Timestamp: "0000000002", Description: "FYI"}},
+				},
+			},
+			{Comment: comment.Comment{Author: "bob", Timestamp: "0000000004", Resolved: &rejected, Category: "needs-tests"}},
+			{Comment: comment.Comment{Author: "dave", Timestamp: "0000000005", Resolved: &accepted, Conditional: true}},
+		},
+	}
+	expected := []SignOff{
+		{Reviewer: "alice", Status: SignOffAccepted, Timestamp: "0000000003"},
+		{Reviewer: "bob", Status: SignOffRejected, Timestamp: "0000000004", Category: "needs-tests"},
+		{Reviewer: "carol", Status: SignOffPending},
+		{Reviewer: "dave", Status: SignOffAccepted, Timestamp: "0000000005", Conditional: true},
+	}
+	signOffs := r.GetSignOffs()
+	if fmt.Sprintf("%+v", signOffs) != fmt.Sprintf("%+v", expected) {
+		t.Fatalf("Unexpected sign-offs: %+v", signOffs)
+	}
+	// Alice's rejection for style no longer counts, as she has since accepted the review.
+	if !r.IsRejected("") || !r.IsRejected("needs-tests") || r.IsRejected("style") {
+		t.Fatal("Unexpected rejections of the review")
+	}
+}
+
+func TestValidateRejectionCategory(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	if err := ValidateRejectionCategory(repo, "design-concern"); err != nil {
+		t.Fatalf("Unexpectedly refused a category while any are allowed: %v", err)
+	}
+	if err := ValidateRejectionCategory(repo, "-style"); err == nil {
+		t.Fatal("Unexpectedly allowed an invalid category name")
+	}
+	repo.SetConfigValue(RejectionCategoriesConfigKey, "needs-tests, design-concern style")
+	if err := ValidateRejectionCategory(repo, "style"); err != nil {
+		t.Fatalf("Unexpectedly refused an allowed category: %v", err)
+	}
+	if err := ValidateRejectionCategory(repo, "typo"); err == nil || !strings.Contains(err.Error(), "needs-tests, design-concern, style") {
+		t.Fatalf("Unexpected result of using a category that is not allowed: %v", err)
+	}
+}
+
+func TestFilterThreads(t *testing.T) {
+	accepted := true
+	rejected := false
+	threads := []CommentThread{
+		{Hash: "fyi", Comment: comment.Comment{Timestamp: "012345"}, Children: []CommentThread{
+			{Hash: "fyi-accepted", Comment: comment.Comment{Timestamp: "012346", Resolved: &accepted}},
+			{Hash: "fyi-fyi", Comment: comment.Comment{Timestamp: "012347"}},
+		}},
+		{Hash: "rejected", Comment: comment.Comment{Timestamp: "012348", Resolved: &rejected}},
+		{Hash: "accepted", Comment: comment.Comment{Timestamp: "012349", Resolved: &accepted}, Children: []CommentThread{
+			{Hash: "accepted-fyi", Comment: comment.Comment{Timestamp: "012350"}},
+		}},
+	}
+	updateThreadsStatus(threads)
+	if threads[0].Resolved != nil || !threads[0].Children[0].IsResolved() || !threads[1].IsUnresolved() || !threads[2].IsResolved() {
+		t.Fatalf("The statuses of the threads were not kept: %+v", threads)
+	}
+
+	resolved := FilterThreads(threads, CommentThread.IsResolved)
+	if len(resolved) != 2 || resolved[0].Hash != "fyi" || !resolved[0].Elided || resolved[1].Hash != "accepted" || resolved[1].Elided {
+		t.Fatalf("Unexpected resolved threads: %+v", resolved)
+	}
+	if len(resolved[0].Children) != 1 || resolved[0].Children[0].Hash != "fyi-accepted" {
+		t.Fatalf("Unexpected resolved replies to a hidden thread: %+v", resolved[0].Children)
+	}
+	if len(resolved[1].Children) != 1 {
+		t.Fatalf("The replies to a resolved thread were filtered out: %+v", resolved[1].Children)
+	}
+
+	unresolved := FilterThreads(threads, CommentThread.IsUnresolved)
+	if len(unresolved) != 1 || unresolved[0].Hash != "rejected" || unresolved[0].Elided {
+		t.Fatalf("Unexpected unresolved threads: %+v", unresolved)
+	}
+	if len(threads[0].Children) != 2 || threads[0].Elided {
+		t.Fatalf("The original threads were modified: %+v", threads[0])
+	}
+}
+
+func TestFindSubmoduleSummary(t *testing.T) {
+	diff := `diff --git a/README b/README
+index 1234567..89abcde 100644
+--- a/README
++++ b/README
+@@ -1 +1 @@
+-Old
++New
+Submodule lib 1111111..2222222:
+  > Second submodule commit
+  > First submodule commit
+Submodule other 3333333...4444444 (commits not present)`
+	expected := []string{
+		"Submodule lib 1111111..2222222:",
+		"  > Second submodule commit",
+		"  > First submodule commit",
+	}
+	if summary := findSubmoduleSummary(diff, "lib"); !reflect.DeepEqual(summary, expected) {
+		t.Fatalf("Unexpected summary for a checked out submodule: %q", summary)
+	}
+	expected = []string{"Submodule other 3333333...4444444 (commits not present)"}
+	if summary := findSubmoduleSummary(diff, "other"); !reflect.DeepEqual(summary, expected) {
+		t.Fatalf("Unexpected summary for a missing submodule: %q", summary)
+	}
+	if summary := findSubmoduleSummary(diff, "README"); summary != nil {
+		t.Fatalf("Unexpected summary for a file: %q", summary)
+	}
+}
+
+func TestFindForCommit(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	findRevisions := func(commit string) []string {
+		reviews, err := FindForCommit(repo, commit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var revisions []string
+		for _, r := range reviews {
+			revisions = append(revisions, r.Revision)
+		}
+		return revisions
+	}
+	if revisions := findRevisions(repository.TestCommitI); !reflect.DeepEqual(revisions, []string{repository.TestCommitG}) {
+		t.Fatalf("Unexpected reviews for the head of a review: %v", revisions)
+	}
+	if revisions := findRevisions(repository.TestCommitD); !reflect.DeepEqual(revisions, []string{repository.TestCommitD}) {
+		t.Fatalf("Unexpected reviews for a reviewed merge commit: %v", revisions)
+	}
+	if revisions := findRevisions(repository.TestCommitE); !reflect.DeepEqual(revisions, []string{repository.TestCommitD}) {
+		t.Fatalf("Unexpected reviews for the last commented upon commit of a submitted review: %v", revisions)
+	}
+	// The first parent of a merge is the commit it was merged into, rather than what was reviewed.
+	if revisions := findRevisions(repository.TestCommitH); revisions != nil {
+		t.Fatalf("Unexpected reviews for a merge into a review: %v", revisions)
+	}
+	if revisions := findRevisions(repository.TestCommitJ); revisions != nil {
+		t.Fatalf("Unexpected reviews for an unreviewed commit: %v", revisions)
+	}
+}
+
+func TestSubmitMessagePattern(t *testing.T) {
+	match := submitMessagePattern.FindStringSubmatch("Submitting review 0123456789ab\n\nDescription")
+	if match == nil || match[1] != "0123456789ab" {
+		t.Fatalf("Failed to find the submitted review: %q", match)
+	}
+	const revision = "0123456789abcdef0123456789abcdef01234567"
+	match = submitMessagePattern.FindStringSubmatch("Add a feature\n\nDetails.\n\nReview: " + revision + "\n\nSigned-off-by: A <a@example.com>")
+	if match == nil || match[2] != revision {
+		t.Fatalf("Failed to find the squashed review: %q", match)
+	}
+	for _, message := range []string{"Fix the submitting review code", "Review: address the comments"} {
+		if match := submitMessagePattern.FindStringSubmatch(message); match != nil {
+			t.Fatalf("Unexpectedly found a submitted review in %q: %q", message, match)
+		}
+	}
 }
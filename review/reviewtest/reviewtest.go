@@ -0,0 +1,117 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package reviewtest provides helpers for writing the reviews of tests declaratively.
+//
+// The helpers write the same notes as the "request" and "comment" commands, so they work
+// with any repository.Repo, but they are typically used with repository.NewRepoWithHistory:
+//
+//	repo := repository.NewRepoWithHistory(map[string][]string{
+//		"master":  {"A"},
+//		"feature": {"A", "B", "C"},
+//	})
+//	revision, err := reviewtest.AddReview(repo, "refs/heads/feature", "refs/heads/master")
+//	...
+//	_, err = reviewtest.AddComment(repo, revision, comment.Comment{Description: "LGTM", Resolved: &accepted})
+package reviewtest
+
+import (
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/request"
+	"strconv"
+	"time"
+)
+
+// AddReview requests a review of the given ref against the given target ref, by the user
+// of the repo, as the "request" command does, and returns the revision of the review.
+//
+// The review is based on the current commit of the target ref, and its description is
+// the message of its first commit.
+func AddReview(repo repository.Repo, reviewRef, targetRef string, reviewers ...string) (string, error) {
+	userEmail, err := repo.GetUserEmail()
+	if err != nil {
+		return "", err
+	}
+	base, err := repo.GetCommitHash(targetRef)
+	if err != nil {
+		return "", err
+	}
+	commits, err := repo.ListCommitsBetween(base, reviewRef)
+	if err != nil {
+		return "", err
+	}
+	if len(commits) == 0 {
+		return "", fmt.Errorf("The ref %q has no commits that are not in %q", reviewRef, targetRef)
+	}
+	revision := commits[0]
+	description, err := repo.GetCommitMessage(revision)
+	if err != nil {
+		return "", err
+	}
+	r := request.New(userEmail, reviewers, reviewRef, targetRef, description)
+	r.BaseCommit = base
+	note, err := r.Write()
+	if err != nil {
+		return "", err
+	}
+	if err := repo.AppendNote(request.Ref, revision, note); err != nil {
+		return "", err
+	}
+	return revision, nil
+}
+
+// AddComment adds the given comment to the review of the given revision, and returns the
+// hash of the comment, for use as the parent of replies to it.
+//
+// The author defaults to the user of the repo, the timestamp to now, and the location to
+// the current head commit of the review.
+func AddComment(repo repository.Repo, revision string, c comment.Comment) (string, error) {
+	if c.Author == "" {
+		userEmail, err := repo.GetUserEmail()
+		if err != nil {
+			return "", err
+		}
+		c.Author = userEmail
+	}
+	if c.Timestamp == "" {
+		c.Timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+	}
+	if c.Location == nil {
+		r, err := review.Get(repo, revision)
+		if err != nil {
+			return "", err
+		}
+		if r == nil {
+			return "", fmt.Errorf("There is no review of %q", revision)
+		}
+		headCommit, err := r.GetHeadCommit()
+		if err != nil {
+			return "", err
+		}
+		c.Location = &comment.Location{Commit: headCommit}
+	}
+	note, err := c.Write()
+	if err != nil {
+		return "", err
+	}
+	if err := repo.AppendNote(comment.Ref, revision, note); err != nil {
+		return "", err
+	}
+	return c.Hash()
+}
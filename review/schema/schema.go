@@ -0,0 +1,150 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package schema contains helpers shared by the formats of the notes holding review
+// data, so that those formats can evolve without older versions of the tool losing data.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Extensions holds the fields of a note that this version of the tool does not
+// understand (for example, fields added by a newer version), encoded as a JSON object.
+//
+// This is a string rather than a map, so that the types holding it remain comparable.
+type Extensions string
+
+// jsonFieldNames returns the names of the JSON fields of the given struct.
+func jsonFieldNames(known interface{}) []string {
+	var names []string
+	t := reflect.TypeOf(known)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// Split returns the fields of the given JSON object that do not match any of the fields
+// of the given struct. As in encoding/json, the field names are matched case-insensitively.
+func Split(data []byte, known interface{}) (Extensions, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", err
+	}
+	knownNames := jsonFieldNames(known)
+	for name := range fields {
+		for _, knownName := range knownNames {
+			if strings.EqualFold(name, knownName) {
+				delete(fields, name)
+				break
+			}
+		}
+	}
+	if len(fields) == 0 {
+		return "", nil
+	}
+	extensions, err := json.Marshal(fields)
+	return Extensions(extensions), err
+}
+
+// AppendTo adds the extension fields to the given JSON object.
+//
+// The extension fields are added, in sorted order, after the existing fields. That way,
+// a note written by json.Marshal is reproduced byte-for-byte as long as its unknown fields
+// come after its known fields and are sorted.
+func (e Extensions) AppendTo(object []byte) ([]byte, error) {
+	if e == "" {
+		return object, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(e), &fields); err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	object = bytes.TrimSpace(object)
+	if len(object) < 2 || object[len(object)-1] != '}' {
+		return nil, fmt.Errorf("Unable to add extension fields to the non-object %q", object)
+	}
+	var buffer bytes.Buffer
+	buffer.Write(object[:len(object)-1])
+	empty := len(bytes.TrimSpace(object[1:len(object)-1])) == 0
+	for _, name := range names {
+		if !empty {
+			buffer.WriteByte(',')
+		}
+		empty = false
+		nameBytes, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(nameBytes)
+		buffer.WriteByte(':')
+		buffer.Write(fields[name])
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+// CheckWellFormed returns an error if the given line of a note is neither blank nor a JSON object.
+//
+// A well-formed line need not be valid for any particular kind of note, since notes refs
+// may hold notes written by other tools.
+func CheckWellFormed(line []byte) error {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil
+	}
+	var object map[string]json.RawMessage
+	return json.Unmarshal(line, &object)
+}
+
+var (
+	warnedMutex sync.Mutex
+	warned      = make(map[string]bool)
+)
+
+// WarnUnsupportedVersion prints a warning (once per kind of note) that some notes were
+// ignored because their format version is newer than the supported one.
+func WarnUnsupportedVersion(kind string, version, supportedVersion int) {
+	warnedMutex.Lock()
+	defer warnedMutex.Unlock()
+	if warned[kind] {
+		return
+	}
+	warned[kind] = true
+	fmt.Fprintf(os.Stderr, "Warning: ignoring %s notes with version %d, which is newer than the supported version %d; upgrade git-appraise to see them.\n",
+		kind, version, supportedVersion)
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+import (
+	"testing"
+)
+
+type testNote struct {
+	Timestamp string `json:"timestamp,omitempty"`
+	Ignored   string `json:"-"`
+	Untagged  string
+}
+
+func TestSplit(t *testing.T) {
+	extensions, err := Split([]byte(`{"TIMESTAMP": "1", "untagged": "x", "zeta": [1, 2], "alpha": {"b": true}}`), testNote{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extensions != `{"alpha":{"b":true},"zeta":[1,2]}` {
+		t.Fatalf("Unexpected extensions: %q", extensions)
+	}
+	if extensions, err := Split([]byte(`{"timestamp": "1"}`), testNote{}); err != nil || extensions != "" {
+		t.Fatalf("Unexpected extensions: %q, %v", extensions, err)
+	}
+}
+
+func TestAppendTo(t *testing.T) {
+	extensions := Extensions(`{"zeta":[1,2],"alpha":{"b":true}}`)
+	object, err := extensions.AppendTo([]byte(`{"timestamp":"1"}`))
+	if err != nil || string(object) != `{"timestamp":"1","alpha":{"b":true},"zeta":[1,2]}` {
+		t.Fatalf("Unexpected object: %q, %v", object, err)
+	}
+	object, err = extensions.AppendTo([]byte(`{}`))
+	if err != nil || string(object) != `{"alpha":{"b":true},"zeta":[1,2]}` {
+		t.Fatalf("Unexpected object: %q, %v", object, err)
+	}
+	if object, err := Extensions("").AppendTo([]byte(`{"timestamp":"1"}`)); err != nil || string(object) != `{"timestamp":"1"}` {
+		t.Fatalf("Unexpected object: %q, %v", object, err)
+	}
+}
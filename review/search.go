@@ -0,0 +1,132 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"regexp"
+	"sort"
+)
+
+// The fields of a review that a search can match.
+const (
+	SearchFieldDescription = "description"
+	SearchFieldComment     = "comment"
+	SearchFieldPath        = "path"
+	SearchFieldRequester   = "requester"
+	SearchFieldReviewer    = "reviewer"
+)
+
+// SearchQuery matches reviews that contain every one of its terms, ignoring case.
+type SearchQuery struct {
+	terms []*regexp.Regexp
+}
+
+// SearchMatch is a single field of a review that matched some of the terms of a query.
+type SearchMatch struct {
+	Field string `json:"field"`
+	// CommentHash is the hash of the comment that the field belongs to, if any.
+	CommentHash string `json:"commentHash,omitempty"`
+	Text        string `json:"text"`
+	// Ranges holds the start and end byte offsets within the text of each match, in order.
+	Ranges [][]int `json:"ranges"`
+}
+
+// SearchResult is a review that matched a query, along with the fields that matched.
+type SearchResult struct {
+	Review  Review        `json:"review"`
+	Matches []SearchMatch `json:"matches"`
+}
+
+// NewSearchQuery builds a query from the given terms, which are either regular expressions
+// or, if isRegex is false, plain strings.
+func NewSearchQuery(terms []string, isRegex bool) (*SearchQuery, error) {
+	var query SearchQuery
+	for _, term := range terms {
+		if !isRegex {
+			term = regexp.QuoteMeta(term)
+		}
+		compiled, err := regexp.Compile("(?i)" + term)
+		if err != nil {
+			return nil, err
+		}
+		query.terms = append(query.terms, compiled)
+	}
+	return &query, nil
+}
+
+// searchText holds a single field of a review, to be matched against the terms of a query.
+type searchText struct {
+	field       string
+	commentHash string
+	text        string
+}
+
+// collectCommentTexts appends the descriptions and paths of the comments in the given threads.
+func collectCommentTexts(texts []searchText, threads []CommentThread) []searchText {
+	for _, thread := range threads {
+		texts = append(texts, searchText{SearchFieldComment, thread.Hash, thread.Comment.Description})
+		if thread.Comment.Location != nil && thread.Comment.Location.Path != "" {
+			texts = append(texts, searchText{SearchFieldPath, thread.Hash, thread.Comment.Location.Path})
+		}
+		texts = collectCommentTexts(texts, thread.Children)
+	}
+	return texts
+}
+
+// Match returns the fields of the given review that match any of the terms of the query, or
+// nil unless every term matches at least one of the fields.
+func (query *SearchQuery) Match(r *Review) []SearchMatch {
+	texts := []searchText{
+		{SearchFieldDescription, "", r.Request.Description},
+		{SearchFieldRequester, "", r.Request.Requester},
+	}
+	for _, reviewer := range r.Request.Reviewers {
+		texts = append(texts, searchText{SearchFieldReviewer, "", reviewer})
+	}
+	texts = collectCommentTexts(texts, r.Comments)
+
+	matched := make([]bool, len(query.terms))
+	var matches []SearchMatch
+	for _, text := range texts {
+		var ranges [][]int
+		for i, term := range query.terms {
+			termRanges := term.FindAllStringIndex(text.text, -1)
+			if len(termRanges) > 0 {
+				matched[i] = true
+				ranges = append(ranges, termRanges...)
+			}
+		}
+		if len(ranges) == 0 {
+			continue
+		}
+		sort.Slice(ranges, func(i, j int) bool {
+			return ranges[i][0] < ranges[j][0]
+		})
+		matches = append(matches, SearchMatch{
+			Field:       text.field,
+			CommentHash: text.commentHash,
+			Text:        text.text,
+			Ranges:      ranges,
+		})
+	}
+	for _, termMatched := range matched {
+		if !termMatched {
+			return nil
+		}
+	}
+	return matches
+}
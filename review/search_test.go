@@ -0,0 +1,59 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/request"
+	"reflect"
+	"testing"
+)
+
+func TestSearchQuery(t *testing.T) {
+	r := &Review{
+		Request: request.Request{Description: "Add a retry loop", Requester: "alice@example.com", Reviewers: []string{"bob@example.com"}},
+		Comments: []CommentThread{{
+			Hash:    "abc",
+			Comment: comment.Comment{Description: "What about the backoff?", Location: &comment.Location{Path: "retry/backoff.go"}},
+			Children: []CommentThread{
+				{Hash: "def", Comment: comment.Comment{Description: "Done, with exponential BACKOFF"}},
+			},
+		}},
+	}
+	query, err := NewSearchQuery([]string{"retry", "backoff"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []SearchMatch{
+		{Field: SearchFieldDescription, Text: "Add a retry loop", Ranges: [][]int{{6, 11}}},
+		{Field: SearchFieldComment, CommentHash: "abc", Text: "What about the backoff?", Ranges: [][]int{{15, 22}}},
+		{Field: SearchFieldPath, CommentHash: "abc", Text: "retry/backoff.go", Ranges: [][]int{{0, 5}, {6, 13}}},
+		{Field: SearchFieldComment, CommentHash: "def", Text: "Done, with exponential BACKOFF", Ranges: [][]int{{23, 30}}},
+	}
+	if matches := query.Match(r); !reflect.DeepEqual(matches, expected) {
+		t.Errorf("Unexpected matches: got %+v, want %+v", matches, expected)
+	}
+	if query, err := NewSearchQuery([]string{"retry", "jitter"}, false); err != nil || query.Match(r) != nil {
+		t.Errorf("Unexpectedly matched a review that does not contain every term: %v", err)
+	}
+	if query, err := NewSearchQuery([]string{`bob@.*\.com`}, true); err != nil || len(query.Match(r)) != 1 {
+		t.Errorf("Failed to match a regular expression: %v", err)
+	}
+	if _, err := NewSearchQuery([]string{"retry("}, true); err == nil {
+		t.Error("Unexpectedly accepted a malformed regular expression")
+	}
+}
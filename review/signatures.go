@@ -0,0 +1,133 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/request"
+	"strings"
+)
+
+const (
+	// SignConfigKey is the git config key that, if true, signs every request and comment written.
+	SignConfigKey = "appraise.sign"
+	// RequireSignaturesConfigKey is the git config key that, if true, prevents submitting a
+	// review unless all of its accepting comments have good signatures.
+	RequireSignaturesConfigKey = "appraise.requireSignatures"
+)
+
+// Possible values for the status of a note's signature.
+const (
+	SignatureUnsigned = "unsigned"
+	SignatureBad      = "bad"
+	SignatureGood     = "good"
+)
+
+// NoteSignature describes the signature of a single request or comment in a review.
+type NoteSignature struct {
+	// Kind is either "request" or "comment".
+	Kind string `json:"kind"`
+	// ID identifies the note; this is the timestamp of a request, or the hash of a comment.
+	ID string `json:"id"`
+	// Author is the requester of a request, or the author of a comment.
+	Author string `json:"author"`
+	// Accepting is set for the comments that accept the review.
+	Accepting bool `json:"accepting,omitempty"`
+	// Status is one of SignatureUnsigned, SignatureBad, or SignatureGood.
+	Status string `json:"status"`
+	// Signer is the identity of whoever made a good signature.
+	Signer string `json:"signer,omitempty"`
+	// Error explains why a signature is bad.
+	Error string `json:"error,omitempty"`
+}
+
+// ShouldSign returns whether or not new requests and comments should be signed by default.
+func ShouldSign(repo repository.Repo) bool {
+	return repository.IsConfigTrue(repo, SignConfigKey)
+}
+
+// SignaturesRequired returns whether or not reviews may only be submitted if their
+// accepting comments have good signatures.
+func SignaturesRequired(repo repository.Repo) bool {
+	return repository.IsConfigTrue(repo, RequireSignaturesConfigKey)
+}
+
+// checkSignature fills in the status of the given note signature, using the given function to verify it.
+//
+// A signature is only good if it was made by the note's author, since otherwise anyone
+// with a signing key could sign a note in someone else's name.
+func checkSignature(noteSignature NoteSignature, signature string, verify func(repository.Repo) (string, error), repo repository.Repo) NoteSignature {
+	if signature == "" {
+		noteSignature.Status = SignatureUnsigned
+		return noteSignature
+	}
+	signer, err := verify(repo)
+	if err == nil && noteSignature.Author != "" && !strings.Contains(strings.ToLower(signer), strings.ToLower(noteSignature.Author)) {
+		err = fmt.Errorf("The signature was made by %q rather than the author.", signer)
+	}
+	if err != nil {
+		noteSignature.Status = SignatureBad
+		noteSignature.Error = strings.TrimSpace(err.Error())
+		return noteSignature
+	}
+	noteSignature.Status = SignatureGood
+	noteSignature.Signer = signer
+	return noteSignature
+}
+
+// verifyThreadSignatures appends the signatures of the comments in the given thread to the given list.
+func verifyThreadSignatures(repo repository.Repo, thread CommentThread, signatures []NoteSignature) []NoteSignature {
+	c := thread.Comment
+	signatures = append(signatures, checkSignature(NoteSignature{
+		Kind:      "comment",
+		ID:        thread.Hash,
+		Author:    c.Author,
+		Accepting: c.Resolved != nil && *c.Resolved,
+	}, c.Signature, c.VerifySignature, repo))
+	for _, child := range thread.Children {
+		signatures = verifyThreadSignatures(repo, child, signatures)
+	}
+	return signatures
+}
+
+// VerifySignatures verifies the signatures of every request and comment in the review.
+func (r *Review) VerifySignatures() []NoteSignature {
+	var signatures []NoteSignature
+	for _, req := range request.ParseAllValid(r.Repo.GetNotes(request.Ref, r.Revision)) {
+		signatures = append(signatures, checkSignature(NoteSignature{
+			Kind:   "request",
+			ID:     req.Timestamp,
+			Author: req.Requester,
+		}, req.Signature, req.VerifySignature, r.Repo))
+	}
+	for _, thread := range r.Comments {
+		signatures = verifyThreadSignatures(r.Repo, thread, signatures)
+	}
+	return signatures
+}
+
+// GetUnverifiedAcceptances returns the signatures of the review's accepting comments that are not good.
+func (r *Review) GetUnverifiedAcceptances() []NoteSignature {
+	var unverified []NoteSignature
+	for _, signature := range r.VerifySignatures() {
+		if signature.Accepting && signature.Status != SignatureGood {
+			unverified = append(unverified, signature)
+		}
+	}
+	return unverified
+}
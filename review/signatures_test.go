@@ -0,0 +1,79 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/comment"
+	"testing"
+)
+
+func TestVerifySignatures(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	r, err := Get(repo, repository.TestCommitG)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v, %v", r, err)
+	}
+	resolved := true
+	signed := comment.New("user@example.com", "Signed")
+	signed.Resolved = &resolved
+	if err := signed.Sign(repo); err != nil {
+		t.Fatal(err)
+	}
+	tampered := comment.New("user@example.com", "Tampered")
+	if err := tampered.Sign(repo); err != nil {
+		t.Fatal(err)
+	}
+	tampered.Description = "Changed after signing"
+	impersonated := comment.New("someone@example.com", "Impersonated")
+	impersonated.Resolved = &resolved
+	if err := impersonated.Sign(repo); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []comment.Comment{signed, tampered, impersonated} {
+		if err := r.AddComment(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r, err = Get(repo, repository.TestCommitG); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := make(map[string]string)
+	for _, signature := range r.VerifySignatures() {
+		statuses[signature.Author+":"+signature.Status] += signature.Kind + ","
+	}
+	// The review request and its existing comments are unsigned.
+	for key, expected := range map[string]string{
+		"user@example.com:good":   "comment,",
+		"user@example.com:bad":    "comment,",
+		"someone@example.com:bad": "comment,",
+	} {
+		if statuses[key] != expected {
+			t.Fatalf("Unexpected signature statuses: %v", statuses)
+		}
+	}
+	unverified := r.GetUnverifiedAcceptances()
+	if len(unverified) == 0 {
+		t.Fatal("Failed to find the unverified accepting comments")
+	}
+	for _, signature := range unverified {
+		if signature.Author == "user@example.com" {
+			t.Fatalf("The validly signed acceptance was reported as unverified: %+v", signature)
+		}
+	}
+}
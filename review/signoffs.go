@@ -0,0 +1,164 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RequiredApprovalsConfigKey is the config key holding the number of reviewers who have to
+// accept a review before it can be submitted.
+const RequiredApprovalsConfigKey = "appraise.requiredApprovals"
+
+// RejectionCategoriesConfigKey is the config key holding the categories (separated by commas
+// or spaces) that rejections may be tagged with. If it is not set, then any category with a
+// valid name may be used.
+const RejectionCategoriesConfigKey = "appraise.rejectionCategories"
+
+// Possible values for the status of a sign-off.
+const (
+	SignOffAccepted = "accepted"
+	SignOffRejected = "rejected"
+	SignOffPending  = "pending"
+)
+
+// SignOff summarizes where a single reviewer stands on a review.
+type SignOff struct {
+	Reviewer string `json:"reviewer"`
+	// Status is one of SignOffAccepted, SignOffRejected, or SignOffPending.
+	Status string `json:"status"`
+	// Timestamp is the time of the reviewer's latest vote, unless the sign-off is pending.
+	Timestamp string `json:"timestamp,omitempty"`
+	// Conditional is set if the reviewer's latest vote is a conditional acceptance.
+	Conditional bool `json:"conditional,omitempty"`
+	// Category is the reason given for the reviewer's latest vote, if it is a rejection that
+	// was tagged with one.
+	Category string `json:"category,omitempty"`
+}
+
+// collectVotes records the latest vote of each author in the given comment threads.
+func collectVotes(threads []CommentThread, votes map[string]SignOff) {
+	for _, thread := range threads {
+		c := thread.Comment
+		if c.Resolved != nil {
+			if vote, ok := votes[c.Author]; !ok || vote.Timestamp <= c.Timestamp {
+				status, category := SignOffRejected, c.Category
+				if *c.Resolved {
+					status, category = SignOffAccepted, ""
+				}
+				votes[c.Author] = SignOff{
+					Reviewer:    c.Author,
+					Status:      status,
+					Timestamp:   c.Timestamp,
+					Conditional: *c.Resolved && c.Conditional,
+					Category:    category,
+				}
+			}
+		}
+		collectVotes(thread.Children, votes)
+	}
+}
+
+// GetSignOffs returns the latest vote of everyone who accepted or rejected the review,
+// along with a pending sign-off for each requested reviewer who has not yet voted.
+//
+// The requested reviewers are listed first, in the order they were requested, followed
+// by anyone else who voted, sorted by email address.
+func (r *Review) GetSignOffs() []SignOff {
+	votes := make(map[string]SignOff)
+	collectVotes(r.Comments, votes)
+	var signOffs []SignOff
+	listed := make(map[string]bool)
+	for _, reviewer := range r.Request.Reviewers {
+		if reviewer == "" || listed[reviewer] {
+			continue
+		}
+		listed[reviewer] = true
+		if vote, ok := votes[reviewer]; ok {
+			signOffs = append(signOffs, vote)
+		} else {
+			signOffs = append(signOffs, SignOff{Reviewer: reviewer, Status: SignOffPending})
+		}
+	}
+	var others []string
+	for reviewer := range votes {
+		if !listed[reviewer] {
+			others = append(others, reviewer)
+		}
+	}
+	sort.Strings(others)
+	for _, reviewer := range others {
+		signOffs = append(signOffs, votes[reviewer])
+	}
+	return signOffs
+}
+
+// RequiredApprovals returns the number of reviewers who have to accept a review before it
+// can be submitted, which is zero if there is no such requirement.
+func RequiredApprovals(repo repository.Repo) int {
+	required, err := strconv.Atoi(repository.GetConfigValue(repo, RequiredApprovalsConfigKey, "0"))
+	if err != nil || required < 0 {
+		return 0
+	}
+	return required
+}
+
+// CountApprovals returns the number of reviewers whose latest vote accepts the review.
+func (r *Review) CountApprovals() int {
+	approvals := 0
+	for _, signOff := range r.GetSignOffs() {
+		if signOff.Status == SignOffAccepted {
+			approvals++
+		}
+	}
+	return approvals
+}
+
+// IsRejected returns whether or not the latest vote of any reviewer rejects the review.
+//
+// If a category is given, then only the rejections tagged with that category count.
+func (r *Review) IsRejected(category string) bool {
+	for _, signOff := range r.GetSignOffs() {
+		if signOff.Status == SignOffRejected && (category == "" || signOff.Category == category) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateRejectionCategory checks that the given rejection category is named like a label,
+// and that it is one of the categories allowed by the RejectionCategoriesConfigKey setting,
+// if that is set.
+func ValidateRejectionCategory(repo repository.Repo, category string) error {
+	if !labelNamePattern.MatchString(category) {
+		return fmt.Errorf("Invalid category %q; categories are made up of letters, digits, \".\", \"-\", and \"_\", and start with a letter or digit.", category)
+	}
+	allowed, err := listAllowed(repo, RejectionCategoriesConfigKey)
+	if err != nil || len(allowed) == 0 {
+		return err
+	}
+	for _, allowedCategory := range allowed {
+		if category == allowedCategory {
+			return nil
+		}
+	}
+	return fmt.Errorf("The category %q is not allowed by the %s setting; expected one of: %s", category, RejectionCategoriesConfigKey, strings.Join(allowed, ", "))
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/comment"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ViewedRef defines the git-notes ref recording which of the files changed by each review
+// each reviewer has marked as viewed.
+const ViewedRef = "refs/notes/devtools/viewed"
+
+// The statuses of a file changed by a review, from the point of view of a single reviewer.
+const (
+	FileNotViewed          = "not viewed"
+	FileViewed             = "viewed"
+	FileChangedSinceViewed = "changed since viewed"
+)
+
+// viewedNote is the format of the notes in ViewedRef, each of which records that a reviewer
+// marked a file as viewed (or no longer viewed) when the review's head was at a given commit.
+//
+// Since notes are merged by concatenating them, the latest note for each reviewer and file
+// is the one that counts.
+type viewedNote struct {
+	Timestamp string `json:"timestamp"`
+	Reviewer  string `json:"reviewer"`
+	Path      string `json:"path"`
+	Commit    string `json:"commit"`
+	Viewed    bool   `json:"viewed"`
+}
+
+// ChangedFile is a single file changed by a review, along with whether a reviewer has viewed it.
+type ChangedFile struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+}
+
+// listChangedPaths returns the paths of the files that differ between the two given commits.
+func listChangedPaths(repo repository.Repo, from, to string) ([]string, error) {
+	out, err := repo.Diff(from, to, "-z", "--name-only", "--no-renames")
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, path := range strings.Split(out, "\x00") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// changedPathsAndHead returns the paths of the files changed by the review, and its head commit.
+func (r *Review) changedPathsAndHead() ([]string, string, error) {
+	baseCommit, err := r.GetBaseCommit()
+	if err != nil {
+		return nil, "", err
+	}
+	headCommit, err := r.GetHeadCommit()
+	if err != nil {
+		return nil, "", err
+	}
+	paths, err := listChangedPaths(r.Repo, baseCommit, headCommit)
+	return paths, headCommit, err
+}
+
+// parseViewed returns the latest of the given notes made by the given reviewer, keyed by path.
+func parseViewed(notes []repository.Note, reviewer string) map[string]viewedNote {
+	latest := make(map[string]viewedNote)
+	latestTimestamps := make(map[string]int64)
+	for _, note := range notes {
+		var parsed viewedNote
+		if err := json.Unmarshal([]byte(note), &parsed); err != nil || parsed.Reviewer != reviewer || parsed.Path == "" {
+			continue
+		}
+		timestamp, err := strconv.ParseInt(parsed.Timestamp, 10, 64)
+		if err != nil {
+			continue
+		}
+		if previous, ok := latestTimestamps[parsed.Path]; !ok || timestamp >= previous {
+			latest[parsed.Path], latestTimestamps[parsed.Path] = parsed, timestamp
+		}
+	}
+	return latest
+}
+
+// SetViewed records that the given reviewer has viewed (or, if viewed is false, no longer
+// considers viewed) the given files, as of the current head commit of the review.
+//
+// Files can only be marked as viewed if the review changes them.
+func (r *Review) SetViewed(reviewer string, paths []string, viewed bool) error {
+	changedPaths, headCommit, err := r.changedPathsAndHead()
+	if err != nil {
+		return err
+	}
+	changed := make(map[string]bool)
+	for _, path := range changedPaths {
+		changed[path] = true
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	var notes []viewedNote
+	for _, path := range paths {
+		path = comment.NormalizePath(path)
+		if viewed && !changed[path] {
+			return fmt.Errorf("The file %q is not changed by review %.12s.", path, r.Revision)
+		}
+		notes = append(notes, viewedNote{timestamp, reviewer, path, headCommit, viewed})
+	}
+	for _, note := range notes {
+		noteBytes, err := json.Marshal(note)
+		if err != nil {
+			return err
+		}
+		if err := r.Repo.AppendNote(ViewedRef, r.Revision, repository.Note(noteBytes)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetChangedFiles returns the files changed by the review, in the order git lists them,
+// along with whether the given reviewer has viewed each one.
+//
+// A file that the reviewer viewed is reported as changed since viewed if a later revision
+// of the review has changed it again.
+func (r *Review) GetChangedFiles(reviewer string) ([]ChangedFile, error) {
+	paths, headCommit, err := r.changedPathsAndHead()
+	if err != nil {
+		return nil, err
+	}
+	viewed := parseViewed(r.Repo.GetNotes(ViewedRef, r.Revision), reviewer)
+	// changedSince caches the files changed between each viewed commit and the head commit.
+	changedSince := make(map[string]map[string]bool)
+	var files []ChangedFile
+	for _, path := range paths {
+		note, ok := viewed[path]
+		if !ok || !note.Viewed {
+			files = append(files, ChangedFile{path, FileNotViewed})
+			continue
+		}
+		if note.Commit == headCommit {
+			files = append(files, ChangedFile{path, FileViewed})
+			continue
+		}
+		if _, ok := changedSince[note.Commit]; !ok {
+			changed := make(map[string]bool)
+			laterPaths, err := listChangedPaths(r.Repo, note.Commit, headCommit)
+			if err != nil {
+				// The commit that was viewed may have been rewritten away.
+				for _, path := range paths {
+					changed[path] = true
+				}
+			}
+			for _, laterPath := range laterPaths {
+				changed[laterPath] = true
+			}
+			changedSince[note.Commit] = changed
+		}
+		if changedSince[note.Commit][path] {
+			files = append(files, ChangedFile{path, FileChangedSinceViewed})
+		} else {
+			files = append(files, ChangedFile{path, FileViewed})
+		}
+	}
+	return files, nil
+}
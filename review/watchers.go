@@ -0,0 +1,102 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"encoding/json"
+	"github.com/google/git-appraise/repository"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// WatchersRef defines the git-notes ref recording who is watching each review, separately
+// from its requester and reviewers.
+const WatchersRef = "refs/notes/devtools/watchers"
+
+// watchNote is the format of the notes in WatchersRef, each of which records that someone
+// started or stopped watching a review.
+//
+// Since notes are merged by concatenating them, everyone's latest note is the one that counts.
+type watchNote struct {
+	Timestamp string `json:"timestamp"`
+	Watcher   string `json:"watcher"`
+	Watching  bool   `json:"watching"`
+}
+
+// parseWatchers returns the sorted list of everyone whose latest note in the given notes is
+// a request to watch.
+func parseWatchers(notes []repository.Note) []string {
+	latest := make(map[string]watchNote)
+	latestTimestamps := make(map[string]int64)
+	for _, note := range notes {
+		var parsed watchNote
+		if err := json.Unmarshal([]byte(note), &parsed); err != nil || parsed.Watcher == "" {
+			continue
+		}
+		timestamp, err := strconv.ParseInt(parsed.Timestamp, 10, 64)
+		if err != nil {
+			continue
+		}
+		if previous, ok := latestTimestamps[parsed.Watcher]; !ok || timestamp >= previous {
+			latest[parsed.Watcher], latestTimestamps[parsed.Watcher] = parsed, timestamp
+		}
+	}
+	var watchers []string
+	for watcher, note := range latest {
+		if note.Watching {
+			watchers = append(watchers, watcher)
+		}
+	}
+	sort.Strings(watchers)
+	return watchers
+}
+
+// GetWatchers returns the sorted list of everyone watching the review.
+func (r *Review) GetWatchers() []string {
+	return parseWatchers(r.Repo.GetNotes(WatchersRef, r.Revision))
+}
+
+// SetWatching records that the given watcher has started, or stopped, watching the review.
+func (r *Review) SetWatching(watcher string, watching bool) error {
+	note, err := json.Marshal(watchNote{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Watcher:   watcher,
+		Watching:  watching,
+	})
+	if err != nil {
+		return err
+	}
+	return r.Repo.AppendNote(WatchersRef, r.Revision, repository.Note(note))
+}
+
+// ListWatched returns the revisions of the reviews that the given watcher is watching.
+func ListWatched(repo repository.Repo, watcher string) (map[string]bool, error) {
+	notes, err := repo.GetAllNotes(WatchersRef)
+	if err != nil {
+		return nil, err
+	}
+	watched := make(map[string]bool)
+	for revision, revisionNotes := range notes {
+		for _, w := range parseWatchers(revisionNotes) {
+			if w == watcher {
+				watched[revision] = true
+			}
+		}
+	}
+	return watched, nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"github.com/google/git-appraise/repository"
+	"reflect"
+	"testing"
+)
+
+func TestParseWatchers(t *testing.T) {
+	notes := []repository.Note{
+		repository.Note(`{"timestamp": "0000000003", "watcher": "alice@example.com", "watching": false}`),
+		repository.Note(`{"timestamp": "0000000001", "watcher": "alice@example.com", "watching": true}`),
+		repository.Note(`{"timestamp": "0000000002", "watcher": "bob@example.com", "watching": true}`),
+		repository.Note(`{"timestamp": "0000000004", "watcher": "carol@example.com", "watching": true}`),
+		repository.Note(`not json`),
+		repository.Note(`{"timestamp": "0000000005", "watching": true}`),
+	}
+	// Since merged notes are reordered, the latest note for each watcher wins, wherever it is.
+	expected := []string{"bob@example.com", "carol@example.com"}
+	if watchers := parseWatchers(notes); !reflect.DeepEqual(watchers, expected) {
+		t.Fatalf("Unexpected watchers: got %v, want %v", watchers, expected)
+	}
+}
+
+func TestSetWatching(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	r, err := Get(repo, repository.TestCommitB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetWatching("alice@example.com", true); err != nil {
+		t.Fatal(err)
+	}
+	if watchers := r.GetWatchers(); !reflect.DeepEqual(watchers, []string{"alice@example.com"}) {
+		t.Fatalf("Unexpected watchers: %v", watchers)
+	}
+	watched, err := ListWatched(repo, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(watched, map[string]bool{repository.TestCommitB: true}) {
+		t.Fatalf("Unexpected watched reviews: %v", watched)
+	}
+	if watched, err := ListWatched(repo, "bob@example.com"); err != nil || len(watched) != 0 {
+		t.Fatalf("Unexpected watched reviews for someone else: %v, %v", watched, err)
+	}
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// APIPath is the path prefix of the JSON API that exposes the read-only methods of the
+// served repository. Each method is called by POSTing a JSON array of its (string) arguments
+// to the path of the API followed by the name of the method, such as "/api/repo/GetNotes".
+const APIPath = "/api/repo/"
+
+// maxAPIRequestSize is the largest request body that the API accepts.
+const maxAPIRequestSize = 16 << 20
+
+// sharedConfigKeyPrefix is the prefix of the only config keys that the API reveals, so that
+// the server does not leak settings such as credentials.
+const sharedConfigKeyPrefix = "appraise."
+
+// safeDiffOptions are the options that clients may pass to the Diff method. Anything else is
+// rejected, since git diff can also be told to do things such as write to files on the server.
+var safeDiffOptions = map[string]bool{
+	"-z":                    true,
+	"-M":                    true,
+	"-U":                    true,
+	"-w":                    true,
+	"-b":                    true,
+	"-W":                    true,
+	"--unified":             true,
+	"--name-only":           true,
+	"--name-status":         true,
+	"--no-renames":          true,
+	"--find-renames":        true,
+	"--diff-filter":         true,
+	"--submodule":           true,
+	"--stat":                true,
+	"--numstat":             true,
+	"--shortstat":           true,
+	"--ignore-all-space":    true,
+	"--ignore-space-change": true,
+	"--ignore-blank-lines":  true,
+	"--function-context":    true,
+	"--word-diff":           true,
+	"--patience":            true,
+	"--histogram":           true,
+	"--minimal":             true,
+}
+
+// checkDiffOptions returns an error unless all of the given diff options are safe to run on
+// the server.
+func checkDiffOptions(options []string) error {
+	for _, option := range options {
+		name := option
+		if i := strings.Index(name, "="); i >= 0 {
+			name = name[:i]
+		} else if len(name) > 2 && name[0] == '-' && name[1] != '-' {
+			// Short options such as -U5 or -M50% carry their value.
+			name = name[:2]
+		}
+		if !safeDiffOptions[name] {
+			return fmt.Errorf("The diff option %q is not allowed", option)
+		}
+	}
+	return nil
+}
+
+// checkArguments returns an error if any of the given arguments, which are revisions, paths,
+// refs, or config keys, starts with "-", so that none of them is taken as an option by git.
+func checkArguments(args []string) error {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("The argument %q is not allowed, since it looks like an option", arg)
+		}
+	}
+	return nil
+}
+
+func isSharedConfigKey(key string) bool {
+	return strings.HasPrefix(strings.ToLower(key), sharedConfigKeyPrefix)
+}
+
+// apiMethod serves one of the read-only methods of repository.Repo.
+type apiMethod struct {
+	// args is the number of arguments that the method takes, and variadic is whether or not
+	// it also takes any number of additional ones.
+	args     int
+	variadic bool
+	call     func(repo repository.Repo, args []string) (interface{}, error)
+}
+
+// apiMethods holds the methods of repository.Repo that can be called through the API, which
+// are just those that read from the repository.
+var apiMethods = map[string]apiMethod{
+	"IsShallow": {0, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.IsShallow()
+	}},
+	"GetRepoStateHash": {0, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.GetRepoStateHash()
+	}},
+	"GetConfigValues": {1, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		if !isSharedConfigKey(args[0]) {
+			return []string{}, nil
+		}
+		return repo.GetConfigValues(args[0])
+	}},
+	"GetConfigValuesWithOrigin": {1, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		if !isSharedConfigKey(args[0]) {
+			return []repository.ConfigValue{}, nil
+		}
+		return repo.GetConfigValuesWithOrigin(args[0])
+	}},
+	"VerifyCommit": {1, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return nil, repo.VerifyCommit(args[0])
+	}},
+	"VerifyGitRef": {1, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return nil, repo.VerifyGitRef(args[0])
+	}},
+	"GetCommitHash": {1, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.GetCommitHash(args[0])
+	}},
+	"ResolveRefCommit": {1, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.ResolveRefCommit(args[0])
+	}},
+	"GetCommitMessage": {1, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.GetCommitMessage(args[0])
+	}},
+	"GetCommitTime": {1, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.GetCommitTime(args[0])
+	}},
+	"GetLastParent": {1, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.GetLastParent(args[0])
+	}},
+	"GetCommitDetails": {1, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.GetCommitDetails(args[0])
+	}},
+	"MergeBase": {2, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.MergeBase(args[0], args[1])
+	}},
+	"IsAncestor": {2, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.IsAncestor(args[0], args[1])
+	}},
+	// The descendant comes first, followed by the commits that might be its ancestors.
+	"FindAncestors": {1, true, func(repo repository.Repo, args []string) (interface{}, error) {
+		if err := checkArguments(args[1:]); err != nil {
+			return nil, err
+		}
+		return repo.FindAncestors(args[1:], args[0])
+	}},
+	"Diff": {2, true, func(repo repository.Repo, args []string) (interface{}, error) {
+		if err := checkDiffOptions(args[2:]); err != nil {
+			return nil, err
+		}
+		return repo.Diff(args[0], args[1], args[2:]...)
+	}},
+	"Show": {2, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.Show(args[0], args[1])
+	}},
+	"IsSubmodule": {2, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.IsSubmodule(args[0], args[1])
+	}},
+	"ListCommitsBetween": {2, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.ListCommitsBetween(args[0], args[1])
+	}},
+	"ListCommits": {2, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.ListCommits(args[0], args[1])
+	}},
+	"GetNotes": {2, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.GetNotes(args[0], args[1]), nil
+	}},
+	"GetAllNotes": {1, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.GetAllNotes(args[0])
+	}},
+	"ListNotedRevisions": {1, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.ListNotedRevisions(args[0]), nil
+	}},
+	"ListNotesRefs": {1, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.ListNotesRefs(args[0])
+	}},
+	"ListRefs": {1, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.ListRefs(args[0])
+	}},
+	"ListNotes": {1, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.ListNotes(args[0])
+	}},
+	"GetNotesTip": {1, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.GetNotesTip(args[0])
+	}},
+	"ListChangedNotes": {2, false, func(repo repository.Repo, args []string) (interface{}, error) {
+		return repo.ListChangedNotes(args[0], args[1])
+	}},
+}
+
+// serveAPI calls the read-only repository method named by the request path, and responds
+// with its result as JSON. Errors are returned as plain text.
+func (s *Server) serveAPI(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "The API only accepts POST requests", http.StatusMethodNotAllowed)
+		return
+	}
+	// Browsers send cross-site POSTs without asking first only for a few content types, which
+	// do not include JSON, so requiring it keeps other web pages from calling the API.
+	if mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		http.Error(w, "The API only accepts application/json requests", http.StatusUnsupportedMediaType)
+		return
+	}
+	name := strings.TrimPrefix(req.URL.Path, APIPath)
+	method, ok := apiMethods[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown or unsupported method %q", name), http.StatusNotFound)
+		return
+	}
+	var args []string
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxAPIRequestSize)).Decode(&args); err != nil {
+		http.Error(w, fmt.Sprintf("Malformed arguments: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(args) < method.args || (len(args) > method.args && !method.variadic) {
+		http.Error(w, fmt.Sprintf("The %s method takes %d arguments, not %d", name, method.args, len(args)), http.StatusBadRequest)
+		return
+	}
+	// Any additional arguments are checked by the method itself, since they may be options.
+	if err := checkArguments(args[:method.args]); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result, err := method.call(s.repo.WithContext(req.Context()), args)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,450 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/git-appraise/repository"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// remoteRepo is a read-only repository.Repo that reads the review data from the API of a
+// git-appraise server, rather than from a local git repo.
+//
+// Everything that would modify the repo returns a repository.ReadOnlyError, and since the
+// remote repo has no working tree, it also has no current ref or uncommitted changes.
+type remoteRepo struct {
+	url    string
+	client *http.Client
+	ctx    context.Context
+}
+
+// NewRemoteRepo returns a read-only repository that reads from the git-appraise server
+// (started with "git appraise serve") at the given URL.
+func NewRemoteRepo(serverURL string) (repository.Repo, error) {
+	parsed, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid server URL %q: %v", serverURL, err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid server URL %q: it must start with http:// or https://", serverURL)
+	}
+	return &remoteRepo{
+		url:    strings.TrimSuffix(serverURL, "/"),
+		client: http.DefaultClient,
+	}, nil
+}
+
+// call calls the named method through the server's API, and decodes its result into the given value.
+func (repo *remoteRepo) call(method string, result interface{}, args ...string) error {
+	if args == nil {
+		args = []string{}
+	}
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(repo.Context(), http.MethodPost, repo.url+APIPath+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := repo.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("The server at %s failed to run %s: %s", repo.url, method, strings.TrimSpace(string(message)))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (repo *remoteRepo) callString(method string, args ...string) (string, error) {
+	var result string
+	err := repo.call(method, &result, args...)
+	return result, err
+}
+
+func (repo *remoteRepo) callStrings(method string, args ...string) ([]string, error) {
+	var result []string
+	err := repo.call(method, &result, args...)
+	return result, err
+}
+
+func (repo *remoteRepo) callBool(method string, args ...string) (bool, error) {
+	var result bool
+	err := repo.call(method, &result, args...)
+	return result, err
+}
+
+// GetPath returns the URL of the server.
+func (repo *remoteRepo) GetPath() string {
+	return repo.url
+}
+
+// Context returns the context that stops the repo's requests once it is done.
+func (repo *remoteRepo) Context() context.Context {
+	if repo.ctx == nil {
+		return context.Background()
+	}
+	return repo.ctx
+}
+
+// WithContext returns a copy of the repo whose requests are stopped once the given context is done.
+func (repo *remoteRepo) WithContext(ctx context.Context) repository.Repo {
+	copied := *repo
+	copied.ctx = ctx
+	return &copied
+}
+
+// IsBare returns true, as the remote repo has no working tree.
+func (repo *remoteRepo) IsBare() (bool, error) {
+	return true, nil
+}
+
+// IsShallow returns whether or not the remote repository is a shallow clone.
+func (repo *remoteRepo) IsShallow() (bool, error) {
+	return repo.callBool("IsShallow")
+}
+
+// GetGitDir returns an error, as there is no local git directory. Among other things,
+// this means that the parsed reviews are not cached.
+func (repo *remoteRepo) GetGitDir() (string, error) {
+	return "", errors.New("A remote repository has no local git directory")
+}
+
+// GetRepoStateHash returns a hash which embodies the entire current state of the remote repository.
+func (repo *remoteRepo) GetRepoStateHash() (string, error) {
+	return repo.callString("GetRepoStateHash")
+}
+
+// GetUserEmail returns an error, as the user is not known to the remote repository.
+func (repo *remoteRepo) GetUserEmail() (string, error) {
+	return "", errors.New("The user's identity is not known to a remote repository")
+}
+
+// GetConfigValues returns the values of the given config key in the remote repository.
+//
+// The server only reveals the keys of the git-appraise settings.
+func (repo *remoteRepo) GetConfigValues(key string) ([]string, error) {
+	return repo.callStrings("GetConfigValues", key)
+}
+
+// GetConfigValuesWithOrigin returns the values of the given config key in the remote
+// repository, along with the scope of the git config that each was set in.
+func (repo *remoteRepo) GetConfigValuesWithOrigin(key string) ([]repository.ConfigValue, error) {
+	var values []repository.ConfigValue
+	err := repo.call("GetConfigValuesWithOrigin", &values, key)
+	return values, err
+}
+
+func (repo *remoteRepo) SetConfigValue(key, value string) error {
+	return repository.ReadOnlyError{Operation: "set " + key}
+}
+
+func (repo *remoteRepo) SignPayload(payload []byte) (string, error) {
+	return "", repository.ReadOnlyError{Operation: "sign a note"}
+}
+
+func (repo *remoteRepo) VerifySignature(payload []byte, signature string) (string, error) {
+	return "", errors.New("Signatures cannot be verified against a remote repository")
+}
+
+// ListRemotes returns no remotes, as those of the remote repository are of no use to its clients.
+func (repo *remoteRepo) ListRemotes() ([]string, error) {
+	return nil, nil
+}
+
+// HasUncommittedChanges returns false, as the remote repo has no working tree.
+func (repo *remoteRepo) HasUncommittedChanges() (bool, error) {
+	return false, nil
+}
+
+func (repo *remoteRepo) StashChanges(message string) (string, error) {
+	return "", repository.ReadOnlyError{Operation: "stash changes"}
+}
+
+func (repo *remoteRepo) RestoreStash(stash string) error {
+	return repository.ReadOnlyError{Operation: "restore stashed changes"}
+}
+
+func (repo *remoteRepo) SnapshotChanges(ref, message string, staged bool) (string, error) {
+	return "", repository.ReadOnlyError{Operation: "snapshot changes"}
+}
+
+func (repo *remoteRepo) DeleteRef(ref string) error {
+	return repository.ReadOnlyError{Operation: "delete " + ref}
+}
+
+func (repo *remoteRepo) UpdateRef(ref, newValue, oldValue string) error {
+	return repository.ReadOnlyError{Operation: "update " + ref}
+}
+
+func (repo *remoteRepo) DeleteRemoteRef(remote, ref string) error {
+	return repository.ReadOnlyError{Operation: "delete " + ref + " from " + remote}
+}
+
+// VerifyCommit verifies that the supplied hash points to a known commit.
+func (repo *remoteRepo) VerifyCommit(hash string) error {
+	return repo.call("VerifyCommit", nil, hash)
+}
+
+// VerifyGitRef verifies that the supplied ref points to a known commit.
+func (repo *remoteRepo) VerifyGitRef(ref string) error {
+	return repo.call("VerifyGitRef", nil, ref)
+}
+
+// GetHeadRef returns an error, as the remote repo has no working tree, and so no current ref.
+func (repo *remoteRepo) GetHeadRef() (string, error) {
+	return "", errors.New("A remote repository has no current ref")
+}
+
+// GetCommitHash returns the hash of the commit pointed to by the given ref.
+func (repo *remoteRepo) GetCommitHash(ref string) (string, error) {
+	return repo.callString("GetCommitHash", ref)
+}
+
+// ResolveRefCommit returns the commit pointed to by the given ref, which may be a remote ref.
+func (repo *remoteRepo) ResolveRefCommit(ref string) (string, error) {
+	return repo.callString("ResolveRefCommit", ref)
+}
+
+// GetCommitMessage returns the message stored in the commit pointed to by the given ref.
+func (repo *remoteRepo) GetCommitMessage(ref string) (string, error) {
+	return repo.callString("GetCommitMessage", ref)
+}
+
+// GetCommitTime returns the commit time of the commit pointed to by the given ref.
+func (repo *remoteRepo) GetCommitTime(ref string) (string, error) {
+	return repo.callString("GetCommitTime", ref)
+}
+
+// GetLastParent returns the last parent of the given commit (as ordered by git).
+func (repo *remoteRepo) GetLastParent(ref string) (string, error) {
+	return repo.callString("GetLastParent", ref)
+}
+
+// GetCommitDetails returns the details of a commit's metadata.
+func (repo *remoteRepo) GetCommitDetails(ref string) (*repository.CommitDetails, error) {
+	var details repository.CommitDetails
+	if err := repo.call("GetCommitDetails", &details, ref); err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+// MergeBase determines if the first commit that is an ancestor of the two arguments.
+func (repo *remoteRepo) MergeBase(a, b string) (string, error) {
+	return repo.callString("MergeBase", a, b)
+}
+
+// IsAncestor determines if the first argument points to a commit that is an ancestor of the second.
+func (repo *remoteRepo) IsAncestor(ancestor, descendant string) (bool, error) {
+	return repo.callBool("IsAncestor", ancestor, descendant)
+}
+
+// FindAncestors determines which of the given commits are ancestors of the given descendant.
+func (repo *remoteRepo) FindAncestors(commits []string, descendant string) (map[string]bool, error) {
+	var ancestors map[string]bool
+	err := repo.call("FindAncestors", &ancestors, append([]string{descendant}, commits...)...)
+	return ancestors, err
+}
+
+// Diff computes the diff between two given commits.
+//
+// The server refuses any diff arguments that it does not know to be safe.
+func (repo *remoteRepo) Diff(left, right string, diffArgs ...string) (string, error) {
+	return repo.callString("Diff", append([]string{left, right}, diffArgs...)...)
+}
+
+// Show returns the contents of the given file at the given commit.
+func (repo *remoteRepo) Show(commit, path string) (string, error) {
+	return repo.callString("Show", commit, path)
+}
+
+// IsSubmodule returns whether or not the given path is a submodule at the given commit.
+func (repo *remoteRepo) IsSubmodule(commit, path string) (bool, error) {
+	return repo.callBool("IsSubmodule", commit, path)
+}
+
+func (repo *remoteRepo) SwitchToRef(ref string) error {
+	return repository.ReadOnlyError{Operation: "check out " + ref}
+}
+
+func (repo *remoteRepo) MergeRef(ref string, fastForward, sign bool, messages ...string) error {
+	return repository.ReadOnlyError{Operation: "merge " + ref}
+}
+
+func (repo *remoteRepo) RebaseRef(ref string, sign bool) error {
+	return repository.ReadOnlyError{Operation: "rebase " + ref}
+}
+
+func (repo *remoteRepo) SquashRef(ref string, sign bool, messages ...string) error {
+	return repository.ReadOnlyError{Operation: "squash " + ref}
+}
+
+func (repo *remoteRepo) CommitMerge(sign bool, messages ...string) error {
+	return repository.ReadOnlyError{Operation: "commit a merge"}
+}
+
+func (repo *remoteRepo) AbortMerge() error {
+	return repository.ReadOnlyError{Operation: "abort a merge"}
+}
+
+// MergeInProgress returns that there is no merge in progress, as the remote repo has no working tree.
+func (repo *remoteRepo) MergeInProgress() (string, []string, error) {
+	return "", nil, nil
+}
+
+// ListCommitsBetween returns the list of commits between the two given revisions.
+func (repo *remoteRepo) ListCommitsBetween(from, to string) ([]string, error) {
+	return repo.callStrings("ListCommitsBetween", from, to)
+}
+
+// ListCommits returns the details of the commits between the two given revisions.
+func (repo *remoteRepo) ListCommits(from, to string) ([]repository.Commit, error) {
+	var commits []repository.Commit
+	if err := repo.call("ListCommits", &commits, from, to); err != nil {
+		return nil, err
+	}
+	return commits, nil
+}
+
+func (repo *remoteRepo) FormatPatch(from, to, outputDir string, trailers []string, coverLetter string) ([]string, error) {
+	return nil, errors.New("Patches cannot be written from a remote repository")
+}
+
+// GetNotes reads the notes from the given ref that annotate the given revision.
+//
+// As with a local repo, any failure to read the notes is treated as there being none.
+func (repo *remoteRepo) GetNotes(notesRef, revision string) []repository.Note {
+	var notes []repository.Note
+	if err := repo.call("GetNotes", &notes, notesRef, revision); err != nil {
+		return nil
+	}
+	return notes
+}
+
+// GetAllNotes reads all of the notes from the given ref, keyed by the annotated object.
+func (repo *remoteRepo) GetAllNotes(notesRef string) (map[string][]repository.Note, error) {
+	var notes map[string][]repository.Note
+	err := repo.call("GetAllNotes", &notes, notesRef)
+	return notes, err
+}
+
+func (repo *remoteRepo) AppendNote(ref, revision string, note repository.Note) error {
+	return repository.ReadOnlyError{Operation: "append a note"}
+}
+
+func (repo *remoteRepo) StoreBlob(notesRef string, contents []byte) (string, error) {
+	return "", repository.ReadOnlyError{Operation: "store a blob"}
+}
+
+// ListNotedRevisions returns the collection of revisions that are annotated by notes in the given ref.
+func (repo *remoteRepo) ListNotedRevisions(notesRef string) []string {
+	revisions, err := repo.callStrings("ListNotedRevisions", notesRef)
+	if err != nil {
+		return nil
+	}
+	return revisions
+}
+
+// ListNotesRefs returns the sorted names of the notes refs matching the given pattern.
+func (repo *remoteRepo) ListNotesRefs(refPattern string) ([]string, error) {
+	return repo.callStrings("ListNotesRefs", refPattern)
+}
+
+// ListRefs returns the objects that the refs matching the given pattern point to, keyed by ref.
+func (repo *remoteRepo) ListRefs(refPattern string) (map[string]string, error) {
+	var refs map[string]string
+	err := repo.call("ListRefs", &refs, refPattern)
+	return refs, err
+}
+
+// ListNotes returns the hash of the note blob for every object annotated in the given notes ref.
+func (repo *remoteRepo) ListNotes(notesRef string) (map[string]string, error) {
+	var notes map[string]string
+	err := repo.call("ListNotes", &notes, notesRef)
+	return notes, err
+}
+
+func (repo *remoteRepo) SetNotes(notesRef, revision string, notes []repository.Note) error {
+	return repository.ReadOnlyError{Operation: "set notes"}
+}
+
+func (repo *remoteRepo) CompactNotes(notesRef, backupRef string, compact func(object string, notes []repository.Note) []repository.Note) error {
+	return repository.ReadOnlyError{Operation: "compact notes"}
+}
+
+func (repo *remoteRepo) Fetch(remote string) error {
+	return repository.ReadOnlyError{Operation: "fetch from " + remote}
+}
+
+func (repo *remoteRepo) AddWorktree(path, commit string) error {
+	return repository.ReadOnlyError{Operation: "add a worktree"}
+}
+
+func (repo *remoteRepo) RemoveWorktree(path string) error {
+	return repository.ReadOnlyError{Operation: "remove a worktree"}
+}
+
+// GetNotesTip returns the commit that the given notes ref currently points to.
+func (repo *remoteRepo) GetNotesTip(notesRef string) (string, error) {
+	return repo.callString("GetNotesTip", notesRef)
+}
+
+// ListChangedNotes returns the sorted list of annotated objects whose notes differ
+// between two commits of a notes ref.
+func (repo *remoteRepo) ListChangedNotes(leftCommit, rightCommit string) ([]string, error) {
+	return repo.callStrings("ListChangedNotes", leftCommit, rightCommit)
+}
+
+func (repo *remoteRepo) DiffRemoteRefs(remote string, refPatterns ...string) ([]repository.RefDiff, error) {
+	return nil, repository.ReadOnlyError{Operation: "compare refs with " + remote}
+}
+
+func (repo *remoteRepo) PushNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	return repository.ReadOnlyError{Operation: "push to " + remote}
+}
+
+func (repo *remoteRepo) ForcePushNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	return repository.ReadOnlyError{Operation: "push to " + remote}
+}
+
+func (repo *remoteRepo) InitNotesRef(notesRef string) (bool, error) {
+	return false, repository.ReadOnlyError{Operation: "create " + notesRef}
+}
+
+func (repo *remoteRepo) ConfigureFetchRefSpecs(remote, notesRefPattern, archiveRefPattern string) ([]string, error) {
+	return nil, repository.ReadOnlyError{Operation: "configure " + remote}
+}
+
+func (repo *remoteRepo) PullNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	return repository.ReadOnlyError{Operation: "pull from " + remote}
+}
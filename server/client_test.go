@@ -0,0 +1,140 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoteRepo(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	repo.SetConfigValue("appraise.labels", "bug")
+	repo.SetConfigValue("credential.helper", "secret")
+	testServer := httptest.NewServer(New(repo, time.Minute))
+	defer testServer.Close()
+	remote, err := NewRemoteRepo(testServer.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local, remoteReviews := review.ListAll(repo), review.ListAll(remote)
+	if len(local) == 0 || len(remoteReviews) != len(local) {
+		t.Fatalf("Unexpected reviews read from the server: got %d, want %d", len(remoteReviews), len(local))
+	}
+	r, err := review.Get(remote, repository.TestCommitG)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to read a review from the server: %v", err)
+	}
+	if localReview, _ := review.Get(repo, repository.TestCommitG); r.Request.Description != localReview.Request.Description {
+		t.Fatalf("Unexpected review description: %q", r.Request.Description)
+	}
+	if details, err := remote.GetCommitDetails(repository.TestCommitG); err != nil || details.AuthorEmail == "" {
+		t.Fatalf("Unexpected commit details: %+v, %v", details, err)
+	}
+
+	if values, err := remote.GetConfigValues("appraise.labels"); err != nil || len(values) != 1 || values[0] != "bug" {
+		t.Fatalf("Unexpected shared setting: %q, %v", values, err)
+	}
+	if values, err := remote.GetConfigValues("credential.helper"); err != nil || len(values) != 0 {
+		t.Fatalf("The server revealed a private setting: %q, %v", values, err)
+	}
+	if _, err := remote.Diff(repository.TestCommitA, repository.TestCommitG, "--output=/tmp/leaked"); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Fatalf("The server ran an unsafe diff: %v", err)
+	}
+	if _, err := remote.Diff(repository.TestCommitA, repository.TestCommitG, "-U5", "--stat"); err != nil {
+		t.Fatalf("The server refused a safe diff: %v", err)
+	}
+
+	err = remote.AppendNote("refs/notes/devtools/discuss", repository.TestCommitG, repository.Note("{}"))
+	if _, ok := err.(repository.ReadOnlyError); !ok {
+		t.Fatalf("Unexpected result of writing to the remote repository: %v", err)
+	}
+	if _, err := NewRemoteRepo("ftp://example.com"); err == nil {
+		t.Fatal("Unexpectedly accepted a URL that is not HTTP")
+	}
+}
+
+func TestCheckDiffOptions(t *testing.T) {
+	for _, test := range []struct {
+		options []string
+		wantErr bool
+	}{
+		{nil, false},
+		{[]string{"-z", "--name-only", "--no-renames"}, false},
+		{[]string{"-M50%", "--diff-filter=R", "--submodule=log"}, false},
+		{[]string{"--output=/etc/passwd"}, true},
+		{[]string{"--ext-diff"}, true},
+		{[]string{"--", "path"}, true},
+	} {
+		if err := checkDiffOptions(test.options); (err != nil) != test.wantErr {
+			t.Errorf("Unexpected result of checking %q: %v", test.options, err)
+		}
+	}
+}
+
+func TestAPIRejectsOptions(t *testing.T) {
+	testServer := httptest.NewServer(New(repository.NewMockRepoForTest(), time.Minute))
+	defer testServer.Close()
+	output := filepath.Join(t.TempDir(), "leaked")
+	post := func(method, contentType, body string) int {
+		resp, err := http.Post(testServer.URL+APIPath+method, contentType, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, test := range []struct {
+		method string
+		args   string
+	}{
+		{"Diff", `["--output=` + output + `", "HEAD"]`},
+		{"Diff", `["HEAD", "--output=` + output + `"]`},
+		{"Show", `["--output=` + output + `", "path"]`},
+		{"Show", `["HEAD", "--output=` + output + `"]`},
+		{"MergeBase", `["--output=` + output + `", "HEAD"]`},
+		{"IsAncestor", `["HEAD", "--all"]`},
+		{"GetCommitDetails", `["--output=` + output + `"]`},
+		{"ListCommits", `["--output=` + output + `", "HEAD"]`},
+		{"ListCommitsBetween", `["HEAD", "--output=` + output + `"]`},
+		{"FindAncestors", `["HEAD", "--all"]`},
+	} {
+		if status := post(test.method, "application/json", test.args); status == http.StatusOK {
+			t.Errorf("The server accepted an option as an argument of %s: %s", test.method, test.args)
+		}
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("The server wrote to %s: %v", output, err)
+	}
+
+	// Web pages can only POST plain text to another site, without asking first.
+	args := `["` + repository.TestCommitA + `", "` + repository.TestCommitG + `"]`
+	if status := post("IsAncestor", "text/plain", args); status != http.StatusUnsupportedMediaType {
+		t.Errorf("Unexpected status of a plain text request: %d", status)
+	}
+	if status := post("IsAncestor", "application/json; charset=utf-8", args); status != http.StatusOK {
+		t.Errorf("Unexpected status of a JSON request: %d", status)
+	}
+}
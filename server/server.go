@@ -0,0 +1,169 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package server serves the code reviews in a repository over HTTP, and provides a client
+// for reading them back as a read-only repository.
+package server
+
+import (
+	"encoding/json"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/comment"
+	"github.com/google/git-appraise/review/request"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// UpdatesPath is the path of the websocket endpoint that streams review updates.
+const UpdatesPath = "/updates"
+
+// DefaultPollInterval is how often the notes refs are checked for changes, by default.
+const DefaultPollInterval = 5 * time.Second
+
+// watchedRefs are the notes refs whose changes are reported to clients.
+//
+// Both of these annotate the first revision of a review, so the annotated objects
+// whose notes changed are exactly the revisions of the affected reviews.
+var watchedRefs = []string{request.Ref, comment.Ref}
+
+// Update is the message sent to the clients of the updates endpoint whenever the
+// review data changes.
+type Update struct {
+	// Revisions holds the revisions of the reviews that have changed.
+	Revisions []string `json:"revisions"`
+}
+
+// Server serves the code reviews of a single repository.
+type Server struct {
+	repo         repository.Repo
+	pollInterval time.Duration
+	mux          *http.ServeMux
+	// allowedOrigins lists the origins of the web pages, other than those served from the
+	// same host, that may connect to the updates endpoint.
+	allowedOrigins []string
+}
+
+// New returns a server for the given repository, which checks for updated reviews
+// at the given interval.
+func New(repo repository.Repo, pollInterval time.Duration) *Server {
+	s := &Server{
+		repo:         repo,
+		pollInterval: pollInterval,
+		mux:          http.NewServeMux(),
+	}
+	s.mux.HandleFunc(UpdatesPath, s.serveUpdates)
+	s.mux.HandleFunc(APIPath, s.serveAPI)
+	return s
+}
+
+// AllowOrigins allows web pages from the given origins, such as "https://dashboard.example.com",
+// to connect to the updates endpoint, in addition to those served from the same host.
+func (s *Server) AllowOrigins(origins ...string) {
+	s.allowedOrigins = append(s.allowedOrigins, origins...)
+}
+
+// ServeHTTP handles a single HTTP request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mux.ServeHTTP(w, req)
+}
+
+// getTips returns the current commit of each of the watched notes refs.
+func (s *Server) getTips() (map[string]string, error) {
+	tips := make(map[string]string)
+	for _, ref := range watchedRefs {
+		tip, err := s.repo.GetNotesTip(ref)
+		if err != nil {
+			return nil, err
+		}
+		tips[ref] = tip
+	}
+	return tips, nil
+}
+
+// getChangedRevisions returns the sorted revisions whose notes differ between the given tips.
+func (s *Server) getChangedRevisions(oldTips, newTips map[string]string) ([]string, error) {
+	changed := make(map[string]bool)
+	for _, ref := range watchedRefs {
+		if oldTips[ref] == newTips[ref] {
+			continue
+		}
+		revisions, err := s.repo.ListChangedNotes(oldTips[ref], newTips[ref])
+		if err != nil {
+			return nil, err
+		}
+		for _, revision := range revisions {
+			changed[revision] = true
+		}
+	}
+	var revisions []string
+	for revision := range changed {
+		revisions = append(revisions, revision)
+	}
+	sort.Strings(revisions)
+	return revisions, nil
+}
+
+// serveUpdates streams an Update message over a websocket whenever the reviews change.
+//
+// The polling loop stops as soon as the client disconnects, and closing the connection
+// in turn stops the goroutine reading from it, so no goroutines outlive the client.
+func (s *Server) serveUpdates(w http.ResponseWriter, req *http.Request) {
+	tips, err := s.getTips()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ws, err := upgradeWebsocket(w, req, s.allowedOrigins)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+	disconnected := make(chan struct{})
+	go func() {
+		ws.discardIncoming()
+		close(disconnected)
+	}()
+
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-disconnected:
+			return
+		case <-ticker.C:
+		}
+		newTips, err := s.getTips()
+		if err != nil {
+			continue
+		}
+		revisions, err := s.getChangedRevisions(tips, newTips)
+		if err != nil {
+			continue
+		}
+		tips = newTips
+		if len(revisions) == 0 {
+			continue
+		}
+		message, err := json.Marshal(Update{Revisions: revisions})
+		if err != nil {
+			return
+		}
+		if err := ws.WriteText(message); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/google/git-appraise/repository"
+	"github.com/google/git-appraise/review/comment"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestComputeAcceptKey(t *testing.T) {
+	// This is the example handshake from RFC 6455.
+	if key := computeAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); key != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Unexpected accept key: %q", key)
+	}
+}
+
+// readServerFrame reads a single (unmasked) frame sent by the server.
+func readServerFrame(t *testing.T, reader *bufio.Reader) (byte, []byte) {
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	if header[1]&0x80 != 0 || header[1] >= 126 {
+		t.Fatalf("Unexpected frame header: %v", header)
+	}
+	payload := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0F, payload
+}
+
+// dialUpdates sends a websocket handshake for the updates to the given server, from a web
+// page of the given origin, if it is not empty, and returns the connection and the response.
+func dialUpdates(t *testing.T, testServer *httptest.Server, origin string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", testServer.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	handshake := "GET " + UpdatesPath + " HTTP/1.1\r\n" +
+		"Host: localhost:8080\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n"
+	if origin != "" {
+		handshake += "Origin: " + origin + "\r\n"
+	}
+	if _, err := conn.Write([]byte(handshake + "\r\n")); err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	return conn, reader, resp
+}
+
+func TestUpdatesOrigin(t *testing.T) {
+	s := New(repository.NewMockRepoForTest(), time.Minute)
+	s.AllowOrigins("https://dashboard.example.com")
+	testServer := httptest.NewServer(s)
+	defer testServer.Close()
+	for _, test := range []struct {
+		origin string
+		want   int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"http://localhost:8080", http.StatusSwitchingProtocols},
+		{"https://dashboard.example.com", http.StatusSwitchingProtocols},
+		{"https://evil.example.com", http.StatusForbidden},
+		{"http://localhost:9090", http.StatusForbidden},
+		{"null", http.StatusForbidden},
+	} {
+		conn, _, resp := dialUpdates(t, testServer, test.origin)
+		conn.Close()
+		if resp.StatusCode != test.want {
+			t.Errorf("Unexpected response to a handshake from %q: got %d, want %d", test.origin, resp.StatusCode, test.want)
+		}
+	}
+}
+
+func TestUpdates(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	testServer := httptest.NewServer(New(repo, 10*time.Millisecond))
+	defer testServer.Close()
+
+	conn, reader, resp := dialUpdates(t, testServer, "")
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Unexpected handshake response: %v", resp)
+	}
+
+	if err := repo.AppendNote(comment.Ref, repository.TestCommitG, repository.Note(repository.TestDiscussB)); err != nil {
+		t.Fatal(err)
+	}
+	opcode, payload := readServerFrame(t, reader)
+	var update Update
+	if err := json.Unmarshal(payload, &update); opcode != opText || err != nil {
+		t.Fatalf("Unexpected message: %d, %q, %v", opcode, payload, err)
+	}
+	if len(update.Revisions) != 1 || update.Revisions[0] != repository.TestCommitG {
+		t.Fatalf("Unexpected update: %+v", update)
+	}
+
+	// Send a (masked, empty) close frame, and expect it to be echoed back.
+	if _, err := conn.Write([]byte{0x80 | opClose, 0x80, 1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if opcode, _ := readServerFrame(t, reader); opcode != opClose {
+		t.Fatalf("Unexpected response to a close frame: %d", opcode)
+	}
+}
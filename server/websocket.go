@@ -0,0 +1,238 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// websocketGUID is the fixed string that the server concatenates with the client's
+// key in order to compute the accept header of the handshake (see RFC 6455).
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The websocket frame opcodes used by the server.
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xA
+)
+
+// maxControlPayload is the largest payload allowed in a control (close, ping, or pong) frame.
+const maxControlPayload = 125
+
+// websocketConn is a minimal, server-side websocket connection.
+//
+// It only supports sending text messages; messages received from the client are
+// discarded, other than those needed to keep the connection alive or close it.
+type websocketConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// writeMutex serializes writes, since pongs are written by the reading goroutine.
+	writeMutex sync.Mutex
+}
+
+// computeAcceptKey computes the value of the Sec-WebSocket-Accept header for the given client key.
+func computeAcceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// headerContains returns whether or not the given comma-separated header includes the given token.
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isAllowedOrigin returns whether or not the web page that sent the given request, if any,
+// may connect to the server. That is the case for pages served from the same host as the
+// server, and for those from the given allowed origins, such as "https://dashboard.example.com".
+//
+// Browsers do not apply the same-origin policy to websockets, so without this check, any page
+// that the user visits could read the updates.
+func isAllowedOrigin(req *http.Request, allowedOrigins []string) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		// The request was not sent by a web page, such as one from another git-appraise client.
+		return true
+	}
+	for _, allowed := range allowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	originURL, err := url.Parse(origin)
+	return err == nil && originURL.Host != "" && strings.EqualFold(originURL.Host, req.Host)
+}
+
+// upgradeWebsocket performs the websocket handshake for the given request, and takes over
+// its underlying connection. If the handshake fails, an error response has already been sent.
+//
+// Handshakes from web pages are refused unless they come from the same host as the server,
+// or from one of the given allowed origins.
+func upgradeWebsocket(w http.ResponseWriter, req *http.Request, allowedOrigins []string) (*websocketConn, error) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != "GET" || !headerContains(req.Header, "Connection", "upgrade") ||
+		!headerContains(req.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "Expected a websocket handshake", http.StatusBadRequest)
+		return nil, errors.New("Not a websocket handshake")
+	}
+	if !isAllowedOrigin(req, allowedOrigins) {
+		http.Error(w, "Connections from this origin are not allowed", http.StatusForbidden)
+		return nil, errors.New("The websocket handshake came from an origin that is not allowed")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Websockets are not supported", http.StatusInternalServerError)
+		return nil, errors.New("The response writer does not support hijacking the connection")
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + computeAcceptKey(key) + "\r\n\r\n"
+	if _, err := buf.WriteString(response); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := buf.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &websocketConn{conn: conn, reader: buf.Reader}, nil
+}
+
+// writeFrame writes a single, unfragmented frame with the given opcode and payload.
+func (ws *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	ws.writeMutex.Lock()
+	defer ws.writeMutex.Unlock()
+	header := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+	if _, err := ws.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// WriteText sends the given text message to the client.
+func (ws *websocketConn) WriteText(message []byte) error {
+	return ws.writeFrame(opText, message)
+}
+
+// readFrame reads a single frame from the client, and returns its opcode and unmasked payload.
+func (ws *websocketConn) readFrame() (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(ws.reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(ws.reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(ws.reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if !masked {
+		return 0, nil, errors.New("Received an unmasked frame from the client")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.reader, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	if opcode >= opClose && length > maxControlPayload {
+		return 0, nil, errors.New("Received an oversized control frame")
+	}
+	if opcode < opClose {
+		// We do not care about the contents of data frames, so skip them without buffering.
+		if _, err := io.CopyN(ioutil.Discard, ws.reader, int64(length)); err != nil {
+			return 0, nil, err
+		}
+		return opcode, nil, nil
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// discardIncoming reads, and discards, frames from the client until the connection is
+// closed, answering pings along the way. It returns once the connection is unusable.
+func (ws *websocketConn) discardIncoming() {
+	for {
+		opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case opClose:
+			ws.writeFrame(opClose, payload)
+			return
+		case opPing:
+			if err := ws.writeFrame(opPong, payload); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Close closes the underlying network connection.
+func (ws *websocketConn) Close() error {
+	return ws.conn.Close()
+}